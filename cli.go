@@ -0,0 +1,91 @@
+package main
+
+import (
+	"codeswitch/services"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// cliCommand 描述一个命令行子命令
+// 带子命令启动时不创建窗口，执行完毕后直接退出
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var cliCommands = map[string]cliCommand{}
+
+func registerCLICommand(cmd cliCommand) {
+	cliCommands[cmd.name] = cmd
+}
+
+// runCLI 在启动桌面应用之前分发子命令
+// 返回 handled=false 表示不是已知子命令，应按桌面应用正常启动
+func runCLI(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	name := args[0]
+	switch name {
+	case "help", "-h", "--help":
+		printCLIUsage()
+		return 0, true
+	}
+	cmd, ok := cliCommands[name]
+	if !ok {
+		return 0, false
+	}
+	if relayCfg, err := services.NewRelayConfigService().GetRelayConfig(); err == nil {
+		services.ApplyPricingConfig(relayCfg.Pricing, relayCfg.Proxy)
+		services.ApplyCurrencyConfig(relayCfg.Currency)
+	}
+	return cmd.run(args[1:]), true
+}
+
+func printCLIUsage() {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "用法: code-switch <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "可用命令:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, cliCommands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "不带命令启动时运行桌面应用。")
+}
+
+// ansi 在终端支持颜色时为文本着色，遵循 NO_COLOR 约定
+type ansi struct {
+	enabled bool
+}
+
+func newANSI(disabled bool) ansi {
+	if disabled || os.Getenv("NO_COLOR") != "" || strings.EqualFold(os.Getenv("TERM"), "dumb") {
+		return ansi{}
+	}
+	if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return ansi{}
+	}
+	return ansi{enabled: true}
+}
+
+func (a ansi) wrap(code string, text string) string {
+	if !a.enabled {
+		return text
+	}
+	return "\033[" + code + "m" + text + "\033[0m"
+}
+
+func (a ansi) red(text string) string    { return a.wrap("31", text) }
+func (a ansi) green(text string) string  { return a.wrap("32", text) }
+func (a ansi) yellow(text string) string { return a.wrap("33", text) }
+func (a ansi) cyan(text string) string   { return a.wrap("36", text) }
+func (a ansi) dim(text string) string    { return a.wrap("2", text) }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"codeswitch/services"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "apply",
+		summary: "修改客户端的配置，使其通过本机 relay 请求（" + strings.Join(services.ClientIntegrationNames(), " | ") + "）",
+		run:     runApplyCommand,
+	})
+	registerCLICommand(cliCommand{
+		name:    "unapply",
+		summary: "恢复 apply 之前的客户端配置",
+		run:     runUnapplyCommand,
+	})
+}
+
+func runApplyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "用法: code-switch apply <%s> [flags]\n", strings.Join(services.ClientIntegrationNames(), "|"))
+		return 2
+	}
+	integration, err := services.LookupClientIntegration(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fs := flag.NewFlagSet("apply "+integration.Name(), flag.ContinueOnError)
+	opts := services.ClientApplyOptions{TierModels: map[string]string{}}
+	fs.StringVar(&opts.BaseURL, "base-url", "", "relay 地址，默认 http://127.0.0.1:18100")
+	fs.StringVar(&opts.Model, "model", "", "默认使用的模型")
+	for _, tier := range []string{"opus", "sonnet", "haiku"} {
+		fs.Func(tier+"-model", tier+" 档位使用的模型（claude）", func(value string) error {
+			opts.TierModels[tier] = value
+			return nil
+		})
+	}
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "只输出修改后的配置文件，不写入")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	result, err := integration.Apply(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "修改 %s 配置失败: %v\n", integration.DisplayName(), err)
+		return 1
+	}
+	if opts.DryRun {
+		fmt.Println(result.Settings)
+		return 0
+	}
+	if result.BackupPath != "" {
+		fmt.Printf("已备份原配置到 %s\n", result.BackupPath)
+	}
+	if !result.Changed {
+		fmt.Printf("%s 已指向 relay，无需修改\n", result.SettingsPath)
+	} else {
+		fmt.Printf("已更新 %s，重新启动 %s 后生效；使用 code-switch unapply %s 恢复\n", result.SettingsPath, integration.DisplayName(), integration.Name())
+	}
+	if result.Note != "" {
+		fmt.Println(result.Note)
+	}
+	return 0
+}
+
+func runUnapplyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "用法: code-switch unapply <%s>\n", strings.Join(services.ClientIntegrationNames(), "|"))
+		return 2
+	}
+	integration, err := services.LookupClientIntegration(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fs := flag.NewFlagSet("unapply "+integration.Name(), flag.ContinueOnError)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	result, err := integration.Unapply()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "恢复 %s 配置失败: %v\n", integration.DisplayName(), err)
+		return 1
+	}
+	switch {
+	case result.BackupPath != "":
+		fmt.Printf("已从 %s 恢复 %s\n", result.BackupPath, result.SettingsPath)
+	case result.Changed:
+		fmt.Printf("未找到备份，已从 %s 移除 relay 设置\n", result.SettingsPath)
+	default:
+		fmt.Printf("%s 未指向 relay，无需恢复\n", result.SettingsPath)
+	}
+	return 0
+}
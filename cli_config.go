@@ -0,0 +1,171 @@
+package main
+
+import (
+	modelpricing "codeswitch/resources/model-pricing"
+	"codeswitch/services"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "config",
+		summary: "校验配置，导出/导入可分享的完整配置包（validate | export | import）",
+		run:     runConfigCommand,
+	})
+}
+
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch config <validate|export|import> [flags]")
+		return 2
+	}
+	bundleService := services.NewConfigBundleService(services.NewProviderService(), services.NewRelayConfigService())
+	switch args[0] {
+	case "validate":
+		return runConfigValidate(args[1:])
+	case "export":
+		return runConfigExport(bundleService, args[1:])
+	case "import":
+		return runConfigImport(bundleService, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的 config 子命令: %s\n", args[0])
+		return 2
+	}
+}
+
+func runConfigExport(bundleService *services.ConfigBundleService, args []string) int {
+	fs := flag.NewFlagSet("config export", flag.ContinueOnError)
+	redact := fs.Bool("redact-secrets", false, "将所有 API Key 替换为占位符")
+	output := fs.String("output", "", "输出文件（默认输出到标准输出）")
+	fs.StringVar(output, "o", "", "同 --output")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *output != "" {
+		if err := bundleService.ExportBundle(*output, *redact); err != nil {
+			fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "配置已导出到 %s\n", *output)
+		return 0
+	}
+
+	bundle, err := bundleService.BuildBundle(*redact)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return 1
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+func runConfigImport(bundleService *services.ConfigBundleService, args []string) int {
+	fs := flag.NewFlagSet("config import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只展示将要发生的变更，不写入配置")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch config import [--dry-run] <bundle.json>")
+		return 2
+	}
+
+	result, err := bundleService.ImportBundleFile(fs.Arg(0), *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+		return 1
+	}
+	printBundleImportResult(result)
+	return 0
+}
+
+func printBundleImportResult(result services.ConfigBundleImportResult) {
+	prefix := ""
+	if result.DryRun {
+		prefix = "[dry-run] "
+	}
+	if len(result.Created) > 0 {
+		fmt.Printf("%s新增 provider: %s\n", prefix, strings.Join(result.Created, ", "))
+	}
+	if len(result.Updated) > 0 {
+		fmt.Printf("%s更新 provider: %s\n", prefix, strings.Join(result.Updated, ", "))
+	}
+	if result.RelayUpdated {
+		fmt.Printf("%s更新 relay 配置\n", prefix)
+	}
+	if len(result.MissingSecrets) > 0 {
+		fmt.Printf("%s以下 provider 尚未配置 API Key，请在应用中补齐: %s\n", prefix, strings.Join(result.MissingSecrets, ", "))
+	}
+}
+
+func runConfigValidate(args []string) int {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	connectivity := fs.Bool("connectivity", false, "对每个启用的 provider 发送一次不带 Key 的请求，检查网络、代理与 TLS 设置")
+	timeout := fs.Duration("timeout", 10*time.Second, "连通性检查的超时时间")
+	offline := fs.Bool("offline", false, "只使用内置价格数据检查模型名，不读取缓存或请求远程")
+	asJSON := fs.Bool("json", false, "以 JSON 输出结果")
+	noColor := fs.Bool("no-color", false, "禁用颜色输出")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var (
+		pricing *modelpricing.Service
+		err     error
+	)
+	if *offline {
+		pricing, err = modelpricing.NewService()
+	} else {
+		pricing, err = modelpricing.DefaultService()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载价格数据失败，跳过模型名检查: %v\n", err)
+	}
+
+	report := services.ValidateConfig(services.ConfigValidateOptions{
+		Connectivity:        *connectivity,
+		ConnectivityTimeout: *timeout,
+		Pricing:             pricing,
+	})
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "输出失败: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	} else {
+		color := newANSI(*noColor)
+		for _, issue := range report.Issues {
+			line := issue.String()
+			if issue.Severity == services.ConfigIssueWarning {
+				line = color.yellow(line)
+			} else {
+				line = color.red(line)
+			}
+			fmt.Println(line)
+		}
+		if len(report.Files) == 0 {
+			fmt.Println("没有找到配置文件")
+		} else if len(report.Issues) == 0 {
+			fmt.Println(color.green(fmt.Sprintf("已检查 %d 个配置文件，没有发现问题", len(report.Files))))
+		} else {
+			fmt.Printf("\n已检查 %d 个配置文件，%d 个错误，%d 个警告\n", len(report.Files), report.Errors, report.Warnings)
+		}
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
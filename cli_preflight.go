@@ -0,0 +1,76 @@
+package main
+
+import (
+	"codeswitch/services"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "preflight",
+		summary: "部署前预检：配置校验、价格数据、provider 认证与路由模拟",
+		run:     runPreflightCommand,
+	})
+}
+
+func runPreflightCommand(args []string) int {
+	fs := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	configPath := fs.String("config", "", "预检配置文件（YAML 或 JSON）")
+	exitCode := fs.Bool("exit-code", false, "存在失败项时以状态码 1 退出，用于 CI 门禁")
+	authMode := fs.String("auth", "", "覆盖认证检查方式：mock、live 或 skip")
+	asJSON := fs.Bool("json", false, "以 JSON 输出结果")
+	noColor := fs.Bool("no-color", false, "禁用颜色输出")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var spec services.PreflightSpec
+	if *configPath != "" {
+		loaded, err := services.LoadPreflightSpec(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "读取预检配置失败: %v\n", err)
+			return 2
+		}
+		spec = loaded
+	}
+	if *authMode != "" {
+		spec.Auth.Mode = *authMode
+	}
+
+	report := services.NewPreflightService(services.NewProviderService(), services.NewRelayConfigService()).Run(spec)
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "输出失败: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	} else {
+		printPreflightReport(report, newANSI(*noColor))
+	}
+	if *exitCode && !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+func printPreflightReport(report services.PreflightReport, color ansi) {
+	for _, check := range report.Checks {
+		status := color.green("OK  ")
+		switch check.Status {
+		case services.PreflightWarn:
+			status = color.yellow("WARN")
+		case services.PreflightFail:
+			status = color.red("FAIL")
+		}
+		line := fmt.Sprintf("%s %-8s %s", status, check.Stage, check.Name)
+		if check.Message != "" {
+			line += color.dim(" — " + check.Message)
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\n%d 项检查，%d 项失败，%d 项警告\n", len(report.Checks), report.Failures, report.Warnings)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	modelpricing "codeswitch/resources/model-pricing"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "prices",
+		summary: "查看与排查模型价格（list | show | explain | refresh）",
+		run:     runPricesCommand,
+	})
+}
+
+func runPricesCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch prices <list|show|explain|refresh> [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		return runPricesList(args[1:])
+	case "show":
+		return runPricesShow(args[1:])
+	case "explain":
+		return runPricesExplain(args[1:])
+	case "refresh":
+		return runPricesRefresh(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的 prices 子命令: %s\n", args[0])
+		return 2
+	}
+}
+
+// pricingForCLI 返回按配置创建的价格服务，失败时输出错误
+func pricingForCLI() (*modelpricing.Service, bool) {
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil {
+		fmt.Fprintf(os.Stderr, "加载价格数据失败: %v\n", err)
+		return nil, false
+	}
+	return pricing, true
+}
+
+// priceListItem 是 prices list 输出的一行，单价为每 1M tokens 的美元价格
+type priceListItem struct {
+	Model      string  `json:"model"`
+	Provider   string  `json:"provider,omitempty"`
+	Mode       string  `json:"mode,omitempty"`
+	Input      float64 `json:"input_per_1m"`
+	Output     float64 `json:"output_per_1m"`
+	CacheRead  float64 `json:"cache_read_per_1m"`
+	CacheWrite float64 `json:"cache_write_per_1m"`
+	MaxInput   int     `json:"max_input_tokens,omitempty"`
+}
+
+func runPricesList(args []string) int {
+	fs := flag.NewFlagSet("prices list", flag.ContinueOnError)
+	filter := fs.String("filter", "", "只显示名称包含该子串的模型（不区分大小写）")
+	provider := fs.String("provider", "", "只显示指定 litellm_provider 的模型，如 anthropic")
+	mode := fs.String("mode", "", "只显示指定类型的模型，如 chat、embedding、rerank")
+	limit := fs.Int("n", 0, "最多显示的模型数量，0 表示不限制")
+	asJSON := fs.Bool("json", false, "以 JSON 输出结果")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	pricing, ok := pricingForCLI()
+	if !ok {
+		return 1
+	}
+
+	needle := strings.ToLower(strings.TrimSpace(*filter))
+	items := make([]priceListItem, 0)
+	for _, model := range pricing.ListModels() {
+		if needle != "" && !strings.Contains(strings.ToLower(model), needle) {
+			continue
+		}
+		entry, found := pricing.GetEntry(model)
+		if !found {
+			continue
+		}
+		if *provider != "" && !strings.EqualFold(entry.LiteLLMProvider, *provider) {
+			continue
+		}
+		if *mode != "" && !strings.EqualFold(entry.Mode, *mode) {
+			continue
+		}
+		items = append(items, priceListItem{
+			Model:      model,
+			Provider:   entry.LiteLLMProvider,
+			Mode:       entry.Mode,
+			Input:      entry.InputCostPerToken * 1e6,
+			Output:     entry.OutputCostPerToken * 1e6,
+			CacheRead:  entry.CacheReadInputTokenCost * 1e6,
+			CacheWrite: entry.CacheCreationInputTokenCost * 1e6,
+			MaxInput:   int(entry.MaxInputTokens),
+		})
+		if *limit > 0 && len(items) >= *limit {
+			break
+		}
+	}
+	if *asJSON {
+		return printJSON(items)
+	}
+	if len(items) == 0 {
+		fmt.Fprintln(os.Stderr, "没有匹配的模型")
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tPROVIDER\tMODE\tINPUT/1M\tOUTPUT/1M\tCACHE READ/1M\tCACHE WRITE/1M\tMAX INPUT")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", item.Model, item.Provider, item.Mode,
+			formatUnitPrice(item.Input), formatUnitPrice(item.Output), formatUnitPrice(item.CacheRead), formatUnitPrice(item.CacheWrite),
+			formatTokenLimit(item.MaxInput))
+	}
+	w.Flush()
+	info := pricing.Info()
+	fmt.Printf("\n共 %d 个模型（价格数据 %s，版本 %s）\n", len(items), info.Source, info.Version)
+	return 0
+}
+
+func runPricesShow(args []string) int {
+	fs := flag.NewFlagSet("prices show", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch prices show <model>")
+		return 2
+	}
+	pricing, ok := pricingForCLI()
+	if !ok {
+		return 1
+	}
+	model := fs.Arg(0)
+	entry, found := pricing.GetEntry(model)
+	if !found {
+		fmt.Fprintf(os.Stderr, "价格数据中没有匹配 %s 的模型，可用 code-switch prices explain %s 查看匹配过程\n", model, model)
+		return 1
+	}
+	return printJSON(struct {
+		Model string                    `json:"model"`
+		Match modelpricing.ModelMatch   `json:"match"`
+		Entry modelpricing.PricingEntry `json:"entry"`
+	}{Model: model, Match: pricing.MatchModel(model), Entry: entry})
+}
+
+func runPricesExplain(args []string) int {
+	fs := flag.NewFlagSet("prices explain", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "以 JSON 输出结果")
+	noColor := fs.Bool("no-color", false, "禁用彩色输出")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch prices explain [--json] <model>")
+		return 2
+	}
+	pricing, ok := pricingForCLI()
+	if !ok {
+		return 1
+	}
+	explanation := pricing.ExplainMatch(fs.Arg(0))
+	if *asJSON {
+		return printJSON(explanation)
+	}
+
+	color := newANSI(*noColor)
+	fmt.Printf("模型 %s 的匹配过程：\n", explanation.Model)
+	for i, step := range explanation.Steps {
+		result := color.dim("未找到")
+		if step.Matched {
+			result = color.green("匹配 " + step.Key)
+		}
+		line := fmt.Sprintf("  %d. %-10s %s → %s", i+1, step.Strategy, step.Name, result)
+		if step.Note != "" {
+			line += color.dim("（" + step.Note + "）")
+		}
+		fmt.Println(line)
+	}
+	match := explanation.Match
+	if !match.Found() {
+		fmt.Println(color.red("结果：没有匹配的价格条目，该模型的请求不计费（has_pricing=false）"))
+		fmt.Println("可在 pricing.aliases 中为该模型配置别名，或通过 pricing.overridesFile 补充价格")
+		return 1
+	}
+	fmt.Printf("结果：按 %s 计费（%s）\n", color.cyan(match.Key), match.Strategy)
+	if match.Ambiguous() {
+		fmt.Println(color.yellow("警告：模糊匹配有多个候选，可能按错误的模型计费：" + strings.Join(match.Candidates, ", ")))
+	}
+	if entry, found := pricing.GetEntry(explanation.Model); found && entry.InputCostPerToken == 0 && entry.OutputCostPerToken == 0 &&
+		entry.InputCostPerQuery == 0 && entry.InputCostPerRequest == 0 {
+		fmt.Println(color.yellow("注意：匹配的条目没有 token 单价，按 token 计算的费用为 0"))
+	}
+	return 0
+}
+
+func runPricesRefresh(args []string) int {
+	fs := flag.NewFlagSet("prices refresh", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", time.Minute, "拉取远程价格数据的超时时间")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	pricing, ok := pricingForCLI()
+	if !ok {
+		return 1
+	}
+	if modelpricing.Offline() {
+		fmt.Fprintln(os.Stderr, "价格数据处于离线模式，不会从远程更新")
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := pricing.ForceRefresh(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "刷新价格数据失败: %v\n", err)
+		return 1
+	}
+	info := pricing.Info()
+	fmt.Printf("价格数据已刷新：%s（版本 %s，%d 个模型）\n", info.SourceURL, info.Version, info.ModelCount)
+	return 0
+}
+
+func printJSON(value any) int {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "输出失败: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+// formatUnitPrice 格式化每 1M tokens 的单价，0 显示为 -
+func formatUnitPrice(price float64) string {
+	if price == 0 {
+		return "-"
+	}
+	return "$" + strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.4f", price), "0"), ".")
+}
+
+func formatTokenLimit(limit int) string {
+	switch {
+	case limit <= 0:
+		return "-"
+	case limit%1000000 == 0:
+		return fmt.Sprintf("%dM", limit/1000000)
+	case limit%1000 == 0:
+		return fmt.Sprintf("%dk", limit/1000)
+	}
+	return fmt.Sprint(limit)
+}
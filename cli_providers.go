@@ -0,0 +1,234 @@
+package main
+
+import (
+	"codeswitch/services"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "providers",
+		summary: "批量管理与分享 provider（bulk | import-csv | export | import）",
+		run:     runProvidersCommand,
+	})
+}
+
+func runProvidersCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch providers <bulk|import-csv|export|import> [flags]")
+		return 2
+	}
+	providerService := services.NewProviderService()
+	switch args[0] {
+	case "bulk":
+		return runProvidersBulk(providerService, args[1:])
+	case "import-csv":
+		return runProvidersImportCSV(providerService, args[1:])
+	case "export":
+		return runProvidersExport(providerService, args[1:])
+	case "import":
+		return runProvidersImport(providerService, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的 providers 子命令: %s\n", args[0])
+		return 2
+	}
+}
+
+func runProvidersBulk(providerService *services.ProviderService, args []string) int {
+	fs := flag.NewFlagSet("providers bulk", flag.ContinueOnError)
+	platform := fs.String("platform", "claude", "平台（claude 或 codex）")
+	action := fs.String("action", "", "操作：enable、disable、set-level、set-rate-limit、add-tag、remove-tag")
+	tags := fs.String("select-tag", "", "按标签选择 provider（多个以逗号分隔，满足任一即可）")
+	names := fs.String("select-name", "", "按名称选择 provider（多个以逗号分隔）")
+	pattern := fs.String("match", "", "按名称通配符选择 provider，如 team-a-*")
+	tag := fs.String("tag", "", "add-tag / remove-tag 使用的标签")
+	level := fs.Int("level", 0, "set-level 使用的优先级（1-10）")
+	rps := fs.Float64("rps", 0, "set-rate-limit 使用的每秒请求数（0 表示不限制）")
+	burst := fs.Int("burst", 0, "set-rate-limit 使用的突发请求数")
+	dryRun := fs.Bool("dry-run", false, "只展示将要发生的变更，不写入配置")
+	asJSON := fs.Bool("json", false, "以 JSON 输出结果")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *action == "" {
+		fmt.Fprintln(os.Stderr, "用法: code-switch providers bulk --action <action> [--select-tag t] [--select-name n] [--match pattern] [--dry-run]")
+		return 2
+	}
+
+	result, err := providerService.BulkUpdateProviders(services.BulkProviderRequest{
+		Platform: *platform,
+		Selector: services.ProviderSelector{
+			Tags:        splitFlagList(*tags),
+			Names:       splitFlagList(*names),
+			NamePattern: *pattern,
+		},
+		Action:            *action,
+		Tag:               *tag,
+		Level:             *level,
+		RequestsPerSecond: *rps,
+		RequestBurst:      *burst,
+		DryRun:            *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "批量操作失败: %v\n", err)
+		return 1
+	}
+	return printBulkResult(result, *asJSON)
+}
+
+func runProvidersImportCSV(providerService *services.ProviderService, args []string) int {
+	fs := flag.NewFlagSet("providers import-csv", flag.ContinueOnError)
+	platform := fs.String("platform", "claude", "平台（claude 或 codex）")
+	dryRun := fs.Bool("dry-run", false, "只校验并展示将要创建的 provider，不写入配置")
+	asJSON := fs.Bool("json", false, "以 JSON 输出结果")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch providers import-csv [--platform claude] [--dry-run] <providers.csv>")
+		return 2
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取 CSV 失败: %v\n", err)
+		return 1
+	}
+	result, err := providerService.ImportProvidersCSV(*platform, data, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+		return 1
+	}
+	return printBulkResult(result, *asJSON)
+}
+
+// profilePassphraseEnv 是读取 provider 配置加密口令的环境变量，避免口令出现在命令行参数中
+const profilePassphraseEnv = "CODE_SWITCH_PROFILE_PASSPHRASE"
+
+func profilePassphrase(file string) (string, error) {
+	if file == "" {
+		return os.Getenv(profilePassphraseEnv), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func runProvidersExport(providerService *services.ProviderService, args []string) int {
+	fs := flag.NewFlagSet("providers export", flag.ContinueOnError)
+	platform := fs.String("platform", "", "平台（claude 或 codex，默认两者）")
+	names := fs.String("select-name", "", "按名称选择 provider（多个以逗号分隔，默认全部）")
+	redact := fs.Bool("redact-secrets", false, "将所有 API Key 替换为占位符")
+	encrypt := fs.Bool("encrypt", false, "使用口令加密 API Key，口令取自 --passphrase-file 或环境变量 "+profilePassphraseEnv)
+	passphraseFile := fs.String("passphrase-file", "", "口令文件")
+	output := fs.String("output", "", "输出文件（默认输出到标准输出）")
+	fs.StringVar(output, "o", "", "同 --output")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !*redact && !*encrypt {
+		fmt.Fprintln(os.Stderr, "导出的配置会包含明文 API Key，请使用 --redact-secrets 或 --encrypt")
+		return 2
+	}
+
+	opts := services.ProviderProfileOptions{Platform: *platform, Names: splitFlagList(*names), RedactSecrets: *redact}
+	if *encrypt {
+		passphrase, err := profilePassphrase(*passphraseFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "读取口令失败: %v\n", err)
+			return 1
+		}
+		if passphrase == "" {
+			fmt.Fprintf(os.Stderr, "--encrypt 需要通过 --passphrase-file 或环境变量 %s 提供口令\n", profilePassphraseEnv)
+			return 2
+		}
+		opts.Passphrase = passphrase
+	}
+	bundleService := services.NewConfigBundleService(providerService, nil)
+	if *output != "" {
+		if err := bundleService.ExportProfileFile(*output, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "provider 配置已导出到 %s\n", *output)
+		return 0
+	}
+	bundle, err := bundleService.ExportProfile(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return 1
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+func runProvidersImport(providerService *services.ProviderService, args []string) int {
+	fs := flag.NewFlagSet("providers import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只展示将要发生的变更，不写入配置")
+	passphraseFile := fs.String("passphrase-file", "", "口令文件（默认读取环境变量 "+profilePassphraseEnv+"）")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch providers import [--dry-run] [--passphrase-file file] <profile.json>")
+		return 2
+	}
+	passphrase, err := profilePassphrase(*passphraseFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取口令失败: %v\n", err)
+		return 1
+	}
+	result, err := services.NewConfigBundleService(providerService, nil).ImportProfileFile(fs.Arg(0), passphrase, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+		return 1
+	}
+	printBundleImportResult(result)
+	return 0
+}
+
+func printBulkResult(result services.BulkProviderResult, asJSON bool) int {
+	if asJSON {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "输出失败: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	prefix := ""
+	if result.DryRun {
+		prefix = "[dry-run] "
+	}
+	if len(result.Created) > 0 {
+		fmt.Printf("%s新增 provider（%d 个）: %s\n", prefix, len(result.Created), strings.Join(result.Created, ", "))
+	}
+	if result.Action != "import-csv" {
+		fmt.Printf("%s匹配 %d 个 provider，变更 %d 项\n", prefix, len(result.Matched), len(result.Changes))
+	}
+	for _, change := range result.Changes {
+		fmt.Printf("%s  %s.%s: %s -> %s\n", prefix, change.Provider, change.Field, change.Before, change.After)
+	}
+	return 0
+}
+
+func splitFlagList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
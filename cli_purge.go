@@ -0,0 +1,81 @@
+package main
+
+import (
+	"codeswitch/services"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "purge",
+		summary: "按 Key、会话或时间范围清除保存的请求/响应内容（统计数据保留）",
+		run:     runPurgeCommand,
+	})
+}
+
+func runPurgeCommand(args []string) int {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	key := fs.String("key", "", "清除使用指定 API Key 的内容（完整 Key 或脱敏片段）")
+	session := fs.String("session", "", "清除指定会话的内容")
+	since := fs.String("since", "", "起始时间（含），格式 2006-01-02 或 2006-01-02 15:04:05")
+	until := fs.String("until", "", "结束时间（不含），格式同 --since")
+	reason := fs.String("reason", "", "清除原因，记录在审计中")
+	soft := fs.Bool("soft", false, "仅软删除（可恢复），不永久清除")
+	dryRun := fs.Bool("dry-run", false, "只统计将被清除的条数")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	criteria := services.TranscriptCriteria{Key: *key, SessionID: *session}
+	var err error
+	if criteria.From, err = parseCLITime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "--since 无效: %v\n", err)
+		return 2
+	}
+	if criteria.To, err = parseCLITime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "--until 无效: %v\n", err)
+		return 2
+	}
+
+	if err := services.InitDatabase(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	transcripts := services.NewTranscriptService()
+	var result services.PurgeResult
+	if *soft && !*dryRun {
+		result, err = transcripts.SoftDeleteTranscripts(criteria, *reason)
+	} else {
+		result, err = transcripts.PurgeTranscripts(criteria, *reason, *dryRun)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "清除失败: %v\n", err)
+		return 1
+	}
+	if result.DryRun {
+		fmt.Printf("[dry-run] 将清除 %d 条内容\n", result.Affected)
+		return 0
+	}
+	if result.Affected == 0 {
+		fmt.Println("没有匹配的内容")
+		return 0
+	}
+	fmt.Printf("已处理 %d 条内容，审计记录 #%d\n", result.Affected, result.AuditID)
+	return 0
+}
+
+// parseCLITime 按本地时区解析命令行中的时间
+func parseCLITime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Parse(time.RFC3339, value)
+}
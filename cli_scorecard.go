@@ -0,0 +1,54 @@
+package main
+
+import (
+	"codeswitch/services"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "scorecard",
+		summary: "生成 provider 月度 SLA scorecard（可用率、延迟、吞吐、错误分布、有效单价）",
+		run:     runScorecardCommand,
+	})
+}
+
+func runScorecardCommand(args []string) int {
+	fs := flag.NewFlagSet("scorecard", flag.ContinueOnError)
+	month := fs.String("month", "", "统计月份，格式 2006-01，默认当月")
+	format := fs.String("format", "json", "输出格式：json 或 html")
+	output := fs.String("o", "", "输出文件路径，留空输出到终端")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := services.InitDatabase(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logs := services.NewLogService()
+	if *output != "" {
+		if err := logs.ExportScorecards(*month, *format, *output); err != nil {
+			fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("已导出到 %s\n", *output)
+		return 0
+	}
+
+	report, err := logs.ProviderScorecards(*month)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "生成失败: %v\n", err)
+		return 1
+	}
+	data, err := services.RenderScorecards(report, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	os.Stdout.Write(data)
+	fmt.Println()
+	return 0
+}
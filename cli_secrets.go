@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"codeswitch/services"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "secrets",
+		summary: "将 API Key 保存到系统钥匙串或加密文件（set | delete | list | migrate）",
+		run:     runSecretsCommand,
+	})
+}
+
+func runSecretsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch secrets <set|delete|list|migrate> [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "set":
+		return runSecretsSet(args[1:])
+	case "delete":
+		return runSecretsDelete(args[1:])
+	case "list":
+		return runSecretsList(args[1:])
+	case "migrate":
+		return runSecretsMigrate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的 secrets 子命令: %s\n", args[0])
+		return 2
+	}
+}
+
+func secretBackendFlag(fs *flag.FlagSet) *string {
+	return fs.String("backend", services.SecretBackendKeyring, "存储方式："+services.SecretBackendKeyring+"（系统钥匙串）或 "+services.SecretBackendFile+"（加密文件，口令取自 CODE_SWITCH_SECRETS_PASSPHRASE）")
+}
+
+func runSecretsSet(args []string) int {
+	fs := flag.NewFlagSet("secrets set", flag.ContinueOnError)
+	backend := secretBackendFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch secrets set [--backend keyring] <name>（Key 从标准输入读取）")
+		return 2
+	}
+	// 从标准输入读取，避免 Key 出现在 shell 历史与进程参数中
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "请输入 API Key: ")
+	}
+	value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && value == "" {
+		fmt.Fprintf(os.Stderr, "读取 Key 失败: %v\n", err)
+		return 1
+	}
+	ref, err := services.SetSecret(*backend, fs.Arg(0), strings.TrimRight(value, "\r\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "保存失败: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "已保存，在 provider 的 apiKey 中填写以下引用:")
+	fmt.Println(ref)
+	return 0
+}
+
+func runSecretsDelete(args []string) int {
+	fs := flag.NewFlagSet("secrets delete", flag.ContinueOnError)
+	backend := secretBackendFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch secrets delete [--backend keyring] <name>")
+		return 2
+	}
+	if err := services.DeleteSecret(*backend, fs.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "删除失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("已删除 %s:%s\n", *backend, fs.Arg(0))
+	return 0
+}
+
+func runSecretsList(args []string) int {
+	fs := flag.NewFlagSet("secrets list", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	names, err := services.ListFileSecrets()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取失败: %v\n", err)
+		return 1
+	}
+	for _, name := range names {
+		fmt.Printf("%s:%s\n", services.SecretBackendFile, name)
+	}
+	return 0
+}
+
+func runSecretsMigrate(args []string) int {
+	fs := flag.NewFlagSet("secrets migrate", flag.ContinueOnError)
+	backend := secretBackendFlag(fs)
+	dryRun := fs.Bool("dry-run", false, "只展示将要迁移的 Key，不写入")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	migrated, err := services.NewProviderService().MigrateSecrets(*backend, *dryRun)
+	prefix := ""
+	if *dryRun {
+		prefix = "[dry-run] "
+	}
+	for _, m := range migrated {
+		fmt.Printf("%s%s -> %s\n", prefix, m.Provider, m.Ref)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "迁移失败: %v\n", err)
+		return 1
+	}
+	if len(migrated) == 0 {
+		fmt.Println("配置中没有明文 Key")
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	modelpricing "codeswitch/resources/model-pricing"
+	"codeswitch/services"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "tail",
+		summary: "实时输出经过代理的请求日志",
+		run:     runTailCommand,
+	})
+}
+
+func runTailCommand(args []string) int {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	platform := fs.String("platform", "", "只显示指定平台（claude/codex）")
+	provider := fs.String("provider", "", "只显示指定 provider")
+	key := fs.String("key", "", "只显示使用指定 API Key 的请求（完整 Key 或脱敏片段）")
+	errorsOnly := fs.Bool("errors-only", false, "只显示失败的请求（非 2xx）")
+	minCost := fs.Float64("min-cost", 0, "只显示费用不低于该值（美元）的请求")
+	lines := fs.Int("n", 10, "启动时先输出最近 N 条历史日志")
+	interval := fs.Duration("interval", time.Second, "轮询间隔")
+	noColor := fs.Bool("no-color", false, "禁用彩色输出")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := services.InitDatabase(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logService := services.NewLogService()
+	filter := services.RequestLogFilter{
+		Platform:   *platform,
+		Provider:   *provider,
+		Key:        *key,
+		ErrorsOnly: *errorsOnly,
+		MinCost:    *minCost,
+	}
+	color := newANSI(*noColor)
+
+	lastID, err := logService.LatestRequestLogID()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取日志失败: %v\n", err)
+		return 1
+	}
+	recent, err := logService.RecentRequestLogs(filter, *lines)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取日志失败: %v\n", err)
+		return 1
+	}
+	for _, entry := range recent {
+		fmt.Println(formatTailLine(color, entry))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = logService.FollowRequestLogs(ctx, filter, lastID, *interval, func(entry services.ReqeustLog) {
+		fmt.Println(formatTailLine(color, entry))
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取日志失败: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func formatTailLine(color ansi, entry services.ReqeustLog) string {
+	status := fmt.Sprintf("%3d", entry.HttpCode)
+	switch {
+	case entry.HttpCode >= 200 && entry.HttpCode < 300:
+		status = color.green(status)
+	case entry.HttpCode == 429:
+		status = color.yellow(status)
+	default:
+		status = color.red(status)
+	}
+
+	cost := "      -"
+	if entry.HasPricing {
+		cost = modelpricing.FormatCost(entry.TotalCost)
+	}
+	stream := ""
+	if entry.IsStream {
+		stream = color.dim(" stream")
+	}
+	return fmt.Sprintf("%s %s %-6s %-16s %-32s %s in=%d out=%d cache=%d/%d %5.2fs %s%s",
+		color.dim(entry.CreatedAt),
+		status,
+		entry.Platform,
+		color.cyan(entry.Provider),
+		entry.Model,
+		color.dim(entry.KeyHint),
+		entry.InputTokens,
+		entry.OutputTokens,
+		entry.CacheCreateTokens,
+		entry.CacheReadTokens,
+		entry.DurationSec,
+		cost,
+		stream,
+	)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"codeswitch/services"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+)
+
+func init() {
+	registerCLICommand(cliCommand{
+		name:    "transcripts",
+		summary: "查看、导出、回放保存的请求内容与管理加密密钥（show | export | replay | keys | rotate-key）",
+		run:     runTranscriptsCommand,
+	})
+}
+
+func runTranscriptsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch transcripts <show|export|replay|keys|rotate-key> [flags]")
+		return 2
+	}
+	if err := services.InitDatabase(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	transcripts := services.NewTranscriptService()
+	switch args[0] {
+	case "show":
+		return runTranscriptsShow(transcripts, args[1:])
+	case "export":
+		return runTranscriptsExport(transcripts, args[1:])
+	case "replay":
+		return runTranscriptsReplay(transcripts, args[1:])
+	case "keys":
+		return runTranscriptsKeys(transcripts)
+	case "rotate-key":
+		return runTranscriptsRotateKey(transcripts, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "未知的 transcripts 子命令: %s\n", args[0])
+		return 2
+	}
+}
+
+func runTranscriptsShow(transcripts *services.TranscriptService, args []string) int {
+	fs := flag.NewFlagSet("transcripts show", flag.ContinueOnError)
+	reason := fs.String("reason", "", "查看原因，记录在审计中")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch transcripts show [--reason 原因] <id>")
+		return 2
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无效的 id: %s\n", fs.Arg(0))
+		return 2
+	}
+	transcript, err := transcripts.ReadTranscript(id, *reason)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("#%d %s %s/%s %s session=%s\n", transcript.ID, transcript.CreatedAt,
+		transcript.Platform, transcript.Provider, transcript.Model, transcript.SessionID)
+	if transcript.Truncated {
+		fmt.Println("（内容超过上限，已截断）")
+	}
+	fmt.Println("--- request ---")
+	fmt.Println(transcript.RequestBody)
+	fmt.Println("--- response ---")
+	fmt.Println(transcript.ResponseBody)
+	return 0
+}
+
+func runTranscriptsExport(transcripts *services.TranscriptService, args []string) int {
+	fs := flag.NewFlagSet("transcripts export", flag.ContinueOnError)
+	format := fs.String("format", services.ExportFormatClaudeCode, "导出格式：claude-code 或 openai")
+	cwd := fs.String("cwd", "", "写入 Claude Code 会话的工作目录，默认当前目录")
+	reason := fs.String("reason", "", "导出原因，记录在审计中")
+	output := fs.String("o", "", "输出文件路径，留空输出到终端")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch transcripts export [--format claude-code|openai] [--cwd 目录] [-o 文件] <session>")
+		return 2
+	}
+	data, err := transcripts.ExportConversation(fs.Arg(0), services.ConversationExportOptions{
+		Format: *format,
+		Cwd:    *cwd,
+		Reason: *reason,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return 1
+	}
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "写入文件失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("已导出到 %s\n", *output)
+	return 0
+}
+
+func runTranscriptsReplay(transcripts *services.TranscriptService, args []string) int {
+	fs := flag.NewFlagSet("transcripts replay", flag.ContinueOnError)
+	key := fs.String("key", "", "只回放使用指定 API Key 的请求（完整 Key 或脱敏片段）")
+	session := fs.String("session", "", "只回放指定会话的请求")
+	since := fs.String("since", "", "起始时间（含），格式 2006-01-02 或 2006-01-02 15:04:05")
+	until := fs.String("until", "", "结束时间（不含），格式同 --since")
+	limit := fs.Int("limit", 0, "最多回放的条数，0 表示不限制")
+	target := fs.String("target", "", "relay 地址，默认 http://127.0.0.1:18100")
+	checkpoint := fs.String("checkpoint", "", "检查点文件，中断后使用同一文件继续时跳过已成功的请求")
+	timeout := fs.Float64("timeout", 0, "单个请求的超时时间（秒），默认 600")
+	reason := fs.String("reason", "", "回放原因，解密内容时记录在审计中")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := services.ReplayOptions{
+		Criteria:       services.TranscriptCriteria{Key: *key, SessionID: *session},
+		Limit:          *limit,
+		Target:         *target,
+		Checkpoint:     *checkpoint,
+		Reason:         *reason,
+		TimeoutSeconds: *timeout,
+	}
+	var err error
+	if opts.Criteria.From, err = parseCLITime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "--since 无效: %v\n", err)
+		return 2
+	}
+	if opts.Criteria.To, err = parseCLITime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "--until 无效: %v\n", err)
+		return 2
+	}
+
+	// Ctrl+C 时在当前请求结束后停止，检查点保留已完成的进度
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	summary, err := transcripts.ReplayTranscripts(ctx, opts, func(result services.ReplayResult) {
+		switch {
+		case result.Skipped:
+			fmt.Printf("#%d 已完成，跳过\n", result.TranscriptID)
+		case result.OK():
+			fmt.Printf("#%d %s %s HTTP %d %dms\n", result.TranscriptID, result.Platform, result.Model, result.StatusCode, result.DurationMs)
+		default:
+			fmt.Printf("#%d %s %s 失败: %s\n", result.TranscriptID, result.Platform, result.Model, result.Error)
+		}
+	})
+	fmt.Printf("共 %d 条：成功 %d，失败 %d，跳过 %d\n", summary.Total, summary.Succeeded, summary.Failed, summary.Skipped)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "回放中断: %v\n", err)
+		if *checkpoint != "" {
+			fmt.Fprintf(os.Stderr, "使用相同参数与 --checkpoint %s 重新执行即可继续\n", *checkpoint)
+		}
+		return 1
+	}
+	if summary.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runTranscriptsKeys(transcripts *services.TranscriptService) int {
+	keys, err := transcripts.ListTranscriptKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取密钥失败: %v\n", err)
+		return 1
+	}
+	if len(keys) == 0 {
+		fmt.Println("尚未生成任何加密密钥")
+		return 0
+	}
+	for _, key := range keys {
+		marker := " "
+		if key.Active {
+			marker = "*"
+		}
+		fmt.Printf("%s %-10s %s  %s\n", marker, key.Tenant, key.KeyID, key.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	return 0
+}
+
+func runTranscriptsRotateKey(transcripts *services.TranscriptService, args []string) int {
+	fs := flag.NewFlagSet("transcripts rotate-key", flag.ContinueOnError)
+	reencrypt := fs.Bool("reencrypt", false, "轮换后用新密钥重新加密历史内容并删除旧密钥")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法: code-switch transcripts rotate-key [--reencrypt] <tenant>")
+		return 2
+	}
+	keyID, err := transcripts.RotateTranscriptKey(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "轮换失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("新的活动密钥: %s\n", keyID)
+	if *reencrypt {
+		count, err := transcripts.ReencryptTranscripts(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "重新加密失败（已处理 %d 条）: %v\n", count, err)
+			return 1
+		}
+		fmt.Printf("已重新加密 %d 条内容\n", count)
+	}
+	return 0
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	modelpricing "codeswitch/resources/model-pricing"
 	"codeswitch/services"
 	"embed"
 	_ "embed"
 	"fmt"
 	"log"
+	"os"
 	"runtime"
 	"time"
 
@@ -61,6 +63,10 @@ func (a *AppService) OpenSecondWindow() {
 // and starts a goroutine that emits a time-based event every second. It subsequently runs the application and
 // logs any error that might occur.
 func main() {
+	if code, handled := runCLI(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+
 	appservice := &AppService{}
 
 	suiService, errt := services.NewSuiStore()
@@ -68,7 +74,13 @@ func main() {
 		// 处理错误，比如日志或退出
 	}
 	providerService := services.NewProviderService()
-	providerRelay := services.NewProviderRelayService(providerService, ":18100")
+	relayConfigService := services.NewRelayConfigService()
+	if relayCfg, err := relayConfigService.GetRelayConfig(); err == nil {
+		services.ApplyPricingConfig(relayCfg.Pricing, relayCfg.Proxy)
+		services.ApplyCurrencyConfig(relayCfg.Currency)
+	}
+	providerRelay := services.NewProviderRelayService(providerService, relayConfigService, ":18100")
+	providerRelay.SetVersion(AppVersion)
 	claudeSettings := services.NewClaudeSettingsService(providerRelay.Addr())
 	codexSettings := services.NewCodexSettingsService(providerRelay.Addr())
 	logService := services.NewLogService()
@@ -77,6 +89,10 @@ func main() {
 	mcpService := services.NewMCPService()
 	skillService := services.NewSkillService()
 	importService := services.NewImportService(providerService, mcpService)
+	configBundleService := services.NewConfigBundleService(providerService, relayConfigService)
+	repricingService := services.NewRepricingService()
+	relayStatsService := services.NewRelayStatsService(providerRelay)
+	transcriptService := services.NewTranscriptService()
 	dockService := dock.New()
 	versionService := NewVersionService()
 
@@ -85,6 +101,7 @@ func main() {
 			log.Printf("provider relay start error: %v", err)
 		}
 	}()
+	_ = repricingService.Start()
 
 	//fmt.Println(clipboardService)
 	// Create a new Wails application by providing the necessary options.
@@ -99,6 +116,7 @@ func main() {
 			application.NewService(appservice),
 			application.NewService(suiService),
 			application.NewService(providerService),
+			application.NewService(relayConfigService),
 			application.NewService(claudeSettings),
 			application.NewService(codexSettings),
 			application.NewService(logService),
@@ -106,6 +124,10 @@ func main() {
 			application.NewService(mcpService),
 			application.NewService(skillService),
 			application.NewService(importService),
+			application.NewService(configBundleService),
+			application.NewService(repricingService),
+			application.NewService(relayStatsService),
+			application.NewService(transcriptService),
 			application.NewService(dockService),
 			application.NewService(versionService),
 		},
@@ -117,8 +139,11 @@ func main() {
 		},
 	})
 
+	// 收到 SIGTERM 或关闭应用时执行：先等待 relay 中进行中的请求完成并写入请求日志（计费需要价格数据），再停止价格更新
 	app.OnShutdown(func() {
 		_ = providerRelay.Stop()
+		_ = repricingService.Stop()
+		modelpricing.Shutdown()
 	})
 
 	// Create a new window with the necessary options.
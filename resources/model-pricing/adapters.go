@@ -0,0 +1,260 @@
+package modelpricing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 价格数据源的格式。
+const (
+	// FormatLiteLLM 为 LiteLLM 的 model_prices_and_context_window.json，内置数据源使用该格式
+	FormatLiteLLM = "litellm"
+	// FormatOpenRouter 为 OpenRouter 的 /api/v1/models 接口
+	FormatOpenRouter = "openrouter"
+	// FormatCSV 为按每 1k token 计价的 CSV 文件
+	FormatCSV = "csv"
+)
+
+// PricingSource 把非 LiteLLM 格式的数据源转换为价格条目。转换后的条目叠加在内置数据之上（同名条目整体替换），
+// 数据源未提供的模型仍按内置价格计算，转换结果按 LiteLLM 格式缓存并保存快照。
+type PricingSource interface {
+	Format() string
+	Parse(data []byte) (map[string]PricingEntry, error)
+}
+
+// SourceFormat 返回格式名对应的数据源适配器，LiteLLM 格式（或空字符串）返回 nil。
+func SourceFormat(format string) (PricingSource, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatLiteLLM:
+		return nil, nil
+	case FormatOpenRouter:
+		return OpenRouterSource{}, nil
+	case FormatCSV:
+		return CSVSource{}, nil
+	}
+	return nil, fmt.Errorf("不支持的价格数据格式: %s", format)
+}
+
+// WithSource 添加指定格式的数据源，优先于现有数据源尝试；source 为 nil 时按 LiteLLM 格式解析。
+func WithSource(url string, source PricingSource) Option {
+	return func(o *serviceOptions) {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			return
+		}
+		o.sourceURLs = cleanURLs(append([]string{url}, o.sourceURLs...))
+		formats := make(map[string]PricingSource, len(o.sourceFormats)+1)
+		for key, value := range o.sourceFormats {
+			formats[key] = value
+		}
+		if source == nil {
+			delete(formats, url)
+		} else {
+			formats[url] = source
+		}
+		o.sourceFormats = formats
+	}
+}
+
+// convertSource 把数据源返回的内容转换为 LiteLLM 格式。
+func (o serviceOptions) convertSource(url string, data []byte) ([]byte, error) {
+	source := o.sourceFormats[url]
+	if source == nil {
+		var test map[string]json.RawMessage
+		if err := json.Unmarshal(data, &test); err != nil {
+			return nil, fmt.Errorf("远程数据格式无效: %w", err)
+		}
+		return data, nil
+	}
+	entries, err := source.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 格式的价格数据失败: %w", source.Format(), err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s 格式的价格数据中没有模型", source.Format())
+	}
+	merged := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(pricingFile, &merged); err != nil {
+		return nil, fmt.Errorf("解析内置价格数据失败: %w", err)
+	}
+	for name, entry := range entries {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = encoded
+	}
+	return json.Marshal(merged)
+}
+
+// OpenRouterSource 解析 OpenRouter 的 /api/v1/models 接口（官方地址 https://openrouter.ai/api/v1/models），
+// 每个模型同时以 OpenRouter 的 ID（如 anthropic/claude-sonnet-4.5）与 openrouter/ 前缀的名称提供，按 OpenRouter 的实际价格计费。
+// 价格不固定的模型（如 openrouter/auto，价格为 -1）被忽略。
+type OpenRouterSource struct{}
+
+func (OpenRouterSource) Format() string { return FormatOpenRouter }
+
+// openRouterModel 是 OpenRouter 模型列表中的一项，价格为每 token（图片为每张）的美元字符串。
+type openRouterModel struct {
+	ID            string `json:"id"`
+	ContextLength int    `json:"context_length"`
+	Pricing       struct {
+		Prompt          openRouterPrice `json:"prompt"`
+		Completion      openRouterPrice `json:"completion"`
+		InputCacheRead  openRouterPrice `json:"input_cache_read"`
+		InputCacheWrite openRouterPrice `json:"input_cache_write"`
+		Image           openRouterPrice `json:"image"`
+	} `json:"pricing"`
+	TopProvider struct {
+		ContextLength       int `json:"context_length"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	} `json:"top_provider"`
+}
+
+// openRouterPrice 兼容字符串与数字形式的价格。
+type openRouterPrice float64
+
+func (p *openRouterPrice) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		*p = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("无效的价格 %s", data)
+	}
+	*p = openRouterPrice(value)
+	return nil
+}
+
+func (OpenRouterSource) Parse(data []byte) (map[string]PricingEntry, error) {
+	var payload struct {
+		Data []openRouterModel `json:"data"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]PricingEntry, len(payload.Data)*2)
+	for _, model := range payload.Data {
+		pricing := model.Pricing
+		if model.ID == "" || pricing.Prompt < 0 || pricing.Completion < 0 {
+			continue
+		}
+		entry := PricingEntry{
+			InputCostPerToken:           float64(pricing.Prompt),
+			OutputCostPerToken:          float64(pricing.Completion),
+			CacheReadInputTokenCost:     float64(pricing.InputCacheRead),
+			CacheCreationInputTokenCost: float64(pricing.InputCacheWrite),
+			InputCostPerImage:           float64(pricing.Image),
+			LiteLLMProvider:             FormatOpenRouter,
+			Mode:                        "chat",
+			MaxInputTokens:              TokenLimit(model.ContextLength),
+			MaxOutputTokens:             TokenLimit(model.TopProvider.MaxCompletionTokens),
+		}
+		if entry.MaxInputTokens == 0 {
+			entry.MaxInputTokens = TokenLimit(model.TopProvider.ContextLength)
+		}
+		entries[model.ID] = entry
+		if !strings.HasPrefix(model.ID, FormatOpenRouter+"/") {
+			entries[FormatOpenRouter+"/"+model.ID] = entry
+		}
+	}
+	return entries, nil
+}
+
+// CSVSource 解析按每 1k token 计价（美元）的 CSV 文件，第一行为列名，# 开头的行为注释：
+//
+//	model,input_per_1k,output_per_1k,cache_read_per_1k,cache_write_per_1k,max_input_tokens,max_output_tokens
+//	my-finetune,0.003,0.015,0.0003,0.00375,200000,64000
+//
+// 只有 model 列是必需的，未知的列被忽略，空单元格视为 0。
+type CSVSource struct{}
+
+func (CSVSource) Format() string { return FormatCSV }
+
+// csvPriceColumns 为按每 1k token 计价的列
+var csvPriceColumns = map[string]func(*PricingEntry) *float64{
+	"input_per_1k":       func(e *PricingEntry) *float64 { return &e.InputCostPerToken },
+	"output_per_1k":      func(e *PricingEntry) *float64 { return &e.OutputCostPerToken },
+	"cache_read_per_1k":  func(e *PricingEntry) *float64 { return &e.CacheReadInputTokenCost },
+	"cache_write_per_1k": func(e *PricingEntry) *float64 { return &e.CacheCreationInputTokenCost },
+}
+
+// csvLimitColumns 为 token 上限的列
+var csvLimitColumns = map[string]func(*PricingEntry) *TokenLimit{
+	"max_input_tokens":  func(e *PricingEntry) *TokenLimit { return &e.MaxInputTokens },
+	"max_output_tokens": func(e *PricingEntry) *TokenLimit { return &e.MaxOutputTokens },
+}
+
+func (CSVSource) Parse(data []byte) (map[string]PricingEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取列名失败: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	modelColumn, ok := columns["model"]
+	if !ok {
+		return nil, errors.New("缺少 model 列")
+	}
+	entries := make(map[string]PricingEntry)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		cell := func(column string) (string, bool) {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return "", false
+			}
+			value := strings.TrimSpace(record[i])
+			return value, value != ""
+		}
+		model := ""
+		if modelColumn < len(record) {
+			model = strings.TrimSpace(record[modelColumn])
+		}
+		if model == "" {
+			continue
+		}
+		entry := PricingEntry{LiteLLMProvider: FormatCSV, Mode: "chat"}
+		for column, field := range csvPriceColumns {
+			if value, ok := cell(column); ok {
+				price, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("第 %d 行 %s 列的价格无效: %s", line, column, value)
+				}
+				*field(&entry) = price / 1000
+			}
+		}
+		for column, field := range csvLimitColumns {
+			if value, ok := cell(column); ok {
+				limit, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("第 %d 行 %s 列的 token 上限无效: %s", line, column, value)
+				}
+				*field(&entry) = TokenLimit(limit)
+			}
+		}
+		entries[model] = entry
+	}
+	return entries, nil
+}
@@ -0,0 +1,67 @@
+package modelpricing
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPricingSourceAdapters(t *testing.T) {
+	openRouter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":[
+			{"id":"anthropic/claude-sonnet-4.5","context_length":1000000,"pricing":{"prompt":"0.000003","completion":"0.000015","input_cache_read":"0.0000003","input_cache_write":"0.00000375"},"top_provider":{"max_completion_tokens":64000}},
+			{"id":"openrouter/auto","pricing":{"prompt":"-1","completion":"-1"}}
+		]}`)
+	}))
+	defer openRouter.Close()
+	svc, err := New(WithSource(openRouter.URL, OpenRouterSource{}),
+		WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000, OutputTokens: 1000}
+	for _, model := range []string{"anthropic/claude-sonnet-4.5", "openrouter/anthropic/claude-sonnet-4.5"} {
+		cost := svc.CalculateCost(model, usage)
+		if cost.Match.Strategy != MatchExact || math.Abs(cost.TotalCost-0.018) > 1e-12 {
+			t.Fatalf("%s 应按 OpenRouter 的价格计费: %+v", model, cost)
+		}
+	}
+	if _, maxOutput, ok := svc.ContextWindow("anthropic/claude-sonnet-4.5"); !ok || maxOutput != 64000 {
+		t.Fatalf("应使用 OpenRouter 的上下文限制: %d %v", maxOutput, ok)
+	}
+	// 数据源未提供的模型仍按内置价格计算
+	if cost := svc.CalculateCost("claude-haiku-4-5", usage); !cost.HasPricing || svc.Info().Source != SourceRemote {
+		t.Fatalf("内置模型应保留价格: %+v %+v", cost, svc.Info())
+	}
+
+	csvSource := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}))
+	}
+	good := csvSource("# 自部署模型\nmodel,input_per_1k,output_per_1k,max_input_tokens\nmy-finetune,0.003,0.015,32000\n")
+	defer good.Close()
+	bad := csvSource("model,input_per_1k\nmy-finetune,abc\n")
+	defer bad.Close()
+	// 格式错误的数据源被跳过，使用下一个数据源
+	svc, err = New(WithSource(good.URL, CSVSource{}),
+		WithSource(bad.URL, CSVSource{}), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	if info := svc.Info(); info.SourceURL != good.URL {
+		t.Fatalf("应使用格式正确的 CSV 数据源: %+v", info)
+	}
+	if cost := svc.CalculateCost("my-finetune", usage); math.Abs(cost.TotalCost-0.018) > 1e-12 {
+		t.Fatalf("应按 CSV 中每 1k token 的价格计费: %+v", cost)
+	}
+
+	if _, err := SourceFormat("xml"); err == nil {
+		t.Fatalf("不支持的格式应返回错误")
+	}
+	if source, err := SourceFormat("OpenRouter"); err != nil || source == nil || source.Format() != FormatOpenRouter {
+		t.Fatalf("格式名应不区分大小写: %v %v", source, err)
+	}
+}
@@ -0,0 +1,63 @@
+package modelpricing
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheDirResolution(t *testing.T) {
+	home := t.TempDir()
+	xdg := t.TempDir()
+	custom := t.TempDir()
+	tests := []struct {
+		name     string
+		override string
+		env      string
+		xdg      string
+		want     string
+	}{
+		{"未设置 XDG_CACHE_HOME 时使用 ~/.cache", "", "", "", filepath.Join(home, ".cache", "code-switch")},
+		{"XDG_CACHE_HOME", "", "", xdg, filepath.Join(xdg, "code-switch")},
+		{"忽略相对路径的 XDG_CACHE_HOME", "", "", "relative/cache", filepath.Join(home, ".cache", "code-switch")},
+		{"环境变量优先于 XDG_CACHE_HOME", "", custom, xdg, custom},
+		{"SetCacheDir 优先于环境变量", filepath.Join(custom, "override"), custom, xdg, filepath.Join(custom, "override")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", home)
+			t.Setenv("XDG_CACHE_HOME", tt.xdg)
+			t.Setenv(CacheDirEnv, tt.env)
+			SetCacheDir(tt.override)
+			defer SetCacheDir("")
+			got, err := CacheDir()
+			if err != nil || got != tt.want {
+				t.Fatalf("CacheDir = (%q, %v)，期望 %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPricingCacheWrittenToXDGCacheHome(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", xdg)
+	t.Setenv(CacheDirEnv, "")
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"m":{"input_cost_per_token":0.000001}}`)
+	}))
+	defer source.Close()
+
+	if _, err := New(WithSourceURLs(source.URL), WithLogger(nil)); err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(xdg, "code-switch", cacheFileName)); err != nil {
+		t.Fatalf("未指定缓存目录时应写入 $XDG_CACHE_HOME/code-switch: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("HOME"), ".cache")); !os.IsNotExist(err) {
+		t.Fatalf("设置 XDG_CACHE_HOME 后不应写入 ~/.cache: %v", err)
+	}
+}
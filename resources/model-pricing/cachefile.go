@@ -0,0 +1,104 @@
+package modelpricing
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// 缓存目录中的锁文件，见 WithCacheLock
+	cacheLockName = "pricing.lock"
+	// 等待其他进程释放锁的时间，超时后不加锁直接写入（写入本身是原子的）
+	cacheLockTimeout = 5 * time.Second
+	// 超过该时间的锁文件视为持有锁的进程已退出
+	cacheLockStale = time.Minute
+	cacheLockRetry = 20 * time.Millisecond
+	// 损坏的缓存文件重命名后保留，便于排查
+	corruptCacheSuffix = ".corrupt"
+)
+
+// WithCacheLock 在写入价格缓存前获取缓存目录中的锁文件，多个进程（如 CLI 与常驻的 relay）共享缓存目录时
+// 保证价格数据与校验信息成对写入。未开启时每个文件仍通过临时文件改名原子写入，不会出现写了一半的文件。
+func WithCacheLock(enabled bool) Option {
+	return func(o *serviceOptions) {
+		o.cacheLock = enabled
+	}
+}
+
+// writeFileAtomic 先写入同目录的临时文件再改名，读取方只会看到完整的旧文件或新文件。
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// lockCache 获取缓存锁并返回释放函数，未开启 WithCacheLock 或无法获取锁时返回空操作。
+func (o serviceOptions) lockCache() (unlock func()) {
+	unlock = func() {}
+	if !o.cacheLock {
+		return unlock
+	}
+	cachePath, err := o.cacheFilePath()
+	if err != nil {
+		return unlock
+	}
+	path := filepath.Join(filepath.Dir(cachePath), cacheLockName)
+	deadline := time.Now().Add(cacheLockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }
+		}
+		if !os.IsExist(err) {
+			o.logf("警告：创建价格缓存锁失败，不加锁写入: %v", err)
+			return unlock
+		}
+		if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > cacheLockStale {
+			// 持有锁的进程异常退出，清理残留的锁文件
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			o.logf("警告：等待价格缓存锁超时，不加锁写入")
+			return unlock
+		}
+		time.Sleep(cacheLockRetry)
+	}
+}
+
+// quarantineCache 将无法解析的缓存文件改名保留并删除校验信息，之后的拉取不再发送条件请求。
+func (o serviceOptions) quarantineCache(cause error) {
+	cachePath, err := o.cacheFilePath()
+	if err != nil {
+		return
+	}
+	o.logf("警告：价格缓存文件已损坏，改名为 %s: %v", filepath.Base(cachePath)+corruptCacheSuffix, cause)
+	if err := os.Rename(cachePath, cachePath+corruptCacheSuffix); err != nil && !os.IsNotExist(err) {
+		os.Remove(cachePath)
+	}
+	if metaPath, err := o.cacheMetaPath(); err == nil {
+		os.Remove(metaPath)
+	}
+}
@@ -0,0 +1,88 @@
+package modelpricing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPricingCacheRecovery(t *testing.T) {
+	var requests atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	cacheDir := t.TempDir()
+	cachePath := filepath.Join(cacheDir, "model_prices_and_context_window.json")
+	opts := []Option{WithSourceURLs(failing.URL), WithCacheDir(cacheDir), WithLogger(nil)}
+
+	// 被截断的缓存与无法解析的价格数据都回退到内置数据
+	for _, content := range []string{
+		fmt.Sprintf(`{"timestamp":%d,"data":{"m":{"input_cost_per_tok`, time.Now().Unix()),
+		fmt.Sprintf(`{"timestamp":%d,"data":"not a table"}`, time.Now().Unix()),
+	} {
+		if err := os.WriteFile(cachePath, []byte(content), 0644); err != nil {
+			t.Fatalf("写入缓存失败: %v", err)
+		}
+		svc, err := New(opts...)
+		if err != nil {
+			t.Fatalf("缓存损坏时不应返回错误: %v", err)
+		}
+		if info := svc.Info(); info.Source != SourceEmbedded || info.ModelCount == 0 {
+			t.Fatalf("缓存损坏时应使用内置数据: %+v", info)
+		}
+		if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+			t.Fatalf("损坏的缓存应被移走: %v", err)
+		}
+		if _, err := os.Stat(cachePath + ".corrupt"); err != nil {
+			t.Fatalf("损坏的缓存应改名保留: %v", err)
+		}
+	}
+
+	// 多个实例共享缓存目录并发写入，缓存始终完整
+	var body atomic.Int64
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"m":{"input_cost_per_token":%d}}`, body.Add(1))
+	}))
+	defer source.Close()
+	sharedDir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc, err := New(WithSourceURLs(source.URL), WithCacheDir(sharedDir),
+				WithLogger(nil), WithCacheLock(true), WithMinRetainRatio(0), WithMaxTokenPrice(100))
+			if err != nil {
+				t.Errorf("创建价格服务失败: %v", err)
+				return
+			}
+			for j := 0; j < 5; j++ {
+				if err := svc.ForceRefresh(context.Background()); err != nil {
+					t.Errorf("刷新价格失败: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	svc, err := New(WithSourceURLs(failing.URL), WithCacheDir(sharedDir), WithLogger(nil),
+		WithMaxTokenPrice(100))
+	if err != nil || svc.Info().Source != SourceCache {
+		t.Fatalf("并发写入后缓存应可读取: %+v %v", svc.Info(), err)
+	}
+	files, _ := os.ReadDir(sharedDir)
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".tmp") || file.Name() == "pricing.lock" {
+			t.Fatalf("不应残留临时文件或锁文件: %s", file.Name())
+		}
+	}
+}
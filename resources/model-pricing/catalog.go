@@ -0,0 +1,75 @@
+package modelpricing
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// sampleSpecKey 是 LiteLLM 价格文件中说明字段含义的示例条目，不是真实模型。
+const sampleSpecKey = "sample_spec"
+
+// 1M 上下文（[1m] 后缀）模型的输入上限，价格文件中只记录标准上下文
+const longContextWindow = 1000000
+
+// TokenLimit 是价格文件中的 token 上限，兼容整数、浮点数与 sample_spec 中的说明文字（视为 0）。
+type TokenLimit int
+
+// UnmarshalJSON 忽略无法解析为数字的取值。
+func (t *TokenLimit) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		*t = 0
+		return nil
+	}
+	*t = TokenLimit(value)
+	return nil
+}
+
+// ListModels 返回价格数据中的所有模型名（按名称排序）。
+func (s *Service) ListModels() []string {
+	if s == nil {
+		return nil
+	}
+	table := s.current()
+	models := make([]string, 0, len(table.pricingMap))
+	for name := range table.pricingMap {
+		if name != sampleSpecKey {
+			models = append(models, name)
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+// GetEntry 返回模型的完整价格条目，模型名的匹配方式与 CalculateCost 相同。
+func (s *Service) GetEntry(model string) (PricingEntry, bool) {
+	if s == nil {
+		return PricingEntry{}, false
+	}
+	_, entry, _ := s.lookup(model)
+	if entry == nil {
+		return PricingEntry{}, false
+	}
+	return *entry, true
+}
+
+// ContextWindow 返回模型的最大输入与输出 tokens，缺少 max_input_tokens / max_output_tokens 时使用 max_tokens。
+// 价格数据中没有该模型或没有任何上限时 ok 为 false。
+func (s *Service) ContextWindow(model string) (maxInput int, maxOutput int, ok bool) {
+	entry, found := s.GetEntry(model)
+	if !found {
+		return 0, 0, false
+	}
+	maxInput, maxOutput = int(entry.MaxInputTokens), int(entry.MaxOutputTokens)
+	if maxInput <= 0 {
+		maxInput = int(entry.MaxTokens)
+	}
+	if maxOutput <= 0 {
+		maxOutput = int(entry.MaxTokens)
+	}
+	if strings.Contains(strings.ToLower(model), "[1m]") && maxInput < longContextWindow {
+		maxInput = longContextWindow
+	}
+	return maxInput, maxOutput, maxInput > 0 || maxOutput > 0
+}
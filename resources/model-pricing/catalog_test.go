@@ -0,0 +1,33 @@
+package modelpricing
+
+import (
+	"testing"
+)
+
+func TestModelCatalog(t *testing.T) {
+	pricing, err := NewServiceFromData([]byte(`{
+		"sample_spec":{"max_input_tokens":"max input tokens","max_tokens":"legacy"},
+		"model-b":{"input_cost_per_token":0.000001,"max_input_tokens":128000,"max_output_tokens":16384.0,"litellm_provider":"openai","mode":"chat"},
+		"model-a":{"input_cost_per_token":0.000002,"max_tokens":8192}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	if models := pricing.ListModels(); len(models) != 2 || models[0] != "model-a" || models[1] != "model-b" {
+		t.Fatalf("ListModels = %v", models)
+	}
+	entry, ok := pricing.GetEntry("model-b")
+	if !ok || entry.LiteLLMProvider != "openai" || entry.Mode != "chat" || entry.InputCostPerToken != 0.000001 {
+		t.Fatalf("GetEntry = (%+v, %v)", entry, ok)
+	}
+	if in, out, ok := pricing.ContextWindow("model-b"); !ok || in != 128000 || out != 16384 {
+		t.Fatalf("model-b 上下文 = (%d, %d, %v)", in, out, ok)
+	}
+	// 只有 max_tokens 时输入输出均使用该值
+	if in, out, ok := pricing.ContextWindow("model-a"); !ok || in != 8192 || out != 8192 {
+		t.Fatalf("model-a 上下文 = (%d, %d, %v)", in, out, ok)
+	}
+	if _, _, ok := pricing.ContextWindow("unknown-model-xyz"); ok {
+		t.Fatalf("未知模型不应返回上下文上限")
+	}
+}
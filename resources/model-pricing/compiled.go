@@ -0,0 +1,126 @@
+package modelpricing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 编译后的价格数据缓存（gob 格式），与 JSON 缓存放在同一目录。
+// 解析数千个模型的 JSON 是启动时最耗时的一步，内容摘要相同时直接读取解码好的条目。
+const compiledCacheFileName = "model_prices.compiled.gob"
+
+// compiledSchema 为 PricingEntry 的字段签名，字段变化后旧的编译缓存自动失效。
+var compiledSchema = func() string {
+	entryType := reflect.TypeOf(PricingEntry{})
+	fields := make([]string, 0, entryType.NumField())
+	for i := 0; i < entryType.NumField(); i++ {
+		field := entryType.Field(i)
+		fields = append(fields, field.Name+":"+field.Type.String())
+	}
+	return strings.Join(fields, ",")
+}()
+
+// compiledCache 是编译缓存的文件内容。
+type compiledCache struct {
+	Schema string
+	// Checksum 为原始 JSON 数据的完整 SHA256 摘要
+	Checksum string
+	Entries  map[string]PricingEntry
+}
+
+// decodePricingData 解析 LiteLLM 格式的价格数据。
+func decodePricingData(data []byte) (map[string]PricingEntry, error) {
+	raw := make(map[string]PricingEntry)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析价格数据失败: %w", err)
+	}
+	return raw, nil
+}
+
+// compiledEntries 返回价格数据解码后的条目，优先读取摘要相同的编译缓存，未命中时解析 JSON 并更新编译缓存。
+// 返回的 map 可由调用方修改。
+func (o serviceOptions) compiledEntries(data []byte) (map[string]PricingEntry, error) {
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	path, pathErr := o.compiledCachePath()
+	if pathErr == nil {
+		if file, err := os.Open(path); err == nil {
+			var cache compiledCache
+			err = gob.NewDecoder(file).Decode(&cache)
+			file.Close()
+			if err == nil && cache.Schema == compiledSchema && cache.Checksum == checksum && cache.Entries != nil {
+				return cache.Entries, nil
+			}
+		}
+	}
+	raw, err := decodePricingData(data)
+	if err != nil {
+		return nil, err
+	}
+	if pathErr == nil {
+		var encoded bytes.Buffer
+		if err := gob.NewEncoder(&encoded).Encode(compiledCache{Schema: compiledSchema, Checksum: checksum, Entries: raw}); err == nil {
+			if err := writeFileAtomic(path, encoded.Bytes(), 0644); err != nil {
+				o.logf("警告：保存编译的价格缓存失败: %v", err)
+			}
+		}
+	}
+	return raw, nil
+}
+
+func (o serviceOptions) compiledCachePath() (string, error) {
+	cachePath, err := o.cacheFilePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cachePath), compiledCacheFileName), nil
+}
+
+// normalizedName 是价格条目名称与其归一化的结果。
+type normalizedName struct {
+	key        string
+	normalized string
+}
+
+// normalizedIndex 返回忽略大小写与分隔符后的模型名索引，首次进行 normalized 匹配时才构建。
+// 多个模型归一化后同名时取名称最小的一个。
+func (t *pricingTable) normalizedIndex() map[string]string {
+	t.buildNormalized()
+	return t.normalized
+}
+
+// fuzzyNames 返回按名称排序的归一化模型名（不含 sample_spec）。
+func (t *pricingTable) fuzzyNames() []normalizedName {
+	t.buildNormalized()
+	return t.normalizedNames
+}
+
+func (t *pricingTable) buildNormalized() {
+	t.normalizeOnce.Do(func() {
+		keys := make([]string, 0, len(t.pricingMap))
+		for key := range t.pricingMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		t.normalized = make(map[string]string, len(keys))
+		t.normalizedNames = make([]normalizedName, 0, len(keys))
+		for _, key := range keys {
+			norm := normalizeName(key)
+			if _, exists := t.normalized[norm]; !exists {
+				t.normalized[norm] = key
+			}
+			if key != sampleSpecKey {
+				t.normalizedNames = append(t.normalizedNames, normalizedName{key: key, normalized: norm})
+			}
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package modelpricing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPricingCompiledCache(t *testing.T) {
+	var body atomic.Value
+	body.Store(`{"Model-A":{"input_cost_per_token":0.000001}}`)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body.Load().(string))
+	}))
+	defer source.Close()
+	cacheDir := t.TempDir()
+	compiledPath := filepath.Join(cacheDir, "model_prices.compiled.gob")
+	opts := []Option{WithSourceURLs(source.URL), WithCacheDir(cacheDir),
+		WithLogger(nil), WithMinRetainRatio(0)}
+	usage := UsageSnapshot{InputTokens: 1000}
+	check := func(svc *Service, want float64) {
+		t.Helper()
+		cost := svc.CalculateCost("model_a", usage)
+		if cost.Match.Strategy != MatchNormalized || math.Abs(cost.TotalCost-want) > 1e-12 {
+			t.Fatalf("费用应为 %v: %+v", want, cost)
+		}
+	}
+
+	svc, err := New(opts...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	check(svc, 0.001)
+	if _, err := os.Stat(compiledPath); err != nil {
+		t.Fatalf("应保存编译的价格缓存: %v", err)
+	}
+	// 第二次启动读取 JSON 缓存，条目来自编译缓存
+	reopened, err := New(opts...)
+	if err != nil || reopened.Info().Source != SourceCache || reopened.Version() != svc.Version() {
+		t.Fatalf("应从缓存启动: %+v %v", reopened.Info(), err)
+	}
+	check(reopened, 0.001)
+
+	// 数据变化后编译缓存按摘要失效
+	body.Store(`{"Model-A":{"input_cost_per_token":0.000002}}`)
+	if err := reopened.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("刷新价格失败: %v", err)
+	}
+	check(reopened, 0.002)
+	reopened, _ = New(opts...)
+	check(reopened, 0.002)
+
+	// 损坏的编译缓存被忽略
+	if err := os.WriteFile(compiledPath, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("写入编译缓存失败: %v", err)
+	}
+	reopened, err = New(opts...)
+	if err != nil {
+		t.Fatalf("编译缓存损坏时不应返回错误: %v", err)
+	}
+	check(reopened, 0.002)
+}
@@ -0,0 +1,293 @@
+package modelpricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CurrencyUSD 是价格数据使用的货币，所有费用都以美元计算后再换算。
+	CurrencyUSD = "USD"
+	// 默认的汇率数据源（以美元为基准）
+	defaultRateSourceURL = "https://open.er-api.com/v6/latest/USD"
+	// 汇率缓存的有效期
+	defaultRateCacheTTL = 24 * time.Hour
+	rateCacheFileName   = "exchange_rates.json"
+	rateFetchTimeout    = 10 * time.Second
+)
+
+// RateSource 提供以美元为基准的汇率：1 USD = rates[code] 单位该货币。
+type RateSource interface {
+	FetchRates() (map[string]float64, error)
+}
+
+// StaticRates 是固定的汇率表，适合使用账单上的结算汇率。
+type StaticRates map[string]float64
+
+// FetchRates 返回汇率表的副本。
+func (r StaticRates) FetchRates() (map[string]float64, error) {
+	rates := make(map[string]float64, len(r))
+	for code, rate := range r {
+		rates[strings.ToUpper(code)] = rate
+	}
+	return rates, nil
+}
+
+// HTTPRateSource 从返回 {"rates": {"CNY": 7.1, ...}} 格式 JSON 的接口获取汇率。
+type HTTPRateSource struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// FetchRates 请求远程汇率接口。
+func (s HTTPRateSource) FetchRates() (map[string]float64, error) {
+	url := s.URL
+	if url == "" {
+		url = defaultRateSourceURL
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = rateFetchTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("请求汇率数据失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("汇率服务器返回错误状态: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取汇率数据失败: %w", err)
+	}
+	var payload struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("汇率数据格式无效: %w", err)
+	}
+	if len(payload.Rates) == 0 {
+		return nil, errors.New("汇率数据为空")
+	}
+	return payload.Rates, nil
+}
+
+// CurrencyConverter 将美元费用换算为目标货币，汇率在内存与本地缓存中保存 ttl 时长。
+type CurrencyConverter struct {
+	mu        sync.Mutex
+	source    RateSource
+	ttl       time.Duration
+	target    string
+	rates     map[string]float64
+	fetchedAt time.Time
+	// persist 为 true 时将远程汇率写入缓存目录，供下次启动或离线时使用
+	persist bool
+}
+
+// NewCurrencyConverter 创建换算器，source 为 nil 时使用默认的远程汇率源。
+func NewCurrencyConverter(target string, source RateSource, ttl time.Duration) *CurrencyConverter {
+	persist := false
+	if source == nil {
+		source = HTTPRateSource{}
+		persist = true
+	} else if _, ok := source.(HTTPRateSource); ok {
+		persist = true
+	}
+	if ttl <= 0 {
+		ttl = defaultRateCacheTTL
+	}
+	target = strings.ToUpper(strings.TrimSpace(target))
+	if target == "" {
+		target = CurrencyUSD
+	}
+	return &CurrencyConverter{source: source, ttl: ttl, target: target, persist: persist}
+}
+
+// Target 返回目标货币代码。
+func (c *CurrencyConverter) Target() string {
+	if c == nil {
+		return CurrencyUSD
+	}
+	return c.target
+}
+
+// Rate 返回 1 美元可兑换的目标货币数量。
+func (c *CurrencyConverter) Rate() (float64, error) {
+	if c == nil || c.target == CurrencyUSD {
+		return 1, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rates == nil && c.persist {
+		if rates, fetchedAt, err := loadRateCache(); err == nil {
+			c.rates, c.fetchedAt = rates, fetchedAt
+		}
+	}
+	if c.rates == nil || time.Since(c.fetchedAt) > c.ttl {
+		rates, err := c.source.FetchRates()
+		if err == nil {
+			c.rates, c.fetchedAt = rates, time.Now()
+			if c.persist {
+				if err := saveRateCache(rates, c.fetchedAt); err != nil {
+					fmt.Printf("保存汇率缓存失败: %v\n", err)
+				}
+			}
+		} else if c.rates == nil {
+			return 0, err
+		} else {
+			// 刷新失败时继续使用过期的汇率
+			fmt.Printf("刷新汇率失败，使用 %s 的缓存汇率: %v\n", c.fetchedAt.Format("2006-01-02 15:04"), err)
+		}
+	}
+	rate, ok := c.rates[c.target]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("没有 %s 的汇率", c.target)
+	}
+	return rate, nil
+}
+
+// Convert 将美元金额换算为目标货币。
+func (c *CurrencyConverter) Convert(usd float64) (float64, error) {
+	rate, err := c.Rate()
+	if err != nil {
+		return 0, err
+	}
+	return usd * rate, nil
+}
+
+// Format 将美元金额换算并格式化为目标货币，换算失败时按美元显示。
+func (c *CurrencyConverter) Format(usd float64) string {
+	amount, err := c.Convert(usd)
+	if err != nil {
+		return FormatAmount(usd, CurrencyUSD)
+	}
+	return FormatAmount(amount, c.Target())
+}
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"CNY": "¥",
+	"JPY": "¥",
+	"EUR": "€",
+	"GBP": "£",
+	"KRW": "₩",
+	"HKD": "HK$",
+	"TWD": "NT$",
+	"SGD": "S$",
+	"AUD": "A$",
+	"CAD": "C$",
+	"INR": "₹",
+	"RUB": "₽",
+}
+
+// CurrencySymbol 返回货币符号，未知货币返回代码本身。
+func CurrencySymbol(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol
+	}
+	return currency + " "
+}
+
+// FormatAmount 按货币格式化金额。
+// 单次请求的费用通常很小，因此比常规记账格式保留更多小数位（日元、韩元 2 位，其余 4 位）。
+func FormatAmount(amount float64, currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = CurrencyUSD
+	}
+	decimals := 4
+	switch currency {
+	case "JPY", "KRW":
+		decimals = 2
+	}
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%s%.*f", sign, CurrencySymbol(currency), decimals, amount)
+}
+
+var (
+	currencyMu       sync.RWMutex
+	defaultConverter = NewCurrencyConverter(CurrencyUSD, StaticRates{}, 0)
+)
+
+// ConfigureCurrency 设置报表使用的目标货币与汇率源，source 为 nil 时使用默认的远程汇率源。
+func ConfigureCurrency(target string, source RateSource, ttl time.Duration) {
+	converter := NewCurrencyConverter(target, source, ttl)
+	currencyMu.Lock()
+	defaultConverter = converter
+	currencyMu.Unlock()
+}
+
+// DefaultConverter 返回 ConfigureCurrency 设置的换算器（默认不换算，显示美元）。
+func DefaultConverter() *CurrencyConverter {
+	currencyMu.RLock()
+	defer currencyMu.RUnlock()
+	return defaultConverter
+}
+
+// FormatCost 将美元费用按配置的目标货币格式化。
+func FormatCost(usd float64) string {
+	return DefaultConverter().Format(usd)
+}
+
+type rateCache struct {
+	FetchedAt int64              `json:"fetched_at"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+func getRateCachePath() (string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+	return filepath.Join(dir, rateCacheFileName), nil
+}
+
+func loadRateCache() (map[string]float64, time.Time, error) {
+	path, err := getRateCachePath()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var cache rateCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(cache.Rates) == 0 {
+		return nil, time.Time{}, errors.New("汇率缓存为空")
+	}
+	return cache.Rates, time.Unix(cache.FetchedAt, 0), nil
+}
+
+func saveRateCache(rates map[string]float64, fetchedAt time.Time) error {
+	path, err := getRateCachePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rateCache{FetchedAt: fetchedAt.Unix(), Rates: rates})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
@@ -0,0 +1,69 @@
+package modelpricing
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+)
+
+// 每份价格数据缓存的模型名匹配结果数量，超出后淘汰最久未使用的
+const lookupCacheSize = 1024
+
+// lookupCache 是模型名到匹配结果的 LRU 缓存。relay 每次响应都会计算费用，
+// 价格数据中没有的模型名每次都要做一次模糊匹配，缓存后只在首次出现时扫描。
+type lookupCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lookupResult struct {
+	key   string
+	entry *PricingEntry
+	match ModelMatch
+}
+
+func (c *lookupCache) get(key string) (lookupResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return lookupResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(lookupResult), true
+}
+
+func (c *lookupCache) put(result lookupResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element, lookupCacheSize)
+		c.order = list.New()
+	}
+	if element, ok := c.entries[result.key]; ok {
+		element.Value = result
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[result.key] = c.order.PushFront(result)
+	if c.order.Len() > lookupCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(lookupResult).key)
+	}
+}
+
+// resolve 与 match 相同，同名的价格条目直接返回，其余结果按模型名与匹配规则缓存。
+func (t *pricingTable) resolve(model string, opts matchOptions) (*PricingEntry, ModelMatch) {
+	if entry, ok := t.pricingMap[model]; ok {
+		return entry, ModelMatch{Key: model, Strategy: MatchExact}
+	}
+	key := strconv.FormatBool(opts.strict) + ":" + strconv.FormatUint(opts.generation, 10) + ":" + model
+	if cached, ok := t.lookups.get(key); ok {
+		return cached.entry, cached.match
+	}
+	entry, match := t.match(model, opts)
+	t.lookups.put(lookupResult{key: key, entry: entry, match: match})
+	return entry, match
+}
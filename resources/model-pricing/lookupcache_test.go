@@ -0,0 +1,25 @@
+package modelpricing
+
+import (
+	"testing"
+)
+
+func TestPricingLookupCache(t *testing.T) {
+	svc, err := NewService()
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	first := svc.MatchModel("claude-sonnet-4-5-custom-build")
+	if again := svc.MatchModel("claude-sonnet-4-5-custom-build"); again.Key != first.Key || again.Strategy != first.Strategy {
+		t.Fatalf("缓存的匹配结果应与首次一致: %+v %+v", first, again)
+	}
+	// 别名变化后不再使用缓存的结果
+	svc.SetAliases(map[string]string{"claude-sonnet-4-5-custom-build": "claude-haiku-4-5"})
+	if match := svc.MatchModel("claude-sonnet-4-5-custom-build"); match.Strategy != MatchAlias || match.Key != "claude-haiku-4-5" {
+		t.Fatalf("设置别名后应按别名匹配: %+v", match)
+	}
+	svc.SetAliases(nil)
+	if match := svc.MatchModel("claude-sonnet-4-5-custom-build"); match.Key != first.Key || match.Strategy != first.Strategy {
+		t.Fatalf("清除别名后应恢复原来的匹配: %+v", match)
+	}
+}
@@ -0,0 +1,344 @@
+package modelpricing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 模型名匹配价格条目的方式，按尝试顺序排列。
+const (
+	// MatchNone 表示没有匹配的价格条目
+	MatchNone = "none"
+	// MatchExact 为价格数据中的同名条目
+	MatchExact = "exact"
+	// MatchAlias 为模型别名（内置的 gpt-5-codex -> gpt-5 或 WithAliases 定义的别名）指向的条目，或去掉 [1m] 后缀的同名条目
+	MatchAlias = "alias"
+	// MatchPrefix 为去掉 Bedrock 推理配置文件 ARN、区域前缀（us./eu./apac./global. 等）、anthropic. 前缀与 -v1:0 版本后缀后的同名条目，
+	// 或 Vertex AI 模型名（claude-sonnet-4-5@20250929）对应的 vertex_ai/ 条目与以 - 分隔日期的条目，以及 OpenRouter 的 slug（anthropic/claude-sonnet-4.5）对应的 openrouter/ 条目
+	MatchPrefix = "prefix"
+	// MatchNormalized 为忽略大小写与 -_.:/ 等分隔符后的同名条目
+	MatchNormalized = "normalized"
+	// MatchFuzzy 为名称互相包含的条目，可能匹配到错误的模型，严格模式下不使用
+	MatchFuzzy = "fuzzy"
+)
+
+// 诊断信息中最多列出的模糊匹配候选数量
+const maxMatchCandidates = 10
+
+// builtinAliases 是价格数据中没有同名条目的模型使用的价格，用户定义的别名优先。
+var builtinAliases = map[string]string{
+	"gpt-5-codex": "gpt-5",
+}
+
+// matchOptions 是一次匹配使用的规则。
+type matchOptions struct {
+	strict bool
+	// 用户定义的别名，键为小写的模型名
+	aliases map[string]string
+	// 解析别名指向的模型名时不再展开别名，避免循环
+	noAliases bool
+	// 用户别名的版本，别名变化后不再使用缓存的匹配结果
+	generation uint64
+	// 不为 nil 时记录每一步尝试，见 ExplainMatch
+	trace *[]MatchStep
+}
+
+// MatchStep 是匹配过程中的一次尝试。
+type MatchStep struct {
+	Strategy string `json:"strategy"`
+	// Name 为该步查找的名称（normalized / fuzzy 为归一化后的名称）
+	Name    string `json:"name"`
+	Matched bool   `json:"matched"`
+	// Key 为该步匹配到的价格条目名称
+	Key string `json:"key,omitempty"`
+	// Note 说明该步的附加信息，如别名目标或跳过的原因
+	Note string `json:"note,omitempty"`
+}
+
+// step 在需要诊断时记录一次尝试。
+func (o matchOptions) step(strategy string, name string, key string, note string) {
+	if o.trace != nil {
+		*o.trace = append(*o.trace, MatchStep{Strategy: strategy, Name: name, Matched: key != "", Key: key, Note: note})
+	}
+}
+
+// alias 返回模型名的别名目标。
+func (o matchOptions) alias(model string) (string, bool) {
+	if o.noAliases {
+		return "", false
+	}
+	if target, ok := o.aliases[strings.ToLower(model)]; ok {
+		return target, true
+	}
+	target, ok := builtinAliases[model]
+	return target, ok
+}
+
+// ModelMatch 描述模型名匹配到的价格条目，用于记录错误或有歧义的匹配。
+type ModelMatch struct {
+	// Key 为匹配到的价格条目名称，未匹配时为空
+	Key      string `json:"key,omitempty"`
+	Strategy string `json:"strategy"`
+	// Candidates 为模糊匹配时所有名称互相包含的条目（按名称排序，最多 10 个），多于一个时 Key 取名称长度最接近的一个
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+// Found 返回是否匹配到价格条目。
+func (m ModelMatch) Found() bool {
+	return m.Strategy != "" && m.Strategy != MatchNone
+}
+
+// Ambiguous 返回模糊匹配是否有多个候选条目。
+func (m ModelMatch) Ambiguous() bool {
+	return m.Strategy == MatchFuzzy && len(m.Candidates) > 1
+}
+
+// WithStrictMatching 启用严格匹配：模型名只按 exact/alias/prefix/normalized 方式匹配，不做模糊匹配，
+// 避免 sonnet 之类的名称被计入其他模型（如微调模型）的价格，未匹配的请求不计费（HasPricing 为 false）。
+func WithStrictMatching(strict bool) Option {
+	return func(o *serviceOptions) {
+		o.strictMatching = strict
+	}
+}
+
+// WithAliases 添加模型别名（模型名 -> 价格条目名，模型名不区分大小写），用于 relay 以自定义名称提供的模型，
+// 如 {"kimi-for-coding": "moonshot/kimi-k2-0905-preview"}。别名指向的名称同样按 MatchModel 的规则匹配。
+func WithAliases(aliases map[string]string) Option {
+	return func(o *serviceOptions) {
+		merged := make(map[string]string, len(o.aliases)+len(aliases))
+		for alias, target := range o.aliases {
+			merged[alias] = target
+		}
+		for alias, target := range cleanAliases(aliases) {
+			merged[alias] = target
+		}
+		o.aliases = merged
+	}
+}
+
+// SetAliases 替换运行中的服务的用户别名（不影响内置别名）。
+func (s *Service) SetAliases(aliases map[string]string) {
+	if s == nil {
+		return
+	}
+	cleaned := cleanAliases(aliases)
+	s.mu.Lock()
+	s.aliases = cleaned
+	s.aliasGeneration++
+	s.mu.Unlock()
+}
+
+// Aliases 返回生效的模型别名（内置别名与用户别名，用户别名优先）。
+func (s *Service) Aliases() map[string]string {
+	aliases := make(map[string]string, len(builtinAliases))
+	for alias, target := range builtinAliases {
+		aliases[alias] = target
+	}
+	if s == nil {
+		return aliases
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for alias, target := range s.aliases {
+		aliases[alias] = target
+	}
+	return aliases
+}
+
+// SetAliases 替换 Configure 全局选项与 DefaultService 的用户别名，之后创建的 NewServiceWithDynamicUpdate 实例同样使用。
+func SetAliases(aliases map[string]string) {
+	optionsMu.Lock()
+	globalOptions.aliases = cleanAliases(aliases)
+	optionsMu.Unlock()
+	if svc := defaultInstance.Load(); svc != nil {
+		svc.SetAliases(aliases)
+	}
+}
+
+// cleanAliases 去掉空白与空的别名，模型名转为小写。
+func cleanAliases(aliases map[string]string) map[string]string {
+	cleaned := make(map[string]string, len(aliases))
+	for alias, target := range aliases {
+		alias, target = strings.ToLower(strings.TrimSpace(alias)), strings.TrimSpace(target)
+		if alias != "" && target != "" {
+			cleaned[alias] = target
+		}
+	}
+	return cleaned
+}
+
+// MatchModel 返回模型名匹配价格条目的方式，匹配规则与 CalculateCost 相同。
+func (s *Service) MatchModel(model string) ModelMatch {
+	if s == nil {
+		return ModelMatch{Strategy: MatchNone}
+	}
+	_, _, match := s.lookup(model)
+	return match
+}
+
+// MatchExplanation 是 ExplainMatch 的结果。
+type MatchExplanation struct {
+	Model string      `json:"model"`
+	Match ModelMatch  `json:"match"`
+	Steps []MatchStep `json:"steps"`
+}
+
+// ExplainMatch 返回模型名匹配价格条目的每一步尝试，用于排查费用为 0 或按错误的模型计费的原因。
+// 匹配规则与 MatchModel 相同，但不使用缓存的匹配结果。
+func (s *Service) ExplainMatch(model string) MatchExplanation {
+	explanation := MatchExplanation{Model: model, Match: ModelMatch{Strategy: MatchNone}}
+	if s == nil {
+		return explanation
+	}
+	opts := s.matchOptions()
+	opts.trace = &explanation.Steps
+	_, explanation.Match = s.current().match(model, opts)
+	return explanation
+}
+
+// lookup 返回当前价格数据与模型匹配到的价格条目。
+func (s *Service) lookup(model string) (*pricingTable, *PricingEntry, ModelMatch) {
+	table := s.current()
+	entry, match := table.resolve(model, s.matchOptions())
+	return table, entry, match
+}
+
+// matchOptions 返回当前的匹配规则。
+func (s *Service) matchOptions() matchOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return matchOptions{strict: s.options.strictMatching, aliases: s.aliases, generation: s.aliasGeneration}
+}
+
+// match 按 ModelMatch 的策略顺序查找价格条目，strict 为 true 时不做模糊匹配。
+func (t *pricingTable) match(model string, opts matchOptions) (*PricingEntry, ModelMatch) {
+	if model == "" {
+		return nil, ModelMatch{Strategy: MatchNone}
+	}
+	if entry, ok := t.pricingMap[model]; ok {
+		opts.step(MatchExact, model, model, "")
+		return entry, ModelMatch{Key: model, Strategy: MatchExact}
+	}
+	opts.step(MatchExact, model, "", "")
+	if target, ok := opts.alias(model); ok {
+		opts.step(MatchAlias, model, "", "别名指向 "+target)
+		resolve := opts
+		resolve.noAliases = true
+		if entry, match := t.match(target, resolve); match.Found() {
+			// 别名指向的名称模糊匹配时保留 fuzzy，便于调用方发现有歧义的别名
+			if match.Strategy != MatchFuzzy {
+				match.Strategy = MatchAlias
+			}
+			return entry, match
+		}
+	}
+	if strings.HasSuffix(strings.ToLower(model), "[1m]") {
+		opts.step(MatchAlias, model, "", "去掉 [1m] 后缀")
+		if entry, match := t.match(model[:len(model)-len("[1m]")], opts); match.Found() {
+			if match.Strategy == MatchExact {
+				match.Strategy = MatchAlias
+			}
+			return entry, match
+		}
+	}
+	withoutRegion := stripRegionPrefix(model)
+	if id := bedrockModelID(model); id != model {
+		if entry, ok := t.pricingMap[id]; ok {
+			opts.step(MatchPrefix, id, id, "取 ARN 中的模型 ID")
+			return entry, ModelMatch{Key: id, Strategy: MatchPrefix}
+		}
+		opts.step(MatchPrefix, id, "", "取 ARN 中的模型 ID")
+		withoutRegion = stripRegionPrefix(id)
+	}
+	if withoutRegion != model {
+		if entry, ok := t.pricingMap[withoutRegion]; ok {
+			opts.step(MatchPrefix, withoutRegion, withoutRegion, "去掉区域前缀")
+			return entry, ModelMatch{Key: withoutRegion, Strategy: MatchPrefix}
+		}
+		opts.step(MatchPrefix, withoutRegion, "", "去掉区域前缀")
+	}
+	withoutProvider := strings.TrimPrefix(withoutRegion, "anthropic.")
+	if withoutProvider != withoutRegion {
+		if entry, ok := t.pricingMap[withoutProvider]; ok {
+			opts.step(MatchPrefix, withoutProvider, withoutProvider, "去掉 anthropic. 前缀")
+			return entry, ModelMatch{Key: withoutProvider, Strategy: MatchPrefix}
+		}
+		opts.step(MatchPrefix, withoutProvider, "", "去掉 anthropic. 前缀")
+	}
+	if withoutVersion := stripBedrockVersion(withoutProvider); withoutVersion != withoutProvider {
+		if entry, ok := t.pricingMap[withoutVersion]; ok {
+			opts.step(MatchPrefix, withoutVersion, withoutVersion, "去掉 Bedrock 版本后缀")
+			return entry, ModelMatch{Key: withoutVersion, Strategy: MatchPrefix}
+		}
+		opts.step(MatchPrefix, withoutVersion, "", "去掉 Bedrock 版本后缀")
+	}
+	// Vertex AI 的模型名以 @ 分隔版本日期，如 claude-sonnet-4-5@20250929
+	if strings.Contains(model, "@") {
+		for _, candidate := range []string{"vertex_ai/" + model, strings.Replace(model, "@", "-", 1)} {
+			if entry, ok := t.pricingMap[candidate]; ok {
+				opts.step(MatchPrefix, candidate, candidate, "Vertex AI 模型名")
+				return entry, ModelMatch{Key: candidate, Strategy: MatchPrefix}
+			}
+			opts.step(MatchPrefix, candidate, "", "Vertex AI 模型名")
+		}
+	}
+	// OpenRouter 的模型 slug 为 <厂商>/<模型>，价格数据中以 openrouter/ 开头
+	if strings.Contains(model, "/") && !strings.HasPrefix(model, "openrouter/") {
+		candidate := "openrouter/" + model
+		if entry, ok := t.pricingMap[candidate]; ok {
+			opts.step(MatchPrefix, candidate, candidate, "OpenRouter 模型 slug")
+			return entry, ModelMatch{Key: candidate, Strategy: MatchPrefix}
+		}
+		opts.step(MatchPrefix, candidate, "", "OpenRouter 模型 slug")
+	}
+	normalizedTarget := normalizeName(model)
+	if key, ok := t.normalizedIndex()[normalizedTarget]; ok {
+		opts.step(MatchNormalized, normalizedTarget, key, "")
+		return t.pricingMap[key], ModelMatch{Key: key, Strategy: MatchNormalized}
+	}
+	opts.step(MatchNormalized, normalizedTarget, "", "")
+	if opts.strict {
+		opts.step(MatchFuzzy, normalizedTarget, "", "严格匹配模式，不做模糊匹配")
+		return nil, ModelMatch{Strategy: MatchNone}
+	}
+	entry, match := t.fuzzyMatch(normalizedTarget)
+	note := ""
+	if match.Ambiguous() {
+		note = fmt.Sprintf("%d 个候选，取名称长度最接近的一个", len(match.Candidates))
+	}
+	opts.step(MatchFuzzy, normalizedTarget, match.Key, note)
+	return entry, match
+}
+
+// fuzzyMatch 查找名称与 normalizedTarget 互相包含的条目，多个候选时取名称长度最接近的（相同时取名称较小的）。
+func (t *pricingTable) fuzzyMatch(normalizedTarget string) (*PricingEntry, ModelMatch) {
+	if normalizedTarget == "" {
+		return nil, ModelMatch{Strategy: MatchNone}
+	}
+	// 名称已按顺序排列并预先归一化
+	var candidates []string
+	best, bestDistance := "", 0
+	for _, name := range t.fuzzyNames() {
+		if strings.Contains(name.normalized, normalizedTarget) || strings.Contains(normalizedTarget, name.normalized) {
+			candidates = append(candidates, name.key)
+			if distance := lengthDistance(name.normalized, normalizedTarget); best == "" || distance < bestDistance {
+				best, bestDistance = name.key, distance
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ModelMatch{Strategy: MatchNone}
+	}
+	match := ModelMatch{Key: best, Strategy: MatchFuzzy, Candidates: candidates}
+	if len(candidates) > maxMatchCandidates {
+		match.Candidates = candidates[:maxMatchCandidates]
+	}
+	return t.pricingMap[best], match
+}
+
+func lengthDistance(a string, b string) int {
+	if len(a) > len(b) {
+		return len(a) - len(b)
+	}
+	return len(b) - len(a)
+}
@@ -0,0 +1,113 @@
+package modelpricing
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPricingStrictMatching(t *testing.T) {
+	data := []byte(`{
+		"claude-sonnet-4-5": {"input_cost_per_token": 0.000003},
+		"ft:claude-sonnet-4-5:corp": {"input_cost_per_token": 0.00003},
+		"us.anthropic.claude-haiku-4-5": {"input_cost_per_token": 0.000001},
+		"gpt-5": {"input_cost_per_token": 0.00000125}
+	}`)
+	loose, err := NewServiceFromData(data)
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	cases := map[string]string{
+		"claude-sonnet-4-5":          MatchExact,
+		"gpt-5-codex":                MatchAlias,
+		"claude-sonnet-4-5[1m]":      MatchAlias,
+		"eu.claude-sonnet-4-5":       MatchPrefix,
+		"Claude_Sonnet_4.5":          MatchNormalized,
+		"us.anthropic.claude-haiku?": MatchNone,
+	}
+	for model, strategy := range cases {
+		if got := loose.MatchModel(model); got.Strategy != strategy {
+			t.Errorf("%s 的匹配方式应为 %s: %+v", model, strategy, got)
+		}
+	}
+
+	usage := UsageSnapshot{InputTokens: 1000}
+	cost := loose.CalculateCost("sonnet-4-5", usage)
+	if cost.Match.Strategy != MatchFuzzy || !cost.Match.Ambiguous() || cost.Match.Key != "claude-sonnet-4-5" ||
+		len(cost.Match.Candidates) != 2 || math.Abs(cost.TotalCost-0.003) > 1e-12 {
+		t.Fatalf("模糊匹配应报告所有候选并取名称最接近的条目: %+v", cost)
+	}
+
+	strict, err := New(WithOffline(true), WithStrictMatching(true), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	if cost := strict.CalculateCost("sonnet", usage); cost.HasPricing || cost.TotalCost != 0 || cost.Match.Found() {
+		t.Fatalf("严格模式不应模糊匹配: %+v", cost)
+	}
+	if cost := strict.CalculateCost("us.anthropic.claude-sonnet-4-5-20250929-v1:0", usage); !cost.HasPricing || cost.Match.Strategy == MatchFuzzy {
+		t.Fatalf("严格模式仍应按前缀匹配: %+v", cost)
+	}
+}
+
+func TestPricingModelAliases(t *testing.T) {
+	svc, err := New(WithOffline(true), WithLogger(nil), WithStrictMatching(true),
+		WithAliases(map[string]string{" GLM-4.6-Claude ": "claude-sonnet-4-5", "loop": "loop", "": "gpt-5"}))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000}
+	official := svc.CalculateCost("claude-sonnet-4-5", usage).TotalCost
+	cost := svc.CalculateCost("glm-4.6-claude", usage)
+	if cost.Match.Strategy != MatchAlias || cost.Match.Key != "claude-sonnet-4-5" || cost.TotalCost != official {
+		t.Fatalf("别名应使用目标模型的价格: %+v", cost)
+	}
+	if match := svc.MatchModel("loop"); match.Found() {
+		t.Fatalf("指向自身的别名不应匹配: %+v", match)
+	}
+
+	svc.SetAliases(map[string]string{"kimi-for-coding": "eu.claude-haiku-4-5"})
+	if match := svc.MatchModel("Kimi-For-Coding"); match.Strategy != MatchAlias || match.Key != "claude-haiku-4-5" {
+		t.Fatalf("别名应不区分大小写并按前缀规则解析目标: %+v", match)
+	}
+	if match := svc.MatchModel("glm-4.6-claude"); match.Found() {
+		t.Fatalf("SetAliases 应替换原有的用户别名: %+v", match)
+	}
+	if aliases := svc.Aliases(); aliases["kimi-for-coding"] == "" || aliases["gpt-5-codex"] != "gpt-5" || len(aliases) != 2 {
+		t.Fatalf("生效的别名应包含内置别名与用户别名: %v", aliases)
+	}
+}
+
+func TestPricingExplainMatch(t *testing.T) {
+	svc, err := NewService()
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	explained := svc.ExplainMatch("us.anthropic.claude-sonnet-4-5-custom")
+	if match := svc.MatchModel("us.anthropic.claude-sonnet-4-5-custom"); explained.Match.Key != match.Key || explained.Match.Strategy != match.Strategy {
+		t.Fatalf("ExplainMatch 的结果应与 MatchModel 一致: %+v", explained.Match)
+	}
+	var strategies []string
+	for _, step := range explained.Steps {
+		strategies = append(strategies, step.Strategy)
+	}
+	if got := strings.Join(strategies, ","); got != "exact,prefix,prefix,normalized,fuzzy" {
+		t.Fatalf("匹配步骤错误: %s %+v", got, explained.Steps)
+	}
+	last := explained.Steps[len(explained.Steps)-1]
+	if !last.Matched || last.Key != explained.Match.Key || explained.Match.Strategy != MatchFuzzy {
+		t.Fatalf("最后一步应为模糊匹配的结果: %+v", explained)
+	}
+
+	// 别名的步骤包含目标名称的匹配过程
+	svc.SetAliases(map[string]string{"my-coder": "claude-haiku-4-5"})
+	aliased := svc.ExplainMatch("my-coder")
+	if aliased.Match.Key != "claude-haiku-4-5" || aliased.Match.Strategy != MatchAlias || len(aliased.Steps) != 3 ||
+		aliased.Steps[1].Strategy != MatchAlias || aliased.Steps[2].Name != "claude-haiku-4-5" || !aliased.Steps[2].Matched {
+		t.Fatalf("别名的匹配步骤错误: %+v", aliased)
+	}
+
+	if none := svc.ExplainMatch(""); none.Match.Found() || len(none.Steps) != 0 {
+		t.Fatalf("空模型名不应匹配: %+v", none)
+	}
+}
@@ -0,0 +1,95 @@
+package modelpricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OfflineEnv 为 true 时启用离线模式（WithOffline 优先），适用于无法访问外网的环境。
+const OfflineEnv = "CODE_SWITCH_PRICING_OFFLINE"
+
+// ErrOffline 表示离线模式下请求了远程拉取。
+var ErrOffline = errors.New("pricing service is offline")
+
+// WithOffline 启用或关闭离线模式：只使用内置数据与 WithOverridesFile 指定的本地覆盖，不读取缓存、不访问远程数据源，Start 不启动定时更新。
+// 未设置时由环境变量 CODE_SWITCH_PRICING_OFFLINE 决定。
+func WithOffline(offline bool) Option {
+	return func(o *serviceOptions) {
+		o.offline = &offline
+	}
+}
+
+// WithOverridesFile 指定本地价格覆盖文件（LiteLLM 格式），其中的字段覆盖同名模型的对应字段，未出现的模型会被新增。
+// 覆盖在每次加载或更新数据时重新读取，离线与在线模式均生效。
+func WithOverridesFile(path string) Option {
+	return func(o *serviceOptions) {
+		o.overridesFile = strings.TrimSpace(path)
+	}
+}
+
+// isOffline 返回是否处于离线模式。
+func (o serviceOptions) isOffline() bool {
+	if o.offline != nil {
+		return *o.offline
+	}
+	offline, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(OfflineEnv)))
+	return offline
+}
+
+// Offline 返回使用 Configure 全局选项的服务是否处于离线模式。
+func Offline() bool {
+	return currentOptions().isOffline()
+}
+
+// parseTable 解析价格数据，叠加本地覆盖并使用配置的兜底规则，覆盖文件无法读取时记录日志并忽略。
+// compiled 为 true 时读取并更新编译缓存（见 compiled.go），历史快照不使用编译缓存以免与当前数据互相覆盖。
+func (o serviceOptions) parseTable(data []byte, compiled bool) (*pricingTable, error) {
+	overrides, err := o.loadOverrides()
+	if err != nil {
+		o.logf("警告：忽略本地价格覆盖: %v", err)
+		overrides = nil
+	}
+	var raw map[string]PricingEntry
+	if compiled {
+		raw, err = o.compiledEntries(data)
+	} else {
+		raw, err = decodePricingData(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	table, err := buildPricingTable(raw, overrides, dataVersion(data))
+	if err != nil {
+		return nil, err
+	}
+	if o.fallbackRules != nil {
+		table.rules = o.fallbackRules
+	}
+	return table, nil
+}
+
+// loadOverrides 读取本地价格覆盖，未配置时返回 nil。
+func (o serviceOptions) loadOverrides() (map[string]json.RawMessage, error) {
+	if o.overridesFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(o.overridesFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取价格覆盖文件失败: %w", err)
+	}
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("解析价格覆盖文件 %s 失败: %w", o.overridesFile, err)
+	}
+	for key, override := range overrides {
+		var entry PricingEntry
+		if err := json.Unmarshal(override, &entry); err != nil {
+			return nil, fmt.Errorf("价格覆盖文件中模型 %s 的格式无效: %w", key, err)
+		}
+	}
+	return overrides, nil
+}
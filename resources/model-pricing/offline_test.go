@@ -0,0 +1,67 @@
+package modelpricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPricingOfflineMode(t *testing.T) {
+	var hits atomic.Int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, `{"m":{"input_cost_per_token":0.000001}}`)
+	}))
+	defer source.Close()
+	overrides := filepath.Join(t.TempDir(), "overrides.json")
+	if err := os.WriteFile(overrides, []byte(`{
+		"claude-sonnet-4-5": {"input_cost_per_token": 0.000001},
+		"corp-internal-model": {"input_cost_per_token": 0.000002, "output_cost_per_token": 0.000004}
+	}`), 0644); err != nil {
+		t.Fatalf("写入覆盖文件失败: %v", err)
+	}
+	opts := []Option{WithSourceURLs(source.URL), WithCacheDir(t.TempDir()),
+		WithLogger(nil), WithOverridesFile(overrides), WithUpdateInterval(time.Millisecond)}
+
+	svc, err := New(append(opts, WithOffline(true))...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	svc.Start()
+	time.Sleep(20 * time.Millisecond)
+	svc.Stop()
+	if err := svc.ForceRefresh(context.Background()); !errors.Is(err, ErrOffline) {
+		t.Fatalf("离线模式下 ForceRefresh 应返回 ErrOffline: %v", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("离线模式不应访问远程数据源，实际请求 %d 次", n)
+	}
+	if info := svc.Info(); !info.Offline || info.Stale || info.Source != SourceEmbedded {
+		t.Fatalf("离线模式信息错误: %+v", info)
+	}
+
+	usage := UsageSnapshot{InputTokens: 1000, OutputTokens: 1000}
+	sonnet := svc.CalculateCost("claude-sonnet-4-5", usage)
+	if math.Abs(sonnet.InputCost-0.001) > 1e-12 || math.Abs(sonnet.OutputCost-0.015) > 1e-12 {
+		t.Fatalf("覆盖应只替换输入价格: %+v", sonnet)
+	}
+	if got := svc.CalculateCost("corp-internal-model", usage).TotalCost; math.Abs(got-0.006) > 1e-12 {
+		t.Fatalf("覆盖文件应能新增模型: %v", got)
+	}
+
+	t.Setenv(OfflineEnv, "true")
+	if svc, _ := New(opts...); !svc.Info().Offline || hits.Load() != 0 {
+		t.Fatalf("环境变量应开启离线模式: %+v", svc.Info())
+	}
+	if svc, _ := New(append(opts, WithOffline(false))...); svc.Info().Source != SourceRemote {
+		t.Fatalf("WithOffline(false) 应优先于环境变量: %+v", svc.Info())
+	}
+}
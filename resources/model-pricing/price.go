@@ -4,8 +4,6 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,31 +17,37 @@ var pricingFile []byte
 const (
 	// 第三方价格数据源URL
 	remotePricingURL = "https://raw.githubusercontent.com/BerriAI/litellm/main/model_prices_and_context_window.json"
-	// 更新间隔：24小时
-	updateInterval = 24 * time.Hour
+	// 默认更新间隔：24小时
+	defaultUpdateInterval = 24 * time.Hour
 	// 本地缓存文件名
 	cacheFileName = "model_prices_and_context_window.json"
+	// 缓存子目录，避免与其他同样缓存 LiteLLM 价格文件的工具冲突
+	cacheSubDir = "code-switch"
+	// CacheDirEnv 指定价格缓存目录的环境变量（优先级低于 SetCacheDir）
+	CacheDirEnv = "CODE_SWITCH_PRICING_CACHE_DIR"
 )
 
 var (
-	defaultOnce    sync.Once
-	defaultService *Service
-	defaultErr     error
-	nameReplacer   = strings.NewReplacer("-", "", "_", "", ".", "", ":", "", "/", "", " ", "")
-	// 用于动态更新的互斥锁
-	updateMutex sync.RWMutex
-	// 最后更新时间
-	lastUpdateTime time.Time
-	// 更新定时器
-	updateTimer *time.Timer
+	nameReplacer = strings.NewReplacer("-", "", "_", "", ".", "", ":", "", "/", "", " ", "")
+	// 通过 SetCacheDir 指定的缓存目录
+	cacheDirMu       sync.RWMutex
+	cacheDirOverride string
 )
 
-// Service 提供模型价格相关的计算能力。
-type Service struct {
-	pricingMap   map[string]*PricingEntry
-	normalized   map[string]string
-	ephemeral1h  map[string]float64
-	longContexts map[string]LongContextPricing
+// pricingTable 是一份解析后的价格数据，创建后不再修改，更新时整体替换。
+type pricingTable struct {
+	pricingMap map[string]*PricingEntry
+	// 归一化的模型名索引，由 normalizedIndex 在首次使用时构建
+	normalizeOnce sync.Once
+	normalized    map[string]string
+	// 按名称排序并归一化的模型名（不含 sample_spec），用于模糊匹配
+	normalizedNames []normalizedName
+	// 匹配结果的缓存，见 lookupcache.go
+	lookups lookupCache
+	// 原始价格数据的摘要（不含本地覆盖），与 Service.Version 相同
+	version string
+	// 价格数据缺少 1 小时缓存与长上下文单价时使用的规则，见 rules.go
+	rules []FallbackRule
 }
 
 // PricingEntry 映射 JSON 内的字段。
@@ -54,9 +58,49 @@ type PricingEntry struct {
 	CacheCreationInputTokenCostAbove1Hr float64 `json:"cache_creation_input_token_cost_above_1hr"`
 	CacheCreationInputTokenCostAbove200 float64 `json:"cache_creation_input_token_cost_above_200k_tokens"`
 	CacheReadInputTokenCost             float64 `json:"cache_read_input_token_cost"`
+	CacheReadInputTokenCostAbove200k    float64 `json:"cache_read_input_token_cost_above_200k_tokens"`
 	InputCostPerTokenAbove200k          float64 `json:"input_cost_per_token_above_200k_tokens"`
 	InputCostPerTokenAbove128k          float64 `json:"input_cost_per_token_above_128k_tokens"`
 	OutputCostPerTokenAbove200k         float64 `json:"output_cost_per_token_above_200k_tokens"`
+	InputCostPerTokenBatches            float64 `json:"input_cost_per_token_batches"`
+	OutputCostPerTokenBatches           float64 `json:"output_cost_per_token_batches"`
+	InputCostPerImage                   float64 `json:"input_cost_per_image"`
+	OutputCostPerImage                  float64 `json:"output_cost_per_image"`
+	InputCostPerAudioToken              float64 `json:"input_cost_per_audio_token"`
+	OutputCostPerAudioToken             float64 `json:"output_cost_per_audio_token"`
+	InputCostPerTokenPriority           float64 `json:"input_cost_per_token_priority"`
+	OutputCostPerTokenPriority          float64 `json:"output_cost_per_token_priority"`
+	CacheReadInputTokenCostPriority     float64 `json:"cache_read_input_token_cost_priority"`
+	InputCostPerTokenFlex               float64 `json:"input_cost_per_token_flex"`
+	OutputCostPerTokenFlex              float64 `json:"output_cost_per_token_flex"`
+	CacheReadInputTokenCostFlex         float64 `json:"cache_read_input_token_cost_flex"`
+	// 服务端工具的按次费用：网页搜索每次查询、代码执行每个会话
+	SearchContextCostPerQuery     QueryCost `json:"search_context_cost_per_query"`
+	CodeInterpreterCostPerSession float64   `json:"code_interpreter_cost_per_session"`
+	// 按次计费的模型：重排序（rerank）与搜索按每次查询，部分模型每个请求另收固定费用
+	InputCostPerQuery   float64 `json:"input_cost_per_query"`
+	InputCostPerRequest float64 `json:"input_cost_per_request"`
+
+	LiteLLMProvider string     `json:"litellm_provider"`
+	Mode            string     `json:"mode"`
+	MaxInputTokens  TokenLimit `json:"max_input_tokens"`
+	MaxOutputTokens TokenLimit `json:"max_output_tokens"`
+	MaxTokens       TokenLimit `json:"max_tokens"`
+}
+
+// 服务等级，取自 OpenAI 的 service_tier 与 Anthropic 的 usage.service_tier，其余取值按标准价格计算。
+const (
+	ServiceTierPriority = "priority"
+	ServiceTierFlex     = "flex"
+)
+
+// NormalizeServiceTier 将上游返回的服务等级归一化，标准等级（default、standard、auto 等）返回空字符串。
+func NormalizeServiceTier(tier string) string {
+	switch tier = strings.ToLower(strings.TrimSpace(tier)); tier {
+	case ServiceTierPriority, ServiceTierFlex:
+		return tier
+	}
+	return ""
 }
 
 // UsageSnapshot 描述一次请求的 token 用量。
@@ -66,6 +110,25 @@ type UsageSnapshot struct {
 	CacheCreateTokens int
 	CacheReadTokens   int
 	CacheCreation     *CacheCreationDetail
+	// 音频 tokens 包含在 InputTokens / OutputTokens 中（与 OpenAI usage 一致），模型有音频单价时单独计费
+	InputAudioTokens  int
+	OutputAudioTokens int
+	// 输入/生成的图片张数，按 *_cost_per_image 计费（按 token 计费的图片已包含在 InputTokens 中）
+	InputImages  int
+	OutputImages int
+	// 服务等级（priority / flex），为空时按标准价格计算
+	ServiceTier string
+	// 服务端工具的调用次数（Anthropic 的 server_tool_use、OpenAI Responses 的 web_search_call / code_interpreter_call），按次计费
+	WebSearchCalls int
+	CodeExecutions int
+	// 重排序请求的查询次数（Cohere 的 search_units），按 input_cost_per_query 计费
+	Queries int
+}
+
+// hasUsage 判断请求是否产生了任何用量。
+func (u UsageSnapshot) hasUsage() bool {
+	return u.InputTokens+u.OutputTokens+u.CacheCreateTokens+u.CacheReadTokens+u.InputImages+u.OutputImages+
+		u.WebSearchCalls+u.CodeExecutions+u.Queries > 0
 }
 
 // CacheCreationDetail 细分缓存创建 tokens。
@@ -85,6 +148,91 @@ type CostBreakdown struct {
 	TotalCost       float64 `json:"total_cost"`
 	HasPricing      bool    `json:"has_pricing"`
 	IsLongContext   bool    `json:"is_long_context"`
+	// SurchargeCost 为 provider 按次收取的固定费用（已计入 TotalCost，不受倍率影响）
+	SurchargeCost float64 `json:"surcharge_cost"`
+	ImageCost     float64 `json:"image_cost"`
+	AudioCost     float64 `json:"audio_cost"`
+	// ToolCost 为网页搜索、代码执行等服务端工具的按次费用（不参与 Batch 与时段折扣）
+	ToolCost float64 `json:"tool_cost"`
+	// RequestCost 为重排序等模型按查询次数与按请求计的费用（不参与 Batch 与时段折扣）
+	RequestCost float64 `json:"request_cost"`
+	// BatchDiscount 为通过 Batch API 提交时相对实时价格节省的费用
+	BatchDiscount float64 `json:"batch_discount"`
+	// TimeOfDayDiscount 为按请求时段（如错峰优惠）相对全天价格节省的费用
+	TimeOfDayDiscount float64 `json:"time_of_day_discount"`
+	// ServiceTier 为计费使用的服务等级，ServiceTierCost 为相对标准价格的差额（flex 为负数）
+	ServiceTier     string  `json:"service_tier,omitempty"`
+	ServiceTierCost float64 `json:"service_tier_cost"`
+	// Match 描述模型名匹配到的价格条目，调用方可据此记录模糊匹配
+	Match ModelMatch `json:"match"`
+	// PricingVersion 为计算使用的价格数据版本，保存后可通过 CalculateCostAt 按同一版本重新计算
+	PricingVersion string `json:"pricing_version"`
+}
+
+// CostOption 调整 CalculateCost 的计算方式。
+type CostOption func(*costOptions)
+
+type costOptions struct {
+	multiplier float64
+	surcharge  float64
+	batch      bool
+	// 请求时间，用于按时段计价，见 timeofday.go
+	requestTime time.Time
+	// 计价时使用的模型名，key 为请求的模型名
+	modelAliases map[string]string
+	// 不计费，见 WithZeroCost
+	zeroCost bool
+}
+
+// defaultBatchDiscount 是 Anthropic / OpenAI Batch API 的折扣比例，价格数据没有 *_batches 单价时使用。
+const defaultBatchDiscount = 0.5
+
+// WithMarkup 按倍率调整官方价格，并为每次产生用量的请求附加固定费用（美元），
+// 用于通过转售商购买（如 0.8× 或 1.5× 官方价格）时的费用统计。
+// multiplier 不大于 0 时视为 1。
+func WithMarkup(multiplier float64, requestFee float64) CostOption {
+	return func(o *costOptions) {
+		if multiplier > 0 {
+			o.multiplier = multiplier
+		}
+		if requestFee > 0 {
+			o.surcharge = requestFee
+		}
+	}
+}
+
+// WithSurcharge 为每次产生用量的请求附加固定费用（美元），记录在 SurchargeCost 中。
+func WithSurcharge(fee float64) CostOption {
+	return func(o *costOptions) {
+		if fee > 0 {
+			o.surcharge = fee
+		}
+	}
+}
+
+// WithBatch 按 Batch API 价格计算（通常为实时价格的 50%），节省的费用记录在 BatchDiscount 中。
+func WithBatch() CostOption {
+	return func(o *costOptions) {
+		o.batch = true
+	}
+}
+
+// WithModelAliases 按指定的模型计价，用于名称不对应任何模型的部署名（如 Azure OpenAI 的 deployment -> gpt-4o）。
+// 与服务级的 WithAliases 不同，只影响使用该选项的调用，可以为每个 provider 单独设置。
+func WithModelAliases(aliases map[string]string) CostOption {
+	return func(o *costOptions) {
+		if len(aliases) > 0 {
+			o.modelAliases = aliases
+		}
+	}
+}
+
+// WithZeroCost 将费用记为 0，用于本地推理服务（Ollama、LM Studio 等）上的模型。
+// 结果的 HasPricing 为 true，不会被当作缺少价格的模型，也不收取按次附加费。
+func WithZeroCost() CostOption {
+	return func(o *costOptions) {
+		o.zeroCost = true
+	}
 }
 
 // LongContextPricing 描述 1M 上下文模型的单价。
@@ -93,170 +241,365 @@ type LongContextPricing struct {
 	Output float64
 }
 
-// DefaultService 返回单例，支持动态更新。
-func DefaultService() (*Service, error) {
-	defaultOnce.Do(func() {
-		defaultService, defaultErr = NewServiceWithDynamicUpdate()
-		if defaultErr == nil {
-			// 启动定时更新
-			startPeriodicUpdate()
-		}
-	})
-	updateMutex.RLock()
-	defer updateMutex.RUnlock()
-	return defaultService, defaultErr
-}
-
 // NewService 从嵌入的 JSON 创建服务实例。
 func NewService() (*Service, error) {
-	return NewServiceFromData(pricingFile)
+	svc, err := NewServiceFromData(pricingFile)
+	if err != nil {
+		return nil, err
+	}
+	svc.source = SourceEmbedded
+	return svc, nil
 }
 
-// NewServiceWithDynamicUpdate 创建支持动态更新的服务实例。
-func NewServiceWithDynamicUpdate() (*Service, error) {
-	// 尝试从缓存加载
-	data, err := loadFromCache()
+// NewServiceFromData 从指定的 JSON 数据创建服务实例，使用默认选项（调用 Start 后同样会定时更新）。
+func NewServiceFromData(data []byte) (*Service, error) {
+	table, err := parsePricingTable(data, nil)
 	if err != nil {
-		// 缓存失败，尝试从远程拉取
-		data, err = fetchRemotePricing()
-		if err != nil {
-			// 远程拉取失败，使用嵌入的数据
-			fmt.Printf("警告：无法获取最新价格数据，使用内置数据: %v\n", err)
-			data = pricingFile
-		} else {
-			// 远程拉取成功，保存到缓存
-			if saveErr := saveToCache(data); saveErr != nil {
-				fmt.Printf("警告：保存价格数据到缓存失败: %v\n", saveErr)
-			}
-			lastUpdateTime = time.Now()
-		}
+		return nil, err
 	}
+	return &Service{options: defaultServiceOptions(), table: table, version: table.version, source: SourceProvided}, nil
+}
 
-	return NewServiceFromData(data)
+// parsePricingTable 解析 LiteLLM 格式的价格数据，overrides 中的字段覆盖同名模型的对应字段（可新增模型）。
+func parsePricingTable(data []byte, overrides map[string]json.RawMessage) (*pricingTable, error) {
+	raw, err := decodePricingData(data)
+	if err != nil {
+		return nil, err
+	}
+	return buildPricingTable(raw, overrides, dataVersion(data))
 }
 
-// NewServiceFromData 从指定的 JSON 数据创建服务实例。
-func NewServiceFromData(data []byte) (*Service, error) {
-	raw := make(map[string]PricingEntry)
-	if err := json.Unmarshal(data, &raw); err != nil {
-		return nil, fmt.Errorf("解析价格数据失败: %w", err)
+// buildPricingTable 由解码后的条目创建价格表，会修改 raw。
+func buildPricingTable(raw map[string]PricingEntry, overrides map[string]json.RawMessage, version string) (*pricingTable, error) {
+	for key, override := range overrides {
+		entry := raw[key]
+		if err := json.Unmarshal(override, &entry); err != nil {
+			return nil, fmt.Errorf("解析模型 %s 的价格覆盖失败: %w", key, err)
+		}
+		raw[key] = entry
 	}
 	pricing := make(map[string]*PricingEntry, len(raw))
-	normalized := make(map[string]string, len(raw))
+	items := make([]PricingEntry, 0, len(raw))
 	for key, entry := range raw {
-		item := entry
-		ensureCachePricing(&item)
-		pricing[key] = &item
-		norm := normalizeName(key)
-		if _, exists := normalized[norm]; !exists {
-			normalized[norm] = key
-		}
-	}
-	return &Service{
-		pricingMap:   pricing,
-		normalized:   normalized,
-		ephemeral1h:  buildEphemeral1hPricing(),
-		longContexts: buildLongContextPricing(),
+		ensureCachePricing(&entry)
+		items = append(items, entry)
+		pricing[key] = &items[len(items)-1]
+	}
+	return &pricingTable{
+		pricingMap: pricing,
+		version:    version,
+		rules:      defaultFallbackRules,
 	}, nil
 }
 
 // CalculateCost 根据模型与 token 用量返回费用明细（美元）。
-func (s *Service) CalculateCost(model string, usage UsageSnapshot) CostBreakdown {
+func (s *Service) CalculateCost(model string, usage UsageSnapshot, opts ...CostOption) CostBreakdown {
 	if s == nil || model == "" {
 		return CostBreakdown{}
 	}
-	entry, hasPricing := s.getPricing(model)
-	breakdown := CostBreakdown{HasPricing: hasPricing}
+	return s.calculate(s.current(), model, usage, opts...)
+}
+
+// EstimateCost 在发送请求前按提示词与预计输出的 token 数估算费用（美元），不含缓存命中带来的折扣，结果偏保守。
+// 负数的 token 数视为 0。
+func (s *Service) EstimateCost(model string, promptTokens int, expectedOutputTokens int, opts ...CostOption) CostBreakdown {
+	return s.CalculateCost(model, UsageSnapshot{InputTokens: max(promptTokens, 0), OutputTokens: max(expectedOutputTokens, 0)}, opts...)
+}
+
+// calculate 按指定的价格数据计算费用。
+func (s *Service) calculate(table *pricingTable, model string, usage UsageSnapshot, opts ...CostOption) CostBreakdown {
+	options := costOptions{multiplier: 1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if target, ok := options.modelAliases[model]; ok && target != "" {
+		model = target
+	}
+	if options.zeroCost {
+		return CostBreakdown{HasPricing: true, Match: ModelMatch{Strategy: MatchNone}, PricingVersion: table.version}
+	}
+	entry, match := table.resolve(model, s.matchOptions())
+	breakdown := table.baseCost(model, entry, usage)
+	breakdown.Match = match
+	breakdown.PricingVersion = table.version
+	if options.batch {
+		applyBatchDiscount(entry, &breakdown)
+	}
+	s.options.applyTimeOfDay(model, entry, options.requestTime, &breakdown)
+	if options.multiplier != 1 {
+		breakdown.InputCost *= options.multiplier
+		breakdown.OutputCost *= options.multiplier
+		breakdown.CacheCreateCost *= options.multiplier
+		breakdown.CacheReadCost *= options.multiplier
+		breakdown.Ephemeral5mCost *= options.multiplier
+		breakdown.Ephemeral1hCost *= options.multiplier
+		breakdown.ImageCost *= options.multiplier
+		breakdown.AudioCost *= options.multiplier
+		breakdown.ToolCost *= options.multiplier
+		breakdown.RequestCost *= options.multiplier
+		breakdown.TotalCost *= options.multiplier
+		breakdown.BatchDiscount *= options.multiplier
+		breakdown.TimeOfDayDiscount *= options.multiplier
+		breakdown.ServiceTierCost *= options.multiplier
+	}
+	// 没有产生用量的请求（如上游报错）不收取按次费用
+	if options.surcharge > 0 && usage.hasUsage() {
+		breakdown.SurchargeCost = options.surcharge
+		breakdown.TotalCost += options.surcharge
+	}
+	return breakdown
+}
+
+// applyBatchDiscount 将实时价格换算为 Batch API 价格。
+// 价格数据提供 *_batches 单价时按其与实时单价的比例折算（缓存费用沿用输入的比例），否则统一按 50% 计算。
+func applyBatchDiscount(entry *PricingEntry, breakdown *CostBreakdown) {
+	inputRatio, outputRatio := defaultBatchDiscount, defaultBatchDiscount
+	if entry != nil {
+		if entry.InputCostPerTokenBatches > 0 && entry.InputCostPerToken > 0 {
+			inputRatio = entry.InputCostPerTokenBatches / entry.InputCostPerToken
+		}
+		if entry.OutputCostPerTokenBatches > 0 && entry.OutputCostPerToken > 0 {
+			outputRatio = entry.OutputCostPerTokenBatches / entry.OutputCostPerToken
+		}
+	}
+	breakdown.BatchDiscount = scaleCost(breakdown, inputRatio, outputRatio)
+}
+
+// scaleCost 按输入（含缓存、图片、音频）与输出的比例调整费用，返回减少的费用。
+func scaleCost(breakdown *CostBreakdown, inputRatio float64, outputRatio float64) float64 {
+	before := breakdown.TotalCost
+	breakdown.InputCost *= inputRatio
+	breakdown.OutputCost *= outputRatio
+	breakdown.CacheCreateCost *= inputRatio
+	breakdown.CacheReadCost *= inputRatio
+	breakdown.Ephemeral5mCost *= inputRatio
+	breakdown.Ephemeral1hCost *= inputRatio
+	breakdown.ImageCost *= inputRatio
+	breakdown.AudioCost *= inputRatio
+	breakdown.ServiceTierCost *= inputRatio
+	breakdown.TotalCost = breakdown.sum()
+	return before - breakdown.TotalCost
+}
+
+// baseCost 按官方价格计算费用，entry 为模型匹配到的价格条目（未匹配时为 nil）。
+func (t *pricingTable) baseCost(model string, entry *PricingEntry, usage UsageSnapshot) CostBreakdown {
+	breakdown := CostBreakdown{HasPricing: entry != nil}
 	if entry == nil && !strings.Contains(strings.ToLower(model), "[1m]") {
 		return breakdown
 	}
-	longTier, useLong := s.longContextTier(model, usage)
 	if entry == nil {
 		entry = &PricingEntry{}
 	}
+	longTier, useLong := t.longContextTier(model, entry, usage)
+	usage, breakdown.ImageCost, breakdown.AudioCost = multimodalCost(entry, usage)
+	cacheCreateTokens, cache1hTokens := resolveCacheTokens(usage)
+	cache5mCost := float64(cacheCreateTokens) * entry.CacheCreationInputTokenCost
+	cache1hCost := float64(cache1hTokens) * t.ephemeral1hPrice(model, entry)
+	breakdown.CacheReadCost = float64(usage.CacheReadTokens) * entry.CacheReadInputTokenCost
 	if useLong {
 		breakdown.IsLongContext = true
 		breakdown.InputCost = float64(usage.InputTokens) * longTier.Input
 		breakdown.OutputCost = float64(usage.OutputTokens) * longTier.Output
+	} else if tiered, ok := tieredCost(entry, usage, cacheCreateTokens, cache1hTokens); ok {
+		breakdown.IsLongContext = true
+		breakdown.InputCost = tiered.InputCost
+		breakdown.OutputCost = tiered.OutputCost
+		breakdown.CacheReadCost = tiered.CacheReadCost
+		cache5mCost = tiered.Ephemeral5mCost
 	} else {
 		breakdown.InputCost = float64(usage.InputTokens) * entry.InputCostPerToken
 		breakdown.OutputCost = float64(usage.OutputTokens) * entry.OutputCostPerToken
 	}
-	cacheCreateTokens, cache1hTokens := resolveCacheTokens(usage)
-	cache5mCost := float64(cacheCreateTokens) * entry.CacheCreationInputTokenCost
-	cache1hCost := float64(cache1hTokens) * s.getEphemeral1hPricing(model)
 	breakdown.Ephemeral5mCost = cache5mCost
 	breakdown.Ephemeral1hCost = cache1hCost
 	breakdown.CacheCreateCost = cache5mCost + cache1hCost
-	breakdown.CacheReadCost = float64(usage.CacheReadTokens) * entry.CacheReadInputTokenCost
-	breakdown.TotalCost = breakdown.InputCost + breakdown.OutputCost + breakdown.CacheCreateCost + breakdown.CacheReadCost
+	breakdown.TotalCost = breakdown.sum()
+	applyServiceTier(entry, usage.ServiceTier, &breakdown)
+	breakdown.ToolCost = float64(usage.WebSearchCalls)*t.webSearchPrice(model, entry) +
+		float64(usage.CodeExecutions)*t.codeExecutionPrice(model, entry)
+	breakdown.TotalCost += breakdown.ToolCost
+	if usage.hasUsage() {
+		breakdown.RequestCost = float64(usage.Queries)*entry.InputCostPerQuery + entry.InputCostPerRequest
+		breakdown.TotalCost += breakdown.RequestCost
+	}
 	if breakdown.TotalCost > 0 {
 		breakdown.HasPricing = true
 	}
 	return breakdown
 }
 
-func (s *Service) getPricing(model string) (*PricingEntry, bool) {
-	if model == "" {
-		return nil, false
-	}
-	if entry, ok := s.pricingMap[model]; ok {
-		return entry, true
-	}
-	if model == "gpt-5-codex" {
-		if entry, ok := s.pricingMap["gpt-5"]; ok {
-			return entry, true
+// sum 返回各项费用之和（不含按次附加费）。
+func (b *CostBreakdown) sum() float64 {
+	return b.InputCost + b.OutputCost + b.CacheCreateCost + b.CacheReadCost + b.ImageCost + b.AudioCost + b.ToolCost + b.RequestCost
+}
+
+// QueryCost 是网页搜索的每次查询费用。LiteLLM 按搜索上下文大小分别定价（search_context_size_low/medium/high），
+// 取默认的 medium，也接受单个数字。
+type QueryCost float64
+
+func (q *QueryCost) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		*q = QueryCost(value)
+		return nil
+	}
+	var sizes map[string]float64
+	if err := json.Unmarshal(data, &sizes); err != nil {
+		*q = 0
+		return nil
+	}
+	for _, size := range []string{"search_context_size_medium", "search_context_size_low", "search_context_size_high"} {
+		if price, ok := sizes[size]; ok {
+			*q = QueryCost(price)
+			return nil
 		}
 	}
-	withoutRegion := stripRegionPrefix(model)
-	if entry, ok := s.pricingMap[withoutRegion]; ok {
-		return entry, true
-	}
-	withoutProvider := strings.TrimPrefix(withoutRegion, "anthropic.")
-	if entry, ok := s.pricingMap[withoutProvider]; ok {
-		return entry, true
-	}
-	normalizedTarget := normalizeName(model)
-	if key, ok := s.normalized[normalizedTarget]; ok {
-		return s.pricingMap[key], true
+	*q = 0
+	return nil
+}
+
+// multimodalCost 计算图片与音频费用，返回扣除已按音频单价计费部分后的文本用量。
+// 模型没有音频单价时，音频 tokens 仍按文本单价计费。
+func multimodalCost(entry *PricingEntry, usage UsageSnapshot) (UsageSnapshot, float64, float64) {
+	imageCost := float64(usage.InputImages)*entry.InputCostPerImage + float64(usage.OutputImages)*entry.OutputCostPerImage
+	audioCost := 0.0
+	if entry.InputCostPerAudioToken > 0 && usage.InputAudioTokens > 0 {
+		audio := min(usage.InputAudioTokens, usage.InputTokens)
+		audioCost += float64(audio) * entry.InputCostPerAudioToken
+		usage.InputTokens -= audio
+	}
+	if entry.OutputCostPerAudioToken > 0 && usage.OutputAudioTokens > 0 {
+		audio := min(usage.OutputAudioTokens, usage.OutputTokens)
+		audioCost += float64(audio) * entry.OutputCostPerAudioToken
+		usage.OutputTokens -= audio
+	}
+	return usage, imageCost, audioCost
+}
+
+// applyServiceTier 按服务等级单价与标准单价的比例调整 token 费用（缓存创建沿用输入的比例，缺少缓存读取单价时也沿用输入的比例）。
+// 价格数据没有该等级的单价时（如 Anthropic 的 priority tier 需单独签约）仍记录等级，但按标准价格计算。
+func applyServiceTier(entry *PricingEntry, tier string, breakdown *CostBreakdown) {
+	tier = NormalizeServiceTier(tier)
+	if tier == "" {
+		return
 	}
-	for key, entry := range s.pricingMap {
-		normKey := normalizeName(key)
-		if strings.Contains(normKey, normalizedTarget) || strings.Contains(normalizedTarget, normKey) {
-			return entry, true
-		}
+	breakdown.ServiceTier = tier
+	input, output, cacheRead := entry.InputCostPerTokenPriority, entry.OutputCostPerTokenPriority, entry.CacheReadInputTokenCostPriority
+	if tier == ServiceTierFlex {
+		input, output, cacheRead = entry.InputCostPerTokenFlex, entry.OutputCostPerTokenFlex, entry.CacheReadInputTokenCostFlex
+	}
+	inputRatio := priceRatio(input, entry.InputCostPerToken)
+	outputRatio := priceRatio(output, entry.OutputCostPerToken)
+	cacheReadRatio := priceRatio(cacheRead, entry.CacheReadInputTokenCost)
+	if cacheRead <= 0 {
+		cacheReadRatio = inputRatio
+	}
+	before := breakdown.TotalCost
+	breakdown.InputCost *= inputRatio
+	breakdown.OutputCost *= outputRatio
+	breakdown.CacheReadCost *= cacheReadRatio
+	breakdown.Ephemeral5mCost *= inputRatio
+	breakdown.Ephemeral1hCost *= inputRatio
+	breakdown.CacheCreateCost = breakdown.Ephemeral5mCost + breakdown.Ephemeral1hCost
+	breakdown.TotalCost = breakdown.sum()
+	breakdown.ServiceTierCost = breakdown.TotalCost - before
+}
+
+// priceRatio 返回等级单价相对标准单价的比例，任一单价缺失时为 1。
+func priceRatio(tierRate float64, standard float64) float64 {
+	if tierRate <= 0 || standard <= 0 {
+		return 1
 	}
-	return nil, false
+	return tierRate / standard
 }
 
-func (s *Service) longContextTier(model string, usage UsageSnapshot) (LongContextPricing, bool) {
-	totalInput := usage.InputTokens + usage.CacheCreateTokens + usage.CacheReadTokens
-	if strings.Contains(strings.ToLower(model), "[1m]") && totalInput > 200000 && len(s.longContexts) > 0 {
-		if tier, ok := s.longContexts[model]; ok {
-			return tier, true
-		}
-		for _, tier := range s.longContexts {
-			return tier, true
+// 分档计价的阈值（按提示词 tokens 计，包括缓存读取与缓存创建）
+const (
+	tierThreshold128k = 128000
+	tierThreshold200k = 200000
+)
+
+// priceTier 表示提示词超过 threshold 的部分按 rate 计价。
+type priceTier struct {
+	threshold int
+	rate      float64
+}
+
+// tieredCost 按 *_above_128k_tokens / *_above_200k_tokens 分档价格计算费用。
+// 提示词内 token 的顺序视为：缓存读取、缓存创建、普通输入，各部分仅超过阈值的 tokens 按高档价格计费；
+// 输出没有位置，提示词超过 200k 时整体按高档价格计费。
+// 条目没有分档价格或提示词未超过阈值时返回 false。
+func tieredCost(entry *PricingEntry, usage UsageSnapshot, cache5mTokens int, cache1hTokens int) (CostBreakdown, bool) {
+	promptTokens := usage.InputTokens + usage.CacheReadTokens + cache5mTokens + cache1hTokens
+	lowest := tierThreshold200k
+	if entry.InputCostPerTokenAbove128k > 0 {
+		lowest = tierThreshold128k
+	}
+	if promptTokens <= lowest {
+		return CostBreakdown{}, false
+	}
+	inputTiers := []priceTier{
+		{threshold: tierThreshold128k, rate: entry.InputCostPerTokenAbove128k},
+		{threshold: tierThreshold200k, rate: entry.InputCostPerTokenAbove200k},
+	}
+	cacheCreateTiers := []priceTier{{threshold: tierThreshold200k, rate: entry.CacheCreationInputTokenCostAbove200}}
+	cacheReadTiers := []priceTier{{threshold: tierThreshold200k, rate: entry.CacheReadInputTokenCostAbove200k}}
+	if !hasTierRate(inputTiers) && !hasTierRate(cacheCreateTiers) && !hasTierRate(cacheReadTiers) && entry.OutputCostPerTokenAbove200k <= 0 {
+		return CostBreakdown{}, false
+	}
+
+	var breakdown CostBreakdown
+	offset := 0
+	breakdown.CacheReadCost = tierSpanCost(offset, usage.CacheReadTokens, entry.CacheReadInputTokenCost, cacheReadTiers)
+	offset += usage.CacheReadTokens
+	// 1 小时缓存单独计价，这里只跳过其位置
+	offset += cache1hTokens
+	breakdown.Ephemeral5mCost = tierSpanCost(offset, cache5mTokens, entry.CacheCreationInputTokenCost, cacheCreateTiers)
+	offset += cache5mTokens
+	breakdown.InputCost = tierSpanCost(offset, usage.InputTokens, entry.InputCostPerToken, inputTiers)
+	outputRate := entry.OutputCostPerToken
+	if promptTokens > tierThreshold200k && entry.OutputCostPerTokenAbove200k > 0 {
+		outputRate = entry.OutputCostPerTokenAbove200k
+	}
+	breakdown.OutputCost = float64(usage.OutputTokens) * outputRate
+	return breakdown, true
+}
+
+func hasTierRate(tiers []priceTier) bool {
+	for _, tier := range tiers {
+		if tier.rate > 0 {
+			return true
 		}
 	}
-	return LongContextPricing{}, false
+	return false
 }
 
-func (s *Service) getEphemeral1hPricing(model string) float64 {
-	if price, ok := s.ephemeral1h[model]; ok {
-		return price
-	}
-	name := strings.ToLower(model)
-	switch {
-	case strings.Contains(name, "opus"):
-		return 0.00003
-	case strings.Contains(name, "sonnet"):
-		return 0.000006
-	case strings.Contains(name, "haiku"):
-		return 0.0000016
-	default:
+// tierSpanCost 计算提示词中 [offset, offset+tokens) 区间的费用，tiers 按阈值升序排列，rate 为 0 的档位沿用上一档价格。
+func tierSpanCost(offset int, tokens int, base float64, tiers []priceTier) float64 {
+	if tokens <= 0 {
 		return 0
 	}
+	end := offset + tokens
+	cost := 0.0
+	start, rate := offset, base
+	for _, tier := range tiers {
+		if tier.rate <= 0 {
+			continue
+		}
+		if tier.threshold > start {
+			cut := tier.threshold
+			if cut > end {
+				cut = end
+			}
+			cost += float64(cut-start) * rate
+			start = cut
+		}
+		rate = tier.rate
+		if start >= end {
+			return cost
+		}
+	}
+	return cost + float64(end-start)*rate
 }
 
 func ensureCachePricing(entry *PricingEntry) {
@@ -272,7 +615,7 @@ func ensureCachePricing(entry *PricingEntry) {
 }
 
 func stripRegionPrefix(name string) string {
-	for _, prefix := range []string{"us.", "eu.", "apac."} {
+	for _, prefix := range []string{"us.", "eu.", "apac.", "global.", "jp.", "au.", "ca.", "us-gov."} {
 		if strings.HasPrefix(strings.ToLower(name), prefix) {
 			return name[len(prefix):]
 		}
@@ -280,6 +623,44 @@ func stripRegionPrefix(name string) string {
 	return name
 }
 
+// bedrockModelID 取 Bedrock 推理配置文件或基础模型 ARN 中的模型 ID，
+// 如 arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-sonnet-4-5-20250929-v1:0
+func bedrockModelID(name string) string {
+	if !strings.HasPrefix(name, "arn:") {
+		return name
+	}
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		return name[index+1:]
+	}
+	return name
+}
+
+// stripBedrockVersion 去掉 Bedrock 模型 ID 的版本后缀，如 claude-opus-4-5-20251101-v1:0 -> claude-opus-4-5-20251101
+func stripBedrockVersion(name string) string {
+	index := strings.LastIndex(name, "-v")
+	if index < 0 {
+		return name
+	}
+	version := name[index+2:]
+	major, minor, found := strings.Cut(version, ":")
+	if !found || !isDigits(major) || !isDigits(minor) {
+		return name
+	}
+	return name[:index]
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func normalizeName(name string) string {
 	return nameReplacer.Replace(strings.ToLower(name))
 }
@@ -303,211 +684,33 @@ func resolveCacheTokens(usage UsageSnapshot) (fiveMin int, oneHour int) {
 	return five, one
 }
 
-func buildEphemeral1hPricing() map[string]float64 {
-	return map[string]float64{
-		"claude-opus-4-1":            0.00003,
-		"claude-opus-4-1-20250805":   0.00003,
-		"claude-opus-4":              0.00003,
-		"claude-opus-4-20250514":     0.00003,
-		"claude-opus-4-5-20251101":   0.00003, // 新增 opus 4.5 支持
-		"claude-3-opus":              0.00003,
-		"claude-3-opus-latest":       0.00003,
-		"claude-3-opus-20240229":     0.00003,
-		"claude-3-5-sonnet":          0.000006,
-		"claude-3-5-sonnet-latest":   0.000006,
-		"claude-3-5-sonnet-20241022": 0.000006,
-		"claude-3-5-sonnet-20240620": 0.000006,
-		"claude-3-sonnet":            0.000006,
-		"claude-3-sonnet-20240307":   0.000006,
-		"claude-sonnet-3":            0.000006,
-		"claude-sonnet-3-5":          0.000006,
-		"claude-sonnet-3-7":          0.000006,
-		"claude-sonnet-4":            0.000006,
-		"claude-sonnet-4-20250514":   0.000006,
-		"claude-3-5-haiku":           0.0000016,
-		"claude-3-5-haiku-latest":    0.0000016,
-		"claude-3-5-haiku-20241022":  0.0000016,
-		"claude-3-haiku":             0.0000016,
-		"claude-3-haiku-20240307":    0.0000016,
-		"claude-haiku-3":             0.0000016,
-		"claude-haiku-3-5":           0.0000016,
-	}
-}
-
-func buildLongContextPricing() map[string]LongContextPricing {
-	return map[string]LongContextPricing{
-		"claude-sonnet-4-20250514[1m]": {
-			Input:  0.000006,
-			Output: 0.0000225,
-		},
-	}
-}
-
-// 动态更新相关函数
-
-// fetchRemotePricing 从远程URL获取价格数据。
-func fetchRemotePricing() ([]byte, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	resp, err := client.Get(remotePricingURL)
-	if err != nil {
-		return nil, fmt.Errorf("请求远程价格数据失败: %w", err)
-	}
-	defer resp.Body.Close()
+// SetCacheDir 指定价格数据的缓存目录，传入空字符串恢复默认规则。
+// 需在首次调用 DefaultService 之前设置才会影响启动时的缓存读取。
+func SetCacheDir(dir string) {
+	cacheDirMu.Lock()
+	defer cacheDirMu.Unlock()
+	cacheDirOverride = strings.TrimSpace(dir)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("远程服务器返回错误状态: %d", resp.StatusCode)
+// CacheDir 返回价格数据的缓存目录，优先级：
+// SetCacheDir > $CODE_SWITCH_PRICING_CACHE_DIR > $XDG_CACHE_HOME/code-switch > ~/.cache/code-switch
+func CacheDir() (string, error) {
+	cacheDirMu.RLock()
+	dir := cacheDirOverride
+	cacheDirMu.RUnlock()
+	if dir != "" {
+		return dir, nil
 	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取响应数据失败: %w", err)
+	if dir = strings.TrimSpace(os.Getenv(CacheDirEnv)); dir != "" {
+		return dir, nil
 	}
-
-	// 验证JSON格式
-	var test map[string]interface{}
-	if err := json.Unmarshal(data, &test); err != nil {
-		return nil, fmt.Errorf("远程数据格式无效: %w", err)
+	// XDG 规范要求忽略相对路径
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" && filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, cacheSubDir), nil
 	}
-
-	return data, nil
-}
-
-// getCacheFilePath 获取缓存文件的完整路径。
-func getCacheFilePath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("获取用户主目录失败: %w", err)
 	}
-
-	cacheDir := filepath.Join(homeDir, ".cache")
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return "", fmt.Errorf("创建缓存目录失败: %w", err)
-	}
-
-	return filepath.Join(cacheDir, cacheFileName), nil
-}
-
-// saveToCache 将价格数据保存到本地缓存。
-func saveToCache(data []byte) error {
-	cachePath, err := getCacheFilePath()
-	if err != nil {
-		return err
-	}
-
-	// 创建带时间戳的缓存数据
-	cacheData := map[string]interface{}{
-		"timestamp": time.Now().Unix(),
-		"data":      json.RawMessage(data),
-	}
-
-	cacheBytes, err := json.Marshal(cacheData)
-	if err != nil {
-		return fmt.Errorf("序列化缓存数据失败: %w", err)
-	}
-
-	if err := os.WriteFile(cachePath, cacheBytes, 0644); err != nil {
-		return fmt.Errorf("写入缓存文件失败: %w", err)
-	}
-
-	return nil
-}
-
-// loadFromCache 从本地缓存加载价格数据。
-func loadFromCache() ([]byte, error) {
-	cachePath, err := getCacheFilePath()
-	if err != nil {
-		return nil, err
-	}
-
-	// 检查文件是否存在
-	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("缓存文件不存在")
-	}
-
-	cacheBytes, err := os.ReadFile(cachePath)
-	if err != nil {
-		return nil, fmt.Errorf("读取缓存文件失败: %w", err)
-	}
-
-	var cacheData struct {
-		Timestamp int64           `json:"timestamp"`
-		Data      json.RawMessage `json:"data"`
-	}
-
-	if err := json.Unmarshal(cacheBytes, &cacheData); err != nil {
-		return nil, fmt.Errorf("解析缓存数据失败: %w", err)
-	}
-
-	// 检查缓存是否过期（超过24小时）
-	cacheTime := time.Unix(cacheData.Timestamp, 0)
-	if time.Since(cacheTime) > updateInterval {
-		return nil, fmt.Errorf("缓存已过期")
-	}
-
-	lastUpdateTime = cacheTime
-	return cacheData.Data, nil
-}
-
-// startPeriodicUpdate 启动定时更新机制。
-func startPeriodicUpdate() {
-	// 计算下次更新时间
-	nextUpdate := updateInterval
-	if !lastUpdateTime.IsZero() {
-		elapsed := time.Since(lastUpdateTime)
-		if elapsed < updateInterval {
-			nextUpdate = updateInterval - elapsed
-		} else {
-			nextUpdate = time.Minute // 立即更新
-		}
-	}
-
-	updateTimer = time.AfterFunc(nextUpdate, func() {
-		updatePricingData()
-		// 设置下一次更新
-		updateTimer = time.AfterFunc(updateInterval, func() {
-			updatePricingData()
-		})
-	})
-}
-
-// updatePricingData 更新价格数据。
-func updatePricingData() {
-	fmt.Println("开始更新模型价格数据...")
-
-	data, err := fetchRemotePricing()
-	if err != nil {
-		fmt.Printf("更新价格数据失败: %v\n", err)
-		return
-	}
-
-	// 创建新的服务实例
-	newService, err := NewServiceFromData(data)
-	if err != nil {
-		fmt.Printf("创建新服务实例失败: %v\n", err)
-		return
-	}
-
-	// 原子性更新
-	updateMutex.Lock()
-	defaultService = newService
-	lastUpdateTime = time.Now()
-	updateMutex.Unlock()
-
-	// 保存到缓存
-	if err := saveToCache(data); err != nil {
-		fmt.Printf("保存价格数据到缓存失败: %v\n", err)
-	}
-
-	fmt.Println("模型价格数据更新完成")
-}
-
-// StopPeriodicUpdate 停止定时更新（用于测试或优雅关闭）。
-func StopPeriodicUpdate() {
-	if updateTimer != nil {
-		updateTimer.Stop()
-		updateTimer = nil
-	}
+	return filepath.Join(homeDir, ".cache", cacheSubDir), nil
 }
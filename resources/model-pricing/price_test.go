@@ -0,0 +1,258 @@
+package modelpricing
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCalculateCostTieredPricing(t *testing.T) {
+	pricing, err := NewServiceFromData([]byte(`{
+		"gemini-test":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000004,
+			"input_cost_per_token_above_128k_tokens":0.000002,"input_cost_per_token_above_200k_tokens":0.000003,
+			"output_cost_per_token_above_200k_tokens":0.000008},
+		"claude-test":{"input_cost_per_token":0.000003,"output_cost_per_token":0.000015,
+			"cache_read_input_token_cost":0.0000003,"cache_read_input_token_cost_above_200k_tokens":0.0000006,
+			"input_cost_per_token_above_200k_tokens":0.000006,"output_cost_per_token_above_200k_tokens":0.0000225}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+
+	short := pricing.CalculateCost("gemini-test", UsageSnapshot{InputTokens: 100000, OutputTokens: 1000})
+	if short.IsLongContext || math.Abs(short.InputCost-0.1) > 1e-9 || math.Abs(short.OutputCost-0.004) > 1e-9 {
+		t.Fatalf("未超过阈值时应按基础价格计费: %+v", short)
+	}
+
+	// 128k 以内、128k-200k、200k 以上三段分别计价
+	long := pricing.CalculateCost("gemini-test", UsageSnapshot{InputTokens: 250000, OutputTokens: 1000})
+	wantInput := 128000*0.000001 + 72000*0.000002 + 50000*0.000003
+	if !long.IsLongContext || math.Abs(long.InputCost-wantInput) > 1e-9 || math.Abs(long.OutputCost-0.008) > 1e-9 {
+		t.Fatalf("分档计费错误: %+v，期望输入费用 %v", long, wantInput)
+	}
+
+	// 缓存读取位于提示词开头，超过 200k 后的普通输入才按高档计价
+	cached := pricing.CalculateCost("claude-test", UsageSnapshot{InputTokens: 50000, CacheReadTokens: 180000})
+	wantRead := 180000 * 0.0000003
+	wantInput = 20000*0.000003 + 30000*0.000006
+	if math.Abs(cached.CacheReadCost-wantRead) > 1e-9 || math.Abs(cached.InputCost-wantInput) > 1e-9 {
+		t.Fatalf("缓存分档计费错误: %+v", cached)
+	}
+	if math.Abs(cached.TotalCost-(wantRead+wantInput)) > 1e-9 {
+		t.Fatalf("TotalCost = %v，期望 %v", cached.TotalCost, wantRead+wantInput)
+	}
+}
+
+func TestCalculateCostBatchDiscount(t *testing.T) {
+	pricing, err := NewServiceFromData([]byte(`{
+		"claude-test":{"input_cost_per_token":0.000003,"output_cost_per_token":0.000015},
+		"gpt-test":{"input_cost_per_token":0.000002,"output_cost_per_token":0.000008,
+			"input_cost_per_token_batches":0.000001,"output_cost_per_token_batches":0.000002}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000, OutputTokens: 1000, CacheReadTokens: 1000}
+
+	realtime := pricing.CalculateCost("claude-test", usage)
+	batch := pricing.CalculateCost("claude-test", usage, WithBatch())
+	if math.Abs(batch.TotalCost-realtime.TotalCost/2) > 1e-12 || math.Abs(batch.BatchDiscount-realtime.TotalCost/2) > 1e-12 {
+		t.Fatalf("默认应按 50%% 折扣计费: %+v", batch)
+	}
+	if realtime.BatchDiscount != 0 {
+		t.Fatalf("实时请求不应有批量折扣: %+v", realtime)
+	}
+
+	// 价格数据提供 *_batches 单价时按其计费
+	gpt := pricing.CalculateCost("gpt-test", UsageSnapshot{InputTokens: 1000, OutputTokens: 1000}, WithBatch())
+	if math.Abs(gpt.InputCost-0.001) > 1e-12 || math.Abs(gpt.OutputCost-0.002) > 1e-12 {
+		t.Fatalf("应使用 *_batches 单价: %+v", gpt)
+	}
+
+	// 批量折扣先于转售倍率计算
+	marked := pricing.CalculateCost("claude-test", usage, WithBatch(), WithMarkup(2, 0))
+	if math.Abs(marked.TotalCost-realtime.TotalCost) > 1e-12 || math.Abs(marked.BatchDiscount-realtime.TotalCost) > 1e-12 {
+		t.Fatalf("批量折扣与倍率组合错误: %+v", marked)
+	}
+}
+
+func TestCalculateCostImageAndAudio(t *testing.T) {
+	pricing, err := NewServiceFromData([]byte(`{
+		"gpt-audio-test":{"input_cost_per_token":0.000002,"output_cost_per_token":0.000008,
+			"input_cost_per_audio_token":0.00004,"output_cost_per_audio_token":0.00008},
+		"gemini-image-test":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000002,
+			"input_cost_per_image":0.001,"output_cost_per_image":0.04}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+
+	// 音频 tokens 包含在 input/output tokens 中，只按音频单价计一次
+	audio := pricing.CalculateCost("gpt-audio-test", UsageSnapshot{
+		InputTokens: 1000, OutputTokens: 500, InputAudioTokens: 400, OutputAudioTokens: 100,
+	})
+	wantText := 600*0.000002 + 400*0.000008
+	wantAudio := 400*0.00004 + 100*0.00008
+	if math.Abs(audio.InputCost+audio.OutputCost-wantText) > 1e-12 || math.Abs(audio.AudioCost-wantAudio) > 1e-12 {
+		t.Fatalf("音频计费错误: %+v", audio)
+	}
+	if math.Abs(audio.TotalCost-(wantText+wantAudio)) > 1e-12 {
+		t.Fatalf("TotalCost = %v，期望 %v", audio.TotalCost, wantText+wantAudio)
+	}
+
+	image := pricing.CalculateCost("gemini-image-test", UsageSnapshot{InputTokens: 100, InputImages: 3, OutputImages: 1})
+	if math.Abs(image.ImageCost-(3*0.001+0.04)) > 1e-12 || math.Abs(image.TotalCost-(100*0.000001+3*0.001+0.04)) > 1e-12 {
+		t.Fatalf("图片计费错误: %+v", image)
+	}
+
+	// 没有音频单价的模型按文本单价计费
+	plain, _ := NewServiceFromData([]byte(`{"m":{"input_cost_per_token":0.000001}}`))
+	if cost := plain.CalculateCost("m", UsageSnapshot{InputTokens: 1000, InputAudioTokens: 1000}); math.Abs(cost.InputCost-0.001) > 1e-12 || cost.AudioCost != 0 {
+		t.Fatalf("无音频单价时应按文本计费: %+v", cost)
+	}
+}
+
+func TestCalculateCostServiceTier(t *testing.T) {
+	pricing, err := NewServiceFromData([]byte(`{
+		"gpt-tier-test":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000008,"cache_read_input_token_cost":0.0000001,
+			"input_cost_per_token_priority":0.000002,"output_cost_per_token_priority":0.000016,
+			"input_cost_per_token_flex":0.0000005,"output_cost_per_token_flex":0.000004,"cache_read_input_token_cost_flex":0.00000005},
+		"claude-tier-test":{"input_cost_per_token":0.000003,"output_cost_per_token":0.000015}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000, OutputTokens: 100, CacheReadTokens: 1000}
+	standard := pricing.CalculateCost("gpt-tier-test", usage)
+
+	usage.ServiceTier = "priority"
+	priority := pricing.CalculateCost("gpt-tier-test", usage)
+	// 缺少 priority 缓存读取单价时沿用输入的比例
+	want := 1000*0.000002 + 100*0.000016 + 1000*0.0000002
+	if priority.ServiceTier != ServiceTierPriority || math.Abs(priority.TotalCost-want) > 1e-12 ||
+		math.Abs(priority.ServiceTierCost-(want-standard.TotalCost)) > 1e-12 {
+		t.Fatalf("priority 计费错误: %+v", priority)
+	}
+
+	usage.ServiceTier = "flex"
+	flex := pricing.CalculateCost("gpt-tier-test", usage)
+	if math.Abs(flex.TotalCost-standard.TotalCost/2) > 1e-12 || flex.ServiceTierCost >= 0 {
+		t.Fatalf("flex 计费错误: %+v", flex)
+	}
+
+	// 没有等级单价时记录等级但按标准价格计算
+	claude := pricing.CalculateCost("claude-tier-test", UsageSnapshot{InputTokens: 1000, ServiceTier: "priority"})
+	if claude.ServiceTier != ServiceTierPriority || claude.ServiceTierCost != 0 || math.Abs(claude.TotalCost-0.003) > 1e-12 {
+		t.Fatalf("无等级单价时应按标准价格计算: %+v", claude)
+	}
+	if NormalizeServiceTier("standard") != "" || NormalizeServiceTier("default") != "" {
+		t.Fatalf("标准等级应归一化为空")
+	}
+}
+
+func TestPricingServerToolCosts(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"search-model":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000002,"search_context_cost_per_query":0.02},
+			"sized-search-model":{"input_cost_per_token":0.000001,"search_context_cost_per_query":{"search_context_size_low":0.01,"search_context_size_medium":0.025,"search_context_size_high":0.05},"code_interpreter_cost_per_session":0.05},
+			"gpt-test":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000002,"input_cost_per_token_batches":0.0000005,"output_cost_per_token_batches":0.000001}}`)
+	}))
+	defer source.Close()
+	svc, err := New(WithSourceURLs(source.URL), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	cases := []struct {
+		model string
+		usage UsageSnapshot
+		tool  float64
+		total float64
+	}{
+		{"search-model", UsageSnapshot{InputTokens: 1000, OutputTokens: 1000, WebSearchCalls: 2}, 0.04, 0.043},
+		// 按搜索上下文大小定价时取 medium
+		{"sized-search-model", UsageSnapshot{WebSearchCalls: 2, CodeExecutions: 1}, 0.1, 0.1},
+		// 价格数据未提供时按内置规则：网页搜索 $0.01 / 次，代码执行 $0.03 / 会话
+		{"gpt-test", UsageSnapshot{InputTokens: 1000, WebSearchCalls: 1, CodeExecutions: 2}, 0.07, 0.071},
+	}
+	for _, tc := range cases {
+		cost := svc.CalculateCost(tc.model, tc.usage)
+		if !cost.HasPricing || math.Abs(cost.ToolCost-tc.tool) > 1e-12 || math.Abs(cost.TotalCost-tc.total) > 1e-12 {
+			t.Fatalf("%s 的工具费用应为 %v、总费用 %v: %+v", tc.model, tc.tool, tc.total, cost)
+		}
+	}
+	// Batch 折扣只作用于 token 费用
+	batch := svc.CalculateCost("gpt-test", UsageSnapshot{InputTokens: 1000, WebSearchCalls: 1}, WithBatch())
+	if math.Abs(batch.ToolCost-0.01) > 1e-12 || math.Abs(batch.TotalCost-0.0105) > 1e-12 {
+		t.Fatalf("Batch 请求的工具费用不应打折: %+v", batch)
+	}
+
+}
+
+func TestPricingEmbeddingAndRerankCosts(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"embed-model":{"input_cost_per_token":0.0000001,"output_cost_per_token":0,"mode":"embedding"},
+			"rerank-model":{"input_cost_per_query":0.002,"input_cost_per_token":0,"output_cost_per_token":0,"mode":"rerank"},
+			"online-model":{"input_cost_per_request":0.005,"input_cost_per_token":0,"output_cost_per_token":0.000002,"mode":"chat"}}`)
+	}))
+	defer source.Close()
+	svc, err := New(WithSourceURLs(source.URL), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	cases := []struct {
+		model   string
+		usage   UsageSnapshot
+		request float64
+		total   float64
+	}{
+		{"embed-model", UsageSnapshot{InputTokens: 10000}, 0, 0.001},
+		{"rerank-model", UsageSnapshot{Queries: 3}, 0.006, 0.006},
+		{"online-model", UsageSnapshot{OutputTokens: 1000}, 0.005, 0.007},
+		// 没有用量（如上游报错）时不收取按请求的费用
+		{"online-model", UsageSnapshot{}, 0, 0},
+	}
+	for _, tc := range cases {
+		cost := svc.CalculateCost(tc.model, tc.usage)
+		if !cost.HasPricing || math.Abs(cost.RequestCost-tc.request) > 1e-12 || math.Abs(cost.TotalCost-tc.total) > 1e-12 {
+			t.Fatalf("%s 的按次费用应为 %v、总费用 %v: %+v", tc.model, tc.request, tc.total, cost)
+		}
+	}
+	batch := svc.CalculateCost("rerank-model", UsageSnapshot{Queries: 1}, WithBatch(), WithMarkup(2, 0))
+	if math.Abs(batch.TotalCost-0.004) > 1e-12 {
+		t.Fatalf("按查询计的费用不应参与 Batch 折扣，但应按倍率调整: %+v", batch)
+	}
+}
+
+func BenchmarkPricingCalculateCost(b *testing.B) {
+	svc, err := NewService()
+	if err != nil {
+		b.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1200, OutputTokens: 300, CacheReadTokens: 5000}
+	for _, bench := range []struct {
+		name  string
+		model string
+	}{
+		{"exact", "claude-sonnet-4-5"},
+		{"prefix", "us.anthropic.claude-sonnet-4-5-20250929-v1:0"},
+		{"normalized", "Claude_Sonnet_4.5"},
+		{"fuzzy", "claude-sonnet-4-5-custom-build"},
+		{"unknown", "totally-unknown-model-name"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				svc.CalculateCost(bench.model, usage)
+			}
+		})
+	}
+	b.Run("parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				svc.CalculateCost("claude-sonnet-4-5-custom-build", usage)
+			}
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package modelpricing
+
+import "strings"
+
+// FallbackRule 为价格数据缺少 1 小时缓存写入、[1m] 长上下文或服务端工具单价的模型提供单价，按顺序取第一条适用的规则。
+// 价格数据（含 WithOverridesFile 的覆盖）提供 cache_creation_input_token_cost_above_1hr、*_above_200k_tokens、
+// search_context_cost_per_query 或 code_interpreter_cost_per_session 时不使用规则。
+type FallbackRule struct {
+	// Pattern 为模型名包含的子串（不区分大小写），为空时匹配所有模型
+	Pattern string `json:"pattern"`
+	// Ephemeral1hInputMultiplier 为 1 小时缓存写入单价相对输入单价的倍率，模型没有输入单价时使用 Ephemeral1h
+	Ephemeral1hInputMultiplier float64 `json:"ephemeral1hInputMultiplier,omitempty"`
+	Ephemeral1h                float64 `json:"ephemeral1h,omitempty"`
+	// LongContextInput / LongContextOutput 为 [1m] 模型提示词超过 200k 时的输入与输出单价
+	LongContextInput  float64 `json:"longContextInput,omitempty"`
+	LongContextOutput float64 `json:"longContextOutput,omitempty"`
+	// WebSearchPerCall / CodeExecutionPerCall 为每次网页搜索与每个代码执行会话的费用
+	WebSearchPerCall     float64 `json:"webSearchPerCall,omitempty"`
+	CodeExecutionPerCall float64 `json:"codeExecutionPerCall,omitempty"`
+}
+
+// defaultFallbackRules 按 Anthropic 的定价规则：1 小时缓存写入为输入单价的 2 倍，Sonnet 4 的 1M 上下文超过 200k 时输入 $6 / 输出 $22.5，
+// 网页搜索 $10 / 1k 次；OpenAI Responses 的网页搜索 $10 / 1k 次，代码解释器 $0.03 / 会话。
+var defaultFallbackRules = []FallbackRule{
+	{Pattern: "opus", Ephemeral1hInputMultiplier: 2, Ephemeral1h: 0.00003, WebSearchPerCall: 0.01},
+	{Pattern: "sonnet", Ephemeral1hInputMultiplier: 2, Ephemeral1h: 0.000006, LongContextInput: 0.000006, LongContextOutput: 0.0000225, WebSearchPerCall: 0.01},
+	{Pattern: "haiku", Ephemeral1hInputMultiplier: 2, Ephemeral1h: 0.0000016, WebSearchPerCall: 0.01},
+	{Pattern: "gpt-", WebSearchPerCall: 0.01, CodeExecutionPerCall: 0.03},
+	{Pattern: "o3", WebSearchPerCall: 0.01, CodeExecutionPerCall: 0.03},
+	{Pattern: "o4-", WebSearchPerCall: 0.01, CodeExecutionPerCall: 0.03},
+}
+
+// WithFallbackRules 添加优先于内置规则的单价规则，内置规则仍作为最后的兜底。
+func WithFallbackRules(rules ...FallbackRule) Option {
+	return func(o *serviceOptions) {
+		cleaned := make([]FallbackRule, 0, len(rules)+len(defaultFallbackRules))
+		for _, rule := range rules {
+			rule.Pattern = strings.ToLower(strings.TrimSpace(rule.Pattern))
+			cleaned = append(cleaned, rule)
+		}
+		o.fallbackRules = append(cleaned, defaultFallbackRules...)
+	}
+}
+
+// matches 返回规则是否适用于该模型。
+func (r FallbackRule) matches(model string) bool {
+	return strings.Contains(strings.ToLower(model), r.Pattern)
+}
+
+// ephemeral1hPrice 返回 1 小时缓存写入单价：优先使用价格数据，其次按规则计算，都没有时为 0。
+func (t *pricingTable) ephemeral1hPrice(model string, entry *PricingEntry) float64 {
+	if entry.CacheCreationInputTokenCostAbove1Hr > 0 {
+		return entry.CacheCreationInputTokenCostAbove1Hr
+	}
+	for _, rule := range t.rules {
+		if !rule.matches(model) {
+			continue
+		}
+		if rule.Ephemeral1hInputMultiplier > 0 && entry.InputCostPerToken > 0 {
+			return entry.InputCostPerToken * rule.Ephemeral1hInputMultiplier
+		}
+		if rule.Ephemeral1h > 0 {
+			return rule.Ephemeral1h
+		}
+	}
+	return 0
+}
+
+// longContextTier 返回 [1m] 模型提示词超过 200k 时的单价（整个请求按该单价计费）：优先使用价格数据的 *_above_200k_tokens，其次按规则。
+func (t *pricingTable) longContextTier(model string, entry *PricingEntry, usage UsageSnapshot) (LongContextPricing, bool) {
+	totalInput := usage.InputTokens + usage.CacheCreateTokens + usage.CacheReadTokens
+	if !strings.Contains(strings.ToLower(model), "[1m]") || totalInput <= tierThreshold200k {
+		return LongContextPricing{}, false
+	}
+	if entry.InputCostPerTokenAbove200k > 0 {
+		tier := LongContextPricing{Input: entry.InputCostPerTokenAbove200k, Output: entry.OutputCostPerTokenAbove200k}
+		if tier.Output <= 0 {
+			tier.Output = entry.OutputCostPerToken
+		}
+		return tier, true
+	}
+	for _, rule := range t.rules {
+		if rule.matches(model) && rule.LongContextInput > 0 {
+			return LongContextPricing{Input: rule.LongContextInput, Output: rule.LongContextOutput}, true
+		}
+	}
+	return LongContextPricing{}, false
+}
+
+// webSearchPrice 返回每次网页搜索的费用：优先使用价格数据，其次按规则。
+func (t *pricingTable) webSearchPrice(model string, entry *PricingEntry) float64 {
+	if entry.SearchContextCostPerQuery > 0 {
+		return float64(entry.SearchContextCostPerQuery)
+	}
+	for _, rule := range t.rules {
+		if rule.matches(model) && rule.WebSearchPerCall > 0 {
+			return rule.WebSearchPerCall
+		}
+	}
+	return 0
+}
+
+// codeExecutionPrice 返回每个代码执行会话的费用：优先使用价格数据，其次按规则。
+func (t *pricingTable) codeExecutionPrice(model string, entry *PricingEntry) float64 {
+	if entry.CodeInterpreterCostPerSession > 0 {
+		return entry.CodeInterpreterCostPerSession
+	}
+	for _, rule := range t.rules {
+		if rule.matches(model) && rule.CodeExecutionPerCall > 0 {
+			return rule.CodeExecutionPerCall
+		}
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package modelpricing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPricingFallbackRules(t *testing.T) {
+	overrides := filepath.Join(t.TempDir(), "overrides.json")
+	if err := os.WriteFile(overrides, []byte(`{
+		"claude-opus-9": {"input_cost_per_token": 0.00001, "output_cost_per_token": 0.00005,
+			"cache_creation_input_token_cost_above_1hr": 0.000025,
+			"input_cost_per_token_above_200k_tokens": 0.00002, "output_cost_per_token_above_200k_tokens": 0.000075},
+		"claude-sonnet-9": {"input_cost_per_token": 0.000004, "output_cost_per_token": 0.00002},
+		"corp-ultra": {"input_cost_per_token": 0.000005, "output_cost_per_token": 0.00001}
+	}`), 0644); err != nil {
+		t.Fatalf("写入覆盖文件失败: %v", err)
+	}
+	svc, err := New(WithOffline(true), WithLogger(nil), WithOverridesFile(overrides),
+		WithFallbackRules(FallbackRule{Pattern: "Ultra", Ephemeral1hInputMultiplier: 3}))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	cache1h := UsageSnapshot{CacheCreateTokens: 1000, CacheCreation: &CacheCreationDetail{Ephemeral1hTokens: 1000}}
+	for model, want := range map[string]float64{
+		"claude-opus-9":   0.025, // 价格数据中的 1 小时缓存单价
+		"claude-sonnet-9": 0.008, // 内置规则：输入单价的 2 倍
+		"corp-ultra":      0.015, // 配置的规则
+		"gpt-5":           0,
+	} {
+		if got := svc.CalculateCost(model, cache1h).Ephemeral1hCost; math.Abs(got-want) > 1e-12 {
+			t.Errorf("%s 的 1 小时缓存费用应为 %v: %v", model, want, got)
+		}
+	}
+
+	long := UsageSnapshot{InputTokens: 300000, OutputTokens: 1000}
+	opus := svc.CalculateCost("claude-opus-9[1m]", long)
+	if !opus.IsLongContext || math.Abs(opus.InputCost-6) > 1e-9 || math.Abs(opus.OutputCost-0.075) > 1e-12 {
+		t.Fatalf("[1m] 模型应使用价格数据中的 200k 以上单价: %+v", opus)
+	}
+	sonnet := svc.CalculateCost("claude-sonnet-9[1m]", long)
+	if !sonnet.IsLongContext || math.Abs(sonnet.InputCost-1.8) > 1e-9 || math.Abs(sonnet.OutputCost-0.0225) > 1e-12 {
+		t.Fatalf("缺少 200k 以上单价的 [1m] 模型应使用内置规则: %+v", sonnet)
+	}
+	if short := svc.CalculateCost("claude-opus-9[1m]", UsageSnapshot{InputTokens: 1000}); short.IsLongContext {
+		t.Fatalf("未超过 200k 时不应按长上下文计费: %+v", short)
+	}
+}
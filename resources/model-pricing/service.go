@@ -0,0 +1,679 @@
+package modelpricing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Service 提供模型价格相关的计算能力。
+// 通过 New 创建的实例彼此独立，各自使用自己的数据源、缓存目录与更新周期；调用 Start 后定时更新价格数据，
+// 不再使用时调用 Close 结束更新协程与进行中的拉取。
+type Service struct {
+	options serviceOptions
+
+	mu    sync.RWMutex
+	table *pricingTable
+	// 用户定义的模型别名（键为小写的模型名），通过 SetAliases 整体替换
+	aliases map[string]string
+	// 每次 SetAliases 后递增，见 lookupcache.go
+	aliasGeneration uint64
+	// 价格数据内容的摘要，用于 OnUpdated 报告新旧版本
+	version string
+	// 当前数据的来源（Source* 常量）与数据源地址
+	source    string
+	sourceURL string
+	// 价格数据的更新时间（使用内置数据时为零值）
+	lastUpdate time.Time
+	// 最后一次确认数据仍为最新的时间（远程返回 304 时只更新该时间）
+	lastCheck time.Time
+
+	// 已加载的历史版本，供 CalculateCostAt 使用
+	snapshotMu sync.Mutex
+	snapshots  map[string]*pricingTable
+
+	// 远程更新的结果计数（含创建时的拉取，304 计为成功）
+	updateSuccesses atomic.Int64
+	updateFailures  atomic.Int64
+
+	// refreshMu 保证定时更新与 ForceRefresh 不会同时拉取
+	refreshMu sync.Mutex
+
+	lifecycle sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closed    bool
+	// lifetime 在 Close 时取消，用于中断进行中的 ForceRefresh
+	lifetimeOnce sync.Once
+	lifetime     context.Context
+	endLifetime  context.CancelFunc
+
+	hooksMu      sync.Mutex
+	nextHookID   int
+	updatedHooks []updatedHook
+	failedHooks  []failedHook
+}
+
+type updatedHook struct {
+	id int
+	fn func(oldVersion, newVersion string)
+}
+
+type failedHook struct {
+	id int
+	fn func(err error)
+}
+
+// 价格数据的来源
+const (
+	// SourceEmbedded 为编译时内置的数据
+	SourceEmbedded = "embedded"
+	// SourceCache 为本地缓存
+	SourceCache = "cache"
+	// SourceRemote 为本次运行中从远程数据源拉取
+	SourceRemote = "remote"
+	// SourceProvided 为 NewServiceFromData 传入的数据
+	SourceProvided = "provided"
+)
+
+// Info 描述当前使用的价格数据，用于诊断与界面展示数据是否过期。
+type Info struct {
+	Source    string `json:"source"`
+	SourceURL string `json:"source_url,omitempty"`
+	Version   string `json:"version"`
+	// FetchedAt 是数据从远程拉取的时间（内置数据为零值），CheckedAt 是最近一次确认数据仍为最新的时间
+	FetchedAt         time.Time `json:"fetched_at"`
+	CheckedAt         time.Time `json:"checked_at"`
+	ModelCount        int       `json:"model_count"`
+	UpdateIntervalSec float64   `json:"update_interval_sec"`
+	// Stale 表示超过更新间隔未能确认数据为最新（使用内置数据时总是为 true，离线模式下总是为 false）
+	Stale bool `json:"stale"`
+	// Offline 表示处于离线模式，不会从远程更新
+	Offline bool `json:"offline"`
+	// 远程更新成功与失败的累计次数（304 计为成功）
+	UpdateSuccessTotal int64 `json:"update_success_total"`
+	UpdateFailureTotal int64 `json:"update_failure_total"`
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultInstance atomic.Pointer[Service]
+	defaultErr      error
+)
+
+// DefaultService 返回使用 Configure / SetCacheDir 全局选项创建的单例，首次调用时启动定时更新。
+func DefaultService() (*Service, error) {
+	defaultOnce.Do(func() {
+		svc, err := NewServiceWithDynamicUpdate()
+		defaultErr = err
+		if err == nil {
+			svc.Start()
+			defaultInstance.Store(svc)
+		}
+	})
+	return defaultInstance.Load(), defaultErr
+}
+
+// LastUpdated 返回 DefaultService 价格数据的更新时间（未初始化或使用内置数据时为零值）。
+func LastUpdated() time.Time {
+	if svc := defaultInstance.Load(); svc != nil {
+		return svc.LastUpdated()
+	}
+	return time.Time{}
+}
+
+// ForceRefresh 立即从远程拉取 DefaultService 的价格数据，忽略缓存有效期。
+func ForceRefresh(ctx context.Context) error {
+	svc, err := DefaultService()
+	if err != nil {
+		return err
+	}
+	return svc.ForceRefresh(ctx)
+}
+
+// CurrentInfo 返回 DefaultService 的价格数据信息，未初始化时返回零值。
+func CurrentInfo() Info {
+	if svc := defaultInstance.Load(); svc != nil {
+		return svc.Info()
+	}
+	return Info{}
+}
+
+// StopPeriodicUpdate 停止 DefaultService 的定时更新（用于测试或优雅关闭）。
+func StopPeriodicUpdate() {
+	if svc := defaultInstance.Load(); svc != nil {
+		svc.Stop()
+	}
+}
+
+// Shutdown 关闭 DefaultService：停止定时更新并中断进行中的刷新，用于应用退出。
+func Shutdown() {
+	if svc := defaultInstance.Load(); svc != nil {
+		_ = svc.Close()
+	}
+}
+
+// NewServiceWithDynamicUpdate 创建支持动态更新的服务实例，opts 叠加在 Configure 设置的选项之上。
+func NewServiceWithDynamicUpdate(opts ...Option) (*Service, error) {
+	return newService(context.Background(), currentOptions(opts...))
+}
+
+// New 按 opts 创建独立的服务实例（不使用 Configure 设置的全局选项）：
+// 依次尝试未过期的缓存、远程数据源与内置数据，调用 Start 后开始定时更新。
+func New(opts ...Option) (*Service, error) {
+	return NewWithContext(context.Background(), opts...)
+}
+
+// NewWithContext 与 New 相同，ctx 用于控制创建时的远程拉取，取消后直接使用缓存或内置数据。
+func NewWithContext(ctx context.Context, opts ...Option) (*Service, error) {
+	options := defaultServiceOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return newService(ctx, options)
+}
+
+func newService(ctx context.Context, options serviceOptions) (*Service, error) {
+	s := &Service{options: options, source: SourceCache, aliases: options.aliases}
+	var (
+		table *pricingTable
+		data  []byte
+		err   error
+	)
+	if options.isOffline() {
+		// 离线模式只使用内置数据（叠加本地覆盖）
+		data = pricingFile
+		s.source = SourceEmbedded
+	} else if data, err = s.loadCacheData(false); err == nil {
+		// 使用未过期的缓存
+		s.sourceURL = s.cachedSourceURL()
+	} else {
+		// 缓存失败，尝试从远程拉取
+		var meta cacheMeta
+		data, meta, err = s.fetchRemotePricing(ctx)
+		switch {
+		case errors.Is(err, errNotModified):
+			// 远程数据未变化，继续使用已过期的缓存
+			options.touchCache(meta)
+			data, err = s.loadCacheData(true)
+			s.sourceURL = meta.Source
+			s.updateSuccesses.Add(1)
+		case err == nil:
+			// 远程拉取成功，校验通过后保存到缓存
+			if table, err = options.parseVerified(data, nil); err != nil {
+				break
+			}
+			if saveErr := options.saveToCache(data, meta); saveErr != nil {
+				options.logf("警告：保存价格数据到缓存失败: %v", saveErr)
+			}
+			s.lastUpdate = time.Now()
+			s.lastCheck = s.lastUpdate
+			s.source, s.sourceURL = SourceRemote, meta.Source
+			s.updateSuccesses.Add(1)
+		}
+		if err != nil {
+			// 远程拉取失败，使用嵌入的数据
+			options.logf("警告：无法获取最新价格数据，使用内置数据: %v", err)
+			s.updateFailures.Add(1)
+			data = pricingFile
+			s.source, s.sourceURL = SourceEmbedded, ""
+		}
+	}
+
+	if table == nil {
+		table, err = s.options.parseTable(data, true)
+		if err != nil && s.source != SourceEmbedded {
+			// 缓存中的价格数据无法解析时使用嵌入的数据
+			s.options.quarantineCache(err)
+			data = pricingFile
+			s.source, s.sourceURL = SourceEmbedded, ""
+			s.lastUpdate, s.lastCheck = time.Time{}, time.Time{}
+			table, err = s.options.parseTable(data, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.table = table
+	s.version = table.version
+	options.saveSnapshot(s.version, data)
+	return s, nil
+}
+
+// dataVersion 返回价格数据内容的摘要，内容不变时版本不变。
+func dataVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// cachedSourceURL 返回缓存数据的来源地址，缓存信息缺失时返回空字符串。
+func (s *Service) cachedSourceURL() string {
+	meta, err := s.options.loadCacheMeta()
+	if err != nil {
+		return ""
+	}
+	return meta.Source
+}
+
+// Info 返回当前价格数据的来源、版本、更新时间与模型数量。
+func (s *Service) Info() Info {
+	if s == nil {
+		return Info{}
+	}
+	models := len(s.ListModels())
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info := Info{
+		Source:             s.source,
+		SourceURL:          s.sourceURL,
+		Version:            s.version,
+		FetchedAt:          s.lastUpdate,
+		CheckedAt:          s.lastCheck,
+		ModelCount:         models,
+		UpdateIntervalSec:  s.options.updateInterval.Seconds(),
+		Offline:            s.options.isOffline(),
+		UpdateSuccessTotal: s.updateSuccesses.Load(),
+		UpdateFailureTotal: s.updateFailures.Load(),
+	}
+	if info.Source != SourceProvided && !info.Offline {
+		info.Stale = info.CheckedAt.IsZero() || time.Since(info.CheckedAt) > s.options.updateInterval
+	}
+	return info
+}
+
+// current 返回当前使用的价格数据。
+func (s *Service) current() *pricingTable {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.table
+}
+
+// Version 返回当前价格数据的版本（内容摘要）。
+func (s *Service) Version() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// OnUpdated 注册价格数据替换后的回调，返回的函数用于取消注册。
+// 回调在更新协程中按注册顺序同步执行，耗时的处理应自行放到其他协程；数据未变化（304 或内容相同）时不调用。
+func (s *Service) OnUpdated(fn func(oldVersion, newVersion string)) (unregister func()) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.nextHookID++
+	id := s.nextHookID
+	s.updatedHooks = append(s.updatedHooks, updatedHook{id: id, fn: fn})
+	return func() {
+		s.hooksMu.Lock()
+		defer s.hooksMu.Unlock()
+		for i, hook := range s.updatedHooks {
+			if hook.id == id {
+				s.updatedHooks = append(s.updatedHooks[:i:i], s.updatedHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// OnUpdateFailed 注册更新失败（拉取或解析失败，包括 ForceRefresh）时的回调，返回的函数用于取消注册。被取消（如 Stop）的更新不视为失败。
+func (s *Service) OnUpdateFailed(fn func(err error)) (unregister func()) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.nextHookID++
+	id := s.nextHookID
+	s.failedHooks = append(s.failedHooks, failedHook{id: id, fn: fn})
+	return func() {
+		s.hooksMu.Lock()
+		defer s.hooksMu.Unlock()
+		for i, hook := range s.failedHooks {
+			if hook.id == id {
+				s.failedHooks = append(s.failedHooks[:i:i], s.failedHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (s *Service) notifyUpdated(oldVersion, newVersion string) {
+	s.hooksMu.Lock()
+	hooks := append([]updatedHook(nil), s.updatedHooks...)
+	s.hooksMu.Unlock()
+	for _, hook := range hooks {
+		hook.fn(oldVersion, newVersion)
+	}
+}
+
+func (s *Service) notifyFailed(err error) {
+	s.hooksMu.Lock()
+	hooks := append([]failedHook(nil), s.failedHooks...)
+	s.hooksMu.Unlock()
+	for _, hook := range hooks {
+		hook.fn(err)
+	}
+}
+
+// LastUpdated 返回当前价格数据的更新时间（使用内置数据时为零值）。
+func (s *Service) LastUpdated() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastUpdate
+}
+
+// ErrClosed 表示服务已通过 Close 关闭。
+var ErrClosed = errors.New("pricing service is closed")
+
+// Start 启动定时更新，已启动、处于离线模式或已关闭时不做任何事。首次更新的时间根据缓存的新鲜度计算。
+func (s *Service) Start() {
+	s.StartWithContext(context.Background())
+}
+
+// StartWithContext 与 Start 相同，ctx 取消后定时更新随之停止（进行中的拉取被中断），之后可以再次调用 Start。
+func (s *Service) StartWithContext(ctx context.Context) {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.closed || s.options.isOffline() {
+		return
+	}
+	if s.cancel != nil {
+		select {
+		case <-s.done:
+			// 上次的定时更新已因 ctx 取消退出
+			s.cancel()
+		default:
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.run(ctx, s.done)
+}
+
+// Stop 停止定时更新，中断进行中的拉取并等待更新协程退出。之后可以再次调用 Start。
+func (s *Service) Stop() {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.stopLocked()
+}
+
+func (s *Service) stopLocked() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel, s.done = nil, nil
+}
+
+// Close 停止定时更新并中断进行中的 ForceRefresh，等待所有拉取结束后返回。
+// 关闭后价格计算仍使用最后的数据，Start 不再生效，ForceRefresh 返回 ErrClosed。
+func (s *Service) Close() error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.closed = true
+	s.lifetimeContext()
+	s.endLifetime()
+	s.stopLocked()
+	// 等待进行中的 ForceRefresh 退出
+	s.refreshMu.Lock()
+	s.refreshMu.Unlock()
+	return nil
+}
+
+// lifetimeContext 返回在 Close 时取消的 context。
+func (s *Service) lifetimeContext() context.Context {
+	s.lifetimeOnce.Do(func() {
+		s.lifetime, s.endLifetime = context.WithCancel(context.Background())
+	})
+	return s.lifetime
+}
+
+// withLifetime 返回在 Close 时同时取消的 ctx，服务已关闭时返回 ErrClosed。
+func (s *Service) withLifetime(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	lifetime := s.lifetimeContext()
+	if lifetime.Err() != nil {
+		return ctx, func() {}, ErrClosed
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(lifetime, func() { cancel(ErrClosed) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}, nil
+}
+
+func (s *Service) run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+	timer := time.NewTimer(s.nextUpdateDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.update(ctx)
+			timer.Reset(s.options.updateInterval)
+		}
+	}
+}
+
+// nextUpdateDelay 计算距下次更新的时间，数据已过期时一分钟后更新。
+func (s *Service) nextUpdateDelay() time.Duration {
+	interval := s.options.updateInterval
+	s.mu.RLock()
+	lastCheck := s.lastCheck
+	s.mu.RUnlock()
+	if lastCheck.IsZero() {
+		return interval
+	}
+	if elapsed := time.Since(lastCheck); elapsed < interval {
+		return interval - elapsed
+	}
+	return time.Minute
+}
+
+// ForceRefresh 忽略缓存的有效期与条件请求，立即从远程拉取并替换价格数据，失败时保留当前数据并返回错误。
+// 离线模式下返回 ErrOffline。
+func (s *Service) ForceRefresh(ctx context.Context) error {
+	return s.refresh(ctx, true)
+}
+
+// update 由定时更新调用，错误已记录日志并通知 OnUpdateFailed。
+func (s *Service) update(ctx context.Context) {
+	_ = s.refresh(ctx, false)
+}
+
+// refresh 拉取并替换价格数据；force 为 true 时不发送条件请求。
+func (s *Service) refresh(ctx context.Context, force bool) error {
+	if s.options.isOffline() {
+		return ErrOffline
+	}
+	ctx, cancel, err := s.withLifetime(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	s.options.logf("开始更新模型价格数据...")
+
+	var (
+		data []byte
+		meta cacheMeta
+	)
+	if force {
+		data, meta, err = fetchFromSources(ctx, s.options, cacheMeta{})
+	} else {
+		data, meta, err = s.fetchRemotePricing(ctx)
+	}
+	if errors.Is(err, errNotModified) {
+		// 数据未变化，只刷新缓存的新鲜度，不重新解析
+		s.options.touchCache(meta)
+		s.mu.Lock()
+		s.lastCheck = time.Now()
+		s.mu.Unlock()
+		s.updateSuccesses.Add(1)
+		s.options.logf("模型价格数据未变化")
+		return nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		s.options.logf("更新价格数据失败: %v", err)
+		s.updateFailures.Add(1)
+		s.notifyFailed(err)
+		return err
+	}
+
+	// 校验未通过的数据不替换当前数据，也不写入缓存
+	table, err := s.options.parseVerified(data, s.current())
+	if err != nil {
+		s.options.logf("解析新的价格数据失败: %v", err)
+		s.updateFailures.Add(1)
+		s.notifyFailed(err)
+		return err
+	}
+
+	// 原子性更新
+	newVersion := table.version
+	s.mu.Lock()
+	oldVersion := s.version
+	s.table = table
+	s.version = newVersion
+	s.source, s.sourceURL = SourceRemote, meta.Source
+	s.lastUpdate = time.Now()
+	s.lastCheck = s.lastUpdate
+	s.mu.Unlock()
+
+	// 保存到缓存
+	if err := s.options.saveToCache(data, meta); err != nil {
+		s.options.logf("保存价格数据到缓存失败: %v", err)
+	}
+	s.options.saveSnapshot(newVersion, data)
+
+	s.updateSuccesses.Add(1)
+	s.options.logf("模型价格数据更新完成")
+	if oldVersion != newVersion {
+		s.notifyUpdated(oldVersion, newVersion)
+	}
+	return nil
+}
+
+// fetchRemotePricing 按配置的数据源顺序获取价格数据，对上次成功的数据源发送条件请求。
+// 数据未变化时返回 errNotModified。
+func (s *Service) fetchRemotePricing(ctx context.Context) ([]byte, cacheMeta, error) {
+	previous, _ := s.options.loadCacheMeta()
+	// 缓存数据丢失时不能依赖 304，需要重新下载
+	if cachePath, err := s.options.cacheFilePath(); err != nil {
+		previous = cacheMeta{}
+	} else if _, err := os.Stat(cachePath); err != nil {
+		previous = cacheMeta{}
+	}
+	return fetchFromSources(ctx, s.options, previous)
+}
+
+// loadCacheData 读取缓存的价格数据并记录其更新时间；allowStale 为 true 时忽略过期时间。
+func (s *Service) loadCacheData(allowStale bool) ([]byte, error) {
+	cachePath, err := s.options.cacheFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查文件是否存在
+	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("缓存文件不存在")
+	}
+
+	cacheBytes, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取缓存文件失败: %w", err)
+	}
+
+	var cacheData struct {
+		Timestamp int64           `json:"timestamp"`
+		Data      json.RawMessage `json:"data"`
+	}
+
+	if err := json.Unmarshal(cacheBytes, &cacheData); err != nil {
+		// 文件被截断（如写入时进程被终止）等情况，之后重新下载
+		s.options.quarantineCache(err)
+		return nil, fmt.Errorf("解析缓存数据失败: %w", err)
+	}
+
+	// 检查缓存是否过期（超过更新间隔），远程确认未变化的时间同样视为新鲜
+	cacheTime := time.Unix(cacheData.Timestamp, 0)
+	checkTime := cacheTime
+	if meta, err := s.options.loadCacheMeta(); err == nil && meta.CheckedAt > cacheData.Timestamp {
+		checkTime = time.Unix(meta.CheckedAt, 0)
+	}
+	if !allowStale && time.Since(checkTime) > s.options.updateInterval {
+		return nil, fmt.Errorf("缓存已过期")
+	}
+
+	s.mu.Lock()
+	s.lastUpdate = cacheTime
+	s.lastCheck = checkTime
+	s.mu.Unlock()
+	return cacheData.Data, nil
+}
+
+// cacheFilePath 获取缓存文件的完整路径，未通过 WithCacheDir 指定目录时使用 CacheDir。
+func (o serviceOptions) cacheFilePath() (string, error) {
+	cacheDir := o.cacheDir
+	if cacheDir == "" {
+		dir, err := CacheDir()
+		if err != nil {
+			return "", err
+		}
+		cacheDir = dir
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+
+	return filepath.Join(cacheDir, cacheFileName), nil
+}
+
+// saveToCache 将价格数据保存到本地缓存，同时记录用于条件请求的校验信息。
+func (o serviceOptions) saveToCache(data []byte, meta cacheMeta) error {
+	cachePath, err := o.cacheFilePath()
+	if err != nil {
+		return err
+	}
+
+	// 创建带时间戳的缓存数据
+	cacheData := map[string]interface{}{
+		"timestamp": time.Now().Unix(),
+		"data":      json.RawMessage(data),
+	}
+
+	cacheBytes, err := json.Marshal(cacheData)
+	if err != nil {
+		return fmt.Errorf("序列化缓存数据失败: %w", err)
+	}
+
+	unlock := o.lockCache()
+	defer unlock()
+	if err := writeFileAtomic(cachePath, cacheBytes, 0644); err != nil {
+		return fmt.Errorf("写入缓存文件失败: %w", err)
+	}
+
+	meta.CheckedAt = 0
+	return o.saveCacheMeta(meta)
+}
@@ -0,0 +1,343 @@
+package modelpricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPricingServiceInstancesUpdateIndependently(t *testing.T) {
+	newSource := func(price float64) (*httptest.Server, *atomic.Value, *atomic.Int64) {
+		current := &atomic.Value{}
+		current.Store(price)
+		hits := &atomic.Int64{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			fmt.Fprintf(w, `{"m":{"input_cost_per_token":%g}}`, current.Load().(float64))
+		}))
+		return server, current, hits
+	}
+	sourceA, priceA, hitsA := newSource(0.000001)
+	defer sourceA.Close()
+	sourceB, _, _ := newSource(0.000003)
+	defer sourceB.Close()
+
+	a, err := New(WithSourceURLs(sourceA.URL), WithCacheDir(t.TempDir()),
+		WithUpdateInterval(20*time.Millisecond), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务 A 失败: %v", err)
+	}
+	b, err := New(WithSourceURLs(sourceB.URL), WithCacheDir(t.TempDir()),
+		WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务 B 失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000}
+	if got := a.CalculateCost("m", usage).TotalCost; math.Abs(got-0.001) > 1e-12 {
+		t.Fatalf("A 的初始费用 = %v", got)
+	}
+	if got := b.CalculateCost("m", usage).TotalCost; math.Abs(got-0.003) > 1e-12 {
+		t.Fatalf("B 的费用 = %v", got)
+	}
+	if a.LastUpdated().IsZero() {
+		t.Fatalf("从远程拉取后应记录更新时间")
+	}
+
+	priceA.Store(0.000002)
+	a.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for math.Abs(a.CalculateCost("m", usage).TotalCost-0.002) > 1e-12 {
+		if time.Now().After(deadline) {
+			t.Fatalf("定时更新后 A 的价格未变化")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Stop()
+	stoppedAt := hitsA.Load()
+	time.Sleep(100 * time.Millisecond)
+	if hitsA.Load() != stoppedAt {
+		t.Fatalf("Stop 后不应继续拉取价格数据")
+	}
+	if got := b.CalculateCost("m", usage).TotalCost; math.Abs(got-0.003) > 1e-12 {
+		t.Fatalf("A 的更新不应影响 B: %v", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestPricingFetchUsesTransportAndContext(t *testing.T) {
+	var proxied atomic.Int64
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		proxied.Add(1)
+		rec := httptest.NewRecorder()
+		fmt.Fprint(rec, `{"m":{"input_cost_per_token":0.000004}}`)
+		return rec.Result(), nil
+	})
+	svc, err := New(WithSourceURLs("https://pricing.invalid/prices.json"),
+		WithTransport(transport), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	if proxied.Load() != 1 {
+		t.Fatalf("应通过指定的 Transport 拉取价格数据")
+	}
+	if got := svc.CalculateCost("m", UsageSnapshot{InputTokens: 1000}).TotalCost; math.Abs(got-0.004) > 1e-12 {
+		t.Fatalf("费用 = %v", got)
+	}
+
+	// 已取消的 ctx 不应等待数据源超时，直接使用内置数据
+	blocking := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started := time.Now()
+	svc, err = NewWithContext(ctx, WithSourceURLs("https://pricing.invalid/prices.json"),
+		WithTransport(blocking), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil || time.Since(started) > time.Second {
+		t.Fatalf("取消后应立即使用内置数据: %v", err)
+	}
+	if !svc.LastUpdated().IsZero() || svc.CalculateCost("claude-sonnet-4-5", UsageSnapshot{InputTokens: 1000}).TotalCost == 0 {
+		t.Fatalf("应使用内置价格数据")
+	}
+}
+
+func TestPricingUpdateHooks(t *testing.T) {
+	var price atomic.Value
+	price.Store(0.000001)
+	var failing atomic.Bool
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"m":{"input_cost_per_token":%g}}`, price.Load().(float64))
+	}))
+	defer source.Close()
+
+	svc, err := New(WithSourceURLs(source.URL), WithCacheDir(t.TempDir()),
+		WithUpdateInterval(20*time.Millisecond), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	initial := svc.Version()
+	updates := make(chan [2]string, 10)
+	failures := make(chan error, 10)
+	svc.OnUpdated(func(oldVersion, newVersion string) { updates <- [2]string{oldVersion, newVersion} })
+	unregister := svc.OnUpdateFailed(func(err error) { failures <- err })
+
+	price.Store(0.000002)
+	svc.Start()
+	defer svc.Stop()
+	select {
+	case update := <-updates:
+		if update[0] != initial || update[1] == initial || update[1] != svc.Version() {
+			t.Fatalf("版本变化错误: %v (初始 %s)", update, initial)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("价格变化后应调用 OnUpdated")
+	}
+
+	failing.Store(true)
+	select {
+	case err := <-failures:
+		if err == nil {
+			t.Fatalf("OnUpdateFailed 应收到错误")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("更新失败后应调用 OnUpdateFailed")
+	}
+	unregister()
+	svc.Stop()
+	for len(failures) > 0 {
+		<-failures
+	}
+	svc.Start()
+	time.Sleep(100 * time.Millisecond)
+	if len(failures) != 0 {
+		t.Fatalf("取消注册后不应再收到失败通知")
+	}
+	if len(updates) != 0 {
+		t.Fatalf("价格未变化时不应调用 OnUpdated: %d", len(updates))
+	}
+}
+
+func TestPricingForceRefresh(t *testing.T) {
+	var price atomic.Value
+	price.Store(0.000001)
+	var conditional atomic.Int64
+	var failing atomic.Bool
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("If-None-Match") != "" {
+			// 模拟 CDN 未及时更新 ETag 的情况，条件请求总是返回 304
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintf(w, `{"m":{"input_cost_per_token":%g}}`, price.Load().(float64))
+	}))
+	defer source.Close()
+
+	cacheDir := t.TempDir()
+	opts := []Option{WithSourceURLs(source.URL), WithCacheDir(cacheDir), WithLogger(nil)}
+	if _, err := New(opts...); err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	price.Store(0.000005)
+	// 缓存仍在有效期内，新实例直接使用缓存
+	svc, err := New(opts...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000}
+	if got := svc.CalculateCost("m", usage).TotalCost; math.Abs(got-0.001) > 1e-12 {
+		t.Fatalf("应使用缓存的价格: %v", got)
+	}
+
+	if err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("ForceRefresh 失败: %v", err)
+	}
+	if got := svc.CalculateCost("m", usage).TotalCost; math.Abs(got-0.005) > 1e-12 || conditional.Load() != 0 {
+		t.Fatalf("ForceRefresh 应绕过缓存与条件请求: cost=%v conditional=%d", got, conditional.Load())
+	}
+
+	failing.Store(true)
+	if err := svc.ForceRefresh(context.Background()); err == nil {
+		t.Fatalf("数据源失败时 ForceRefresh 应返回错误")
+	}
+	if got := svc.CalculateCost("m", usage).TotalCost; math.Abs(got-0.005) > 1e-12 {
+		t.Fatalf("刷新失败时应保留当前价格: %v", got)
+	}
+}
+
+func TestPricingInfo(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"m1":{"input_cost_per_token":0.000001},"m2":{"input_cost_per_token":0.000002},"sample_spec":{}}`)
+	}))
+	defer source.Close()
+	cacheDir := t.TempDir()
+	opts := []Option{WithSourceURLs(source.URL), WithCacheDir(cacheDir), WithLogger(nil)}
+
+	remote, err := New(opts...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	info := remote.Info()
+	if info.Source != SourceRemote || info.SourceURL != source.URL || info.ModelCount != 2 ||
+		info.Stale || info.FetchedAt.IsZero() || info.Version != remote.Version() {
+		t.Fatalf("远程数据信息错误: %+v", info)
+	}
+
+	cached, _ := New(opts...)
+	if info := cached.Info(); info.Source != SourceCache || info.SourceURL != source.URL || info.Stale {
+		t.Fatalf("缓存数据信息错误: %+v", info)
+	}
+
+	embedded, _ := New(WithSourceURLs(source.URL+"/missing"), WithCacheDir(t.TempDir()),
+		WithLogger(nil))
+	if info := embedded.Info(); info.Source != SourceEmbedded || !info.Stale || info.ModelCount < 100 || !info.FetchedAt.IsZero() {
+		t.Fatalf("内置数据信息错误: %+v", info)
+	}
+
+	provided, _ := NewServiceFromData([]byte(`{"m":{}}`))
+	if info := provided.Info(); info.Source != SourceProvided || info.Stale || info.ModelCount != 1 {
+		t.Fatalf("传入数据信息错误: %+v", info)
+	}
+}
+
+func TestPricingUpdaterShutdown(t *testing.T) {
+	var requests atomic.Int32
+	var blocking atomic.Bool
+	blocked := make(chan struct{}, 1)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if blocking.Load() {
+			blocked <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprintf(w, `{"m":{"input_cost_per_token":%d}}`, requests.Load())
+	}))
+	defer source.Close()
+	svc, err := New(WithSourceURLs(source.URL), WithCacheDir(t.TempDir()),
+		WithUpdateInterval(10*time.Millisecond), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	waitForRequests := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for requests.Load() < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("定时更新未运行: %d 次请求", requests.Load())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// ctx 取消后定时更新停止，之后可以重新启动
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.StartWithContext(ctx)
+	waitForRequests(requests.Load() + 2)
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+	stopped := requests.Load()
+	time.Sleep(50 * time.Millisecond)
+	if requests.Load() != stopped {
+		t.Fatalf("ctx 取消后不应继续更新: %d -> %d", stopped, requests.Load())
+	}
+	svc.Start()
+	waitForRequests(stopped + 1)
+	svc.Stop()
+
+	// Close 中断进行中的 ForceRefresh
+	blocking.Store(true)
+	refreshed := make(chan error, 1)
+	go func() { refreshed <- svc.ForceRefresh(context.Background()) }()
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ForceRefresh 未发出请求")
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("关闭价格服务失败: %v", err)
+	}
+	select {
+	case err := <-refreshed:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("Close 中断的 ForceRefresh 应返回 ErrClosed: %v", err)
+		}
+	default:
+		t.Fatalf("Close 返回前应等待 ForceRefresh 结束")
+	}
+	if err := svc.ForceRefresh(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("关闭后 ForceRefresh 应返回 ErrClosed: %v", err)
+	}
+	closedAt := requests.Load()
+	svc.Start()
+	time.Sleep(50 * time.Millisecond)
+	if requests.Load() != closedAt {
+		t.Fatalf("关闭后 Start 不应生效")
+	}
+	if cost := svc.CalculateCost("m", UsageSnapshot{InputTokens: 1}); !cost.HasPricing {
+		t.Fatalf("关闭后仍应使用最后的价格数据: %+v", cost)
+	}
+}
@@ -0,0 +1,232 @@
+package modelpricing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrSnapshotNotFound 表示磁盘上没有指定版本的价格数据快照（已被清理或来自其他机器）。
+var ErrSnapshotNotFound = errors.New("pricing snapshot not found")
+
+const (
+	// 快照保存在缓存目录的子目录中，每个版本一个 gzip 压缩的 LiteLLM 格式文件
+	snapshotDirName = "pricing-snapshots"
+	snapshotExt     = ".json.gz"
+	// 快照的默认保留时间（自最后一次使用该版本起算），覆盖按月与按年对账
+	defaultSnapshotRetention = 400 * 24 * time.Hour
+	// 内存中最多保留的历史版本数量
+	maxLoadedSnapshots = 4
+)
+
+// SnapshotInfo 描述一个保存在磁盘上的价格数据快照。
+type SnapshotInfo struct {
+	Version string `json:"version"`
+	// LastUsedAt 为该版本最后一次作为当前数据的时间，超过保留时间后快照被清理
+	LastUsedAt time.Time `json:"last_used_at"`
+	SizeBytes  int64     `json:"size_bytes"`
+}
+
+// WithSnapshotRetention 设置价格数据快照的保留时间（默认 400 天），超过该时间未使用的版本在下次保存快照时清理。
+func WithSnapshotRetention(retention time.Duration) Option {
+	return func(o *serviceOptions) {
+		if retention > 0 {
+			o.snapshotRetention = retention
+		}
+	}
+}
+
+// CalculateCostAt 按指定版本（CostBreakdown.PricingVersion）的价格数据计算费用，用于历史请求的费用在价格更新后保持不变。
+// version 为空或为当前版本时与 CalculateCost 相同；快照不存在时返回 ErrSnapshotNotFound。
+// 别名、严格匹配与兜底规则使用当前配置，本地价格覆盖按当前文件叠加。
+func (s *Service) CalculateCostAt(version string, model string, usage UsageSnapshot, opts ...CostOption) (CostBreakdown, error) {
+	if s == nil || model == "" {
+		return CostBreakdown{}, nil
+	}
+	table, err := s.snapshotTable(version)
+	if err != nil {
+		return CostBreakdown{}, err
+	}
+	return s.calculate(table, model, usage, opts...), nil
+}
+
+// Snapshots 返回磁盘上保存的价格数据快照（按最后使用时间从新到旧）。
+func (s *Service) Snapshots() ([]SnapshotInfo, error) {
+	dir, err := s.options.snapshotDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+	snapshots := make([]SnapshotInfo, 0, len(files))
+	for _, file := range files {
+		version, ok := strings.CutSuffix(file.Name(), snapshotExt)
+		if !ok || file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{Version: version, LastUsedAt: info.ModTime(), SizeBytes: info.Size()})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].LastUsedAt.After(snapshots[j].LastUsedAt)
+	})
+	return snapshots, nil
+}
+
+// snapshotTable 返回指定版本的价格数据，优先使用当前数据与已加载的快照。
+func (s *Service) snapshotTable(version string) (*pricingTable, error) {
+	table := s.current()
+	if version == "" || version == table.version {
+		return table, nil
+	}
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if loaded, ok := s.snapshots[version]; ok {
+		return loaded, nil
+	}
+	data, err := s.options.loadSnapshot(version)
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := s.options.parseTable(data, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.snapshots == nil {
+		s.snapshots = make(map[string]*pricingTable, maxLoadedSnapshots)
+	}
+	if len(s.snapshots) >= maxLoadedSnapshots {
+		for key := range s.snapshots {
+			delete(s.snapshots, key)
+			break
+		}
+	}
+	s.snapshots[version] = loaded
+	return loaded, nil
+}
+
+// snapshotDir 返回快照目录，位于价格缓存目录下。
+func (o serviceOptions) snapshotDir() (string, error) {
+	cachePath, err := o.cacheFilePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cachePath), snapshotDirName), nil
+}
+
+// saveSnapshot 保存当前版本的价格数据（已存在时只刷新最后使用时间），并清理超过保留时间的快照。
+func (o serviceOptions) saveSnapshot(version string, data []byte) {
+	if err := o.writeSnapshot(version, data); err != nil {
+		o.logf("警告：保存价格数据快照失败: %v", err)
+	}
+}
+
+func (o serviceOptions) writeSnapshot(version string, data []byte) error {
+	if !validSnapshotVersion(version) {
+		return fmt.Errorf("无效的价格数据版本: %q", version)
+	}
+	dir, err := o.snapshotDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建快照目录失败: %w", err)
+	}
+	path := filepath.Join(dir, version+snapshotExt)
+	now := time.Now()
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Chtimes(path, now, now); err != nil {
+			return err
+		}
+	} else {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(data); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, compressed.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	o.pruneSnapshots(dir, version)
+	return nil
+}
+
+// pruneSnapshots 删除超过保留时间未使用的快照，keep 为当前版本。
+func (o serviceOptions) pruneSnapshots(dir string, keep string) {
+	retention := o.snapshotRetention
+	if retention <= 0 {
+		retention = defaultSnapshotRetention
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		version, ok := strings.CutSuffix(file.Name(), snapshotExt)
+		if !ok || version == keep {
+			continue
+		}
+		if info, err := file.Info(); err == nil && time.Since(info.ModTime()) > retention {
+			if err := os.Remove(filepath.Join(dir, file.Name())); err == nil {
+				o.logf("已清理过期的价格数据快照 %s", version)
+			}
+		}
+	}
+}
+
+// loadSnapshot 读取指定版本的快照数据。
+func (o serviceOptions) loadSnapshot(version string) ([]byte, error) {
+	if !validSnapshotVersion(version) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, version)
+	}
+	dir, err := o.snapshotDir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, version+snapshotExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, version)
+		}
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取价格数据快照 %s 失败: %w", version, err)
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// validSnapshotVersion 只接受 dataVersion 生成的十六进制版本，避免版本号被用作路径。
+func validSnapshotVersion(version string) bool {
+	if version == "" {
+		return false
+	}
+	for _, c := range version {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
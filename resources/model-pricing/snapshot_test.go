@@ -0,0 +1,76 @@
+package modelpricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPricingSnapshots(t *testing.T) {
+	var body atomic.Value
+	body.Store(`{"m":{"input_cost_per_token":0.000001}}`)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body.Load().(string))
+	}))
+	defer source.Close()
+	cacheDir := t.TempDir()
+	opts := []Option{WithSourceURLs(source.URL), WithCacheDir(cacheDir),
+		WithLogger(nil), WithMinRetainRatio(0)}
+	svc, err := New(opts...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000}
+	before := svc.CalculateCost("m", usage)
+	if before.PricingVersion == "" || before.PricingVersion != svc.Version() {
+		t.Fatalf("费用应记录价格数据版本: %+v", before)
+	}
+
+	body.Store(`{"m":{"input_cost_per_token":0.000002}}`)
+	if err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("刷新价格失败: %v", err)
+	}
+	if got := svc.CalculateCost("m", usage); math.Abs(got.TotalCost-0.002) > 1e-12 || got.PricingVersion == before.PricingVersion {
+		t.Fatalf("刷新后应使用新价格: %+v", got)
+	}
+	old, err := svc.CalculateCostAt(before.PricingVersion, "m", usage)
+	if err != nil || math.Abs(old.TotalCost-0.001) > 1e-12 || old.PricingVersion != before.PricingVersion {
+		t.Fatalf("应按历史版本计算费用: %+v %v", old, err)
+	}
+
+	// 新的实例从磁盘读取快照
+	reopened, _ := New(opts...)
+	if old, err := reopened.CalculateCostAt(before.PricingVersion, "m", usage); err != nil || math.Abs(old.TotalCost-0.001) > 1e-12 {
+		t.Fatalf("应从磁盘快照计算历史费用: %+v %v", old, err)
+	}
+	for _, version := range []string{"0123456789ab", "../m"} {
+		if _, err := reopened.CalculateCostAt(version, "m", usage); !errors.Is(err, ErrSnapshotNotFound) {
+			t.Fatalf("不存在的版本 %s 应返回 ErrSnapshotNotFound: %v", version, err)
+		}
+	}
+	snapshots, err := reopened.Snapshots()
+	if err != nil || len(snapshots) != 2 || snapshots[0].Version != reopened.Version() {
+		t.Fatalf("快照列表错误: %+v %v", snapshots, err)
+	}
+
+	// 长期未使用的快照在下次保存时清理
+	longAgo := time.Now().AddDate(-2, 0, 0)
+	if err := os.Chtimes(filepath.Join(cacheDir, "pricing-snapshots", before.PricingVersion+".json.gz"), longAgo, longAgo); err != nil {
+		t.Fatalf("修改快照时间失败: %v", err)
+	}
+	body.Store(`{"m":{"input_cost_per_token":0.000003}}`)
+	if err := reopened.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("刷新价格失败: %v", err)
+	}
+	if snapshots, _ := reopened.Snapshots(); len(snapshots) != 2 {
+		t.Fatalf("过期的快照应被清理: %+v", snapshots)
+	}
+}
@@ -0,0 +1,341 @@
+package modelpricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 单个价格数据源的默认超时
+const defaultSourceTimeout = 30 * time.Second
+
+// defaultSourceURLs 是内置的价格数据源，按顺序尝试
+var defaultSourceURLs = []string{
+	remotePricingURL,
+	// jsDelivr 镜像，raw.githubusercontent.com 无法访问时使用
+	"https://cdn.jsdelivr.net/gh/BerriAI/litellm@main/model_prices_and_context_window.json",
+}
+
+// Option 配置价格服务的数据源、缓存目录、更新间隔、HTTP 客户端、日志与数据校验。
+type Option func(*serviceOptions)
+
+type serviceOptions struct {
+	sourceURLs []string
+	// 非 LiteLLM 格式的数据源（按地址），见 adapters.go
+	sourceFormats  map[string]PricingSource
+	sourceTimeout  time.Duration
+	cacheDir       string
+	updateInterval time.Duration
+	httpClient     *http.Client
+	transport      http.RoundTripper
+	logger         Logger
+	// 远程数据的完整性校验，见 verify.go
+	sha256Pins     []string
+	minModels      int
+	minRetainRatio float64
+	maxTokenPrice  float64
+	// 离线模式与本地价格覆盖，见 offline.go（offline 为 nil 时读取环境变量）
+	offline       *bool
+	overridesFile string
+	// 严格匹配模型名与用户定义的模型别名，见 match.go
+	strictMatching bool
+	aliases        map[string]string
+	// 1 小时缓存与长上下文单价的兜底规则，见 rules.go（nil 时使用内置规则）
+	fallbackRules []FallbackRule
+	// 价格数据快照的保留时间，见 snapshot.go
+	snapshotRetention time.Duration
+	// 按时段调整价格的规则，见 timeofday.go
+	timeOfDayRules []timeOfDayRule
+	// 写入缓存前获取锁文件，见 cachefile.go
+	cacheLock bool
+}
+
+func defaultServiceOptions() serviceOptions {
+	return serviceOptions{
+		sourceURLs:     append([]string(nil), defaultSourceURLs...),
+		sourceTimeout:  defaultSourceTimeout,
+		updateInterval: defaultUpdateInterval,
+		logger:         stdoutLogger{},
+		minRetainRatio: defaultMinRetainRatio,
+		maxTokenPrice:  defaultMaxTokenPrice,
+	}
+}
+
+// Logger 接收价格服务的运行日志，*log.Logger 满足该接口。
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// stdoutLogger 输出到标准输出，与 relay 其余日志一致。
+type stdoutLogger struct{}
+
+func (stdoutLogger) Printf(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func (o serviceOptions) logf(format string, args ...any) {
+	if o.logger != nil {
+		o.logger.Printf(format, args...)
+	}
+}
+
+// WithSourceURLs 替换价格数据源列表，按顺序尝试直到成功。
+func WithSourceURLs(urls ...string) Option {
+	return func(o *serviceOptions) {
+		if cleaned := cleanURLs(urls); len(cleaned) > 0 {
+			o.sourceURLs = cleaned
+		}
+	}
+}
+
+// WithMirrors 添加用户提供的镜像地址，优先于现有数据源尝试。
+func WithMirrors(urls ...string) Option {
+	return func(o *serviceOptions) {
+		o.sourceURLs = cleanURLs(append(append([]string(nil), urls...), o.sourceURLs...))
+	}
+}
+
+// WithSourceTimeout 设置单个数据源的请求超时。
+func WithSourceTimeout(timeout time.Duration) Option {
+	return func(o *serviceOptions) {
+		if timeout > 0 {
+			o.sourceTimeout = timeout
+		}
+	}
+}
+
+// WithCacheDir 指定缓存目录，未指定时使用 CacheDir 的规则。
+// 使用不同数据源的实例应指定不同的目录，否则会互相覆盖缓存。
+func WithCacheDir(dir string) Option {
+	return func(o *serviceOptions) {
+		o.cacheDir = strings.TrimSpace(dir)
+	}
+}
+
+// WithUpdateInterval 设置定时更新的间隔，同时作为缓存的有效期。
+func WithUpdateInterval(interval time.Duration) Option {
+	return func(o *serviceOptions) {
+		if interval > 0 {
+			o.updateInterval = interval
+		}
+	}
+}
+
+// WithHTTPClient 指定拉取价格数据使用的 HTTP 客户端（如需要代理时），单个数据源的超时仍由 WithSourceTimeout 控制。
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *serviceOptions) {
+		o.httpClient = client
+	}
+}
+
+// WithTransport 指定拉取价格数据使用的 RoundTripper（如企业代理或测试替身），与 WithHTTPClient 同时使用时替换其 Transport。
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *serviceOptions) {
+		o.transport = transport
+	}
+}
+
+// client 返回拉取价格数据使用的 HTTP 客户端，未指定时使用 http.DefaultClient（遵循 HTTPS_PROXY 等环境变量）。
+func (o serviceOptions) client() *http.Client {
+	if o.transport == nil {
+		if o.httpClient != nil {
+			return o.httpClient
+		}
+		return http.DefaultClient
+	}
+	client := &http.Client{}
+	if o.httpClient != nil {
+		*client = *o.httpClient
+	}
+	client.Transport = o.transport
+	return client
+}
+
+// WithLogger 指定日志输出，传入 nil 时不输出日志。
+func WithLogger(logger Logger) Option {
+	return func(o *serviceOptions) {
+		o.logger = logger
+	}
+}
+
+var (
+	optionsMu     sync.RWMutex
+	globalOptions = defaultServiceOptions()
+)
+
+// Configure 设置 DefaultService 与定时更新使用的拉取选项，每次调用都从默认值开始应用。
+func Configure(opts ...Option) {
+	options := defaultServiceOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	optionsMu.Lock()
+	globalOptions = options
+	optionsMu.Unlock()
+}
+
+// currentOptions 返回全局选项叠加 opts 后的结果。
+func currentOptions(opts ...Option) serviceOptions {
+	optionsMu.RLock()
+	options := globalOptions
+	options.sourceURLs = append([]string(nil), globalOptions.sourceURLs...)
+	options.sha256Pins = append([]string(nil), globalOptions.sha256Pins...)
+	optionsMu.RUnlock()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// SourceURLs 返回当前配置的价格数据源。
+func SourceURLs() []string {
+	return currentOptions().sourceURLs
+}
+
+func cleanURLs(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	cleaned := make([]string, 0, len(urls))
+	for _, url := range urls {
+		url = strings.TrimSpace(url)
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		cleaned = append(cleaned, url)
+	}
+	return cleaned
+}
+
+// fetchFromSources 依次尝试各数据源，返回第一个成功的结果；全部失败时返回汇总的错误。
+// previous 是上次成功拉取时记录的校验信息，只对同一个数据源发送条件请求。
+func fetchFromSources(ctx context.Context, options serviceOptions, previous cacheMeta) ([]byte, cacheMeta, error) {
+	if len(options.sourceURLs) == 0 {
+		return nil, cacheMeta{}, errors.New("未配置价格数据源")
+	}
+	var errs []error
+	for _, url := range options.sourceURLs {
+		validators := cacheMeta{Source: url}
+		if previous.Source == url {
+			validators = previous
+		}
+		data, meta, err := fetchSource(ctx, options.client(), url, options.sourceTimeout, validators)
+		if err == nil {
+			err = options.verifyChecksum(data)
+		}
+		if err == nil {
+			data, err = options.convertSource(url, data)
+		}
+		if err == nil || errors.Is(err, errNotModified) {
+			return data, meta, err
+		}
+		if ctx.Err() != nil {
+			return nil, cacheMeta{}, ctx.Err()
+		}
+		options.logf("价格数据源 %s 不可用: %v", url, err)
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return nil, cacheMeta{}, errors.Join(errs...)
+}
+
+// fetchSource 从单个数据源获取价格数据；服务器返回 304 时返回 errNotModified。
+func fetchSource(ctx context.Context, client *http.Client, url string, timeout time.Duration, validators cacheMeta) ([]byte, cacheMeta, error) {
+	meta := cacheMeta{Source: url}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, meta, fmt.Errorf("创建请求失败: %w", err)
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, meta, fmt.Errorf("请求远程价格数据失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, validators, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, meta, fmt.Errorf("远程服务器返回错误状态: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, meta, fmt.Errorf("读取响应数据失败: %w", err)
+	}
+
+	meta.ETag = resp.Header.Get("ETag")
+	meta.LastModified = resp.Header.Get("Last-Modified")
+	return data, meta, nil
+}
+
+// errNotModified 表示远程价格数据自上次拉取后没有变化。
+var errNotModified = errors.New("pricing data not modified")
+
+// cacheMeta 保存在缓存文件旁，记录条件请求所需的校验信息。
+type cacheMeta struct {
+	Source       string `json:"source"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// CheckedAt 是最近一次远程确认数据未变化的时间（Unix 秒）
+	CheckedAt int64 `json:"checked_at,omitempty"`
+}
+
+func (o serviceOptions) cacheMetaPath() (string, error) {
+	cachePath, err := o.cacheFilePath()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(cachePath, ".json") + ".meta.json", nil
+}
+
+func (o serviceOptions) loadCacheMeta() (cacheMeta, error) {
+	var meta cacheMeta
+	path, err := o.cacheMetaPath()
+	if err != nil {
+		return meta, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+func (o serviceOptions) saveCacheMeta(meta cacheMeta) error {
+	path, err := o.cacheMetaPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// touchCache 在远程返回 304 时刷新缓存的新鲜度，不重写价格数据。
+func (o serviceOptions) touchCache(meta cacheMeta) {
+	meta.CheckedAt = time.Now().Unix()
+	unlock := o.lockCache()
+	defer unlock()
+	if err := o.saveCacheMeta(meta); err != nil {
+		o.logf("更新价格缓存时间失败: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package modelpricing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pricingSource 返回固定价格的数据源，failing 为 true 时返回 503
+func pricingSource(price float64, failing *atomic.Bool, hits *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"m":{"input_cost_per_token":%g}}`, price)
+	}))
+}
+
+func TestFetchFallsBackToNextSource(t *testing.T) {
+	var primaryDown, secondaryDown atomic.Bool
+	var primaryHits, secondaryHits atomic.Int32
+	primaryDown.Store(true)
+	primary := pricingSource(0.000001, &primaryDown, &primaryHits)
+	defer primary.Close()
+	secondary := pricingSource(0.000002, &secondaryDown, &secondaryHits)
+	defer secondary.Close()
+
+	// 镜像优先于已有的数据源尝试
+	svc, err := New(WithSourceURLs(secondary.URL), WithMirrors(primary.URL), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	if primaryHits.Load() != 1 || secondaryHits.Load() != 1 {
+		t.Fatalf("主数据源失败后应尝试下一个数据源: %d %d", primaryHits.Load(), secondaryHits.Load())
+	}
+	if info := svc.Info(); info.Source != SourceRemote || info.SourceURL != secondary.URL {
+		t.Fatalf("应使用成功的数据源: %+v", info)
+	}
+	if got := svc.CalculateCost("m", UsageSnapshot{InputTokens: 1000}).TotalCost; math.Abs(got-0.002) > 1e-12 {
+		t.Fatalf("费用 = %v，应按备用数据源的价格计算", got)
+	}
+
+	// 主数据源恢复后优先使用
+	primaryDown.Store(false)
+	if err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("刷新失败: %v", err)
+	}
+	if info := svc.Info(); info.SourceURL != primary.URL || secondaryHits.Load() != 1 {
+		t.Fatalf("主数据源可用时不应请求备用数据源: %+v %d", info, secondaryHits.Load())
+	}
+}
+
+func TestFetchSourceTimeoutFallsBack(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	var down atomic.Bool
+	var hits atomic.Int32
+	fast := pricingSource(0.000003, &down, &hits)
+	defer fast.Close()
+
+	started := time.Now()
+	svc, err := New(WithSourceURLs(slow.URL, fast.URL), WithSourceTimeout(50*time.Millisecond), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("数据源超时后应尽快尝试下一个: %s", elapsed)
+	}
+	if info := svc.Info(); info.SourceURL != fast.URL || hits.Load() != 1 {
+		t.Fatalf("超时的数据源应被跳过: %+v", info)
+	}
+}
+
+func TestAllSourcesFailKeepsCurrentData(t *testing.T) {
+	var primaryDown, secondaryDown atomic.Bool
+	var primaryHits, secondaryHits atomic.Int32
+	primary := pricingSource(0.000001, &primaryDown, &primaryHits)
+	defer primary.Close()
+	secondary := pricingSource(0.000002, &secondaryDown, &secondaryHits)
+	defer secondary.Close()
+	cacheDir := t.TempDir()
+	opts := []Option{WithSourceURLs(primary.URL, secondary.URL), WithCacheDir(cacheDir), WithLogger(nil)}
+
+	svc, err := New(opts...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	cachePath := filepath.Join(cacheDir, cacheFileName)
+	cached, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("应写入缓存: %v", err)
+	}
+
+	// 所有数据源都失败时保留当前数据与缓存，错误中列出每个数据源
+	primaryDown.Store(true)
+	secondaryDown.Store(true)
+	err = svc.ForceRefresh(context.Background())
+	if err == nil || !strings.Contains(err.Error(), primary.URL) || !strings.Contains(err.Error(), secondary.URL) {
+		t.Fatalf("应返回所有数据源的错误: %v", err)
+	}
+	if got := svc.CalculateCost("m", UsageSnapshot{InputTokens: 1000}).TotalCost; math.Abs(got-0.001) > 1e-12 {
+		t.Fatalf("刷新失败后应保留当前价格: %v", got)
+	}
+	if data, _ := os.ReadFile(cachePath); string(data) != string(cached) {
+		t.Fatalf("刷新失败后不应修改缓存")
+	}
+	if info := svc.Info(); info.UpdateFailureTotal != 1 || info.SourceURL != primary.URL {
+		t.Fatalf("应记录失败并保留数据来源: %+v", info)
+	}
+
+	// 未过期的缓存在数据源全部不可用时仍可使用
+	fromCache, err := New(opts...)
+	if err != nil || fromCache.Info().Source != SourceCache || fromCache.CalculateCost("m", UsageSnapshot{InputTokens: 1000}).TotalCost == 0 {
+		t.Fatalf("应使用缓存的价格数据: %+v %v", fromCache.Info(), err)
+	}
+
+	// 没有缓存时回退到内置数据
+	embedded, err := New(WithSourceURLs(primary.URL, secondary.URL), WithCacheDir(t.TempDir()), WithLogger(nil))
+	if err != nil || embedded.Info().Source != SourceEmbedded || embedded.CalculateCost("claude-sonnet-4-5", UsageSnapshot{InputTokens: 1000}).TotalCost == 0 {
+		t.Fatalf("应回退到内置价格数据: %+v %v", embedded.Info(), err)
+	}
+}
+
+func TestConditionalRequestNotModified(t *testing.T) {
+	var lastIfNoneMatch, lastIfModifiedSince atomic.Value
+	var notModified atomic.Int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIfNoneMatch.Store(r.Header.Get("If-None-Match"))
+		lastIfModifiedSince.Store(r.Header.Get("If-Modified-Since"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 Oct 2026 00:00:00 GMT")
+		fmt.Fprint(w, `{"m":{"input_cost_per_token":0.000001}}`)
+	}))
+	defer source.Close()
+	cacheDir := t.TempDir()
+	// 更新间隔极短，缓存立即过期，每次创建与更新都会请求数据源
+	opts := []Option{WithSourceURLs(source.URL), WithCacheDir(cacheDir), WithUpdateInterval(time.Nanosecond), WithLogger(nil)}
+
+	svc, err := New(opts...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	meta, err := svc.options.loadCacheMeta()
+	if err != nil || meta.ETag != `"v1"` || meta.LastModified == "" || meta.Source != source.URL {
+		t.Fatalf("应保存响应的校验信息: %+v %v", meta, err)
+	}
+	version := svc.Version()
+	firstCheck := svc.Info().CheckedAt
+
+	// 304 时保留当前数据与校验信息，只刷新缓存的新鲜度
+	time.Sleep(10 * time.Millisecond)
+	svc.update(context.Background())
+	if notModified.Load() != 1 || lastIfNoneMatch.Load() != `"v1"` || lastIfModifiedSince.Load() != meta.LastModified {
+		t.Fatalf("应对上次成功的数据源发送条件请求: %v %v", lastIfNoneMatch.Load(), lastIfModifiedSince.Load())
+	}
+	if svc.Version() != version || svc.CalculateCost("m", UsageSnapshot{InputTokens: 1000}).TotalCost == 0 {
+		t.Fatalf("304 时应保留当前的价格数据")
+	}
+	info := svc.Info()
+	if !info.CheckedAt.After(firstCheck) || info.UpdateSuccessTotal != 2 || info.UpdateFailureTotal != 0 {
+		t.Fatalf("304 应计为成功并刷新检查时间: %+v", info)
+	}
+	touched, err := svc.options.loadCacheMeta()
+	if err != nil || touched.ETag != `"v1"` || touched.LastModified != meta.LastModified || touched.CheckedAt == 0 {
+		t.Fatalf("304 后应保留 ETag 并记录检查时间: %+v %v", touched, err)
+	}
+
+	// 缓存过期后重新创建的实例收到 304 时继续使用缓存的数据
+	restarted, err := New(opts...)
+	if err != nil || notModified.Load() != 2 || restarted.Version() != version || restarted.Info().Source != SourceCache {
+		t.Fatalf("304 时应使用已过期的缓存: %+v %v", restarted.Info(), err)
+	}
+
+	// 强制刷新不发送条件请求
+	if err := svc.ForceRefresh(context.Background()); err != nil || lastIfNoneMatch.Load() != "" {
+		t.Fatalf("强制刷新应重新下载: %v %v", err, lastIfNoneMatch.Load())
+	}
+
+	// 缓存数据丢失时不能依赖 304
+	if err := os.Remove(filepath.Join(cacheDir, cacheFileName)); err != nil {
+		t.Fatalf("删除缓存失败: %v", err)
+	}
+	svc.update(context.Background())
+	if lastIfNoneMatch.Load() != "" || notModified.Load() != 2 {
+		t.Fatalf("缓存丢失后应重新下载: %v", lastIfNoneMatch.Load())
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, cacheFileName)); err != nil {
+		t.Fatalf("重新下载后应写入缓存: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package modelpricing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeOfDayRule 在每天的固定时段按倍率调整模型的价格，如 DeepSeek 在北京时间 00:30-08:30 的错峰优惠：
+//
+//	{"pattern": "deepseek", "start": "00:30", "end": "08:30", "timezone": "Asia/Shanghai", "inputMultiplier": 0.5, "outputMultiplier": 0.5}
+//
+// 按顺序取第一条适用的规则，只有通过 WithRequestTime 传入请求时间时生效。
+type TimeOfDayRule struct {
+	// Pattern 为模型名包含的子串（不区分大小写），为空时匹配所有模型
+	Pattern string `json:"pattern"`
+	// Provider 为价格条目的 litellm_provider（如 deepseek），为空时不限制
+	Provider string `json:"provider,omitempty"`
+	// Start / End 为时段的开始（含）与结束（不含），格式 HH:MM；End 早于 Start 时时段跨过零点
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone 为时段所在的时区（IANA 名称，如 Asia/Shanghai），为空时使用本地时区
+	Timezone string `json:"timezone,omitempty"`
+	// InputMultiplier 调整输入、缓存、图片与音频费用，OutputMultiplier 调整输出费用，0 表示不调整
+	InputMultiplier  float64 `json:"inputMultiplier,omitempty"`
+	OutputMultiplier float64 `json:"outputMultiplier,omitempty"`
+}
+
+// timeOfDayRule 是解析后的 TimeOfDayRule，start / end 为一天中的分钟数。
+type timeOfDayRule struct {
+	TimeOfDayRule
+	start, end int
+	location   *time.Location
+}
+
+// Validate 检查时段、时区与倍率是否有效。
+func (r TimeOfDayRule) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+func (r TimeOfDayRule) compile() (timeOfDayRule, error) {
+	rule := timeOfDayRule{TimeOfDayRule: r, location: time.Local}
+	rule.Pattern = strings.ToLower(strings.TrimSpace(r.Pattern))
+	rule.Provider = strings.ToLower(strings.TrimSpace(r.Provider))
+	var err error
+	if rule.start, err = parseClock(r.Start); err != nil {
+		return rule, fmt.Errorf("无效的开始时间 %q: %w", r.Start, err)
+	}
+	if rule.end, err = parseClock(r.End); err != nil {
+		return rule, fmt.Errorf("无效的结束时间 %q: %w", r.End, err)
+	}
+	if rule.start == rule.end {
+		return rule, errors.New("开始时间与结束时间相同")
+	}
+	if zone := strings.TrimSpace(r.Timezone); zone != "" {
+		if rule.location, err = time.LoadLocation(zone); err != nil {
+			return rule, fmt.Errorf("无效的时区 %q: %w", zone, err)
+		}
+	}
+	if r.InputMultiplier < 0 || r.OutputMultiplier < 0 {
+		return rule, errors.New("倍率不能为负数")
+	}
+	if r.InputMultiplier == 0 && r.OutputMultiplier == 0 {
+		return rule, errors.New("未设置倍率")
+	}
+	return rule, nil
+}
+
+// parseClock 解析 HH:MM 格式的时间，返回一天中的分钟数。
+func parseClock(value string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, errors.New("格式应为 HH:MM")
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// WithTimeOfDayRules 设置按时段调整价格的规则，无效的规则被忽略（可先用 Validate 检查）。
+func WithTimeOfDayRules(rules ...TimeOfDayRule) Option {
+	return func(o *serviceOptions) {
+		o.timeOfDayRules = nil
+		for _, rule := range rules {
+			if compiled, err := rule.compile(); err == nil {
+				o.timeOfDayRules = append(o.timeOfDayRules, compiled)
+			}
+		}
+	}
+}
+
+// WithRequestTime 按请求时间应用 TimeOfDayRule，不传入时按全天统一的价格计算。
+func WithRequestTime(at time.Time) CostOption {
+	return func(o *costOptions) {
+		o.requestTime = at
+	}
+}
+
+// applies 返回规则是否适用于该模型在 at 时刻的请求。
+func (r timeOfDayRule) applies(model string, entry *PricingEntry, at time.Time) bool {
+	if !strings.Contains(strings.ToLower(model), r.Pattern) {
+		return false
+	}
+	if r.Provider != "" && (entry == nil || strings.ToLower(entry.LiteLLMProvider) != r.Provider) {
+		return false
+	}
+	local := at.In(r.location)
+	minute := local.Hour()*60 + local.Minute()
+	if r.start < r.end {
+		return minute >= r.start && minute < r.end
+	}
+	return minute >= r.start || minute < r.end
+}
+
+// applyTimeOfDay 按第一条适用的规则调整费用，节省的费用记录在 TimeOfDayDiscount 中（加价时为负数）。
+func (o serviceOptions) applyTimeOfDay(model string, entry *PricingEntry, at time.Time, breakdown *CostBreakdown) {
+	if at.IsZero() || !breakdown.HasPricing {
+		return
+	}
+	for _, rule := range o.timeOfDayRules {
+		if !rule.applies(model, entry, at) {
+			continue
+		}
+		inputRatio, outputRatio := rule.InputMultiplier, rule.OutputMultiplier
+		if inputRatio == 0 {
+			inputRatio = 1
+		}
+		if outputRatio == 0 {
+			outputRatio = 1
+		}
+		breakdown.TimeOfDayDiscount = scaleCost(breakdown, inputRatio, outputRatio)
+		return
+	}
+}
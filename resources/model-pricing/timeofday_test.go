@@ -0,0 +1,63 @@
+package modelpricing
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPricingTimeOfDayRules(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"deepseek-chat":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000002,"litellm_provider":"deepseek"},
+			"other-model":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000002,"litellm_provider":"other"}}`)
+	}))
+	defer source.Close()
+	svc, err := New(WithSourceURLs(source.URL), WithCacheDir(t.TempDir()), WithLogger(nil),
+		WithTimeOfDayRules(
+			TimeOfDayRule{Pattern: "deepseek", Start: "00:30", End: "08:30", Timezone: "Asia/Shanghai", InputMultiplier: 0.5, OutputMultiplier: 0.25},
+			// 跨过零点的时段，只适用于 litellm_provider 为 other 的模型
+			TimeOfDayRule{Provider: "other", Start: "22:00", End: "06:00", Timezone: "UTC", InputMultiplier: 2},
+		))
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000, OutputTokens: 1000}
+	cases := []struct {
+		model string
+		at    string
+		want  float64
+	}{
+		// UTC 17:00 为北京时间 01:00，输入 $0.0005 + 输出 $0.0005
+		{"deepseek-chat", "2026-01-01T17:00:00Z", 0.001},
+		{"deepseek-chat", "2026-01-01T04:00:00Z", 0.003},
+		{"deepseek-chat", "2026-01-01T00:30:00Z", 0.003},
+		{"other-model", "2026-01-01T23:00:00Z", 0.004},
+		{"other-model", "2026-01-01T05:59:00Z", 0.004},
+		{"other-model", "2026-01-01T06:00:00Z", 0.003},
+	}
+	for _, tc := range cases {
+		at, _ := time.Parse(time.RFC3339, tc.at)
+		cost := svc.CalculateCost(tc.model, usage, WithRequestTime(at))
+		if math.Abs(cost.TotalCost-tc.want) > 1e-12 || math.Abs(cost.TimeOfDayDiscount-(0.003-tc.want)) > 1e-12 {
+			t.Fatalf("%s 在 %s 的费用应为 %v: %+v", tc.model, tc.at, tc.want, cost)
+		}
+	}
+	// 未传入请求时间时按全天价格计算
+	if cost := svc.CalculateCost("deepseek-chat", usage); math.Abs(cost.TotalCost-0.003) > 1e-12 {
+		t.Fatalf("未传入请求时间时不应调整价格: %+v", cost)
+	}
+
+	for _, rule := range []TimeOfDayRule{
+		{Start: "25:00", End: "08:00", InputMultiplier: 0.5},
+		{Start: "08:00", End: "08:00", InputMultiplier: 0.5},
+		{Start: "00:00", End: "08:00", Timezone: "Mars/Olympus", InputMultiplier: 0.5},
+		{Start: "00:00", End: "08:00"},
+	} {
+		if err := rule.Validate(); err == nil {
+			t.Fatalf("无效的规则应返回错误: %+v", rule)
+		}
+	}
+}
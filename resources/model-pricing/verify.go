@@ -0,0 +1,137 @@
+package modelpricing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrVerificationFailed 表示远程价格数据未通过完整性校验，当前数据保持不变。
+var ErrVerificationFailed = errors.New("pricing data verification failed")
+
+const (
+	// 更新后的模型数量少于当前数量的该比例时拒绝更新
+	defaultMinRetainRatio = 0.5
+	// 单 token 价格（美元）的上限，超出视为数据错误
+	defaultMaxTokenPrice = 1.0
+)
+
+// WithSHA256 只接受 SHA256 摘要（十六进制）与其中之一相同的数据，适用于发布固定文件的镜像。
+func WithSHA256(digests ...string) Option {
+	return func(o *serviceOptions) {
+		o.sha256Pins = nil
+		for _, digest := range digests {
+			if digest = strings.ToLower(strings.TrimSpace(digest)); digest != "" {
+				o.sha256Pins = append(o.sha256Pins, digest)
+			}
+		}
+	}
+}
+
+// WithMinModels 拒绝模型数量少于 n 的数据。
+func WithMinModels(n int) Option {
+	return func(o *serviceOptions) {
+		if n >= 0 {
+			o.minModels = n
+		}
+	}
+}
+
+// WithMinRetainRatio 拒绝模型数量少于当前数量 ratio 倍的更新（默认 0.5），0 表示不检查。
+func WithMinRetainRatio(ratio float64) Option {
+	return func(o *serviceOptions) {
+		if ratio >= 0 && ratio <= 1 {
+			o.minRetainRatio = ratio
+		}
+	}
+}
+
+// WithMaxTokenPrice 设置单 token 价格（美元）的上限（默认 1），任一模型超出时拒绝整份数据。
+func WithMaxTokenPrice(price float64) Option {
+	return func(o *serviceOptions) {
+		if price > 0 {
+			o.maxTokenPrice = price
+		}
+	}
+}
+
+// verifyChecksum 校验数据的 SHA256 摘要，未配置摘要时不检查。
+func (o serviceOptions) verifyChecksum(data []byte) error {
+	if len(o.sha256Pins) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	for _, pin := range o.sha256Pins {
+		if pin == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: SHA256 %s 与配置的摘要不一致", ErrVerificationFailed, digest)
+}
+
+// parseVerified 解析远程数据并检查模型数量与价格范围，previous 为当前使用的数据（首次加载时为 nil）。
+func (o serviceOptions) parseVerified(data []byte, previous *pricingTable) (*pricingTable, error) {
+	table, err := o.parseTable(data, true)
+	if err != nil {
+		return nil, err
+	}
+	count := table.modelCount()
+	if count < o.minModels {
+		return nil, fmt.Errorf("%w: 只有 %d 个模型，少于要求的 %d 个", ErrVerificationFailed, count, o.minModels)
+	}
+	if previous != nil && o.minRetainRatio > 0 {
+		if before := previous.modelCount(); float64(count) < float64(before)*o.minRetainRatio {
+			return nil, fmt.Errorf("%w: 模型数量从 %d 骤减到 %d", ErrVerificationFailed, before, count)
+		}
+	}
+	for name, entry := range table.pricingMap {
+		if name == sampleSpecKey {
+			continue
+		}
+		for _, price := range entry.tokenPrices() {
+			if price < 0 || math.IsNaN(price) || price > o.maxTokenPrice {
+				return nil, fmt.Errorf("%w: 模型 %s 的单 token 价格 %g 超出合理范围", ErrVerificationFailed, name, price)
+			}
+		}
+	}
+	return table, nil
+}
+
+// modelCount 返回模型数量（不含 sample_spec）。
+func (t *pricingTable) modelCount() int {
+	count := len(t.pricingMap)
+	if _, ok := t.pricingMap[sampleSpecKey]; ok {
+		count--
+	}
+	return count
+}
+
+// tokenPrices 返回按 token 计费的所有单价。
+func (e *PricingEntry) tokenPrices() []float64 {
+	return []float64{
+		e.InputCostPerToken,
+		e.OutputCostPerToken,
+		e.CacheCreationInputTokenCost,
+		e.CacheCreationInputTokenCostAbove1Hr,
+		e.CacheCreationInputTokenCostAbove200,
+		e.CacheReadInputTokenCost,
+		e.CacheReadInputTokenCostAbove200k,
+		e.InputCostPerTokenAbove200k,
+		e.InputCostPerTokenAbove128k,
+		e.OutputCostPerTokenAbove200k,
+		e.InputCostPerTokenBatches,
+		e.OutputCostPerTokenBatches,
+		e.InputCostPerAudioToken,
+		e.OutputCostPerAudioToken,
+		e.InputCostPerTokenPriority,
+		e.OutputCostPerTokenPriority,
+		e.CacheReadInputTokenCostPriority,
+		e.InputCostPerTokenFlex,
+		e.OutputCostPerTokenFlex,
+		e.CacheReadInputTokenCostFlex,
+	}
+}
@@ -0,0 +1,61 @@
+package modelpricing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPricingVerification(t *testing.T) {
+	const catalog = `{"m1":{"input_cost_per_token":0.000001},"m2":{"input_cost_per_token":0.000002},"m3":{},"m4":{}}`
+	var body atomic.Value
+	body.Store(catalog)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body.Load().(string))
+	}))
+	defer source.Close()
+	sum := sha256.Sum256([]byte(catalog))
+	base := []Option{WithSourceURLs(source.URL), WithLogger(nil)}
+
+	pinned, err := New(append(base, WithCacheDir(t.TempDir()), WithSHA256("00"+hex.EncodeToString(sum[1:])))...)
+	if err != nil || pinned.Info().Source != SourceEmbedded {
+		t.Fatalf("摘要不一致时应使用内置数据: %+v %v", pinned.Info(), err)
+	}
+	pinned, _ = New(append(base, WithCacheDir(t.TempDir()), WithSHA256(hex.EncodeToString(sum[:])))...)
+	if pinned.Info().Source != SourceRemote {
+		t.Fatalf("摘要一致时应使用远程数据: %+v", pinned.Info())
+	}
+	if svc, _ := New(append(base, WithCacheDir(t.TempDir()), WithMinModels(10))...); svc.Info().Source != SourceEmbedded {
+		t.Fatalf("模型数量不足时应使用内置数据: %+v", svc.Info())
+	}
+
+	svc, err := New(append(base, WithCacheDir(t.TempDir()))...)
+	if err != nil {
+		t.Fatalf("创建价格服务失败: %v", err)
+	}
+	usage := UsageSnapshot{InputTokens: 1000}
+	for name, data := range map[string]string{
+		"价格异常": `{"m1":{"input_cost_per_token":3},"m2":{},"m3":{},"m4":{}}`,
+		"价格为负": `{"m1":{"output_cost_per_token":-0.000001},"m2":{},"m3":{},"m4":{}}`,
+		"模型骤减": `{"m1":{"input_cost_per_token":0.000009}}`,
+	} {
+		body.Store(data)
+		if err := svc.ForceRefresh(context.Background()); !errors.Is(err, ErrVerificationFailed) {
+			t.Fatalf("%s 的数据应被拒绝: %v", name, err)
+		}
+		if got := svc.CalculateCost("m1", usage).TotalCost; math.Abs(got-0.001) > 1e-12 {
+			t.Fatalf("%s 的数据被拒绝后应保留当前价格: %v", name, got)
+		}
+	}
+	body.Store(`{"m1":{"input_cost_per_token":0.000003},"m2":{},"m3":{}}`)
+	if err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("正常数据应通过校验: %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 内置的上游认证方式
+const (
+	AuthTypeBearer = "bearer"
+	AuthTypeAPIKey = "x-api-key"
+	AuthTypeQuery  = "query"
+	AuthTypeHeader = "header"
+	AuthTypeAWS    = "aws-sigv4"
+	AuthTypeGoogle = "google-oauth"
+)
+
+// AuthConfig 描述 provider 的上游认证方式，未配置时使用 Authorization: Bearer
+type AuthConfig struct {
+	Type string `json:"type"`
+	// header 方式使用的请求头名称
+	Header string `json:"header,omitempty"`
+	// header 方式的值模板，{key} 会被替换为 API Key（默认为 {key}）
+	Template string `json:"template,omitempty"`
+	// query 方式使用的参数名（默认 key）
+	QueryParam string `json:"queryParam,omitempty"`
+	// aws-sigv4 方式的区域与服务名（默认 bedrock）
+	Region  string `json:"region,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// AuthStrategy 为发往上游的请求附加认证信息
+// Apply 在请求体设置完成之后、请求发出之前调用，签名类的方式可以读取最终的 URL 与请求体
+type AuthStrategy interface {
+	Apply(req *http.Request, apiKey string) error
+}
+
+// clientAuthHeaders 是客户端发给 relay 的认证头，转发前统一移除，由 AuthStrategy 重新设置
+var clientAuthHeaders = []string{"Authorization", "X-Api-Key"}
+
+// AuthStrategy 返回 provider 配置的认证方式
+func (p Provider) AuthStrategy() (AuthStrategy, error) {
+	if p.Auth == nil {
+		switch p.APIFormat {
+		case APIFormatGemini:
+			return headerAuth{header: "x-goog-api-key", template: "{key}"}, nil
+		case APIFormatBedrock:
+			// 未配置认证时使用 SigV4，区域取自 apiUrl
+			return p.bedrockAuth(AuthConfig{Type: AuthTypeAWS})
+		case APIFormatVertex:
+			return googleOAuthAuth{}, nil
+		}
+		if p.Azure != nil {
+			return headerAuth{header: "api-key", template: "{key}"}, nil
+		}
+		if p.Local != nil {
+			return localAuth{}, nil
+		}
+		return bearerAuth{}, nil
+	}
+	cfg := *p.Auth
+	if p.APIFormat == APIFormatBedrock && strings.ToLower(strings.TrimSpace(cfg.Type)) == AuthTypeAWS {
+		return p.bedrockAuth(cfg)
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case "", AuthTypeBearer:
+		return bearerAuth{}, nil
+	case AuthTypeAPIKey:
+		return headerAuth{header: "x-api-key", template: "{key}"}, nil
+	case AuthTypeHeader:
+		if strings.TrimSpace(cfg.Header) == "" {
+			return nil, errors.New("header 认证方式需要配置 header")
+		}
+		template := cfg.Template
+		if template == "" {
+			template = "{key}"
+		}
+		return headerAuth{header: cfg.Header, template: template}, nil
+	case AuthTypeQuery:
+		param := cfg.QueryParam
+		if param == "" {
+			param = "key"
+		}
+		return queryAuth{param: param}, nil
+	case AuthTypeAWS:
+		if strings.TrimSpace(cfg.Region) == "" {
+			return nil, errors.New("aws-sigv4 认证方式需要配置 region")
+		}
+		service := cfg.Service
+		if service == "" {
+			service = "bedrock"
+		}
+		return awsSigV4Auth{region: cfg.Region, service: service}, nil
+	case AuthTypeGoogle:
+		return googleOAuthAuth{}, nil
+	default:
+		return nil, fmt.Errorf("不支持的认证方式: %s", cfg.Type)
+	}
+}
+
+// bedrockAuth 返回 bedrock 协议的 SigV4 认证，未配置 region 时从 bedrock-runtime 的 apiUrl 中识别
+func (p Provider) bedrockAuth(cfg AuthConfig) (AuthStrategy, error) {
+	region := strings.TrimSpace(cfg.Region)
+	if region == "" {
+		region = bedrockRegion(p.APIURL)
+	}
+	if region == "" {
+		return nil, errors.New("无法从 apiUrl 识别 AWS 区域，请在 auth 中配置 region")
+	}
+	service := cfg.Service
+	if service == "" {
+		service = "bedrock"
+	}
+	return awsSigV4Auth{region: region, service: service}, nil
+}
+
+func removeClientAuth(req *http.Request) {
+	for _, header := range clientAuthHeaders {
+		req.Header.Del(header)
+	}
+}
+
+// bearerAuth 使用 Authorization: Bearer <key>（默认方式）
+type bearerAuth struct{}
+
+func (bearerAuth) Apply(req *http.Request, apiKey string) error {
+	removeClientAuth(req)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	return nil
+}
+
+// headerAuth 将 Key 写入指定请求头，如 x-api-key 或 Azure 的 api-key
+type headerAuth struct {
+	header   string
+	template string
+}
+
+func (a headerAuth) Apply(req *http.Request, apiKey string) error {
+	removeClientAuth(req)
+	req.Header.Set(a.header, strings.ReplaceAll(a.template, "{key}", apiKey))
+	return nil
+}
+
+// queryAuth 将 Key 作为查询参数发送，如 Gemini 的 ?key=
+type queryAuth struct {
+	param string
+}
+
+func (a queryAuth) Apply(req *http.Request, apiKey string) error {
+	removeClientAuth(req)
+	query := req.URL.Query()
+	query.Set(a.param, apiKey)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// awsSigV4Auth 使用 AWS Signature Version 4 签名请求
+// API Key 的格式为 "ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]"
+type awsSigV4Auth struct {
+	region  string
+	service string
+	now     func() time.Time
+}
+
+func (a awsSigV4Auth) Apply(req *http.Request, apiKey string) error {
+	parts := strings.SplitN(apiKey, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return errors.New("aws-sigv4 的 API Key 格式应为 ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]")
+	}
+	accessKey, secretKey := parts[0], parts[1]
+	removeClientAuth(req)
+
+	payload, err := requestPayload(req)
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if len(parts) == 3 && parts[2] != "" {
+		req.Header.Set("X-Amz-Security-Token", parts[2])
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	signed := map[string]string{"host": host}
+	for _, name := range []string{"Content-Type", "X-Amz-Date", "X-Amz-Content-Sha256", "X-Amz-Security-Token"} {
+		if value := req.Header.Get(name); value != "" {
+			signed[strings.ToLower(name)] = strings.TrimSpace(value)
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	if a.service != "s3" {
+		// 除 S3 外，AWS 要求对已编码的路径再编码一次
+		canonicalURI = awsEscape(canonicalURI, false)
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + a.region + "/" + a.service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, a.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// requestPayload 通过 GetBody 读取请求体副本，不消耗将要发送的 Body
+func requestPayload(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		if req.Body == nil || req.Body == http.NoBody {
+			return nil, nil
+		}
+		return nil, errors.New("请求体不可重放，无法签名")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, value := range vals {
+			pairs = append(pairs, awsEscape(key, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape 按 AWS 规则编码：只保留 A-Z a-z 0-9 - _ . ~，encodeSlash 为 false 时保留 /
+func awsEscape(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthStrategies(t *testing.T) {
+	cases := []struct {
+		auth  *AuthConfig
+		check func(req *http.Request) bool
+	}{
+		{nil, func(req *http.Request) bool {
+			return req.Header.Get("Authorization") == "Bearer sk-test" && req.Header.Get("X-Api-Key") == ""
+		}},
+		{&AuthConfig{Type: AuthTypeAPIKey}, func(req *http.Request) bool {
+			return req.Header.Get("X-Api-Key") == "sk-test" && req.Header.Get("Authorization") == ""
+		}},
+		{&AuthConfig{Type: AuthTypeQuery}, func(req *http.Request) bool {
+			return req.URL.Query().Get("key") == "sk-test" && req.URL.Query().Get("alt") == "sse" && req.Header.Get("Authorization") == ""
+		}},
+		{&AuthConfig{Type: AuthTypeHeader, Header: "api-key", Template: "Token {key}"}, func(req *http.Request) bool {
+			return req.Header.Get("Api-Key") == "Token sk-test" && req.Header.Get("X-Api-Key") == ""
+		}},
+	}
+	for _, tc := range cases {
+		strategy, err := Provider{Auth: tc.auth}.AuthStrategy()
+		if err != nil {
+			t.Fatalf("AuthStrategy(%+v) 失败: %v", tc.auth, err)
+		}
+		req, _ := http.NewRequest(http.MethodPost, "https://example.com/v1/messages?alt=sse", nil)
+		req.Header.Set("Authorization", "Bearer client-key")
+		req.Header.Set("X-Api-Key", "client-key")
+		if err := strategy.Apply(req, "sk-test"); err != nil {
+			t.Fatalf("Apply(%+v) 失败: %v", tc.auth, err)
+		}
+		if !tc.check(req) {
+			t.Fatalf("认证方式 %+v 结果不符合预期: url=%s headers=%v", tc.auth, req.URL, req.Header)
+		}
+	}
+
+	// 本地 provider 未配置 Key 时也不转发客户端的 Key
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:11434/v1/messages", nil)
+	req.Header.Set("Authorization", "Bearer client-key")
+	req.Header.Set("X-Api-Key", "client-key")
+	if err := (localAuth{}).Apply(req, ""); err != nil || req.Header.Get("Authorization") != "" || req.Header.Get("X-Api-Key") != "" {
+		t.Fatalf("本地认证不应转发客户端的 Key: %v %v", req.Header, err)
+	}
+
+	for _, invalid := range []*AuthConfig{{Type: "oauth"}, {Type: AuthTypeHeader}, {Type: AuthTypeAWS}} {
+		if _, err := (Provider{Auth: invalid}).AuthStrategy(); err == nil {
+			t.Fatalf("AuthStrategy(%+v) 应返回错误", invalid)
+		}
+		if errs := (&Provider{Name: "p", APIURL: "https://example.com", APIKey: "k", Auth: invalid}).ValidateConfiguration(); len(errs) == 0 {
+			t.Fatalf("ValidateConfiguration 应报告无效的 auth 配置 %+v", invalid)
+		}
+	}
+}
+
+// 使用 AWS SigV4 测试套件中的 get-vanilla 用例
+func TestAWSSigV4Auth(t *testing.T) {
+	auth := awsSigV4Auth{
+		region:  "us-east-1",
+		service: "service",
+		now:     func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err := auth.Apply(req, "AKIDEXAMPLE:wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"); err != nil {
+		t.Fatalf("Apply 失败: %v", err)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization = %s\nwant %s", got, want)
+	}
+
+	if err := auth.Apply(req, "missing-secret"); err == nil || !strings.Contains(err.Error(), "ACCESS_KEY_ID") {
+		t.Fatalf("格式错误的 Key 应返回错误: %v", err)
+	}
+}
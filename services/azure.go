@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Azure OpenAI 未指定 api-version 时使用的版本，同时支持 Chat Completions 与 Responses API
+const defaultAzureAPIVersion = "2025-04-01-preview"
+
+// AzureConfig 描述 Azure OpenAI 资源：请求的模型名为部署名，每个请求都需要 api-version 查询参数。
+// apiUrl 为 https://<resource>.openai.azure.com/openai，codex 的 /responses 请求直接转发，
+// claude 使用 openai-chat 协议时转发到 /deployments/<部署名>/chat/completions
+type AzureConfig struct {
+	// api-version 查询参数，默认 2025-04-01-preview
+	APIVersion string `json:"apiVersion,omitempty"`
+	// 部署名 -> 部署的模型（如 "prod-gpt4o": "gpt-4o"），用于按模型计价
+	Deployments map[string]string `json:"deployments,omitempty"`
+}
+
+func (c *AzureConfig) apiVersion() string {
+	if c == nil || strings.TrimSpace(c.APIVersion) == "" {
+		return defaultAzureAPIVersion
+	}
+	return strings.TrimSpace(c.APIVersion)
+}
+
+// upstreamQuery 返回发往 provider 的查询参数，Azure OpenAI 需要附加 api-version
+func (p *Provider) upstreamQuery(query map[string]string) map[string]string {
+	if p.Azure == nil {
+		return query
+	}
+	merged := cloneMap(query)
+	merged["api-version"] = p.Azure.apiVersion()
+	return merged
+}
+
+// pricingModel 返回计价使用的模型名，Azure 部署名按配置换成部署的模型
+func (p *Provider) pricingModel(model string) string {
+	if p.Azure != nil {
+		if target := strings.TrimSpace(p.Azure.Deployments[model]); target != "" {
+			return target
+		}
+	}
+	return model
+}
+
+// validate 检查部署映射是否完整
+func (c *AzureConfig) validate() []string {
+	var errors []string
+	for deployment, model := range c.Deployments {
+		if strings.TrimSpace(deployment) == "" || strings.TrimSpace(model) == "" {
+			errors = append(errors, fmt.Sprintf("azure.deployments 的部署名与模型不能为空: '%s' -> '%s'", deployment, model))
+		}
+	}
+	return errors
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAzureProviderDefaults(t *testing.T) {
+	provider := Provider{Azure: &AzureConfig{Deployments: map[string]string{"prod-gpt4o": "gpt-4o"}}}
+	if auth, _ := provider.AuthStrategy(); auth != (headerAuth{header: "api-key", template: "{key}"}) {
+		t.Fatalf("Azure 默认应使用 api-key 请求头: %#v", auth)
+	}
+	client := map[string]string{"foo": "bar"}
+	query := provider.upstreamQuery(client)
+	if query["api-version"] != defaultAzureAPIVersion || query["foo"] != "bar" || len(client) != 1 {
+		t.Fatalf("应在客户端参数的副本上附加 api-version: %v %v", query, client)
+	}
+	provider.Azure.APIVersion = "2024-10-21"
+	if query := provider.upstreamQuery(nil); query["api-version"] != "2024-10-21" {
+		t.Fatalf("应使用配置的 api-version: %v", query)
+	}
+	if query := (&Provider{}).upstreamQuery(client); query["api-version"] != "" {
+		t.Fatalf("非 Azure provider 不应附加 api-version: %v", query)
+	}
+
+	if endpoint := provider.dialect("claude", "/v1/messages"); endpoint != nil {
+		t.Fatalf("未配置 apiFormat 时不应转换协议")
+	}
+	provider.APIFormat = APIFormatOpenAIChat
+	if endpoint := provider.dialect("claude", "/v1/messages").endpoint("prod-gpt4o", true); endpoint != "/deployments/prod-gpt4o/chat/completions" {
+		t.Fatalf("Azure 的 Chat Completions 路径应包含部署名: %s", endpoint)
+	}
+
+	if errs := (&Provider{Name: "azure", APIURL: "https://res.openai.azure.com/openai", APIKey: "k",
+		Azure: &AzureConfig{Deployments: map[string]string{"prod": ""}}}).ValidateConfiguration(); len(errs) == 0 {
+		t.Fatalf("部署映射缺少模型时应报错")
+	}
+}
+
+func TestAzureDeploymentPricing(t *testing.T) {
+	pricing, err := modelpricing.NewServiceFromData([]byte(`{"gpt-4o": {"input_cost_per_token": 0.0000025, "output_cost_per_token": 0.00001}}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	provider := Provider{Azure: &AzureConfig{Deployments: map[string]string{"prod-east": "gpt-4o"}}, PriceMultiplier: 2}
+	usage := modelpricing.UsageSnapshot{InputTokens: 1000, OutputTokens: 100}
+	cost := pricing.CalculateCost("prod-east", usage, provider.CostOptions()...)
+	if !cost.HasPricing || cost.Match.Key != "gpt-4o" || math.Abs(cost.TotalCost-0.007) > 1e-12 {
+		t.Fatalf("部署名应按部署的模型计价: %+v", cost)
+	}
+	if cost := pricing.CalculateCost("prod-east", usage); cost.HasPricing {
+		t.Fatalf("未使用部署映射时部署名不应有价格: %+v", cost)
+	}
+	if model := provider.pricingModel("prod-east"); model != "gpt-4o" {
+		t.Fatalf("pricingModel 错误: %s", model)
+	}
+}
+
+func TestRelayForwardsToAzureResponses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwardedPath, forwardedVersion, forwardedKey, forwardedAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedPath, forwardedVersion = r.URL.Path, r.URL.Query().Get("api-version")
+		forwardedKey, forwardedAuth = r.Header.Get("api-key"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"model\":\"gpt-4o\",\"output\":[],\"usage\":{\"input_tokens\":1000,\"output_tokens\":100}}}\n\n")
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("codex", []Provider{{
+		ID: 1, Name: "azure", APIURL: upstream.URL + "/openai", APIKey: "azure-key-1234567890", Enabled: true,
+		Azure:         &AzureConfig{Deployments: map[string]string{"prod-gpt4o": "gpt-4o"}},
+		ModelRewrites: []ModelRewrite{{Match: "gpt-4o", Target: "prod-gpt4o"}},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/responses", strings.NewReader(`{"model":"gpt-4o","stream":true,"input":"hi"}`))
+	req.Header.Set("Authorization", "Bearer client-token")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedPath != "/openai/responses" || forwardedVersion != defaultAzureAPIVersion {
+		t.Fatalf("应转发到 Azure 的 Responses 接口并附加 api-version: %s %s", forwardedPath, forwardedVersion)
+	}
+	if forwardedKey != "azure-key-1234567890" || forwardedAuth != "" {
+		t.Fatalf("应使用 api-key 认证: key=%q auth=%q", forwardedKey, forwardedAuth)
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "azure"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	pricing, _ := modelpricing.DefaultService()
+	expected := pricing.CalculateCost("gpt-4o", modelpricing.UsageSnapshot{InputTokens: 1000, OutputTokens: 100})
+	if entry := requestLogFromRecord(record); entry.Model != "prod-gpt4o" || !expected.HasPricing ||
+		math.Abs(record.GetFloat64("original_cost")-expected.TotalCost) > 1e-12 {
+		t.Fatalf("部署的费用应按 gpt-4o 计算: %+v cost=%v expected=%v", entry, record.GetFloat64("original_cost"), expected.TotalCost)
+	}
+}
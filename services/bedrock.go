@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Bedrock 调用 Anthropic 模型时请求体中必须携带的版本
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// bedrockHostPattern 从 bedrock-runtime 的地址中提取区域，如 https://bedrock-runtime.us-east-1.amazonaws.com
+var bedrockHostPattern = regexp.MustCompile(`bedrock-runtime(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com`)
+
+// bedrockRegion 返回 apiUrl 对应的 AWS 区域，无法识别时返回空字符串
+func bedrockRegion(apiURL string) string {
+	if match := bedrockHostPattern.FindStringSubmatch(apiURL); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// bedrockDialect 通过 bedrock-runtime 的 InvokeModel / InvokeModelWithResponseStream 调用 Anthropic 模型。
+// 请求与响应仍是 Anthropic Messages 格式，区别在于模型 ID 在路径中、请求体需要 anthropic_version，
+// 以及流式响应使用 AWS 的 event stream 二进制帧
+type bedrockDialect struct{}
+
+// 模型 ID（如 us.anthropic.claude-sonnet-4-5-20250929-v1:0）或推理配置文件 ARN 作为一个路径段，需要编码 : 与 /
+func (bedrockDialect) endpoint(model string, stream bool) string {
+	path := "/model/" + strings.NewReplacer(":", "%3A", "/", "%2F").Replace(model)
+	if stream {
+		return path + "/invoke-with-response-stream"
+	}
+	return path + "/invoke"
+}
+
+func (bedrockDialect) translateRequest(body []byte, _ string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("请求体不是有效的 JSON")
+	}
+	// 模型与是否流式由接口路径决定，Bedrock 会拒绝请求体中的这两个字段
+	data, err := sjson.DeleteBytes(body, "model")
+	if err == nil {
+		data, err = sjson.DeleteBytes(data, "stream")
+	}
+	if err == nil && !gjson.GetBytes(data, "anthropic_version").Exists() {
+		data, err = sjson.SetBytes(data, "anthropic_version", bedrockAnthropicVersion)
+	}
+	return data, err
+}
+
+func (bedrockDialect) responseHook(_ string, stream bool, usage *upstreamUsage) xrequest.ResponseHook {
+	return anthropicUsageHook(stream, usage)
+}
+
+func (bedrockDialect) decodeStream(body io.ReadCloser) io.ReadCloser {
+	return &eventStreamReader{body: body}
+}
+
+// eventStreamReader 将 application/vnd.amazon.eventstream 的二进制帧解码为 Anthropic 的 SSE 事件。
+// 每个 chunk 帧的负载为 {"bytes": "<base64 编码的事件 JSON>"}，exception 帧转换为 error 事件
+type eventStreamReader struct {
+	body    io.ReadCloser
+	pending bytes.Buffer
+	err     error
+}
+
+func (r *eventStreamReader) Read(p []byte) (int, error) {
+	for r.pending.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.decodeFrame()
+	}
+	return r.pending.Read(p)
+}
+
+func (r *eventStreamReader) Close() error {
+	return r.body.Close()
+}
+
+// decodeFrame 读取一个帧并把对应的 SSE 事件写入 pending，流正常结束时返回 io.EOF
+func (r *eventStreamReader) decodeFrame() error {
+	var prelude [12]byte
+	if _, err := io.ReadFull(r.body, prelude[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("event stream 帧不完整: %w", err)
+		}
+		return err
+	}
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return errors.New("event stream 帧头校验失败")
+	}
+	// 帧至少包含 12 字节的前导与 4 字节的校验和，Bedrock 的单个帧不会超过 16MB
+	if totalLength < 16 || totalLength > 16<<20 || headersLength > totalLength-16 {
+		return fmt.Errorf("event stream 帧长度无效: %d", totalLength)
+	}
+	frame := make([]byte, totalLength)
+	copy(frame, prelude[:])
+	if _, err := io.ReadFull(r.body, frame[12:]); err != nil {
+		return fmt.Errorf("event stream 帧不完整: %w", err)
+	}
+	if crc32.ChecksumIEEE(frame[:totalLength-4]) != binary.BigEndian.Uint32(frame[totalLength-4:]) {
+		return errors.New("event stream 帧校验失败")
+	}
+	headers, err := eventStreamHeaders(frame[12 : 12+headersLength])
+	if err != nil {
+		return err
+	}
+	payload := frame[12+headersLength : totalLength-4]
+
+	switch headers[":message-type"] {
+	case "event":
+		if headers[":event-type"] != "chunk" {
+			return nil
+		}
+		event, err := base64.StdEncoding.DecodeString(gjson.GetBytes(payload, "bytes").String())
+		if err != nil || !gjson.ValidBytes(event) {
+			return fmt.Errorf("event stream chunk 无法解码: %v", err)
+		}
+		fmt.Fprintf(&r.pending, "event: %s\ndata: %s\n\n", gjson.GetBytes(event, "type").String(), event)
+	case "exception", "error":
+		exception := headers[":exception-type"]
+		if exception == "" {
+			exception = headers[":error-code"]
+		}
+		message := gjson.GetBytes(payload, "message").String()
+		if message == "" {
+			message = headers[":error-message"]
+		}
+		data, _ := json.Marshal(map[string]any{
+			"type":  "error",
+			"error": map[string]any{"type": bedrockErrorType(exception), "message": exception + ": " + message},
+		})
+		fmt.Fprintf(&r.pending, "event: error\ndata: %s\n\n", data)
+	}
+	return nil
+}
+
+// bedrockErrorType 将 Bedrock 的异常类型转换为 Anthropic 的错误类型，过载类异常让后续请求避开该模型
+func bedrockErrorType(exception string) string {
+	switch exception {
+	case "throttlingException":
+		return "rate_limit_error"
+	case "serviceUnavailableException", "modelNotReadyException":
+		return "overloaded_error"
+	case "validationException":
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+// eventStreamHeaders 解析帧头，只保留字符串类型的值
+func eventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	invalid := errors.New("event stream 帧头格式无效")
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 2+nameLength {
+			return nil, invalid
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+		// 各类型值的长度：0/1 为布尔，2 byte，3 short，4 int，5 long，6 bytes，7 string，8 timestamp，9 uuid
+		size := 0
+		switch valueType {
+		case 0, 1:
+		case 2:
+			size = 1
+		case 3:
+			size = 2
+		case 4:
+			size = 4
+		case 5, 8:
+			size = 8
+		case 9:
+			size = 16
+		case 6, 7:
+			if len(data) < 2 {
+				return nil, invalid
+			}
+			size = int(binary.BigEndian.Uint16(data[:2]))
+			data = data[2:]
+		default:
+			return nil, invalid
+		}
+		if len(data) < size {
+			return nil, invalid
+		}
+		if valueType == 7 {
+			headers[name] = string(data[:size])
+		}
+		data = data[size:]
+	}
+	return headers, nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// eventStreamFrame 按 AWS event stream 格式编码一个帧，headers 均为字符串类型
+func eventStreamFrame(headers [][2]string, payload []byte) []byte {
+	var encoded bytes.Buffer
+	for _, header := range headers {
+		encoded.WriteByte(byte(len(header[0])))
+		encoded.WriteString(header[0])
+		encoded.WriteByte(7)
+		binary.Write(&encoded, binary.BigEndian, uint16(len(header[1])))
+		encoded.WriteString(header[1])
+	}
+	total := 12 + encoded.Len() + len(payload) + 4
+	frame := make([]byte, 0, total)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(total))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(encoded.Len()))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	frame = append(frame, encoded.Bytes()...)
+	frame = append(frame, payload...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
+
+func bedrockChunk(event string) []byte {
+	payload := `{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(event)) + `","p":"abcd"}`
+	return eventStreamFrame([][2]string{{":event-type", "chunk"}, {":content-type", "application/json"}, {":message-type", "event"}}, []byte(payload))
+}
+
+func bedrockStream() []byte {
+	var stream bytes.Buffer
+	for _, event := range []string{
+		`{"type":"message_start","message":{"id":"msg_bdrk_1","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[],"usage":{"input_tokens":25,"cache_read_input_tokens":100,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":25,"outputTokenCount":15}}`,
+	} {
+		stream.Write(bedrockChunk(event))
+	}
+	return stream.Bytes()
+}
+
+func TestBedrockEventStreamDecoding(t *testing.T) {
+	decoded, err := io.ReadAll(bedrockDialect{}.decodeStream(io.NopCloser(bytes.NewReader(bedrockStream()))))
+	if err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	events := streamEvents(t, string(decoded))
+	if len(events) != 6 || events[0].Get("message.id").String() != "msg_bdrk_1" || events[2].Get("delta.text").String() != "Hello" {
+		t.Fatalf("应解码为 Anthropic 事件: %s", decoded)
+	}
+	if !strings.HasPrefix(string(decoded), "event: message_start\ndata: ") {
+		t.Fatalf("SSE 事件应带有事件名: %s", decoded)
+	}
+
+	exception := eventStreamFrame([][2]string{{":exception-type", "serviceUnavailableException"}, {":message-type", "exception"}},
+		[]byte(`{"message":"Bedrock is unable to process your request."}`))
+	decoded, err = io.ReadAll(bedrockDialect{}.decodeStream(io.NopCloser(bytes.NewReader(append(bedrockChunk(`{"type":"ping"}`), exception...)))))
+	if err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	if events := streamEvents(t, string(decoded)); len(events) != 2 || events[1].Get("error.type").String() != "overloaded_error" {
+		t.Fatalf("异常帧应转换为 overloaded_error: %s", decoded)
+	}
+
+	corrupted := bedrockStream()
+	corrupted[len(corrupted)-1] ^= 0xff
+	if _, err := io.ReadAll(bedrockDialect{}.decodeStream(io.NopCloser(bytes.NewReader(corrupted)))); err == nil {
+		t.Fatalf("校验和错误的帧应返回错误")
+	}
+	if _, err := io.ReadAll(bedrockDialect{}.decodeStream(io.NopCloser(bytes.NewReader(bedrockStream()[:40])))); err == nil {
+		t.Fatalf("不完整的帧应返回错误")
+	}
+}
+
+func TestBedrockRequestTranslation(t *testing.T) {
+	data, err := bedrockDialect{}.translateRequest([]byte(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`), "")
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	request := gjson.ParseBytes(data)
+	if request.Get("model").Exists() || request.Get("stream").Exists() || request.Get("anthropic_version").String() != bedrockAnthropicVersion ||
+		request.Get("messages.0.content").String() != "hi" {
+		t.Fatalf("请求体转换错误: %s", data)
+	}
+	if endpoint := (bedrockDialect{}).endpoint("us.anthropic.claude-sonnet-4-5-20250929-v1:0", true); endpoint !=
+		"/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke-with-response-stream" {
+		t.Fatalf("模型 ID 应编码后放入路径: %s", endpoint)
+	}
+	if endpoint := (bedrockDialect{}).endpoint("arn:aws:bedrock:us-east-1:123456789012:application-inference-profile/abc", false); endpoint !=
+		"/model/arn%3Aaws%3Abedrock%3Aus-east-1%3A123456789012%3Aapplication-inference-profile%2Fabc/invoke" {
+		t.Fatalf("ARN 应作为一个路径段: %s", endpoint)
+	}
+}
+
+func TestBedrockAuth(t *testing.T) {
+	provider := Provider{APIURL: "https://bedrock-runtime.eu-west-1.amazonaws.com", APIFormat: APIFormatBedrock}
+	auth, err := provider.AuthStrategy()
+	if err != nil {
+		t.Fatalf("获取认证方式失败: %v", err)
+	}
+	if sigv4, ok := auth.(awsSigV4Auth); !ok || sigv4.region != "eu-west-1" || sigv4.service != "bedrock" {
+		t.Fatalf("未配置认证时应使用 apiUrl 区域的 SigV4: %#v", auth)
+	}
+
+	provider.Auth = &AuthConfig{Type: AuthTypeAWS, Region: "us-west-2"}
+	if auth, _ := provider.AuthStrategy(); auth.(awsSigV4Auth).region != "us-west-2" {
+		t.Fatalf("配置的 region 应优先: %#v", auth)
+	}
+	provider.Auth = &AuthConfig{Type: AuthTypeBearer}
+	if auth, _ := provider.AuthStrategy(); auth != (bearerAuth{}) {
+		t.Fatalf("Bedrock API Key 应可使用 bearer 认证: %#v", auth)
+	}
+	if _, err := (Provider{APIURL: "https://bedrock.example.com", APIFormat: APIFormatBedrock}).AuthStrategy(); err == nil {
+		t.Fatalf("无法识别区域时应报错")
+	}
+}
+
+func TestBedrockModelPricing(t *testing.T) {
+	pricing, err := modelpricing.NewServiceFromData([]byte(`{
+		"claude-opus-4-5-20251101": {"input_cost_per_token": 0.000005},
+		"us.anthropic.claude-sonnet-4-5-20250929-v1:0": {"input_cost_per_token": 0.0000033}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	cases := map[string]string{
+		"us.anthropic.claude-sonnet-4-5-20250929-v1:0":                                                          "us.anthropic.claude-sonnet-4-5-20250929-v1:0",
+		"apac.anthropic.claude-opus-4-5-20251101-v1:0":                                                          "claude-opus-4-5-20251101",
+		"global.anthropic.claude-opus-4-5-20251101-v1:0":                                                        "claude-opus-4-5-20251101",
+		"arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-sonnet-4-5-20250929-v1:0": "us.anthropic.claude-sonnet-4-5-20250929-v1:0",
+	}
+	for model, key := range cases {
+		if match := pricing.MatchModel(model); match.Key != key || match.Strategy == modelpricing.MatchFuzzy {
+			t.Errorf("%s 应匹配 %s: %+v", model, key, match)
+		}
+	}
+}
+
+func TestRelayForwardsToBedrock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwardedPath, forwardedAuth string
+	var forwardedBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedBody, _ = io.ReadAll(r.Body)
+		forwardedPath, forwardedAuth = r.URL.EscapedPath(), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(bedrockStream())
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "bedrock", APIURL: upstream.URL, APIKey: "AKIDEXAMPLE:wJalrXUtnFEMI/K7MDENG", Enabled: true,
+		APIFormat: APIFormatBedrock, Auth: &AuthConfig{Type: AuthTypeAWS, Region: "us-east-1"},
+		ModelRewrites: []ModelRewrite{{Match: "claude-sonnet-4-5", Target: "us.anthropic.claude-sonnet-4-5-20250929-v1:0"}},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedPath != "/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke-with-response-stream" {
+		t.Fatalf("应转发到 invoke-with-response-stream 接口: %s", forwardedPath)
+	}
+	if !strings.HasPrefix(forwardedAuth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(forwardedAuth, "/us-east-1/bedrock/aws4_request") {
+		t.Fatalf("请求应使用 SigV4 签名: %s", forwardedAuth)
+	}
+	if gjson.GetBytes(forwardedBody, "model").Exists() || gjson.GetBytes(forwardedBody, "anthropic_version").String() != bedrockAnthropicVersion {
+		t.Fatalf("请求体应转换为 Bedrock 格式: %s", forwardedBody)
+	}
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("响应应为 SSE: %s", rec.Header().Get("Content-Type"))
+	}
+	if events := streamEvents(t, rec.Body.String()); len(events) != 6 || events[5].Get("type").String() != "message_stop" {
+		t.Fatalf("event stream 应解码为 Anthropic 事件: %s", rec.Body.String())
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "bedrock"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); entry.InputTokens != 25 || entry.CacheReadTokens != 100 || entry.OutputTokens != 15 ||
+		entry.Model != "us.anthropic.claude-sonnet-4-5-20250929-v1:0" {
+		t.Fatalf("Bedrock 的用量应计入日志: %+v", entry)
+	}
+}
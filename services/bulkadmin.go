@@ -0,0 +1,370 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 批量操作支持的动作，均作用于 provider；客户端 Key 没有各自的预算（client.dailyBudget 是整个 relay 的预算），
+// 因此不提供按团队批量设置预算的操作
+const (
+	BulkActionEnable       = "enable"
+	BulkActionDisable      = "disable"
+	BulkActionSetLevel     = "set-level"
+	BulkActionSetRateLimit = "set-rate-limit"
+	BulkActionAddTag       = "add-tag"
+	BulkActionRemoveTag    = "remove-tag"
+)
+
+// ProviderSelector 选择批量操作的目标，各条件需同时满足，全部为空时选择所有 provider
+type ProviderSelector struct {
+	// 包含任一标签即匹配
+	Tags  []string `json:"tags,omitempty"`
+	Names []string `json:"names,omitempty"`
+	// 名称通配符，如 "team-a-*"
+	NamePattern string `json:"namePattern,omitempty"`
+}
+
+// BulkProviderRequest 描述一次批量修改
+type BulkProviderRequest struct {
+	Platform string           `json:"platform"`
+	Selector ProviderSelector `json:"selector"`
+	Action   string           `json:"action"`
+	// add-tag / remove-tag 使用的标签
+	Tag string `json:"tag,omitempty"`
+	// set-level 使用的优先级
+	Level int `json:"level,omitempty"`
+	// set-rate-limit 使用的节流参数
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	RequestBurst      int     `json:"requestBurst,omitempty"`
+	DryRun            bool    `json:"dryRun"`
+}
+
+// BulkProviderChange 描述单个 provider 的一项变更
+type BulkProviderChange struct {
+	Provider string `json:"provider"`
+	Field    string `json:"field"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+// BulkProviderResult 描述批量操作的结果，DryRun 时只展示将要发生的变更
+type BulkProviderResult struct {
+	Platform string               `json:"platform"`
+	Action   string               `json:"action"`
+	DryRun   bool                 `json:"dryRun"`
+	Matched  []string             `json:"matched"`
+	Created  []string             `json:"created"`
+	Changes  []BulkProviderChange `json:"changes"`
+}
+
+// HasTag 判断 provider 是否带有指定标签（不区分大小写）
+func (p Provider) HasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if strings.EqualFold(strings.TrimSpace(t), strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 判断 provider 是否满足选择条件
+func (s ProviderSelector) Match(p Provider) bool {
+	if len(s.Tags) > 0 {
+		matched := false
+		for _, tag := range s.Tags {
+			if p.HasTag(tag) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.Names) > 0 {
+		matched := false
+		for _, name := range s.Names {
+			if name == p.Name {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if s.NamePattern != "" && !matchWildcard(s.NamePattern, p.Name) {
+		return false
+	}
+	return true
+}
+
+// BulkUpdateProviders 对选中的 provider 批量执行同一项修改，所有变更一次性校验并保存
+func (ps *ProviderService) BulkUpdateProviders(req BulkProviderRequest) (BulkProviderResult, error) {
+	result := BulkProviderResult{
+		Platform: req.Platform,
+		Action:   req.Action,
+		DryRun:   req.DryRun,
+		Matched:  []string{},
+		Created:  []string{},
+		Changes:  []BulkProviderChange{},
+	}
+	apply, err := bulkAction(req)
+	if err != nil {
+		return result, err
+	}
+	providers, err := ps.LoadProviders(req.Platform)
+	if err != nil {
+		return result, err
+	}
+	for i := range providers {
+		if !req.Selector.Match(providers[i]) {
+			continue
+		}
+		result.Matched = append(result.Matched, providers[i].Name)
+		if field, before, after, changed := apply(&providers[i]); changed {
+			result.Changes = append(result.Changes, BulkProviderChange{
+				Provider: providers[i].Name,
+				Field:    field,
+				Before:   before,
+				After:    after,
+			})
+		}
+	}
+	if req.DryRun || len(result.Changes) == 0 {
+		return result, nil
+	}
+	return result, ps.SaveProviders(req.Platform, providers)
+}
+
+// bulkAction 返回修改单个 provider 的函数，changed=false 表示该 provider 已是目标状态
+func bulkAction(req BulkProviderRequest) (func(p *Provider) (field, before, after string, changed bool), error) {
+	switch req.Action {
+	case BulkActionEnable, BulkActionDisable:
+		enabled := req.Action == BulkActionEnable
+		return func(p *Provider) (string, string, string, bool) {
+			before := p.Enabled
+			p.Enabled = enabled
+			return "enabled", strconv.FormatBool(before), strconv.FormatBool(enabled), before != enabled
+		}, nil
+	case BulkActionSetLevel:
+		if req.Level < 1 || req.Level > 10 {
+			return nil, fmt.Errorf("level 必须在 1-10 之间: %d", req.Level)
+		}
+		return func(p *Provider) (string, string, string, bool) {
+			before := p.Level
+			p.Level = req.Level
+			return "level", strconv.Itoa(before), strconv.Itoa(req.Level), before != req.Level
+		}, nil
+	case BulkActionSetRateLimit:
+		if req.RequestsPerSecond < 0 || req.RequestBurst < 0 {
+			return nil, errors.New("节流参数不能为负数")
+		}
+		return func(p *Provider) (string, string, string, bool) {
+			before := fmt.Sprintf("%g/s burst %d", p.RequestsPerSecond, p.RequestBurst)
+			after := fmt.Sprintf("%g/s burst %d", req.RequestsPerSecond, req.RequestBurst)
+			p.RequestsPerSecond = req.RequestsPerSecond
+			p.RequestBurst = req.RequestBurst
+			return "rateLimit", before, after, before != after
+		}, nil
+	case BulkActionAddTag, BulkActionRemoveTag:
+		tag := strings.TrimSpace(req.Tag)
+		if tag == "" {
+			return nil, fmt.Errorf("%s 需要指定 tag", req.Action)
+		}
+		add := req.Action == BulkActionAddTag
+		return func(p *Provider) (string, string, string, bool) {
+			before := strings.Join(p.Tags, ",")
+			if add == p.HasTag(tag) {
+				return "tags", before, before, false
+			}
+			if add {
+				p.Tags = append(p.Tags, tag)
+			} else {
+				kept := p.Tags[:0:0]
+				for _, t := range p.Tags {
+					if !strings.EqualFold(strings.TrimSpace(t), tag) {
+						kept = append(kept, t)
+					}
+				}
+				p.Tags = kept
+			}
+			return "tags", before, strings.Join(p.Tags, ","), true
+		}, nil
+	default:
+		return nil, fmt.Errorf("不支持的批量操作: %s", req.Action)
+	}
+}
+
+// ImportProvidersCSV 根据 CSV 批量创建 provider
+// 第一行为表头，必须包含 name、apiUrl 列；可选 apiKey、apiKeys、tags（多个值以 ; 分隔）、level、enabled、officialSite
+// 任一行有误时不写入任何 provider
+func (ps *ProviderService) ImportProvidersCSV(kind string, data []byte, dryRun bool) (BulkProviderResult, error) {
+	result := BulkProviderResult{
+		Platform: kind,
+		Action:   "import-csv",
+		DryRun:   dryRun,
+		Matched:  []string{},
+		Created:  []string{},
+		Changes:  []BulkProviderChange{},
+	}
+	providers, err := ps.LoadProviders(kind)
+	if err != nil {
+		return result, err
+	}
+	existing := make(map[string]bool, len(providers))
+	nextID := 0
+	for _, p := range providers {
+		existing[p.Name] = true
+		if p.ID > nextID {
+			nextID = p.ID
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return result, fmt.Errorf("读取 CSV 表头失败: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "apiurl"} {
+		if _, ok := columns[required]; !ok {
+			return result, fmt.Errorf("CSV 缺少 %s 列", required)
+		}
+	}
+
+	var rowErrors []string
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("解析 CSV 第 %d 行失败: %w", line, err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		provider, err := providerFromCSVRow(field)
+		if err == nil && existing[provider.Name] {
+			err = fmt.Errorf("provider %s 已存在", provider.Name)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("第 %d 行: %v", line, err))
+			continue
+		}
+		nextID++
+		provider.ID = nextID
+		existing[provider.Name] = true
+		providers = append(providers, provider)
+		result.Created = append(result.Created, provider.Name)
+	}
+	if len(rowErrors) > 0 {
+		return result, fmt.Errorf("CSV 校验失败：\n  - %s", strings.Join(rowErrors, "\n  - "))
+	}
+	if dryRun || len(result.Created) == 0 {
+		return result, nil
+	}
+	return result, ps.SaveProviders(kind, providers)
+}
+
+func providerFromCSVRow(field func(name string) string) (Provider, error) {
+	provider := Provider{
+		Name:    field("name"),
+		APIURL:  field("apiurl"),
+		APIKey:  field("apikey"),
+		APIKeys: splitCSVList(field("apikeys")),
+		Tags:    splitCSVList(field("tags")),
+		Site:    field("officialsite"),
+		Enabled: true,
+	}
+	if provider.Name == "" || provider.APIURL == "" {
+		return provider, errors.New("name 与 apiUrl 不能为空")
+	}
+	if raw := field("level"); raw != "" {
+		level, err := strconv.Atoi(raw)
+		if err != nil {
+			return provider, fmt.Errorf("无效的 level: %s", raw)
+		}
+		provider.Level = level
+	}
+	if raw := field("enabled"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return provider, fmt.Errorf("无效的 enabled: %s", raw)
+		}
+		provider.Enabled = enabled
+	}
+	if errs := provider.ValidateConfiguration(); len(errs) > 0 {
+		return provider, errors.New(strings.Join(errs, "; "))
+	}
+	return provider, nil
+}
+
+func splitCSVList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// registerBulkRoutes 注册批量管理接口，dry_run=true 时只返回将要发生的变更
+func (prs *ProviderRelayService) registerBulkRoutes(admin gin.IRouter) {
+	admin.POST("/providers/bulk", func(c *gin.Context) {
+		if !requireContentType(c, "application/json") {
+			return
+		}
+		var req BulkProviderRequest
+		if body, err := io.ReadAll(c.Request.Body); err != nil || json.Unmarshal(body, &req) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		if dryRun, _ := strconv.ParseBool(c.Query("dry_run")); dryRun {
+			req.DryRun = true
+		}
+		result, err := prs.providerService.BulkUpdateProviders(req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+	// 请求体为 CSV 内容，格式见 ImportProvidersCSV
+	admin.POST("/providers/import/:platform", func(c *gin.Context) {
+		if !requireContentType(c, "text/csv") {
+			return
+		}
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil || len(bytes.TrimSpace(data)) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+		result, err := prs.providerService.ImportProvidersCSV(c.Param("platform"), data, dryRun)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
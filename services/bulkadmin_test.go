@@ -0,0 +1,195 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBulkUpdateProviders(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ps := NewProviderService()
+	providers := []Provider{
+		{ID: 1, Name: "team-a-1", APIURL: "https://a1.example.com", APIKey: "k", Enabled: true, Tags: []string{"team-a"}},
+		{ID: 2, Name: "team-a-2", APIURL: "https://a2.example.com", APIKey: "k", Enabled: true, Tags: []string{"Team-A", "backup"}},
+		{ID: 3, Name: "team-b-1", APIURL: "https://b1.example.com", APIKey: "k", Enabled: true, Tags: []string{"team-b"}},
+	}
+	if err := ps.SaveProviders("claude", providers); err != nil {
+		t.Fatalf("SaveProviders 失败: %v", err)
+	}
+
+	req := BulkProviderRequest{Platform: "claude", Selector: ProviderSelector{Tags: []string{"team-a"}}, Action: BulkActionDisable, DryRun: true}
+	result, err := ps.BulkUpdateProviders(req)
+	if err != nil || len(result.Matched) != 2 || len(result.Changes) != 2 {
+		t.Fatalf("dry-run 结果不正确: %+v, %v", result, err)
+	}
+	loaded, _ := ps.LoadProviders("claude")
+	if !loaded[0].Enabled || !loaded[1].Enabled {
+		t.Fatalf("dry-run 不应写入配置")
+	}
+
+	req.DryRun = false
+	if _, err := ps.BulkUpdateProviders(req); err != nil {
+		t.Fatalf("BulkUpdateProviders 失败: %v", err)
+	}
+	loaded, _ = ps.LoadProviders("claude")
+	if loaded[0].Enabled || loaded[1].Enabled || !loaded[2].Enabled {
+		t.Fatalf("只有 team-a 的 provider 应被禁用: %+v", loaded)
+	}
+
+	// 已是目标状态时不产生变更
+	result, _ = ps.BulkUpdateProviders(req)
+	if len(result.Changes) != 0 {
+		t.Fatalf("重复操作不应产生变更: %+v", result.Changes)
+	}
+
+	result, err = ps.BulkUpdateProviders(BulkProviderRequest{Platform: "claude", Selector: ProviderSelector{NamePattern: "team-*-1"}, Action: BulkActionAddTag, Tag: "primary"})
+	if err != nil || len(result.Changes) != 2 {
+		t.Fatalf("按名称通配符添加标签失败: %+v, %v", result, err)
+	}
+	if _, err := ps.BulkUpdateProviders(BulkProviderRequest{Platform: "claude", Action: BulkActionSetLevel, Level: 42}); err == nil {
+		t.Fatalf("无效的 level 应报错")
+	}
+}
+
+func TestImportProvidersCSV(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ps := NewProviderService()
+	csvData := "name,apiUrl,apiKey,tags,level\n" +
+		"relay-1,https://r1.example.com,sk-1,team-c;primary,1\n" +
+		"relay-2,https://r2.example.com,sk-2,team-c,2\n"
+
+	result, err := ps.ImportProvidersCSV("codex", []byte(csvData), true)
+	if err != nil || len(result.Created) != 2 {
+		t.Fatalf("dry-run 结果不正确: %+v, %v", result, err)
+	}
+	if loaded, _ := ps.LoadProviders("codex"); len(loaded) != 0 {
+		t.Fatalf("dry-run 不应写入配置")
+	}
+
+	if _, err := ps.ImportProvidersCSV("codex", []byte(csvData), false); err != nil {
+		t.Fatalf("ImportProvidersCSV 失败: %v", err)
+	}
+	loaded, _ := ps.LoadProviders("codex")
+	if len(loaded) != 2 || loaded[1].ID != 2 || !loaded[0].HasTag("primary") || loaded[1].Level != 2 {
+		t.Fatalf("导入结果不正确: %+v", loaded)
+	}
+
+	// 重复的名称与缺失字段会让整个导入失败
+	_, err = ps.ImportProvidersCSV("codex", []byte("name,apiUrl\nrelay-1,https://x.example.com\n,https://y.example.com\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "已存在") || !strings.Contains(err.Error(), "第 3 行") {
+		t.Fatalf("应报告每一行的错误: %v", err)
+	}
+	if loaded, _ := ps.LoadProviders("codex"); len(loaded) != 2 {
+		t.Fatalf("校验失败时不应写入任何 provider")
+	}
+}
+
+func TestBulkAdminRoutes(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "team-a-1", APIURL: "https://a1.example.com", APIKey: "k", Enabled: true, Tags: []string{"team-a"}},
+		{ID: 2, Name: "team-b-1", APIURL: "https://b1.example.com", APIKey: "k", Enabled: true, Tags: []string{"team-b"}},
+	}); err != nil {
+		t.Fatalf("SaveProviders 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	send := func(path, remote, contentType, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = remote
+		req.Host = "127.0.0.1:18100"
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			if k == "Host" {
+				req.Host = v
+			} else {
+				req.Header.Set(k, v)
+			}
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	const local = "127.0.0.1:4321"
+	post := func(path, remote, body string) *httptest.ResponseRecorder {
+		contentType := "application/json"
+		if strings.HasPrefix(path, "/admin/providers/import/") {
+			contentType = "text/csv"
+		}
+		return send(path, remote, contentType, body, nil)
+	}
+	disable := `{"platform":"claude","selector":{"tags":["team-a"]},"action":"disable"}`
+
+	if rec := post("/admin/providers/bulk", "192.0.2.10:4321", disable); rec.Code != http.StatusForbidden {
+		t.Fatalf("非本机请求应被拒绝: %d", rec.Code)
+	}
+	// 网页发起的跨站请求：无需预检的 text/plain、带 Origin 的请求与 DNS rebinding 后的 Host 都应被拒绝
+	for _, tc := range []struct {
+		name        string
+		path        string
+		contentType string
+		header      map[string]string
+		want        int
+	}{
+		{"text/plain", "/admin/providers/bulk", "text/plain", nil, http.StatusUnsupportedMediaType},
+		{"跨站 text/plain", "/admin/providers/bulk", "text/plain", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"跨站 JSON", "/admin/providers/bulk", "application/json", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"DNS rebinding", "/admin/providers/bulk", "application/json", map[string]string{"Host": "evil.example:18100"}, http.StatusForbidden},
+		{"CSV 使用 text/plain", "/admin/providers/import/claude", "text/plain", nil, http.StatusUnsupportedMediaType},
+	} {
+		body := disable
+		if strings.Contains(tc.path, "import") {
+			body = "name,apiUrl\nevil,https://evil.example\n"
+		}
+		if rec := send(tc.path, local, tc.contentType, body, tc.header); rec.Code != tc.want {
+			t.Fatalf("%s: 应返回 %d，实际 %d %s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+	if loaded, _ := ps.LoadProviders("claude"); len(loaded) != 2 || !loaded[0].Enabled {
+		t.Fatalf("被拒绝的请求不应修改配置: %+v", loaded)
+	}
+	rec := post("/admin/providers/bulk?dry_run=true", local, disable)
+	var result BulkProviderResult
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &result) != nil || !result.DryRun ||
+		strings.Join(result.Matched, ",") != "team-a-1" || len(result.Changes) != 1 {
+		t.Fatalf("dry_run 应返回将要发生的变更: %d %s", rec.Code, rec.Body.String())
+	}
+	if loaded, _ := ps.LoadProviders("claude"); !loaded[0].Enabled {
+		t.Fatalf("dry_run 不应写入配置")
+	}
+	if rec := post("/admin/providers/bulk", local, disable); rec.Code != http.StatusOK {
+		t.Fatalf("批量禁用失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if loaded, _ := ps.LoadProviders("claude"); loaded[0].Enabled || !loaded[1].Enabled {
+		t.Fatalf("只有 team-a 的 provider 应被禁用: %+v", loaded)
+	}
+	if rec := post("/admin/providers/bulk", local, `{"platform":"claude","action":"explode"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("不支持的操作应返回 400: %d", rec.Code)
+	}
+
+	csvData := "name,apiUrl,apiKey,tags\nrelay-1,https://r1.example.com,sk-1,team-c\nrelay-2,https://r2.example.com,sk-2,team-c\n"
+	rec = post("/admin/providers/import/codex?dry_run=true", local, csvData)
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &result) != nil || !result.DryRun || len(result.Created) != 2 {
+		t.Fatalf("CSV dry_run 应返回将要创建的 provider: %d %s", rec.Code, rec.Body.String())
+	}
+	if loaded, _ := ps.LoadProviders("codex"); len(loaded) != 0 {
+		t.Fatalf("dry_run 不应写入配置")
+	}
+	if rec := post("/admin/providers/import/codex", local, csvData); rec.Code != http.StatusOK {
+		t.Fatalf("CSV 导入失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if loaded, _ := ps.LoadProviders("codex"); len(loaded) != 2 || !loaded[1].HasTag("team-c") {
+		t.Fatalf("导入结果不正确: %+v", loaded)
+	}
+	if rec := post("/admin/providers/import/codex", local, csvData); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "已存在") {
+		t.Fatalf("重复导入应返回每一行的错误: %d %s", rec.Code, rec.Body.String())
+	}
+}
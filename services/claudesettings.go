@@ -1,8 +1,10 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -52,52 +54,204 @@ func (css *ClaudeSettingsService) ProxyStatus() (ClaudeProxyStatus, error) {
 	return status, nil
 }
 
+// EnableProxy 让 Claude Code 通过 relay 请求，等同于不指定模型的 Apply
 func (css *ClaudeSettingsService) EnableProxy() error {
+	_, err := css.Apply(ClaudeApplyOptions{})
+	return err
+}
+
+// DisableProxy 恢复 EnableProxy 之前的配置，见 Unapply
+func (css *ClaudeSettingsService) DisableProxy() error {
+	_, err := css.Unapply()
+	return err
+}
+
+// claudeManagedEnv 是 Apply 会修改的环境变量，Unapply 时恢复为 Apply 之前的值
+var claudeManagedEnv = []string{
+	"ANTHROPIC_BASE_URL",
+	"ANTHROPIC_AUTH_TOKEN",
+	"ANTHROPIC_API_KEY",
+	"ANTHROPIC_MODEL",
+	"ANTHROPIC_DEFAULT_OPUS_MODEL",
+	"ANTHROPIC_DEFAULT_SONNET_MODEL",
+	"ANTHROPIC_DEFAULT_HAIKU_MODEL",
+}
+
+// ClaudeApplyOptions 是写入 Claude Code settings.json 的内容，模型留空时保留原有的设置
+type ClaudeApplyOptions struct {
+	// relay 地址，留空时使用本机 relay 的监听地址
+	BaseURL string `json:"baseUrl"`
+	// 默认使用的模型（ANTHROPIC_MODEL）
+	Model string `json:"model"`
+	// opus、sonnet、haiku 档位对应的模型（ANTHROPIC_DEFAULT_*_MODEL）
+	OpusModel   string `json:"opusModel"`
+	SonnetModel string `json:"sonnetModel"`
+	HaikuModel  string `json:"haikuModel"`
+	// 只返回修改后的内容，不写入文件
+	DryRun bool `json:"dryRun"`
+}
+
+// Apply 修改 settings.json 中的 env，使 Claude Code 通过 relay 请求，其余设置保持不变；
+// 首次 Apply 时备份原文件（不存在时记录为空配置），重复 Apply 不会覆盖备份
+func (css *ClaudeSettingsService) Apply(opts ClaudeApplyOptions) (ClientApplyResult, error) {
 	settingsPath, backupPath, err := css.paths()
+	result := ClientApplyResult{SettingsPath: settingsPath}
 	if err != nil {
-		return err
+		return result, err
 	}
-	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
-		return err
+	baseURL, err := relayBaseURL(opts.BaseURL, css.baseURL())
+	if err != nil {
+		return result, err
 	}
-	if _, err := os.Stat(settingsPath); err == nil {
-		content, readErr := os.ReadFile(settingsPath)
-		if readErr != nil {
-			return readErr
-		}
-		if err := os.WriteFile(backupPath, content, 0o600); err != nil {
-			return err
-		}
+	settings, original, err := readClaudeSettings(settingsPath)
+	if err != nil {
+		return result, err
+	}
+	env, err := claudeSettingsEnv(settings)
+	if err != nil {
+		return result, err
 	}
-	settings := claudeSettingsFile{
-		Env: map[string]string{
-			"ANTHROPIC_AUTH_TOKEN": claudeAuthTokenValue,
-			"ANTHROPIC_BASE_URL":   css.baseURL(),
-		},
+	env["ANTHROPIC_BASE_URL"] = baseURL
+	env["ANTHROPIC_AUTH_TOKEN"] = claudeAuthTokenValue
+	// 同时存在 ANTHROPIC_API_KEY 时 Claude Code 会优先使用它，真实的 Key 由 relay 负责
+	delete(env, "ANTHROPIC_API_KEY")
+	for key, model := range map[string]string{
+		"ANTHROPIC_MODEL":                opts.Model,
+		"ANTHROPIC_DEFAULT_OPUS_MODEL":   opts.OpusModel,
+		"ANTHROPIC_DEFAULT_SONNET_MODEL": opts.SonnetModel,
+		"ANTHROPIC_DEFAULT_HAIKU_MODEL":  opts.HaikuModel,
+	} {
+		if model = strings.TrimSpace(model); model != "" {
+			env[key] = model
+		}
 	}
+	settings["env"] = env
 	payload, err := json.MarshalIndent(settings, "", "  ")
 	if err != nil {
-		return err
+		return result, err
+	}
+	result.Settings = string(payload)
+	result.Changed = !bytes.Equal(payload, original)
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		return result, err
+	}
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		backup := original
+		if backup == nil {
+			backup = []byte("{}")
+		}
+		if err := os.WriteFile(backupPath, backup, 0o600); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
 	}
-	return os.WriteFile(settingsPath, payload, 0o600)
+	return result, os.WriteFile(settingsPath, payload, 0o600)
 }
 
-func (css *ClaudeSettingsService) DisableProxy() error {
+// Unapply 将 Apply 修改的环境变量恢复为备份中的值并删除备份，Apply 之后对 settings.json 的其他修改保留；
+// 恢复后没有任何设置时删除 settings.json。没有备份时只移除指向 relay 的设置
+func (css *ClaudeSettingsService) Unapply() (ClientApplyResult, error) {
 	settingsPath, backupPath, err := css.paths()
+	result := ClientApplyResult{SettingsPath: settingsPath}
+	if err != nil {
+		return result, err
+	}
+	settings, original, err := readClaudeSettings(settingsPath)
+	if err != nil {
+		return result, err
+	}
+	env, err := claudeSettingsEnv(settings)
 	if err != nil {
-		return err
+		return result, err
 	}
-	if err := os.Remove(settingsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+	backup, backupRaw, err := readClaudeSettings(backupPath)
+	if err != nil {
+		return result, fmt.Errorf("读取备份失败: %w", err)
+	}
+	if backupRaw != nil {
+		previous, err := claudeSettingsEnv(backup)
+		if err != nil {
+			return result, fmt.Errorf("读取备份失败: %w", err)
+		}
+		for _, key := range claudeManagedEnv {
+			if value, ok := previous[key]; ok {
+				env[key] = value
+			} else {
+				delete(env, key)
+			}
+		}
+	} else if value, _ := env["ANTHROPIC_AUTH_TOKEN"].(string); value == claudeAuthTokenValue {
+		delete(env, "ANTHROPIC_AUTH_TOKEN")
+		delete(env, "ANTHROPIC_BASE_URL")
+	}
+	if len(env) == 0 {
+		delete(settings, "env")
+	} else {
+		settings["env"] = env
+	}
+
+	if len(settings) == 0 {
+		if original != nil {
+			if err := os.Remove(settingsPath); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		}
+	} else {
+		payload, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return result, err
+		}
+		result.Settings = string(payload)
+		if !bytes.Equal(payload, original) {
+			if err := os.WriteFile(settingsPath, payload, 0o600); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		}
 	}
-	if _, err := os.Stat(backupPath); err == nil {
-		if err := os.Rename(backupPath, settingsPath); err != nil {
-			return err
+	if backupRaw != nil {
+		if err := os.Remove(backupPath); err != nil {
+			return result, err
 		}
-	} else if errors.Is(err, os.ErrNotExist) {
-		return nil
+		result.BackupPath = backupPath
+	}
+	return result, nil
+}
+
+// readClaudeSettings 读取 settings.json，文件不存在时返回空配置与 nil 的原始内容；内容无法解析时报错，避免覆盖手动编辑的文件
+func readClaudeSettings(path string) (map[string]any, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]any{}, nil, nil
+		}
+		return nil, nil, err
+	}
+	settings := map[string]any{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return nil, nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+		}
+	}
+	if settings == nil {
+		settings = map[string]any{}
+	}
+	return settings, data, nil
+}
+
+func claudeSettingsEnv(settings map[string]any) (map[string]any, error) {
+	switch env := settings["env"].(type) {
+	case nil:
+		return map[string]any{}, nil
+	case map[string]any:
+		return env, nil
+	default:
+		return nil, errors.New("settings.json 中的 env 必须是对象")
 	}
-	return nil
 }
 
 func (css *ClaudeSettingsService) paths() (settingsPath string, backupPath string, err error) {
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClaudeApplyMergesAndUnapplyRestores(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	settingsPath := filepath.Join(home, ".claude", "settings.json")
+	os.MkdirAll(filepath.Dir(settingsPath), 0o755)
+	os.WriteFile(settingsPath, []byte(`{"permissions":{"allow":["Bash(ls)"]},"env":{"ANTHROPIC_API_KEY":"sk-ant-1234567890","ANTHROPIC_MODEL":"claude-opus-4-1","DEBUG":"1"}}`), 0o600)
+	readSettings := func() map[string]any {
+		t.Helper()
+		settings, _, err := readClaudeSettings(settingsPath)
+		if err != nil {
+			t.Fatalf("读取 settings.json 失败: %v", err)
+		}
+		return settings
+	}
+
+	css := NewClaudeSettingsService(":18100")
+	result, err := css.Apply(ClaudeApplyOptions{HaikuModel: "claude-haiku-4-5", DryRun: true})
+	if err != nil || !result.Changed || result.BackupPath != "" {
+		t.Fatalf("dry-run 失败: %+v %v", result, err)
+	}
+	if env := readSettings()["env"].(map[string]any); env["ANTHROPIC_BASE_URL"] != nil {
+		t.Fatalf("dry-run 不应写入文件")
+	}
+
+	result, err = css.Apply(ClaudeApplyOptions{HaikuModel: "claude-haiku-4-5"})
+	if err != nil || result.BackupPath == "" {
+		t.Fatalf("apply 失败: %+v %v", result, err)
+	}
+	settings := readSettings()
+	env := settings["env"].(map[string]any)
+	if env["ANTHROPIC_BASE_URL"] != "http://127.0.0.1:18100" || env["ANTHROPIC_AUTH_TOKEN"] != claudeAuthTokenValue ||
+		env["ANTHROPIC_API_KEY"] != nil || env["ANTHROPIC_MODEL"] != "claude-opus-4-1" || env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] != "claude-haiku-4-5" || env["DEBUG"] != "1" {
+		t.Fatalf("env 合并错误: %+v", env)
+	}
+	if settings["permissions"] == nil {
+		t.Fatalf("应保留其他设置: %+v", settings)
+	}
+	if status, _ := css.ProxyStatus(); !status.Enabled {
+		t.Fatalf("apply 后应显示已启用")
+	}
+
+	// 重复 apply 不覆盖首次的备份
+	if result, err = css.Apply(ClaudeApplyOptions{Model: "claude-sonnet-4-5"}); err != nil || result.BackupPath != "" {
+		t.Fatalf("重复 apply 不应重新备份: %+v %v", result, err)
+	}
+	// apply 之后手动添加的设置在 unapply 后保留
+	settings = readSettings()
+	settings["theme"] = "dark"
+	data, _ := json.Marshal(settings)
+	os.WriteFile(settingsPath, data, 0o600)
+
+	result, err = css.Unapply()
+	if err != nil || result.BackupPath == "" || !result.Changed {
+		t.Fatalf("unapply 失败: %+v %v", result, err)
+	}
+	settings = readSettings()
+	env = settings["env"].(map[string]any)
+	if env["ANTHROPIC_API_KEY"] != "sk-ant-1234567890" || env["ANTHROPIC_MODEL"] != "claude-opus-4-1" || env["ANTHROPIC_BASE_URL"] != nil ||
+		env["ANTHROPIC_AUTH_TOKEN"] != nil || env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] != nil || settings["theme"] != "dark" {
+		t.Fatalf("应恢复 apply 之前的 env 并保留其他修改: %+v", settings)
+	}
+	if _, err := os.Stat(result.BackupPath); !os.IsNotExist(err) {
+		t.Fatalf("unapply 后应删除备份")
+	}
+
+	// apply 之前不存在 settings.json 时，unapply 删除该文件
+	os.Remove(settingsPath)
+	if _, err := css.Apply(ClaudeApplyOptions{}); err != nil {
+		t.Fatalf("apply 失败: %v", err)
+	}
+	if _, err := css.Unapply(); err != nil {
+		t.Fatalf("unapply 失败: %v", err)
+	}
+	if _, err := os.Stat(settingsPath); !os.IsNotExist(err) {
+		t.Fatalf("应删除 apply 创建的 settings.json")
+	}
+
+	os.WriteFile(settingsPath, []byte(`{"env": [`), 0o600)
+	if _, err := css.Apply(ClaudeApplyOptions{}); err == nil {
+		t.Fatalf("settings.json 无法解析时不应覆盖")
+	}
+}
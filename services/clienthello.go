@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientConfig 控制 /v1/client/hello 返回给客户端的提示信息
+type ClientConfig struct {
+	// 每日费用预算（美元，按本地时间零点重置），0 表示不限制
+	DailyBudget float64 `json:"dailyBudget"`
+	// 发送前估算费用，当天已用费用加估算费用超出 DailyBudget 时拒绝请求（返回 402）
+	EnforceDailyBudget bool `json:"enforceDailyBudget,omitempty"`
+	// 单个请求估算费用的上限（美元），超出时拒绝请求，0 表示不限制
+	MaxRequestCost float64 `json:"maxRequestCost,omitempty"`
+	// 按平台推荐给客户端的模型，未配置的平台使用已启用 provider 的模型白名单
+	RecommendedModels map[string][]string `json:"recommendedModels,omitempty"`
+}
+
+// ClientDialect 描述 relay 支持的一种请求格式
+type ClientDialect struct {
+	Platform string `json:"platform"`
+	Endpoint string `json:"endpoint"`
+	Format   string `json:"format"`
+}
+
+// ClientBudget 是当天的预算使用情况
+type ClientBudget struct {
+	DailyLimit float64   `json:"daily_limit"`
+	Spent      float64   `json:"spent"`
+	Remaining  float64   `json:"remaining"`
+	ResetAt    time.Time `json:"reset_at"`
+}
+
+// ClientRateLimit 是一个平台当前的限流状态
+type ClientRateLimit struct {
+	// 所有已启用的 provider 均处于限流冷却中
+	Limited       bool      `json:"limited"`
+	ResumeAt      time.Time `json:"resume_at,omitempty"`
+	RetryAfterSec float64   `json:"retry_after_sec,omitempty"`
+	// 当前可参与路由的 provider 数量
+	AvailableProviders int `json:"available_providers"`
+}
+
+// ClientHello 返回给包装脚本与 IDE 插件，用于根据代理状态调整行为（如预算不足时改用更便宜的模型）
+type ClientHello struct {
+	Version           string                     `json:"version"`
+	Dialects          []ClientDialect            `json:"dialects"`
+	RecommendedModels map[string][]string        `json:"recommended_models"`
+	Budget            *ClientBudget              `json:"budget,omitempty"`
+	RateLimits        map[string]ClientRateLimit `json:"rate_limits"`
+}
+
+// clientDialects 与 registerRoutes 注册的代理接口保持一致
+var clientDialects = []ClientDialect{
+	{Platform: "claude", Endpoint: "/v1/messages", Format: "anthropic-messages"},
+	{Platform: "claude", Endpoint: countTokensEndpoint, Format: "anthropic-count-tokens"},
+	{Platform: "codex", Endpoint: "/responses", Format: "openai-responses"},
+	{Platform: "codex", Endpoint: chatCompletionsEndpoint, Format: "openai-chat-completions"},
+	{Platform: "codex", Endpoint: embeddingsEndpoint, Format: "openai-embeddings"},
+	{Platform: "codex", Endpoint: rerankEndpoint, Format: "rerank"},
+}
+
+// SetVersion 设置 /v1/client/hello 报告的代理版本
+func (prs *ProviderRelayService) SetVersion(version string) {
+	prs.version = version
+}
+
+// ClientHello 汇总代理版本、支持的格式、推荐模型、当天剩余预算与各平台的限流状态
+func (prs *ProviderRelayService) ClientHello() (ClientHello, error) {
+	cfg := prs.loadRelayConfig().Client
+	hello := ClientHello{
+		Version:           prs.version,
+		Dialects:          clientDialects,
+		RecommendedModels: make(map[string][]string, len(clientDialects)),
+		RateLimits:        make(map[string]ClientRateLimit, len(clientDialects)),
+	}
+	now := time.Now()
+	for _, dialect := range clientDialects {
+		kind := dialect.Platform
+		if _, done := hello.RateLimits[kind]; done {
+			continue
+		}
+		providers, err := prs.loadProviders(kind)
+		if err != nil {
+			return hello, fmt.Errorf("加载 %s provider 失败: %w", kind, err)
+		}
+		active := make([]Provider, 0, len(providers))
+		for _, provider := range providers {
+			if routeSkipReason(provider, "") == "" {
+				active = append(active, provider)
+			}
+		}
+
+		models, configured := cfg.RecommendedModels[kind]
+		if !configured {
+			models = supportedModelNames(active)
+		}
+		hello.RecommendedModels[kind] = models
+
+		limit := ClientRateLimit{AvailableProviders: len(active)}
+		if resumeAt, limited := prs.keyPool.rateLimitedUntil(kind, active); limited {
+			limit.Limited = true
+			limit.ResumeAt = resumeAt
+			limit.RetryAfterSec = resumeAt.Sub(now).Seconds()
+			limit.AvailableProviders = 0
+		}
+		hello.RateLimits[kind] = limit
+	}
+
+	if cfg.DailyBudget > 0 {
+		start := startOfDay(now)
+		spent, err := NewLogService().SpentSince(start)
+		if err != nil {
+			return hello, fmt.Errorf("统计当天费用失败: %w", err)
+		}
+		remaining := cfg.DailyBudget - spent
+		if remaining < 0 {
+			remaining = 0
+		}
+		hello.Budget = &ClientBudget{
+			DailyLimit: cfg.DailyBudget,
+			Spent:      spent,
+			Remaining:  remaining,
+			ResetAt:    start.Add(24 * time.Hour),
+		}
+	}
+	return hello, nil
+}
+
+// supportedModelNames 返回 provider 模型白名单的并集（按名称排序）
+func supportedModelNames(providers []Provider) []string {
+	seen := make(map[string]bool)
+	models := make([]string, 0)
+	for _, provider := range providers {
+		for model, supported := range provider.SupportedModels {
+			if supported && !seen[model] {
+				seen[model] = true
+				models = append(models, model)
+			}
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+func (prs *ProviderRelayService) clientHelloHandler(c *gin.Context) {
+	hello, err := prs.ClientHello()
+	if err != nil {
+		fmt.Printf("[WARN] 生成 client hello 失败: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hello)
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientHello(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "p1", APIURL: "https://p1.example", APIKey: "sk-p1-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-5": true, "claude-haiku-4-5": true}},
+		{ID: 2, Name: "off", APIURL: "https://off.example", APIKey: "sk-off-1234567890",
+			SupportedModels: map[string]bool{"claude-opus-4-1": true}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	if err := ps.SaveProviders("codex", []Provider{
+		{ID: 1, Name: "c1", APIURL: "https://c1.example", APIKey: "sk-c1-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Client = ClientConfig{DailyBudget: 1, RecommendedModels: map[string][]string{"codex": {"gpt-5-mini"}}}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	relay.SetVersion("v9.9.9")
+
+	// 当天的 10 万输入 token（sonnet 官方价格 $0.3）计入预算，前一天的不计入
+	logs := xdb.New("request_log", xdb.WithSaveZero())
+	for _, createdAt := range []time.Time{time.Now(), startOfDay(time.Now()).Add(-time.Hour)} {
+		if _, err := logs.Insert(xdb.Record{
+			"platform": "claude", "provider": "p1", "model": "claude-sonnet-4-5", "http_code": 200,
+			"input_tokens": 100000, "created_at": createdAt.Format(timeLayout),
+		}); err != nil {
+			t.Fatalf("写入日志失败: %v", err)
+		}
+	}
+	relay.keyPool.markRateLimited("codex", "c1", "sk-c1-1234567890", time.Minute)
+
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/client/hello", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("状态码 %d: %s", rec.Code, rec.Body.String())
+	}
+	var hello ClientHello
+	if err := json.Unmarshal(rec.Body.Bytes(), &hello); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+
+	if hello.Version != "v9.9.9" || len(hello.Dialects) != 6 {
+		t.Fatalf("版本或格式列表错误: %+v", hello)
+	}
+	if got := hello.RecommendedModels["claude"]; !reflect.DeepEqual(got, []string{"claude-haiku-4-5", "claude-sonnet-4-5"}) {
+		t.Errorf("未配置时应推荐已启用 provider 的模型: %v", got)
+	}
+	if got := hello.RecommendedModels["codex"]; !reflect.DeepEqual(got, []string{"gpt-5-mini"}) {
+		t.Errorf("应使用配置的推荐模型: %v", got)
+	}
+	if hello.Budget == nil || hello.Budget.DailyLimit != 1 || hello.Budget.Spent < 0.299 || hello.Budget.Spent > 0.301 ||
+		hello.Budget.Remaining > 0.701 || hello.Budget.Remaining < 0.699 {
+		t.Errorf("预算信息错误: %+v", hello.Budget)
+	}
+	if limit := hello.RateLimits["claude"]; limit.Limited || limit.AvailableProviders != 1 {
+		t.Errorf("claude 不应处于限流: %+v", limit)
+	}
+	if limit := hello.RateLimits["codex"]; !limit.Limited || limit.RetryAfterSec <= 0 || limit.AvailableProviders != 0 {
+		t.Errorf("codex 应报告限流: %+v", limit)
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ClientIntegration 修改客户端的配置文件，使其通过本机 relay 请求；可以通过 RegisterClientIntegration 接入新的客户端，
+// code-switch apply / unapply 按名称查找，不需要修改 relay
+type ClientIntegration interface {
+	// apply 命令使用的名称，如 continue
+	Name() string
+	// 客户端名称，用于提示信息
+	DisplayName() string
+	Apply(opts ClientApplyOptions) (ClientApplyResult, error)
+	// Unapply 撤销 Apply 的修改，Apply 之后对配置文件的其他修改保留
+	Unapply() (ClientApplyResult, error)
+}
+
+// ClientApplyOptions 是各客户端通用的 apply 选项，客户端不支持的选项被忽略
+type ClientApplyOptions struct {
+	// relay 地址，留空时使用本机 relay 的监听地址
+	BaseURL string `json:"baseUrl"`
+	// 默认使用的模型
+	Model string `json:"model"`
+	// 按档位指定的模型，如 claude 的 opus、sonnet、haiku
+	TierModels map[string]string `json:"tierModels,omitempty"`
+	// 只返回修改后的内容，不写入文件
+	DryRun bool `json:"dryRun"`
+}
+
+// ClientApplyResult 描述对客户端配置文件的修改
+type ClientApplyResult struct {
+	SettingsPath string `json:"settingsPath"`
+	// Apply 时为本次创建的备份（重复 Apply 时为空），Unapply 时为已恢复并删除的备份
+	BackupPath string `json:"backupPath,omitempty"`
+	// 修改后的配置文件内容，文件被删除时为空
+	Settings string `json:"settings"`
+	Changed  bool   `json:"changed"`
+	// 使用修改后的配置的提示，如切换模型的方式
+	Note string `json:"note,omitempty"`
+}
+
+var (
+	clientIntegrationsMu sync.RWMutex
+	clientIntegrations   = map[string]ClientIntegration{}
+)
+
+// clientIntegrationAliases 是客户端的其他常用名称
+var clientIntegrationAliases = map[string]string{
+	"gemini-cli": "gemini",
+}
+
+// RegisterClientIntegration 注册客户端集成，同名的集成会被替换
+func RegisterClientIntegration(integration ClientIntegration) {
+	clientIntegrationsMu.Lock()
+	defer clientIntegrationsMu.Unlock()
+	clientIntegrations[integration.Name()] = integration
+}
+
+// LookupClientIntegration 返回指定名称的客户端集成
+func LookupClientIntegration(name string) (ClientIntegration, error) {
+	clientIntegrationsMu.RLock()
+	defer clientIntegrationsMu.RUnlock()
+	key := strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := clientIntegrationAliases[key]; ok {
+		key = alias
+	}
+	integration, ok := clientIntegrations[key]
+	if !ok {
+		return nil, fmt.Errorf("不支持的客户端: %s（可用: %s）", name, strings.Join(clientIntegrationNamesLocked(), "、"))
+	}
+	return integration, nil
+}
+
+// ClientIntegrationNames 返回已注册的客户端名称
+func ClientIntegrationNames() []string {
+	clientIntegrationsMu.RLock()
+	defer clientIntegrationsMu.RUnlock()
+	return clientIntegrationNamesLocked()
+}
+
+func clientIntegrationNamesLocked() []string {
+	names := make([]string, 0, len(clientIntegrations))
+	for name := range clientIntegrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterClientIntegration(claudeIntegration{})
+	RegisterClientIntegration(codexIntegration{})
+	RegisterClientIntegration(continueIntegration{})
+	RegisterClientIntegration(geminiIntegration{})
+	RegisterClientIntegration(clineIntegration{})
+}
+
+// relayBaseURL 返回客户端使用的 relay 地址，raw 为空时使用 fallback
+func relayBaseURL(raw string, fallback string) (string, error) {
+	baseURL := strings.TrimSpace(raw)
+	if baseURL == "" {
+		baseURL = fallback
+	}
+	if parsed, err := url.Parse(baseURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("relay 地址无效: %s", baseURL)
+	}
+	return strings.TrimRight(baseURL, "/"), nil
+}
+
+type claudeIntegration struct{}
+
+func (claudeIntegration) Name() string        { return "claude" }
+func (claudeIntegration) DisplayName() string { return "Claude Code" }
+
+func (claudeIntegration) Apply(opts ClientApplyOptions) (ClientApplyResult, error) {
+	return NewClaudeSettingsService("").Apply(ClaudeApplyOptions{
+		BaseURL:     opts.BaseURL,
+		Model:       opts.Model,
+		OpusModel:   opts.TierModels["opus"],
+		SonnetModel: opts.TierModels["sonnet"],
+		HaikuModel:  opts.TierModels["haiku"],
+		DryRun:      opts.DryRun,
+	})
+}
+
+func (claudeIntegration) Unapply() (ClientApplyResult, error) {
+	return NewClaudeSettingsService("").Unapply()
+}
+
+type codexIntegration struct{}
+
+func (codexIntegration) Name() string        { return "codex" }
+func (codexIntegration) DisplayName() string { return "Codex" }
+
+func (codexIntegration) Apply(opts ClientApplyOptions) (ClientApplyResult, error) {
+	result, err := NewCodexSettingsService("").Apply(CodexApplyOptions{BaseURL: opts.BaseURL, Model: opts.Model, DryRun: opts.DryRun})
+	result.Note = "可使用 codex --profile code-switch-<model> 切换模型，codex provider 变化时由运行中的 code-switch 自动更新"
+	return result, err
+}
+
+func (codexIntegration) Unapply() (ClientApplyResult, error) {
+	return NewCodexSettingsService("").Unapply()
+}
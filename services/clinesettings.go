@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Cline CLI 与独立运行的 Cline 将配置保存在 ~/.cline/data 下，VS Code 扩展使用扩展自己的存储
+	clineDataDir         = ".cline/data"
+	clineGlobalStateName = "globalState.json"
+	clineSecretsName     = "secrets.json"
+	clineBackupFileName  = "cc-studio.back.json"
+)
+
+// clineBackup 记录 Apply 之前被修改的字段，Missing 为 Apply 之前不存在的文件
+type clineBackup struct {
+	GlobalState map[string]any `json:"globalState"`
+	Secrets     map[string]any `json:"secrets"`
+	Missing     []string       `json:"missing,omitempty"`
+}
+
+// clineIntegration 修改 Cline 的 globalState.json 与 secrets.json，使 Plan / Act 模式都使用 Anthropic 协议并通过 relay 请求，
+// 其余设置保持不变
+type clineIntegration struct{}
+
+func (clineIntegration) Name() string        { return "cline" }
+func (clineIntegration) DisplayName() string { return "Cline" }
+
+func (clineIntegration) paths() (dir string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, clineDataDir), nil
+}
+
+// clineSettings 返回 Apply 写入的字段，model 为空时不修改模型
+func clineSettings(baseURL string, model string) (globalState map[string]any, secrets map[string]any) {
+	globalState = map[string]any{
+		"actModeApiProvider":  "anthropic",
+		"planModeApiProvider": "anthropic",
+		"anthropicBaseUrl":    baseURL,
+	}
+	if model = strings.TrimSpace(model); model != "" {
+		globalState["actModeApiModelId"] = model
+		globalState["planModeApiModelId"] = model
+	}
+	return globalState, map[string]any{"apiKey": claudeAuthTokenValue}
+}
+
+// clineManagedKeys 是 Apply 可能修改的字段，Unapply 时恢复为 Apply 之前的值
+var clineManagedKeys = map[string][]string{
+	clineGlobalStateName: {"actModeApiProvider", "planModeApiProvider", "anthropicBaseUrl", "actModeApiModelId", "planModeApiModelId"},
+	clineSecretsName:     {"apiKey"},
+}
+
+// Apply 首次修改时备份被修改的字段，重复 Apply 不会覆盖备份；Settings 为修改后的 globalState.json（secrets.json 只包含 Key，不输出）
+func (ci clineIntegration) Apply(opts ClientApplyOptions) (ClientApplyResult, error) {
+	dir, err := ci.paths()
+	result := ClientApplyResult{SettingsPath: filepath.Join(dir, clineGlobalStateName)}
+	if err != nil {
+		return result, err
+	}
+	baseURL, err := relayBaseURL(opts.BaseURL, NewClaudeSettingsService("").baseURL())
+	if err != nil {
+		return result, err
+	}
+	backup := clineBackup{GlobalState: map[string]any{}, Secrets: map[string]any{}}
+	updates := map[string]map[string]any{}
+	updates[clineGlobalStateName], updates[clineSecretsName] = clineSettings(baseURL, opts.Model)
+	payloads := map[string][]byte{}
+	for _, name := range []string{clineGlobalStateName, clineSecretsName} {
+		path := filepath.Join(dir, name)
+		settings, original, err := readClaudeSettings(path)
+		if err != nil {
+			return result, err
+		}
+		previous := backup.GlobalState
+		if name == clineSecretsName {
+			previous = backup.Secrets
+		}
+		if original == nil {
+			backup.Missing = append(backup.Missing, name)
+		}
+		for _, key := range clineManagedKeys[name] {
+			if value, ok := settings[key]; ok {
+				previous[key] = value
+			}
+		}
+		for key, value := range updates[name] {
+			settings[key] = value
+		}
+		payload, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return result, err
+		}
+		payloads[name] = payload
+		result.Changed = result.Changed || !bytes.Equal(payload, original)
+	}
+	result.Settings = string(payloads[clineGlobalStateName])
+	result.Note = "只对 Cline CLI 与独立运行的 Cline 生效；VS Code 扩展请在设置中将 API Provider 设为 Anthropic，" +
+		"勾选 Use custom base URL 并填写 " + baseURL
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return result, err
+	}
+	backupPath := filepath.Join(dir, clineBackupFileName)
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		data, err := json.MarshalIndent(backup, "", "  ")
+		if err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(backupPath, data, 0o600); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	for _, name := range []string{clineGlobalStateName, clineSecretsName} {
+		if err := os.WriteFile(filepath.Join(dir, name), payloads[name], 0o600); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Unapply 将 Apply 修改的字段恢复为备份中的值并删除备份，Apply 之后的其他修改保留；
+// 文件由 Apply 创建且恢复后没有内容时删除。没有备份时只移除指向 relay 的设置
+func (ci clineIntegration) Unapply() (ClientApplyResult, error) {
+	dir, err := ci.paths()
+	result := ClientApplyResult{SettingsPath: filepath.Join(dir, clineGlobalStateName)}
+	if err != nil {
+		return result, err
+	}
+	backupPath := filepath.Join(dir, clineBackupFileName)
+	data, backupErr := os.ReadFile(backupPath)
+	if backupErr != nil && !errors.Is(backupErr, os.ErrNotExist) {
+		return result, fmt.Errorf("读取备份失败: %w", backupErr)
+	}
+	var backup *clineBackup
+	if backupErr == nil {
+		backup = &clineBackup{}
+		if err := json.Unmarshal(data, backup); err != nil {
+			return result, fmt.Errorf("读取备份失败: %w", err)
+		}
+	}
+	// 没有备份时以 secrets.json 中的 Key 判断是否由 Apply 修改
+	secrets, _, err := readClaudeSettings(filepath.Join(dir, clineSecretsName))
+	if err != nil {
+		return result, err
+	}
+	relayKey, _ := secrets["apiKey"].(string)
+	for _, name := range []string{clineGlobalStateName, clineSecretsName} {
+		path := filepath.Join(dir, name)
+		settings, original, err := readClaudeSettings(path)
+		if err != nil {
+			return result, err
+		}
+		if original == nil {
+			continue
+		}
+		missing := false
+		if backup != nil {
+			previous := backup.GlobalState
+			if name == clineSecretsName {
+				previous = backup.Secrets
+			}
+			for _, key := range clineManagedKeys[name] {
+				if value, ok := previous[key]; ok {
+					settings[key] = value
+				} else {
+					delete(settings, key)
+				}
+			}
+			for _, file := range backup.Missing {
+				missing = missing || file == name
+			}
+		} else if relayKey == claudeAuthTokenValue {
+			delete(settings, "anthropicBaseUrl")
+			delete(settings, "apiKey")
+		}
+
+		if missing && len(settings) == 0 {
+			if err := os.Remove(path); err != nil {
+				return result, err
+			}
+			result.Changed = true
+			continue
+		}
+		payload, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return result, err
+		}
+		if name == clineGlobalStateName {
+			result.Settings = string(payload)
+		}
+		if !bytes.Equal(payload, original) {
+			if err := os.WriteFile(path, payload, 0o600); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		}
+	}
+	if backup != nil {
+		if err := os.Remove(backupPath); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClineIntegrationKeepsUserSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".cline", "data")
+	os.MkdirAll(dir, 0o755)
+	statePath := filepath.Join(dir, "globalState.json")
+	secretsPath := filepath.Join(dir, "secrets.json")
+	os.WriteFile(statePath, []byte(`{"actModeApiProvider":"openrouter","actModeApiModelId":"gpt-5","autoApprovalSettings":{"enabled":true}}`), 0o600)
+
+	integration, err := LookupClientIntegration("cline")
+	if err != nil {
+		t.Fatalf("应注册 cline: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		result, err := integration.Apply(ClientApplyOptions{Model: "claude-sonnet-4-5"})
+		if err != nil || (i == 0) != (result.BackupPath != "") {
+			t.Fatalf("apply 失败: %+v %v", result, err)
+		}
+	}
+	read := func(path string) map[string]any {
+		t.Helper()
+		settings := map[string]any{}
+		data, _ := os.ReadFile(path)
+		if err := json.Unmarshal(data, &settings); err != nil {
+			t.Fatalf("解析 %s 失败: %v %s", path, err, data)
+		}
+		return settings
+	}
+	state := read(statePath)
+	if state["actModeApiProvider"] != "anthropic" || state["planModeApiProvider"] != "anthropic" ||
+		state["anthropicBaseUrl"] != "http://127.0.0.1:18100" || state["planModeApiModelId"] != "claude-sonnet-4-5" || state["autoApprovalSettings"] == nil {
+		t.Fatalf("globalState.json 内容错误: %v", state)
+	}
+	if secrets := read(secretsPath); secrets["apiKey"] != claudeAuthTokenValue {
+		t.Fatalf("secrets.json 内容错误: %v", secrets)
+	}
+
+	if result, err := integration.Unapply(); err != nil || result.BackupPath == "" {
+		t.Fatalf("unapply 失败: %+v %v", result, err)
+	}
+	state = read(statePath)
+	if state["actModeApiProvider"] != "openrouter" || state["actModeApiModelId"] != "gpt-5" || state["anthropicBaseUrl"] != nil || state["planModeApiProvider"] != nil {
+		t.Fatalf("unapply 应恢复原来的设置: %v", state)
+	}
+	// apply 之前不存在的 secrets.json 被删除
+	if _, err := os.Stat(secretsPath); !os.IsNotExist(err) {
+		t.Fatalf("应删除 apply 创建的 secrets.json")
+	}
+}
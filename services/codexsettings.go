@@ -1,10 +1,13 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/pelletier/go-toml/v2"
@@ -22,6 +25,7 @@ const (
 	codexEnvKey           = "OPENAI_API_KEY"
 	codexWireAPI          = "responses"
 	codexTokenValue       = "code-switch"
+	codexProfilePrefix    = "code-switch-"
 )
 
 type CodexSettingsService struct {
@@ -52,70 +56,294 @@ func (css *CodexSettingsService) ProxyStatus() (ClaudeProxyStatus, error) {
 	return status, nil
 }
 
+// EnableProxy 让 Codex 通过 relay 请求，等同于不指定模型的 Apply
 func (css *CodexSettingsService) EnableProxy() error {
+	_, err := css.Apply(CodexApplyOptions{})
+	return err
+}
+
+// DisableProxy 恢复 EnableProxy 之前的配置，见 Unapply
+func (css *CodexSettingsService) DisableProxy() error {
+	_, err := css.Unapply()
+	return err
+}
+
+// codexManagedKeys 是 Apply 会修改的 config.toml 顶层设置，Unapply 时恢复为 Apply 之前的值
+var codexManagedKeys = []string{"preferred_auth_method", "model", "model_provider"}
+
+// CodexApplyOptions 是写入 Codex config.toml 的内容
+type CodexApplyOptions struct {
+	// relay 地址，留空时使用本机 relay 的监听地址
+	BaseURL string `json:"baseUrl"`
+	// 默认使用的模型，留空时保留当前的模型（当前优先的 provider 不支持时改用它支持的模型）
+	Model string `json:"model"`
+	// 只返回修改后的内容，不写入文件
+	DryRun bool `json:"dryRun"`
+}
+
+// Apply 在 config.toml 中添加指向 relay 的 model_provider，并为 codex provider 支持的每个模型生成
+// code-switch-<model> profile，其余设置保持不变；首次 Apply 时备份原文件，重复 Apply 不会覆盖备份。
+// relay 运行时 codex provider 配置变化会自动同步 profile 与默认模型，见 SyncCodexConfig
+func (css *CodexSettingsService) Apply(opts CodexApplyOptions) (ClientApplyResult, error) {
 	settingsPath, backupPath, err := css.paths()
+	result := ClientApplyResult{SettingsPath: settingsPath}
 	if err != nil {
-		return err
+		return result, err
 	}
-	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
-		return err
+	baseURL, err := relayBaseURL(opts.BaseURL, css.baseURL())
+	if err != nil {
+		return result, err
 	}
-	var raw map[string]any
-	if _, err := os.Stat(settingsPath); err == nil {
-		content, readErr := os.ReadFile(settingsPath)
-		if readErr != nil {
-			return readErr
-		}
-		if err := os.WriteFile(backupPath, content, 0o600); err != nil {
-			return err
-		}
-		if err := toml.Unmarshal(content, &raw); err != nil {
-			return err
-		}
-	} else {
-		raw = make(map[string]any)
+	providers, err := NewProviderService().LoadProviders("codex")
+	if err != nil {
+		return result, fmt.Errorf("读取 codex provider 失败: %w", err)
 	}
-	if raw == nil {
-		raw = make(map[string]any)
+	raw, original, err := readCodexConfigFile(settingsPath)
+	if err != nil {
+		return result, err
 	}
 	raw["preferred_auth_method"] = codexPreferredAuth
-	raw["model"] = codexDefaultModel
 	raw["model_provider"] = codexProviderKey
+	current, _ := raw["model"].(string)
+	if model := strings.TrimSpace(opts.Model); model != "" {
+		raw["model"] = model
+	} else {
+		raw["model"] = codexModelFor(providers, current)
+	}
 
 	modelProviders := ensureTomlTable(raw, "model_providers")
 	provider := ensureProviderTable(modelProviders, codexProviderKey)
 	provider["name"] = codexProviderKey
-	provider["base_url"] = css.baseURL()
+	provider["base_url"] = baseURL
 	provider["env_key"] = codexEnvKey
 	provider["wire_api"] = codexWireAPI
 	provider["requires_openai_auth"] = false
 	modelProviders[codexProviderKey] = provider
+	setCodexProfiles(raw, providers)
 
-	data, err := toml.Marshal(raw)
+	payload, err := marshalCodexConfig(raw)
 	if err != nil {
-		return err
+		return result, err
 	}
-	cleaned := stripModelProvidersHeader(data)
-	if err := os.WriteFile(settingsPath, cleaned, 0o600); err != nil {
-		return err
+	result.Settings = string(payload)
+	result.Changed = !bytes.Equal(payload, original)
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		return result, err
 	}
-	return css.writeAuthFile()
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		// 原来不存在 config.toml 时备份为空文件，Unapply 时据此删除生成的设置
+		if err := os.WriteFile(backupPath, original, 0o600); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	if err := os.WriteFile(settingsPath, payload, 0o600); err != nil {
+		return result, err
+	}
+	return result, css.writeAuthFile()
 }
 
-func (css *CodexSettingsService) DisableProxy() error {
+// Unapply 将 Apply 修改的设置恢复为备份中的值，移除生成的 model_provider 与 profile 并删除备份，
+// Apply 之后对 config.toml 的其他修改保留；恢复后没有任何设置时删除 config.toml
+func (css *CodexSettingsService) Unapply() (ClientApplyResult, error) {
 	settingsPath, backupPath, err := css.paths()
+	result := ClientApplyResult{SettingsPath: settingsPath}
 	if err != nil {
-		return err
+		return result, err
 	}
-	if err := os.Remove(settingsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+	raw, original, err := readCodexConfigFile(settingsPath)
+	if err != nil {
+		return result, err
 	}
-	if _, err := os.Stat(backupPath); err == nil {
-		if err := os.Rename(backupPath, settingsPath); err != nil {
-			return err
+	backup, backupRaw, err := readCodexConfigFile(backupPath)
+	if err != nil {
+		return result, fmt.Errorf("读取备份失败: %w", err)
+	}
+	if backupRaw != nil {
+		for _, key := range codexManagedKeys {
+			if value, ok := backup[key]; ok {
+				raw[key] = value
+			} else {
+				delete(raw, key)
+			}
+		}
+	} else if value, _ := raw["model_provider"].(string); value == codexProviderKey {
+		delete(raw, "model_provider")
+	}
+	for _, table := range []string{"model_providers", "profiles"} {
+		previous := ensureTomlTable(backup, table)
+		entries := ensureTomlTable(raw, table)
+		for name := range entries {
+			if name == codexProviderKey || strings.HasPrefix(name, codexProfilePrefix) {
+				delete(entries, name)
+			}
+		}
+		for name, entry := range previous {
+			if name == codexProviderKey || strings.HasPrefix(name, codexProfilePrefix) {
+				entries[name] = entry
+			}
+		}
+		if len(entries) == 0 {
+			delete(raw, table)
 		}
 	}
-	return css.restoreAuthFile()
+
+	if len(raw) == 0 {
+		if original != nil {
+			if err := os.Remove(settingsPath); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		}
+	} else {
+		payload, err := marshalCodexConfig(raw)
+		if err != nil {
+			return result, err
+		}
+		result.Settings = string(payload)
+		if !bytes.Equal(payload, original) {
+			if err := os.WriteFile(settingsPath, payload, 0o600); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		}
+	}
+	if backupRaw != nil {
+		if err := os.Remove(backupPath); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	return result, css.restoreAuthFile()
+}
+
+// SyncCodexConfig 在 codex provider 配置变化后更新 Apply 生成的 profile 与默认模型：
+// 当前的默认模型不再被优先的 provider 支持时改用它支持的模型。未 Apply 时不做任何修改
+func SyncCodexConfig(providers []Provider) (bool, error) {
+	settingsPath, _, err := NewCodexSettingsService("").paths()
+	if err != nil {
+		return false, err
+	}
+	raw, original, err := readCodexConfigFile(settingsPath)
+	if err != nil || original == nil {
+		return false, err
+	}
+	if value, _ := raw["model_provider"].(string); value != codexProviderKey {
+		return false, nil
+	}
+	current, _ := raw["model"].(string)
+	raw["model"] = codexModelFor(providers, current)
+	setCodexProfiles(raw, providers)
+	payload, err := marshalCodexConfig(raw)
+	if err != nil || bytes.Equal(payload, original) {
+		return false, err
+	}
+	return true, os.WriteFile(settingsPath, payload, 0o600)
+}
+
+// explicitModels 返回已启用的 provider 明确支持的模型：modelMapping 中的外部模型名，以及 supportedModels 中
+// 不是映射目标的模型名（均不含通配符）
+func explicitModels(providers []Provider) []string {
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if !p.Enabled {
+			continue
+		}
+		targets := make(map[string]bool, len(p.ModelMapping))
+		for model, target := range p.ModelMapping {
+			targets[target] = true
+			if !strings.Contains(model, "*") {
+				seen[model] = true
+			}
+		}
+		for model, ok := range p.SupportedModels {
+			if ok && !targets[model] && !strings.Contains(model, "*") {
+				seen[model] = true
+			}
+		}
+	}
+	models := make([]string, 0, len(seen))
+	for model := range seen {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// codexModelFor 返回 Codex 的默认模型：current 被优先级最高的已启用 provider 支持时保留，
+// 否则依次使用 gpt-5-codex、该 provider 明确支持的第一个模型
+func codexModelFor(providers []Provider, current string) string {
+	var active *Provider
+	for i := range providers {
+		if providers[i].Enabled && (active == nil || providerLevel(providers[i]) < providerLevel(*active)) {
+			active = &providers[i]
+		}
+	}
+	if active == nil {
+		if current != "" {
+			return current
+		}
+		return codexDefaultModel
+	}
+	if current != "" && active.IsModelSupported(current) {
+		return current
+	}
+	if active.IsModelSupported(codexDefaultModel) {
+		return codexDefaultModel
+	}
+	if models := explicitModels([]Provider{*active}); len(models) > 0 {
+		return models[0]
+	}
+	return codexDefaultModel
+}
+
+// setCodexProfiles 重新生成 code-switch-<model> profile，使用 codex --profile 切换模型
+func setCodexProfiles(raw map[string]any, providers []Provider) {
+	profiles := ensureTomlTable(raw, "profiles")
+	for name := range profiles {
+		if strings.HasPrefix(name, codexProfilePrefix) {
+			delete(profiles, name)
+		}
+	}
+	for _, model := range explicitModels(providers) {
+		profiles[codexProfilePrefix+model] = map[string]any{
+			"model":          model,
+			"model_provider": codexProviderKey,
+		}
+	}
+	if len(profiles) == 0 {
+		delete(raw, "profiles")
+	}
+}
+
+// readCodexConfigFile 读取 config.toml，文件不存在时返回空配置与 nil 的原始内容；内容无法解析时报错，避免覆盖手动编辑的文件
+func readCodexConfigFile(path string) (map[string]any, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]any{}, nil, nil
+		}
+		return nil, nil, err
+	}
+	raw := map[string]any{}
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	if raw == nil {
+		raw = map[string]any{}
+	}
+	return raw, data, nil
+}
+
+func marshalCodexConfig(raw map[string]any) ([]byte, error) {
+	data, err := toml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return stripModelProvidersHeader(data), nil
 }
 
 func (css *CodexSettingsService) readConfig() (*codexConfig, error) {
@@ -223,7 +451,7 @@ func stripModelProvidersHeader(data []byte) []byte {
 	lines := strings.Split(string(data), "\n")
 	result := make([]string, 0, len(lines))
 	for _, line := range lines {
-		if strings.TrimSpace(line) == "[model_providers]" {
+		if trimmed := strings.TrimSpace(line); trimmed == "[model_providers]" || trimmed == "[profiles]" {
 			continue
 		}
 		result = append(result, line)
@@ -239,12 +467,13 @@ func (css *CodexSettingsService) writeAuthFile() error {
 	if err := os.MkdirAll(filepath.Dir(authPath), 0o755); err != nil {
 		return err
 	}
-	if _, err := os.Stat(authPath); err == nil {
-		content, readErr := os.ReadFile(authPath)
-		if readErr != nil {
-			return readErr
-		}
-		if err := os.WriteFile(backupPath, content, 0o600); err != nil {
+	// 只在首次写入时备份，重复 Apply 不会用生成的内容覆盖原来的登录信息
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		if content, err := os.ReadFile(authPath); err == nil {
+			if err := os.WriteFile(backupPath, content, 0o600); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
 	}
@@ -263,13 +492,18 @@ func (css *CodexSettingsService) restoreAuthFile() error {
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(authPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	if _, err := os.Stat(backupPath); err == nil {
-		if err := os.Rename(backupPath, authPath); err != nil {
+	// 只替换 writeAuthFile 生成的文件；之后重新登录写入的登录信息保留，备份直接删除
+	var current map[string]any
+	if data, err := os.ReadFile(authPath); err == nil && json.Unmarshal(data, &current) == nil && current[codexEnvKey] == codexTokenValue {
+		if err := os.Remove(authPath); err != nil {
 			return err
 		}
 	}
-	return nil
+	if _, err := os.Stat(backupPath); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(authPath); err == nil {
+		return os.Remove(backupPath)
+	}
+	return os.Rename(backupPath, authPath)
 }
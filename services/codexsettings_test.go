@@ -0,0 +1,103 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCodexApplyGeneratesProfilesAndSyncs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	initTestDatabase(t)
+	configPath := filepath.Join(home, ".codex", "config.toml")
+	authPath := filepath.Join(home, ".codex", "auth.json")
+	os.MkdirAll(filepath.Dir(configPath), 0o755)
+	os.WriteFile(configPath, []byte("model = \"o3\"\napproval_policy = \"on-request\"\n\n[profiles.fast]\nmodel = \"o4-mini\"\n"), 0o600)
+	os.WriteFile(authPath, []byte(`{"tokens":{"access_token":"chatgpt-login"}}`), 0o600)
+	readConfig := func() map[string]any {
+		t.Helper()
+		raw, _, err := readCodexConfigFile(configPath)
+		if err != nil {
+			t.Fatalf("读取 config.toml 失败: %v", err)
+		}
+		return raw
+	}
+	profileModels := func(raw map[string]any) map[string]any {
+		profiles, _ := raw["profiles"].(map[string]any)
+		models := map[string]any{}
+		for name, profile := range profiles {
+			models[name] = profile.(map[string]any)["model"]
+		}
+		return models
+	}
+
+	ps := NewProviderService()
+	providers := []Provider{
+		{ID: 1, Name: "backup", APIURL: "https://backup.example.com", APIKey: "sk-backup-1234567890", Enabled: true, Level: 2,
+			SupportedModels: map[string]bool{"gpt-5.1-codex": true, "gpt-*": true}},
+		{ID: 2, Name: "primary", APIURL: "https://primary.example.com", APIKey: "sk-primary-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"openai/gpt-5-codex": true}, ModelMapping: map[string]string{"gpt-5-codex": "openai/gpt-5-codex"}},
+	}
+	if err := ps.SaveProviders("codex", providers); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	css := NewCodexSettingsService(":18100")
+	result, err := css.Apply(CodexApplyOptions{})
+	if err != nil || result.BackupPath == "" {
+		t.Fatalf("apply 失败: %+v %v", result, err)
+	}
+	raw := readConfig()
+	provider := raw["model_providers"].(map[string]any)[codexProviderKey].(map[string]any)
+	if raw["model_provider"] != codexProviderKey || provider["base_url"] != "http://127.0.0.1:18100" || provider["wire_api"] != codexWireAPI {
+		t.Fatalf("model_provider 错误: %+v", raw)
+	}
+	// o3 不被优先级最高的 primary 支持，改用它支持的模型
+	if raw["model"] != "gpt-5-codex" || raw["approval_policy"] != "on-request" {
+		t.Fatalf("默认模型或其他设置错误: %+v", raw)
+	}
+	if models := profileModels(raw); len(models) != 3 || models["fast"] != "o4-mini" ||
+		models["code-switch-gpt-5-codex"] != "gpt-5-codex" || models["code-switch-gpt-5.1-codex"] != "gpt-5.1-codex" {
+		t.Fatalf("profile 生成错误: %+v", models)
+	}
+	if status, _ := css.ProxyStatus(); !status.Enabled {
+		t.Fatalf("apply 后应显示已启用")
+	}
+
+	// 优先的 provider 变化后，relay 重新加载配置时同步默认模型与 profile
+	providers[1].Enabled = false
+	if err := ps.SaveProviders("codex", providers); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	if _, err := relay.loadProviders("codex"); err != nil {
+		t.Fatalf("加载 provider 失败: %v", err)
+	}
+	raw = readConfig()
+	if models := profileModels(raw); raw["model"] != codexDefaultModel || len(models) != 2 || models["code-switch-gpt-5.1-codex"] == nil {
+		t.Fatalf("应同步默认模型与 profile: %+v", raw)
+	}
+
+	// 重复 apply 不覆盖首次的备份
+	if result, err = css.Apply(CodexApplyOptions{Model: "gpt-5.1-codex"}); err != nil || result.BackupPath != "" || readConfig()["model"] != "gpt-5.1-codex" {
+		t.Fatalf("重复 apply 失败: %+v %v", result, err)
+	}
+	if result, err = css.Unapply(); err != nil || result.BackupPath == "" {
+		t.Fatalf("unapply 失败: %+v %v", result, err)
+	}
+	raw = readConfig()
+	if models := profileModels(raw); raw["model"] != "o3" || raw["model_provider"] != nil || raw["model_providers"] != nil || len(models) != 1 {
+		t.Fatalf("应恢复 apply 之前的配置: %+v", raw)
+	}
+	var auth map[string]any
+	if data, _ := os.ReadFile(authPath); json.Unmarshal(data, &auth) != nil || auth["tokens"] == nil {
+		t.Fatalf("应恢复原来的 auth.json: %s", data)
+	}
+
+	// 未 apply 时不修改 config.toml
+	if changed, err := SyncCodexConfig(providers); changed || err != nil {
+		t.Fatalf("未 apply 时不应同步: %v %v", changed, err)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 客户端用该请求头标记后台任务（值为 background），并发已满时让交互请求先获得空位
+	requestPriorityHeader = "X-Code-Switch-Priority"
+	priorityBackground    = "background"
+
+	// 未配置时等待队列的最大长度与最长等待时间
+	defaultConcurrencyQueue = 50
+	defaultConcurrencyWait  = 60 * time.Second
+)
+
+// concurrencyLimitError 表示 provider 的并发已满、且等待队列已满或等待超时，
+// 请求降级到下一个 provider
+type concurrencyLimitError struct {
+	provider string
+	reason   string
+}
+
+func (e *concurrencyLimitError) Error() string {
+	return fmt.Sprintf("provider %s 并发已满，%s", e.provider, e.reason)
+}
+
+// ProviderConcurrency 是 provider 当前的并发占用
+type ProviderConcurrency struct {
+	Platform string `json:"platform"`
+	Provider string `json:"provider"`
+	Limit    int    `json:"limit"`
+	Active   int    `json:"active"`
+	Waiting  int    `json:"waiting"`
+}
+
+type concurrencyWaiter struct {
+	ready chan struct{}
+}
+
+// providerSemaphore 限制 provider 同时进行中的请求数；
+// 空位释放时优先交给等待中的交互请求，其次是后台请求，同一优先级先到先得
+type providerSemaphore struct {
+	limit       int
+	active      int
+	interactive []*concurrencyWaiter
+	background  []*concurrencyWaiter
+}
+
+func (s *providerSemaphore) waiting() int {
+	return len(s.interactive) + len(s.background)
+}
+
+// next 取出下一个获得空位的等待者，没有等待者时返回 nil
+func (s *providerSemaphore) next() *concurrencyWaiter {
+	if len(s.interactive) > 0 {
+		waiter := s.interactive[0]
+		s.interactive = s.interactive[1:]
+		return waiter
+	}
+	if len(s.background) > 0 {
+		waiter := s.background[0]
+		s.background = s.background[1:]
+		return waiter
+	}
+	return nil
+}
+
+// remove 将放弃等待的 waiter 移出队列，已被唤醒时返回 false
+func (s *providerSemaphore) remove(waiter *concurrencyWaiter) bool {
+	for _, queue := range []*[]*concurrencyWaiter{&s.interactive, &s.background} {
+		for i, w := range *queue {
+			if w == waiter {
+				*queue = append((*queue)[:i], (*queue)[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// concurrencyLimiter 按 provider 维护信号量，key 为 poolKey(platform, provider)
+type concurrencyLimiter struct {
+	mu         sync.Mutex
+	semaphores map[string]*providerSemaphore
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{semaphores: make(map[string]*providerSemaphore)}
+}
+
+// acquire 占用 provider 的一个并发空位，并发已满时排队等待；返回释放函数与等待时长
+// 未配置 MaxConcurrency 的 provider 不做限制
+func (cl *concurrencyLimiter) acquire(ctx context.Context, kind string, provider Provider, background bool) (func(), time.Duration, error) {
+	if provider.MaxConcurrency <= 0 {
+		return func() {}, 0, nil
+	}
+	key := poolKey(kind, provider.Name)
+	cl.mu.Lock()
+	sem := cl.semaphores[key]
+	if sem == nil {
+		sem = &providerSemaphore{}
+		cl.semaphores[key] = sem
+	}
+	// 配置变更后按新的上限放行，已占用的空位照常释放
+	sem.limit = provider.MaxConcurrency
+	release := func() { cl.release(key) }
+	if sem.active < sem.limit && sem.waiting() == 0 {
+		sem.active++
+		cl.mu.Unlock()
+		return release, 0, nil
+	}
+	maxQueue := provider.ConcurrencyQueue
+	if maxQueue <= 0 {
+		maxQueue = defaultConcurrencyQueue
+	}
+	if sem.waiting() >= maxQueue {
+		cl.mu.Unlock()
+		return nil, 0, &concurrencyLimitError{provider: provider.Name, reason: fmt.Sprintf("等待队列已满（%d）", maxQueue)}
+	}
+	waiter := &concurrencyWaiter{ready: make(chan struct{})}
+	if background {
+		sem.background = append(sem.background, waiter)
+	} else {
+		sem.interactive = append(sem.interactive, waiter)
+	}
+	cl.mu.Unlock()
+
+	wait := defaultConcurrencyWait
+	if provider.ConcurrencyWaitSeconds > 0 {
+		wait = time.Duration(provider.ConcurrencyWaitSeconds * float64(time.Second))
+	}
+	start := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-waiter.ready:
+		return release, time.Since(start), nil
+	case <-timer.C:
+		err = &concurrencyLimitError{provider: provider.Name, reason: fmt.Sprintf("等待超过 %.0fs", wait.Seconds())}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	cl.mu.Lock()
+	removed := sem.remove(waiter)
+	cl.mu.Unlock()
+	if !removed {
+		// 放弃等待的同时已获得空位，交还给下一个等待者
+		cl.release(key)
+	}
+	return nil, 0, err
+}
+
+// release 释放一个空位：有等待者时直接转交，否则减少占用数
+func (cl *concurrencyLimiter) release(key string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	sem := cl.semaphores[key]
+	if sem == nil {
+		return
+	}
+	if sem.active <= sem.limit {
+		if waiter := sem.next(); waiter != nil {
+			close(waiter.ready)
+			return
+		}
+	}
+	if sem.active > 0 {
+		sem.active--
+	}
+}
+
+// snapshot 返回配置了并发上限的 provider 当前的占用
+func (cl *concurrencyLimiter) snapshot(kind string, provider Provider) ProviderConcurrency {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	usage := ProviderConcurrency{Platform: kind, Provider: provider.Name, Limit: provider.MaxConcurrency}
+	if sem := cl.semaphores[poolKey(kind, provider.Name)]; sem != nil {
+		usage.Active = sem.active
+		usage.Waiting = sem.waiting()
+	}
+	return usage
+}
+
+// isBackgroundRequest 判断客户端是否将请求标记为后台任务
+func isBackgroundRequest(header http.Header) bool {
+	return strings.EqualFold(strings.TrimSpace(header.Get(requestPriorityHeader)), priorityBackground)
+}
+
+// ProviderConcurrency 返回配置了并发上限的 provider 当前进行中与排队的请求数
+func (prs *ProviderRelayService) ProviderConcurrency() []ProviderConcurrency {
+	result := []ProviderConcurrency{}
+	for _, kind := range []string{"claude", "codex"} {
+		providers, err := prs.loadProviders(kind)
+		if err != nil {
+			continue
+		}
+		for _, provider := range providers {
+			if provider.MaxConcurrency > 0 {
+				result = append(result, prs.concurrency.snapshot(kind, provider))
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimiterPrefersInteractiveRequests(t *testing.T) {
+	limiter := newConcurrencyLimiter()
+	provider := Provider{Name: "p", MaxConcurrency: 1, ConcurrencyQueue: 2, ConcurrencyWaitSeconds: 5}
+	release, _, err := limiter.acquire(context.Background(), "claude", provider, false)
+	if err != nil {
+		t.Fatalf("有空位时应直接获得: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	start := func(name string, background bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, _, err := limiter.acquire(context.Background(), "claude", provider, background)
+			if err != nil {
+				t.Errorf("%s 等待失败: %v", name, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			done()
+		}()
+	}
+	// 等待请求进入队列后再发起下一个，保证入队顺序
+	start("background", true)
+	for limiter.snapshot("claude", provider).Waiting < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	start("interactive", false)
+	for limiter.snapshot("claude", provider).Waiting < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 队列已满时直接返回错误
+	var limitErr *concurrencyLimitError
+	if _, _, err := limiter.acquire(context.Background(), "claude", provider, false); !errors.As(err, &limitErr) {
+		t.Fatalf("队列已满时应返回并发错误: %v", err)
+	}
+
+	release()
+	wg.Wait()
+	if strings.Join(order, ",") != "interactive,background" {
+		t.Fatalf("空位应优先交给交互请求: %v", order)
+	}
+	if usage := limiter.snapshot("claude", provider); usage.Active != 0 || usage.Waiting != 0 {
+		t.Fatalf("全部释放后不应有占用: %+v", usage)
+	}
+}
+
+func TestConcurrencyLimiterTimesOut(t *testing.T) {
+	limiter := newConcurrencyLimiter()
+	provider := Provider{Name: "p", MaxConcurrency: 1, ConcurrencyWaitSeconds: 0.02}
+	release, _, err := limiter.acquire(context.Background(), "claude", provider, false)
+	if err != nil {
+		t.Fatalf("有空位时应直接获得: %v", err)
+	}
+	var limitErr *concurrencyLimitError
+	if _, _, err := limiter.acquire(context.Background(), "claude", provider, false); !errors.As(err, &limitErr) || fallbackErrorType(err) != FallbackOnRateLimit {
+		t.Fatalf("等待超时应返回并发错误: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := limiter.acquire(ctx, "claude", provider, false); !errors.Is(err, context.Canceled) {
+		t.Fatalf("客户端断开时应停止等待: %v", err)
+	}
+	release()
+	if usage := limiter.snapshot("claude", provider); usage.Active != 0 || usage.Waiting != 0 {
+		t.Fatalf("放弃等待的请求不应占用空位: %+v", usage)
+	}
+	if _, _, err := limiter.acquire(context.Background(), "claude", Provider{Name: "unlimited"}, false); err != nil {
+		t.Fatalf("未配置并发上限时不应限制: %v", err)
+	}
+}
+
+func TestRelaySpillsOverWhenProviderConcurrencyIsFull(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	unblock := make(chan struct{})
+	entered := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var hits []string
+	handler := func(name string, block bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits = append(hits, name)
+			mu.Unlock()
+			if block {
+				entered <- struct{}{}
+				<-unblock
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":10,"output_tokens":5}}`)
+		}
+	}
+	primary := httptest.NewServer(handler("primary", true))
+	defer primary.Close()
+	backup := httptest.NewServer(handler("backup", false))
+	defer backup.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: primary.URL, APIKey: "sk-primary-1234567890", Enabled: true, Level: 1,
+			MaxConcurrency: 1, ConcurrencyWaitSeconds: 0.05},
+		{ID: 2, Name: "backup", APIURL: backup.URL, APIKey: "sk-backup-1234567890", Enabled: true, Level: 2},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- post() }()
+	<-entered
+	if usage := relay.ProviderConcurrency(); len(usage) != 1 || usage[0].Active != 1 || usage[0].Limit != 1 {
+		t.Fatalf("应统计进行中的请求: %+v", usage)
+	}
+	if rec := post(); rec.Code != http.StatusOK {
+		t.Fatalf("并发已满时应降级到下一个 provider: %d %s", rec.Code, rec.Body.String())
+	}
+	close(unblock)
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Fatalf("第一个请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(hits, ",") != "primary,backup" {
+		t.Fatalf("并发已满的 provider 不应收到第二个请求: %v", hits)
+	}
+	if usage := relay.ProviderConcurrency(); usage[0].Active != 0 || usage[0].Waiting != 0 {
+		t.Fatalf("请求结束后应释放空位: %+v", usage)
+	}
+}
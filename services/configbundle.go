@@ -0,0 +1,416 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	configBundleVersion = 1
+	secretPlaceholder   = "<fill-in-secret>"
+)
+
+// bundleKinds 是配置包中包含的 provider 类型
+var bundleKinds = []string{"claude", "codex"}
+
+// ConfigBundle 是可分享的完整配置包：provider（含模型映射）与 relay 配置
+// 导出时可将密钥替换为占位符，团队成员导入后只需补齐自己的 Key
+type ConfigBundle struct {
+	Version    int                   `json:"version"`
+	ExportedAt time.Time             `json:"exportedAt"`
+	Redacted   bool                  `json:"redacted"`
+	Providers  map[string][]Provider `json:"providers"`
+	Relay      *RelayConfig          `json:"relay,omitempty"`
+	Encryption *BundleEncryption     `json:"encryption,omitempty"`
+}
+
+// ConfigBundleImportResult 描述一次导入的结果
+type ConfigBundleImportResult struct {
+	Created        []string `json:"created"`
+	Updated        []string `json:"updated"`
+	MissingSecrets []string `json:"missingSecrets"`
+	RelayUpdated   bool     `json:"relayUpdated"`
+	DryRun         bool     `json:"dryRun"`
+}
+
+type ConfigBundleService struct {
+	providerService *ProviderService
+	relayConfig     *RelayConfigService
+}
+
+func NewConfigBundleService(ps *ProviderService, rcs *RelayConfigService) *ConfigBundleService {
+	return &ConfigBundleService{providerService: ps, relayConfig: rcs}
+}
+
+func (cbs *ConfigBundleService) Start() error { return nil }
+func (cbs *ConfigBundleService) Stop() error  { return nil }
+
+// BuildBundle 汇总当前配置；redactSecrets 为 true 时所有 Key 被替换为占位符
+func (cbs *ConfigBundleService) BuildBundle(redactSecrets bool) (ConfigBundle, error) {
+	bundle := ConfigBundle{
+		Version:    configBundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Redacted:   redactSecrets,
+		Providers:  make(map[string][]Provider, len(bundleKinds)),
+	}
+	for _, kind := range bundleKinds {
+		providers, err := cbs.providerService.LoadProviders(kind)
+		if err != nil {
+			return bundle, fmt.Errorf("加载 %s provider 失败: %w", kind, err)
+		}
+		if providers == nil {
+			providers = []Provider{}
+		}
+		if redactSecrets {
+			for i := range providers {
+				redactProviderSecrets(&providers[i])
+			}
+		}
+		bundle.Providers[kind] = providers
+	}
+	if cbs.relayConfig != nil {
+		cfg, err := cbs.relayConfig.GetRelayConfig()
+		if err != nil {
+			return bundle, fmt.Errorf("加载 relay 配置失败: %w", err)
+		}
+		if redactSecrets {
+			redactRelaySecrets(&cfg)
+		}
+		bundle.Relay = &cfg
+	}
+	return bundle, nil
+}
+
+// ExportBundle 将配置包写入 path
+func (cbs *ConfigBundleService) ExportBundle(path string, redactSecrets bool) error {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return fmt.Errorf("export path is required")
+	}
+	bundle, err := cbs.BuildBundle(redactSecrets)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// ImportBundleFile 从文件读取并导入配置包
+func (cbs *ConfigBundleService) ImportBundleFile(path string, dryRun bool) (ConfigBundleImportResult, error) {
+	data, err := os.ReadFile(strings.TrimSpace(path))
+	if err != nil {
+		return ConfigBundleImportResult{}, err
+	}
+	bundle, err := ParseConfigBundle(data)
+	if err != nil {
+		return ConfigBundleImportResult{}, err
+	}
+	return cbs.ImportBundle(bundle, dryRun)
+}
+
+// ParseConfigBundle 解析配置包并校验版本
+func ParseConfigBundle(data []byte) (ConfigBundle, error) {
+	var bundle ConfigBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return bundle, fmt.Errorf("解析配置包失败: %w", err)
+	}
+	if bundle.Version == 0 || bundle.Version > encryptedBundleVersion {
+		return bundle, fmt.Errorf("不支持的配置包版本: %d", bundle.Version)
+	}
+	if (bundle.Version == encryptedBundleVersion) != (bundle.Encryption != nil) {
+		return bundle, errors.New("配置包的版本与加密信息不一致")
+	}
+	return bundle, nil
+}
+
+// ImportBundle 按 provider 名称合并配置包：同名 provider 被覆盖（保留原 ID 与本地 Key），
+// 新 provider 追加到末尾；占位符 Key 优先沿用本地已有的 Key，否则留空并在结果中提示
+func (cbs *ConfigBundleService) ImportBundle(bundle ConfigBundle, dryRun bool) (ConfigBundleImportResult, error) {
+	result := ConfigBundleImportResult{
+		Created:        []string{},
+		Updated:        []string{},
+		MissingSecrets: []string{},
+		DryRun:         dryRun,
+	}
+	if bundle.Encryption != nil {
+		return result, errors.New("配置包中的 Key 已使用口令加密，请先使用口令解密")
+	}
+
+	kinds := make([]string, 0, len(bundle.Providers))
+	for kind := range bundle.Providers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	merged := make(map[string][]Provider, len(kinds))
+	for _, kind := range kinds {
+		existing, err := cbs.providerService.LoadProviders(kind)
+		if err != nil {
+			return result, fmt.Errorf("加载 %s provider 失败: %w", kind, err)
+		}
+		indexByName := make(map[string]int, len(existing))
+		nextID := 0
+		for i, p := range existing {
+			indexByName[p.Name] = i
+			if p.ID > nextID {
+				nextID = p.ID
+			}
+		}
+
+		for _, incoming := range bundle.Providers[kind] {
+			label := fmt.Sprintf("%s/%s", kind, incoming.Name)
+			if idx, ok := indexByName[incoming.Name]; ok {
+				local := existing[idx]
+				incoming.ID = local.ID
+				restored := fillRedactedSecrets(&incoming, &local)
+				existing[idx] = incoming
+				result.Updated = append(result.Updated, label)
+				if !restored {
+					result.MissingSecrets = append(result.MissingSecrets, label)
+					continue
+				}
+			} else {
+				nextID++
+				incoming.ID = nextID
+				restored := fillRedactedSecrets(&incoming, nil)
+				existing = append(existing, incoming)
+				indexByName[incoming.Name] = len(existing) - 1
+				result.Created = append(result.Created, label)
+				if !restored {
+					result.MissingSecrets = append(result.MissingSecrets, label)
+					continue
+				}
+			}
+			if !incoming.HasAPIKey() {
+				result.MissingSecrets = append(result.MissingSecrets, label)
+			}
+		}
+		merged[kind] = existing
+	}
+	result.RelayUpdated = bundle.Relay != nil && cbs.relayConfig != nil
+	if result.RelayUpdated {
+		local, err := cbs.relayConfig.GetRelayConfig()
+		if err != nil {
+			return result, fmt.Errorf("加载 relay 配置失败: %w", err)
+		}
+		relay := *bundle.Relay
+		result.MissingSecrets = append(result.MissingSecrets, fillRedactedRelaySecrets(&relay, local)...)
+		bundle.Relay = &relay
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	for _, kind := range kinds {
+		if err := cbs.providerService.SaveProviders(kind, merged[kind]); err != nil {
+			return result, fmt.Errorf("保存 %s provider 失败: %w", kind, err)
+		}
+	}
+	if result.RelayUpdated {
+		if _, err := cbs.relayConfig.SaveRelayConfig(*bundle.Relay); err != nil {
+			return result, fmt.Errorf("保存 relay 配置失败: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// redactProviderSecrets 将 Key、凭据类 header 的值替换为占位符并去掉代理地址中的用户名密码；
+// 环境变量、文件与钥匙串引用本身不含 Key，保留以便提交到团队仓库
+func redactProviderSecrets(p *Provider) {
+	if strings.TrimSpace(p.APIKey) != "" && !isConfigReference(p.APIKey) {
+		p.APIKey = secretPlaceholder
+	}
+	for i := range p.APIKeys {
+		if !isConfigReference(p.APIKeys[i]) {
+			p.APIKeys[i] = secretPlaceholder
+		}
+	}
+	if p.Headers != nil {
+		redactHeaderSecrets(p.Headers.Set)
+		redactHeaderSecrets(p.Headers.Append)
+	}
+	p.Proxy = redactProxyURL(p.Proxy)
+}
+
+// redactRelaySecrets 去掉 relay 代理地址中的用户名密码，并隐藏模型访问规则中的客户端 Key
+func redactRelaySecrets(cfg *RelayConfig) {
+	cfg.Proxy.URL = redactProxyURL(cfg.Proxy.URL)
+	rules := make([]ModelPolicyRule, len(cfg.ModelPolicy.Rules))
+	for i, rule := range cfg.ModelPolicy.Rules {
+		keys := make([]string, len(rule.ClientKeys))
+		for j, key := range rule.ClientKeys {
+			keys[j] = key
+			if !isConfigReference(key) {
+				keys[j] = secretPlaceholder
+			}
+		}
+		if rule.ClientKeys != nil {
+			rule.ClientKeys = keys
+		}
+		rules[i] = rule
+	}
+	if cfg.ModelPolicy.Rules != nil {
+		cfg.ModelPolicy.Rules = rules
+	}
+}
+
+// isSensitiveHeader 判断 header 的值是否为凭据（Authorization、x-api-key、Cookie、*-token 等）
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "authorization", "proxy-authorization", "cookie", "x-api-key", "api-key":
+		return true
+	}
+	return strings.HasSuffix(name, "-key") || strings.Contains(name, "token") || strings.Contains(name, "secret")
+}
+
+func redactHeaderSecrets(values map[string]string) {
+	for name, value := range values {
+		if isSensitiveHeader(name) && strings.TrimSpace(value) != "" && !isConfigReference(value) {
+			values[name] = secretPlaceholder
+		}
+	}
+}
+
+// redactProxyURL 去掉代理地址中的用户名密码，无法解析的地址原样保留（配置校验会拒绝）
+func redactProxyURL(raw string) string {
+	if strings.TrimSpace(raw) == "" || raw == ProxyDirect || isConfigReference(raw) {
+		return raw
+	}
+	proxyURL, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || proxyURL.User == nil {
+		return raw
+	}
+	proxyURL.User = nil
+	return proxyURL.String()
+}
+
+// restoreProxyURL 导入不含用户名密码的代理地址时，若本地使用同一代理则沿用本地的用户名密码
+func restoreProxyURL(incoming string, local string) string {
+	if redactProxyURL(local) != local && redactProxyURL(local) == incoming {
+		return local
+	}
+	return incoming
+}
+
+func isSecretPlaceholder(value string) bool {
+	return strings.TrimSpace(value) == secretPlaceholder
+}
+
+// fillRedactedSecrets 将占位符替换为本地已有的 Key 与 header 值（没有则清空），
+// 有占位符的 header 在本地没有对应值时被删除并返回 false
+func fillRedactedSecrets(incoming *Provider, local *Provider) bool {
+	if isSecretPlaceholder(incoming.APIKey) {
+		incoming.APIKey = ""
+		if local != nil {
+			incoming.APIKey = local.APIKey
+		}
+	}
+	keys := make([]string, 0, len(incoming.APIKeys))
+	redacted := false
+	for _, key := range incoming.APIKeys {
+		if isSecretPlaceholder(key) {
+			redacted = true
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if redacted && local != nil {
+		keys = append(keys, local.APIKeys...)
+	}
+	incoming.APIKeys = keys
+	if len(incoming.APIKeys) == 0 {
+		incoming.APIKeys = nil
+	}
+
+	restored := true
+	if incoming.Headers != nil {
+		// 复制后再补齐，不修改调用方的配置包
+		headers := *incoming.Headers
+		headers.Set, headers.Append = cloneStringMap(headers.Set), cloneStringMap(headers.Append)
+		incoming.Headers = &headers
+		var localSet, localAppend map[string]string
+		if local != nil && local.Headers != nil {
+			localSet, localAppend = local.Headers.Set, local.Headers.Append
+		}
+		restored = fillHeaderSecrets(incoming.Headers.Set, localSet) && restored
+		restored = fillHeaderSecrets(incoming.Headers.Append, localAppend) && restored
+	}
+	if local != nil {
+		incoming.Proxy = restoreProxyURL(incoming.Proxy, local.Proxy)
+	}
+	return restored
+}
+
+func fillHeaderSecrets(values map[string]string, local map[string]string) bool {
+	restored := true
+	for name, value := range values {
+		if !isSecretPlaceholder(value) {
+			continue
+		}
+		if localValue, ok := lookupHeaderValue(local, name); ok && !isSecretPlaceholder(localValue) {
+			values[name] = localValue
+			continue
+		}
+		delete(values, name)
+		restored = false
+	}
+	return restored
+}
+
+func lookupHeaderValue(values map[string]string, name string) (string, bool) {
+	for key, value := range values {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// fillRedactedRelaySecrets 沿用本地的代理用户名密码与同名规则的客户端 Key，返回无法补齐的项
+func fillRedactedRelaySecrets(cfg *RelayConfig, local RelayConfig) []string {
+	cfg.Proxy.URL = restoreProxyURL(cfg.Proxy.URL, local.Proxy.URL)
+	localKeys := make(map[string][]string, len(local.ModelPolicy.Rules))
+	for _, rule := range local.ModelPolicy.Rules {
+		localKeys[rule.Name] = rule.ClientKeys
+	}
+	var missing []string
+	rules := make([]ModelPolicyRule, len(cfg.ModelPolicy.Rules))
+	for i, rule := range cfg.ModelPolicy.Rules {
+		keys := make([]string, 0, len(rule.ClientKeys))
+		redacted := false
+		for _, key := range rule.ClientKeys {
+			if isSecretPlaceholder(key) {
+				redacted = true
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if redacted {
+			if restored, ok := localKeys[rule.Name]; ok {
+				rule.ClientKeys = append(keys, restored...)
+			} else {
+				// 保留占位符：规则不匹配任何客户端，而不是在清空 Key 后对所有客户端生效
+				missing = append(missing, "relay/modelPolicy/"+rule.Name)
+			}
+		}
+		rules[i] = rule
+	}
+	if cfg.ModelPolicy.Rules != nil {
+		cfg.ModelPolicy.Rules = rules
+	}
+	return missing
+}
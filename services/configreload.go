@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 后台检查配置文件变化的间隔
+const configWatchInterval = 2 * time.Second
+
+// ConfigReloadStatus 是一个配置文件的加载状态
+type ConfigReloadStatus struct {
+	// claude、codex 或 relay
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// 正在使用的配置的加载时间
+	LoadedAt time.Time `json:"loaded_at"`
+	// 最近一次加载失败的原因，失败时继续使用上一次有效的配置；加载成功后清空
+	Error    string    `json:"error,omitempty"`
+	FailedAt time.Time `json:"failed_at,omitempty"`
+}
+
+type watchedConfig struct {
+	checked   bool
+	modTime   time.Time
+	size      int64
+	loaded    bool
+	providers []Provider
+	relay     RelayConfig
+	status    ConfigReloadStatus
+}
+
+// configReloader 在配置文件变化时重新加载并校验 provider 与 relay 配置，文件未变化时直接使用已加载的配置；
+// 新配置无效时继续使用上一次有效的配置，进行中的请求使用各自开始时的配置，不受影响
+type configReloader struct {
+	mu    sync.Mutex
+	files map[string]*watchedConfig
+	stop  chan struct{}
+}
+
+func newConfigReloader() *configReloader {
+	return &configReloader{files: make(map[string]*watchedConfig)}
+}
+
+func (cr *configReloader) entry(name string, path string) *watchedConfig {
+	w, ok := cr.files[name]
+	if !ok {
+		w = &watchedConfig{status: ConfigReloadStatus{Name: name}}
+		cr.files[name] = w
+	}
+	w.status.Path = path
+	return w
+}
+
+// changed 判断文件自上次检查后是否变化，并记录本次检查的状态
+func (w *watchedConfig) changed(path string) bool {
+	var modTime time.Time
+	var size int64 = -1
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	if w.checked && modTime.Equal(w.modTime) && size == w.size {
+		return false
+	}
+	w.checked, w.modTime, w.size = true, modTime, size
+	return true
+}
+
+// accept 更新加载结果；已有有效配置时拒绝无效的新配置，首次加载时无效的配置仍会使用（与逐个跳过无效 provider 的行为一致）
+func (w *watchedConfig) accept(problems []string, fatal bool) bool {
+	if len(problems) == 0 {
+		w.status.LoadedAt, w.status.Error, w.status.FailedAt = time.Now(), "", time.Time{}
+		return true
+	}
+	w.status.Error, w.status.FailedAt = strings.Join(problems, "; "), time.Now()
+	if w.loaded {
+		fmt.Printf("[WARN] 配置 %s 无效，继续使用上一次有效的配置: %s\n", w.status.Path, w.status.Error)
+		return false
+	}
+	if fatal {
+		fmt.Printf("[WARN] 配置 %s 加载失败: %s\n", w.status.Path, w.status.Error)
+		return false
+	}
+	w.status.LoadedAt = time.Now()
+	return true
+}
+
+// providers 返回 kind 生效的 provider 列表；从未成功加载时返回读取错误
+func (cr *configReloader) providers(ps *ProviderService, kind string) ([]Provider, error) {
+	path, err := providerFilePath(kind)
+	if err != nil {
+		return nil, err
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	w := cr.entry(kind, path)
+	if !w.changed(path) {
+		if !w.loaded {
+			return nil, errors.New(w.status.Error)
+		}
+		return w.providers, nil
+	}
+	providers, err := ps.LoadProviders(kind)
+	if err != nil {
+		w.accept([]string{fmt.Sprintf("读取配置失败: %v", err)}, true)
+		if !w.loaded {
+			return nil, err
+		}
+		return w.providers, nil
+	}
+	problems, _ := providerConfigIssues(kind, providers)
+	problems = append(problems, duplicateProviderNames(providers)...)
+	if w.accept(append(problems, resolveProviderReferences(kind, providers)...), false) {
+		if w.loaded {
+			fmt.Printf("[INFO] 已重新加载 %s 的 provider 配置（%d 个 provider）\n", kind, len(providers))
+		}
+		w.providers, w.loaded = providers, true
+		if kind == "codex" {
+			// codex provider 变化时同步 apply codex 生成的 profile 与默认模型
+			if changed, err := SyncCodexConfig(providers); err != nil {
+				fmt.Printf("[WARN] 同步 Codex 配置失败: %v\n", err)
+			} else if changed {
+				fmt.Printf("[INFO] 已根据 codex provider 更新 Codex 配置\n")
+			}
+		}
+	}
+	return w.providers, nil
+}
+
+// relay 返回生效的 relay 配置；从未成功加载时使用默认配置
+func (cr *configReloader) relay(rcs *RelayConfigService) RelayConfig {
+	if rcs == nil {
+		return defaultRelayConfig()
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	w := cr.entry("relay", rcs.path)
+	if !w.changed(rcs.path) {
+		if !w.loaded {
+			return defaultRelayConfig()
+		}
+		return w.relay
+	}
+	cfg, err := rcs.GetRelayConfig()
+	if err != nil {
+		w.accept([]string{fmt.Sprintf("读取配置失败: %v", err)}, true)
+		if !w.loaded {
+			return defaultRelayConfig()
+		}
+		return w.relay
+	}
+	if w.accept(cfg.validate(), false) {
+		if w.loaded {
+			fmt.Printf("[INFO] 已重新加载 relay 配置\n")
+		}
+		w.relay, w.loaded = cfg, true
+	}
+	return w.relay
+}
+
+func (cr *configReloader) snapshot() []ConfigReloadStatus {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	statuses := make([]ConfigReloadStatus, 0, len(cr.files))
+	for _, name := range []string{"claude", "codex", "relay"} {
+		if w, ok := cr.files[name]; ok {
+			statuses = append(statuses, w.status)
+		}
+	}
+	return statuses
+}
+
+func duplicateProviderNames(providers []Provider) []string {
+	var problems []string
+	seen := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		if seen[p.Name] {
+			problems = append(problems, fmt.Sprintf("provider 名称重复: %s", p.Name))
+		}
+		seen[p.Name] = true
+	}
+	return problems
+}
+
+// loadProviders 返回 kind 生效的 provider 配置，配置文件变化时自动重新加载
+func (prs *ProviderRelayService) loadProviders(kind string) ([]Provider, error) {
+	if prs.reloader == nil {
+		providers, err := prs.providerService.LoadProviders(kind)
+		for _, msg := range resolveProviderReferences(kind, providers) {
+			fmt.Printf("[WARN] %s\n", msg)
+		}
+		return providers, err
+	}
+	return prs.reloader.providers(prs.providerService, kind)
+}
+
+// ReloadConfig 立即检查配置文件并加载变化，返回各配置文件的加载状态
+func (prs *ProviderRelayService) ReloadConfig() []ConfigReloadStatus {
+	for _, kind := range []string{"claude", "codex"} {
+		prs.loadProviders(kind)
+	}
+	prs.loadRelayConfig()
+	return prs.reloader.snapshot()
+}
+
+// ConfigReloadStatus 返回各配置文件的加载状态与最近一次校验错误
+func (prs *ProviderRelayService) ConfigReloadStatus() []ConfigReloadStatus {
+	return prs.reloader.snapshot()
+}
+
+// watchConfig 定期检查配置文件，使配置错误能及时出现在日志与管理接口中
+func (prs *ProviderRelayService) watchConfig() {
+	stop := make(chan struct{})
+	prs.reloader.mu.Lock()
+	prs.reloader.stop = stop
+	prs.reloader.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				prs.ReloadConfig()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (prs *ProviderRelayService) stopWatchingConfig() {
+	prs.reloader.mu.Lock()
+	defer prs.reloader.mu.Unlock()
+	if prs.reloader.stop != nil {
+		close(prs.reloader.stop)
+		prs.reloader.stop = nil
+	}
+}
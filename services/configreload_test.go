@@ -0,0 +1,119 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRelayReloadsConfigAndKeepsLastGood(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+		}))
+	}
+	first := newUpstream("first")
+	defer first.Close()
+	second := newUpstream("second")
+	defer second.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "first", APIURL: first.URL, APIKey: "sk-first-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	admin := func(method string, path string) []ConfigReloadStatus {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:4321"
+		req.Host = "localhost:18100"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp struct {
+			Files []ConfigReloadStatus `json:"files"`
+		}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+			t.Fatalf("管理接口请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+		return resp.Files
+	}
+	path, err := providerFilePath("claude")
+	if err != nil {
+		t.Fatalf("获取配置路径失败: %v", err)
+	}
+	writeProviders := func(providers []Provider) {
+		data, _ := json.Marshal(providerEnvelope{Providers: providers})
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("写入配置失败: %v", err)
+		}
+	}
+
+	if code := send(); code != http.StatusOK || strings.Join(hits, ",") != "first" {
+		t.Fatalf("请求失败: %d %v", code, hits)
+	}
+
+	// 手动编辑的文件不完整或校验失败时继续使用上一次有效的配置
+	os.WriteFile(path, []byte(`{"providers": [`), 0o644)
+	if code := send(); code != http.StatusOK || hits[len(hits)-1] != "first" {
+		t.Fatalf("配置无法解析时应继续使用上一次有效的配置: %d %v", code, hits)
+	}
+	writeProviders([]Provider{
+		{ID: 2, Name: "second", APIURL: second.URL, APIKey: "sk-second-1234567890", Enabled: true, CacheControl: "always"},
+	})
+	if code := send(); code != http.StatusOK || hits[len(hits)-1] != "first" {
+		t.Fatalf("配置校验失败时应继续使用上一次有效的配置: %d %v", code, hits)
+	}
+	files := admin(http.MethodGet, "/admin/config")
+	if len(files) == 0 || files[0].Name != "claude" || !strings.Contains(files[0].Error, "cacheControl") {
+		t.Fatalf("管理接口应返回校验错误: %+v", files)
+	}
+
+	// 有效的新配置立即生效，不需要重启
+	writeProviders([]Provider{
+		{ID: 2, Name: "second", APIURL: second.URL, APIKey: "sk-second-1234567890", Enabled: true},
+	})
+	files = admin(http.MethodPost, "/admin/config/reload")
+	if files[0].Error != "" {
+		t.Fatalf("加载成功后应清空错误: %+v", files)
+	}
+	if code := send(); code != http.StatusOK || hits[len(hits)-1] != "second" {
+		t.Fatalf("应使用重新加载的配置: %d %v", code, hits)
+	}
+
+	// relay 配置同样保留上一次有效的版本
+	cfg := defaultRelayConfig()
+	cfg.Cache = ResponseCacheConfig{Enabled: true}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	if !relay.loadRelayConfig().Cache.Enabled {
+		t.Fatalf("应加载修改后的 relay 配置")
+	}
+	cfg.Proxy = ProxyConfig{URL: "ftp://proxy.corp"}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	if loaded := relay.loadRelayConfig(); loaded.Proxy.URL != "" || !loaded.Cache.Enabled {
+		t.Fatalf("relay 配置校验失败时应继续使用上一次有效的配置: %+v", loaded.Proxy)
+	}
+}
@@ -0,0 +1,569 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+// 配置检查问题的严重程度
+const (
+	ConfigIssueError   = "error"
+	ConfigIssueWarning = "warning"
+)
+
+const defaultConnectivityTimeout = 10 * time.Second
+
+// ConfigValidateOptions 控制 config validate 的检查范围
+type ConfigValidateOptions struct {
+	// 对每个启用的 provider 发送一次不带 Key 的请求，检查 DNS、代理与 TLS 设置是否可用
+	Connectivity        bool
+	ConnectivityTimeout time.Duration
+	// 用于检查模型名的价格数据，为 nil 时不检查
+	Pricing *modelpricing.Service
+}
+
+// ConfigIssue 是配置文件中的一个问题，Line 与 Column 从 1 开始，无法定位时为 0
+type ConfigIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// String 以 file:line:column 的形式输出，便于在编辑器中跳转
+func (i ConfigIssue) String() string {
+	location := i.File
+	if i.Line > 0 {
+		location = fmt.Sprintf("%s:%d:%d", i.File, i.Line, i.Column)
+	}
+	severity := "错误"
+	if i.Severity == ConfigIssueWarning {
+		severity = "警告"
+	}
+	return fmt.Sprintf("%s: %s: %s", location, severity, i.Message)
+}
+
+// ConfigValidateReport 汇总配置检查的结果
+type ConfigValidateReport struct {
+	// 已检查的配置文件
+	Files    []string      `json:"files"`
+	Issues   []ConfigIssue `json:"issues"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+}
+
+// OK 判断是否没有错误，警告不影响结果
+func (r ConfigValidateReport) OK() bool {
+	return r.Errors == 0
+}
+
+// configFileChecker 在一个配置文件内按 JSON 路径定位问题
+type configFileChecker struct {
+	path    string
+	data    []byte
+	offsets map[string]int64
+	report  *ConfigValidateReport
+}
+
+// add 记录问题，位置取 paths 中第一个能在文件中找到的路径
+func (c *configFileChecker) add(severity string, paths []string, format string, args ...interface{}) {
+	issue := ConfigIssue{File: c.path, Severity: severity, Message: fmt.Sprintf(format, args...)}
+	for _, path := range paths {
+		if offset, ok := c.offsets[path]; ok {
+			issue.Line, issue.Column = lineColumn(c.data, offset)
+			break
+		}
+	}
+	c.addIssue(issue)
+}
+
+func (c *configFileChecker) addAt(severity string, offset int64, format string, args ...interface{}) {
+	issue := ConfigIssue{File: c.path, Severity: severity, Message: fmt.Sprintf(format, args...)}
+	issue.Line, issue.Column = lineColumn(c.data, offset)
+	c.addIssue(issue)
+}
+
+func (c *configFileChecker) addIssue(issue ConfigIssue) {
+	if issue.Severity == ConfigIssueWarning {
+		c.report.Warnings++
+	} else {
+		c.report.Errors++
+	}
+	c.report.Issues = append(c.report.Issues, issue)
+}
+
+// parse 解析配置文件并检查未知字段；返回 false 表示文件无法解析，后续检查没有意义
+func (c *configFileChecker) parse(target interface{}) bool {
+	err := json.Unmarshal(c.data, target)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		c.addAt(ConfigIssueError, syntaxErr.Offset-1, "JSON 语法错误: %v", syntaxErr)
+		return false
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		c.addAt(ConfigIssueError, typeErr.Offset-1, "字段 %s 应为 %s，实际为 JSON %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	} else if err != nil {
+		c.add(ConfigIssueError, nil, "解析配置失败: %v", err)
+		return false
+	}
+
+	walker := &jsonWalker{data: c.data, dec: json.NewDecoder(bytes.NewReader(c.data)), offsets: c.offsets}
+	if err := walker.walk("", reflect.TypeOf(target)); err != nil {
+		c.add(ConfigIssueError, nil, "解析配置失败: %v", err)
+		return false
+	}
+	for _, field := range walker.unknown {
+		if field.suggestion != "" {
+			c.add(ConfigIssueError, []string{field.path}, "未知字段 %q，是否想写 %q？", field.key, field.suggestion)
+		} else {
+			c.add(ConfigIssueError, []string{field.path}, "未知字段 %q，该设置不会生效", field.key)
+		}
+	}
+	return true
+}
+
+// ValidateConfig 检查本机的 provider 与 relay 配置文件：JSON 语法、未知字段、各类 provider 的必填项、
+// 配置的模型能否在价格数据中找到，以及可选的连通性检查；不存在的配置文件跳过
+func ValidateConfig(opts ConfigValidateOptions) ConfigValidateReport {
+	report := ConfigValidateReport{Files: []string{}, Issues: []ConfigIssue{}}
+	relayCfg := defaultRelayConfig()
+	rcs := NewRelayConfigService()
+	if data, ok := readConfigFile(rcs.path, &report); ok {
+		checker := &configFileChecker{path: rcs.path, data: data, offsets: make(map[string]int64), report: &report}
+		cfg := defaultRelayConfig()
+		if checker.parse(&cfg) {
+			checker.checkRelay(cfg)
+			relayCfg = cfg
+		}
+	}
+
+	for _, kind := range bundleKinds {
+		path, err := providerFilePath(kind)
+		if err != nil {
+			report.Errors++
+			report.Issues = append(report.Issues, ConfigIssue{File: kind, Severity: ConfigIssueError, Message: err.Error()})
+			continue
+		}
+		data, ok := readConfigFile(path, &report)
+		if !ok {
+			continue
+		}
+		checker := &configFileChecker{path: path, data: data, offsets: make(map[string]int64), report: &report}
+		var envelope providerEnvelope
+		if !checker.parse(&envelope) {
+			continue
+		}
+		checker.checkProviders(kind, envelope.Providers, opts.Pricing)
+		if opts.Connectivity {
+			checker.checkConnectivity(envelope.Providers, relayCfg, opts.ConnectivityTimeout)
+		}
+	}
+	return report
+}
+
+func readConfigFile(path string, report *ConfigValidateReport) ([]byte, bool) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+	report.Files = append(report.Files, path)
+	if err != nil {
+		report.Errors++
+		report.Issues = append(report.Issues, ConfigIssue{File: path, Severity: ConfigIssueError, Message: fmt.Sprintf("读取配置失败: %v", err)})
+		return nil, false
+	}
+	return data, len(strings.TrimSpace(string(data))) > 0
+}
+
+func (c *configFileChecker) checkRelay(cfg RelayConfig) {
+	for _, msg := range cfg.validate() {
+		// 带字段前缀的错误定位到对应字段
+		field, _, _ := strings.Cut(msg, ":")
+		c.add(ConfigIssueError, []string{field}, "%s", msg)
+	}
+}
+
+// checkProviders 检查每个 provider，providers 中的引用会被展开，供后续的连通性检查使用
+func (c *configFileChecker) checkProviders(kind string, providers []Provider, pricing *modelpricing.Service) {
+	seen := make(map[string]int, len(providers))
+	for i, p := range providers {
+		path := fmt.Sprintf("providers[%d]", i)
+		at := func(field string) []string { return []string{path + "." + field, path} }
+		label := fmt.Sprintf("[%s/%s]", kind, p.Name)
+
+		if strings.TrimSpace(p.Name) == "" {
+			c.add(ConfigIssueError, at("name"), "[%s] 第 %d 个 provider 未配置 name", kind, i+1)
+			label = fmt.Sprintf("[%s/#%d]", kind, i+1)
+		} else if first, ok := seen[p.Name]; ok {
+			c.add(ConfigIssueError, at("name"), "%s provider 名称与第 %d 个 provider 重复，统计与熔断状态会互相覆盖", label, first+1)
+		} else {
+			seen[p.Name] = i + 1
+		}
+
+		for _, msg := range p.ValidateConfiguration() {
+			if strings.HasPrefix(msg, "警告：") {
+				c.add(ConfigIssueWarning, []string{path}, "%s %s", label, strings.TrimPrefix(msg, "警告："))
+			} else {
+				c.add(ConfigIssueError, []string{path}, "%s %s", label, msg)
+			}
+		}
+		// 检查展开环境变量、文件与钥匙串引用之后的配置；未启用的 provider 不会被使用，缺少必填项不影响运行
+		severity := ConfigIssueError
+		if !p.Enabled {
+			severity = ConfigIssueWarning
+		}
+		problems := providers[i].resolveReferences()
+		for _, msg := range problems {
+			field, _, _ := strings.Cut(msg, ":")
+			c.add(severity, at(field), "%s %s", label, msg)
+		}
+		if p.Enabled && len(problems) == 0 {
+			c.checkRequired(providers[i], label, at)
+		}
+		c.checkModels(p, label, path, pricing)
+	}
+}
+
+// checkRequired 检查 provider 参与路由所需的字段，与 relay 跳过不可用 provider 的条件一致
+func (c *configFileChecker) checkRequired(p Provider, label string, at func(string) []string) {
+	if p.upstreamBaseURL() == "" {
+		switch {
+		case p.APIFormat == APIFormatVertex:
+			c.add(ConfigIssueError, at("apiUrl"), "%s 未配置 apiUrl，也无法从 vertex.projectId 或服务账号中确定项目", label)
+		default:
+			c.add(ConfigIssueError, at("apiUrl"), "%s 未配置 apiUrl，该 provider 会被跳过", label)
+		}
+	} else if err := validateUpstreamURL(p.upstreamBaseURL()); err != nil {
+		c.add(ConfigIssueError, at("apiUrl"), "%s apiUrl 无效: %v", label, err)
+	}
+	if p.Local != nil {
+		return
+	}
+	keys := p.AllAPIKeys()
+	strategy, _ := p.AuthStrategy()
+	switch {
+	case len(keys) == 0:
+		hint := "apiKey"
+		switch strategy.(type) {
+		case awsSigV4Auth:
+			hint = "apiKey（格式为 ACCESS_KEY_ID:SECRET_ACCESS_KEY）"
+		case googleOAuthAuth:
+			hint = "apiKey（服务账号 JSON 或其文件路径）"
+		}
+		c.add(ConfigIssueError, at("apiKey"), "%s 未配置 %s，该 provider 会被跳过", label, hint)
+	case isSecretPlaceholder(keys[0]):
+		c.add(ConfigIssueError, at("apiKey"), "%s apiKey 仍是导出时的占位符 %s，请填写真实的 Key", label, secretPlaceholder)
+	default:
+		switch strategy.(type) {
+		case awsSigV4Auth:
+			if parts := strings.SplitN(keys[0], ":", 3); len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+				c.add(ConfigIssueError, at("apiKey"), "%s aws-sigv4 认证的 apiKey 格式应为 ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]", label)
+			}
+		case googleOAuthAuth:
+			if _, err := parseGoogleCredentials(keys[0]); err != nil {
+				c.add(ConfigIssueError, at("apiKey"), "%s %v", label, err)
+			}
+		}
+	}
+}
+
+func validateUpstreamURL(raw string) error {
+	req, err := http.NewRequest(http.MethodGet, raw, nil)
+	if err != nil {
+		return err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("需要以 http:// 或 https:// 开头")
+	}
+	if req.URL.Host == "" {
+		return fmt.Errorf("缺少主机名")
+	}
+	return nil
+}
+
+// checkModels 检查白名单、映射与改写规则中发送给上游的模型能否在价格数据中找到，找不到或只能模糊匹配时通常是拼写错误
+func (c *configFileChecker) checkModels(p Provider, label string, path string, pricing *modelpricing.Service) {
+	if pricing == nil {
+		return
+	}
+	type reference struct {
+		model string
+		path  string
+	}
+	var refs []reference
+	for model := range p.SupportedModels {
+		refs = append(refs, reference{model, path + ".supportedModels." + model})
+	}
+	for external, model := range p.ModelMapping {
+		refs = append(refs, reference{model, path + ".modelMapping." + external})
+	}
+	for i, rule := range p.ModelRewrites {
+		refs = append(refs, reference{rule.Target, fmt.Sprintf("%s.modelRewrites[%d].target", path, i)})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].path < refs[j].path })
+
+	checked := make(map[string]bool, len(refs))
+	var catalog []string
+	for _, ref := range refs {
+		// 通配符与捕获组引用需要具体请求才能展开
+		if ref.model == "" || strings.ContainsAny(ref.model, "*$") || checked[ref.model] {
+			continue
+		}
+		checked[ref.model] = true
+		match := pricing.MatchModel(ref.model)
+		switch {
+		case !match.Found():
+			if catalog == nil {
+				catalog = pricing.ListModels()
+			}
+			if suggestion := closestName(ref.model, catalog); suggestion != "" {
+				c.add(ConfigIssueWarning, []string{ref.path, path}, "%s 价格数据中没有模型 %q，是否想写 %q？费用将无法统计", label, ref.model, suggestion)
+			} else {
+				c.add(ConfigIssueWarning, []string{ref.path, path}, "%s 价格数据中没有模型 %q，费用将无法统计", label, ref.model)
+			}
+		case match.Strategy == modelpricing.MatchFuzzy:
+			c.add(ConfigIssueWarning, []string{ref.path, path}, "%s 模型 %q 只能模糊匹配到价格条目 %q，请确认模型名是否正确", label, ref.model, match.Key)
+		}
+	}
+}
+
+// checkConnectivity 使用 provider 的代理与 TLS 设置请求上游地址，不携带 Key；收到任何 HTTP 响应都视为可连通
+func (c *configFileChecker) checkConnectivity(providers []Provider, relayCfg RelayConfig, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultConnectivityTimeout
+	}
+	clients := newUpstreamClients()
+	for i, p := range providers {
+		baseURL := p.upstreamBaseURL()
+		if !p.Enabled || baseURL == "" || validateUpstreamURL(baseURL) != nil {
+			continue
+		}
+		at := []string{fmt.Sprintf("providers[%d].apiUrl", i), fmt.Sprintf("providers[%d]", i)}
+		connect, _, _ := resolveTimeouts(p.Timeouts, relayCfg.Timeouts)
+		client, err := clients.get(p.Name, upstreamTransport{connect: connect, proxy: resolveProxy(p.Proxy, relayCfg.Proxy), tls: p.TLS})
+		if err != nil {
+			c.add(ConfigIssueError, at, "[%s] TLS 配置无效: %v", p.Name, err)
+			continue
+		}
+		req, _ := http.NewRequest(http.MethodGet, baseURL, nil)
+		resp, err := (&http.Client{Transport: client.Transport, Timeout: timeout}).Do(req)
+		if err != nil {
+			c.add(ConfigIssueError, at, "[%s] 无法连接 %s: %v", p.Name, baseURL, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// jsonWalker 按目标类型遍历 JSON，记录每个字段的位置并找出类型中不存在的字段（json 解析时会被静默忽略）
+type jsonWalker struct {
+	data    []byte
+	dec     *json.Decoder
+	offsets map[string]int64
+	unknown []unknownJSONField
+}
+
+type unknownJSONField struct {
+	path       string
+	key        string
+	suggestion string
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func (w *jsonWalker) walk(path string, t reflect.Type) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// 自定义解析的类型无法按字段检查
+	if t != nil && reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		t = nil
+	}
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	switch delim {
+	case '{':
+		var fields map[string]reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+		for w.dec.More() {
+			offset := w.nextOffset()
+			keyTok, err := w.dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := keyTok.(string)
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			w.offsets[child] = offset
+			var childType reflect.Type
+			switch {
+			case fields != nil:
+				var found bool
+				if childType, found = lookupJSONField(fields, key); !found {
+					w.unknown = append(w.unknown, unknownJSONField{path: child, key: key, suggestion: closestName(key, sortedKeys(fields))})
+				}
+			case t != nil && t.Kind() == reflect.Map:
+				childType = t.Elem()
+			}
+			if err := w.walk(child, childType); err != nil {
+				return err
+			}
+		}
+	case '[':
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i := 0; w.dec.More(); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			w.offsets[child] = w.nextOffset()
+			if err := w.walk(child, elem); err != nil {
+				return err
+			}
+		}
+	}
+	// 读取结束的 } 或 ]
+	_, err = w.dec.Token()
+	return err
+}
+
+// nextOffset 返回下一个 token 的起始位置
+func (w *jsonWalker) nextOffset() int64 {
+	offset := w.dec.InputOffset()
+	for offset < int64(len(w.data)) && strings.IndexByte(" \t\r\n,:", w.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// jsonFields 返回结构体可解析的 JSON 字段名及其类型，包含匿名嵌入结构体的字段
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range jsonFields(embedded) {
+					fields[k] = v
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// lookupJSONField 与 encoding/json 一致，字段名不区分大小写
+func lookupJSONField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func sortedKeys(m map[string]reflect.Type) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closestName 返回编辑距离足够接近的候选名称，没有时返回空字符串
+func closestName(name string, candidates []string) string {
+	lower := strings.ToLower(name)
+	maxDistance := utf8.RuneCountInString(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	if maxDistance > 3 {
+		maxDistance = 3
+	}
+	best, bestDistance := "", maxDistance+1
+	for _, candidate := range candidates {
+		if d := editDistance(lower, strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// lineColumn 将字节偏移转换为从 1 开始的行号与列号（按字符计数）
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(os.Getenv("HOME"), ".code-switch", name)
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("写入配置失败: %v", err)
+	}
+	return path
+}
+
+func findIssue(report ConfigValidateReport, text string) (ConfigIssue, bool) {
+	for _, issue := range report.Issues {
+		if strings.Contains(issue.Message, text) {
+			return issue, true
+		}
+	}
+	return ConfigIssue{}, false
+}
+
+func TestValidateConfigReportsIssuesWithLineNumbers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	claudePath := writeConfigFile(t, "claude-code.json", `{
+  "providers": [
+    {
+      "id": 1,
+      "name": "primary",
+      "apiUrl": "https://api.example.com",
+      "apiKey": "sk-primary-1234567890",
+      "enabled": true,
+      "supportedModel": {"claude-sonnet-4-5": true},
+      "modelMapping": {"claude-sonnet-4-5": "claude-sonet-4-5"}
+    },
+    {
+      "id": 2,
+      "name": "bedrock",
+      "apiUrl": "https://bedrock-runtime.us-east-1.amazonaws.com",
+      "apiFormat": "bedrock",
+      "enabled": true
+    }
+  ]
+}`)
+	codexPath := writeConfigFile(t, "codex.json", `{"providers": [
+  {"name": "broken",}
+]}`)
+	relayPath := writeConfigFile(t, "relay.json", `{
+  "timeouts": {"connectSeconds": -1}
+}`)
+	pricing, err := modelpricing.NewServiceFromData([]byte(`{"claude-sonnet-4-5": {"input_cost_per_token": 0.000003, "output_cost_per_token": 0.000015}}`))
+	if err != nil {
+		t.Fatalf("加载价格数据失败: %v", err)
+	}
+
+	report := ValidateConfig(ConfigValidateOptions{Pricing: pricing})
+	if report.OK() || len(report.Files) != 3 {
+		t.Fatalf("应检查全部配置文件并报告错误: %+v", report)
+	}
+	expect := []struct {
+		text     string
+		file     string
+		line     int
+		column   int
+		severity string
+	}{
+		{`未知字段 "supportedModel"，是否想写 "supportedModels"`, claudePath, 9, 7, ConfigIssueError},
+		{`价格数据中没有模型 "claude-sonet-4-5"，是否想写 "claude-sonnet-4-5"`, claudePath, 10, 24, ConfigIssueWarning},
+		{"[claude/bedrock] 未配置 apiKey（格式为 ACCESS_KEY_ID:SECRET_ACCESS_KEY）", claudePath, 12, 5, ConfigIssueError},
+		{"JSON 语法错误", codexPath, 2, 21, ConfigIssueError},
+		{"timeouts:", relayPath, 2, 3, ConfigIssueError},
+	}
+	for _, want := range expect {
+		issue, ok := findIssue(report, want.text)
+		if !ok {
+			t.Fatalf("缺少问题 %q: %+v", want.text, report.Issues)
+		}
+		if issue.File != want.file || issue.Line != want.line || issue.Column != want.column || issue.Severity != want.severity {
+			t.Fatalf("问题 %q 的位置不正确: %s", want.text, issue)
+		}
+	}
+	if _, ok := findIssue(report, "[claude/primary] 未配置"); ok {
+		t.Fatalf("配置完整的 provider 不应报告缺少必填项: %+v", report.Issues)
+	}
+}
+
+func TestValidateConfigChecksConnectivity(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer upstream.Close()
+	writeConfigFile(t, "claude-code.json", `{"providers": [
+  {"name": "reachable", "apiUrl": "`+upstream.URL+`", "apiKey": "sk-reachable-1234567890", "enabled": true},
+  {"name": "unreachable", "apiUrl": "http://127.0.0.1:1", "apiKey": "sk-unreachable-1234567890", "enabled": true}
+]}`)
+
+	if report := ValidateConfig(ConfigValidateOptions{}); !report.OK() {
+		t.Fatalf("未开启连通性检查时应通过: %+v", report.Issues)
+	}
+	report := ValidateConfig(ConfigValidateOptions{Connectivity: true})
+	if report.Errors != 1 || !strings.Contains(report.Issues[0].Message, "[unreachable] 无法连接") || report.Issues[0].Line != 3 {
+		t.Fatalf("应只报告无法连接的 provider: %+v", report.Issues)
+	}
+	if authorization != "" {
+		t.Fatalf("连通性检查不应携带 API Key: %q", authorization)
+	}
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	continueConfigDir        = ".continue"
+	continueConfigFileName   = "config.yaml"
+	continueLegacyConfigName = "config.json"
+	continueBackupFileName   = "cc-studio.back.config.yaml"
+	// 生成的模型以该前缀命名，Apply 与 Unapply 只修改这些模型
+	continueModelPrefix = "code-switch/"
+)
+
+// continueModel 是 Continue config.yaml 中的一个模型
+type continueModel struct {
+	Name     string   `yaml:"name"`
+	Provider string   `yaml:"provider"`
+	Model    string   `yaml:"model"`
+	APIBase  string   `yaml:"apiBase"`
+	APIKey   string   `yaml:"apiKey"`
+	Roles    []string `yaml:"roles"`
+}
+
+// continueIntegration 在 Continue.dev 的 ~/.continue/config.yaml 中为 provider 支持的每个模型添加 code-switch/<model>：
+// claude provider 的模型使用 anthropic 协议，codex provider 的模型使用 openai 协议，其余模型与注释保持不变
+type continueIntegration struct{}
+
+func (continueIntegration) Name() string        { return "continue" }
+func (continueIntegration) DisplayName() string { return "Continue" }
+
+func (continueIntegration) paths() (configPath string, backupPath string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(home, continueConfigDir)
+	return filepath.Join(dir, continueConfigFileName), filepath.Join(dir, continueBackupFileName), nil
+}
+
+func (ci continueIntegration) Apply(opts ClientApplyOptions) (ClientApplyResult, error) {
+	configPath, backupPath, err := ci.paths()
+	result := ClientApplyResult{SettingsPath: configPath}
+	if err != nil {
+		return result, err
+	}
+	baseURL, err := relayBaseURL(opts.BaseURL, NewClaudeSettingsService("").baseURL())
+	if err != nil {
+		return result, err
+	}
+	doc, original, err := readContinueConfig(configPath)
+	if err != nil {
+		return result, err
+	}
+	if original == nil {
+		// Continue 在没有 config.yaml 时使用旧版 config.json，新建 config.yaml 会使其失效
+		if _, err := os.Stat(filepath.Join(filepath.Dir(configPath), continueLegacyConfigName)); err == nil {
+			return result, fmt.Errorf("%s 使用旧版的 config.json，请先在 Continue 中迁移到 config.yaml", filepath.Dir(configPath))
+		}
+	}
+	models, err := continueModels(opts.Model, baseURL)
+	if err != nil {
+		return result, err
+	}
+	sequence, err := continueModelsNode(doc)
+	if err != nil {
+		return result, err
+	}
+	removeContinueModels(sequence)
+	for _, model := range models {
+		var node yaml.Node
+		if err := node.Encode(model); err != nil {
+			return result, err
+		}
+		sequence.Content = append(sequence.Content, &node)
+	}
+
+	payload, err := marshalContinueConfig(doc)
+	if err != nil {
+		return result, err
+	}
+	result.Settings = string(payload)
+	result.Changed = !bytes.Equal(payload, original)
+	result.Note = fmt.Sprintf("在 Continue 的模型列表中选择 %s<model> 即可通过 relay 请求", continueModelPrefix)
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		return result, err
+	}
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		// 原来不存在 config.yaml 时备份为空文件，Unapply 时据此删除生成的文件
+		if err := os.WriteFile(backupPath, original, 0o600); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	return result, os.WriteFile(configPath, payload, 0o600)
+}
+
+// Unapply 移除 Apply 添加的 code-switch/ 模型并删除备份；config.yaml 由 Apply 创建且没有其他内容时删除该文件
+func (ci continueIntegration) Unapply() (ClientApplyResult, error) {
+	configPath, backupPath, err := ci.paths()
+	result := ClientApplyResult{SettingsPath: configPath}
+	if err != nil {
+		return result, err
+	}
+	doc, original, err := readContinueConfig(configPath)
+	if err != nil {
+		return result, err
+	}
+	backup, backupErr := os.ReadFile(backupPath)
+	if backupErr != nil && !errors.Is(backupErr, os.ErrNotExist) {
+		return result, fmt.Errorf("读取备份失败: %w", backupErr)
+	}
+	if original != nil {
+		sequence, err := continueModelsNode(doc)
+		if err != nil {
+			return result, err
+		}
+		removeContinueModels(sequence)
+		if backupErr == nil && len(bytes.TrimSpace(backup)) == 0 && len(sequence.Content) == 0 && onlyContinueScaffold(doc) {
+			if err := os.Remove(configPath); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		} else {
+			payload, err := marshalContinueConfig(doc)
+			if err != nil {
+				return result, err
+			}
+			result.Settings = string(payload)
+			if !bytes.Equal(payload, original) {
+				if err := os.WriteFile(configPath, payload, 0o600); err != nil {
+					return result, err
+				}
+				result.Changed = true
+			}
+		}
+	}
+	if backupErr == nil {
+		if err := os.Remove(backupPath); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	return result, nil
+}
+
+// continueModels 返回生成的模型，model 不为空时放在第一个（Continue 默认使用列表中的第一个模型）
+func continueModels(model string, baseURL string) ([]continueModel, error) {
+	ps := NewProviderService()
+	claudeProviders, err := ps.LoadProviders("claude")
+	if err != nil {
+		return nil, fmt.Errorf("读取 claude provider 失败: %w", err)
+	}
+	codexProviders, err := ps.LoadProviders("codex")
+	if err != nil {
+		return nil, fmt.Errorf("读取 codex provider 失败: %w", err)
+	}
+	claudeModels := explicitModels(claudeProviders)
+	codexModels := explicitModels(codexProviders)
+
+	newModel := func(name string, openai bool) continueModel {
+		m := continueModel{
+			Name:     continueModelPrefix + name,
+			Provider: "anthropic",
+			Model:    name,
+			APIBase:  baseURL + "/v1/",
+			APIKey:   claudeAuthTokenValue,
+			Roles:    []string{"chat", "edit", "apply"},
+		}
+		if openai {
+			m.Provider, m.APIBase, m.APIKey = "openai", baseURL+"/", codexTokenValue
+		}
+		return m
+	}
+	var models []continueModel
+	seen := map[string]bool{}
+	if model = strings.TrimSpace(model); model != "" {
+		models = append(models, newModel(model, slices.Contains(codexModels, model) && !slices.Contains(claudeModels, model)))
+		seen[model] = true
+	}
+	for _, name := range claudeModels {
+		if !seen[name] {
+			models = append(models, newModel(name, false))
+			seen[name] = true
+		}
+	}
+	for _, name := range codexModels {
+		if !seen[name] {
+			models = append(models, newModel(name, true))
+			seen[name] = true
+		}
+	}
+	if len(models) == 0 {
+		return nil, errors.New("provider 中没有明确支持的模型（supportedModels 或 modelMapping），请使用 --model 指定")
+	}
+	return models, nil
+}
+
+// readContinueConfig 读取 config.yaml，文件不存在时返回 Continue 要求的基本字段与 nil 的原始内容；内容无法解析时报错，避免覆盖手动编辑的文件
+func readContinueConfig(path string) (*yaml.Node, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+		}
+	}
+	if len(doc.Content) == 0 {
+		var root yaml.Node
+		if err := root.Encode(map[string]any{"name": "Local Config", "version": "1.0.0", "schema": "v1"}); err != nil {
+			return nil, nil, err
+		}
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&root}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("%s 的顶层必须是对象", path)
+	}
+	return &doc, data, nil
+}
+
+// continueModelsNode 返回 models 列表，不存在时添加
+func continueModelsNode(doc *yaml.Node) (*yaml.Node, error) {
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "models" {
+			value := root.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+				*value = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			}
+			if value.Kind != yaml.SequenceNode {
+				return nil, errors.New("config.yaml 中的 models 必须是列表")
+			}
+			return value, nil
+		}
+	}
+	sequence := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "models"}, sequence)
+	return sequence, nil
+}
+
+// removeContinueModels 移除以 code-switch/ 命名的模型
+func removeContinueModels(sequence *yaml.Node) {
+	kept := sequence.Content[:0]
+	for _, item := range sequence.Content {
+		var model struct {
+			Name string `yaml:"name"`
+		}
+		if item.Kind == yaml.MappingNode && item.Decode(&model) == nil && strings.HasPrefix(model.Name, continueModelPrefix) {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	sequence.Content = kept
+}
+
+// onlyContinueScaffold 判断 config.yaml 是否只包含新建时写入的基本字段
+func onlyContinueScaffold(doc *yaml.Node) bool {
+	root := doc.Content[0]
+	for i := 0; i < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "name", "version", "schema", "models":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func marshalContinueConfig(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestContinueIntegrationKeepsUserModels(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	configPath := filepath.Join(home, ".continue", "config.yaml")
+	os.MkdirAll(filepath.Dir(configPath), 0o755)
+	os.WriteFile(configPath, []byte("name: My Config\nversion: 1.0.0\nschema: v1\nmodels:\n  # 本地模型\n  - name: Qwen\n    provider: ollama\n    model: qwen2.5-coder\n"), 0o600)
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "anthropic", APIURL: "https://api.anthropic.com", APIKey: "sk-ant-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-5": true}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	if err := ps.SaveProviders("codex", []Provider{
+		{ID: 1, Name: "openai", APIURL: "https://api.openai.com", APIKey: "sk-openai-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"gpt-5-codex": true}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	integration, err := LookupClientIntegration("continue")
+	if err != nil {
+		t.Fatalf("应注册 continue: %v", err)
+	}
+	if _, err := LookupClientIntegration("zed"); err == nil || !strings.Contains(err.Error(), "claude、cline、codex、continue、gemini") {
+		t.Fatalf("未注册的客户端应列出可用的名称: %v", err)
+	}
+	readModels := func() []continueModel {
+		t.Helper()
+		var cfg struct {
+			Name   string          `yaml:"name"`
+			Models []continueModel `yaml:"models"`
+		}
+		data, _ := os.ReadFile(configPath)
+		if err := yaml.Unmarshal(data, &cfg); err != nil || cfg.Name != "My Config" {
+			t.Fatalf("解析 config.yaml 失败: %v %s", err, data)
+		}
+		return cfg.Models
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := integration.Apply(ClientApplyOptions{Model: "gpt-5-codex"})
+		if err != nil || (i == 0) != (result.BackupPath != "") {
+			t.Fatalf("apply 失败: %+v %v", result, err)
+		}
+	}
+	models := readModels()
+	if len(models) != 3 || models[0].Name != "Qwen" {
+		t.Fatalf("重复 apply 应只保留一份生成的模型: %+v", models)
+	}
+	// --model 指定的模型排在生成的模型的第一个
+	if m := models[1]; m.Name != "code-switch/gpt-5-codex" || m.Provider != "openai" || m.APIBase != "http://127.0.0.1:18100/" {
+		t.Fatalf("openai 模型错误: %+v", m)
+	}
+	if m := models[2]; m.Name != "code-switch/claude-sonnet-4-5" || m.Provider != "anthropic" || m.APIBase != "http://127.0.0.1:18100/v1/" {
+		t.Fatalf("anthropic 模型错误: %+v", m)
+	}
+	if data, _ := os.ReadFile(configPath); !strings.Contains(string(data), "# 本地模型") {
+		t.Fatalf("应保留注释: %s", data)
+	}
+
+	if result, err := integration.Unapply(); err != nil || result.BackupPath == "" {
+		t.Fatalf("unapply 失败: %+v %v", result, err)
+	}
+	if models := readModels(); len(models) != 1 || models[0].Name != "Qwen" {
+		t.Fatalf("unapply 应只移除生成的模型: %+v", models)
+	}
+
+	// apply 之前不存在 config.yaml 时，unapply 删除该文件
+	os.Remove(configPath)
+	if _, err := integration.Apply(ClientApplyOptions{}); err != nil {
+		t.Fatalf("apply 失败: %v", err)
+	}
+	if _, err := integration.Unapply(); err != nil {
+		t.Fatalf("unapply 失败: %v", err)
+	}
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Fatalf("应删除 apply 创建的 config.yaml")
+	}
+
+	// 仍在使用旧版 config.json 时不创建 config.yaml
+	os.WriteFile(filepath.Join(home, ".continue", "config.json"), []byte(`{"models": []}`), 0o600)
+	if _, err := integration.Apply(ClientApplyOptions{}); err == nil {
+		t.Fatalf("使用 config.json 时应报错")
+	}
+}
@@ -0,0 +1,418 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// ExportFormatClaudeCode 导出为 Claude Code 会话文件（~/.claude/projects/<项目>/<sessionId>.jsonl）
+	ExportFormatClaudeCode = "claude-code"
+	// ExportFormatOpenAI 导出为 ChatGPT 数据导出中的 conversations.json 格式
+	ExportFormatOpenAI = "openai"
+
+	auditActionExport = "export"
+)
+
+// ConversationExportOptions 控制会话导出
+type ConversationExportOptions struct {
+	Format string `json:"format"`
+	// Cwd 写入 Claude Code 会话条目的工作目录，留空时使用当前目录
+	Cwd string `json:"cwd"`
+	// Reason 记录在审计中（导出加密内容时需要解密）
+	Reason string `json:"reason"`
+}
+
+// conversationMessage 是平台无关的单条消息
+type conversationMessage struct {
+	Role string
+	// Content 为 Claude 格式的 content（字符串或 content block 数组）
+	Content json.RawMessage
+	Text    string
+	Model   string
+	Time    time.Time
+}
+
+// ExportConversation 将保存的会话转换为 Claude Code 或 OpenAI 格式，便于在原生工具中继续对话
+// 会话的完整历史取自最近一次可解析的请求，再追加该次请求的响应
+func (ts *TranscriptService) ExportConversation(sessionID string, opts ConversationExportOptions) ([]byte, error) {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return nil, errors.New("session id 不能为空")
+	}
+	format := strings.ToLower(strings.TrimSpace(opts.Format))
+	if format == "" {
+		format = ExportFormatClaudeCode
+	}
+	if format != ExportFormatClaudeCode && format != ExportFormatOpenAI {
+		return nil, fmt.Errorf("不支持的导出格式: %s（可选 %s、%s）", opts.Format, ExportFormatClaudeCode, ExportFormatOpenAI)
+	}
+
+	records, err := xdb.New("request_transcript").Selects(
+		xdb.WhereEq("session_id", sessionID),
+		xdb.WhereEq("deleted_at", ""),
+		xdb.WhereEq("purged_at", ""),
+		xdb.OrderByDesc("id"),
+	)
+	if err != nil && !errors.Is(err, xdb.ErrNotFound) {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("会话 %s 没有保存的内容", sessionID)
+	}
+
+	var messages []conversationMessage
+	var source Transcript
+	for _, record := range records {
+		transcript := decodeTranscriptRecord(record)
+		if transcript.Encrypted {
+			if transcript, err = ts.decryptTranscript(transcript); err != nil {
+				return nil, err
+			}
+		}
+		if messages, err = conversationFromTranscript(transcript); err == nil {
+			source = transcript
+			break
+		}
+	}
+	if messages == nil {
+		return nil, fmt.Errorf("会话 %s 的内容均已截断或无法解析，无法导出", sessionID)
+	}
+	if source.Encrypted {
+		if _, err := recordAudit(auditActionExport, map[string]any{"session_id": sessionID, "tenant": source.Tenant}, opts.Reason, 1); err != nil {
+			return nil, fmt.Errorf("写入导出审计失败: %w", err)
+		}
+	}
+
+	if format == ExportFormatOpenAI {
+		return renderOpenAIConversation(sessionID, messages)
+	}
+	cwd := opts.Cwd
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	return renderClaudeCodeSession(sessionID, cwd, messages)
+}
+
+func (ts *TranscriptService) decryptTranscript(transcript Transcript) (Transcript, error) {
+	var err error
+	if transcript.RequestBody, err = ts.keyring.decryptField(transcript.Tenant, transcript.RequestBody); err != nil {
+		return transcript, err
+	}
+	if transcript.ResponseBody, err = ts.keyring.decryptField(transcript.Tenant, transcript.ResponseBody); err != nil {
+		return transcript, err
+	}
+	// 压缩发生在加密之前，解密后再解压
+	return decompressTranscript(transcript)
+}
+
+// conversationFromTranscript 从单条内容中还原完整对话；内容被截断时返回错误
+func conversationFromTranscript(transcript Transcript) ([]conversationMessage, error) {
+	if transcript.Truncated || !gjson.Valid(transcript.RequestBody) {
+		return nil, errors.New("内容已截断")
+	}
+	createdAt, _ := time.ParseInLocation(timeLayout, transcript.CreatedAt, time.UTC)
+	var messages []conversationMessage
+	if transcript.Platform == "codex" {
+		messages = codexRequestMessages(transcript.RequestBody)
+	} else {
+		messages = claudeRequestMessages(transcript.RequestBody)
+	}
+	if reply, ok := responseMessage(transcript.Platform, transcript.ResponseBody); ok {
+		reply.Model = transcript.Model
+		messages = append(messages, reply)
+	}
+	if len(messages) == 0 {
+		return nil, errors.New("没有可导出的消息")
+	}
+	for i := range messages {
+		// 历史消息没有各自的时间，按顺序以毫秒递增，保持导入后的先后顺序
+		messages[i].Time = createdAt.Add(time.Duration(i-len(messages)+1) * time.Millisecond)
+	}
+	return messages, nil
+}
+
+func claudeRequestMessages(body string) []conversationMessage {
+	var messages []conversationMessage
+	gjson.Get(body, "messages").ForEach(func(_, message gjson.Result) bool {
+		content := message.Get("content")
+		messages = append(messages, conversationMessage{
+			Role:    message.Get("role").String(),
+			Content: json.RawMessage(content.Raw),
+			Text:    claudeContentText(content),
+		})
+		return true
+	})
+	return messages
+}
+
+func codexRequestMessages(body string) []conversationMessage {
+	var messages []conversationMessage
+	gjson.Get(body, "input").ForEach(func(_, item gjson.Result) bool {
+		role := item.Get("role").String()
+		if item.Get("type").String() != "message" && role == "" {
+			return true
+		}
+		// developer/system 消息是工具注入的指令，不属于对话内容
+		if role != "user" && role != "assistant" {
+			return true
+		}
+		text := codexContentText(item.Get("content"))
+		messages = append(messages, conversationMessage{Role: role, Text: text, Content: textContent(text)})
+		return true
+	})
+	return messages
+}
+
+// responseMessage 解析响应内容（JSON 或 SSE）中的助手回复
+func responseMessage(platform string, body string) (conversationMessage, bool) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return conversationMessage{}, false
+	}
+	if platform == "codex" {
+		text := ""
+		if gjson.Valid(body) {
+			text = codexOutputText(gjson.Get(body, "output"))
+		} else {
+			text = codexStreamText(body)
+		}
+		if text == "" {
+			return conversationMessage{}, false
+		}
+		return conversationMessage{Role: "assistant", Text: text, Content: textContent(text)}, true
+	}
+
+	var content json.RawMessage
+	if gjson.Valid(body) {
+		content = json.RawMessage(gjson.Get(body, "content").Raw)
+	} else {
+		content = claudeStreamContent(body)
+	}
+	if len(content) == 0 || string(content) == "[]" {
+		return conversationMessage{}, false
+	}
+	return conversationMessage{Role: "assistant", Content: content, Text: claudeContentText(gjson.ParseBytes(content))}, true
+}
+
+// claudeStreamContent 将 SSE 事件重新拼装为 content block 数组
+func claudeStreamContent(body string) json.RawMessage {
+	type block struct {
+		value   map[string]any
+		text    strings.Builder
+		partial strings.Builder
+	}
+	var blocks []*block
+	eachSSEData(body, func(data gjson.Result) {
+		index := int(data.Get("index").Int())
+		switch data.Get("type").String() {
+		case "content_block_start":
+			value, _ := data.Get("content_block").Value().(map[string]any)
+			if value == nil {
+				return
+			}
+			for len(blocks) <= index {
+				blocks = append(blocks, nil)
+			}
+			blocks[index] = &block{value: value}
+		case "content_block_delta":
+			if index >= len(blocks) || blocks[index] == nil {
+				return
+			}
+			delta := data.Get("delta")
+			blocks[index].text.WriteString(delta.Get("text").String())
+			blocks[index].text.WriteString(delta.Get("thinking").String())
+			blocks[index].partial.WriteString(delta.Get("partial_json").String())
+		}
+	})
+	content := make([]map[string]any, 0, len(blocks))
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		switch b.value["type"] {
+		case "text":
+			b.value["text"] = b.text.String()
+		case "thinking":
+			b.value["thinking"] = b.text.String()
+		case "tool_use":
+			var input any = map[string]any{}
+			if partial := b.partial.String(); partial != "" {
+				_ = json.Unmarshal([]byte(partial), &input)
+			}
+			b.value["input"] = input
+		}
+		content = append(content, b.value)
+	}
+	raw, _ := json.Marshal(content)
+	return raw
+}
+
+func codexStreamText(body string) string {
+	var completed, deltas strings.Builder
+	eachSSEData(body, func(data gjson.Result) {
+		switch data.Get("type").String() {
+		case "response.completed":
+			completed.WriteString(codexOutputText(data.Get("response.output")))
+		case "response.output_text.delta":
+			deltas.WriteString(data.Get("delta").String())
+		}
+	})
+	if completed.Len() > 0 {
+		return completed.String()
+	}
+	return deltas.String()
+}
+
+func codexOutputText(output gjson.Result) string {
+	var parts []string
+	output.ForEach(func(_, item gjson.Result) bool {
+		if item.Get("type").String() == "message" {
+			if text := codexContentText(item.Get("content")); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return true
+	})
+	return strings.Join(parts, "\n\n")
+}
+
+func codexContentText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+	var parts []string
+	content.ForEach(func(_, part gjson.Result) bool {
+		if text := part.Get("text").String(); text != "" {
+			parts = append(parts, text)
+		}
+		return true
+	})
+	return strings.Join(parts, "\n")
+}
+
+func claudeContentText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+	var parts []string
+	content.ForEach(func(_, block gjson.Result) bool {
+		if block.Get("type").String() == "text" {
+			parts = append(parts, block.Get("text").String())
+		}
+		return true
+	})
+	return strings.Join(parts, "\n")
+}
+
+func textContent(text string) json.RawMessage {
+	raw, _ := json.Marshal([]map[string]string{{"type": "text", "text": text}})
+	return raw
+}
+
+func eachSSEData(body string, fn func(data gjson.Result)) {
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if gjson.Valid(payload) {
+			fn(gjson.Parse(payload))
+		}
+	}
+}
+
+// renderClaudeCodeSession 生成 Claude Code 会话 JSONL，每行一条消息，通过 parentUuid 串联
+func renderClaudeCodeSession(sessionID string, cwd string, messages []conversationMessage) ([]byte, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		// Claude Code 只识别 UUID 形式的会话 ID
+		sessionID = uuid.NewString()
+	}
+	var buf bytes.Buffer
+	var parent any
+	for _, message := range messages {
+		id := uuid.NewString()
+		entryMessage := map[string]any{"role": message.Role, "content": message.Content}
+		if message.Role == "assistant" {
+			entryMessage["type"] = "message"
+			entryMessage["model"] = message.Model
+		}
+		line, err := json.Marshal(map[string]any{
+			"parentUuid":  parent,
+			"isSidechain": false,
+			"userType":    "external",
+			"cwd":         cwd,
+			"sessionId":   sessionID,
+			"type":        message.Role,
+			"message":     entryMessage,
+			"uuid":        id,
+			"timestamp":   message.Time.UTC().Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		parent = id
+	}
+	return buf.Bytes(), nil
+}
+
+// renderOpenAIConversation 生成 ChatGPT 数据导出格式（conversations.json）的单个会话
+func renderOpenAIConversation(sessionID string, messages []conversationMessage) ([]byte, error) {
+	mapping := map[string]any{}
+	rootID := uuid.NewString()
+	mapping[rootID] = map[string]any{"id": rootID, "message": nil, "parent": nil, "children": []string{}}
+	parent := rootID
+	title := ""
+	for _, message := range messages {
+		if title == "" && message.Role == "user" {
+			title = truncateString(strings.TrimSpace(message.Text), 60)
+		}
+		id := uuid.NewString()
+		node := mapping[parent].(map[string]any)
+		node["children"] = append(node["children"].([]string), id)
+		metadata := map[string]any{}
+		if message.Model != "" {
+			metadata["model_slug"] = message.Model
+		}
+		mapping[id] = map[string]any{
+			"id": id,
+			"message": map[string]any{
+				"id":          id,
+				"author":      map[string]any{"role": message.Role},
+				"create_time": float64(message.Time.UnixMilli()) / 1000,
+				"content":     map[string]any{"content_type": "text", "parts": []string{message.Text}},
+				"metadata":    metadata,
+			},
+			"parent":   parent,
+			"children": []string{},
+		}
+		parent = id
+	}
+	if title == "" {
+		title = sessionID
+	}
+	first, last := messages[0].Time, messages[len(messages)-1].Time
+	return json.MarshalIndent([]map[string]any{{
+		"title":           title,
+		"create_time":     float64(first.UnixMilli()) / 1000,
+		"update_time":     float64(last.UnixMilli()) / 1000,
+		"mapping":         mapping,
+		"current_node":    parent,
+		"conversation_id": sessionID,
+	}}, "", "  ")
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestExportConversation(t *testing.T) {
+	initTestDatabase(t)
+
+	request := []byte(`{"model":"claude-sonnet","messages":[{"role":"user","content":"你好"},{"role":"assistant","content":[{"type":"text","text":"你好！"}]},{"role":"user","content":"写一首诗"}]}`)
+	capture := newTranscriptCapture(TranscriptConfig{})
+	capture.write([]byte("event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"床前明月光\"}}\n\n"))
+	entry := &ReqeustLog{Platform: "claude", Provider: "p1", Model: "claude-sonnet"}
+	if err := saveTranscript(1, entry, "s1", request, capture); err != nil {
+		t.Fatalf("保存内容失败: %v", err)
+	}
+
+	ts := NewTranscriptService()
+	data, err := ts.ExportConversation("s1", ConversationExportOptions{Format: ExportFormatClaudeCode, Cwd: "/work"})
+	if err != nil {
+		t.Fatalf("导出 Claude Code 会话失败: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("应导出 4 条消息，实际 %d", len(lines))
+	}
+	last := gjson.Parse(lines[3])
+	if last.Get("type").String() != "assistant" || last.Get("message.content.0.text").String() != "床前明月光" ||
+		last.Get("parentUuid").String() != gjson.Get(lines[2], "uuid").String() {
+		t.Errorf("助手回复不正确: %s", lines[3])
+	}
+
+	data, err = ts.ExportConversation("s1", ConversationExportOptions{Format: ExportFormatOpenAI})
+	if err != nil {
+		t.Fatalf("导出 OpenAI 会话失败: %v", err)
+	}
+	var conversations []map[string]any
+	if err := json.Unmarshal(data, &conversations); err != nil || len(conversations) != 1 {
+		t.Fatalf("OpenAI 导出格式无效: %v", err)
+	}
+	if gjson.GetBytes(data, "0.title").String() != "你好" || len(gjson.GetBytes(data, "0.mapping").Map()) != 5 {
+		t.Errorf("OpenAI 导出内容不正确: %s", data)
+	}
+
+	if _, err := ts.ExportConversation("missing", ConversationExportOptions{}); err == nil {
+		t.Errorf("不存在的会话应返回错误")
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+// newRouteTarget 返回请求的路由依据，cost 策略需要估算提示词与输出的 token 数
+func newRouteTarget(kind string, model string, body []byte, routing RoutingConfig) routeTarget {
+	target := routeTarget{kind: kind, model: model}
+	if routing.Strategy != RoutingStrategyCost {
+		return target
+	}
+	target.promptTokens, _ = countPromptTokens(model, promptText(kind, body))
+	target.promptTokens += countInputImages(kind, body) * estimatedTokensPerImage
+	target.maxOutputTokens = requestedOutputTokens(kind, body)
+	target.expectedOutputTokens = target.maxOutputTokens
+	if target.expectedOutputTokens <= 0 {
+		target.expectedOutputTokens = defaultEstimatedOutputTokens
+	}
+	return target
+}
+
+// routeCost 是 cost 策略下一个 provider/模型组合的估算费用
+type routeCost struct {
+	model      string
+	cost       float64
+	hasPricing bool
+	// 模型的上下文窗口放不下本次请求
+	tooLarge bool
+}
+
+// estimateRouteCost 按 provider 实际请求的模型与计价调整估算本次请求的费用，并检查上下文窗口
+func estimateRouteCost(pricing *modelpricing.Service, target routeTarget, provider Provider) routeCost {
+	model := provider.GetEffectiveModel(provider.routeModel(target.model))
+	result := routeCost{model: model}
+	if pricing == nil || model == "" {
+		return result
+	}
+	if maxInput, maxOutput, ok := pricing.ContextWindow(provider.pricingModel(model)); ok {
+		result.tooLarge = (maxInput > 0 && target.promptTokens > maxInput) ||
+			(maxOutput > 0 && target.maxOutputTokens > maxOutput)
+	}
+	opts := append(provider.CostOptions(), modelpricing.WithRequestTime(time.Now()))
+	cost := pricing.EstimateCost(model, target.promptTokens, target.expectedOutputTokens, opts...)
+	result.cost, result.hasPricing = cost.TotalCost, cost.HasPricing
+	return result
+}
+
+// rankByCost 将同一优先级的 provider 按估算费用从低到高排列：
+// 没有价格数据的排在有价格的之后，上下文窗口放不下请求的排在最后
+func rankByCost(target routeTarget, group []Provider) {
+	if len(group) < 2 {
+		return
+	}
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil {
+		return
+	}
+	costs := make([]routeCost, len(group))
+	for i, provider := range group {
+		costs[i] = estimateRouteCost(pricing, target, provider)
+	}
+	rank := func(cost routeCost) int {
+		switch {
+		case cost.tooLarge:
+			return 2
+		case !cost.hasPricing:
+			return 1
+		}
+		return 0
+	}
+	order := make([]int, len(group))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := costs[order[i]], costs[order[j]]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		return a.hasPricing && a.cost < b.cost
+	})
+
+	ranked := make([]Provider, len(group))
+	summary := make([]string, 0, len(group))
+	for i, index := range order {
+		ranked[i] = group[index]
+		cost := costs[index]
+		switch {
+		case cost.tooLarge:
+			summary = append(summary, fmt.Sprintf("%s(%s) 上下文窗口不足", group[index].Name, cost.model))
+		case !cost.hasPricing:
+			summary = append(summary, fmt.Sprintf("%s(%s) 无价格", group[index].Name, cost.model))
+		default:
+			summary = append(summary, fmt.Sprintf("%s(%s) $%.6f", group[index].Name, cost.model, cost.cost))
+		}
+	}
+	copy(group, ranked)
+	fmt.Printf("[INFO]   按估算费用排列: %s\n", strings.Join(summary, ", "))
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestRankByCost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	routing := RoutingConfig{
+		Strategy:     RoutingStrategyCost,
+		ModelClasses: map[string][]string{"sonnet-tier": {"claude-sonnet-4-5", "deepseek-chat"}},
+	}
+	sonnetOnly := map[string]bool{"claude-sonnet-4-5": true}
+	providers := routing.expandModelClass([]Provider{
+		{Name: "official", SupportedModels: sonnetOnly},
+		{Name: "reseller", SupportedModels: sonnetOnly, PriceMultiplier: 0.5},
+		{Name: "deepseek", SupportedModels: map[string]bool{"deepseek-chat": true}},
+	}, "sonnet-tier")
+	active := make([]Provider, 0, len(providers))
+	for _, provider := range providers {
+		if provider.IsModelSupported(provider.routeModel("sonnet-tier")) {
+			active = append(active, provider)
+		}
+	}
+	if len(active) != 3 {
+		t.Fatalf("模型档位应展开为每个 provider 支持的模型: %d", len(active))
+	}
+
+	small := newRouteTarget("claude", "sonnet-tier", []byte(`{"messages":[{"role":"user","content":"hi"}],"max_tokens":1000}`), routing)
+	group := append([]Provider(nil), active...)
+	rankByCost(small, group)
+	if got := providerNames(group); got != "deepseek,reseller,official" {
+		t.Fatalf("应按估算费用从低到高排列，实际 %s", got)
+	}
+
+	// deepseek-chat 最多输出 8192 tokens，放不下时排到最后
+	large := newRouteTarget("claude", "sonnet-tier", []byte(`{"messages":[{"role":"user","content":"hi"}],"max_tokens":20000}`), routing)
+	group = append([]Provider(nil), active...)
+	rankByCost(large, group)
+	if got := providerNames(group); got != "reseller,official,deepseek" {
+		t.Fatalf("上下文窗口不足的组合应排在最后，实际 %s", got)
+	}
+
+	unpriced := []Provider{{Name: "custom", ModelMapping: map[string]string{"claude-sonnet-4-5": "acme-coder-v1"}}, {Name: "official"}}
+	rankByCost(routeTarget{kind: "claude", model: "claude-sonnet-4-5"}, unpriced)
+	if got := providerNames(unpriced); got != "official,custom" {
+		t.Fatalf("没有价格数据的组合应排在有价格的之后，实际 %s", got)
+	}
+}
+
+func TestRelayCostRoutingWithModelClass(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwardedKey, forwardedModel string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwardedKey, forwardedModel = r.Header.Get("Authorization"), gjson.GetBytes(body, "model").String()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"type":"message","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "official", APIURL: upstream.URL, APIKey: "sk-official-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-5": true}},
+		{ID: 2, Name: "deepseek", APIURL: upstream.URL, APIKey: "sk-deepseek-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"deepseek-chat": true}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relayConfig := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Routing = RoutingConfig{
+		Strategy:     RoutingStrategyCost,
+		ModelClasses: map[string][]string{"sonnet-tier": {"claude-sonnet-4-5", "deepseek-chat"}},
+	}
+	if _, err := relayConfig.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, relayConfig, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"sonnet-tier","max_tokens":1000,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedKey != "Bearer sk-deepseek-1234567890" || forwardedModel != "deepseek-chat" {
+		t.Fatalf("应使用最便宜的组合 deepseek(deepseek-chat)，实际 %s %s", forwardedKey, forwardedModel)
+	}
+}
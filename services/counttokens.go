@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// Anthropic 的 token 计数接口，Claude Code 在压缩上下文等场景会频繁调用
+	countTokensEndpoint = "/v1/messages/count_tokens"
+	// 本地估算 token 时在响应头中标记，便于区分上游计数
+	countTokensSourceHeader = "X-Code-Switch-Token-Count"
+)
+
+var countTokensClient = &http.Client{Timeout: 30 * time.Second}
+
+// supportsCountTokens 返回 provider 是否提供 Anthropic 的 count_tokens 接口：
+// 协议转换的 provider（OpenAI 兼容、Gemini 等）与本地推理服务没有，改为在本地估算
+func (p *Provider) supportsCountTokens() bool {
+	return p.dialect("claude", "/v1/messages") == nil && p.Local == nil
+}
+
+// countTokensHandler 将 count_tokens 请求转发给第一个支持该接口的可用 provider，
+// 没有支持的 provider 或上游失败时使用分词器本地估算，切换到 OpenAI 兼容的 provider 后客户端仍可正常工作。
+// 计数请求不计费，也不写入请求日志
+func (prs *ProviderRelayService) countTokensHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil || !gjson.ValidBytes(body) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	requestedModel := gjson.GetBytes(body, "model").String()
+	_, listener, ok := prs.listenerConfig(c)
+	if !ok {
+		return
+	}
+	providers, err := prs.listenerProviders("claude", listener)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load providers"})
+		return
+	}
+
+	if provider, apiKey, ok := prs.countTokensProvider(providers, requestedModel); ok {
+		model := provider.GetEffectiveModel(provider.routeModel(requestedModel))
+		cache := prs.loadRelayConfig().Cache
+		cacheKey := ""
+		if cache.cacheable(false, c.Request.Header) {
+			cacheKey = responseCacheKey("claude", provider.Name, model, countTokensEndpoint, body)
+			if entry, ok := prs.responses.get(cacheKey); ok {
+				entry.serve(c)
+				return
+			}
+		}
+		status, data, err := forwardCountTokens(c, provider, apiKey, body, model)
+		switch {
+		case err != nil:
+			fmt.Printf("[WARN] Provider %s 统计 token 失败，改为本地估算: %v\n", provider.Name, err)
+		case status == http.StatusOK || status == http.StatusBadRequest:
+			if status == http.StatusOK && cacheKey != "" {
+				prs.responses.put(cache, &cachedResponse{key: cacheKey, status: status, contentType: "application/json", body: data})
+			}
+			// 请求本身无效时原样返回上游的错误
+			c.Data(status, "application/json", data)
+			return
+		default:
+			fmt.Printf("[WARN] Provider %s 统计 token 失败（HTTP %d），改为本地估算\n", provider.Name, status)
+		}
+	}
+
+	inputTokens, tokenizerName := estimateInputTokens("claude", requestedModel, body)
+	c.Header(countTokensSourceHeader, "estimated; tokenizer="+tokenizerName)
+	c.JSON(http.StatusOK, gin.H{"input_tokens": inputTokens})
+}
+
+// countTokensProvider 按路由顺序返回第一个可用且支持 count_tokens 的 provider 及其可用的 Key
+func (prs *ProviderRelayService) countTokensProvider(providers []Provider, requestedModel string) (Provider, string, bool) {
+	for _, provider := range prs.loadRelayConfig().Routing.expandModelClass(providers, requestedModel) {
+		if routeSkipReason(provider, provider.routeModel(requestedModel)) != "" || !provider.supportsCountTokens() {
+			continue
+		}
+		if keys := prs.keyPool.candidates("claude", provider); len(keys) > 0 {
+			return provider, keys[0], true
+		}
+	}
+	return Provider{}, "", false
+}
+
+// forwardCountTokens 向 provider 的 count_tokens 接口发送请求，model 为映射后的模型名
+func forwardCountTokens(c *gin.Context, provider Provider, apiKey string, body []byte, model string) (int, []byte, error) {
+	if requestedModel := gjson.GetBytes(body, "model").String(); requestedModel != "" && model != requestedModel {
+		var err error
+		if body, err = ReplaceModelInRequestBody(body, model); err != nil {
+			return 0, nil, err
+		}
+	}
+	auth, err := provider.AuthStrategy()
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost,
+		joinURL(provider.upstreamBaseURL(), countTokensEndpoint), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("无效的 apiUrl: %w", err)
+	}
+	query := req.URL.Query()
+	for key, value := range provider.upstreamQuery(flattenQuery(c.Request.URL.Query())) {
+		query.Set(key, value)
+	}
+	req.URL.RawQuery = query.Encode()
+	for key, value := range cloneHeaders(c.Request.Header) {
+		switch http.CanonicalHeaderKey(key) {
+		// 由 Go 客户端处理压缩与长度
+		case "Accept-Encoding", "Content-Length", "Host":
+		default:
+			req.Header.Set(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if provider.OpenRouter != nil {
+		headers := map[string]string{}
+		provider.OpenRouter.setHeaders(headers)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+	}
+	if err := auth.Apply(req, apiKey); err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := countTokensClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode == http.StatusOK && !gjson.GetBytes(data, "input_tokens").Exists() {
+		return 0, nil, fmt.Errorf("响应中没有 input_tokens: %s", truncateString(string(data), 256))
+	}
+	return resp.StatusCode, data, nil
+}
+
+// estimateInputTokens 按费用估算的规则统计请求的输入 token（提示词文本与图片）
+func estimateInputTokens(kind string, model string, body []byte) (int, string) {
+	tokens, tokenizerName := countPromptTokens(model, promptText(kind, body))
+	return tokens + countInputImages(kind, body)*estimatedTokensPerImage, tokenizerName
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestCountTokensPassthroughAndEstimate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var hits []string
+	var model, auth, beta string
+	supported := true
+	anthropic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, "anthropic "+r.URL.Path)
+		if !supported {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		model, auth, beta = gjson.GetBytes(body, "model").String(), r.Header.Get("Authorization"), r.URL.Query().Get("beta")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"input_tokens":42}`)
+	}))
+	defer anthropic.Close()
+	openai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, "openai "+r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer openai.Close()
+
+	ps := NewProviderService()
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, countTokensEndpoint+"?beta=true", strings.NewReader(
+			`{"model":"claude-sonnet-4-5","system":"You are a helpful assistant.","messages":[{"role":"user","content":"Count the tokens in this message, please."}]}`)))
+		return rec
+	}
+	save := func(providers ...Provider) {
+		if err := ps.SaveProviders("claude", providers); err != nil {
+			t.Fatalf("保存 provider 失败: %v", err)
+		}
+	}
+	openaiProvider := Provider{ID: 1, Name: "openai", APIURL: openai.URL, APIKey: "sk-openai-1234567890", Enabled: true, APIFormat: APIFormatOpenAIChat}
+	anthropicProvider := Provider{ID: 2, Name: "anthropic", APIURL: anthropic.URL, APIKey: "sk-ant-1234567890", Enabled: true,
+		ModelRewrites: []ModelRewrite{{Match: "claude-sonnet-4-5", Target: "claude-sonnet-4-5-20250929"}}}
+
+	// 排在前面的 OpenAI 兼容 provider 不支持计数，转发给支持的 provider
+	save(openaiProvider, anthropicProvider)
+	rec := post()
+	if rec.Code != http.StatusOK || gjson.Get(rec.Body.String(), "input_tokens").Int() != 42 || rec.Header().Get(countTokensSourceHeader) != "" {
+		t.Fatalf("应返回上游的计数: %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Join(hits, ",") != "anthropic "+countTokensEndpoint || model != "claude-sonnet-4-5-20250929" ||
+		auth != "Bearer sk-ant-1234567890" || beta != "true" {
+		t.Fatalf("转发的计数请求错误: hits=%v model=%q auth=%q beta=%q", hits, model, auth, beta)
+	}
+
+	// 上游不提供计数接口时本地估算
+	supported = false
+	hits = nil
+	rec = post()
+	var estimated struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &estimated); err != nil || rec.Code != http.StatusOK || estimated.InputTokens <= 0 ||
+		!strings.HasPrefix(rec.Header().Get(countTokensSourceHeader), "estimated") {
+		t.Fatalf("上游失败时应本地估算: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(hits) != 1 {
+		t.Fatalf("上游失败时不应继续尝试其他 provider: %v", hits)
+	}
+
+	// 只有 OpenAI 兼容 provider 时不请求上游
+	save(openaiProvider)
+	hits = nil
+	if rec := post(); rec.Code != http.StatusOK || gjson.Get(rec.Body.String(), "input_tokens").Int() != int64(estimated.InputTokens) || len(hits) != 0 {
+		t.Fatalf("没有支持计数的 provider 时应直接本地估算: %d %s %v", rec.Code, rec.Body.String(), hits)
+	}
+}
+
+func TestCountTokensUsesResponseCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	hits := 0
+	anthropic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"input_tokens":42}`)
+	}))
+	defer anthropic.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "anthropic", APIURL: anthropic.URL, APIKey: "sk-ant-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Cache = ResponseCacheConfig{Enabled: true}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, countTokensEndpoint, strings.NewReader(
+			`{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`)))
+		if rec.Code != http.StatusOK || gjson.Get(rec.Body.String(), "input_tokens").Int() != 42 {
+			t.Fatalf("计数请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	if hits != 1 || relay.ResponseCacheStats().Hits != 2 {
+		t.Fatalf("重复的计数请求应使用缓存: hits=%d stats=%+v", hits, relay.ResponseCacheStats())
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+func TestCurrencyConversion(t *testing.T) {
+	t.Cleanup(func() { ApplyCurrencyConfig(CurrencyConfig{}) })
+
+	if got := modelpricing.FormatCost(0.01234); got != "$0.0123" {
+		t.Fatalf("默认应按美元显示，实际 %s", got)
+	}
+
+	ApplyCurrencyConfig(CurrencyConfig{Target: "cny", Rates: map[string]float64{"CNY": 7.2}})
+	if got := modelpricing.FormatCost(0.5); got != "¥3.6000" {
+		t.Fatalf("FormatCost = %s", got)
+	}
+	info := NewLogService().CurrencyInfo()
+	if info.Currency != "CNY" || info.Rate != 7.2 || info.Error != "" {
+		t.Fatalf("CurrencyInfo = %+v", info)
+	}
+	html, err := RenderScorecards(ScorecardReport{Providers: []ProviderScorecard{{Provider: "p", CostTotal: 1}}}, "html")
+	if err != nil || !strings.Contains(string(html), "¥7.2000") {
+		t.Fatalf("scorecard 应按目标货币显示费用: %v", err)
+	}
+
+	// 缺少目标货币的汇率时回退为美元
+	ApplyCurrencyConfig(CurrencyConfig{Target: "EUR", Rates: map[string]float64{"CNY": 7.2}})
+	if got := modelpricing.FormatCost(1); got != "$1.0000" {
+		t.Fatalf("汇率不可用时应回退为美元，实际 %s", got)
+	}
+	if info := NewLogService().CurrencyInfo(); info.Currency != "USD" || info.Error == "" {
+		t.Fatalf("CurrencyInfo 应报告汇率错误: %+v", info)
+	}
+	if got := modelpricing.FormatAmount(-12.5, "JPY"); got != "-¥12.50" {
+		t.Fatalf("FormatAmount = %s", got)
+	}
+}
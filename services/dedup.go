@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 响应头标记响应由同时进行中的相同请求共享
+const dedupHeader = "X-Code-Switch-Dedup"
+
+// DedupConfig 控制同时到达的相同请求的合并（默认关闭）
+// 开启后，同一客户端凭证、接口与请求体（忽略字段顺序与空白）的请求只向上游发送一次，响应同时写给所有等待的客户端
+type DedupConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DedupStats 是请求合并的运行统计
+type DedupStats struct {
+	// 合并到进行中请求的次数
+	Hits int64 `json:"hits"`
+	// 当前正在向上游发送、可被合并的请求数
+	InFlight int `json:"in_flight"`
+}
+
+// dedupCall 是一个进行中的请求，记录已写出的响应供合并的请求回放
+type dedupCall struct {
+	mu      sync.Mutex
+	status  int
+	header  http.Header
+	body    []byte
+	done    bool
+	aborted bool
+	// 每次写入后关闭并替换，唤醒等待中的请求
+	updated chan struct{}
+}
+
+func (call *dedupCall) append(status int, header http.Header, data []byte) {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	if call.header == nil {
+		call.status = status
+		call.header = header.Clone()
+	}
+	call.body = append(call.body, data...)
+	close(call.updated)
+	call.updated = make(chan struct{})
+}
+
+// follow 将进行中请求的响应写给合并的请求，直到响应结束或客户端断开；
+// 首次请求未写出响应就中断时返回 false，由调用方自行发送请求
+func (call *dedupCall) follow(ctx context.Context, w gin.ResponseWriter) bool {
+	written := 0
+	headerWritten := false
+	for {
+		call.mu.Lock()
+		header, status := call.header, call.status
+		data := call.body[written:]
+		done, aborted := call.done, call.aborted
+		updated := call.updated
+		call.mu.Unlock()
+
+		if !headerWritten && header != nil {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.Header().Set(dedupHeader, "coalesced")
+			w.WriteHeader(status)
+			headerWritten = true
+		}
+		if header == nil && done {
+			return !aborted
+		}
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return true
+			}
+			w.Flush()
+			written += len(data)
+		}
+		if done {
+			return true
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+// dedupWriter 在写给首次请求的客户端的同时记录响应
+type dedupWriter struct {
+	gin.ResponseWriter
+	call *dedupCall
+}
+
+func (w *dedupWriter) Write(data []byte) (int, error) {
+	w.call.append(w.Status(), w.Header(), data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *dedupWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// requestDeduper 按请求摘要记录进行中的请求
+type requestDeduper struct {
+	mu    sync.Mutex
+	calls map[string]*dedupCall
+	hits  atomic.Int64
+}
+
+func newRequestDeduper() *requestDeduper {
+	return &requestDeduper{calls: make(map[string]*dedupCall)}
+}
+
+// dedupKey 返回请求的合并 key；包含客户端凭证，不同客户端的请求不会合并
+func dedupKey(kind string, endpoint string, r *http.Request, body []byte) string {
+	return requestHash(body, kind, endpoint, r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("X-Api-Key"))
+}
+
+// join 加入 key 对应的进行中请求；没有时创建并返回 leader 为 true，由调用方发送请求
+func (rd *requestDeduper) join(key string) (*dedupCall, bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if call, ok := rd.calls[key]; ok {
+		rd.hits.Add(1)
+		return call, false
+	}
+	call := &dedupCall{updated: make(chan struct{})}
+	rd.calls[key] = call
+	return call, true
+}
+
+// finish 结束进行中的请求，之后到达的相同请求重新发送
+func (rd *requestDeduper) finish(key string, call *dedupCall, aborted bool) {
+	rd.mu.Lock()
+	delete(rd.calls, key)
+	rd.mu.Unlock()
+	call.mu.Lock()
+	call.done = true
+	call.aborted = aborted
+	close(call.updated)
+	call.updated = make(chan struct{})
+	call.mu.Unlock()
+}
+
+func (rd *requestDeduper) snapshot() DedupStats {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	return DedupStats{Hits: rd.hits.Load(), InFlight: len(rd.calls)}
+}
+
+// dedupRequest 在开启请求合并时处理相同的进行中请求：已有相同请求时等待并共享其响应，返回 true 表示已处理；
+// 否则将当前请求登记为首次请求，返回的函数需在请求结束时调用
+func (prs *ProviderRelayService) dedupRequest(c *gin.Context, cfg DedupConfig, kind string, endpoint string, body []byte) (bool, func()) {
+	if !cfg.Enabled {
+		return false, func() {}
+	}
+	key := dedupKey(kind, endpoint, c.Request, body)
+	if listener := c.GetString(listenerContextKey); listener != "" {
+		// 不同监听配置使用的 provider 不同，请求不跨监听合并
+		key = listener + "|" + key
+	}
+	call, leader := prs.dedup.join(key)
+	if !leader {
+		fmt.Printf("[INFO] 合并到进行中的相同请求\n")
+		if call.follow(c.Request.Context(), c.Writer) {
+			return true, nil
+		}
+		fmt.Printf("[INFO] 进行中的相同请求已中断，重新发送\n")
+		return false, func() {}
+	}
+	// 客户端断开的请求不共享，等待的请求改为自行发送
+	ctx := c.Request.Context()
+	c.Writer = &dedupWriter{ResponseWriter: c.Writer, call: call}
+	return false, func() { prs.dedup.finish(key, call, ctx.Err() != nil) }
+}
+
+// DedupStats 返回请求合并的命中次数与当前可合并的请求数
+func (prs *ProviderRelayService) DedupStats() DedupStats {
+	return prs.dedup.snapshot()
+}
+
+// writeDedupMetrics 输出请求合并指标
+func writeDedupMetrics(metrics *metricsWriter, stats DedupStats) {
+	metrics.counter("request_dedup_hits_total", "合并到进行中相同请求的次数", float64(stats.Hits))
+	metrics.gauge("request_dedup_inflight", "当前可被合并的进行中请求数", float64(stats.InFlight))
+}
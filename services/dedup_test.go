@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRelayCoalescesConcurrentIdenticalRequests(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5}}\n\n")
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: upstream.URL, APIKey: "sk-primary-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Dedup = DedupConfig{Enabled: true}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(body string, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		req.Header.Set("X-Api-Key", apiKey)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	waitFor := func(cond func() bool) {
+		for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待超时: %+v", relay.DedupStats())
+			}
+		}
+	}
+
+	results := make(chan *httptest.ResponseRecorder, 3)
+	go func() {
+		results <- post(`{"model":"claude-sonnet-4-5","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`, "client-a")
+	}()
+	waitFor(func() bool { return hits.Load() == 1 })
+	// 客户端自行重试的相同请求（字段顺序不同）合并到进行中的请求；其他客户端的请求单独发送
+	go func() {
+		results <- post(`{"stream":true,"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`, "client-a")
+	}()
+	waitFor(func() bool { return relay.DedupStats().Hits == 1 })
+	go func() {
+		results <- post(`{"model":"claude-sonnet-4-5","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`, "client-b")
+	}()
+	waitFor(func() bool { return hits.Load() == 2 })
+	close(release)
+
+	var coalesced, bodies []string
+	for i := 0; i < 3; i++ {
+		rec := <-results
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "message_delta") {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+		coalesced = append(coalesced, rec.Header().Get(dedupHeader))
+		bodies = append(bodies, rec.Body.String())
+	}
+	if hits.Load() != 2 || strings.Count(strings.Join(coalesced, ","), "coalesced") != 1 {
+		t.Fatalf("相同的请求应只发送一次: hits=%d %v", hits.Load(), coalesced)
+	}
+	if bodies[0] != bodies[1] || bodies[1] != bodies[2] {
+		t.Fatalf("合并的请求应收到完整的响应: %q", bodies)
+	}
+	if stats := relay.DedupStats(); stats.Hits != 1 || stats.InFlight != 0 {
+		t.Fatalf("合并统计错误: %+v", stats)
+	}
+
+	var metrics metricsWriter
+	writeDedupMetrics(&metrics, relay.DedupStats())
+	if !strings.Contains(metrics.String(), "\nrequest_dedup_hits_total 1\n") {
+		t.Fatalf("metrics 缺少合并次数:\n%s", metrics.String())
+	}
+}
+
+func TestDedupFollowerRetriesWhenLeaderAborts(t *testing.T) {
+	deduper := newRequestDeduper()
+	call, leader := deduper.join("key")
+	if !leader {
+		t.Fatalf("第一个请求应发送到上游")
+	}
+	if _, leader := deduper.join("key"); leader {
+		t.Fatalf("相同的请求应合并")
+	}
+	done := make(chan bool)
+	go func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		done <- call.follow(context.Background(), c.Writer)
+	}()
+	deduper.finish("key", call, true)
+	if <-done {
+		t.Fatalf("首次请求未写出响应就中断时应自行发送")
+	}
+	if _, leader := deduper.join("key"); !leader {
+		t.Fatalf("结束后到达的相同请求应重新发送")
+	}
+}
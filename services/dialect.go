@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// Provider.APIFormat 的取值，留空时与平台的协议一致
+	APIFormatAnthropic  = "anthropic"
+	APIFormatOpenAIChat = "openai-chat"
+	APIFormatGemini     = "gemini"
+	APIFormatBedrock    = "bedrock"
+	APIFormatVertex     = "vertex"
+)
+
+// upstreamDialect 在客户端与 provider 使用不同协议时转换请求与响应，
+// 让只支持一种协议的 provider 也能服务另一种协议的客户端
+type upstreamDialect interface {
+	// endpoint 返回 provider 上对应的接口路径（拼接在 apiUrl 之后）
+	endpoint(model string, stream bool) string
+	// translateRequest 将客户端的请求体转换为 provider 的协议，model 为发送给 provider 的模型名
+	translateRequest(body []byte, model string) ([]byte, error)
+	// responseHook 返回将 provider 的成功响应转换回客户端协议的钩子，流式转换有状态，每次请求新建；
+	// provider 报告的用量写入 usage
+	responseHook(model string, stream bool, usage *upstreamUsage) xrequest.ResponseHook
+}
+
+// streamDecoder 由流式响应不是 SSE 的协议实现，在响应钩子之前将响应体解码为 SSE
+type streamDecoder interface {
+	decodeStream(body io.ReadCloser) io.ReadCloser
+}
+
+// upstreamUsage 是 provider 在原始响应中报告的用量。转换后的事件无法完整表达缓存与推理 token，
+// 记录请求日志时以它为准
+type upstreamUsage struct {
+	reported          bool
+	inputTokens       int
+	outputTokens      int
+	cacheCreateTokens int
+	cacheReadTokens   int
+	reasoningTokens   int
+}
+
+// apply 用 provider 报告的用量覆盖从转换后事件解析出的 token 数，未报告时保持不变
+func (u *upstreamUsage) apply(log *ReqeustLog) {
+	if u == nil || !u.reported {
+		return
+	}
+	log.InputTokens = u.inputTokens
+	log.OutputTokens = u.outputTokens
+	log.CacheCreateTokens = u.cacheCreateTokens
+	log.CacheReadTokens = u.cacheReadTokens
+	log.ReasoningTokens = u.reasoningTokens
+}
+
+// anthropicUsageHook 返回原样转发 Anthropic 格式响应并记录其中用量的钩子，用于 Bedrock、Vertex 等仍使用 Anthropic 格式的协议
+func anthropicUsageHook(stream bool, usage *upstreamUsage) xrequest.ResponseHook {
+	if !stream {
+		return func(data []byte) (bool, []byte) {
+			if reported := gjson.GetBytes(data, "usage"); reported.IsObject() {
+				usage.recordAnthropic(reported, true)
+			}
+			return true, data
+		}
+	}
+	return func(line []byte) (bool, []byte) {
+		payload := strings.TrimSpace(string(line))
+		if strings.HasPrefix(payload, "data:") {
+			event := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(payload, "data:")))
+			switch event.Get("type").String() {
+			case "message_start":
+				usage.recordAnthropic(event.Get("message.usage"), true)
+			case "message_delta":
+				usage.recordAnthropic(event.Get("usage"), false)
+			}
+		}
+		return true, line
+	}
+}
+
+// recordAnthropic 记录 Anthropic 格式的用量；message_delta 中的用量是累计值，只覆盖其中出现的字段
+func (u *upstreamUsage) recordAnthropic(usage gjson.Result, complete bool) {
+	if !usage.IsObject() {
+		return
+	}
+	u.reported = true
+	set := func(target *int, path string) {
+		if value := usage.Get(path); complete || value.Exists() {
+			*target = int(value.Int())
+		}
+	}
+	set(&u.inputTokens, "input_tokens")
+	set(&u.outputTokens, "output_tokens")
+	set(&u.cacheCreateTokens, "cache_creation_input_tokens")
+	set(&u.cacheReadTokens, "cache_read_input_tokens")
+}
+
+// claudeDialects 是 claude 平台（/v1/messages）可以转换到的 provider 协议
+var claudeDialects = map[string]func(p *Provider) upstreamDialect{
+	APIFormatOpenAIChat: func(p *Provider) upstreamDialect { return openAIChatDialect{azure: p.Azure != nil} },
+	APIFormatGemini: func(p *Provider) upstreamDialect {
+		return geminiDialect{safetySettings: p.GeminiSafetySettings}
+	},
+	APIFormatBedrock: func(*Provider) upstreamDialect { return bedrockDialect{} },
+	APIFormatVertex: func(p *Provider) upstreamDialect {
+		return vertexDialect{gemini: geminiDialect{safetySettings: p.GeminiSafetySettings}}
+	},
+}
+
+// dialect 返回 provider 处理该平台接口时需要的协议转换，协议一致或不支持转换时返回 nil
+func (p *Provider) dialect(kind string, endpoint string) upstreamDialect {
+	if p.APIFormat == "" || p.APIFormat == APIFormatAnthropic || kind != "claude" || endpoint != "/v1/messages" {
+		return nil
+	}
+	newDialect, ok := claudeDialects[p.APIFormat]
+	if !ok {
+		return nil
+	}
+	return newDialect(p)
+}
+
+// validateAPIFormat 检查 apiFormat 是否为支持的协议
+func validateAPIFormat(format string) error {
+	if format == "" || format == APIFormatAnthropic {
+		return nil
+	}
+	if _, ok := claudeDialects[format]; ok {
+		return nil
+	}
+	return fmt.Errorf("不支持的 apiFormat: %s", format)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+)
+
+// Embedding 与重排序接口，转发到 codex（OpenAI 兼容）的 provider，响应为完整的 JSON 而非 SSE。
+const (
+	embeddingsEndpoint = "/embeddings"
+	rerankEndpoint     = "/rerank"
+)
+
+// isEmbeddingEndpoint 判断接口是否为 embedding 或重排序
+func isEmbeddingEndpoint(endpoint string) bool {
+	return endpoint == embeddingsEndpoint || endpoint == rerankEndpoint
+}
+
+// embeddingUsageHook 返回解析 embedding / 重排序响应用量的钩子，替代按 SSE 事件解析的 ReqeustLogHook
+func embeddingUsageHook(endpoint string, usage *ReqeustLog) xrequest.ResponseHook {
+	return func(data []byte) (bool, []byte) {
+		EmbeddingParseTokenUsageFromResponse(endpoint, string(data), usage)
+		return true, data
+	}
+}
+
+// EmbeddingParseTokenUsageFromResponse 解析 embedding / 重排序响应的用量：
+// OpenAI 为 usage.prompt_tokens，Jina、Voyage 的重排序为 usage.total_tokens，Cohere 为 meta.billed_units。
+// 重排序按查询次数计费，上游未报告 search_units 时每个请求按一次查询计算。
+func EmbeddingParseTokenUsageFromResponse(endpoint string, data string, usage *ReqeustLog) {
+	for _, path := range []string{"usage.prompt_tokens", "usage.total_tokens", "meta.billed_units.input_tokens"} {
+		if tokens := gjson.Get(data, path); tokens.Exists() {
+			usage.InputTokens = int(tokens.Int())
+			break
+		}
+	}
+	if endpoint != rerankEndpoint {
+		return
+	}
+	usage.Queries = 1
+	if units := gjson.Get(data, "meta.billed_units.search_units"); units.Exists() {
+		usage.Queries = int(units.Int())
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestEmbeddingParseTokenUsage(t *testing.T) {
+	var openai ReqeustLog
+	EmbeddingParseTokenUsageFromResponse(embeddingsEndpoint, `{"object":"list","data":[{"embedding":[0.1]}],"usage":{"prompt_tokens":8,"total_tokens":8}}`, &openai)
+	if openai.InputTokens != 8 || openai.Queries != 0 {
+		t.Fatalf("embedding 用量错误: %+v", openai)
+	}
+	var cohere ReqeustLog
+	EmbeddingParseTokenUsageFromResponse(rerankEndpoint, `{"results":[],"meta":{"billed_units":{"search_units":2}}}`, &cohere)
+	if cohere.Queries != 2 || cohere.InputTokens != 0 {
+		t.Fatalf("Cohere 重排序应按 search_units 计算查询次数: %+v", cohere)
+	}
+	var jina ReqeustLog
+	EmbeddingParseTokenUsageFromResponse(rerankEndpoint, `{"results":[],"usage":{"total_tokens":120}}`, &jina)
+	if jina.Queries != 1 || jina.InputTokens != 120 {
+		t.Fatalf("未报告 search_units 时应按一次查询计算: %+v", jina)
+	}
+}
+
+func TestRelayEmbeddingsRecordsCost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],"model":"text-embedding-3-small","usage":{"prompt_tokens":1000,"total_tokens":1000}}`)
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("codex", []Provider{
+		{ID: 1, Name: "openai", APIURL: upstream.URL + "/v1", APIKey: "sk-embed-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/embeddings",
+		strings.NewReader(`{"model":"text-embedding-3-small","input":"hello"}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"embedding"`) {
+		t.Fatalf("转发 embedding 请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwarded != "/v1/embeddings" {
+		t.Fatalf("应转发到上游的 /v1/embeddings: %s", forwarded)
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("model", "text-embedding-3-small"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	logEntry := requestLogFromRecord(record)
+	if logEntry.InputTokens != 1000 || logEntry.Platform != "codex" {
+		t.Fatalf("embedding 用量记录错误: %+v", logEntry)
+	}
+	// text-embedding-3-small 为 $0.02 / 1M tokens
+	if cost := record.GetFloat64("original_cost"); math.Abs(cost-0.00002) > 1e-12 {
+		t.Fatalf("embedding 费用应为 0.00002: %v", cost)
+	}
+}
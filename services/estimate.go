@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 请求未指定 max_tokens / max_output_tokens 时假设的输出 token 数
+	defaultEstimatedOutputTokens = 4096
+	// 每张输入图片按约 1600 个 token 估算（Anthropic 对 1092x1092 图片的计费）
+	estimatedTokensPerImage = 1600
+	// 内置分词器的名称，按约 4 个字符 1 个 token 估算
+	heuristicTokenizerName = "heuristic"
+)
+
+// Tokenizer 统计提示词的 token 数，可以通过 RegisterTokenizer 接入 tiktoken 等本地分词器使估算更准确
+type Tokenizer interface {
+	Name() string
+	CountTokens(model string, text string) (int, error)
+}
+
+var (
+	tokenizerMu sync.RWMutex
+	tokenizer   Tokenizer = heuristicTokenizer{}
+)
+
+// RegisterTokenizer 设置费用估算使用的分词器，传入 nil 时恢复按字符数估算
+func RegisterTokenizer(t Tokenizer) {
+	tokenizerMu.Lock()
+	defer tokenizerMu.Unlock()
+	if t == nil {
+		t = heuristicTokenizer{}
+	}
+	tokenizer = t
+}
+
+// countPromptTokens 使用注册的分词器统计 token 数，分词器出错时按字符数估算
+func countPromptTokens(model string, text string) (int, string) {
+	tokenizerMu.RLock()
+	t := tokenizer
+	tokenizerMu.RUnlock()
+	if count, err := t.CountTokens(model, text); err == nil {
+		return count, t.Name()
+	} else if t.Name() != heuristicTokenizerName {
+		fmt.Printf("[WARN] 分词器 %s 统计 token 失败，按字符数估算: %v\n", t.Name(), err)
+	}
+	count, _ := heuristicTokenizer{}.CountTokens(model, text)
+	return count, heuristicTokenizerName
+}
+
+type heuristicTokenizer struct{}
+
+func (heuristicTokenizer) Name() string { return heuristicTokenizerName }
+
+func (heuristicTokenizer) CountTokens(_ string, text string) (int, error) {
+	return (len(text) + estimatedCharsPerToken - 1) / estimatedCharsPerToken, nil
+}
+
+// RequestEstimate 是发送请求前估算的费用（按官方价格，不含 provider 的计价调整与缓存折扣）
+type RequestEstimate struct {
+	Platform             string  `json:"platform"`
+	Model                string  `json:"model"`
+	PromptTokens         int     `json:"prompt_tokens"`
+	ExpectedOutputTokens int     `json:"expected_output_tokens"`
+	Tokenizer            string  `json:"tokenizer"`
+	InputCost            float64 `json:"input_cost"`
+	OutputCost           float64 `json:"output_cost"`
+	TotalCost            float64 `json:"total_cost"`
+	HasPricing           bool    `json:"has_pricing"`
+	// Display 为按报表货币格式化的费用，如 ~$0.42
+	Display string `json:"display"`
+}
+
+// EstimateRequest 估算一个 claude（/v1/messages）或 codex（/responses）请求的费用
+func (prs *ProviderRelayService) EstimateRequest(kind string, body []byte) (RequestEstimate, error) {
+	if kind != "claude" && kind != "codex" {
+		return RequestEstimate{}, fmt.Errorf("不支持的平台: %s", kind)
+	}
+	if !gjson.ValidBytes(body) {
+		return RequestEstimate{}, errors.New("请求体不是有效的 JSON")
+	}
+	return estimateRequest(kind, gjson.GetBytes(body, "model").String(), body), nil
+}
+
+func estimateRequest(kind string, model string, body []byte) RequestEstimate {
+	estimate := RequestEstimate{Platform: kind, Model: model}
+	estimate.PromptTokens, estimate.Tokenizer = countPromptTokens(model, promptText(kind, body))
+	estimate.PromptTokens += countInputImages(kind, body) * estimatedTokensPerImage
+
+	estimate.ExpectedOutputTokens = requestedOutputTokens(kind, body)
+	if estimate.ExpectedOutputTokens <= 0 {
+		estimate.ExpectedOutputTokens = defaultEstimatedOutputTokens
+	}
+
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil || model == "" {
+		estimate.Display = "~" + modelpricing.FormatAmount(0, modelpricing.CurrencyUSD)
+		return estimate
+	}
+	if _, maxOutput, ok := pricing.ContextWindow(model); ok && maxOutput > 0 && estimate.ExpectedOutputTokens > maxOutput {
+		estimate.ExpectedOutputTokens = maxOutput
+	}
+	cost := pricing.EstimateCost(model, estimate.PromptTokens, estimate.ExpectedOutputTokens, modelpricing.WithRequestTime(time.Now()))
+	estimate.InputCost = cost.InputCost
+	estimate.OutputCost = cost.OutputCost
+	estimate.TotalCost = cost.TotalCost
+	estimate.HasPricing = cost.HasPricing
+	estimate.Display = "~" + modelpricing.DefaultConverter().Format(cost.TotalCost)
+	return estimate
+}
+
+// requestedOutputTokens 返回请求指定的输出上限（claude 为 max_tokens，codex 为 max_output_tokens），未指定时为 0
+func requestedOutputTokens(kind string, body []byte) int {
+	outputField := "max_tokens"
+	if kind == "codex" {
+		outputField = "max_output_tokens"
+	}
+	return int(gjson.GetBytes(body, outputField).Int())
+}
+
+// promptTextFields 是各平台请求中计入提示词的字段
+var promptTextFields = map[string][]string{
+	"claude": {"system", "messages", "tools"},
+	"codex":  {"instructions", "input", "tools"},
+}
+
+// 不计入提示词的字段：图片与文件内容单独估算，签名与加密内容不会作为文本发送给模型
+var promptSkipKeys = map[string]bool{
+	"data":              true,
+	"image_url":         true,
+	"file_data":         true,
+	"signature":         true,
+	"encrypted_content": true,
+}
+
+// promptText 提取请求中会作为提示词计费的文本（消息、系统提示与工具定义中的字符串）
+func promptText(kind string, body []byte) string {
+	var builder strings.Builder
+	for _, field := range promptTextFields[kind] {
+		collectPromptText(gjson.GetBytes(body, field), &builder)
+	}
+	return builder.String()
+}
+
+func collectPromptText(value gjson.Result, builder *strings.Builder) {
+	switch {
+	case value.IsObject():
+		value.ForEach(func(key, item gjson.Result) bool {
+			if !promptSkipKeys[key.String()] {
+				builder.WriteString(key.String())
+				collectPromptText(item, builder)
+			}
+			return true
+		})
+	case value.IsArray():
+		value.ForEach(func(_, item gjson.Result) bool {
+			collectPromptText(item, builder)
+			return true
+		})
+	case value.Type == gjson.String:
+		builder.WriteString(value.String())
+	}
+}
+
+// budgetBlockReason 在请求的估算费用超出单次上限或当天剩余预算时返回拒绝原因
+func (cfg ClientConfig) budgetBlockReason(estimate RequestEstimate) (string, error) {
+	if cfg.MaxRequestCost > 0 && estimate.TotalCost > cfg.MaxRequestCost {
+		return fmt.Sprintf("请求的估算费用 $%.4f 超出单次上限 $%.4f", estimate.TotalCost, cfg.MaxRequestCost), nil
+	}
+	if cfg.EnforceDailyBudget && cfg.DailyBudget > 0 {
+		spent, err := NewLogService().SpentSince(startOfDay(time.Now()))
+		if err != nil {
+			return "", err
+		}
+		if spent+estimate.TotalCost > cfg.DailyBudget {
+			return fmt.Sprintf("当天已使用 $%.4f，请求的估算费用 $%.4f 将超出每日预算 $%.4f", spent, estimate.TotalCost, cfg.DailyBudget), nil
+		}
+	}
+	return "", nil
+}
+
+// enforcesBudget 返回是否需要在发送前估算费用
+func (cfg ClientConfig) enforcesBudget() bool {
+	return cfg.MaxRequestCost > 0 || (cfg.EnforceDailyBudget && cfg.DailyBudget > 0)
+}
+
+// clientEstimateHandler 估算请求体的费用而不发送，platform 查询参数为 claude（默认）或 codex
+func (prs *ProviderRelayService) clientEstimateHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	estimate, err := prs.EstimateRequest(c.DefaultQuery("platform", "claude"), body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, estimate)
+}
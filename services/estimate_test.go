@@ -0,0 +1,91 @@
+package services
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+type fixedTokenizer struct{ tokens int }
+
+func (fixedTokenizer) Name() string { return "fixed" }
+
+func (t fixedTokenizer) CountTokens(string, string) (int, error) { return t.tokens, nil }
+
+func TestEstimateRequestCost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "p1", APIURL: "https://p1.example", APIKey: "sk-p1-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	// 图片内容不计入文本，按张数估算
+	image := `{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + strings.Repeat("A", 40000) + `"}}`
+	request := `{"model":"claude-sonnet-4-5","max_tokens":1000,"messages":[{"role":"user","content":[{"type":"text","text":"hello"},` + image + `]}]}`
+	rec := post("/v1/client/estimate", request)
+	var estimate RequestEstimate
+	if err := json.Unmarshal(rec.Body.Bytes(), &estimate); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("估算失败 %d: %s", rec.Code, rec.Body.String())
+	}
+	if estimate.Tokenizer != heuristicTokenizerName || estimate.PromptTokens < estimatedTokensPerImage || estimate.PromptTokens > estimatedTokensPerImage+50 {
+		t.Fatalf("按字符数估算的提示词 token 错误: %+v", estimate)
+	}
+
+	RegisterTokenizer(fixedTokenizer{tokens: 100000})
+	t.Cleanup(func() { RegisterTokenizer(nil) })
+	rec = post("/v1/client/estimate", `{"model":"claude-sonnet-4-5","max_tokens":1000,"messages":[]}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &estimate); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	// 10 万输入 token $0.3 + 1000 输出 token $0.015
+	if estimate.Tokenizer != "fixed" || !estimate.HasPricing || math.Abs(estimate.TotalCost-0.315) > 1e-9 || !strings.HasPrefix(estimate.Display, "~") {
+		t.Fatalf("估算费用错误: %+v", estimate)
+	}
+	if rec := post("/v1/client/estimate?platform=gemini", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("不支持的平台应返回 400: %d", rec.Code)
+	}
+
+	// 当天已用 $0.3，加上估算的 $0.315 超出 $0.5 的预算
+	if _, err := xdb.New("request_log", xdb.WithSaveZero()).Insert(xdb.Record{
+		"platform": "claude", "provider": "p1", "model": "claude-sonnet-4-5", "http_code": 200,
+		"input_tokens": 100000, "created_at": time.Now().Format(timeLayout),
+	}); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+	cfg := defaultRelayConfig()
+	cfg.Client = ClientConfig{DailyBudget: 0.5, EnforceDailyBudget: true}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	rec = post("/v1/messages", `{"model":"claude-sonnet-4-5","max_tokens":1000,"messages":[]}`)
+	if rec.Code != http.StatusPaymentRequired || !strings.Contains(rec.Body.String(), "每日预算") {
+		t.Fatalf("超出每日预算的请求应被拒绝: %d %s", rec.Code, rec.Body.String())
+	}
+
+	cfg.Client = ClientConfig{MaxRequestCost: 0.1}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	rec = post("/v1/messages", `{"model":"claude-sonnet-4-5","max_tokens":1000,"messages":[]}`)
+	if rec.Code != http.StatusPaymentRequired || !strings.Contains(rec.Body.String(), "单次上限") {
+		t.Fatalf("超出单次上限的请求应被拒绝: %d %s", rec.Code, rec.Body.String())
+	}
+}
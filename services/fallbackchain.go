@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 响应头，标记由降级链的第几跳处理，如 "2/3 bedrock"
+	fallbackHopHeader = "X-Code-Switch-Fallback-Hop"
+
+	// FallbackHop.FallbackOn 的取值：失败的错误类型
+	FallbackOnAny         = "any"
+	FallbackOnRateLimit   = "rate_limit"
+	FallbackOnOverloaded  = "overloaded"
+	FallbackOnServerError = "server_error"
+	FallbackOnAuth        = "auth"
+	FallbackOnClientError = "client_error"
+	FallbackOnTimeout     = "timeout"
+	FallbackOnNetwork     = "network"
+)
+
+// FallbackChain 为匹配的模型声明固定的降级顺序（如 claude-sonnet-4* -> 官方、Bedrock、GLM 中转），
+// 匹配的请求按链逐跳尝试，不再按 level、weight 与路由策略排序
+type FallbackChain struct {
+	// 匹配请求模型名，规则与 modelRewrites 的 match 相同（通配符或 ^ 开头的正则）
+	Match string        `json:"match"`
+	Hops  []FallbackHop `json:"hops"`
+}
+
+// FallbackHop 是降级链中的一跳
+type FallbackHop struct {
+	// provider 名称
+	Provider string `json:"provider"`
+	// 在该 provider 上使用的模型，留空时使用请求的模型（仍会应用 provider 的模型映射）
+	Model string `json:"model,omitempty"`
+	// 该跳失败时只有错误类型在列表中才继续下一跳，留空或包含 any 时任意错误都继续：
+	// rate_limit、overloaded、server_error、auth、client_error、timeout、network
+	FallbackOn []string `json:"fallbackOn,omitempty"`
+	// 该 provider 上此模型近期的 p50 首字节延迟超过该值（毫秒）时跳过这一跳
+	MaxLatencyMs float64 `json:"maxLatencyMs,omitempty"`
+	// 当天费用达到每日预算（client.dailyBudget）的该比例（0-1）时跳过这一跳，如只在预算用到 80% 前使用官方 API
+	MaxBudgetUsage float64 `json:"maxBudgetUsage,omitempty"`
+}
+
+// fallbackChain 返回第一条匹配请求模型的降级链
+func (c RoutingConfig) fallbackChain(model string) *FallbackChain {
+	if model == "" {
+		return nil
+	}
+	for i := range c.FallbackChains {
+		chain := &c.FallbackChains[i]
+		if len(chain.Hops) == 0 {
+			continue
+		}
+		if _, ok := (ModelRewrite{Match: chain.Match, Target: model}).rewrite(model); ok {
+			return chain
+		}
+	}
+	return nil
+}
+
+// routeChain 是本次请求实际要尝试的各跳，与传给 relayProviders 的 provider 列表一一对应
+type routeChain struct {
+	hops []chainHop
+	// 降级链声明的总跳数
+	total int
+}
+
+type chainHop struct {
+	FallbackHop
+	// 在降级链中的位置，从 1 开始
+	position int
+}
+
+// chainProviders 按降级链的顺序返回可以尝试的 provider：不存在、不可用、延迟或预算条件不满足的跳被跳过
+func (prs *ProviderRelayService) chainProviders(kind string, requestedModel string, chain *FallbackChain, providers []Provider, client ClientConfig) ([]Provider, *routeChain) {
+	route := &routeChain{total: len(chain.Hops)}
+	budgetUsage := -1.0
+	var active []Provider
+	for i, hop := range chain.Hops {
+		index := slices.IndexFunc(providers, func(p Provider) bool { return p.Name == hop.Provider })
+		if index < 0 {
+			fmt.Printf("[WARN] 降级链 %s 的第 %d 跳 provider %s 不存在，已跳过\n", chain.Match, i+1, hop.Provider)
+			continue
+		}
+		provider := providers[index]
+		if model := strings.TrimSpace(hop.Model); model != "" {
+			provider.routedModel = model
+		}
+		if reason := routeSkipReason(provider, provider.routeModel(requestedModel)); reason != "" {
+			fmt.Printf("[INFO] 降级链第 %d 跳 %s %s，已跳过\n", i+1, provider.Name, reason)
+			continue
+		}
+		if reason := prs.quotas.blockReason(kind, provider); reason != "" {
+			fmt.Printf("[INFO] 降级链第 %d 跳 %s %s，已跳过\n", i+1, provider.Name, reason)
+			continue
+		}
+		if hop.MaxLatencyMs > 0 {
+			if latency := prs.latency.stats(kind, provider.Name, requestedModel); latency.Samples >= latencyMinSamples && latency.P50Ms > hop.MaxLatencyMs {
+				fmt.Printf("[INFO] 降级链第 %d 跳 %s 的 p50 首字节延迟 %.0fms 超过 %.0fms，已跳过\n", i+1, provider.Name, latency.P50Ms, hop.MaxLatencyMs)
+				continue
+			}
+		}
+		if hop.MaxBudgetUsage > 0 && client.DailyBudget > 0 {
+			if budgetUsage < 0 {
+				spent, err := NewLogService().SpentSince(startOfDay(time.Now()))
+				if err != nil {
+					fmt.Printf("[WARN] 查询当天费用失败，不按预算跳过: %v\n", err)
+					spent = 0
+				}
+				budgetUsage = spent / client.DailyBudget
+			}
+			if budgetUsage >= hop.MaxBudgetUsage {
+				fmt.Printf("[INFO] 降级链第 %d 跳 %s：当天已使用预算的 %.0f%%，已跳过\n", i+1, provider.Name, budgetUsage*100)
+				continue
+			}
+		}
+		active = append(active, provider)
+		route.hops = append(route.hops, chainHop{FallbackHop: hop, position: i + 1})
+	}
+	return active, route
+}
+
+// fallbackErrorType 返回失败对应的 FallbackOn 错误类型
+func fallbackErrorType(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FallbackOnTimeout
+	}
+	if isOverloadError(err) {
+		return FallbackOnOverloaded
+	}
+	var concurrencyErr *concurrencyLimitError
+	if errors.As(err, &concurrencyErr) {
+		return FallbackOnRateLimit
+	}
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return FallbackOnNetwork
+	}
+	switch code := upstreamErr.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		return FallbackOnRateLimit
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return FallbackOnAuth
+	case code >= http.StatusInternalServerError:
+		return FallbackOnServerError
+	default:
+		return FallbackOnClientError
+	}
+}
+
+// continues 判断这一跳以 err 失败后是否继续下一跳
+func (h chainHop) continues(err error) bool {
+	if len(h.FallbackOn) == 0 || slices.Contains(h.FallbackOn, FallbackOnAny) {
+		return true
+	}
+	return slices.Contains(h.FallbackOn, fallbackErrorType(err))
+}
+
+// annotate 在响应头中标记由哪一跳处理，失败的跳不会写出响应，最终保留成功那一跳的标记
+func (rc *routeChain) annotate(c *gin.Context, index int, provider Provider) {
+	hop := rc.hops[index]
+	c.Header(fallbackHopHeader, fmt.Sprintf("%d/%d %s", hop.position, rc.total, provider.Name))
+}
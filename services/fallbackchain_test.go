@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestFallbackErrorType(t *testing.T) {
+	cases := map[string]error{
+		FallbackOnRateLimit:   &UpstreamError{StatusCode: http.StatusTooManyRequests},
+		FallbackOnOverloaded:  &UpstreamError{StatusCode: 529, Body: `{"type":"error","error":{"type":"overloaded_error"}}`},
+		FallbackOnServerError: &UpstreamError{StatusCode: http.StatusInternalServerError},
+		FallbackOnAuth:        &UpstreamError{StatusCode: http.StatusForbidden},
+		FallbackOnClientError: &UpstreamError{StatusCode: http.StatusBadRequest},
+		FallbackOnTimeout:     fmt.Errorf("请求上游失败: %w", context.DeadlineExceeded),
+		FallbackOnNetwork:     errors.New("connection reset by peer"),
+	}
+	for expected, err := range cases {
+		if got := fallbackErrorType(err); got != expected {
+			t.Errorf("fallbackErrorType(%v) = %s，期望 %s", err, got, expected)
+		}
+	}
+	hop := chainHop{FallbackHop: FallbackHop{FallbackOn: []string{FallbackOnRateLimit, FallbackOnServerError}}}
+	if !hop.continues(cases[FallbackOnServerError]) || hop.continues(cases[FallbackOnClientError]) {
+		t.Fatalf("应只在 fallbackOn 列出的错误类型时继续")
+	}
+	if !(chainHop{}).continues(cases[FallbackOnClientError]) {
+		t.Fatalf("未设置 fallbackOn 时任意错误都应继续")
+	}
+}
+
+func TestRelayWalksFallbackChain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	status := map[string]int{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			hits = append(hits, name+":"+gjson.GetBytes(body, "model").String())
+			w.Header().Set("Content-Type", "application/json")
+			if code := status[name]; code != 0 {
+				w.WriteHeader(code)
+				fmt.Fprint(w, `{"error":"failed"}`)
+				return
+			}
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":10,"output_tokens":5}}`)
+		}
+	}
+	official := httptest.NewServer(handler("official"))
+	defer official.Close()
+	bedrock := httptest.NewServer(handler("bedrock"))
+	defer bedrock.Close()
+	glm := httptest.NewServer(handler("glm"))
+	defer glm.Close()
+
+	ps := NewProviderService()
+	// 列表顺序与 level 都与降级链相反，按链的顺序尝试
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "glm-relay", APIURL: glm.URL, APIKey: "sk-glm-1234567890", Enabled: true, Level: 1},
+		{ID: 2, Name: "bedrock", APIURL: bedrock.URL, APIKey: "sk-bedrock-1234567890", Enabled: true, Level: 2},
+		{ID: 3, Name: "official-anthropic", APIURL: official.URL, APIKey: "sk-ant-1234567890", Enabled: true, Level: 3},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Retry.MaxRetryAttempts = 0
+	cfg.Client = ClientConfig{DailyBudget: 1}
+	cfg.Routing.FallbackChains = []FallbackChain{{
+		Match: "claude-sonnet-4*",
+		Hops: []FallbackHop{
+			{Provider: "official-anthropic", FallbackOn: []string{FallbackOnServerError, FallbackOnRateLimit}, MaxBudgetUsage: 0.5},
+			{Provider: "bedrock", Model: "claude-sonnet-4-5-bedrock"},
+			{Provider: "glm-relay", Model: "glm-4.6"},
+		},
+	}}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(model string) *httptest.ResponseRecorder {
+		hits = nil
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"`+model+`","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+		return rec
+	}
+
+	if rec := post("claude-sonnet-4-5"); rec.Code != http.StatusOK || rec.Header().Get(fallbackHopHeader) != "1/3 official-anthropic" ||
+		strings.Join(hits, ",") != "official:claude-sonnet-4-5" {
+		t.Fatalf("应由第一跳处理: %d %q %v", rec.Code, rec.Header().Get(fallbackHopHeader), hits)
+	}
+
+	status["official"] = http.StatusInternalServerError
+	if rec := post("claude-sonnet-4-5"); rec.Code != http.StatusOK || rec.Header().Get(fallbackHopHeader) != "2/3 bedrock" ||
+		strings.Join(hits, ",") != "official:claude-sonnet-4-5,bedrock:claude-sonnet-4-5-bedrock" {
+		t.Fatalf("第一跳 5xx 后应由第二跳以指定模型处理: %d %q %v", rec.Code, rec.Header().Get(fallbackHopHeader), hits)
+	}
+
+	status["bedrock"] = http.StatusTooManyRequests
+	if rec := post("claude-sonnet-4-5"); rec.Code != http.StatusOK || rec.Header().Get(fallbackHopHeader) != "3/3 glm-relay" ||
+		strings.Join(hits, ",") != "official:claude-sonnet-4-5,bedrock:claude-sonnet-4-5-bedrock,glm:glm-4.6" {
+		t.Fatalf("应逐跳走完降级链: %d %q %v", rec.Code, rec.Header().Get(fallbackHopHeader), hits)
+	}
+
+	// 第一跳只在 5xx 与限流时降级
+	status["official"] = http.StatusBadRequest
+	if rec := post("claude-sonnet-4-5"); rec.Code == http.StatusOK || strings.Join(hits, ",") != "official:claude-sonnet-4-5" {
+		t.Fatalf("错误类型不在 fallbackOn 中时不应降级: %d %v", rec.Code, hits)
+	}
+
+	// 当天费用超过预算的一半后跳过官方 API
+	if _, err := xdb.New("request_log", xdb.WithSaveZero()).Insert(xdb.Record{
+		"platform": "claude", "provider": "official-anthropic", "model": "claude-sonnet-4-5", "http_code": 200,
+		"input_tokens": 200000, "created_at": time.Now().Format(timeLayout),
+	}); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+	status = map[string]int{}
+	// 清除上一步限流的 Key 冷却
+	relay.keyPool = newAPIKeyPool()
+	if rec := post("claude-sonnet-4-5"); rec.Code != http.StatusOK || rec.Header().Get(fallbackHopHeader) != "2/3 bedrock" ||
+		strings.Join(hits, ",") != "bedrock:claude-sonnet-4-5-bedrock" {
+		t.Fatalf("超出预算比例时应跳过第一跳: %d %q %v", rec.Code, rec.Header().Get(fallbackHopHeader), hits)
+	}
+
+	// 不匹配降级链的模型按 level 路由，不标记降级链
+	if rec := post("claude-haiku-4-5"); rec.Code != http.StatusOK || rec.Header().Get(fallbackHopHeader) != "" ||
+		strings.Join(hits, ",") != "glm:claude-haiku-4-5" {
+		t.Fatalf("不匹配的模型应按 level 路由: %d %q %v", rec.Code, rec.Header().Get(fallbackHopHeader), hits)
+	}
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// Vertex AI 需要的 OAuth2 权限范围
+	googleCloudScope = "https://www.googleapis.com/auth/cloud-platform"
+	googleTokenURL   = "https://oauth2.googleapis.com/token"
+	// access token 在过期前提前刷新，避免请求途中失效
+	googleTokenRefreshMargin = 5 * time.Minute
+)
+
+// googleCredentials 是 Google 凭据文件的内容：服务账号（service_account）或 gcloud auth application-default login 生成的用户凭据（authorized_user）
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// parseGoogleCredentials 解析 API Key 中的凭据 JSON，API Key 不是 JSON 时作为凭据文件路径读取
+func parseGoogleCredentials(apiKey string) (*googleCredentials, error) {
+	data := []byte(strings.TrimSpace(apiKey))
+	if !gjson.ValidBytes(data) {
+		content, err := os.ReadFile(expandHome(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("读取 Google 凭据文件失败: %w", err)
+		}
+		data = content
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("Google 凭据格式无效: %w", err)
+	}
+	switch creds.Type {
+	case "service_account":
+		if creds.ClientEmail == "" || creds.PrivateKey == "" {
+			return nil, errors.New("服务账号凭据缺少 client_email 或 private_key")
+		}
+	case "authorized_user":
+		if creds.ClientID == "" || creds.ClientSecret == "" || creds.RefreshToken == "" {
+			return nil, errors.New("用户凭据缺少 client_id、client_secret 或 refresh_token")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的 Google 凭据类型: %s", creds.Type)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+	return &creds, nil
+}
+
+// expandHome 展开路径开头的 ~
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
+
+// googleOAuthAuth 使用 Google 凭据换取 OAuth2 access token，以 Authorization: Bearer 发送
+type googleOAuthAuth struct{}
+
+func (googleOAuthAuth) Apply(req *http.Request, apiKey string) error {
+	token, err := googleTokens.token(req, apiKey)
+	if err != nil {
+		return err
+	}
+	removeClientAuth(req)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// googleTokenCache 按凭据缓存 access token，同一凭据同时只发起一次刷新
+type googleTokenCache struct {
+	mu      sync.Mutex
+	sources map[string]*googleTokenSource
+	client  *http.Client
+}
+
+type googleTokenSource struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+var googleTokens = &googleTokenCache{
+	sources: make(map[string]*googleTokenSource),
+	client:  &http.Client{Timeout: 30 * time.Second},
+}
+
+func (c *googleTokenCache) token(req *http.Request, apiKey string) (string, error) {
+	sum := sha256.Sum256([]byte(apiKey))
+	key := hex.EncodeToString(sum[:])
+	c.mu.Lock()
+	source, ok := c.sources[key]
+	if !ok {
+		source = &googleTokenSource{}
+		c.sources[key] = source
+	}
+	c.mu.Unlock()
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.token != "" && time.Until(source.expiresAt) > googleTokenRefreshMargin {
+		return source.token, nil
+	}
+	creds, err := parseGoogleCredentials(apiKey)
+	if err != nil {
+		return "", err
+	}
+	form, err := creds.tokenRequest(time.Now())
+	if err != nil {
+		return "", err
+	}
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(tokenReq)
+	if err != nil {
+		return "", fmt.Errorf("获取 Google access token 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		if body.Error != "" {
+			return "", fmt.Errorf("获取 Google access token 失败: HTTP %d %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+		}
+		return "", fmt.Errorf("获取 Google access token 失败: HTTP %d", resp.StatusCode)
+	}
+	source.token = body.AccessToken
+	source.expiresAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return source.token, nil
+}
+
+// tokenRequest 返回换取 access token 的表单：服务账号使用签名的 JWT，用户凭据使用 refresh token
+func (c *googleCredentials) tokenRequest(now time.Time) (url.Values, error) {
+	if c.Type == "authorized_user" {
+		return url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {c.ClientID},
+			"client_secret": {c.ClientSecret},
+			"refresh_token": {c.RefreshToken},
+		}, nil
+	}
+	assertion, err := c.signedJWT(now)
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}, nil
+}
+
+// signedJWT 生成服务账号的 RS256 JWT，有效期一小时
+func (c *googleCredentials) signedJWT(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", errors.New("服务账号的 private_key 不是有效的 PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("解析服务账号私钥失败: %w", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("服务账号私钥不是 RSA 密钥")
+	}
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if c.PrivateKeyID != "" {
+		header["kid"] = c.PrivateKeyID
+	}
+	claims := map[string]any{
+		"iss":   c.ClientEmail,
+		"scope": googleCloudScope,
+		"aud":   c.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	encode := func(value any) string {
+		data, _ := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
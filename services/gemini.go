@@ -0,0 +1,470 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+)
+
+// GeminiSafetySetting 是 Gemini 的一条安全设置，如 {"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_NONE"}
+type GeminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+// geminiDialect 将 Anthropic Messages 请求转换为 Gemini generateContent，并把响应（含 SSE 流）转换回 Anthropic 格式。
+// 支持 system、文本、图片、tool_use / tool_result、工具定义与 thinking 预算；Gemini 的思考内容不返回给客户端。
+type geminiDialect struct {
+	safetySettings []GeminiSafetySetting
+}
+
+// 模型名在路径中，流式接口需要 alt=sse 才返回 SSE
+func (geminiDialect) endpoint(model string, stream bool) string {
+	path := "/models/" + strings.TrimPrefix(model, "models/")
+	if stream {
+		return path + ":streamGenerateContent?alt=sse"
+	}
+	return path + ":generateContent"
+}
+
+func (d geminiDialect) translateRequest(body []byte, _ string) ([]byte, error) {
+	return anthropicToGeminiRequest(body, d.safetySettings)
+}
+
+func (geminiDialect) responseHook(model string, stream bool, usage *upstreamUsage) xrequest.ResponseHook {
+	if !stream {
+		return func(data []byte) (bool, []byte) {
+			return true, geminiToAnthropicResponse(data, model, usage)
+		}
+	}
+	translator := &geminiStreamTranslator{model: model, reported: usage}
+	return translator.hook
+}
+
+// anthropicToGeminiRequest 转换 Anthropic Messages 请求体。
+// 请求体中的 safetySettings（或 safety_settings）原样透传，没有时使用 provider 配置的安全设置
+func anthropicToGeminiRequest(body []byte, safetySettings []GeminiSafetySetting) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("请求体不是有效的 JSON")
+	}
+	request := gjson.ParseBytes(body)
+	gemini := map[string]any{}
+
+	if system := anthropicText(request.Get("system")); system != "" {
+		gemini["systemInstruction"] = map[string]any{"parts": []map[string]any{{"text": system}}}
+	}
+	// functionResponse 需要函数名，按 tool_use_id 从之前的 tool_use 中查找
+	toolNames := make(map[string]string)
+	contents := make([]map[string]any, 0)
+	for _, message := range request.Get("messages").Array() {
+		if parts := geminiParts(message.Get("content"), toolNames); len(parts) > 0 {
+			role := "user"
+			if message.Get("role").String() == "assistant" {
+				role = "model"
+			}
+			contents = append(contents, map[string]any{"role": role, "parts": parts})
+		}
+	}
+	gemini["contents"] = contents
+
+	config := map[string]any{}
+	if maxTokens := request.Get("max_tokens"); maxTokens.Exists() {
+		config["maxOutputTokens"] = maxTokens.Int()
+	}
+	for field, target := range map[string]string{"temperature": "temperature", "top_p": "topP"} {
+		if value := request.Get(field); value.Exists() {
+			config[target] = value.Float()
+		}
+	}
+	if topK := request.Get("top_k"); topK.Exists() {
+		config["topK"] = topK.Int()
+	}
+	if stop := request.Get("stop_sequences"); stop.IsArray() && len(stop.Array()) > 0 {
+		sequences := make([]string, 0)
+		for _, sequence := range stop.Array() {
+			sequences = append(sequences, sequence.String())
+		}
+		config["stopSequences"] = sequences
+	}
+	if thinking := request.Get("thinking"); thinking.Get("type").String() == "enabled" {
+		config["thinkingConfig"] = map[string]any{"thinkingBudget": thinking.Get("budget_tokens").Int()}
+	}
+	if len(config) > 0 {
+		gemini["generationConfig"] = config
+	}
+
+	declarations := make([]map[string]any, 0)
+	for _, tool := range request.Get("tools").Array() {
+		// web_search 等服务端工具没有 input_schema，无法表达为函数声明
+		schema := tool.Get("input_schema")
+		if !schema.Exists() {
+			continue
+		}
+		declaration := map[string]any{"name": tool.Get("name").String()}
+		if description := tool.Get("description").String(); description != "" {
+			declaration["description"] = description
+		}
+		// 没有参数的函数不能声明空的 properties
+		if parameters, ok := geminiSchema(schema.Value()).(map[string]any); ok {
+			if properties, ok := parameters["properties"].(map[string]any); !ok || len(properties) > 0 {
+				declaration["parameters"] = parameters
+			}
+		}
+		declarations = append(declarations, declaration)
+	}
+	if len(declarations) > 0 {
+		gemini["tools"] = []map[string]any{{"functionDeclarations": declarations}}
+		choice := request.Get("tool_choice")
+		switch choice.Get("type").String() {
+		case "any":
+			gemini["toolConfig"] = map[string]any{"functionCallingConfig": map[string]any{"mode": "ANY"}}
+		case "none":
+			gemini["toolConfig"] = map[string]any{"functionCallingConfig": map[string]any{"mode": "NONE"}}
+		case "tool":
+			gemini["toolConfig"] = map[string]any{"functionCallingConfig": map[string]any{
+				"mode":                 "ANY",
+				"allowedFunctionNames": []string{choice.Get("name").String()},
+			}}
+		}
+	}
+
+	safety := request.Get("safetySettings")
+	if !safety.Exists() {
+		safety = request.Get("safety_settings")
+	}
+	if safety.IsArray() {
+		gemini["safetySettings"] = json.RawMessage(safety.Raw)
+	} else if len(safetySettings) > 0 {
+		gemini["safetySettings"] = safetySettings
+	}
+	return json.Marshal(gemini)
+}
+
+// geminiParts 将 Anthropic 消息内容转换为 Gemini 的 parts，thinking 等没有对应概念的块会被丢弃
+func geminiParts(content gjson.Result, toolNames map[string]string) []map[string]any {
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil
+		}
+		return []map[string]any{{"text": content.String()}}
+	}
+	parts := make([]map[string]any, 0)
+	for _, block := range content.Array() {
+		switch block.Get("type").String() {
+		case "text":
+			if text := block.Get("text").String(); text != "" {
+				parts = append(parts, map[string]any{"text": text})
+			}
+		case "image":
+			source := block.Get("source")
+			switch source.Get("type").String() {
+			case "base64":
+				parts = append(parts, map[string]any{"inlineData": map[string]any{
+					"mimeType": source.Get("media_type").String(),
+					"data":     source.Get("data").String(),
+				}})
+			case "url":
+				parts = append(parts, map[string]any{"fileData": map[string]any{"fileUri": source.Get("url").String()}})
+			}
+		case "tool_use":
+			name := block.Get("name").String()
+			toolNames[block.Get("id").String()] = name
+			args := json.RawMessage("{}")
+			if input := block.Get("input"); input.IsObject() {
+				args = json.RawMessage(input.Raw)
+			}
+			parts = append(parts, map[string]any{"functionCall": map[string]any{"name": name, "args": args}})
+		case "tool_result":
+			key := "output"
+			if block.Get("is_error").Bool() {
+				key = "error"
+			}
+			parts = append(parts, map[string]any{"functionResponse": map[string]any{
+				"name":     toolNames[block.Get("tool_use_id").String()],
+				"response": map[string]any{key: anthropicText(block.Get("content"))},
+			}})
+		}
+	}
+	return parts
+}
+
+// geminiSchemaFields 是 Gemini 函数声明的参数 schema（OpenAPI 子集）支持的字段，其余字段（如 $schema、additionalProperties）会导致请求被拒绝
+var geminiSchemaFields = map[string]bool{
+	"type": true, "format": true, "title": true, "description": true, "nullable": true, "enum": true,
+	"items": true, "minItems": true, "maxItems": true, "properties": true, "required": true,
+	"minProperties": true, "maxProperties": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "anyOf": true, "default": true, "example": true, "propertyOrdering": true,
+}
+
+// geminiSchema 将工具的 JSON Schema 裁剪为 Gemini 支持的子集，"type": ["string", "null"] 转换为 nullable
+func geminiSchema(value any) any {
+	schema, ok := value.(map[string]any)
+	if !ok {
+		return value
+	}
+	result := make(map[string]any, len(schema))
+	for key, field := range schema {
+		if !geminiSchemaFields[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			if types, ok := field.([]any); ok {
+				for _, item := range types {
+					if item == "null" {
+						result["nullable"] = true
+					} else if _, set := result["type"]; !set {
+						result["type"] = item
+					}
+				}
+				continue
+			}
+			result[key] = field
+		case "properties":
+			properties := make(map[string]any)
+			if fields, ok := field.(map[string]any); ok {
+				for name, property := range fields {
+					properties[name] = geminiSchema(property)
+				}
+			}
+			result[key] = properties
+		case "items":
+			result[key] = geminiSchema(field)
+		case "anyOf":
+			if options, ok := field.([]any); ok {
+				converted := make([]any, len(options))
+				for i, option := range options {
+					converted[i] = geminiSchema(option)
+				}
+				result[key] = converted
+			}
+		default:
+			result[key] = field
+		}
+	}
+	if _, ok := result["properties"]; !ok && result["type"] == "object" {
+		result["properties"] = map[string]any{}
+	}
+	return result
+}
+
+// geminiStopReason 转换 Gemini 的 finishReason，安全拦截类原因视为拒绝
+func geminiStopReason(finishReason string) string {
+	switch finishReason {
+	case "MAX_TOKENS":
+		return "max_tokens"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+// recordGemini 记录 Gemini 的 usageMetadata：promptTokenCount 包含缓存命中的部分，思考 token 按输出计费
+func (u *upstreamUsage) recordGemini(metadata gjson.Result) {
+	cached := int(metadata.Get("cachedContentTokenCount").Int())
+	thoughts := int(metadata.Get("thoughtsTokenCount").Int())
+	u.reported = true
+	u.inputTokens = int(metadata.Get("promptTokenCount").Int()+metadata.Get("toolUsePromptTokenCount").Int()) - cached
+	u.cacheReadTokens = cached
+	u.outputTokens = int(metadata.Get("candidatesTokenCount").Int()) + thoughts
+	u.reasoningTokens = thoughts
+}
+
+// anthropic 返回 Anthropic 格式的用量
+func (u *upstreamUsage) anthropic() map[string]any {
+	result := map[string]any{"input_tokens": u.inputTokens, "output_tokens": u.outputTokens}
+	if u.cacheReadTokens > 0 {
+		result["cache_read_input_tokens"] = u.cacheReadTokens
+	}
+	return result
+}
+
+// geminiToolUseID 使用 Gemini 返回的调用 id，没有时生成 Anthropic 风格的 id
+func geminiToolUseID(id string) string {
+	if id == "" {
+		return "toolu_" + newRequestID()
+	}
+	return id
+}
+
+// geminiMessageID 使用 responseId 生成 Anthropic 风格的消息 id
+func geminiMessageID(id string) string {
+	if id == "" {
+		return "msg_" + newRequestID()
+	}
+	return "msg_" + id
+}
+
+// geminiToAnthropicResponse 转换非流式的 generateContent 响应，无法解析时原样返回
+func geminiToAnthropicResponse(data []byte, model string, usage *upstreamUsage) []byte {
+	if !gjson.ValidBytes(data) {
+		return data
+	}
+	response := gjson.ParseBytes(data)
+	if metadata := response.Get("usageMetadata"); metadata.IsObject() {
+		usage.recordGemini(metadata)
+	}
+	candidate := response.Get("candidates.0")
+	content := make([]map[string]any, 0)
+	text := ""
+	hasToolUse := false
+	for _, part := range candidate.Get("content.parts").Array() {
+		if part.Get("thought").Bool() {
+			continue
+		}
+		if part.Get("text").Exists() {
+			text += part.Get("text").String()
+			continue
+		}
+		if call := part.Get("functionCall"); call.Exists() {
+			if text != "" {
+				content = append(content, map[string]any{"type": "text", "text": text})
+				text = ""
+			}
+			hasToolUse = true
+			content = append(content, map[string]any{
+				"type":  "tool_use",
+				"id":    geminiToolUseID(call.Get("id").String()),
+				"name":  call.Get("name").String(),
+				"input": toolInput(call.Get("args").Raw),
+			})
+		}
+	}
+	if text != "" {
+		content = append(content, map[string]any{"type": "text", "text": text})
+	}
+
+	stopReason := geminiStopReason(candidate.Get("finishReason").String())
+	switch {
+	case hasToolUse:
+		stopReason = "tool_use"
+	case response.Get("promptFeedback.blockReason").String() != "":
+		// 提示词被拦截时没有候选结果
+		stopReason = "refusal"
+	}
+	if version := response.Get("modelVersion").String(); version != "" {
+		model = version
+	}
+	translated, err := json.Marshal(map[string]any{
+		"id":            geminiMessageID(response.Get("responseId").String()),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         usage.anthropic(),
+	})
+	if err != nil {
+		return data
+	}
+	return translated
+}
+
+// geminiStreamTranslator 将 streamGenerateContent 的 SSE 事件转换为 Anthropic 的流式事件。
+// 每个 Gemini 事件携带增量文本或完整的函数调用，以及截至当前的累计用量，带有 finishReason 的事件为最后一个
+type geminiStreamTranslator struct {
+	model   string
+	started bool
+	done    bool
+	anthropicBlocks
+	hasToolUse bool
+	reported   *upstreamUsage
+}
+
+// hook 处理一行 SSE，返回转换后的事件（可能为多个），没有对应事件的行返回空内容
+func (t *geminiStreamTranslator) hook(line []byte) (bool, []byte) {
+	payload := strings.TrimSpace(string(line))
+	if !strings.HasPrefix(payload, "data:") || t.done {
+		return true, nil
+	}
+	payload = strings.TrimSpace(strings.TrimPrefix(payload, "data:"))
+	if !gjson.Valid(payload) {
+		return true, nil
+	}
+	events := t.chunk(gjson.Parse(payload))
+	if len(events) == 0 {
+		return true, nil
+	}
+	// xrequest 会在末尾补上换行，上游事件之后的空行结束最后一个事件
+	return true, []byte(strings.Join(events, "\n\n"))
+}
+
+func (t *geminiStreamTranslator) chunk(chunk gjson.Result) []string {
+	if metadata := chunk.Get("usageMetadata"); metadata.IsObject() {
+		t.reported.recordGemini(metadata)
+	}
+	var events []string
+	if !t.started {
+		t.started = true
+		if version := chunk.Get("modelVersion").String(); version != "" {
+			t.model = version
+		}
+		events = append(events, sseEvent("message_start", map[string]any{"message": map[string]any{
+			"id":            geminiMessageID(chunk.Get("responseId").String()),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         t.model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]any{"input_tokens": t.reported.inputTokens, "output_tokens": 0},
+		}}))
+	}
+
+	candidate := chunk.Get("candidates.0")
+	for _, part := range candidate.Get("content.parts").Array() {
+		if part.Get("thought").Bool() {
+			continue
+		}
+		if text := part.Get("text").String(); text != "" {
+			if t.openBlock != "text" {
+				events = append(events, t.startBlock("text", map[string]any{"type": "text", "text": ""})...)
+			}
+			events = append(events, sseEvent("content_block_delta", map[string]any{
+				"index": t.nextIndex - 1,
+				"delta": map[string]any{"type": "text_delta", "text": text},
+			}))
+			continue
+		}
+		// 函数调用总是完整出现在一个事件中
+		if call := part.Get("functionCall"); call.Exists() {
+			t.hasToolUse = true
+			events = append(events, t.startBlock("tool_use", map[string]any{
+				"type":  "tool_use",
+				"id":    geminiToolUseID(call.Get("id").String()),
+				"name":  call.Get("name").String(),
+				"input": map[string]any{},
+			})...)
+			events = append(events, sseEvent("content_block_delta", map[string]any{
+				"index": t.nextIndex - 1,
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": string(toolInput(call.Get("args").Raw))},
+			}))
+		}
+	}
+
+	finishReason := candidate.Get("finishReason").String()
+	blockReason := chunk.Get("promptFeedback.blockReason").String()
+	if finishReason == "" && blockReason == "" {
+		return events
+	}
+	stopReason := geminiStopReason(finishReason)
+	switch {
+	case t.hasToolUse:
+		stopReason = "tool_use"
+	case blockReason != "":
+		stopReason = "refusal"
+	}
+	t.done = true
+	events = append(events, t.closeBlock()...)
+	return append(events,
+		sseEvent("message_delta", map[string]any{
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": t.reported.anthropic(),
+		}),
+		sseEvent("message_stop", map[string]any{}),
+	)
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestAnthropicToGeminiRequest(t *testing.T) {
+	body := []byte(`{
+		"model": "gemini-2.5-pro",
+		"system": [{"type": "text", "text": "You are a coding assistant."}],
+		"max_tokens": 1024,
+		"temperature": 0.2,
+		"stop_sequences": ["END"],
+		"thinking": {"type": "enabled", "budget_tokens": 2048},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "Read main.go"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "...", "signature": "sig"},
+				{"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": {"path": "main.go"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "package main"}]}
+			]}
+		],
+		"tools": [
+			{"name": "read_file", "description": "Read a file", "input_schema": {
+				"$schema": "http://json-schema.org/draft-07/schema#", "type": "object", "additionalProperties": false,
+				"properties": {"path": {"type": "string"}, "limit": {"type": ["integer", "null"]}}, "required": ["path"]
+			}},
+			{"name": "list_todos", "input_schema": {"type": "object", "properties": {}}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"tool_choice": {"type": "tool", "name": "read_file"}
+	}`)
+	data, err := anthropicToGeminiRequest(body, []GeminiSafetySetting{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"}})
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	request := gjson.ParseBytes(data)
+	if request.Get("model").Exists() || request.Get("systemInstruction.parts.0.text").String() != "You are a coding assistant." {
+		t.Fatalf("system 应转换为 systemInstruction，模型在路径中: %s", data)
+	}
+	config := request.Get("generationConfig")
+	if config.Get("maxOutputTokens").Int() != 1024 || config.Get("temperature").Float() != 0.2 ||
+		config.Get("stopSequences.0").String() != "END" || config.Get("thinkingConfig.thinkingBudget").Int() != 2048 {
+		t.Fatalf("generationConfig 错误: %s", config.Raw)
+	}
+
+	contents := request.Get("contents").Array()
+	if len(contents) != 3 || contents[1].Get("role").String() != "model" {
+		t.Fatalf("应转换为三条 contents: %s", data)
+	}
+	if contents[0].Get("parts.1.inlineData.mimeType").String() != "image/png" || contents[0].Get("parts.1.inlineData.data").String() != "aGVsbG8=" {
+		t.Fatalf("图片应转换为 inlineData: %s", contents[0].Raw)
+	}
+	if parts := contents[1].Get("parts").Array(); len(parts) != 1 || parts[0].Get("functionCall.name").String() != "read_file" ||
+		parts[0].Get("functionCall.args.path").String() != "main.go" {
+		t.Fatalf("thinking 应丢弃，tool_use 应转换为 functionCall: %s", contents[1].Raw)
+	}
+	if response := contents[2].Get("parts.0.functionResponse"); response.Get("name").String() != "read_file" ||
+		response.Get("response.output").String() != "package main" || contents[2].Get("role").String() != "user" {
+		t.Fatalf("tool_result 应转换为带函数名的 functionResponse: %s", contents[2].Raw)
+	}
+
+	declarations := request.Get("tools.0.functionDeclarations").Array()
+	if len(declarations) != 2 {
+		t.Fatalf("服务端工具应跳过: %s", request.Get("tools").Raw)
+	}
+	parameters := declarations[0].Get("parameters")
+	if parameters.Get("$schema").Exists() || parameters.Get("additionalProperties").Exists() ||
+		parameters.Get("required.0").String() != "path" {
+		t.Fatalf("参数 schema 应裁剪为 Gemini 支持的字段: %s", parameters.Raw)
+	}
+	if limit := parameters.Get("properties.limit"); limit.Get("type").String() != "integer" || !limit.Get("nullable").Bool() {
+		t.Fatalf("可空类型应转换为 nullable: %s", limit.Raw)
+	}
+	if declarations[1].Get("parameters").Exists() {
+		t.Fatalf("没有参数的函数不应声明 parameters: %s", declarations[1].Raw)
+	}
+	if config := request.Get("toolConfig.functionCallingConfig"); config.Get("mode").String() != "ANY" ||
+		config.Get("allowedFunctionNames.0").String() != "read_file" {
+		t.Fatalf("tool_choice 转换错误: %s", config.Raw)
+	}
+	if request.Get("safetySettings.0.category").String() != "HARM_CATEGORY_HARASSMENT" {
+		t.Fatalf("应使用 provider 配置的安全设置: %s", request.Get("safetySettings").Raw)
+	}
+}
+
+func TestAnthropicToGeminiRequestSafetyPassthrough(t *testing.T) {
+	body := []byte(`{"max_tokens":10,"messages":[{"role":"user","content":"hi"}],
+		"safety_settings":[{"category":"HARM_CATEGORY_DANGEROUS_CONTENT","threshold":"BLOCK_ONLY_HIGH"}]}`)
+	data, err := anthropicToGeminiRequest(body, []GeminiSafetySetting{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"}})
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	settings := gjson.GetBytes(data, "safetySettings").Array()
+	if len(settings) != 1 || settings[0].Get("threshold").String() != "BLOCK_ONLY_HIGH" {
+		t.Fatalf("请求自带的安全设置应优先透传: %s", data)
+	}
+	if gjson.GetBytes(data, "contents.0.parts.0.text").String() != "hi" {
+		t.Fatalf("字符串内容应转换为 text part: %s", data)
+	}
+}
+
+func TestGeminiToAnthropicResponse(t *testing.T) {
+	usage := &upstreamUsage{}
+	data := geminiToAnthropicResponse([]byte(`{
+		"candidates": [{"content": {"role": "model", "parts": [
+			{"text": "thinking...", "thought": true},
+			{"text": "Let me "},
+			{"text": "check."},
+			{"functionCall": {"name": "read_file", "args": {"path": "main.go"}}}
+		]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 120, "cachedContentTokenCount": 100, "candidatesTokenCount": 30, "thoughtsTokenCount": 50},
+		"modelVersion": "gemini-2.5-pro",
+		"responseId": "abc123"
+	}`), "gemini-2.5-pro", usage)
+	response := gjson.ParseBytes(data)
+	if response.Get("id").String() != "msg_abc123" || response.Get("stop_reason").String() != "tool_use" {
+		t.Fatalf("响应转换错误: %s", data)
+	}
+	content := response.Get("content").Array()
+	if len(content) != 2 || content[0].Get("text").String() != "Let me check." || content[1].Get("type").String() != "tool_use" ||
+		content[1].Get("input.path").String() != "main.go" || !strings.HasPrefix(content[1].Get("id").String(), "toolu_") {
+		t.Fatalf("思考内容应丢弃，文本合并，函数调用转换为 tool_use: %s", response.Get("content").Raw)
+	}
+	if u := response.Get("usage"); u.Get("input_tokens").Int() != 20 || u.Get("output_tokens").Int() != 80 ||
+		u.Get("cache_read_input_tokens").Int() != 100 {
+		t.Fatalf("用量转换错误: %s", u.Raw)
+	}
+	if usage.inputTokens != 20 || usage.cacheReadTokens != 100 || usage.outputTokens != 80 || usage.reasoningTokens != 50 {
+		t.Fatalf("应记录 usageMetadata: %+v", usage)
+	}
+
+	blocked := gjson.ParseBytes(geminiToAnthropicResponse([]byte(`{"promptFeedback":{"blockReason":"SAFETY"},
+		"usageMetadata":{"promptTokenCount":8}}`), "gemini-2.5-pro", &upstreamUsage{}))
+	if blocked.Get("stop_reason").String() != "refusal" || len(blocked.Get("content").Array()) != 0 {
+		t.Fatalf("提示词被拦截应转换为 refusal: %s", blocked.Raw)
+	}
+}
+
+const geminiStream = `data: {"candidates": [{"content": {"parts": [{"text": "Hel"}],"role": "model"}}],"usageMetadata": {"promptTokenCount": 40,"candidatesTokenCount": 1},"modelVersion": "gemini-2.5-flash","responseId": "r1"}
+
+data: {"candidates": [{"content": {"parts": [{"text": "lo"}],"role": "model"}}],"usageMetadata": {"promptTokenCount": 40,"candidatesTokenCount": 2},"modelVersion": "gemini-2.5-flash","responseId": "r1"}
+
+data: {"candidates": [{"content": {"parts": [{"functionCall": {"name": "read_file","args": {"path": "main.go"}}}],"role": "model"},"finishReason": "STOP"}],"usageMetadata": {"promptTokenCount": 40,"cachedContentTokenCount": 10,"candidatesTokenCount": 12,"thoughtsTokenCount": 5},"modelVersion": "gemini-2.5-flash","responseId": "r1"}
+
+`
+
+func TestGeminiStreamTranslation(t *testing.T) {
+	usage := &upstreamUsage{}
+	hook := geminiDialect{}.responseHook("gemini-2.5-flash", true, usage)
+	var output strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(geminiStream, "\n"), "\n") {
+		// 与 xrequest 相同：空行原样写出，其余行经过钩子后补上换行
+		if line == "" {
+			output.WriteString("\n")
+			continue
+		}
+		if flush, data := hook([]byte(line)); flush {
+			output.Write(data)
+			output.WriteString("\n")
+		}
+	}
+
+	events := streamEvents(t, output.String())
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Get("type").String())
+	}
+	expected := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if got := strings.Join(types, ","); got != expected {
+		t.Fatalf("事件顺序错误:\n%s\n期望\n%s", got, expected)
+	}
+	if events[0].Get("message.id").String() != "msg_r1" || events[0].Get("message.usage.input_tokens").Int() != 40 {
+		t.Fatalf("message_start 错误: %s", events[0].Raw)
+	}
+	if events[2].Get("delta.text").String()+events[3].Get("delta.text").String() != "Hello" {
+		t.Fatalf("文本事件错误: %s %s", events[2].Raw, events[3].Raw)
+	}
+	if block := events[5].Get("content_block"); block.Get("name").String() != "read_file" || events[5].Get("index").Int() != 1 ||
+		events[6].Get("delta.partial_json").String() != `{"path": "main.go"}` {
+		t.Fatalf("函数调用应作为第二个内容块: %s %s", events[5].Raw, events[6].Raw)
+	}
+	if delta := events[8]; delta.Get("delta.stop_reason").String() != "tool_use" || delta.Get("usage.input_tokens").Int() != 30 ||
+		delta.Get("usage.output_tokens").Int() != 17 || delta.Get("usage.cache_read_input_tokens").Int() != 10 {
+		t.Fatalf("message_delta 错误: %s", delta.Raw)
+	}
+	if usage.reasoningTokens != 5 {
+		t.Fatalf("应记录思考 token: %+v", usage)
+	}
+}
+
+func TestGeminiStreamSafetyStop(t *testing.T) {
+	hook := geminiDialect{}.responseHook("gemini-2.5-flash", true, &upstreamUsage{})
+	_, data := hook([]byte(`data: {"candidates":[{"content":{"parts":[{"text":"I"}]},"finishReason":"SAFETY"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":1}}`))
+	events := streamEvents(t, string(data)+"\n")
+	if len(events) != 6 || events[4].Get("delta.stop_reason").String() != "refusal" {
+		t.Fatalf("安全拦截应转换为 refusal: %s", data)
+	}
+}
+
+func TestGeminiDefaultAuth(t *testing.T) {
+	auth, err := Provider{APIFormat: APIFormatGemini}.AuthStrategy()
+	if err != nil {
+		t.Fatalf("获取认证方式失败: %v", err)
+	}
+	if header, ok := auth.(headerAuth); !ok || header.header != "x-goog-api-key" {
+		t.Fatalf("gemini 协议默认应使用 x-goog-api-key: %#v", auth)
+	}
+	if errs := (&Provider{Name: "gemini", APIURL: "https://generativelanguage.googleapis.com/v1beta", APIKey: "k", APIFormat: APIFormatGemini,
+		GeminiSafetySettings: []GeminiSafetySetting{{Category: "HARM_CATEGORY_HARASSMENT"}}}).ValidateConfiguration(); len(errs) == 0 {
+		t.Fatalf("缺少 threshold 的安全设置应报错")
+	}
+}
+
+func TestRelayTranslatesToGemini(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwardedPath, forwardedQuery, forwardedKey, forwardedAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwardedPath, forwardedQuery = r.URL.Path, r.URL.RawQuery
+		forwardedKey, forwardedAuth = r.Header.Get("x-goog-api-key"), r.Header.Get("Authorization")
+		if !gjson.GetBytes(body, "contents.0.parts.0.text").Exists() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, geminiStream)
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "gemini", APIURL: upstream.URL + "/v1beta", APIKey: "AIza-test-1234567890", Enabled: true,
+		APIFormat: APIFormatGemini, ModelRewrites: []ModelRewrite{{Match: "claude-*", Target: "gemini-2.5-flash"}},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages?beta=true",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Authorization", "Bearer client-token")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedPath != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || forwardedQuery != "alt=sse" {
+		t.Fatalf("应转发到 streamGenerateContent 接口且不带客户端参数: %s?%s", forwardedPath, forwardedQuery)
+	}
+	if forwardedKey != "AIza-test-1234567890" || forwardedAuth != "" {
+		t.Fatalf("应使用 x-goog-api-key 认证: key=%q auth=%q", forwardedKey, forwardedAuth)
+	}
+	if events := streamEvents(t, rec.Body.String()); len(events) != 10 || events[len(events)-1].Get("type").String() != "message_stop" {
+		t.Fatalf("响应应转换为 Anthropic 事件: %s", rec.Body.String())
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "gemini"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); entry.InputTokens != 30 || entry.CacheReadTokens != 10 || entry.OutputTokens != 17 ||
+		entry.ReasoningTokens != 5 || entry.Model != "gemini-2.5-flash" {
+		t.Fatalf("Gemini 报告的用量应计入日志: %+v", entry)
+	}
+}
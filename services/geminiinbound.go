@@ -0,0 +1,596 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// Gemini 协议的入口，Gemini CLI 通过 GOOGLE_GEMINI_BASE_URL 指向 relay 后请求 /v1beta/models/<model>:<method>
+	geminiInboundPath = "/v1beta/models/:action"
+	// Gemini 请求通常不指定 maxOutputTokens，Anthropic Messages 要求 max_tokens
+	geminiDefaultMaxTokens = 8192
+)
+
+// geminiHandler 处理 Gemini 协议（generateContent / streamGenerateContent / countTokens）的请求：
+// 请求转换为 Anthropic Messages 后按 claude 平台路由，响应转换回 Gemini 格式，所以 Gemini CLI 可以使用 claude 平台的所有 provider
+func (prs *ProviderRelayService) geminiHandler() gin.HandlerFunc {
+	proxy := prs.proxyHandler("claude", "/v1/messages")
+	return func(c *gin.Context) {
+		model, method, _ := strings.Cut(c.Param("action"), ":")
+		switch method {
+		case "generateContent", "streamGenerateContent", "countTokens":
+		default:
+			abortGemini(c, http.StatusNotFound, fmt.Sprintf("不支持的 Gemini 接口: %s", c.Param("action")))
+			return
+		}
+		raw, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortGemini(c, http.StatusBadRequest, "读取请求体失败")
+			return
+		}
+		stream := method == "streamGenerateContent"
+		if method == "countTokens" {
+			// countTokens 的请求体为 contents，或包装在 generateContentRequest 中的完整请求
+			if wrapped := gjson.GetBytes(raw, "generateContentRequest"); wrapped.IsObject() {
+				raw = []byte(wrapped.Raw)
+			}
+		}
+		body, err := geminiToAnthropicRequest(raw, model, stream)
+		if err != nil {
+			abortGemini(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if method == "countTokens" {
+			c.JSON(http.StatusOK, gin.H{"totalTokens": estimateRequest("claude", model, body).PromptTokens})
+			return
+		}
+
+		// Gemini 客户端的 Key 在 x-goog-api-key 或 key 参数中，转换为 relay 识别的客户端 Key，不转发给上游
+		header := c.Request.Header
+		key := header.Get("X-Goog-Api-Key")
+		if key == "" {
+			key = c.Query("key")
+		}
+		header.Del("X-Goog-Api-Key")
+		if key != "" && clientAPIKey(header) == "" {
+			header.Set("X-Api-Key", key)
+		}
+		header.Set("Content-Type", "application/json")
+		if header.Get("Anthropic-Version") == "" {
+			header.Set("Anthropic-Version", "2023-06-01")
+		}
+		c.Request.URL.RawQuery = ""
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		header.Del("Content-Length")
+
+		writer := &geminiResponseWriter{ResponseWriter: c.Writer, model: model, stream: stream}
+		c.Writer = writer
+		proxy(c)
+		writer.finish()
+	}
+}
+
+// geminiToAnthropicRequest 将 Gemini generateContent 请求体转换为 Anthropic Messages 请求体：
+// 支持 systemInstruction、文本、内联图片与 PDF、functionCall / functionResponse、函数声明与思考预算，思考内容（thought）不发送
+func geminiToAnthropicRequest(body []byte, model string, stream bool) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("请求体不是有效的 JSON")
+	}
+	request := gjson.ParseBytes(body)
+	anthropic := map[string]any{"model": model}
+	if stream {
+		anthropic["stream"] = true
+	}
+	texts := make([]string, 0)
+	for _, part := range request.Get("systemInstruction.parts").Array() {
+		if text := part.Get("text").String(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	if len(texts) > 0 {
+		anthropic["system"] = strings.Join(texts, "\n")
+	}
+
+	// Gemini 的函数调用不一定有 id，functionResponse 按函数名依次对应之前的调用
+	pending := make(map[string][]string)
+	messages := make([]map[string]any, 0)
+	for _, content := range request.Get("contents").Array() {
+		role := "user"
+		if content.Get("role").String() == "model" {
+			role = "assistant"
+		}
+		blocks := geminiContentBlocks(content.Get("parts"), pending)
+		if len(blocks) == 0 {
+			continue
+		}
+		// 相邻的同角色消息合并，函数结果与用户输入分开发送时仍然交替
+		if last := len(messages) - 1; last >= 0 && messages[last]["role"] == role {
+			messages[last]["content"] = append(messages[last]["content"].([]map[string]any), blocks...)
+			continue
+		}
+		messages = append(messages, map[string]any{"role": role, "content": blocks})
+	}
+	anthropic["messages"] = messages
+
+	config := request.Get("generationConfig")
+	maxTokens := int64(geminiDefaultMaxTokens)
+	if value := config.Get("maxOutputTokens").Int(); value > 0 {
+		maxTokens = value
+	}
+	// Gemini CLI 同时发送 temperature 与 topP，较新的 Claude 模型不允许同时指定，只保留 temperature
+	if temperature := config.Get("temperature"); temperature.Exists() {
+		anthropic["temperature"] = temperature.Float()
+	} else if topP := config.Get("topP"); topP.Exists() {
+		anthropic["top_p"] = topP.Float()
+	}
+	if topK := config.Get("topK"); topK.Exists() {
+		anthropic["top_k"] = topK.Int()
+	}
+	if stop := config.Get("stopSequences"); stop.IsArray() && len(stop.Array()) > 0 {
+		sequences := make([]string, 0)
+		for _, sequence := range stop.Array() {
+			sequences = append(sequences, sequence.String())
+		}
+		anthropic["stop_sequences"] = sequences
+	}
+	// Anthropic 的思考预算至少 1024 且小于 max_tokens，-1（动态预算）与 0（关闭）不开启思考；
+	// 开启思考时不能指定 temperature、top_k 与小于 0.95 的 top_p
+	if budget := config.Get("thinkingConfig.thinkingBudget").Int(); budget >= 1024 {
+		if maxTokens <= budget {
+			maxTokens = budget + geminiDefaultMaxTokens
+		}
+		anthropic["thinking"] = map[string]any{"type": "enabled", "budget_tokens": budget}
+		delete(anthropic, "temperature")
+		delete(anthropic, "top_k")
+		if topP, ok := anthropic["top_p"].(float64); ok && topP < 0.95 {
+			delete(anthropic, "top_p")
+		}
+	}
+	anthropic["max_tokens"] = maxTokens
+
+	tools := make([]map[string]any, 0)
+	for _, tool := range request.Get("tools").Array() {
+		for _, declaration := range tool.Get("functionDeclarations").Array() {
+			schema := map[string]any{"type": "object", "properties": map[string]any{}}
+			if parameters := declaration.Get("parametersJsonSchema"); parameters.IsObject() {
+				schema = parameters.Value().(map[string]any)
+			} else if parameters := declaration.Get("parameters"); parameters.IsObject() {
+				schema = anthropicSchema(parameters.Value()).(map[string]any)
+			}
+			converted := map[string]any{"name": declaration.Get("name").String(), "input_schema": schema}
+			if description := declaration.Get("description").String(); description != "" {
+				converted["description"] = description
+			}
+			tools = append(tools, converted)
+		}
+	}
+	if len(tools) > 0 {
+		anthropic["tools"] = tools
+		calling := request.Get("toolConfig.functionCallingConfig")
+		switch strings.ToUpper(calling.Get("mode").String()) {
+		case "ANY":
+			if names := calling.Get("allowedFunctionNames").Array(); len(names) == 1 {
+				anthropic["tool_choice"] = map[string]any{"type": "tool", "name": names[0].String()}
+			} else {
+				anthropic["tool_choice"] = map[string]any{"type": "any"}
+			}
+		case "NONE":
+			anthropic["tool_choice"] = map[string]any{"type": "none"}
+		}
+	}
+	return json.Marshal(anthropic)
+}
+
+// geminiContentBlocks 将 Gemini 的 parts 转换为 Anthropic 的内容块，pending 记录尚未返回结果的函数调用 id
+func geminiContentBlocks(parts gjson.Result, pending map[string][]string) []map[string]any {
+	blocks := make([]map[string]any, 0)
+	for _, part := range parts.Array() {
+		if part.Get("thought").Bool() {
+			continue
+		}
+		if text := part.Get("text"); text.Exists() {
+			if text.String() != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": text.String()})
+			}
+			continue
+		}
+		if data := part.Get("inlineData"); data.Exists() {
+			mimeType := data.Get("mimeType").String()
+			blockType := "image"
+			if mimeType == "application/pdf" {
+				blockType = "document"
+			} else if !strings.HasPrefix(mimeType, "image/") {
+				continue
+			}
+			blocks = append(blocks, map[string]any{"type": blockType, "source": map[string]any{
+				"type": "base64", "media_type": mimeType, "data": data.Get("data").String(),
+			}})
+			continue
+		}
+		if call := part.Get("functionCall"); call.Exists() {
+			name := call.Get("name").String()
+			id := anthropicToolID(call.Get("id").String())
+			pending[name] = append(pending[name], id)
+			blocks = append(blocks, map[string]any{
+				"type":  "tool_use",
+				"id":    id,
+				"name":  name,
+				"input": toolInput(call.Get("args").Raw),
+			})
+			continue
+		}
+		if response := part.Get("functionResponse"); response.Exists() {
+			name := response.Get("name").String()
+			var id string
+			if queue := pending[name]; len(queue) > 0 {
+				id, pending[name] = queue[0], queue[1:]
+			} else {
+				id = anthropicToolID(response.Get("id").String())
+			}
+			result := map[string]any{"type": "tool_result", "tool_use_id": id}
+			// Gemini CLI 的结果为 {"output": ...} 或 {"error": ...}
+			output := response.Get("response")
+			switch {
+			case output.Get("error").Exists():
+				result["is_error"] = true
+				result["content"] = geminiResultText(output.Get("error"))
+			case output.Get("output").Exists():
+				result["content"] = geminiResultText(output.Get("output"))
+			default:
+				result["content"] = geminiResultText(output)
+			}
+			blocks = append(blocks, result)
+		}
+	}
+	return blocks
+}
+
+func geminiResultText(value gjson.Result) string {
+	if value.Type == gjson.String {
+		return value.String()
+	}
+	return value.Raw
+}
+
+// anthropicToolID 返回符合 Anthropic 要求（字母、数字、_ 与 -）的 tool_use id，没有时生成
+func anthropicToolID(id string) string {
+	id = strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, id)
+	return geminiToolUseID(id)
+}
+
+// anthropicSchema 将 Gemini 的 Schema（type 为 OBJECT、STRING 等大写值，nullable 表示可为空）转换为 JSON Schema
+func anthropicSchema(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			switch key {
+			case "type":
+				if name, ok := item.(string); ok {
+					item = strings.ToLower(name)
+				}
+				result[key] = item
+			case "nullable":
+			default:
+				result[key] = anthropicSchema(item)
+			}
+		}
+		if nullable, _ := v["nullable"].(bool); nullable {
+			if name, ok := result["type"].(string); ok {
+				result["type"] = []any{name, "null"}
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = anthropicSchema(item)
+		}
+		return result
+	}
+	return value
+}
+
+// geminiFinishReason 转换 Anthropic 的 stop_reason
+func geminiFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "MAX_TOKENS"
+	case "refusal":
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+// geminiUsageMetadata 返回 Gemini 格式的用量，promptTokenCount 包含缓存的部分
+func geminiUsageMetadata(usage *upstreamUsage) map[string]any {
+	prompt := usage.inputTokens + usage.cacheReadTokens + usage.cacheCreateTokens
+	metadata := map[string]any{
+		"promptTokenCount":     prompt,
+		"candidatesTokenCount": usage.outputTokens,
+		"totalTokenCount":      prompt + usage.outputTokens,
+	}
+	if usage.cacheReadTokens > 0 {
+		metadata["cachedContentTokenCount"] = usage.cacheReadTokens
+	}
+	return metadata
+}
+
+func geminiCandidate(parts []map[string]any, finishReason string) map[string]any {
+	candidate := map[string]any{"content": map[string]any{"role": "model", "parts": parts}, "index": 0}
+	if finishReason != "" {
+		candidate["finishReason"] = finishReason
+	}
+	return candidate
+}
+
+// anthropicToGeminiResponse 转换非流式的 Messages 响应，无法解析时原样返回
+func anthropicToGeminiResponse(data []byte, model string) []byte {
+	if !gjson.ValidBytes(data) {
+		return data
+	}
+	response := gjson.ParseBytes(data)
+	parts := make([]map[string]any, 0)
+	for _, block := range response.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			parts = append(parts, map[string]any{"text": block.Get("text").String()})
+		case "tool_use":
+			parts = append(parts, map[string]any{"functionCall": map[string]any{
+				"id":   block.Get("id").String(),
+				"name": block.Get("name").String(),
+				"args": toolInput(block.Get("input").Raw),
+			}})
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, map[string]any{"text": ""})
+	}
+	usage := &upstreamUsage{}
+	usage.recordAnthropic(response.Get("usage"), true)
+	if version := response.Get("model").String(); version != "" {
+		model = version
+	}
+	translated, err := json.Marshal(map[string]any{
+		"candidates":    []map[string]any{geminiCandidate(parts, geminiFinishReason(response.Get("stop_reason").String()))},
+		"usageMetadata": geminiUsageMetadata(usage),
+		"modelVersion":  model,
+		"responseId":    strings.TrimPrefix(response.Get("id").String(), "msg_"),
+	})
+	if err != nil {
+		return data
+	}
+	return translated
+}
+
+// anthropicStreamToGemini 将 Messages 的 SSE 事件转换为 streamGenerateContent 的 SSE 事件：
+// 文本增量逐个转发，tool_use 在块结束时作为完整的 functionCall 发送，message_delta 携带 finishReason 与用量
+type anthropicStreamToGemini struct {
+	model      string
+	responseID string
+	usage      upstreamUsage
+	// 进行中的 tool_use 块，按 index 记录
+	tools map[int64]*geminiPendingCall
+}
+
+type geminiPendingCall struct {
+	id    string
+	name  string
+	input strings.Builder
+}
+
+// line 处理一行 SSE，返回转换后的事件，没有对应事件时返回空
+func (t *anthropicStreamToGemini) line(line string) string {
+	payload := strings.TrimSpace(line)
+	if !strings.HasPrefix(payload, "data:") {
+		return ""
+	}
+	event := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(payload, "data:")))
+	switch event.Get("type").String() {
+	case "message_start":
+		t.responseID = strings.TrimPrefix(event.Get("message.id").String(), "msg_")
+		if model := event.Get("message.model").String(); model != "" {
+			t.model = model
+		}
+		t.usage.recordAnthropic(event.Get("message.usage"), true)
+	case "content_block_start":
+		if block := event.Get("content_block"); block.Get("type").String() == "tool_use" {
+			if t.tools == nil {
+				t.tools = make(map[int64]*geminiPendingCall)
+			}
+			t.tools[event.Get("index").Int()] = &geminiPendingCall{id: block.Get("id").String(), name: block.Get("name").String()}
+		} else if text := block.Get("text").String(); text != "" {
+			return t.chunk([]map[string]any{{"text": text}}, "", false)
+		}
+	case "content_block_delta":
+		delta := event.Get("delta")
+		switch delta.Get("type").String() {
+		case "text_delta":
+			return t.chunk([]map[string]any{{"text": delta.Get("text").String()}}, "", false)
+		case "input_json_delta":
+			if call := t.tools[event.Get("index").Int()]; call != nil {
+				call.input.WriteString(delta.Get("partial_json").String())
+			}
+		}
+	case "content_block_stop":
+		index := event.Get("index").Int()
+		if call := t.tools[index]; call != nil {
+			delete(t.tools, index)
+			return t.chunk([]map[string]any{{"functionCall": map[string]any{
+				"id":   call.id,
+				"name": call.name,
+				"args": toolInput(call.input.String()),
+			}}}, "", false)
+		}
+	case "message_delta":
+		t.usage.recordAnthropic(event.Get("usage"), false)
+		return t.chunk([]map[string]any{{"text": ""}}, geminiFinishReason(event.Get("delta.stop_reason").String()), true)
+	case "error":
+		data, _ := json.Marshal(map[string]any{"error": map[string]any{
+			"code":    http.StatusInternalServerError,
+			"message": event.Get("error.message").String(),
+			"status":  "INTERNAL",
+		}})
+		return "data: " + string(data) + "\n\n"
+	}
+	return ""
+}
+
+func (t *anthropicStreamToGemini) chunk(parts []map[string]any, finishReason string, withUsage bool) string {
+	chunk := map[string]any{
+		"candidates":   []map[string]any{geminiCandidate(parts, finishReason)},
+		"modelVersion": t.model,
+		"responseId":   t.responseID,
+	}
+	if withUsage {
+		chunk["usageMetadata"] = geminiUsageMetadata(&t.usage)
+	}
+	data, _ := json.Marshal(chunk)
+	return "data: " + string(data) + "\n\n"
+}
+
+// geminiStatus 是 HTTP 状态码对应的 Google API 错误状态
+func geminiStatus(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	return "INTERNAL"
+}
+
+// geminiErrorBody 将 relay 或上游返回的错误转换为 Gemini 格式
+func geminiErrorBody(code int, data []byte) []byte {
+	message := strings.TrimSpace(string(data))
+	if parsed := gjson.ParseBytes(data); gjson.ValidBytes(data) {
+		if text := parsed.Get("error.message").String(); text != "" {
+			message = text
+		} else if text := parsed.Get("error").String(); text != "" {
+			message = text
+		}
+	}
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	body, _ := json.Marshal(map[string]any{"error": map[string]any{"code": code, "message": message, "status": geminiStatus(code)}})
+	return body
+}
+
+func abortGemini(c *gin.Context, code int, message string) {
+	c.Data(code, "application/json", geminiErrorBody(code, []byte(message)))
+	c.Abort()
+}
+
+// geminiResponseWriter 将 claude 平台写出的响应转换为 Gemini 格式：成功的流式响应逐行转换，
+// 其余响应（非流式结果与错误）先缓存，请求结束时由 finish 转换后写出
+type geminiResponseWriter struct {
+	gin.ResponseWriter
+	model   string
+	stream  bool
+	written bool
+	pending bytes.Buffer
+	events  *anthropicStreamToGemini
+}
+
+func (w *geminiResponseWriter) streaming() bool {
+	status := w.ResponseWriter.Status()
+	return w.stream && status >= 200 && status < 300
+}
+
+func (w *geminiResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	w.pending.Write(data)
+	if !w.streaming() {
+		return len(data), nil
+	}
+	if w.events == nil {
+		w.events = &anthropicStreamToGemini{model: w.model}
+		w.ResponseWriter.Header().Del("Content-Length")
+		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+	}
+	var out strings.Builder
+	for {
+		line, err := w.pending.ReadString('\n')
+		if err != nil {
+			// 不完整的行留到下一次写入
+			w.pending.Reset()
+			w.pending.WriteString(line)
+			break
+		}
+		out.WriteString(w.events.line(line))
+	}
+	if out.Len() > 0 {
+		if _, err := w.ResponseWriter.WriteString(out.String()); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *geminiResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow 推迟到写出转换后的内容时
+func (w *geminiResponseWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *geminiResponseWriter) Written() bool {
+	return w.written || w.ResponseWriter.Written()
+}
+
+func (w *geminiResponseWriter) Flush() {
+	if w.streaming() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish 写出缓存的响应
+func (w *geminiResponseWriter) finish() {
+	if w.events != nil {
+		if w.pending.Len() > 0 {
+			w.ResponseWriter.WriteString(w.events.line(w.pending.String()))
+		}
+		return
+	}
+	if !w.written {
+		return
+	}
+	status := w.ResponseWriter.Status()
+	data := w.pending.Bytes()
+	if status >= 200 && status < 300 {
+		data = anthropicToGeminiResponse(data, w.model)
+	} else {
+		data = geminiErrorBody(status, data)
+	}
+	header := w.ResponseWriter.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "application/json")
+	w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestGeminiToAnthropicRequest(t *testing.T) {
+	body := `{
+		"systemInstruction": {"parts": [{"text": "be brief"}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "list files"}, {"inlineData": {"mimeType": "image/png", "data": "aGk="}}]},
+			{"role": "model", "parts": [{"text": "thinking", "thought": true}, {"functionCall": {"name": "ls", "args": {"path": "."}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"id": "ls-1700000000-abc", "name": "ls", "response": {"output": "a.go"}}}]},
+			{"role": "user", "parts": [{"text": "thanks"}]}
+		],
+		"tools": [{"functionDeclarations": [{"name": "ls", "description": "list", "parameters": {"type": "OBJECT", "properties": {"path": {"type": "STRING", "nullable": true}}}}]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["ls"]}},
+		"generationConfig": {"temperature": 0, "topP": 1, "maxOutputTokens": 4096, "thinkingConfig": {"thinkingBudget": 2048, "includeThoughts": true}}
+	}`
+	translated, err := geminiToAnthropicRequest([]byte(body), "claude-sonnet-4-5", true)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	request := gjson.ParseBytes(translated)
+	for path, want := range map[string]string{
+		"model":                                     "claude-sonnet-4-5",
+		"stream":                                    "true",
+		"system":                                    "be brief",
+		"max_tokens":                                "4096",
+		"thinking.budget_tokens":                    "2048",
+		"messages.#":                                "3",
+		"messages.0.content.1.source.media_type":    "image/png",
+		"messages.1.role":                           "assistant",
+		"messages.1.content.#":                      "1",
+		"messages.1.content.0.input.path":           ".",
+		"messages.2.content.0.content":              "a.go",
+		"messages.2.content.1.text":                 "thanks",
+		"tools.0.input_schema.type":                 "object",
+		"tools.0.input_schema.properties.path.type": `["string","null"]`,
+		"tool_choice.name":                          "ls",
+	} {
+		if got := request.Get(path).String(); got != want {
+			t.Fatalf("%s = %q，期望 %q: %s", path, got, want, translated)
+		}
+	}
+	if request.Get("messages.2.content.0.tool_use_id").String() != request.Get("messages.1.content.0.id").String() {
+		t.Fatalf("函数结果应对应之前的调用: %s", translated)
+	}
+	// 开启思考时不发送 temperature，且不同时发送 temperature 与 top_p
+	if request.Get("temperature").Exists() || request.Get("top_p").Exists() {
+		t.Fatalf("开启思考时不应发送采样参数: %s", translated)
+	}
+}
+
+func TestAnthropicToGeminiResponse(t *testing.T) {
+	response := `{"id":"msg_01","type":"message","role":"assistant","model":"claude-sonnet-4-5",
+		"content":[{"type":"text","text":"ok"},{"type":"tool_use","id":"toolu_1","name":"ls","input":{"path":"."}}],
+		"stop_reason":"tool_use","usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":3}}`
+	translated := gjson.ParseBytes(anthropicToGeminiResponse([]byte(response), "gemini-2.5-pro"))
+	for path, want := range map[string]string{
+		"responseId":                                          "01",
+		"candidates.0.finishReason":                           "STOP",
+		"candidates.0.content.parts.0.text":                   "ok",
+		"candidates.0.content.parts.1.functionCall.args.path": ".",
+		"usageMetadata.promptTokenCount":                      "15",
+		"usageMetadata.cachedContentTokenCount":               "5",
+		"usageMetadata.totalTokenCount":                       "18",
+	} {
+		if got := translated.Get(path).String(); got != want {
+			t.Fatalf("%s = %q，期望 %q: %s", path, got, want, translated.Raw)
+		}
+	}
+}
+
+const anthropicStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_01","model":"claude-sonnet-4-5","usage":{"input_tokens":12,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"ls","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\".\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestRelayServesGeminiClients(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwardedPath, forwardedAuth, forwardedKey, forwardedGoogKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwardedPath = r.URL.String()
+		forwardedAuth, forwardedKey, forwardedGoogKey = r.Header.Get("Authorization"), r.Header.Get("x-api-key"), r.Header.Get("x-goog-api-key")
+		if gjson.GetBytes(body, "messages.0.content.0.text").String() == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad input"}}`)
+			return
+		}
+		if gjson.GetBytes(body, "stream").Bool() {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, anthropicStream)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_02","type":"message","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":4,"output_tokens":2}}`)
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "anthropic", APIURL: upstream.URL, APIKey: "sk-ant-test-1234567890", Enabled: true,
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	send := func(path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("x-goog-api-key", "code-switch")
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("/v1beta/models/claude-sonnet-4-5:streamGenerateContent?alt=sse", `{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("流式请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedPath != "/v1/messages" || forwardedAuth != "Bearer sk-ant-test-1234567890" || forwardedKey != "" || forwardedGoogKey != "" {
+		t.Fatalf("请求应转发为 Anthropic Messages 且不带客户端 Key: %s %q %q %q", forwardedPath, forwardedAuth, forwardedKey, forwardedGoogKey)
+	}
+	var text, call, finish string
+	var total int64
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		chunk := gjson.Parse(strings.TrimPrefix(line, "data: "))
+		text += chunk.Get("candidates.0.content.parts.0.text").String()
+		if args := chunk.Get("candidates.0.content.parts.0.functionCall.args"); args.Exists() {
+			call = args.Raw
+		}
+		if reason := chunk.Get("candidates.0.finishReason").String(); reason != "" {
+			finish, total = reason, chunk.Get("usageMetadata.totalTokenCount").Int()
+		}
+	}
+	if text != "Hello" || call != `{"path":"."}` || finish != "STOP" || total != 19 {
+		t.Fatalf("流式响应转换错误: text=%q call=%s finish=%q total=%d\n%s", text, call, finish, total, rec.Body.String())
+	}
+
+	rec = send("/v1beta/models/claude-sonnet-4-5:generateContent", `{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`)
+	if rec.Code != http.StatusOK || gjson.Get(rec.Body.String(), "candidates.0.content.parts.0.text").String() != "hi" {
+		t.Fatalf("非流式响应转换错误: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = send("/v1beta/models/claude-sonnet-4-5:generateContent", `{"contents":[{"role":"user","parts":[{"text":"fail"}]}]}`)
+	if rec.Code == http.StatusOK || gjson.Get(rec.Body.String(), "error.status").String() == "" {
+		t.Fatalf("错误应使用 Gemini 格式: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = send("/v1beta/models/claude-sonnet-4-5:countTokens", `{"contents":[{"role":"user","parts":[{"text":"hello world"}]}]}`)
+	if rec.Code != http.StatusOK || gjson.Get(rec.Body.String(), "totalTokens").Int() <= 0 {
+		t.Fatalf("countTokens 应返回估算的 token 数: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("/v1beta/models/claude-sonnet-4-5:embedContent", `{}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("不支持的接口应返回 404: %d", rec.Code)
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	geminiSettingsDir    = ".gemini"
+	geminiEnvFileName    = ".env"
+	geminiBackupFileName = "cc-studio.back.env"
+	geminiAPIKeyValue    = "code-switch"
+)
+
+// geminiManagedEnv 是 Apply 会修改的环境变量，Unapply 时恢复为 Apply 之前的值
+var geminiManagedEnv = []string{
+	"GOOGLE_GEMINI_BASE_URL",
+	"GEMINI_API_KEY",
+	"GEMINI_MODEL",
+	"GEMINI_DEFAULT_AUTH_TYPE",
+	"GOOGLE_GENAI_USE_VERTEXAI",
+	"GOOGLE_API_KEY",
+}
+
+// geminiIntegration 修改 Gemini CLI 的 ~/.gemini/.env，使其通过 relay 的 Gemini 协议入口请求：
+// GOOGLE_GEMINI_BASE_URL 指向 relay，使用 API Key 认证，请求转换为 Anthropic Messages 后由 claude provider 处理；
+// .env 中的其他变量与注释保持不变
+type geminiIntegration struct{}
+
+func (geminiIntegration) Name() string        { return "gemini" }
+func (geminiIntegration) DisplayName() string { return "Gemini CLI" }
+
+func (geminiIntegration) paths() (envPath string, backupPath string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(home, geminiSettingsDir)
+	return filepath.Join(dir, geminiEnvFileName), filepath.Join(dir, geminiBackupFileName), nil
+}
+
+// Apply 首次修改时备份原文件（不存在时备份为空文件），重复 Apply 不会覆盖备份
+func (gi geminiIntegration) Apply(opts ClientApplyOptions) (ClientApplyResult, error) {
+	envPath, backupPath, err := gi.paths()
+	result := ClientApplyResult{SettingsPath: envPath}
+	if err != nil {
+		return result, err
+	}
+	baseURL, err := relayBaseURL(opts.BaseURL, NewClaudeSettingsService("").baseURL())
+	if err != nil {
+		return result, err
+	}
+	original, err := os.ReadFile(envPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return result, err
+	}
+	lines := dotenvLines(original)
+	lines = setDotenv(lines, "GOOGLE_GEMINI_BASE_URL", baseURL)
+	lines = setDotenv(lines, "GEMINI_API_KEY", geminiAPIKeyValue)
+	lines = setDotenv(lines, "GEMINI_DEFAULT_AUTH_TYPE", "gemini-api-key")
+	// Vertex AI 模式与 GOOGLE_API_KEY 会让 Gemini CLI 绕过 GEMINI_API_KEY，真实的 Key 由 relay 负责
+	lines = unsetDotenv(lines, "GOOGLE_GENAI_USE_VERTEXAI")
+	lines = unsetDotenv(lines, "GOOGLE_API_KEY")
+	if model := strings.TrimSpace(opts.Model); model != "" {
+		lines = setDotenv(lines, "GEMINI_MODEL", model)
+	}
+	payload := joinDotenv(lines)
+
+	result.Settings = string(payload)
+	result.Changed = !bytes.Equal(payload, original)
+	result.Note = "Gemini CLI 的请求转换为 Anthropic 协议后由 claude provider 处理，请用 --model 或 GEMINI_MODEL 选择 claude provider 支持的模型；" +
+		"之前使用 Google 账号登录时，在 Gemini CLI 中执行 /auth 选择 Use Gemini API Key。项目目录中的 .env 与 shell 中的同名变量优先于该文件"
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(envPath), 0o755); err != nil {
+		return result, err
+	}
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(backupPath, original, 0o600); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	return result, os.WriteFile(envPath, payload, 0o600)
+}
+
+// Unapply 将 Apply 修改的变量恢复为备份中的值并删除备份，Apply 之后对 .env 的其他修改保留；
+// .env 由 Apply 创建且恢复后没有内容时删除该文件。没有备份时只移除指向 relay 的设置
+func (gi geminiIntegration) Unapply() (ClientApplyResult, error) {
+	envPath, backupPath, err := gi.paths()
+	result := ClientApplyResult{SettingsPath: envPath}
+	if err != nil {
+		return result, err
+	}
+	original, err := os.ReadFile(envPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return result, err
+	}
+	backup, backupErr := os.ReadFile(backupPath)
+	if backupErr != nil && !errors.Is(backupErr, os.ErrNotExist) {
+		return result, fmt.Errorf("读取备份失败: %w", backupErr)
+	}
+	if original != nil {
+		lines := dotenvLines(original)
+		if backupErr == nil {
+			previous := dotenvValues(dotenvLines(backup))
+			for _, key := range geminiManagedEnv {
+				if value, ok := previous[key]; ok {
+					lines = setDotenv(lines, key, value)
+				} else {
+					lines = unsetDotenv(lines, key)
+				}
+			}
+		} else if dotenvValues(lines)["GEMINI_API_KEY"] == geminiAPIKeyValue {
+			for _, key := range []string{"GOOGLE_GEMINI_BASE_URL", "GEMINI_API_KEY", "GEMINI_DEFAULT_AUTH_TYPE"} {
+				lines = unsetDotenv(lines, key)
+			}
+		}
+		payload := joinDotenv(lines)
+		if backupErr == nil && len(bytes.TrimSpace(backup)) == 0 && len(bytes.TrimSpace(payload)) == 0 {
+			if err := os.Remove(envPath); err != nil {
+				return result, err
+			}
+			result.Changed = true
+		} else {
+			result.Settings = string(payload)
+			if !bytes.Equal(payload, original) {
+				if err := os.WriteFile(envPath, payload, 0o600); err != nil {
+					return result, err
+				}
+				result.Changed = true
+			}
+		}
+	}
+	if backupErr == nil {
+		if err := os.Remove(backupPath); err != nil {
+			return result, err
+		}
+		result.BackupPath = backupPath
+	}
+	return result, nil
+}
+
+func dotenvLines(data []byte) []string {
+	text := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func joinDotenv(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// dotenvKey 返回一行 KEY=VALUE（可带 export 前缀）的变量名与值，注释与其他内容返回空
+func dotenvKey(line string) (string, string) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return "", ""
+	}
+	trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "export "))
+	key, value, ok := strings.Cut(trimmed, "=")
+	if !ok {
+		return "", ""
+	}
+	value = strings.TrimSpace(value)
+	if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+		value = unquoted
+	} else if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return strings.TrimSpace(key), value
+}
+
+func dotenvValues(lines []string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range lines {
+		if key, value := dotenvKey(line); key != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// setDotenv 替换变量的第一次定义并移除其余定义，没有定义时追加到末尾
+func setDotenv(lines []string, key string, value string) []string {
+	if strings.ContainsAny(value, " #\"'") {
+		value = strconv.Quote(value)
+	}
+	result := make([]string, 0, len(lines)+1)
+	found := false
+	for _, line := range lines {
+		if name, _ := dotenvKey(line); name == key {
+			if !found {
+				result = append(result, key+"="+value)
+				found = true
+			}
+			continue
+		}
+		result = append(result, line)
+	}
+	if !found {
+		result = append(result, key+"="+value)
+	}
+	return result
+}
+
+func unsetDotenv(lines []string, key string) []string {
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if name, _ := dotenvKey(line); name != key {
+			result = append(result, line)
+		}
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeminiIntegrationKeepsUserEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	envPath := filepath.Join(home, ".gemini", ".env")
+	os.MkdirAll(filepath.Dir(envPath), 0o755)
+	original := "# 代理设置\nHTTPS_PROXY=http://proxy:8080\nGOOGLE_API_KEY=AIza-user\nGEMINI_MODEL=\"gemini-2.5-pro\"\n"
+	os.WriteFile(envPath, []byte(original), 0o600)
+
+	integration, err := LookupClientIntegration("gemini-cli")
+	if err != nil || integration.Name() != "gemini" {
+		t.Fatalf("gemini-cli 应对应 gemini: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		result, err := integration.Apply(ClientApplyOptions{Model: "claude-sonnet-4-5"})
+		if err != nil || (i == 0) != (result.BackupPath != "") {
+			t.Fatalf("apply 失败: %+v %v", result, err)
+		}
+	}
+	data, _ := os.ReadFile(envPath)
+	values := dotenvValues(dotenvLines(data))
+	if values["GOOGLE_GEMINI_BASE_URL"] != "http://127.0.0.1:18100" || values["GEMINI_API_KEY"] != geminiAPIKeyValue ||
+		values["GEMINI_MODEL"] != "claude-sonnet-4-5" || values["HTTPS_PROXY"] != "http://proxy:8080" {
+		t.Fatalf(".env 内容错误: %s", data)
+	}
+	if _, ok := values["GOOGLE_API_KEY"]; ok || !strings.HasPrefix(string(data), "# 代理设置\n") {
+		t.Fatalf("应移除 GOOGLE_API_KEY 并保留注释: %s", data)
+	}
+
+	// apply 之后的其他修改保留，修改的变量恢复为原来的值
+	os.WriteFile(envPath, append(data, []byte("DEBUG=1\n")...), 0o600)
+	if result, err := integration.Unapply(); err != nil || result.BackupPath == "" {
+		t.Fatalf("unapply 失败: %+v %v", result, err)
+	}
+	data, _ = os.ReadFile(envPath)
+	values = dotenvValues(dotenvLines(data))
+	if values["GOOGLE_API_KEY"] != "AIza-user" || values["GEMINI_MODEL"] != "gemini-2.5-pro" || values["DEBUG"] != "1" {
+		t.Fatalf("unapply 应恢复原来的变量: %s", data)
+	}
+	if _, ok := values["GOOGLE_GEMINI_BASE_URL"]; ok {
+		t.Fatalf("unapply 应移除 relay 地址: %s", data)
+	}
+
+	// apply 之前不存在 .env 时，unapply 删除该文件
+	os.Remove(envPath)
+	if _, err := integration.Apply(ClientApplyOptions{}); err != nil {
+		t.Fatalf("apply 失败: %v", err)
+	}
+	if _, err := integration.Unapply(); err != nil {
+		t.Fatalf("unapply 失败: %v", err)
+	}
+	if _, err := os.Stat(envPath); !os.IsNotExist(err) {
+		t.Fatalf("应删除 apply 创建的 .env")
+	}
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HeaderRules 是 provider 发送请求前对 header 的处理，依次执行 remove、set、append
+// set 与 append 的值可使用占位符：{model}（发送给 provider 的模型）、{provider}、{platform}、{request_id}、{session_id}
+// 认证 header 由认证方式最后写入，不受这里的配置影响
+type HeaderRules struct {
+	// 覆盖写入的 header，如 OpenRouter 的 HTTP-Referer、租户 ID
+	Set map[string]string `json:"set,omitempty"`
+	// 以逗号分隔追加到已有值之后并去重，如 anthropic-beta 标记
+	Append map[string]string `json:"append,omitempty"`
+	// 删除客户端带来的 header，不区分大小写，以 * 结尾时按前缀匹配（如 X-Stainless-*）
+	Remove []string `json:"remove,omitempty"`
+}
+
+// headerTemplateValues 是 header 模板可用的占位符
+type headerTemplateValues struct {
+	model     string
+	provider  string
+	platform  string
+	requestID string
+	sessionID string
+}
+
+func (v headerTemplateValues) render(value string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+	return strings.NewReplacer(
+		"{model}", v.model,
+		"{provider}", v.provider,
+		"{platform}", v.platform,
+		"{request_id}", v.requestID,
+		"{session_id}", v.sessionID,
+	).Replace(value)
+}
+
+func (r *HeaderRules) validate() []string {
+	var errors []string
+	check := func(name string, value string) {
+		if !validHeaderName(name) {
+			errors = append(errors, fmt.Sprintf("header 名称无效: %q", name))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			errors = append(errors, fmt.Sprintf("header %s 的值不能包含换行", name))
+		}
+	}
+	for name, value := range r.Set {
+		check(name, value)
+	}
+	for name, value := range r.Append {
+		check(name, value)
+	}
+	for _, name := range r.Remove {
+		if !validHeaderName(strings.TrimSuffix(name, "*")) {
+			errors = append(errors, fmt.Sprintf("要删除的 header 名称无效: %q", name))
+		}
+	}
+	sort.Strings(errors)
+	return errors
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if ch <= ' ' || ch >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply 按规则修改发往上游的 header；headers 的 key 可能不是规范格式，按不区分大小写匹配
+func (r *HeaderRules) apply(headers map[string]string, values headerTemplateValues) {
+	if r == nil {
+		return
+	}
+	for _, pattern := range r.Remove {
+		prefix, wildcard := strings.CutSuffix(pattern, "*")
+		for key := range headers {
+			if (wildcard && strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix))) || strings.EqualFold(key, pattern) {
+				delete(headers, key)
+			}
+		}
+	}
+	for name, value := range r.Set {
+		removeHeader(headers, name)
+		headers[http.CanonicalHeaderKey(name)] = values.render(value)
+	}
+	for name, value := range r.Append {
+		existing := removeHeader(headers, name)
+		headers[http.CanonicalHeaderKey(name)] = appendHeaderValues(existing, values.render(value))
+	}
+}
+
+// removeHeader 删除与 name 不区分大小写相同的 header，返回原有的值
+func removeHeader(headers map[string]string, name string) string {
+	value := ""
+	for key, existing := range headers {
+		if strings.EqualFold(key, name) {
+			value = existing
+			delete(headers, key)
+		}
+	}
+	return value
+}
+
+// appendHeaderValues 将逗号分隔的 added 追加到 existing 之后，跳过已有的值
+func appendHeaderValues(existing string, added string) string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(existing+","+added, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		values = append(values, item)
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHeaderRulesApply(t *testing.T) {
+	headers := map[string]string{
+		"Anthropic-Beta":      "prompt-caching-2024-07-31",
+		"X-Stainless-Os":      "Linux",
+		"X-Stainless-Runtime": "node",
+		"HTTP-Referer":        "https://example.com",
+		"X-Client-Request-Id": "abc",
+		"X-Working-Dir":       "/tmp",
+	}
+	rules := &HeaderRules{
+		Set:    map[string]string{"http-referer": "https://team.example.com", "X-Tenant-Id": "team-{platform}-{model}"},
+		Append: map[string]string{"anthropic-beta": "context-1m-2025-08-07,prompt-caching-2024-07-31"},
+		Remove: []string{"x-stainless-*", "X-Working-Dir"},
+	}
+	rules.apply(headers, headerTemplateValues{model: "claude-sonnet-4-5", platform: "claude"})
+
+	want := map[string]string{
+		"Anthropic-Beta":      "prompt-caching-2024-07-31,context-1m-2025-08-07",
+		"Http-Referer":        "https://team.example.com",
+		"X-Tenant-Id":         "team-claude-claude-sonnet-4-5",
+		"X-Client-Request-Id": "abc",
+	}
+	if len(headers) != len(want) {
+		t.Fatalf("header 数量错误: %v", headers)
+	}
+	for key, value := range want {
+		if headers[key] != value {
+			t.Fatalf("%s: 期望 %q，实际 %q（%v）", key, value, headers[key], headers)
+		}
+	}
+
+	invalid := &HeaderRules{Set: map[string]string{"Bad Header": "x", "X-Ok": "a\r\nb"}, Remove: []string{"*"}}
+	if errs := invalid.validate(); len(errs) != 3 {
+		t.Fatalf("应拒绝非法的 header 名称与值: %v", errs)
+	}
+}
+
+func TestRelayAppliesProviderHeaderRules(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "relay", APIURL: upstream.URL, APIKey: "sk-relay-1234567890", Enabled: true,
+		Headers: &HeaderRules{
+			Set:    map[string]string{"X-Tenant-Id": "{provider}", "Authorization": "Bearer ignored"},
+			Remove: []string{"X-Stainless-*"},
+		},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("X-Stainless-Lang", "js")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if received.Get("X-Tenant-Id") != "relay" || received.Get("X-Stainless-Lang") != "" {
+		t.Fatalf("应按规则处理 header: %v", received)
+	}
+	if received.Get("Authorization") != "Bearer sk-relay-1234567890" {
+		t.Fatalf("认证 header 不应被覆盖: %q", received.Get("Authorization"))
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 滑动窗口保留的最大样本数与时间范围
+	healthWindowSize = 50
+	healthWindowSpan = 10 * time.Minute
+	// 样本数不足时不做自适应调整
+	healthMinSamples = 10
+	// 成功率达到该值且几乎不抖动时视为健康
+	healthyThreshold = 0.95
+	// 成功/失败切换次数占样本的比例超过该值时视为抖动
+	flappingThreshold = 0.2
+)
+
+const (
+	HealthUnknown  = "unknown"
+	HealthHealthy  = "healthy"
+	HealthFlapping = "flapping"
+	HealthDegraded = "degraded"
+)
+
+// ProviderHealth 描述 provider 在最近窗口内的健康情况
+type ProviderHealth struct {
+	Platform    string    `json:"platform"`
+	Provider    string    `json:"provider"`
+	Samples     int       `json:"samples"`
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	Transitions int       `json:"transitions"`
+	SuccessRate float64   `json:"success_rate"`
+	Score       float64   `json:"score"`
+	State       string    `json:"state"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+type healthSample struct {
+	at      time.Time
+	success bool
+}
+
+// healthTracker 按 provider 维护最近请求结果的滑动窗口
+type healthTracker struct {
+	mu      sync.Mutex
+	windows map[string][]healthSample
+	names   map[string][2]string
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		windows: make(map[string][]healthSample),
+		names:   make(map[string][2]string),
+	}
+}
+
+func (ht *healthTracker) record(kind string, providerName string, success bool) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	key := poolKey(kind, providerName)
+	now := time.Now()
+	samples := append(ht.windows[key], healthSample{at: now, success: success})
+	ht.windows[key] = trimHealthSamples(samples, now)
+	ht.names[key] = [2]string{kind, providerName}
+}
+
+func trimHealthSamples(samples []healthSample, now time.Time) []healthSample {
+	start := 0
+	if len(samples) > healthWindowSize {
+		start = len(samples) - healthWindowSize
+	}
+	for start < len(samples) && now.Sub(samples[start].at) > healthWindowSpan {
+		start++
+	}
+	if start == 0 {
+		return samples
+	}
+	return append([]healthSample(nil), samples[start:]...)
+}
+
+// health 计算单个 provider 的健康分
+func (ht *healthTracker) health(kind string, providerName string) ProviderHealth {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	key := poolKey(kind, providerName)
+	samples := trimHealthSamples(ht.windows[key], time.Now())
+	ht.windows[key] = samples
+	return summarizeHealth(kind, providerName, samples)
+}
+
+func summarizeHealth(kind string, providerName string, samples []healthSample) ProviderHealth {
+	result := ProviderHealth{Platform: kind, Provider: providerName, Samples: len(samples), State: HealthUnknown}
+	for i, sample := range samples {
+		if sample.success {
+			result.Successes++
+		} else {
+			result.Failures++
+			result.LastFailure = sample.at
+		}
+		if i > 0 && sample.success != samples[i-1].success {
+			result.Transitions++
+		}
+	}
+	if result.Samples == 0 {
+		return result
+	}
+	result.SuccessRate = float64(result.Successes) / float64(result.Samples)
+	flapRatio := float64(result.Transitions) / float64(result.Samples)
+	// 抖动越频繁，健康分越低
+	result.Score = result.SuccessRate * (1 - flapRatio/2)
+	if result.Samples < healthMinSamples {
+		return result
+	}
+	switch {
+	case result.SuccessRate >= healthyThreshold && flapRatio < flappingThreshold/2:
+		result.State = HealthHealthy
+	case flapRatio >= flappingThreshold:
+		result.State = HealthFlapping
+	default:
+		result.State = HealthDegraded
+	}
+	return result
+}
+
+// snapshot 返回所有 provider 的健康情况，按 platform/provider 排序
+func (ht *healthTracker) snapshot() []ProviderHealth {
+	ht.mu.Lock()
+	now := time.Now()
+	result := make([]ProviderHealth, 0, len(ht.windows))
+	for key, samples := range ht.windows {
+		samples = trimHealthSamples(samples, now)
+		ht.windows[key] = samples
+		names := ht.names[key]
+		result = append(result, summarizeHealth(names[0], names[1], samples))
+	}
+	ht.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		return result[i].Provider < result[j].Provider
+	})
+	return result
+}
+
+// adaptRetryPolicy 根据 provider 的健康情况调整重试力度：
+// 一直健康的 provider 偶发失败通常是终态错误（请求本身有问题），不再重试直接降级；
+// 频繁抖动的 provider 多给一次机会，并放慢退避节奏
+func adaptRetryPolicy(policy RetryPolicy, health ProviderHealth) RetryPolicy {
+	if !policy.Adaptive {
+		return policy
+	}
+	switch health.State {
+	case HealthHealthy:
+		policy.MaxRetryAttempts = 0
+	case HealthFlapping:
+		policy.MaxRetryAttempts++
+		policy.BaseDelay *= 2
+		if policy.MaxDelay < policy.BaseDelay {
+			policy.MaxDelay = policy.BaseDelay
+		}
+	}
+	return policy
+}
+
+// countsTowardHealth 判断一次失败是否反映 provider 本身的健康状况
+// 客户端断开、Key 级别的限流/认证失败以及模型级别的过载不计入
+func countsTowardHealth(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || isOverloadError(err) {
+		return false
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusUnauthorized:
+			return false
+		}
+	}
+	return true
+}
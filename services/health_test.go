@@ -0,0 +1,42 @@
+package services
+
+import "testing"
+
+func TestHealthTrackerStates(t *testing.T) {
+	tracker := newHealthTracker()
+	for i := 0; i < 20; i++ {
+		tracker.record("claude", "stable", true)
+		tracker.record("claude", "flaky", i%2 == 0)
+	}
+
+	if got := tracker.health("claude", "stable").State; got != HealthHealthy {
+		t.Errorf("一直成功的 provider 应为 healthy，实际 %s", got)
+	}
+	flaky := tracker.health("claude", "flaky")
+	if flaky.State != HealthFlapping {
+		t.Errorf("成功失败交替的 provider 应为 flapping，实际 %s", flaky.State)
+	}
+	if flaky.Score >= flaky.SuccessRate {
+		t.Errorf("抖动应降低健康分: score=%.2f successRate=%.2f", flaky.Score, flaky.SuccessRate)
+	}
+	if got := tracker.health("claude", "unseen").State; got != HealthUnknown {
+		t.Errorf("无样本的 provider 应为 unknown，实际 %s", got)
+	}
+}
+
+func TestAdaptRetryPolicy(t *testing.T) {
+	base := RetryConfig{MaxRetryAttempts: 1, BaseDelayMs: 500, MaxDelayMs: 800, Adaptive: true}.Policy()
+
+	if got := adaptRetryPolicy(base, ProviderHealth{State: HealthHealthy}); got.MaxRetryAttempts != 0 {
+		t.Errorf("健康的 provider 不应重试，实际 %d 次", got.MaxRetryAttempts)
+	}
+	flapping := adaptRetryPolicy(base, ProviderHealth{State: HealthFlapping})
+	if flapping.MaxRetryAttempts != 2 || flapping.BaseDelay != 2*base.BaseDelay || flapping.MaxDelay < flapping.BaseDelay {
+		t.Errorf("抖动的 provider 应增加重试并放慢退避: %+v", flapping)
+	}
+
+	base.Adaptive = false
+	if got := adaptRetryPolicy(base, ProviderHealth{State: HealthHealthy}); got.MaxRetryAttempts != 1 {
+		t.Errorf("关闭自适应后应保持原策略，实际 %d 次", got.MaxRetryAttempts)
+	}
+}
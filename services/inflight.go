@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrRequestCancelled 表示请求被管理接口取消
+var ErrRequestCancelled = errors.New("request cancelled by admin")
+
+// InflightRequest 描述一个正在代理中的请求
+type InflightRequest struct {
+	ID             string `json:"id"`
+	Platform       string `json:"platform"`
+	RequestedModel string `json:"requested_model"`
+	// 当前尝试的 provider、实际模型与 Key，降级或重试后更新
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model"`
+	KeyHint    string    `json:"key_hint"`
+	Attempt    int       `json:"attempt"`
+	IsStream   bool      `json:"is_stream"`
+	StartedAt  time.Time `json:"started_at"`
+	ElapsedSec float64   `json:"elapsed_sec"`
+	// 当前尝试中上游已报告的用量；claude 的输出用量在流结束时才报告，此时按已输出字符估算
+	InputTokens     int  `json:"input_tokens"`
+	OutputTokens    int  `json:"output_tokens"`
+	OutputEstimated bool `json:"output_estimated"`
+}
+
+// inflightEntry 是一个进行中请求的登记项，用量由响应钩子所在的协程更新
+type inflightEntry struct {
+	mu     sync.Mutex
+	info   InflightRequest
+	cancel context.CancelCauseFunc
+}
+
+// inflightRegistry 记录所有进行中的请求，用于查看与取消
+type inflightRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*inflightEntry
+}
+
+func newInflightRegistry() *inflightRegistry {
+	return &inflightRegistry{entries: make(map[string]*inflightEntry)}
+}
+
+// register 登记请求，cancel 用于在管理接口取消时中断该请求的上下文
+func (r *inflightRegistry) register(req *relayRequest, cancel context.CancelCauseFunc) *inflightEntry {
+	entry := &inflightEntry{
+		info: InflightRequest{
+			ID:             req.id,
+			Platform:       req.kind,
+			RequestedModel: req.requestedModel,
+			IsStream:       req.isStream,
+			StartedAt:      time.Now(),
+		},
+		cancel: cancel,
+	}
+	r.mu.Lock()
+	r.entries[req.id] = entry
+	r.mu.Unlock()
+	return entry
+}
+
+func (r *inflightRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.entries, id)
+	r.mu.Unlock()
+}
+
+// cancel 取消指定请求，请求不存在（或已结束）时返回 false
+func (r *inflightRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	entry, ok := r.entries[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	entry.cancel(ErrRequestCancelled)
+	return true
+}
+
+// cancelAll 以 cause 取消所有进行中的请求，返回取消的数量
+func (r *inflightRegistry) cancelAll(cause error) int {
+	r.mu.Lock()
+	entries := make([]*inflightEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, entry)
+	}
+	r.mu.Unlock()
+	for _, entry := range entries {
+		entry.cancel(cause)
+	}
+	return len(entries)
+}
+
+func (r *inflightRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+// waitIdle 等待所有进行中的请求结束，ctx 结束时仍有请求返回 false
+func (r *inflightRegistry) waitIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for r.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// snapshot 按开始时间升序返回所有进行中的请求
+func (r *inflightRegistry) snapshot() []InflightRequest {
+	r.mu.Lock()
+	entries := make([]*inflightEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, entry)
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	result := make([]InflightRequest, 0, len(entries))
+	for _, entry := range entries {
+		entry.mu.Lock()
+		info := entry.info
+		entry.mu.Unlock()
+		info.ElapsedSec = now.Sub(info.StartedAt).Seconds()
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// startAttempt 在每次上游尝试开始时更新 provider 信息并清零用量
+func (e *inflightEntry) startAttempt(log *ReqeustLog) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.info.Provider = log.Provider
+	e.info.Model = log.Model
+	e.info.KeyHint = log.KeyHint
+	e.info.Attempt = log.Attempt
+	e.info.InputTokens = 0
+	e.info.OutputTokens = 0
+	e.info.OutputEstimated = false
+}
+
+// progressHook 返回响应钩子，需放在 ReqeustLogHook 之后，将解析出的用量同步到登记项
+func (e *inflightEntry) progressHook(log *ReqeustLog) xrequest.ResponseHook {
+	return func(data []byte) (bool, []byte) {
+		if e == nil {
+			return true, data
+		}
+		output := log.OutputTokens
+		estimated := false
+		if chars := log.progress.chars / estimatedCharsPerToken; chars > output {
+			output = chars
+			estimated = true
+		}
+		e.mu.Lock()
+		e.info.InputTokens = log.InputTokens + log.CacheCreateTokens + log.CacheReadTokens
+		e.info.OutputTokens = output
+		e.info.OutputEstimated = estimated
+		e.mu.Unlock()
+		return true, data
+	}
+}
+
+// InflightRequests 返回当前正在代理中的请求
+func (prs *ProviderRelayService) InflightRequests() []InflightRequest {
+	return prs.inflight.snapshot()
+}
+
+// CancelRequest 取消一个进行中的请求：已开始写出的流会被中断，尚未写出时客户端收到 503
+func (prs *ProviderRelayService) CancelRequest(id string) bool {
+	if prs.inflight.cancel(id) {
+		fmt.Printf("[INFO] 请求 %s 已被取消\n", id)
+		return true
+	}
+	return false
+}
+
+// registerAdminRoutes 注册管理接口，只接受本机命令行工具的访问
+func (prs *ProviderRelayService) registerAdminRoutes(router gin.IRouter) {
+	admin := router.Group("/admin", loopbackOnly, rejectBrowserRequests)
+	admin.GET("/requests", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"requests": prs.InflightRequests()})
+	})
+	admin.DELETE("/requests/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		if !prs.CancelRequest(id) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "请求不存在或已结束"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "cancelled": true})
+	})
+	admin.GET("/config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"files": prs.ConfigReloadStatus()})
+	})
+	admin.POST("/config/reload", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"files": prs.ReloadConfig()})
+	})
+	admin.GET("/storage", func(c *gin.Context) {
+		report, err := transcriptStorageReport()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+	prs.registerProfileRoutes(admin)
+	prs.registerBulkRoutes(admin)
+}
+
+// loopbackOnly 拒绝来自非本机地址的请求，Unix socket 的请求视为本机请求
+func loopbackOnly(c *gin.Context) {
+	ip := net.ParseIP(c.RemoteIP())
+	if (ip == nil || !ip.IsLoopback()) && !isUnixSocketRequest(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "管理接口只允许本机访问"})
+		return
+	}
+	c.Next()
+}
+
+// rejectBrowserRequests 拒绝浏览器发起的请求：网页可以向 127.0.0.1 发送无需预检的跨站 POST，
+// 也可以通过 DNS rebinding 以本机地址读取响应，前者带有 Origin，后者的 Host 不是本机地址
+func rejectBrowserRequests(c *gin.Context) {
+	if c.GetHeader("Origin") != "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "管理接口不接受浏览器发起的请求"})
+		return
+	}
+	if !isUnixSocketRequest(c) && !isLoopbackHost(c.Request.Host) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "管理接口的 Host 必须是 localhost 或本机地址"})
+		return
+	}
+	c.Next()
+}
+
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireContentType 检查请求体的类型，text/plain 等网页无需预检即可发送的类型会被拒绝
+func requireContentType(c *gin.Context, contentType string) bool {
+	if !strings.EqualFold(c.ContentType(), contentType) {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type 必须为 " + contentType})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminCancelInflightStream(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	upstreamDone := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":1200,\"output_tokens\":1}}}\n\n")
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"%s\"}}\n\n", strings.Repeat("a", 2000))
+		w.(http.Flusher).Flush()
+		// 超过 1KB 才会开始转发；之后模拟长时间不结束的流，直到 relay 取消上游请求
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "slow", APIURL: upstream.URL, APIKey: "sk-slow-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/v1/messages", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"messages":[]}`))
+	if err != nil {
+		t.Fatalf("请求 relay 失败: %v", err)
+	}
+	defer resp.Body.Close()
+	id := resp.Header.Get("X-Code-Switch-Request-Id")
+
+	var listed InflightRequest
+	deadline := time.Now().Add(5 * time.Second)
+	for listed.OutputTokens == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		listResp, err := http.Get(server.URL + "/admin/requests")
+		if err != nil {
+			t.Fatalf("查询进行中的请求失败: %v", err)
+		}
+		var body struct {
+			Requests []InflightRequest `json:"requests"`
+		}
+		_ = json.NewDecoder(listResp.Body).Decode(&body)
+		listResp.Body.Close()
+		if len(body.Requests) == 1 {
+			listed = body.Requests[0]
+		}
+	}
+	if listed.ID != id || listed.Provider != "slow" || listed.KeyHint != maskAPIKey("sk-slow-1234567890") || !listed.IsStream {
+		t.Fatalf("进行中的请求信息错误: %+v", listed)
+	}
+	if listed.InputTokens != 1200 || listed.OutputTokens != 500 || !listed.OutputEstimated {
+		t.Fatalf("已有用量错误: %+v", listed)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/admin/requests/"+id, nil)
+	cancelResp, err := http.DefaultClient.Do(req)
+	if err != nil || cancelResp.StatusCode != http.StatusOK {
+		t.Fatalf("取消请求失败: %v %v", cancelResp, err)
+	}
+	cancelResp.Body.Close()
+
+	// 客户端的流随之结束，上游请求也被中断
+	_, _ = io.Copy(io.Discard, bufio.NewReader(resp.Body))
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("取消后上游请求未结束")
+	}
+	if remaining := relay.InflightRequests(); len(remaining) != 0 {
+		t.Fatalf("请求结束后不应再列出: %+v", remaining)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, server.URL+"/admin/requests/"+id, nil)
+	missingResp, err := http.DefaultClient.Do(req)
+	if err != nil || missingResp.StatusCode != http.StatusNotFound {
+		t.Fatalf("已结束的请求应返回 404: %v %v", missingResp, err)
+	}
+	missingResp.Body.Close()
+}
+
+func TestAdminRoutesRejectRemoteClients(t *testing.T) {
+	router := gin.New()
+	(&ProviderRelayService{inflight: newInflightRegistry()}).registerAdminRoutes(router)
+	req := httptest.NewRequest(http.MethodGet, "/admin/requests", nil)
+	req.RemoteAddr = "203.0.113.5:4321"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("非本机访问应被拒绝，实际状态码 %d", rec.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// fileRefPrefix 开头的值从文件读取（去掉末尾换行），如 file:~/.secrets/anthropic
+const fileRefPrefix = "file:"
+
+// envRefPattern 匹配 ${VAR} 与 ${VAR:-默认值}
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateValue 展开配置值中的 ${VAR} 环境变量引用，或读取 file: 引用的文件；
+// 环境变量未设置或为空且没有默认值时报错，使同一份配置可以提交到团队仓库，由每台机器或 CI 环境补齐
+func interpolateValue(value string) (string, error) {
+	if strings.HasPrefix(value, fileRefPrefix) {
+		path := strings.TrimSpace(strings.TrimPrefix(value, fileRefPrefix))
+		data, err := os.ReadFile(expandHome(path))
+		if err != nil {
+			return "", fmt.Errorf("读取 %s 失败: %w", value, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var missing []string
+	expanded := envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := envRefPattern.FindStringSubmatch(ref)
+		if v := os.Getenv(match[1]); v != "" {
+			return v
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		missing = append(missing, match[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("环境变量 %s 未设置", strings.Join(missing, "、"))
+	}
+	return expanded, nil
+}
+
+// isConfigReference 判断值是否完全由一个引用组成（${VAR}、file: 或钥匙串引用），不包含 Key 本身
+func isConfigReference(value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, fileRefPrefix) || isSecretRef(value) {
+		return true
+	}
+	loc := envRefPattern.FindStringIndex(value)
+	return loc != nil && loc[0] == 0 && loc[1] == len(value)
+}
+
+// resolveReferences 在加载配置时展开 apiUrl、header 值与 Key 中的引用，Key 还可以引用钥匙串（见 ResolveSecret）；
+// 无法展开的值被清空，返回的问题以字段名开头
+func (p *Provider) resolveReferences() []string {
+	var problems []string
+	resolve := func(field string, value string, secret bool) string {
+		resolved, err := interpolateValue(value)
+		if err == nil && secret {
+			resolved, err = ResolveSecret(resolved)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", field, err))
+			return ""
+		}
+		return resolved
+	}
+	p.APIURL = resolve("apiUrl", p.APIURL, false)
+	p.APIKey = resolve("apiKey", p.APIKey, true)
+	if len(p.APIKeys) > 0 {
+		keys := make([]string, len(p.APIKeys))
+		for i, key := range p.APIKeys {
+			keys[i] = resolve("apiKeys", key, true)
+		}
+		p.APIKeys = keys
+	}
+	if p.Headers != nil {
+		headers := *p.Headers
+		headers.Set = resolveHeaderValues("headers.set", headers.Set, resolve)
+		headers.Append = resolveHeaderValues("headers.append", headers.Append, resolve)
+		p.Headers = &headers
+	}
+	return problems
+}
+
+func resolveHeaderValues(field string, values map[string]string, resolve func(string, string, bool) string) map[string]string {
+	if len(values) == 0 {
+		return values
+	}
+	resolved := make(map[string]string, len(values))
+	for name, value := range values {
+		resolved[name] = resolve(field+"."+name, value, false)
+	}
+	return resolved
+}
+
+// resolveProviderReferences 展开列表中所有 provider 的引用，返回无法展开的值
+func resolveProviderReferences(kind string, providers []Provider) []string {
+	var problems []string
+	for i := range providers {
+		for _, msg := range providers[i].resolveReferences() {
+			problems = append(problems, fmt.Sprintf("[%s/%s] %s", kind, providers[i].Name, msg))
+		}
+	}
+	return problems
+}
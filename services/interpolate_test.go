@@ -0,0 +1,92 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInterpolateValue(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CS_TEST_HOST", "relay.corp")
+	t.Setenv("CS_TEST_EMPTY", "")
+	keyFile := filepath.Join(os.Getenv("HOME"), ".secrets", "anthropic")
+	os.MkdirAll(filepath.Dir(keyFile), 0o700)
+	os.WriteFile(keyFile, []byte("sk-file-1234567890\n"), 0o600)
+
+	for value, want := range map[string]string{
+		"https://${CS_TEST_HOST}/v1":           "https://relay.corp/v1",
+		"${CS_TEST_EMPTY:-https://fallback}":   "https://fallback",
+		"file:~/.secrets/anthropic":            "sk-file-1234567890",
+		"sk-plain-1234567890":                  "sk-plain-1234567890",
+		"https://${CS_TEST_HOST}:${CS_PORT:-}": "https://relay.corp:",
+	} {
+		if got, err := interpolateValue(value); err != nil || got != want {
+			t.Errorf("interpolateValue(%q) = %q, %v，期望 %q", value, got, err, want)
+		}
+	}
+	if _, err := interpolateValue("${CS_TEST_MISSING}-${CS_TEST_EMPTY}"); err == nil || !strings.Contains(err.Error(), "CS_TEST_MISSING、CS_TEST_EMPTY") {
+		t.Fatalf("环境变量未设置时应报错: %v", err)
+	}
+	if _, err := interpolateValue("file:~/.secrets/missing"); err == nil {
+		t.Fatalf("文件不存在时应报错")
+	}
+
+	if !isConfigReference("${ANTHROPIC_KEY}") || !isConfigReference("file:/run/secrets/key") || isConfigReference("sk-${SUFFIX}") {
+		t.Fatalf("isConfigReference 判断错误")
+	}
+}
+
+func TestRelayUsesInterpolatedConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var authorization, tenant string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, tenant = r.Header.Get("Authorization"), r.Header.Get("X-Tenant")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("CS_TEST_UPSTREAM", upstream.URL)
+	t.Setenv("CS_TEST_KEY", "sk-env-1234567890")
+	t.Setenv("CS_TEST_TENANT", "")
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "team", APIURL: "${CS_TEST_UPSTREAM}", APIKey: "${CS_TEST_KEY}", Enabled: true,
+			Headers: &HeaderRules{Set: map[string]string{"X-Tenant": "${CS_TEST_TENANT:-default-team}"}}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	path, _ := providerFilePath("claude")
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "sk-env") {
+		t.Fatalf("配置中应保留引用而不是展开后的值: %s", data)
+	}
+
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || authorization != "Bearer sk-env-1234567890" || tenant != "default-team" {
+		t.Fatalf("应使用展开后的配置请求上游: %d %q %q", rec.Code, authorization, tenant)
+	}
+
+	// 导出时隐藏 Key 也保留引用，便于提交到团队仓库
+	bundle, err := NewConfigBundleService(ps, NewRelayConfigService()).ExportProfile(ProviderProfileOptions{RedactSecrets: true})
+	if err != nil || bundle.Providers["claude"][0].APIKey != "${CS_TEST_KEY}" {
+		t.Fatalf("导出时应保留引用: %+v %v", bundle.Providers["claude"], err)
+	}
+
+	os.Unsetenv("CS_TEST_KEY")
+	report := ValidateConfig(ConfigValidateOptions{})
+	if issue, ok := findIssue(report, "环境变量 CS_TEST_KEY 未设置"); !ok || issue.Severity != ConfigIssueError || issue.Line == 0 {
+		t.Fatalf("config validate 应报告未设置的环境变量: %+v", report.Issues)
+	}
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 429 未携带 Retry-After 时的默认冷却时长
+	defaultRateLimitCooldown = 60 * time.Second
+	// 401 通常意味着 Key 失效或欠费，冷却更久
+	defaultAuthFailureCooldown = 10 * time.Minute
+)
+
+// APIKeyUsage 描述单个 API Key 的使用情况
+type APIKeyUsage struct {
+	Platform      string    `json:"platform"`
+	Provider      string    `json:"provider"`
+	KeyHint       string    `json:"key_hint"`
+	Requests      int64     `json:"requests"`
+	Failures      int64     `json:"failures"`
+	LastUsedAt    time.Time `json:"last_used_at"`
+	CooldownUntil time.Time `json:"cooldown_until"`
+}
+
+// apiKeyPool 维护每个 provider 的 Key 轮询游标、冷却集合与使用统计
+type apiKeyPool struct {
+	mu        sync.Mutex
+	cursors   map[string]int
+	cooldowns map[string]time.Time
+	// rateLimits 记录因 429 进入冷却的 Key 及其恢复时间
+	rateLimits map[string]time.Time
+	usage      map[string]*APIKeyUsage
+}
+
+func newAPIKeyPool() *apiKeyPool {
+	return &apiKeyPool{
+		cursors:    make(map[string]int),
+		cooldowns:  make(map[string]time.Time),
+		rateLimits: make(map[string]time.Time),
+		usage:      make(map[string]*APIKeyUsage),
+	}
+}
+
+func poolKey(kind string, providerName string) string {
+	return kind + "\x00" + providerName
+}
+
+func keySlot(kind string, providerName string, apiKey string) string {
+	return poolKey(kind, providerName) + "\x00" + apiKey
+}
+
+// candidates 返回本次请求可用的 Key 顺序（已跳过冷却中的 Key）
+// 每次调用都会推进轮询游标，使流量在多个 Key 之间均匀分布
+func (p *apiKeyPool) candidates(kind string, provider Provider) []string {
+	keys := provider.requestKeys()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pk := poolKey(kind, provider.Name)
+	start := p.cursors[pk] % len(keys)
+	p.cursors[pk] = (start + 1) % len(keys)
+
+	now := time.Now()
+	result := make([]string, 0, len(keys))
+	for i := 0; i < len(keys); i++ {
+		key := keys[(start+i)%len(keys)]
+		slot := keySlot(kind, provider.Name, key)
+		if until, ok := p.cooldowns[slot]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(p.cooldowns, slot)
+			delete(p.rateLimits, slot)
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+// markUsed 记录一次 Key 使用
+func (p *apiKeyPool) markUsed(kind string, providerName string, apiKey string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry := p.usageLocked(kind, providerName, apiKey)
+	entry.Requests++
+	entry.LastUsedAt = time.Now()
+}
+
+// markCooldown 将失败的 Key 放入冷却集合
+func (p *apiKeyPool) markCooldown(kind string, providerName string, apiKey string, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until := time.Now().Add(duration)
+	p.cooldowns[keySlot(kind, providerName, apiKey)] = until
+	entry := p.usageLocked(kind, providerName, apiKey)
+	entry.Failures++
+	entry.CooldownUntil = until
+}
+
+// markRateLimited 将收到 429 的 Key 放入冷却集合，并记为限流状态
+func (p *apiKeyPool) markRateLimited(kind string, providerName string, apiKey string, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	p.markCooldown(kind, providerName, apiKey, duration)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rateLimits[keySlot(kind, providerName, apiKey)] = time.Now().Add(duration)
+}
+
+// rateLimitedUntil 判断给定的 provider 是否全部不可用且至少有一个 Key 处于限流冷却中
+// 返回最早恢复的时间；只要还有可用的 Key，或者没有任何 Key 是因限流冷却的，就返回 false
+func (p *apiKeyPool) rateLimitedUntil(kind string, providers []Provider) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var earliest time.Time
+	for _, provider := range providers {
+		for _, key := range provider.requestKeys() {
+			slot := keySlot(kind, provider.Name, key)
+			until, cooling := p.cooldowns[slot]
+			if !cooling || !now.Before(until) {
+				return time.Time{}, false
+			}
+			if limitUntil, limited := p.rateLimits[slot]; limited && now.Before(limitUntil) {
+				if earliest.IsZero() || limitUntil.Before(earliest) {
+					earliest = limitUntil
+				}
+			}
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+func (p *apiKeyPool) usageLocked(kind string, providerName string, apiKey string) *APIKeyUsage {
+	slot := keySlot(kind, providerName, apiKey)
+	entry := p.usage[slot]
+	if entry == nil {
+		entry = &APIKeyUsage{
+			Platform: kind,
+			Provider: providerName,
+			KeyHint:  maskAPIKey(apiKey),
+		}
+		p.usage[slot] = entry
+	}
+	return entry
+}
+
+// snapshot 返回所有 Key 的使用统计，按 platform/provider/key 排序
+func (p *apiKeyPool) snapshot() []APIKeyUsage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]APIKeyUsage, 0, len(p.usage))
+	for _, entry := range p.usage {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		return result[i].KeyHint < result[j].KeyHint
+	})
+	return result
+}
+
+// keyCooldownFor 根据上游状态码决定 Key 是否需要冷却以及冷却时长
+func keyCooldownFor(upstreamErr *UpstreamError) (time.Duration, bool) {
+	if upstreamErr == nil {
+		return 0, false
+	}
+	switch upstreamErr.StatusCode {
+	case http.StatusTooManyRequests:
+		if upstreamErr.RetryAfter > 0 {
+			return upstreamErr.RetryAfter, true
+		}
+		return defaultRateLimitCooldown, true
+	case http.StatusUnauthorized:
+		return defaultAuthFailureCooldown, true
+	default:
+		return 0, false
+	}
+}
+
+// parseRetryAfter 解析 Retry-After 头（秒数或 HTTP 日期）
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// maskAPIKey 生成 Key 的脱敏标识，用于日志与统计
+func maskAPIKey(key string) string {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyPoolRoundRobin(t *testing.T) {
+	pool := newAPIKeyPool()
+	provider := Provider{Name: "relay", APIKey: "key-a", APIKeys: []string{"key-b", "key-c", "key-a"}}
+
+	expectedFirst := []string{"key-a", "key-b", "key-c", "key-a"}
+	for i, expected := range expectedFirst {
+		keys := pool.candidates("claude", provider)
+		if len(keys) != 3 {
+			t.Fatalf("第 %d 次：期望 3 个候选 Key，实际 %d", i, len(keys))
+		}
+		if keys[0] != expected {
+			t.Errorf("第 %d 次：首选 Key = %q，期望 %q", i, keys[0], expected)
+		}
+	}
+}
+
+func TestAPIKeyPoolCooldown(t *testing.T) {
+	pool := newAPIKeyPool()
+	provider := Provider{Name: "relay", APIKey: "key-a", APIKeys: []string{"key-b"}}
+
+	pool.markCooldown("claude", provider.Name, "key-a", time.Minute)
+	for i := 0; i < 3; i++ {
+		keys := pool.candidates("claude", provider)
+		if len(keys) != 1 || keys[0] != "key-b" {
+			t.Fatalf("冷却中的 Key 不应被返回，实际: %v", keys)
+		}
+	}
+
+	pool.markCooldown("claude", provider.Name, "key-b", time.Minute)
+	if keys := pool.candidates("claude", provider); len(keys) != 0 {
+		t.Errorf("所有 Key 冷却时应返回空，实际: %v", keys)
+	}
+
+	usage := pool.snapshot()
+	if len(usage) != 2 || usage[0].Failures != 1 || usage[0].CooldownUntil.IsZero() {
+		t.Errorf("冷却统计不正确: %+v", usage)
+	}
+}
+
+func TestKeyCooldownFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *UpstreamError
+		rotate   bool
+		duration time.Duration
+	}{
+		{"429 带 Retry-After", &UpstreamError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}, true, 5 * time.Second},
+		{"429 无 Retry-After", &UpstreamError{StatusCode: http.StatusTooManyRequests}, true, defaultRateLimitCooldown},
+		{"401", &UpstreamError{StatusCode: http.StatusUnauthorized}, true, defaultAuthFailureCooldown},
+		{"500 不轮换", &UpstreamError{StatusCode: http.StatusInternalServerError}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, rotate := keyCooldownFor(tt.err)
+			if rotate != tt.rotate || duration != tt.duration {
+				t.Errorf("keyCooldownFor = (%v, %v)，期望 (%v, %v)", duration, rotate, tt.duration, tt.rotate)
+			}
+		})
+	}
+}
+
+func TestMaskAPIKey(t *testing.T) {
+	if got := maskAPIKey("sk-ant-1234567890abcd"); got != "sk-a...abcd" {
+		t.Errorf("maskAPIKey = %q", got)
+	}
+	if got := maskAPIKey("short"); got != "*****" {
+		t.Errorf("maskAPIKey(short) = %q", got)
+	}
+}
+
+func TestAPIKeyPoolRateLimitedUntil(t *testing.T) {
+	pool := newAPIKeyPool()
+	providers := []Provider{
+		{Name: "p1", APIKey: "key-a"},
+		{Name: "p2", APIKey: "key-b"},
+	}
+
+	pool.markRateLimited("claude", "p1", "key-a", time.Minute)
+	if _, ok := pool.rateLimitedUntil("claude", providers); ok {
+		t.Fatalf("仍有可用 Key 时不应判定为全部限流")
+	}
+
+	pool.markCooldown("claude", "p2", "key-b", 10*time.Minute)
+	until, ok := pool.rateLimitedUntil("claude", providers)
+	if !ok {
+		t.Fatalf("所有 Key 不可用且存在限流冷却时应判定为全部限流")
+	}
+	if d := time.Until(until); d <= 0 || d > time.Minute {
+		t.Errorf("恢复时间应取限流冷却的到期时间，实际剩余 %v", d)
+	}
+
+	if _, ok := pool.rateLimitedUntil("claude", providers[1:]); ok {
+		t.Errorf("仅因认证失败冷却时不应排队")
+	}
+}
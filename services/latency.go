@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xrequest"
+)
+
+const (
+	// 首字节延迟滑动窗口保留的最大样本数与时间范围
+	latencyWindowSize = 50
+	latencyWindowSpan = 10 * time.Minute
+	// 样本数不足且近期没有失败的 provider 优先尝试，尽快积累样本
+	latencyMinSamples = 3
+	// 其他 provider 的 p50 比当前首选低 20% 以上时才切换
+	defaultLatencyHysteresis = 0.2
+)
+
+// ProviderLatency 描述 provider 上单个模型最近的首字节延迟
+type ProviderLatency struct {
+	Platform string  `json:"platform"`
+	Provider string  `json:"provider"`
+	Model    string  `json:"model"`
+	Samples  int     `json:"samples"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	// latency 策略当前为该模型首选的 provider
+	Preferred bool `json:"preferred"`
+}
+
+type latencySample struct {
+	at        time.Time
+	firstByte time.Duration
+}
+
+// latencyTracker 按 provider + 模型维护首字节延迟的滑动窗口，以及 latency 策略为每个模型选定的首选 provider
+type latencyTracker struct {
+	mu      sync.Mutex
+	windows map[string][]latencySample
+	names   map[string][3]string
+	leaders map[string]string
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		windows: make(map[string][]latencySample),
+		names:   make(map[string][3]string),
+		leaders: make(map[string]string),
+	}
+}
+
+func (lt *latencyTracker) record(kind string, providerName string, model string, firstByte time.Duration) {
+	if model == "" {
+		return
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	key := modelSlot(kind, providerName, model)
+	now := time.Now()
+	samples := append(lt.windows[key], latencySample{at: now, firstByte: firstByte})
+	lt.windows[key] = trimLatencySamples(samples, now)
+	lt.names[key] = [3]string{kind, providerName, model}
+}
+
+// firstByteHook 返回在收到第一段响应时记录首字节延迟的钩子
+func (lt *latencyTracker) firstByteHook(kind string, providerName string, model string, start time.Time) xrequest.ResponseHook {
+	recorded := false
+	return func(data []byte) (bool, []byte) {
+		if !recorded {
+			recorded = true
+			lt.record(kind, providerName, model, time.Since(start))
+		}
+		return true, data
+	}
+}
+
+func trimLatencySamples(samples []latencySample, now time.Time) []latencySample {
+	start := 0
+	if len(samples) > latencyWindowSize {
+		start = len(samples) - latencyWindowSize
+	}
+	for start < len(samples) && now.Sub(samples[start].at) > latencyWindowSpan {
+		start++
+	}
+	return samples[start:]
+}
+
+func summarizeLatency(names [3]string, samples []latencySample) ProviderLatency {
+	result := ProviderLatency{Platform: names[0], Provider: names[1], Model: names[2], Samples: len(samples)}
+	if len(samples) == 0 {
+		return result
+	}
+	durations := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		durations[i] = sample.firstByte
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	result.P50Ms = latencyPercentile(durations, 0.5)
+	result.P95Ms = latencyPercentile(durations, 0.95)
+	return result
+}
+
+// latencyPercentile 按最近秩法计算已排序样本的分位数（毫秒）
+func latencyPercentile(sorted []time.Duration, p float64) float64 {
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return float64(sorted[index].Microseconds()) / 1000
+}
+
+func (lt *latencyTracker) statsLocked(kind string, providerName string, model string, now time.Time) ProviderLatency {
+	key := modelSlot(kind, providerName, model)
+	samples, ok := lt.windows[key]
+	if ok {
+		samples = trimLatencySamples(samples, now)
+		lt.windows[key] = samples
+	}
+	return summarizeLatency([3]string{kind, providerName, model}, samples)
+}
+
+// stats 返回 provider 上该模型近期的首字节延迟
+func (lt *latencyTracker) stats(kind string, providerName string, model string) ProviderLatency {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.statsLocked(kind, providerName, model, time.Now())
+}
+
+// rank 将同一优先级的 provider 按首字节延迟排列：样本不足且 explore 返回 true 的排在最前以便采样，其余按 p50 从低到高，
+// 无法采样的排在最后；当前首选的 provider 只有在其他 provider 的 p50 低于它 hysteresis 比例以上时才被替换，避免在相近的 provider 间来回切换
+func (lt *latencyTracker) rank(kind string, model string, group []Provider, hysteresis float64, explore func(Provider) bool) {
+	if model == "" || len(group) < 2 {
+		return
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	now := time.Now()
+	stats := make(map[string]ProviderLatency, len(group))
+	var measured, exploring, unmeasured []Provider
+	for _, provider := range group {
+		latency := lt.statsLocked(kind, provider.Name, model, now)
+		stats[provider.Name] = latency
+		switch {
+		case latency.Samples >= latencyMinSamples:
+			measured = append(measured, provider)
+		case explore(provider):
+			exploring = append(exploring, provider)
+		default:
+			unmeasured = append(unmeasured, provider)
+		}
+	}
+	sort.SliceStable(measured, func(i, j int) bool {
+		return stats[measured[i].Name].P50Ms < stats[measured[j].Name].P50Ms
+	})
+
+	slot := poolKey(kind, model)
+	if len(measured) > 0 {
+		leader := lt.leaders[slot]
+		for i, provider := range measured {
+			// 差距不足切换阈值时保持当前首选
+			if i > 0 && provider.Name == leader && stats[measured[0].Name].P50Ms >= stats[leader].P50Ms*(1-hysteresis) {
+				copy(measured[1:i+1], measured[:i])
+				measured[0] = provider
+				break
+			}
+		}
+		if measured[0].Name != leader {
+			if leader != "" {
+				fmt.Printf("[INFO]   模型 %s 的首选 provider 切换为 %s（p50 首字节延迟 %.0fms）\n",
+					model, measured[0].Name, stats[measured[0].Name].P50Ms)
+			}
+			lt.leaders[slot] = measured[0].Name
+		}
+	}
+	copy(group, append(append(exploring, measured...), unmeasured...))
+}
+
+// snapshot 返回所有 provider/模型的首字节延迟，按 platform/model/provider 排序
+func (lt *latencyTracker) snapshot() []ProviderLatency {
+	lt.mu.Lock()
+	now := time.Now()
+	result := make([]ProviderLatency, 0, len(lt.windows))
+	for key, samples := range lt.windows {
+		samples = trimLatencySamples(samples, now)
+		lt.windows[key] = samples
+		names := lt.names[key]
+		latency := summarizeLatency(names, samples)
+		latency.Preferred = lt.leaders[poolKey(names[0], names[2])] == names[1]
+		result = append(result, latency)
+	}
+	lt.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		if result[i].Model != result[j].Model {
+			return result[i].Model < result[j].Model
+		}
+		return result[i].Provider < result[j].Provider
+	})
+	return result
+}
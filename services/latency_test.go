@@ -0,0 +1,132 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tracker := newLatencyTracker()
+	for i := 1; i <= 20; i++ {
+		tracker.record("claude", "relay", "claude-sonnet-4-5", time.Duration(i)*time.Millisecond)
+	}
+	tracker.record("claude", "relay", "", time.Second)
+
+	snapshot := tracker.snapshot()
+	if len(snapshot) != 1 {
+		t.Fatalf("未指定模型的请求不应记录延迟: %+v", snapshot)
+	}
+	if got := snapshot[0]; got.Samples != 20 || got.P50Ms != 10 || got.P95Ms != 19 {
+		t.Fatalf("p50/p95 计算错误: %+v", got)
+	}
+}
+
+func TestLatencyRankWithHysteresis(t *testing.T) {
+	tracker := newLatencyTracker()
+	recordN := func(provider string, latency time.Duration) {
+		for i := 0; i < latencyMinSamples; i++ {
+			tracker.record("claude", provider, "m", latency)
+		}
+	}
+	explore := func(Provider) bool { return true }
+	rank := func(providers ...string) string {
+		group := make([]Provider, 0, len(providers))
+		for _, name := range providers {
+			group = append(group, Provider{Name: name})
+		}
+		tracker.rank("claude", "m", group, defaultLatencyHysteresis, explore)
+		return providerNames(group)
+	}
+
+	recordN("a", 100*time.Millisecond)
+	recordN("b", 300*time.Millisecond)
+	if got := rank("b", "a", "new"); got != "new,a,b" {
+		t.Fatalf("样本不足的 provider 应先采样，其余按延迟排列，实际 %s", got)
+	}
+	if got := func() string {
+		group := []Provider{{Name: "new"}, {Name: "b"}, {Name: "a"}}
+		tracker.rank("claude", "m", group, defaultLatencyHysteresis, func(Provider) bool { return false })
+		return providerNames(group)
+	}(); got != "a,b,new" {
+		t.Fatalf("近期失败的 provider 不应优先采样，实际 %s", got)
+	}
+
+	// b 略快于 a，未超过切换阈值，保持 a 为首选
+	recordN("b", 90*time.Millisecond)
+	recordN("b", 90*time.Millisecond)
+	if got := rank("a", "b"); got != "a,b" {
+		t.Fatalf("差距不足切换阈值时应保持首选，实际 %s", got)
+	}
+	recordN("b", 40*time.Millisecond)
+	recordN("b", 40*time.Millisecond)
+	recordN("b", 40*time.Millisecond)
+	if got := rank("a", "b"); got != "b,a" {
+		t.Fatalf("明显更快时应切换首选，实际 %s", got)
+	}
+	for _, latency := range tracker.snapshot() {
+		if latency.Preferred != (latency.Provider == "b") {
+			t.Fatalf("首选 provider 标记错误: %+v", latency)
+		}
+	}
+}
+
+func TestRelayLatencyRouting(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	hits := map[string]int{}
+	upstream := func(name string, delay time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			time.Sleep(delay)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"type":"message","usage":{"input_tokens":1,"output_tokens":1}}`)
+		}))
+	}
+	slow, fast := upstream("slow", 60*time.Millisecond), upstream("fast", 0)
+	defer slow.Close()
+	defer fast.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "slow", APIURL: slow.URL, APIKey: "sk-slow-1234567890", Enabled: true},
+		{ID: 2, Name: "fast", APIURL: fast.URL, APIKey: "sk-fast-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relayConfig := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Routing.Strategy = RoutingStrategyLatency
+	if _, err := relayConfig.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, relayConfig, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	// slow 排在列表前面且已有样本，没有样本的 fast 先被采样，之后因延迟更低成为首选
+	for i := 0; i < latencyMinSamples; i++ {
+		relay.latency.record("claude", "slow", "claude-sonnet-4-5", 60*time.Millisecond)
+	}
+	for i := 0; i < 2*latencyMinSamples; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-5","messages":[]}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	if hits["slow"] != 0 || hits["fast"] != 2*latencyMinSamples {
+		t.Fatalf("应先为没有样本的 provider 采样，之后使用更快的 provider: %+v", hits)
+	}
+	for _, latency := range relay.ProviderLatency() {
+		if latency.Provider == "fast" && (!latency.Preferred || latency.Samples != 2*latencyMinSamples) {
+			t.Fatalf("转发的请求应记录首字节延迟: %+v", latency)
+		}
+	}
+}
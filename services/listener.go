@@ -0,0 +1,181 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listenerContextKey 保存请求到达的监听配置名称，默认监听地址上为空
+const listenerContextKey = "code-switch.listener"
+
+var listenerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ListenerConfig 是同一进程中的另一个监听地址，只使用选定的 provider，可以有独立的路由规则，
+// 请求日志的 listener 字段记录它的归属标签，用于区分工作与个人等不同场景的用量
+type ListenerConfig struct {
+	Name string `json:"name"`
+	// 监听地址，如 :8787、127.0.0.1:8787 或 unix:/home/me/.code-switch/work.sock；地址变化需要重启 code-switch，其余设置随配置重新加载生效
+	Addr string `json:"addr"`
+	// 只使用名称在列表中、或带有 providerTags 中任一标签的 provider，均未配置时使用全部 provider
+	Providers    []string `json:"providers,omitempty"`
+	ProviderTags []string `json:"providerTags,omitempty"`
+	// 替换 relay 的 routing 配置，未配置时使用 relay 的 routing
+	Routing *RoutingConfig `json:"routing,omitempty"`
+	// 写入请求日志的归属标签，默认为 name
+	Tag string `json:"tag,omitempty"`
+}
+
+// attributionTag 返回写入请求日志的归属标签
+func (l ListenerConfig) attributionTag() string {
+	if tag := strings.TrimSpace(l.Tag); tag != "" {
+		return tag
+	}
+	return l.Name
+}
+
+// selects 判断 provider 是否属于该监听配置
+func (l ListenerConfig) selects(p Provider) bool {
+	if len(l.Providers) == 0 && len(l.ProviderTags) == 0 {
+		return true
+	}
+	for _, name := range l.Providers {
+		if name == p.Name {
+			return true
+		}
+	}
+	for _, tag := range l.ProviderTags {
+		for _, providerTag := range p.Tags {
+			if strings.EqualFold(tag, providerTag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (l ListenerConfig) filterProviders(providers []Provider) []Provider {
+	selected := make([]Provider, 0, len(providers))
+	for _, p := range providers {
+		if l.selects(p) {
+			selected = append(selected, p)
+		}
+	}
+	return selected
+}
+
+func validateListeners(listeners []ListenerConfig) []string {
+	var errors []string
+	names := make(map[string]bool, len(listeners))
+	addrs := make(map[string]bool, len(listeners))
+	for i, l := range listeners {
+		label := fmt.Sprintf("listeners[%d]", i)
+		if !listenerNamePattern.MatchString(l.Name) {
+			errors = append(errors, fmt.Sprintf("%s: name 只能包含字母、数字、_、. 与 -: %q", label, l.Name))
+		} else if names[l.Name] {
+			errors = append(errors, fmt.Sprintf("%s: name 重复: %s", label, l.Name))
+		}
+		names[l.Name] = true
+		if path, ok := strings.CutPrefix(l.Addr, unixAddrPrefix); ok {
+			if err := validateSocketPath(path); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", label, err))
+			}
+		} else if _, port, err := net.SplitHostPort(l.Addr); err != nil || port == "" {
+			errors = append(errors, fmt.Sprintf("%s: addr 无效（如 :8787、127.0.0.1:8787 或 unix:/绝对路径）: %q", label, l.Addr))
+		}
+		if addrs[l.Addr] {
+			errors = append(errors, fmt.Sprintf("%s: addr 重复: %s", label, l.Addr))
+		}
+		addrs[l.Addr] = true
+		if l.Routing != nil {
+			for _, err := range validateSystemPrompts(l.Routing.SystemPrompt) {
+				errors = append(errors, fmt.Sprintf("%s: routing: %s", label, err))
+			}
+		}
+	}
+	return errors
+}
+
+// requestListener 返回请求到达的监听配置；默认监听地址返回 nil，监听配置已从 relay.json 中删除时返回错误
+func requestListener(c *gin.Context, cfg RelayConfig) (*ListenerConfig, error) {
+	name := c.GetString(listenerContextKey)
+	if name == "" {
+		return nil, nil
+	}
+	for i := range cfg.Listeners {
+		if cfg.Listeners[i].Name == name {
+			return &cfg.Listeners[i], nil
+		}
+	}
+	return nil, fmt.Errorf("监听配置 %s 已从 relay 配置中删除，重启 code-switch 后停止监听", name)
+}
+
+// listenerConfig 返回请求使用的 relay 配置（监听配置的 routing 替换 relay 的 routing）与监听配置；
+// 监听配置已删除时返回 503 并返回 ok=false
+func (prs *ProviderRelayService) listenerConfig(c *gin.Context) (RelayConfig, *ListenerConfig, bool) {
+	relayCfg := prs.loadRelayConfig()
+	listener, err := requestListener(c, relayCfg)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return relayCfg, nil, false
+	}
+	if listener != nil && listener.Routing != nil {
+		relayCfg.Routing = *listener.Routing
+	}
+	return relayCfg, listener, true
+}
+
+// listenerProviders 返回监听配置选定的 provider
+func (prs *ProviderRelayService) listenerProviders(kind string, listener *ListenerConfig) ([]Provider, error) {
+	providers, err := prs.loadProviders(kind)
+	if err != nil || listener == nil {
+		return providers, err
+	}
+	return listener.filterProviders(providers), nil
+}
+
+// withListener 标记请求到达的监听配置
+func withListener(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(listenerContextKey, name)
+	}
+}
+
+// startListeners 为 relay 配置中的每个监听配置启动独立的 HTTP 服务，路由与默认监听地址相同
+func (prs *ProviderRelayService) startListeners() {
+	for _, listener := range prs.loadRelayConfig().Listeners {
+		if listener.Addr == prs.addr {
+			fmt.Printf("[WARN] 监听配置 %s 的地址 %s 与默认监听地址相同，已忽略\n", listener.Name, listener.Addr)
+			continue
+		}
+		router := gin.Default()
+		name := listener.Name
+		router.Use(withListener(name))
+		prs.registerRoutes(router)
+		ln, err := listenAddr(listener.Addr)
+		if err != nil {
+			fmt.Printf("[WARN] 监听配置 %s 无法监听 %s: %v\n", listener.Name, listener.Addr, err)
+			continue
+		}
+		server := &http.Server{Addr: listener.Addr, Handler: router}
+		prs.listenerServers = append(prs.listenerServers, server)
+		fmt.Printf("provider relay listener %s listening on %s\n", listener.Name, listener.Addr)
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("provider relay listener %s error: %v\n", name, err)
+			}
+		}()
+	}
+}
+
+// listenerTag 返回请求日志中记录的归属标签，默认监听地址为空
+func listenerTag(listener *ListenerConfig) string {
+	if listener == nil {
+		return ""
+	}
+	return listener.attributionTag()
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestListenerProfilesSelectProviders(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+		}))
+	}
+	work, personal := newUpstream("work"), newUpstream("personal")
+	defer work.Close()
+	defer personal.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "company", APIURL: work.URL, APIKey: "sk-work-1234567890", Enabled: true, Tags: []string{"work"}},
+		{ID: 2, Name: "own", APIURL: personal.URL, APIKey: "sk-own-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Listeners = []ListenerConfig{
+		{Name: "work", Addr: ":8787", ProviderTags: []string{"Work"}},
+		{Name: "personal", Addr: ":8788", Providers: []string{"own"}, Tag: "home"},
+	}
+	if errs := cfg.validate(); len(errs) > 0 {
+		t.Fatalf("监听配置应通过校验: %v", errs)
+	}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+
+	relay := NewProviderRelayService(ps, rcs, "")
+	send := func(listener string) int {
+		t.Helper()
+		router := gin.New()
+		if listener != "" {
+			router.Use(withListener(listener))
+		}
+		relay.registerRoutes(router)
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, listener := range []string{"personal", "work", ""} {
+		if code := send(listener); code != http.StatusOK {
+			t.Fatalf("监听 %q 的请求失败: %d", listener, code)
+		}
+	}
+	// 默认监听地址使用全部 provider，按顺序先请求 company
+	if strings.Join(hits, ",") != "personal,work,work" {
+		t.Fatalf("监听配置应只使用选定的 provider: %v", hits)
+	}
+	records, err := xdb.New("request_log").Selects(xdb.OrderByAsc("id"))
+	if err != nil || len(records) != 3 {
+		t.Fatalf("读取请求日志失败: %d %v", len(records), err)
+	}
+	var tags []string
+	for _, record := range records {
+		tags = append(tags, requestLogFromRecord(record).Listener)
+	}
+	if strings.Join(tags, ",") != "home,work," {
+		t.Fatalf("请求日志应记录监听的归属标签: %v", tags)
+	}
+
+	// relay.json 中删除的监听配置在重启前拒绝请求
+	cfg.Listeners = cfg.Listeners[:1]
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	if code := send("personal"); code != http.StatusServiceUnavailable {
+		t.Fatalf("已删除的监听配置应返回 503: %d", code)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	errs := validateListeners([]ListenerConfig{
+		{Name: "work", Addr: ":8787"},
+		{Name: "work", Addr: ":8787"},
+		{Name: "bad name", Addr: "8789"},
+	})
+	if len(errs) != 4 {
+		t.Fatalf("应报告重复的 name、addr 与无效的值: %v", errs)
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+const (
+	// 同一优先级内选择 provider 的策略
+	RoutingStrategyPriority = "priority"
+	RoutingStrategyLatency  = "latency"
+	RoutingStrategyCost     = "cost"
+)
+
+// RoutingConfig 控制同一优先级内 provider 的选择策略
+type RoutingConfig struct {
+	// priority（默认，按列表顺序与权重）、latency（优先使用该模型首字节延迟最低的 provider）
+	// 或 cost（优先使用按估算用量最便宜、且上下文窗口足够的 provider/模型组合）
+	Strategy string `json:"strategy,omitempty"`
+	// latency 策略的切换阈值：其他 provider 的 p50 首字节延迟比当前首选低该比例以上时才切换，默认 0.2
+	LatencyHysteresis float64 `json:"latencyHysteresis,omitempty"`
+	// 模型档位（如 sonnet-tier）-> 可以互相替代的模型，请求以档位名作为模型时，在所有支持其中任一模型的 provider 间路由
+	ModelClasses map[string][]string `json:"modelClasses,omitempty"`
+	// 将同一会话固定到最后一次成功处理它的 provider（该 provider 健康时），保持提示词缓存命中与回复风格一致
+	StickySessions bool `json:"stickySessions,omitempty"`
+	// 会话固定的有效期（分钟），自最后一次成功请求起算，默认 60
+	StickySessionTTLMinutes float64 `json:"stickySessionTtlMinutes,omitempty"`
+	// 客户端指定会话标识的请求头，默认 X-Code-Switch-Session
+	SessionHeader string `json:"sessionHeader,omitempty"`
+	// 按模型声明的降级链，第一条匹配请求模型的链生效
+	FallbackChains []FallbackChain `json:"fallbackChains,omitempty"`
+	// 提示词过长的请求改用 1M 上下文窗口的模型
+	LongPrompt *LongPromptConfig `json:"longPrompt,omitempty"`
+	// 小模型（haiku 等后台任务）请求固定使用的 provider
+	SmallModel *SmallModelConfig `json:"smallModel,omitempty"`
+	// 转发前修改 system prompt 的规则，适用于所有 provider，在 provider 自己的 systemPrompt 之前执行
+	SystemPrompt []SystemPromptRule `json:"systemPrompt,omitempty"`
+}
+
+func (c RoutingConfig) hysteresis() float64 {
+	if c.LatencyHysteresis <= 0 || c.LatencyHysteresis >= 1 {
+		return defaultLatencyHysteresis
+	}
+	return c.LatencyHysteresis
+}
+
+// routeTarget 是一次请求的路由依据
+type routeTarget struct {
+	kind string
+	// 请求的模型名或模型档位
+	model string
+	// cost 策略按估算的提示词与输出 token 数比较价格
+	promptTokens         int
+	expectedOutputTokens int
+	// 请求显式指定的输出上限（max_tokens / max_output_tokens），未指定时为 0
+	maxOutputTokens int
+}
+
+// expandModelClass 在请求的模型为模型档位时，将每个 provider 展开为档位中每个模型的候选；
+// 其余请求原样返回
+func (c RoutingConfig) expandModelClass(providers []Provider, model string) []Provider {
+	members, ok := c.ModelClasses[model]
+	if !ok || len(members) == 0 {
+		return providers
+	}
+	expanded := make([]Provider, 0, len(providers)*len(members))
+	for _, provider := range providers {
+		for _, member := range members {
+			candidate := provider
+			candidate.routedModel = member
+			expanded = append(expanded, candidate)
+		}
+	}
+	return expanded
+}
+
+// 近期成功率低于该值（且样本充足）的 provider 视为不健康，由低优先级的 provider 顶替
+const unhealthySuccessRate = 0.5
+
+// providerLevel 返回 provider 的优先级，未配置时为 1
+func providerLevel(provider Provider) int {
+	if provider.Level <= 0 {
+		return 1
+	}
+	return provider.Level
+}
+
+// providerWeight 返回 provider 在同一优先级内的权重，未配置时为 1
+func providerWeight(provider Provider) int {
+	if provider.Weight <= 0 {
+		return 1
+	}
+	return provider.Weight
+}
+
+// unhealthy 判断 provider 近期的失败是否多到应当让位给低优先级的 provider
+func (h ProviderHealth) unhealthy() bool {
+	return h.Samples >= healthMinSamples && h.SuccessRate < unhealthySuccessRate
+}
+
+// balanceProviders 返回本次请求尝试 provider 的顺序：
+// Level 数字越小越先尝试；同一 Level 内按 latency 策略选择首字节延迟最低的 provider、按 cost 策略选择最便宜的 provider，
+// 或在有 provider 配置了权重时按权重随机排列（如 80/20 分流），否则保持列表顺序；
+// 近期不健康的 provider 排到所有健康的 provider 之后，相当于自动提升低优先级的 provider
+func (prs *ProviderRelayService) balanceProviders(target routeTarget, active []Provider, routing RoutingConfig) []Provider {
+	kind := target.kind
+	ordered := make([]Provider, len(active))
+	copy(ordered, active)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return providerLevel(ordered[i]) < providerLevel(ordered[j])
+	})
+	for start := 0; start < len(ordered); {
+		end := start + 1
+		for end < len(ordered) && providerLevel(ordered[end]) == providerLevel(ordered[start]) {
+			end++
+		}
+		switch routing.Strategy {
+		case RoutingStrategyLatency:
+			prs.latency.rank(kind, target.model, ordered[start:end], routing.hysteresis(), func(provider Provider) bool {
+				return prs.health.health(kind, provider.Name).Failures == 0
+			})
+		case RoutingStrategyCost:
+			rankByCost(target, ordered[start:end])
+		default:
+			shuffleByWeight(ordered[start:end])
+		}
+		start = end
+	}
+
+	healthy := make([]Provider, 0, len(ordered))
+	var demoted []Provider
+	for _, provider := range ordered {
+		if health := prs.health.health(kind, provider.Name); health.unhealthy() {
+			fmt.Printf("[INFO]   Provider %s 近期成功率 %.0f%%，排到健康的 provider 之后\n", provider.Name, health.SuccessRate*100)
+			demoted = append(demoted, provider)
+			continue
+		}
+		healthy = append(healthy, provider)
+	}
+	return append(healthy, demoted...)
+}
+
+// shuffleByWeight 按权重随机排列同一优先级的 provider，排在第一位的概率与权重成正比；
+// 没有 provider 配置权重时保持原顺序
+func shuffleByWeight(group []Provider) {
+	weighted := false
+	for _, provider := range group {
+		if provider.Weight > 0 {
+			weighted = true
+			break
+		}
+	}
+	if !weighted || len(group) < 2 {
+		return
+	}
+	// 加权随机抽样（Efraimidis-Spirakis）：每个 provider 取 u^(1/w)，按从大到小排列
+	keys := make([]float64, len(group))
+	for i, provider := range group {
+		keys[i] = math.Pow(rand.Float64(), 1/float64(providerWeight(provider)))
+	}
+	order := make([]int, len(group))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] > keys[order[j]] })
+	shuffled := make([]Provider, len(group))
+	for i, index := range order {
+		shuffled[i] = group[index]
+	}
+	copy(group, shuffled)
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func providerNames(providers []Provider) string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, provider.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestBalanceProvidersByLevelAndWeight(t *testing.T) {
+	prs := &ProviderRelayService{health: newHealthTracker(), latency: newLatencyTracker()}
+
+	// 未配置权重时同一优先级保持列表顺序，优先级数字小的排在前面
+	ordered := prs.balanceProviders(routeTarget{kind: "claude", model: "m"}, []Provider{
+		{Name: "backup", Level: 2}, {Name: "first"}, {Name: "second", Level: 1},
+	}, RoutingConfig{})
+	if got := providerNames(ordered); got != "first,second,backup" {
+		t.Fatalf("应按优先级排列并保持列表顺序，实际 %s", got)
+	}
+
+	// 80/20 分流：排在第一位的次数与权重成正比
+	active := []Provider{{Name: "relay", Weight: 80}, {Name: "official", Weight: 20}, {Name: "backup", Level: 2}}
+	firsts := map[string]int{}
+	const rounds = 5000
+	for i := 0; i < rounds; i++ {
+		ordered := prs.balanceProviders(routeTarget{kind: "claude", model: "m"}, active, RoutingConfig{})
+		if ordered[2].Name != "backup" {
+			t.Fatalf("低优先级的 provider 应排在最后，实际 %s", providerNames(ordered))
+		}
+		firsts[ordered[0].Name]++
+	}
+	if share := float64(firsts["relay"]) / rounds; share < 0.75 || share > 0.85 {
+		t.Fatalf("权重 80 的 provider 应约有 80%% 的请求，实际 %.2f", share)
+	}
+	if active[0].Name != "relay" {
+		t.Fatalf("不应修改传入的 provider 列表")
+	}
+}
+
+func TestBalanceProvidersPromotesWhenUnhealthy(t *testing.T) {
+	prs := &ProviderRelayService{health: newHealthTracker(), latency: newLatencyTracker()}
+	for i := 0; i < healthMinSamples; i++ {
+		prs.health.record("claude", "primary", i%4 == 0)
+		prs.health.record("codex", "primary", true)
+	}
+	active := []Provider{{Name: "primary", Level: 1}, {Name: "fallback", Level: 2}}
+	if got := providerNames(prs.balanceProviders(routeTarget{kind: "claude", model: "m"}, active, RoutingConfig{})); got != "fallback,primary" {
+		t.Fatalf("不健康的高优先级 provider 应排到健康的 provider 之后，实际 %s", got)
+	}
+	if got := providerNames(prs.balanceProviders(routeTarget{kind: "codex", model: "m"}, active, RoutingConfig{})); got != "primary,fallback" {
+		t.Fatalf("健康情况按平台区分，实际 %s", got)
+	}
+}
+
+func TestProviderWeightValidation(t *testing.T) {
+	provider := Provider{Name: "p", Weight: -1}
+	if errs := provider.ValidateConfiguration(); len(errs) == 0 {
+		t.Fatalf("负数权重应验证失败")
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// 本地推理服务的类型，决定列出模型时使用的接口
+const (
+	LocalServerOllama   = "ollama"
+	LocalServerLMStudio = "lmstudio"
+	LocalServerLlamaCpp = "llamacpp"
+)
+
+// 列出本地模型的超时时间，本地服务未启动时应尽快返回
+const localModelsTimeout = 5 * time.Second
+
+// LocalConfig 描述本地推理服务（Ollama、LM Studio、llama.cpp server）：
+// 请求不计费，API Key 可以留空；claude 的 apiUrl 为服务地址（如 http://localhost:11434），codex 需包含 /v1
+type LocalConfig struct {
+	// 服务类型：ollama、lmstudio 或 llamacpp，留空时按 OpenAI 兼容的 /v1/models 列出模型
+	Server string `json:"server,omitempty"`
+	// 仅作为兜底：排在所有云端 provider 之后，云端均不可用或请求超出预算时才使用
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// LocalModel 是本地推理服务上可用的模型
+type LocalModel struct {
+	ID string `json:"id"`
+	// 模型文件大小（字节），Ollama 提供
+	Size int64 `json:"size,omitempty"`
+	// 参数量与量化方式，如 7.6B / Q4_K_M，Ollama 提供
+	ParameterSize string `json:"parameterSize,omitempty"`
+	Quantization  string `json:"quantization,omitempty"`
+	// 是否已加载到内存，LM Studio 提供
+	Loaded bool `json:"loaded,omitempty"`
+}
+
+func (c *LocalConfig) validate() []string {
+	switch strings.ToLower(strings.TrimSpace(c.Server)) {
+	case "", LocalServerOllama, LocalServerLMStudio, LocalServerLlamaCpp:
+		return nil
+	}
+	return []string{fmt.Sprintf("不支持的本地服务类型: %s（支持 ollama、lmstudio、llamacpp）", c.Server)}
+}
+
+// isLocalFallback 返回 provider 是否为仅作兜底的本地 provider
+func (p *Provider) isLocalFallback() bool {
+	return p.Local != nil && p.Local.Fallback
+}
+
+// requestKeys 返回请求使用的 Key，未配置 Key 的本地 provider 以空 Key 请求
+func (p *Provider) requestKeys() []string {
+	keys := p.AllAPIKeys()
+	if len(keys) == 0 && p.Local != nil {
+		return []string{""}
+	}
+	return keys
+}
+
+// localAuth 是本地 provider 的默认认证：配置了 Key 时使用 Bearer（如开启认证的 LM Studio），否则不附加认证
+type localAuth struct{}
+
+func (localAuth) Apply(req *http.Request, apiKey string) error {
+	removeClientAuth(req)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	return nil
+}
+
+// splitLocalFallbacks 将仅作兜底的本地 provider 从候选列表中分离出来
+func splitLocalFallbacks(providers []Provider) (cloud []Provider, fallbacks []Provider) {
+	for _, provider := range providers {
+		if provider.isLocalFallback() {
+			fallbacks = append(fallbacks, provider)
+		} else {
+			cloud = append(cloud, provider)
+		}
+	}
+	return cloud, fallbacks
+}
+
+// localModelsURL 返回本地服务列出模型的接口：Ollama 为 /api/tags，LM Studio 为 /api/v0/models，其余为 /v1/models
+func (p *Provider) localModelsURL() string {
+	base := strings.TrimSuffix(strings.TrimRight(p.APIURL, "/"), "/v1")
+	server := ""
+	if p.Local != nil {
+		server = strings.ToLower(strings.TrimSpace(p.Local.Server))
+	}
+	switch server {
+	case LocalServerOllama:
+		return base + "/api/tags"
+	case LocalServerLMStudio:
+		return base + "/api/v0/models"
+	}
+	return base + "/v1/models"
+}
+
+// ListLocalModels 通过本地推理服务的接口列出 provider 上可用的模型
+func (ps *ProviderService) ListLocalModels(kind string, name string) ([]LocalModel, error) {
+	providers, err := ps.LoadProviders(kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, provider := range providers {
+		if provider.Name != name {
+			continue
+		}
+		if provider.Local == nil {
+			return nil, fmt.Errorf("provider %s 不是本地 provider", name)
+		}
+		if problems := provider.resolveReferences(); len(problems) > 0 {
+			return nil, errors.New(problems[0])
+		}
+		return listLocalModels(&http.Client{Timeout: localModelsTimeout}, provider)
+	}
+	return nil, fmt.Errorf("provider %s 不存在", name)
+}
+
+func listLocalModels(client *http.Client, provider Provider) ([]LocalModel, error) {
+	req, err := http.NewRequest(http.MethodGet, provider.localModelsURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 apiUrl: %w", err)
+	}
+	strategy, err := provider.AuthStrategy()
+	if err != nil {
+		return nil, err
+	}
+	if err := strategy.Apply(req, provider.requestKeys()[0]); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("本地服务不可用: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("列出模型失败: HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("模型列表不是有效的 JSON")
+	}
+
+	models := []LocalModel{}
+	// Ollama: {"models": [{"name", "size", "details": {...}}]}
+	gjson.GetBytes(body, "models").ForEach(func(_, item gjson.Result) bool {
+		models = append(models, LocalModel{
+			ID:            item.Get("name").String(),
+			Size:          item.Get("size").Int(),
+			ParameterSize: item.Get("details.parameter_size").String(),
+			Quantization:  item.Get("details.quantization_level").String(),
+		})
+		return true
+	})
+	// OpenAI 兼容接口与 LM Studio: {"data": [{"id", "state"}]}
+	gjson.GetBytes(body, "data").ForEach(func(_, item gjson.Result) bool {
+		models = append(models, LocalModel{
+			ID:           item.Get("id").String(),
+			Quantization: item.Get("quantization").String(),
+			Loaded:       item.Get("state").String() == "loaded",
+		})
+		return true
+	})
+	return models, nil
+}
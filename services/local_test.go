@@ -0,0 +1,153 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestListLocalModels(t *testing.T) {
+	var paths []string
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		auths = append(auths, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[{"name":"qwen2.5-coder:7b","size":4683087332,"details":{"parameter_size":"7.6B","quantization_level":"Q4_K_M"}}]}`)
+		case "/api/v0/models":
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"qwen3-coder-30b","state":"loaded","quantization":"Q4_K_M"},{"id":"gemma-3-12b","state":"not-loaded"}]}`)
+		case "/v1/models":
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"llama-3.1-8b"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := server.Client()
+
+	models, err := listLocalModels(client, Provider{APIURL: server.URL + "/v1", Local: &LocalConfig{Server: LocalServerOllama}})
+	if err != nil || len(models) != 1 || models[0].ID != "qwen2.5-coder:7b" || models[0].Size != 4683087332 ||
+		models[0].ParameterSize != "7.6B" || models[0].Quantization != "Q4_K_M" {
+		t.Fatalf("Ollama 模型列表解析错误: %+v %v", models, err)
+	}
+	models, err = listLocalModels(client, Provider{APIURL: server.URL, APIKey: "lm-key", Local: &LocalConfig{Server: LocalServerLMStudio}})
+	if err != nil || len(models) != 2 || !models[0].Loaded || models[1].Loaded || models[1].ID != "gemma-3-12b" {
+		t.Fatalf("LM Studio 模型列表解析错误: %+v %v", models, err)
+	}
+	models, err = listLocalModels(client, Provider{APIURL: server.URL + "/v1/", Local: &LocalConfig{Server: LocalServerLlamaCpp}})
+	if err != nil || len(models) != 1 || models[0].ID != "llama-3.1-8b" {
+		t.Fatalf("llama.cpp 模型列表解析错误: %+v %v", models, err)
+	}
+	if strings.Join(paths, ",") != "/api/tags,/api/v0/models,/v1/models" {
+		t.Fatalf("列出模型的接口错误: %v", paths)
+	}
+	if auths[0] != "" || auths[1] != "Bearer lm-key" {
+		t.Fatalf("未配置 Key 时不应附加认证: %q", auths)
+	}
+
+	if _, err := listLocalModels(client, Provider{APIURL: server.URL + "/missing", Local: &LocalConfig{Server: LocalServerOllama}}); err == nil {
+		t.Fatalf("接口返回 404 时应报错")
+	}
+	if errs := (&Provider{Name: "local", APIURL: server.URL, Local: &LocalConfig{Server: "vllm"}}).ValidateConfiguration(); len(errs) == 0 {
+		t.Fatalf("不支持的本地服务类型应报错")
+	}
+}
+
+func TestLocalProviderIsFree(t *testing.T) {
+	pricing, err := modelpricing.NewServiceFromData([]byte(`{"claude-sonnet-4-5": {"input_cost_per_token": 0.000003, "output_cost_per_token": 0.000015}}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	usage := modelpricing.UsageSnapshot{InputTokens: 1000, OutputTokens: 100}
+	provider := Provider{Local: &LocalConfig{}, PriceMultiplier: 2, RequestFee: 0.01}
+	for _, model := range []string{"claude-sonnet-4-5", "qwen2.5-coder:7b"} {
+		if cost := pricing.CalculateCost(model, usage, provider.CostOptions()...); !cost.HasPricing || cost.TotalCost != 0 || cost.SurchargeCost != 0 {
+			t.Fatalf("本地模型 %s 不应计费: %+v", model, cost)
+		}
+	}
+	if cost := pricing.CalculateCost("claude-sonnet-4-5", usage); cost.TotalCost == 0 {
+		t.Fatalf("云端模型应按价格计费: %+v", cost)
+	}
+}
+
+func TestRelayFallsBackToLocalProvider(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	handler := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			if name == "local" && r.Header.Get("Authorization") != "" {
+				t.Errorf("本地 provider 不应收到认证头: %q", r.Header.Get("Authorization"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"qwen2.5-coder:7b","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":10,"output_tokens":5}}`)
+		}
+	}
+	cloud := httptest.NewServer(handler("cloud", http.StatusInternalServerError))
+	defer cloud.Close()
+	local := httptest.NewServer(handler("local", http.StatusOK))
+	defer local.Close()
+
+	ps := NewProviderService()
+	// 兜底的本地 provider 排在列表最前也只在云端之后使用
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "ollama", APIURL: local.URL, Enabled: true, Local: &LocalConfig{Server: LocalServerOllama, Fallback: true},
+			ModelRewrites: []ModelRewrite{{Match: "claude-*", Target: "qwen2.5-coder:7b"}}},
+		{ID: 2, Name: "cloud", APIURL: cloud.URL, APIKey: "sk-cloud-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":1000,"messages":[]}`)))
+		return rec
+	}
+
+	if rec := post(); rec.Code != http.StatusOK || strings.Join(hits, ",") != "cloud,local" {
+		t.Fatalf("云端失败后应降级到本地 provider: %d %v %s", rec.Code, hits, rec.Body.String())
+	}
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "ollama"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); entry.Model != "qwen2.5-coder:7b" || record.GetFloat64("original_cost") != 0 {
+		t.Fatalf("本地请求应记录改写后的模型且不计费: %+v cost=%v", entry, record.GetFloat64("original_cost"))
+	}
+
+	// 超出单次上限时不请求云端，直接使用本地 provider
+	cfg := defaultRelayConfig()
+	cfg.Client = ClientConfig{MaxRequestCost: 0.000001}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	hits = nil
+	if rec := post(); rec.Code != http.StatusOK || strings.Join(hits, ",") != "local" {
+		t.Fatalf("超出预算时应只使用本地 provider: %d %v %s", rec.Code, hits, rec.Body.String())
+	}
+
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 2, Name: "cloud", APIURL: cloud.URL, APIKey: "sk-cloud-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	hits = nil
+	if rec := post(); rec.Code != http.StatusPaymentRequired || len(hits) != 0 {
+		t.Fatalf("没有本地 provider 时超出预算的请求应被拒绝: %d %v", rec.Code, hits)
+	}
+}
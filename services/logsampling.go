@@ -0,0 +1,141 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+)
+
+// LogSamplingConfig 控制详细日志的采样：请求的 [INFO] 控制台日志、重试钩子事件的 Sampled 标记与保存的请求/响应内容使用同一个采样结果。
+// request_log 中的用量与费用始终完整记录，统计不受采样影响；[WARN]/[ERROR] 日志不采样
+type LogSamplingConfig struct {
+	Enabled bool `json:"enabled"`
+	// 既不是错误也未达到费用阈值的请求按该比例保留（0-1）
+	Rate float64 `json:"rate"`
+	// 单次尝试费用（美元）达到该值时总是保留，0 表示不按费用保留
+	CostThreshold float64 `json:"costThreshold"`
+	// 按 provider 或 Key 覆盖 Rate 与 CostThreshold，使用第一条匹配的规则
+	Rules []LogSamplingRule `json:"rules,omitempty"`
+}
+
+// LogSamplingRule 为匹配的 provider/Key 指定采样比例，Provider 与 Key 同时配置时需都匹配
+type LogSamplingRule struct {
+	// provider 名称，支持 * 通配符
+	Provider string `json:"provider,omitempty"`
+	// 完整 Key 或脱敏后的 key_hint
+	Key           string  `json:"key,omitempty"`
+	Rate          float64 `json:"rate"`
+	CostThreshold float64 `json:"costThreshold"`
+}
+
+func (r LogSamplingRule) matches(providerName string, keyHint string) bool {
+	if r.Provider == "" && r.Key == "" {
+		return false
+	}
+	if r.Provider != "" && !matchWildcard(r.Provider, providerName) {
+		return false
+	}
+	if r.Key != "" && keyHintFor(r.Key) != keyHint {
+		return false
+	}
+	return true
+}
+
+// policy 返回适用于 provider/Key 的采样比例与费用阈值
+func (c LogSamplingConfig) policy(providerName string, keyHint string) (rate float64, costThreshold float64) {
+	for _, rule := range c.Rules {
+		if rule.matches(providerName, keyHint) {
+			return rule.Rate, rule.CostThreshold
+		}
+	}
+	return c.Rate, c.CostThreshold
+}
+
+// keep 判断是否保留一次尝试的详细日志：错误、重试与高费用请求总是保留，其余按请求 ID 采样
+// 按请求 ID 取样保证同一请求的所有尝试得到相同的结果
+func (c LogSamplingConfig) keep(requestID string, entry *ReqeustLog, cost float64) bool {
+	if !c.Enabled || entry == nil {
+		return true
+	}
+	if entry.HttpCode < 200 || entry.HttpCode >= 300 || entry.Attempt > 1 {
+		return true
+	}
+	rate, costThreshold := c.policy(entry.Provider, entry.KeyHint)
+	if costThreshold > 0 && cost >= costThreshold {
+		return true
+	}
+	return sampleFraction(requestID) < rate
+}
+
+// sampleFraction 将请求 ID 映射到 [0, 1) 区间
+func sampleFraction(requestID string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.TrimSpace(requestID)))
+	return float64(h.Sum32()) / (math.MaxUint32 + 1.0)
+}
+
+// sampleDecision 是一个请求的采样结果：任一次尝试需要保留（错误、重试或高费用）时整个请求保留，
+// 没有发起尝试的请求（如命中缓存）按请求 ID 与默认比例采样。
+// 开启采样后 logf 写入的日志先缓存，请求结束时按结果输出或丢弃
+type sampleDecision struct {
+	cfg       LogSamplingConfig
+	requestID string
+
+	mu        sync.Mutex
+	evaluated bool
+	kept      bool
+	lines     []string
+}
+
+func newSampleDecision(cfg LogSamplingConfig, requestID string) *sampleDecision {
+	return &sampleDecision{cfg: cfg, requestID: requestID}
+}
+
+// keep 判断是否保留一次尝试的详细内容，并计入整个请求的采样结果
+func (d *sampleDecision) keep(entry *ReqeustLog, cost float64) bool {
+	kept := d.cfg.keep(d.requestID, entry, cost)
+	d.mu.Lock()
+	d.evaluated = true
+	d.kept = d.kept || kept
+	d.mu.Unlock()
+	return kept
+}
+
+// sampled 返回目前的采样结果，err 不为空时总是保留
+func (d *sampleDecision) sampled(err error) bool {
+	if !d.cfg.Enabled || err != nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.kept || (!d.evaluated && sampleFraction(d.requestID) < d.cfg.Rate)
+}
+
+// logf 输出请求的详细日志，未开启采样时直接输出
+func (d *sampleDecision) logf(format string, args ...any) {
+	if !d.cfg.Enabled {
+		fmt.Printf(format, args...)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lines = append(d.lines, fmt.Sprintf(format, args...))
+}
+
+// finish 在请求结束时输出保留的日志，status 为返回给客户端的状态码，非 2xx 时总是保留
+func (d *sampleDecision) finish(status int) {
+	var err error
+	if status < 200 || status >= 300 {
+		err = fmt.Errorf("status %d", status)
+	}
+	keep := d.sampled(err)
+	d.mu.Lock()
+	lines := d.lines
+	d.lines = nil
+	d.mu.Unlock()
+	if keep {
+		fmt.Print(strings.Join(lines, ""))
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLogSamplingKeep(t *testing.T) {
+	cfg := LogSamplingConfig{
+		Enabled:       true,
+		Rate:          0.1,
+		CostThreshold: 0.5,
+		Rules: []LogSamplingRule{
+			{Provider: "audit-*", Rate: 1},
+			{Key: "sk-noisy-1234567890", Rate: 0},
+		},
+	}
+	ok := &ReqeustLog{Provider: "p1", HttpCode: 200, Attempt: 1}
+
+	if !cfg.keep("r1", &ReqeustLog{Provider: "p1", HttpCode: 502, Attempt: 1}, 0) {
+		t.Fatalf("错误请求应总是保留")
+	}
+	if !cfg.keep("r1", &ReqeustLog{Provider: "p1", HttpCode: 200, Attempt: 2}, 0) {
+		t.Fatalf("重试后的请求应总是保留")
+	}
+	if !cfg.keep("r1", ok, 0.8) {
+		t.Fatalf("高费用请求应总是保留")
+	}
+	if !cfg.keep("r1", &ReqeustLog{Provider: "audit-eu", HttpCode: 200, Attempt: 1}, 0) {
+		t.Fatalf("规则指定 100%% 采样时应保留")
+	}
+	noisy := &ReqeustLog{Provider: "p1", KeyHint: maskAPIKey("sk-noisy-1234567890"), HttpCode: 200, Attempt: 1}
+	for i := 0; i < 50; i++ {
+		if cfg.keep(fmt.Sprintf("r%d", i), noisy, 0.1) {
+			t.Fatalf("规则指定 0%% 采样时不应保留")
+		}
+	}
+
+	// 同一请求 ID 的结果稳定，整体比例接近配置值
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		first := cfg.keep(id, ok, 0)
+		if first != cfg.keep(id, ok, 0) {
+			t.Fatalf("同一请求的采样结果应一致")
+		}
+		if first {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Fatalf("采样比例偏离过大: %d/10000", kept)
+	}
+	if !(LogSamplingConfig{}).keep("r1", ok, 0) {
+		t.Fatalf("未启用采样时应全部保留")
+	}
+}
+
+func TestSampleDecision(t *testing.T) {
+	cfg := LogSamplingConfig{Enabled: true, Rate: 0}
+	ok := &ReqeustLog{Provider: "p1", HttpCode: 200, Attempt: 1}
+
+	logs := newSampleDecision(cfg, "r1")
+	logs.logf("[INFO] %s\n", "buffered")
+	if len(logs.lines) != 1 {
+		t.Fatalf("开启采样时日志应先缓存，实际 %d 行", len(logs.lines))
+	}
+	if logs.sampled(nil) {
+		t.Fatalf("比例为 0 且没有尝试时不应保留")
+	}
+	if !logs.sampled(errors.New("boom")) {
+		t.Fatalf("出错的事件应总是保留")
+	}
+	if logs.keep(ok, 0) || logs.sampled(nil) {
+		t.Fatalf("正常请求按比例 0 不应保留")
+	}
+	if !logs.keep(&ReqeustLog{Provider: "p1", HttpCode: 502, Attempt: 2}, 0) {
+		t.Fatalf("失败的尝试应保留")
+	}
+	// 任一次尝试保留后，后续成功的尝试与钩子事件也随之保留
+	req := &relayRequest{kind: "claude", requestedModel: "claude-sonnet", tracker: newRetryTracker(RetryPolicy{}), sampling: logs}
+	if !req.retryEvent(nil).Sampled {
+		t.Fatalf("钩子事件应使用请求的采样结果")
+	}
+	logs.finish(200)
+	if logs.lines != nil {
+		t.Fatalf("请求结束后应清空缓存的日志")
+	}
+
+	all := newSampleDecision(LogSamplingConfig{Enabled: true, Rate: 1}, "r2")
+	if !all.sampled(nil) {
+		t.Fatalf("比例为 1 时应保留没有尝试的请求")
+	}
+	disabled := newSampleDecision(LogSamplingConfig{}, "r3")
+	disabled.logf("[INFO] %s\n", "direct")
+	if len(disabled.lines) != 0 || !disabled.sampled(nil) {
+		t.Fatalf("未启用采样时日志应直接输出并全部保留")
+	}
+}
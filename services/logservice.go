@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	modelpricing "codeswitch/resources/model-pricing"
@@ -49,28 +52,53 @@ func (ls *LogService) ListRequestLogs(platform string, provider string, limit in
 		return nil, err
 	}
 	logs := make([]ReqeustLog, 0, len(records))
+	markups := loadProviderMarkups()
 	for _, record := range records {
-		logEntry := ReqeustLog{
-			ID:                record.GetInt64("id"),
-			Platform:          record.GetString("platform"),
-			Model:             record.GetString("model"),
-			Provider:          record.GetString("provider"),
-			HttpCode:          record.GetInt("http_code"),
-			InputTokens:       record.GetInt("input_tokens"),
-			OutputTokens:      record.GetInt("output_tokens"),
-			CacheCreateTokens: record.GetInt("cache_create_tokens"),
-			CacheReadTokens:   record.GetInt("cache_read_tokens"),
-			ReasoningTokens:   record.GetInt("reasoning_tokens"),
-			CreatedAt:         record.GetString("created_at"),
-			IsStream:          record.GetBool("is_stream"),
-			DurationSec:       record.GetFloat64("duration_sec"),
-		}
-		ls.decorateCost(&logEntry)
+		logEntry := requestLogFromRecord(record)
+		ls.decorateCost(&logEntry, markups)
 		logs = append(logs, logEntry)
 	}
 	return logs, nil
 }
 
+func requestLogFromRecord(record xdb.Record) ReqeustLog {
+	return ReqeustLog{
+		ID:                record.GetInt64("id"),
+		Platform:          record.GetString("platform"),
+		Model:             record.GetString("model"),
+		Provider:          record.GetString("provider"),
+		HttpCode:          record.GetInt("http_code"),
+		InputTokens:       record.GetInt("input_tokens"),
+		OutputTokens:      record.GetInt("output_tokens"),
+		CacheCreateTokens: record.GetInt("cache_create_tokens"),
+		CacheReadTokens:   record.GetInt("cache_read_tokens"),
+		ReasoningTokens:   record.GetInt("reasoning_tokens"),
+		InputAudioTokens:  record.GetInt("input_audio_tokens"),
+		OutputAudioTokens: record.GetInt("output_audio_tokens"),
+		InputImages:       record.GetInt("input_images"),
+		OutputImages:      record.GetInt("output_images"),
+		WebSearchCalls:    record.GetInt("web_search_calls"),
+		CodeExecutions:    record.GetInt("code_executions"),
+		Queries:           record.GetInt("queries"),
+		KeyHint:           record.GetString("key_hint"),
+		CreatedAt:         record.GetString("created_at"),
+		IsStream:          record.GetBool("is_stream"),
+		DurationSec:       record.GetFloat64("duration_sec"),
+		OriginalCost:      record.GetFloat64("original_cost"),
+		RepricedCost:      record.GetFloat64("repriced_cost"),
+		RepricedAt:        record.GetString("repriced_at"),
+		RequestID:         record.GetString("request_id"),
+		Attempt:           record.GetInt("attempt"),
+		UsageEstimated:    record.GetBool("usage_estimated"),
+		ServiceTier:       record.GetString("service_tier"),
+		PricingVersion:    record.GetString("pricing_version"),
+		ReportedCost:      record.GetFloat64("reported_cost"),
+		CacheHit:          record.GetBool("cache_hit"),
+		CacheSavedCost:    record.GetFloat64("cache_saved_cost"),
+		Listener:          record.GetString("listener"),
+	}
+}
+
 func (ls *LogService) ListProviders(platform string) ([]string, error) {
 	model := xdb.New("request_log")
 	options := []xdb.Option{
@@ -111,12 +139,23 @@ func (ls *LogService) HeatmapStats(days int) ([]HeatmapStat, error) {
 	options := []xdb.Option{
 		xdb.WhereGe("created_at", rangeStart.Format(timeLayout)),
 		xdb.Field(
+			"platform",
+			"provider",
 			"model",
 			"input_tokens",
 			"output_tokens",
 			"reasoning_tokens",
 			"cache_create_tokens",
 			"cache_read_tokens",
+			"input_audio_tokens",
+			"output_audio_tokens",
+			"input_images",
+			"output_images",
+			"web_search_calls",
+			"code_executions",
+			"queries",
+			"service_tier",
+			"pricing_version",
 			"created_at",
 		),
 		xdb.OrderByDesc("created_at"),
@@ -128,6 +167,7 @@ func (ls *LogService) HeatmapStats(days int) ([]HeatmapStat, error) {
 		}
 		return nil, err
 	}
+	markups := loadProviderMarkups()
 	hourBuckets := map[int64]*HeatmapStat{}
 	for _, record := range records {
 		createdAt, _ := parseCreatedAt(record)
@@ -145,18 +185,11 @@ func (ls *LogService) HeatmapStats(days int) ([]HeatmapStat, error) {
 		input := record.GetInt("input_tokens")
 		output := record.GetInt("output_tokens")
 		reasoning := record.GetInt("reasoning_tokens")
-		cacheCreate := record.GetInt("cache_create_tokens")
-		cacheRead := record.GetInt("cache_read_tokens")
 		bucket.InputTokens += int64(input)
 		bucket.OutputTokens += int64(output)
 		bucket.ReasoningTokens += int64(reasoning)
-		usage := modelpricing.UsageSnapshot{
-			InputTokens:       input,
-			OutputTokens:      output,
-			CacheCreateTokens: cacheCreate,
-			CacheReadTokens:   cacheRead,
-		}
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		usage := recordUsage(record)
+		cost := ls.calculateCost(record.GetString("pricing_version"), record.GetString("model"), usage, markups.forRecord(record)...)
 		bucket.TotalCost += cost.TotalCost
 	}
 	if len(hourBuckets) == 0 {
@@ -191,12 +224,25 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 	options := []xdb.Option{
 		xdb.WhereGte("created_at", queryStart.Format(timeLayout)),
 		xdb.Field(
+			"platform",
+			"provider",
 			"model",
 			"input_tokens",
 			"output_tokens",
 			"reasoning_tokens",
 			"cache_create_tokens",
 			"cache_read_tokens",
+			"input_audio_tokens",
+			"output_audio_tokens",
+			"input_images",
+			"output_images",
+			"web_search_calls",
+			"code_executions",
+			"queries",
+			"service_tier",
+			"pricing_version",
+			"cache_hit",
+			"cache_saved_cost",
 			"created_at",
 		),
 		xdb.OrderByAsc("created_at"),
@@ -211,6 +257,7 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 		}
 		return stats, err
 	}
+	markups := loadProviderMarkups()
 
 	seriesBuckets := make([]*LogStatsSeries, seriesHours)
 	for i := 0; i < seriesHours; i++ {
@@ -252,13 +299,8 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 		reasoning := record.GetInt("reasoning_tokens")
 		cacheCreate := record.GetInt("cache_create_tokens")
 		cacheRead := record.GetInt("cache_read_tokens")
-		usage := modelpricing.UsageSnapshot{
-			InputTokens:       input,
-			OutputTokens:      output,
-			CacheCreateTokens: cacheCreate,
-			CacheReadTokens:   cacheRead,
-		}
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		usage := recordUsage(record)
+		cost := ls.calculateCost(record.GetString("pricing_version"), record.GetString("model"), usage, markups.forRecord(record)...)
 
 		bucket.TotalRequests++
 		bucket.InputTokens += int64(input)
@@ -281,7 +323,15 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 		stats.CostOutput += cost.OutputCost
 		stats.CostCacheCreate += cost.CacheCreateCost
 		stats.CostCacheRead += cost.CacheReadCost
+		stats.CostServiceTier += cost.ServiceTierCost
+		stats.CostSurcharge += cost.SurchargeCost
+		stats.CostTool += cost.ToolCost
+		stats.CostRequest += cost.RequestCost
 		stats.CostTotal += cost.TotalCost
+		if record.GetBool("cache_hit") {
+			stats.CacheHits++
+			stats.CostSavedByCache += record.GetFloat64("cache_saved_cost")
+		}
 	}
 
 	for i := 0; i < seriesHours; i++ {
@@ -298,6 +348,46 @@ func (ls *LogService) StatsSince(platform string) (LogStats, error) {
 	return stats, nil
 }
 
+// SpentSince 返回 start 之后所有请求的费用合计（美元）
+func (ls *LogService) SpentSince(start time.Time) (float64, error) {
+	records, err := xdb.New("request_log").Selects(
+		xdb.WhereGte("created_at", start.Format(timeLayout)),
+		xdb.Field(
+			"platform",
+			"provider",
+			"model",
+			"input_tokens",
+			"output_tokens",
+			"reasoning_tokens",
+			"cache_create_tokens",
+			"cache_read_tokens",
+			"input_audio_tokens",
+			"output_audio_tokens",
+			"input_images",
+			"output_images",
+			"web_search_calls",
+			"code_executions",
+			"queries",
+			"service_tier",
+			"pricing_version",
+			"created_at",
+		),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	markups := loadProviderMarkups()
+	total := 0.0
+	for _, record := range records {
+		cost := ls.calculateCost(record.GetString("pricing_version"), record.GetString("model"), recordUsage(record), markups.forRecord(record)...)
+		total += cost.TotalCost
+	}
+	return total, nil
+}
+
 func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat, error) {
 	start := startOfDay(time.Now())
 	end := start.Add(24 * time.Hour)
@@ -306,6 +396,7 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 	options := []xdb.Option{
 		xdb.WhereGte("created_at", queryStart.Format(timeLayout)),
 		xdb.Field(
+			"platform",
 			"provider",
 			"model",
 			"http_code",
@@ -314,6 +405,15 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 			"reasoning_tokens",
 			"cache_create_tokens",
 			"cache_read_tokens",
+			"input_audio_tokens",
+			"output_audio_tokens",
+			"input_images",
+			"output_images",
+			"web_search_calls",
+			"code_executions",
+			"queries",
+			"service_tier",
+			"pricing_version",
 			"created_at",
 		),
 	}
@@ -327,6 +427,7 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 		}
 		return nil, err
 	}
+	markups := loadProviderMarkups()
 	statMap := map[string]*ProviderDailyStat{}
 	for _, record := range records {
 		provider := strings.TrimSpace(record.GetString("provider"))
@@ -355,13 +456,8 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 		reasoning := record.GetInt("reasoning_tokens")
 		cacheCreate := record.GetInt("cache_create_tokens")
 		cacheRead := record.GetInt("cache_read_tokens")
-		usage := modelpricing.UsageSnapshot{
-			InputTokens:       input,
-			OutputTokens:      output,
-			CacheCreateTokens: cacheCreate,
-			CacheReadTokens:   cacheRead,
-		}
-		cost := ls.calculateCost(record.GetString("model"), usage)
+		usage := recordUsage(record)
+		cost := ls.calculateCost(record.GetString("pricing_version"), record.GetString("model"), usage, markups.forRecord(record)...)
 		stat.TotalRequests++
 		// 只有 HTTP 200-299 才算成功，其他（包括 0）都算失败
 		if httpCode >= 200 && httpCode < 300 {
@@ -392,17 +488,11 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 	return stats, nil
 }
 
-func (ls *LogService) decorateCost(logEntry *ReqeustLog) {
+func (ls *LogService) decorateCost(logEntry *ReqeustLog, markups providerMarkups) {
 	if ls == nil || ls.pricing == nil || logEntry == nil {
 		return
 	}
-	usage := modelpricing.UsageSnapshot{
-		InputTokens:       logEntry.InputTokens,
-		OutputTokens:      logEntry.OutputTokens,
-		CacheCreateTokens: logEntry.CacheCreateTokens,
-		CacheReadTokens:   logEntry.CacheReadTokens,
-	}
-	cost := ls.pricing.CalculateCost(logEntry.Model, usage)
+	cost := ls.calculateCost(logEntry.PricingVersion, logEntry.Model, logEntry.usageSnapshot(), markups.forEntry(logEntry)...)
 	logEntry.HasPricing = cost.HasPricing
 	logEntry.InputCost = cost.InputCost
 	logEntry.OutputCost = cost.OutputCost
@@ -410,14 +500,106 @@ func (ls *LogService) decorateCost(logEntry *ReqeustLog) {
 	logEntry.CacheReadCost = cost.CacheReadCost
 	logEntry.Ephemeral5mCost = cost.Ephemeral5mCost
 	logEntry.Ephemeral1hCost = cost.Ephemeral1hCost
+	logEntry.ImageCost = cost.ImageCost
+	logEntry.AudioCost = cost.AudioCost
+	logEntry.ToolCost = cost.ToolCost
+	logEntry.RequestCost = cost.RequestCost
 	logEntry.TotalCost = cost.TotalCost
+	logEntry.SurchargeCost = cost.SurchargeCost
+	logEntry.ServiceTierCost = cost.ServiceTierCost
 }
 
-func (ls *LogService) calculateCost(model string, usage modelpricing.UsageSnapshot) modelpricing.CostBreakdown {
+// calculateCost 按写入日志时的价格数据版本计算费用，快照不存在（已清理或为旧日志）时使用当前价格
+func (ls *LogService) calculateCost(version string, model string, usage modelpricing.UsageSnapshot, opts ...modelpricing.CostOption) modelpricing.CostBreakdown {
 	if ls == nil || ls.pricing == nil {
 		return modelpricing.CostBreakdown{}
 	}
-	return ls.pricing.CalculateCost(model, usage)
+	cost, err := ls.pricing.CalculateCostAt(version, model, usage, opts...)
+	if err != nil {
+		cost = ls.pricing.CalculateCost(model, usage, opts...)
+	}
+	warnAmbiguousMatch(model, cost.Match)
+	return cost
+}
+
+// 已提示过的模糊匹配（模型名 -> 价格条目），每种匹配只提示一次
+var ambiguousMatches sync.Map
+
+// warnAmbiguousMatch 提示模型名模糊匹配到多个价格条目，费用可能按错误的模型计算
+func warnAmbiguousMatch(model string, match modelpricing.ModelMatch) {
+	if !match.Ambiguous() {
+		return
+	}
+	if _, seen := ambiguousMatches.LoadOrStore(model+"\x00"+match.Key, true); seen {
+		return
+	}
+	fmt.Printf("[WARN] 模型 %s 没有精确的价格条目，按 %s 计费（候选: %s），可在价格覆盖中添加该模型或开启严格匹配\n",
+		model, match.Key, strings.Join(match.Candidates, ", "))
+}
+
+// RefreshPricing 立即从远程拉取最新价格数据（忽略缓存有效期），返回新的更新时间
+func (ls *LogService) RefreshPricing() (time.Time, error) {
+	if ls == nil || ls.pricing == nil {
+		return time.Time{}, errors.New("价格服务未初始化")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := ls.pricing.ForceRefresh(ctx); err != nil {
+		return ls.pricing.LastUpdated(), err
+	}
+	return ls.pricing.LastUpdated(), nil
+}
+
+// PricingInfo 返回当前价格数据的来源、版本、更新时间与模型数量，用于展示费用数据是否过期
+func (ls *LogService) PricingInfo() modelpricing.Info {
+	if ls == nil || ls.pricing == nil {
+		return modelpricing.Info{}
+	}
+	return ls.pricing.Info()
+}
+
+// ModelContextWindow 描述模型的上下文上限，Known 为 false 表示价格数据中没有该模型的上限
+type ModelContextWindow struct {
+	Model           string `json:"model"`
+	MaxInputTokens  int    `json:"max_input_tokens"`
+	MaxOutputTokens int    `json:"max_output_tokens"`
+	Known           bool   `json:"known"`
+}
+
+// PricedModels 返回价格数据中的所有模型名，用于校验与补全 provider 配置中的模型名
+func (ls *LogService) PricedModels() []string {
+	if ls == nil || ls.pricing == nil {
+		return []string{}
+	}
+	return ls.pricing.ListModels()
+}
+
+// ContextWindow 返回模型的最大输入与输出 tokens
+func (ls *LogService) ContextWindow(model string) ModelContextWindow {
+	window := ModelContextWindow{Model: model}
+	if ls == nil || ls.pricing == nil {
+		return window
+	}
+	window.MaxInputTokens, window.MaxOutputTokens, window.Known = ls.pricing.ContextWindow(model)
+	return window
+}
+
+// CurrencyInfo 描述报表显示费用使用的货币，费用字段始终为美元，前端按 Rate 换算
+type CurrencyInfo struct {
+	Currency string  `json:"currency"`
+	Symbol   string  `json:"symbol"`
+	Rate     float64 `json:"rate"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// CurrencyInfo 返回配置的目标货币与当前汇率，汇率不可用时回退为美元
+func (ls *LogService) CurrencyInfo() CurrencyInfo {
+	converter := modelpricing.DefaultConverter()
+	rate, err := converter.Rate()
+	if err != nil {
+		return CurrencyInfo{Currency: modelpricing.CurrencyUSD, Symbol: modelpricing.CurrencySymbol(modelpricing.CurrencyUSD), Rate: 1, Error: err.Error()}
+	}
+	return CurrencyInfo{Currency: converter.Target(), Symbol: modelpricing.CurrencySymbol(converter.Target()), Rate: rate}
 }
 
 func parseCreatedAt(record xdb.Record) (time.Time, bool) {
@@ -517,6 +699,12 @@ type LogStats struct {
 	CostOutput        float64          `json:"cost_output"`
 	CostCacheCreate   float64          `json:"cost_cache_create"`
 	CostCacheRead     float64          `json:"cost_cache_read"`
+	CostServiceTier   float64          `json:"cost_service_tier"` // priority / flex 相对标准价格的差额
+	CostSurcharge     float64          `json:"cost_surcharge"`    // provider 按次附加费合计
+	CostTool          float64          `json:"cost_tool"`         // 网页搜索、代码执行等服务端工具的费用合计
+	CostRequest       float64          `json:"cost_request"`      // 重排序等按次计费的费用合计
+	CacheHits         int64            `json:"cache_hits"`        // 命中响应缓存的请求数
+	CostSavedByCache  float64          `json:"cost_saved_by_cache"` // 命中响应缓存节省的费用合计
 	Series            []LogStatsSeries `json:"series"`
 }
 
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+// RequestLogFilter 描述实时日志的过滤条件
+type RequestLogFilter struct {
+	Platform   string
+	Provider   string
+	Key        string  // 完整 Key 或脱敏后的 key_hint 片段
+	ErrorsOnly bool    // 只保留非 2xx 的请求
+	MinCost    float64 // 只保留费用不低于该值（美元）的请求
+}
+
+// Match 判断一条日志是否满足过滤条件（日志需已计算费用）
+func (f RequestLogFilter) Match(entry ReqeustLog) bool {
+	if f.Platform != "" && !strings.EqualFold(entry.Platform, f.Platform) {
+		return false
+	}
+	if f.Provider != "" && !strings.EqualFold(entry.Provider, f.Provider) {
+		return false
+	}
+	if f.Key != "" {
+		hint := entry.KeyHint
+		if hint != maskAPIKey(f.Key) && !strings.Contains(hint, f.Key) {
+			return false
+		}
+	}
+	if f.ErrorsOnly && entry.HttpCode >= 200 && entry.HttpCode < 300 {
+		return false
+	}
+	if f.MinCost > 0 && entry.TotalCost < f.MinCost {
+		return false
+	}
+	return true
+}
+
+// RecentRequestLogs 返回最近 limit 条满足过滤条件的日志（按 id 升序）
+func (ls *LogService) RecentRequestLogs(filter RequestLogFilter, limit int) ([]ReqeustLog, error) {
+	if limit <= 0 {
+		return []ReqeustLog{}, nil
+	}
+	logs, err := ls.ListRequestLogs(filter.Platform, filter.Provider, 1000)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return []ReqeustLog{}, nil
+		}
+		return nil, err
+	}
+	matched := make([]ReqeustLog, 0, limit)
+	for _, entry := range logs {
+		if filter.Match(entry) {
+			matched = append(matched, entry)
+			if len(matched) >= limit {
+				break
+			}
+		}
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, nil
+}
+
+// FollowRequestLogs 持续轮询 request_log，将 afterID 之后新写入且满足过滤条件的日志回调给 fn
+// request_log 由 relay 进程写入，命令行通过共享的 sqlite 文件读取，因此采用轮询而非进程内事件
+func (ls *LogService) FollowRequestLogs(ctx context.Context, filter RequestLogFilter, afterID int64, interval time.Duration, fn func(ReqeustLog)) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastID := afterID
+	for {
+		markups := loadProviderMarkups()
+		options := []xdb.Option{
+			xdb.WhereGt("id", lastID),
+			xdb.OrderByAsc("id"),
+			xdb.Limit(500),
+		}
+		if filter.Platform != "" {
+			options = append(options, xdb.WhereEq("platform", filter.Platform))
+		}
+		if filter.Provider != "" {
+			options = append(options, xdb.WhereEq("provider", filter.Provider))
+		}
+		records, err := xdb.New("request_log").Selects(options...)
+		if err != nil && !errors.Is(err, xdb.ErrNotFound) && !isNoSuchTableErr(err) && !isDatabaseLockedErr(err) {
+			return err
+		}
+		for _, record := range records {
+			entry := requestLogFromRecord(record)
+			if entry.ID > lastID {
+				lastID = entry.ID
+			}
+			ls.decorateCost(&entry, markups)
+			if filter.Match(entry) {
+				fn(entry)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// isDatabaseLockedErr 判断是否为 relay 写入日志时的短暂锁冲突，跟踪日志时在下一轮重试即可
+func isDatabaseLockedErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// LatestRequestLogID 返回当前最大的日志 id，用于只跟踪之后的新日志
+func (ls *LogService) LatestRequestLogID() (int64, error) {
+	record, err := xdb.New("request_log").First(xdb.OrderByDesc("id"), xdb.Field("id"))
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return record.GetInt64("id"), nil
+}
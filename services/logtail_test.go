@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+func TestRequestLogFilterMatch(t *testing.T) {
+	entry := ReqeustLog{Platform: "claude", Provider: "Reseller", KeyHint: maskAPIKey("sk-ant-1234567890"), HttpCode: http.StatusOK, TotalCost: 0.05}
+	failed := entry
+	failed.HttpCode = http.StatusTooManyRequests
+	tests := []struct {
+		name   string
+		filter RequestLogFilter
+		entry  ReqeustLog
+		want   bool
+	}{
+		{"无条件", RequestLogFilter{}, entry, true},
+		{"平台不区分大小写", RequestLogFilter{Platform: "Claude"}, entry, true},
+		{"平台不匹配", RequestLogFilter{Platform: "codex"}, entry, false},
+		{"provider 不区分大小写", RequestLogFilter{Provider: "reseller"}, entry, true},
+		{"provider 不匹配", RequestLogFilter{Provider: "official"}, entry, false},
+		{"完整 Key", RequestLogFilter{Key: "sk-ant-1234567890"}, entry, true},
+		{"key_hint 片段", RequestLogFilter{Key: "7890"}, entry, true},
+		{"Key 不匹配", RequestLogFilter{Key: "sk-other-0987654321"}, entry, false},
+		{"只看错误时跳过 2xx", RequestLogFilter{ErrorsOnly: true}, entry, false},
+		{"只看错误", RequestLogFilter{ErrorsOnly: true}, failed, true},
+		{"费用达到下限", RequestLogFilter{MinCost: 0.05}, entry, true},
+		{"费用低于下限", RequestLogFilter{MinCost: 0.06}, entry, false},
+		{"多个条件同时满足", RequestLogFilter{Platform: "claude", Provider: "reseller", Key: "7890", ErrorsOnly: true}, failed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.entry); got != tt.want {
+				t.Errorf("Match = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowRequestLogs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	insert := func(platform string, provider string, code int) {
+		if _, err := xdb.New("request_log", xdb.WithSaveZero()).Insert(xdb.Record{
+			"platform": platform, "provider": provider, "model": "claude-sonnet-4-5", "http_code": code,
+			"input_tokens": 100, "output_tokens": 10, "created_at": time.Now().Format(timeLayout),
+		}); err != nil {
+			t.Fatalf("写入请求日志失败: %v", err)
+		}
+	}
+	ls := NewLogService()
+	if id, err := ls.LatestRequestLogID(); err != nil || id != 0 {
+		t.Fatalf("空表的最大 id 应为 0: %d %v", id, err)
+	}
+	insert("claude", "reseller", http.StatusBadGateway)
+	afterID, err := ls.LatestRequestLogID()
+	if err != nil || afterID != 1 {
+		t.Fatalf("LatestRequestLogID = %d %v", afterID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan ReqeustLog, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- ls.FollowRequestLogs(ctx, RequestLogFilter{Platform: "claude", ErrorsOnly: true}, afterID, 10*time.Millisecond, func(entry ReqeustLog) {
+			received <- entry
+		})
+	}()
+
+	// 开始跟踪之后新写入的日志，afterID 之前的与不满足过滤条件的日志都不回调
+	insert("claude", "reseller", http.StatusOK)
+	insert("codex", "reseller", http.StatusBadGateway)
+	insert("claude", "official", http.StatusTooManyRequests)
+	select {
+	case entry := <-received:
+		if entry.ID != 4 || entry.Provider != "official" || entry.HttpCode != http.StatusTooManyRequests || entry.TotalCost <= 0 {
+			t.Fatalf("应回调满足过滤条件的新日志（含费用）: %+v", entry)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("未收到新写入的日志")
+	}
+	insert("claude", "reseller", http.StatusServiceUnavailable)
+	select {
+	case entry := <-received:
+		if entry.ID != 5 {
+			t.Fatalf("应按写入顺序继续回调: %+v", entry)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("未收到后续写入的日志")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("ctx 取消后应正常结束: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ctx 取消后应停止跟踪")
+	}
+	if len(received) != 0 {
+		t.Fatalf("不应回调其他日志: %+v", <-received)
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// 未配置阈值时，提示词超过该估算 token 数视为长提示词（标准上下文窗口为 200k，预留输出空间）
+	defaultLongPromptTokens = 180000
+	// 1M 上下文模型的后缀，计价时按长上下文单价计费
+	longContextSuffix = "[1m]"
+	// Anthropic 开启 1M 上下文窗口的 beta
+	longContextBeta = "context-1m-2025-08-07"
+)
+
+// LongPromptConfig 将提示词过长的请求路由到 1M 上下文窗口的模型
+type LongPromptConfig struct {
+	// 提示词估算 token 数超过该值时生效，默认 180000
+	ThresholdTokens int `json:"thresholdTokens,omitempty"`
+	// 长提示词请求使用的模型，留空时在请求的模型名后加 [1m]（如 claude-sonnet-4-5[1m]），按长上下文单价计费
+	Model string `json:"model,omitempty"`
+	// 只路由到这些 provider，留空时为所有支持该模型的 provider
+	Providers []string `json:"providers,omitempty"`
+}
+
+func (c *LongPromptConfig) threshold() int {
+	if c.ThresholdTokens > 0 {
+		return c.ThresholdTokens
+	}
+	return defaultLongPromptTokens
+}
+
+// longPromptTokens 在配置了长提示词路由时估算请求的输入 token 数，未配置时返回 0
+func (c RoutingConfig) longPromptTokens(kind string, model string, body []byte) int {
+	if c.LongPrompt == nil || model == "" {
+		return 0
+	}
+	tokens, _ := estimateInputTokens(kind, model, body)
+	return tokens
+}
+
+// routeLongPrompt 在提示词超过阈值时将候选 provider 换成长上下文模型；
+// 没有 provider 支持长上下文模型时返回 nil，按原模型路由
+func (c RoutingConfig) routeLongPrompt(candidates []Provider, requestedModel string, promptTokens int) []Provider {
+	rule := c.LongPrompt
+	if rule == nil || promptTokens <= rule.threshold() {
+		return nil
+	}
+	routed := make([]Provider, 0, len(candidates))
+	available := false
+	for _, provider := range candidates {
+		if len(rule.Providers) > 0 && !slices.Contains(rule.Providers, provider.Name) {
+			continue
+		}
+		model := strings.TrimSpace(rule.Model)
+		if model == "" {
+			model = provider.routeModel(requestedModel)
+			if !strings.HasSuffix(strings.ToLower(model), longContextSuffix) {
+				model += longContextSuffix
+			}
+		}
+		provider.routedModel = model
+		if routeSkipReason(provider, model) == "" {
+			available = true
+		}
+		routed = append(routed, provider)
+	}
+	if !available {
+		fmt.Printf("[WARN] 提示词约 %d tokens 超过 %d，但没有可用的 provider 支持长上下文模型，按原模型路由\n", promptTokens, rule.threshold())
+		return nil
+	}
+	fmt.Printf("[INFO] 提示词约 %d tokens 超过 %d，路由到长上下文模型\n", promptTokens, rule.threshold())
+	return routed
+}
+
+// longContextUpstreamModel 返回发送给 provider 的模型名：[1m] 后缀只用于路由与计价，发送前去掉；
+// Anthropic 协议的 claude provider 改为通过 anthropic-beta 请求头开启 1M 上下文
+func longContextUpstreamModel(model string) (string, bool) {
+	if len(model) > len(longContextSuffix) && strings.EqualFold(model[len(model)-len(longContextSuffix):], longContextSuffix) {
+		return model[:len(model)-len(longContextSuffix)], true
+	}
+	return model, false
+}
+
+// addLongContextBeta 在 anthropic-beta 请求头中追加 1M 上下文的 beta
+func addLongContextBeta(headers map[string]string) {
+	existing := strings.TrimSpace(headers["Anthropic-Beta"])
+	for _, beta := range strings.Split(existing, ",") {
+		if strings.TrimSpace(beta) == longContextBeta {
+			return
+		}
+	}
+	if existing == "" {
+		headers["Anthropic-Beta"] = longContextBeta
+	} else {
+		headers["Anthropic-Beta"] = existing + "," + longContextBeta
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestRelayRoutesLongPromptToLongContextModel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	var beta string
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			hits = append(hits, name+":"+gjson.GetBytes(body, "model").String())
+			beta = r.Header.Get("anthropic-beta")
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":250000,\"output_tokens\":1}}}\n\n")
+			fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":100}}\n\n")
+		}
+	}
+	standard := httptest.NewServer(handler("standard"))
+	defer standard.Close()
+	long := httptest.NewServer(handler("long"))
+	defer long.Close()
+
+	ps := NewProviderService()
+	standardProvider := Provider{ID: 1, Name: "standard", APIURL: standard.URL, APIKey: "sk-std-1234567890", Enabled: true,
+		SupportedModels: map[string]bool{"claude-sonnet-4-5": true}}
+	if err := ps.SaveProviders("claude", []Provider{
+		standardProvider,
+		{ID: 2, Name: "long", APIURL: long.URL, APIKey: "sk-long-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-5[1m]": true}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Routing.LongPrompt = &LongPromptConfig{ThresholdTokens: 1000}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(prompt string) {
+		hits = nil
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"`+prompt+`"}]}`))
+		req.Header.Set("anthropic-beta", "fine-grained-tool-streaming-2025-05-14")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	post("hi")
+	if strings.Join(hits, ",") != "standard:claude-sonnet-4-5" || strings.Contains(beta, longContextBeta) {
+		t.Fatalf("短提示词应按原模型路由: %v %q", hits, beta)
+	}
+
+	longPrompt := strings.Repeat("lorem ipsum ", 1000)
+	post(longPrompt)
+	if strings.Join(hits, ",") != "long:claude-sonnet-4-5" || beta != "fine-grained-tool-streaming-2025-05-14,"+longContextBeta {
+		t.Fatalf("长提示词应路由到 1M 上下文模型，并去掉后缀改用 beta 请求头: %v %q", hits, beta)
+	}
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "long"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	pricing, err := modelpricing.DefaultService()
+	if err != nil {
+		t.Fatalf("加载价格失败: %v", err)
+	}
+	standardCost := pricing.CalculateCost("claude-sonnet-4-5", modelpricing.UsageSnapshot{InputTokens: 250000, OutputTokens: 100})
+	if entry := requestLogFromRecord(record); entry.Model != "claude-sonnet-4-5[1m]" || record.GetFloat64("original_cost") <= standardCost.TotalCost {
+		t.Fatalf("应按长上下文单价计费: %+v cost=%v standard=%v", entry, record.GetFloat64("original_cost"), standardCost.TotalCost)
+	}
+
+	// 没有 provider 支持长上下文模型时按原模型路由
+	if err := ps.SaveProviders("claude", []Provider{standardProvider}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	post(longPrompt)
+	if strings.Join(hits, ",") != "standard:claude-sonnet-4-5" {
+		t.Fatalf("没有长上下文 provider 时应按原模型路由: %v", hits)
+	}
+}
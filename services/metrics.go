@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsConfig 控制 /metrics 接口（Prometheus 文本格式）
+type MetricsConfig struct {
+	// 允许非本机地址抓取，作为共享网关运行时由监控系统远程抓取
+	AllowRemote bool `json:"allowRemote,omitempty"`
+}
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsHandler 以 Prometheus 文本格式输出价格数据的新鲜度与更新结果，用于价格数据过期告警
+func (prs *ProviderRelayService) metricsHandler(c *gin.Context) {
+	if !prs.loadRelayConfig().Metrics.AllowRemote {
+		if ip := net.ParseIP(c.RemoteIP()); ip == nil || !ip.IsLoopback() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "metrics 接口只允许本机访问，可在配置中开启 metrics.allowRemote"})
+			return
+		}
+	}
+	var metrics metricsWriter
+	if pricing, err := modelpricing.DefaultService(); err == nil && pricing != nil {
+		writePricingMetrics(&metrics, pricing.Info(), time.Now())
+	}
+	writeDedupMetrics(&metrics, prs.DedupStats())
+	c.Data(http.StatusOK, metricsContentType, []byte(metrics.String()))
+}
+
+// writePricingMetrics 输出价格数据指标：数据年龄按最后一次确认数据为最新（CheckedAt）计算，从未确认时为 +Inf
+func writePricingMetrics(metrics *metricsWriter, info modelpricing.Info, now time.Time) {
+	age := math.Inf(1)
+	switch {
+	case info.Source == modelpricing.SourceProvided:
+		age = 0
+	case !info.CheckedAt.IsZero():
+		age = now.Sub(info.CheckedAt).Seconds()
+	}
+	metrics.gauge("pricing_data_age_seconds", "距离价格数据最后一次确认为最新的秒数", age)
+	metrics.counter("pricing_update_success_total", "价格数据远程更新成功的次数（含数据未变化）", float64(info.UpdateSuccessTotal))
+	metrics.counter("pricing_update_failure_total", "价格数据远程更新失败的次数", float64(info.UpdateFailureTotal))
+	metrics.gauge("pricing_models_count", "当前价格数据中的模型数量", float64(info.ModelCount))
+	metrics.gauge("pricing_data_stale", "价格数据超过更新间隔未确认为最新时为 1", boolGauge(info.Stale))
+	metrics.gauge("pricing_offline", "离线模式（不从远程更新）时为 1", boolGauge(info.Offline))
+}
+
+// metricsWriter 按 Prometheus 文本格式拼接指标
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (w *metricsWriter) gauge(name string, help string, value float64) {
+	w.write(name, "gauge", help, value)
+}
+
+func (w *metricsWriter) counter(name string, help string, value float64) {
+	w.write(name, "counter", help, value)
+}
+
+func (w *metricsWriter) write(name string, kind string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatMetricValue(value))
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func boolGauge(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	rcs := NewRelayConfigService()
+	relay := NewProviderRelayService(NewProviderService(), rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("127.0.0.1:40000")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("本机抓取 metrics 失败 %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, want := range []string{
+		"# TYPE pricing_data_age_seconds gauge",
+		"# TYPE pricing_update_success_total counter",
+		"# TYPE pricing_update_failure_total counter",
+		"# TYPE pricing_models_count gauge",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics 缺少 %q:\n%s", want, rec.Body.String())
+		}
+	}
+
+	if rec := get("192.0.2.1:40000"); rec.Code != http.StatusForbidden {
+		t.Fatalf("默认应拒绝远程抓取: %d", rec.Code)
+	}
+	cfg := defaultRelayConfig()
+	cfg.Metrics.AllowRemote = true
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	if rec := get("192.0.2.1:40000"); rec.Code != http.StatusOK {
+		t.Fatalf("开启 allowRemote 后应允许远程抓取: %d", rec.Code)
+	}
+}
+
+func TestWritePricingMetrics(t *testing.T) {
+	now := time.Now()
+	var metrics metricsWriter
+	writePricingMetrics(&metrics, modelpricing.Info{
+		Source:             modelpricing.SourceRemote,
+		CheckedAt:          now.Add(-90 * time.Second),
+		ModelCount:         42,
+		UpdateSuccessTotal: 3,
+		UpdateFailureTotal: 1,
+		Stale:              true,
+	}, now)
+	for _, want := range []string{
+		"\npricing_data_age_seconds 90\n",
+		"\npricing_update_success_total 3\n",
+		"\npricing_update_failure_total 1\n",
+		"\npricing_models_count 42\n",
+		"\npricing_data_stale 1\n",
+		"\npricing_offline 0\n",
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Fatalf("metrics 缺少 %q:\n%s", want, metrics.String())
+		}
+	}
+
+	// 从未确认过的内置数据视为无限旧
+	metrics = metricsWriter{}
+	writePricingMetrics(&metrics, modelpricing.Info{Source: modelpricing.SourceEmbedded}, now)
+	if !strings.Contains(metrics.String(), "\npricing_data_age_seconds +Inf\n") {
+		t.Fatalf("未确认的数据年龄应为 +Inf:\n%s", metrics.String())
+	}
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/daodao97/xgo/xrequest"
+)
+
+// 内置中间件的顺序，AddMiddleware 按 order 从小到大执行，order 相同时按注册顺序执行
+const (
+	MiddlewareOrderHeaders = 100
+	MiddlewareOrderModel   = 200
+	MiddlewareOrderUsage   = 300
+)
+
+// Middleware 是转发链上的处理器：请求发往 provider 之前依次调用 ProcessRequest，
+// 成功的响应依次经过 ProcessResponse（非流式）或 ProcessStreamEvent（流式，逐行）。
+// 内置的 header 注入（headers）、模型映射（model）与用量解析（usage）也是中间件，
+// 集成方实现该接口并通过 ProviderRelayService.AddMiddleware 注册即可加入自定义逻辑，只需处理部分阶段时可以嵌入 BaseMiddleware
+type Middleware interface {
+	// Name 标识中间件，注册同名的中间件时替换原有的（可用于替换内置实现）
+	Name() string
+	// ProcessRequest 可以修改发往 provider 的请求头与请求体，返回错误时跳过该 provider
+	ProcessRequest(req *MiddlewareRequest) error
+	// ProcessResponse 处理完整的非流式响应体，返回写给客户端的内容
+	ProcessResponse(resp *MiddlewareResponse, body []byte) []byte
+	// ProcessStreamEvent 处理流式响应的一行（不含换行符，事件之间的空行不经过中间件），返回空时丢弃该行
+	ProcessStreamEvent(resp *MiddlewareResponse, line []byte) []byte
+}
+
+// BaseMiddleware 提供原样透传的默认实现
+type BaseMiddleware struct{}
+
+func (BaseMiddleware) ProcessRequest(*MiddlewareRequest) error { return nil }
+
+func (BaseMiddleware) ProcessResponse(_ *MiddlewareResponse, body []byte) []byte { return body }
+
+func (BaseMiddleware) ProcessStreamEvent(_ *MiddlewareResponse, line []byte) []byte { return line }
+
+// MiddlewareRequest 描述发往一个 provider 的请求，同一 provider 上的重试与轮换 Key 共用处理结果
+type MiddlewareRequest struct {
+	// Platform 为 claude 或 codex，Endpoint 为客户端请求的接口（如 /v1/messages）
+	Platform string
+	Endpoint string
+	Provider Provider
+	// RequestedModel 为客户端请求的模型，Model 为发往 provider 的模型（模型映射之后，不含 [1m] 后缀）
+	RequestedModel string
+	Model          string
+	RequestID      string
+	SessionID      string
+	IsStream       bool
+	// Headers 为发往 provider 的请求头，认证在所有中间件之后添加
+	Headers map[string]string
+
+	body      *requestBody
+	data      []byte
+	loaded    bool
+	rewritten bool
+}
+
+// Body 返回当前的请求体（客户端协议的格式，system prompt、cache_control 与协议转换在所有中间件之后处理）。
+// 返回的内容不能原地修改，修改后通过 SetBody 设置
+func (r *MiddlewareRequest) Body() ([]byte, error) {
+	if !r.loaded {
+		data, err := r.body.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		r.data, r.loaded = data, true
+	}
+	return r.data, nil
+}
+
+// SetBody 替换发往 provider 的请求体
+func (r *MiddlewareRequest) SetBody(data []byte) {
+	r.data, r.loaded, r.rewritten = data, true, true
+}
+
+// MiddlewareResponse 描述一次上游尝试的成功响应，内容已转换为客户端协议
+type MiddlewareResponse struct {
+	Platform       string
+	Endpoint       string
+	Provider       Provider
+	RequestedModel string
+	Model          string
+	RequestID      string
+	// IsStream 为 true 时响应逐行经过 ProcessStreamEvent
+	IsStream   bool
+	StatusCode int
+	// Header 为上游的响应头，中间件执行时已写给客户端
+	Header http.Header
+	// Log 为本次尝试的请求日志，用量写入其中，响应结束后保存
+	Log *ReqeustLog
+}
+
+type middlewareEntry struct {
+	order      int
+	middleware Middleware
+}
+
+// middlewareSet 保存按顺序排列的中间件
+type middlewareSet struct {
+	mu      sync.RWMutex
+	entries []middlewareEntry
+}
+
+func (ms *middlewareSet) add(order int, middleware Middleware) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	entries := make([]middlewareEntry, 0, len(ms.entries)+1)
+	for _, entry := range ms.entries {
+		if entry.middleware.Name() != middleware.Name() {
+			entries = append(entries, entry)
+		}
+	}
+	i := len(entries)
+	for i > 0 && entries[i-1].order > order {
+		i--
+	}
+	ms.entries = append(entries[:i], append([]middlewareEntry{{order: order, middleware: middleware}}, entries[i:]...)...)
+}
+
+func (ms *middlewareSet) list() []Middleware {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	middlewares := make([]Middleware, len(ms.entries))
+	for i, entry := range ms.entries {
+		middlewares[i] = entry.middleware
+	}
+	return middlewares
+}
+
+// processRequest 依次执行 ProcessRequest，中间件 panic 时按错误处理
+func (ms *middlewareSet) processRequest(req *MiddlewareRequest) error {
+	for _, middleware := range ms.list() {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return middleware.ProcessRequest(req)
+		}()
+		if err != nil {
+			return fmt.Errorf("中间件 %s 处理请求失败: %w", middleware.Name(), err)
+		}
+	}
+	return nil
+}
+
+// responseHook 返回依次执行响应阶段的钩子，中间件 panic 时保留它之前的处理结果
+func (ms *middlewareSet) responseHook(resp *MiddlewareResponse) xrequest.ResponseHook {
+	middlewares := ms.list()
+	return func(data []byte) (bool, []byte) {
+		for _, middleware := range middlewares {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						fmt.Printf("[ERROR] middleware %s panic: %v\n", middleware.Name(), r)
+					}
+				}()
+				if resp.IsStream {
+					data = middleware.ProcessStreamEvent(resp, data)
+				} else {
+					data = middleware.ProcessResponse(resp, data)
+				}
+			}()
+			if resp.IsStream && len(data) == 0 {
+				return true, nil
+			}
+		}
+		return true, data
+	}
+}
+
+// headerMiddleware 添加 OpenRouter 的归属 header 与 provider 配置的自定义 header
+type headerMiddleware struct{ BaseMiddleware }
+
+func (headerMiddleware) Name() string { return "headers" }
+
+func (headerMiddleware) ProcessRequest(req *MiddlewareRequest) error {
+	if req.Provider.OpenRouter != nil {
+		req.Provider.OpenRouter.setHeaders(req.Headers)
+	}
+	req.Provider.Headers.apply(req.Headers, headerTemplateValues{
+		model:     req.Model,
+		provider:  req.Provider.Name,
+		platform:  req.Platform,
+		requestID: req.RequestID,
+		sessionID: req.SessionID,
+	})
+	return nil
+}
+
+// modelMiddleware 将请求体中的模型替换为映射后的模型
+type modelMiddleware struct{ BaseMiddleware }
+
+func (modelMiddleware) Name() string { return "model" }
+
+func (modelMiddleware) ProcessRequest(req *MiddlewareRequest) error {
+	if req.RequestedModel == "" || req.Model == req.RequestedModel {
+		return nil
+	}
+	data, err := req.Body()
+	if err != nil {
+		return err
+	}
+	if data, err = ReplaceModelInRequestBody(data, req.Model); err != nil {
+		return err
+	}
+	req.SetBody(data)
+	return nil
+}
+
+// usageMiddleware 从响应中解析用量写入请求日志
+type usageMiddleware struct{ BaseMiddleware }
+
+func (usageMiddleware) Name() string { return "usage" }
+
+func (m usageMiddleware) ProcessResponse(resp *MiddlewareResponse, body []byte) []byte {
+	return m.parse(resp, body)
+}
+
+func (m usageMiddleware) ProcessStreamEvent(resp *MiddlewareResponse, line []byte) []byte {
+	return m.parse(resp, line)
+}
+
+func (usageMiddleware) parse(resp *MiddlewareResponse, data []byte) []byte {
+	hook := ReqeustLogHook(nil, resp.Platform, resp.Log)
+	if isEmbeddingEndpoint(resp.Endpoint) {
+		hook = embeddingUsageHook(resp.Endpoint, resp.Log)
+	}
+	_, data = hook(data)
+	return data
+}
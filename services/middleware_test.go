@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// tenantMiddleware 为请求添加租户 header 与 metadata，并在流式响应中隐藏 ping 事件
+type tenantMiddleware struct {
+	BaseMiddleware
+	seenModel string
+}
+
+func (*tenantMiddleware) Name() string { return "tenant" }
+
+func (m *tenantMiddleware) ProcessRequest(req *MiddlewareRequest) error {
+	body, err := req.Body()
+	if err != nil {
+		return err
+	}
+	// 在内置的模型映射之后执行，请求体中已是映射后的模型
+	m.seenModel = gjson.GetBytes(body, "model").String()
+	if body, err = sjson.SetBytes(body, "metadata.user_id", "tenant-a"); err != nil {
+		return err
+	}
+	req.SetBody(body)
+	req.Headers["X-Tenant"] = req.Provider.Name + "/" + req.Model
+	return nil
+}
+
+func (*tenantMiddleware) ProcessStreamEvent(resp *MiddlewareResponse, line []byte) []byte {
+	if bytes.Contains(line, []byte("ping")) {
+		return nil
+	}
+	return line
+}
+
+type rejectMiddleware struct{ BaseMiddleware }
+
+func (rejectMiddleware) Name() string { return "reject" }
+
+func (rejectMiddleware) ProcessRequest(req *MiddlewareRequest) error {
+	if req.Provider.Name == "blocked" {
+		return errors.New("blocked")
+	}
+	return nil
+}
+
+func TestMiddlewareChainProcessesRequestAndStream(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r.Header.Get("X-Tenant")+" "+r.Header.Get("X-Custom")+" "+string(body))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+		fmt.Fprint(w, "event: ping\ndata: {\"type\":\"ping\"}\n\n")
+		fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5}}\n\n")
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "blocked", APIURL: upstream.URL, APIKey: "sk-blocked-1234567890", Enabled: true},
+		{ID: 2, Name: "primary", APIURL: upstream.URL, APIKey: "sk-primary-1234567890", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-5-20250929": true},
+			ModelMapping:    map[string]string{"claude-sonnet-4-5": "claude-sonnet-4-5-20250929"},
+			Headers:         &HeaderRules{Set: map[string]string{"X-Custom": "{provider}"}}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	tenant := &tenantMiddleware{}
+	relay.AddMiddleware(MiddlewareOrderModel+1, tenant)
+	relay.AddMiddleware(0, rejectMiddleware{})
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(received) != 1 {
+		t.Fatalf("返回错误的中间件应跳过该 provider: %v", received)
+	}
+	if !strings.HasPrefix(received[0], "primary/claude-sonnet-4-5-20250929 primary ") ||
+		!strings.Contains(received[0], `"user_id":"tenant-a"`) || tenant.seenModel != "claude-sonnet-4-5-20250929" {
+		t.Fatalf("中间件应按顺序修改请求头与请求体: %s (%s)", received[0], tenant.seenModel)
+	}
+	if strings.Contains(rec.Body.String(), "\"ping\"") || !strings.Contains(rec.Body.String(), "message_delta") {
+		t.Fatalf("应丢弃中间件过滤的行: %q", rec.Body.String())
+	}
+	records, err := xdb.New("request_log").Selects(xdb.WhereEq("provider", "primary"))
+	if err != nil || len(records) != 1 || records[0].GetInt("output_tokens") != 5 {
+		t.Fatalf("内置的用量解析应写入请求日志: %v %v", records, err)
+	}
+}
+
+type namedMiddleware struct {
+	BaseMiddleware
+	name string
+}
+
+func (m namedMiddleware) Name() string { return m.name }
+
+func TestMiddlewareSetOrdersAndReplaces(t *testing.T) {
+	var ms middlewareSet
+	ms.add(MiddlewareOrderUsage, namedMiddleware{name: "usage"})
+	ms.add(MiddlewareOrderHeaders, namedMiddleware{name: "headers"})
+	ms.add(MiddlewareOrderHeaders, namedMiddleware{name: "audit"})
+	ms.add(MiddlewareOrderUsage+1, namedMiddleware{name: "headers"})
+	var names []string
+	for _, m := range ms.list() {
+		names = append(names, m.Name())
+	}
+	if got := strings.Join(names, ","); got != "audit,usage,headers" {
+		t.Fatalf("中间件应按 order 与注册顺序排列，同名时替换: %s", got)
+	}
+}
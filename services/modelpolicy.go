@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelPolicyConfig 限制可以请求的模型，所有适用的规则都通过时才允许请求
+type ModelPolicyConfig struct {
+	Rules []ModelPolicyRule `json:"rules,omitempty"`
+}
+
+// ModelPolicyRule 是一条模型访问规则，如禁止实习生的 Key 使用 opus 模型，或禁止使用未批准的 provider
+type ModelPolicyRule struct {
+	// 规则名称，出现在拒绝请求的错误信息中
+	Name string `json:"name"`
+	// 适用的客户端 Key（客户端请求 relay 时使用的 Authorization: Bearer 或 x-api-key 的值），为空时适用于所有客户端
+	ClientKeys []string `json:"clientKeys,omitempty"`
+	// 适用的 provider，为空时检查客户端请求的模型；不为空时检查发往这些 provider 的实际模型（模型映射之后），不允许时跳过该 provider
+	Providers []string `json:"providers,omitempty"`
+	// 允许的模型，支持一个 * 通配符（如 claude-sonnet-*），为空时不限制
+	Allow []string `json:"allow,omitempty"`
+	// 禁止的模型，优先于 allow；["*"] 表示禁止全部模型
+	Deny []string `json:"deny,omitempty"`
+}
+
+func (cfg ModelPolicyConfig) validate() []string {
+	var errors []string
+	for i, rule := range cfg.Rules {
+		label := fmt.Sprintf("rules[%d]", i)
+		if rule.Name == "" {
+			errors = append(errors, label+": name 不能为空")
+		}
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			errors = append(errors, label+": allow 与 deny 不能同时为空")
+		}
+		for _, pattern := range append(append([]string{}, rule.Allow...), rule.Deny...) {
+			if strings.Count(pattern, "*") > 1 {
+				errors = append(errors, fmt.Sprintf("%s: 模型 %q 只能包含一个 * 通配符", label, pattern))
+			}
+		}
+	}
+	return errors
+}
+
+// appliesTo 判断规则是否适用于客户端 Key
+func (rule ModelPolicyRule) appliesTo(clientKey string) bool {
+	if len(rule.ClientKeys) == 0 {
+		return true
+	}
+	for _, key := range rule.ClientKeys {
+		if key != "" && key == clientKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule ModelPolicyRule) permits(model string) bool {
+	model = strings.ToLower(model)
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if matchWildcard(strings.ToLower(pattern), model) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(rule.Deny) {
+		return false
+	}
+	return len(rule.Allow) == 0 || matches(rule.Allow)
+}
+
+// deniedRequest 返回禁止客户端请求该模型的规则
+func (cfg ModelPolicyConfig) deniedRequest(clientKey string, model string) *ModelPolicyRule {
+	for i, rule := range cfg.Rules {
+		if len(rule.Providers) == 0 && rule.appliesTo(clientKey) && !rule.permits(model) {
+			return &cfg.Rules[i]
+		}
+	}
+	return nil
+}
+
+// deniedProvider 返回禁止客户端通过该 provider 请求模型的规则，请求的模型与映射后的模型都需要允许
+func (cfg ModelPolicyConfig) deniedProvider(clientKey string, provider *Provider, requestedModel string) *ModelPolicyRule {
+	model := provider.routeModel(requestedModel)
+	effective := provider.GetEffectiveModel(model)
+	for i, rule := range cfg.Rules {
+		if len(rule.Providers) == 0 || !rule.appliesTo(clientKey) {
+			continue
+		}
+		applies := false
+		for _, name := range rule.Providers {
+			if name == provider.Name {
+				applies = true
+				break
+			}
+		}
+		if applies && (!rule.permits(model) || !rule.permits(effective)) {
+			return &cfg.Rules[i]
+		}
+	}
+	return nil
+}
+
+// filterProviders 移除规则禁止的 provider，返回启用的 provider 被禁止时命中的第一条规则
+func (cfg ModelPolicyConfig) filterProviders(clientKey string, providers []Provider, requestedModel string) ([]Provider, *ModelPolicyRule) {
+	if len(cfg.Rules) == 0 {
+		return providers, nil
+	}
+	var denied *ModelPolicyRule
+	allowed := make([]Provider, 0, len(providers))
+	for _, provider := range providers {
+		if rule := cfg.deniedProvider(clientKey, &provider, requestedModel); rule != nil {
+			if provider.Enabled {
+				fmt.Printf("[INFO] Provider %s 被模型规则 %s 禁止使用模型 %s，已跳过\n", provider.Name, rule.Name, requestedModel)
+				if denied == nil {
+					denied = rule
+				}
+			}
+			continue
+		}
+		allowed = append(allowed, provider)
+	}
+	return allowed, denied
+}
+
+// clientAPIKey 返回客户端请求 relay 时使用的 Key
+func clientAPIKey(header http.Header) string {
+	if key := strings.TrimSpace(header.Get("X-Api-Key")); key != "" {
+		return key
+	}
+	auth := strings.TrimSpace(header.Get("Authorization"))
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// abortModelDenied 以客户端协议的错误格式返回 403
+func abortModelDenied(c *gin.Context, kind string, model string, rule *ModelPolicyRule) {
+	message := fmt.Sprintf("模型 %s 被规则 %s 禁止使用", model, rule.Name)
+	fmt.Printf("[WARN] %s，已拒绝\n", message)
+	if kind == "claude" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"type":  "error",
+			"error": gin.H{"type": "permission_error", "message": message},
+		})
+		return
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": gin.H{"message": message, "type": "invalid_request_error", "param": "model", "code": "model_not_allowed"},
+	})
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestModelPolicyRejectsInClientProtocol(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+		}))
+	}
+	shadow, approved := newUpstream("shadow"), newUpstream("approved")
+	defer shadow.Close()
+	defer approved.Close()
+
+	ps := NewProviderService()
+	providers := []Provider{
+		{ID: 1, Name: "shadow", APIURL: shadow.URL, APIKey: "sk-shadow-1234567890", Enabled: true},
+		{ID: 2, Name: "approved", APIURL: approved.URL, APIKey: "sk-approved-1234567890", Enabled: true},
+	}
+	if err := ps.SaveProviders("claude", providers); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.ModelPolicy.Rules = []ModelPolicyRule{
+		{Name: "intern-no-opus", ClientKeys: []string{"sk-intern"}, Deny: []string{"claude-opus-*"}},
+		{Name: "unapproved-providers", Providers: []string{"shadow"}, Deny: []string{"*"}},
+	}
+	if errs := cfg.validate(); len(errs) > 0 {
+		t.Fatalf("模型规则应通过校验: %v", errs)
+	}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(path string, header string, key string, model string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model":"`+model+`","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set(header, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/v1/messages", "X-Api-Key", "sk-intern", "claude-opus-4-1")
+	if rec.Code != http.StatusForbidden || gjson.Get(rec.Body.String(), "error.type").String() != "permission_error" ||
+		!strings.Contains(gjson.Get(rec.Body.String(), "error.message").String(), "intern-no-opus") {
+		t.Fatalf("应以 Anthropic 的错误格式拒绝: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = post("/responses", "Authorization", "Bearer sk-intern", "claude-opus-4-1")
+	if rec.Code != http.StatusForbidden || gjson.Get(rec.Body.String(), "error.code").String() != "model_not_allowed" {
+		t.Fatalf("应以 OpenAI 的错误格式拒绝: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(hits) != 0 {
+		t.Fatalf("被拒绝的请求不应发往上游: %v", hits)
+	}
+
+	// 其他客户端可以使用 opus，但不会使用未批准的 provider
+	if rec := post("/v1/messages", "X-Api-Key", "sk-senior", "claude-opus-4-1"); rec.Code != http.StatusOK {
+		t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Join(hits, ",") != "approved" {
+		t.Fatalf("不应使用未批准的 provider: %v", hits)
+	}
+	providers[1].Enabled = false
+	if err := ps.SaveProviders("claude", providers); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay = NewProviderRelayService(ps, rcs, "")
+	router = gin.New()
+	relay.registerRoutes(router)
+	if rec := post("/v1/messages", "X-Api-Key", "sk-senior", "claude-sonnet-4-5"); rec.Code != http.StatusForbidden ||
+		!strings.Contains(rec.Body.String(), "unapproved-providers") {
+		t.Fatalf("只剩未批准的 provider 时应拒绝: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestModelPolicyValidate(t *testing.T) {
+	errs := ModelPolicyConfig{Rules: []ModelPolicyRule{
+		{Name: "ok", Allow: []string{"claude-sonnet-*"}},
+		{Allow: []string{"*opus*"}},
+		{Name: "empty"},
+	}}.validate()
+	if len(errs) != 3 {
+		t.Fatalf("应报告缺少名称、多个通配符与空规则: %v", errs)
+	}
+}
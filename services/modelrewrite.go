@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ModelRewrite 是一条按顺序匹配的模型改写规则，用于把客户端请求的模型名换成该 provider 的模型
+// （如 "claude-sonnet-4-*" -> "glm-4.6"），客户端无需感知后端使用的模型
+type ModelRewrite struct {
+	// 匹配请求模型名：以 ^ 开头时为正则表达式（需完整匹配），否则为支持多个 * 的通配符
+	Match string `json:"match"`
+	// 发送给 provider 的模型名：可用 $1、${1}、${name} 引用正则的捕获组（后面紧跟字母或数字时需写成 ${1}），通配符的每个 * 依次对应 $1、$2 ...
+	Target string `json:"target"`
+}
+
+// modelRewritePatterns 缓存编译后的匹配规则，key 为 Match
+var modelRewritePatterns sync.Map
+
+// pattern 返回规则对应的正则表达式，通配符转换为完整匹配的正则
+func (r ModelRewrite) pattern() (*regexp.Regexp, error) {
+	if cached, ok := modelRewritePatterns.Load(r.Match); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	expr := r.Match
+	if !strings.HasPrefix(expr, "^") {
+		parts := strings.Split(expr, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		expr = "^" + strings.Join(parts, "(.*)") + "$"
+	} else {
+		expr = "^(?:" + strings.TrimSuffix(strings.TrimPrefix(expr, "^"), "$") + ")$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	modelRewritePatterns.Store(r.Match, re)
+	return re, nil
+}
+
+// rewrite 在模型名匹配规则时返回改写后的模型名
+func (r ModelRewrite) rewrite(model string) (string, bool) {
+	re, err := r.pattern()
+	if err != nil {
+		return "", false
+	}
+	match := re.FindStringSubmatchIndex(model)
+	if match == nil {
+		return "", false
+	}
+	return string(re.ExpandString(nil, r.Target, model, match)), true
+}
+
+// validate 检查规则能否使用
+func (r ModelRewrite) validate() error {
+	if strings.TrimSpace(r.Match) == "" || strings.TrimSpace(r.Target) == "" {
+		return fmt.Errorf("match 与 target 不能为空")
+	}
+	if _, err := r.pattern(); err != nil {
+		return fmt.Errorf("match '%s' 不是有效的正则: %v", r.Match, err)
+	}
+	return nil
+}
+
+// rewriteModel 按顺序应用 provider 的模型改写规则，返回第一条匹配规则的结果
+func (p *Provider) rewriteModel(requestedModel string) (string, bool) {
+	for _, rule := range p.ModelRewrites {
+		if model, ok := rule.rewrite(requestedModel); ok {
+			return model, true
+		}
+	}
+	return "", false
+}
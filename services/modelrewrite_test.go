@@ -0,0 +1,66 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProviderModelRewrites(t *testing.T) {
+	provider := Provider{
+		Name:         "glm",
+		ModelMapping: map[string]string{"claude-opus-4-1": "glm-4.6-plus", "claude-*": "glm-4.5-air"},
+		ModelRewrites: []ModelRewrite{
+			{Match: "claude-sonnet-4-*", Target: "glm-4.6"},
+			{Match: "claude-3-5-haiku*", Target: "deepseek-chat"},
+			{Match: `^claude-(?P<family>\w+)-(\d+)-(\d+)-(\d{8})$`, Target: "${family}-v${2}.${3}"},
+			{Match: "*-*-preview", Target: "$2-$1"},
+		},
+	}
+	cases := []struct {
+		requested string
+		expected  string
+	}{
+		// 精确映射优先于改写规则
+		{"claude-opus-4-1", "glm-4.6-plus"},
+		{"claude-sonnet-4-5-20250929", "glm-4.6"},
+		{"claude-3-5-haiku-20241022", "deepseek-chat"},
+		{"claude-opus-4-0-20250514", "opus-v4.0"},
+		{"gpt-5-preview", "5-gpt"},
+		// 改写规则之后才使用通配符映射
+		{"claude-instant", "glm-4.5-air"},
+		{"gemini-2.5-pro", "gemini-2.5-pro"},
+	}
+	for _, tc := range cases {
+		if got := provider.GetEffectiveModel(tc.requested); got != tc.expected {
+			t.Errorf("GetEffectiveModel(%q) = %q，期望 %q", tc.requested, got, tc.expected)
+		}
+	}
+	if !provider.IsModelSupported("gpt-5-preview") || provider.IsModelSupported("gemini-2.5-pro") {
+		t.Errorf("只应支持映射或改写规则匹配的模型")
+	}
+
+	rewritesOnly := Provider{Name: "deepseek", ModelRewrites: []ModelRewrite{{Match: "^claude-(sonnet|opus)", Target: "deepseek-chat"}}}
+	if rewritesOnly.IsModelSupported("claude-sonnet-4-5") || !rewritesOnly.IsModelSupported("claude-opus") ||
+		rewritesOnly.IsModelSupported("claude-haiku") {
+		t.Errorf("正则需完整匹配模型名，且只配置改写规则时不应假设支持所有模型")
+	}
+}
+
+func TestProviderModelRewritesValidation(t *testing.T) {
+	provider := Provider{Name: "p", ModelRewrites: []ModelRewrite{
+		{Match: "^claude-(", Target: "x"},
+		{Match: "claude-*", Target: ""},
+		{Match: "ok-*", Target: "fine-$1"},
+	}}
+	if errs := provider.ValidateConfiguration(); len(errs) != 2 {
+		t.Fatalf("应报告无效的正则与空的 target: %v", errs)
+	}
+
+	var decoded Provider
+	if err := json.Unmarshal([]byte(`{"name":"p","modelRewrites":[{"match":"claude-sonnet-4-*","target":"glm-4.6"}]}`), &decoded); err != nil {
+		t.Fatalf("解析 modelRewrites 失败: %v", err)
+	}
+	if got := decoded.GetEffectiveModel("claude-sonnet-4-5"); got != "glm-4.6" {
+		t.Fatalf("从配置读取的改写规则未生效: %s", got)
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/tidwall/gjson"
+)
+
+// countInputImages 统计请求中的图片张数（claude 为 image 内容块，codex 为 input_image）
+func countInputImages(kind string, body []byte) int {
+	field, imageType := "messages", "image"
+	if kind == "codex" {
+		field, imageType = "input", "input_image"
+	}
+	count := 0
+	for _, message := range gjson.GetBytes(body, field).Array() {
+		for _, part := range message.Get("content").Array() {
+			if part.Get("type").String() == imageType {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// countOutputImages 统计 Responses API 输出中生成的图片
+func countOutputImages(output gjson.Result) int {
+	return countOutputItems(output, "image_generation_call")
+}
+
+// countOutputItems 统计 Responses API 输出中指定类型的项（如 web_search_call）
+func countOutputItems(output gjson.Result, itemType string) int {
+	count := 0
+	for _, item := range output.Array() {
+		if item.Get("type").String() == itemType {
+			count++
+		}
+	}
+	return count
+}
+
+// usageSnapshot 返回日志的用量，用于计算费用
+func (l *ReqeustLog) usageSnapshot() modelpricing.UsageSnapshot {
+	return modelpricing.UsageSnapshot{
+		InputTokens:       l.InputTokens,
+		OutputTokens:      l.OutputTokens,
+		CacheCreateTokens: l.CacheCreateTokens,
+		CacheReadTokens:   l.CacheReadTokens,
+		InputAudioTokens:  l.InputAudioTokens,
+		OutputAudioTokens: l.OutputAudioTokens,
+		InputImages:       l.InputImages,
+		OutputImages:      l.OutputImages,
+		ServiceTier:       l.ServiceTier,
+		WebSearchCalls:    l.WebSearchCalls,
+		CodeExecutions:    l.CodeExecutions,
+		Queries:           l.Queries,
+	}
+}
+
+// recordUsage 从 request_log 记录读取用量，查询时需包含 token、图片/音频、服务端工具调用、重排序查询次数与 service_tier 列
+func recordUsage(record xdb.Record) modelpricing.UsageSnapshot {
+	return modelpricing.UsageSnapshot{
+		InputTokens:       record.GetInt("input_tokens"),
+		OutputTokens:      record.GetInt("output_tokens"),
+		CacheCreateTokens: record.GetInt("cache_create_tokens"),
+		CacheReadTokens:   record.GetInt("cache_read_tokens"),
+		InputAudioTokens:  record.GetInt("input_audio_tokens"),
+		OutputAudioTokens: record.GetInt("output_audio_tokens"),
+		InputImages:       record.GetInt("input_images"),
+		OutputImages:      record.GetInt("output_images"),
+		ServiceTier:       record.GetString("service_tier"),
+		WebSearchCalls:    record.GetInt("web_search_calls"),
+		CodeExecutions:    record.GetInt("code_executions"),
+		Queries:           record.GetInt("queries"),
+	}
+}
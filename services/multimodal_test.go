@@ -0,0 +1,14 @@
+package services
+
+import "testing"
+
+func TestCountInputImages(t *testing.T) {
+	claude := []byte(`{"messages":[{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image","source":{}},{"type":"image","source":{}}]}]}`)
+	codex := []byte(`{"input":[{"role":"user","content":[{"type":"input_text","text":"hi"},{"type":"input_image","image_url":"x"}]}]}`)
+	if n := countInputImages("claude", claude); n != 2 {
+		t.Fatalf("claude 图片数 = %d", n)
+	}
+	if n := countInputImages("codex", codex); n != 1 {
+		t.Fatalf("codex 图片数 = %d", n)
+	}
+}
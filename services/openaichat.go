@@ -0,0 +1,433 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+)
+
+// openAIChatDialect 将 Anthropic Messages 请求转换为 OpenAI Chat Completions，并把响应（含 SSE 流）转换回 Anthropic 格式。
+// 支持 system、文本、图片、tool_use / tool_result 与工具定义；thinking 等 OpenAI 协议没有对应概念的内容会被丢弃。
+type openAIChatDialect struct {
+	// Azure OpenAI 的模型名为部署名，接口路径包含部署
+	azure bool
+}
+
+// OpenAI 兼容服务的 apiUrl 通常包含版本前缀，如 https://api.deepseek.com/v1；Azure 的 apiUrl 为 https://<resource>.openai.azure.com/openai
+func (d openAIChatDialect) endpoint(model string, _ bool) string {
+	if d.azure {
+		return "/deployments/" + url.PathEscape(model) + "/chat/completions"
+	}
+	return "/chat/completions"
+}
+
+func (openAIChatDialect) translateRequest(body []byte, model string) ([]byte, error) {
+	return anthropicToOpenAIChatRequest(body, model)
+}
+
+func (openAIChatDialect) responseHook(model string, stream bool, usage *upstreamUsage) xrequest.ResponseHook {
+	if !stream {
+		return func(data []byte) (bool, []byte) {
+			if reported := gjson.GetBytes(data, "usage"); reported.IsObject() {
+				usage.recordOpenAIChat(reported)
+			}
+			return true, openAIChatToAnthropicResponse(data, model)
+		}
+	}
+	translator := &openAIChatStreamTranslator{model: model, reported: usage}
+	return translator.hook
+}
+
+// anthropicToOpenAIChatRequest 转换 Anthropic Messages 请求体
+func anthropicToOpenAIChatRequest(body []byte, model string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("请求体不是有效的 JSON")
+	}
+	request := gjson.ParseBytes(body)
+	if model == "" {
+		model = request.Get("model").String()
+	}
+	chat := map[string]any{"model": model}
+	messages := make([]map[string]any, 0)
+
+	if system := anthropicText(request.Get("system")); system != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": system})
+	}
+	for _, message := range request.Get("messages").Array() {
+		messages = append(messages, openAIChatMessages(message)...)
+	}
+	chat["messages"] = messages
+
+	if maxTokens := request.Get("max_tokens"); maxTokens.Exists() {
+		chat["max_tokens"] = maxTokens.Int()
+	}
+	for _, field := range []string{"temperature", "top_p"} {
+		if value := request.Get(field); value.Exists() {
+			chat[field] = value.Float()
+		}
+	}
+	if stop := request.Get("stop_sequences"); stop.IsArray() && len(stop.Array()) > 0 {
+		sequences := make([]string, 0)
+		for _, sequence := range stop.Array() {
+			sequences = append(sequences, sequence.String())
+		}
+		chat["stop"] = sequences
+	}
+	if user := request.Get("metadata.user_id").String(); user != "" {
+		chat["user"] = user
+	}
+	if request.Get("stream").Bool() {
+		chat["stream"] = true
+		// 最后一个 chunk 返回用量，转换为 message_delta 的 usage
+		chat["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	tools := make([]map[string]any, 0)
+	for _, tool := range request.Get("tools").Array() {
+		// web_search 等服务端工具没有 input_schema，OpenAI 协议无法表达
+		schema := tool.Get("input_schema")
+		if !schema.Exists() {
+			continue
+		}
+		function := map[string]any{"name": tool.Get("name").String(), "parameters": json.RawMessage(schema.Raw)}
+		if description := tool.Get("description").String(); description != "" {
+			function["description"] = description
+		}
+		tools = append(tools, map[string]any{"type": "function", "function": function})
+	}
+	if len(tools) > 0 {
+		chat["tools"] = tools
+		choice := request.Get("tool_choice")
+		switch choice.Get("type").String() {
+		case "any":
+			chat["tool_choice"] = "required"
+		case "none":
+			chat["tool_choice"] = "none"
+		case "tool":
+			chat["tool_choice"] = map[string]any{"type": "function", "function": map[string]any{"name": choice.Get("name").String()}}
+		case "auto":
+			chat["tool_choice"] = "auto"
+		}
+		if choice.Get("disable_parallel_tool_use").Bool() {
+			chat["parallel_tool_calls"] = false
+		}
+	}
+	return json.Marshal(chat)
+}
+
+// anthropicText 拼接字符串或 text 块数组中的文本
+func anthropicText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+	parts := make([]string, 0)
+	for _, block := range content.Array() {
+		if block.Get("type").String() == "text" {
+			parts = append(parts, block.Get("text").String())
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// openAIChatMessages 将一条 Anthropic 消息转换为 OpenAI 消息：
+// tool_result 块拆分为紧随 assistant 消息之后的 tool 消息，assistant 的 tool_use 块转换为 tool_calls
+func openAIChatMessages(message gjson.Result) []map[string]any {
+	role := message.Get("role").String()
+	content := message.Get("content")
+	if content.Type == gjson.String {
+		return []map[string]any{{"role": role, "content": content.String()}}
+	}
+
+	var result []map[string]any
+	parts := make([]map[string]any, 0)
+	toolCalls := make([]map[string]any, 0)
+	texts := make([]string, 0)
+	for _, block := range content.Array() {
+		switch block.Get("type").String() {
+		case "text":
+			texts = append(texts, block.Get("text").String())
+			parts = append(parts, map[string]any{"type": "text", "text": block.Get("text").String()})
+		case "image":
+			if url := anthropicImageURL(block.Get("source")); url != "" {
+				parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]any{"url": url}})
+			}
+		case "tool_use":
+			arguments := block.Get("input").Raw
+			if arguments == "" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, map[string]any{
+				"id":       block.Get("id").String(),
+				"type":     "function",
+				"function": map[string]any{"name": block.Get("name").String(), "arguments": arguments},
+			})
+		case "tool_result":
+			output := anthropicText(block.Get("content"))
+			if block.Get("is_error").Bool() {
+				output = "Error: " + output
+			}
+			result = append(result, map[string]any{"role": "tool", "tool_call_id": block.Get("tool_use_id").String(), "content": output})
+		}
+	}
+
+	if role == "assistant" {
+		assistant := map[string]any{"role": "assistant", "content": strings.Join(texts, "\n")}
+		if len(toolCalls) > 0 {
+			assistant["tool_calls"] = toolCalls
+		}
+		return append(result, assistant)
+	}
+	if len(parts) > 0 {
+		result = append(result, map[string]any{"role": role, "content": parts})
+	}
+	return result
+}
+
+// anthropicImageURL 将图片来源转换为 OpenAI 的 image_url（base64 使用 data URL）
+func anthropicImageURL(source gjson.Result) string {
+	switch source.Get("type").String() {
+	case "base64":
+		return "data:" + source.Get("media_type").String() + ";base64," + source.Get("data").String()
+	case "url":
+		return source.Get("url").String()
+	}
+	return ""
+}
+
+// anthropicStopReason 转换 OpenAI 的 finish_reason
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+// anthropicUsage 转换 OpenAI 的用量，Anthropic 的 input_tokens 不含缓存命中的部分
+func anthropicUsage(usage gjson.Result) map[string]any {
+	cached := usage.Get("prompt_tokens_details.cached_tokens").Int()
+	result := map[string]any{
+		"input_tokens":  usage.Get("prompt_tokens").Int() - cached,
+		"output_tokens": usage.Get("completion_tokens").Int(),
+	}
+	if cached > 0 {
+		result["cache_read_input_tokens"] = cached
+	}
+	return result
+}
+
+// recordOpenAIChat 记录 Chat Completions 的用量，completion_tokens 已包含推理 token
+func (u *upstreamUsage) recordOpenAIChat(usage gjson.Result) {
+	cached := int(usage.Get("prompt_tokens_details.cached_tokens").Int())
+	u.reported = true
+	u.inputTokens = int(usage.Get("prompt_tokens").Int()) - cached
+	u.cacheReadTokens = cached
+	u.outputTokens = int(usage.Get("completion_tokens").Int())
+	u.reasoningTokens = int(usage.Get("completion_tokens_details.reasoning_tokens").Int())
+}
+
+// anthropicMessageID 使用上游的 id 生成 Anthropic 风格的消息 id
+func anthropicMessageID(id string) string {
+	if id == "" {
+		return "msg_" + newRequestID()
+	}
+	return "msg_" + strings.TrimPrefix(id, "chatcmpl-")
+}
+
+// openAIChatToAnthropicResponse 转换非流式的 Chat Completions 响应，无法解析时原样返回
+func openAIChatToAnthropicResponse(data []byte, model string) []byte {
+	if !gjson.ValidBytes(data) {
+		return data
+	}
+	response := gjson.ParseBytes(data)
+	choice := response.Get("choices.0")
+	content := make([]map[string]any, 0)
+	if text := choice.Get("message.content").String(); text != "" {
+		content = append(content, map[string]any{"type": "text", "text": text})
+	}
+	for _, call := range choice.Get("message.tool_calls").Array() {
+		content = append(content, map[string]any{
+			"type":  "tool_use",
+			"id":    call.Get("id").String(),
+			"name":  call.Get("function.name").String(),
+			"input": toolInput(call.Get("function.arguments").String()),
+		})
+	}
+	if responseModel := response.Get("model").String(); responseModel != "" {
+		model = responseModel
+	}
+	translated, err := json.Marshal(map[string]any{
+		"id":            anthropicMessageID(response.Get("id").String()),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   anthropicStopReason(choice.Get("finish_reason").String()),
+		"stop_sequence": nil,
+		"usage":         anthropicUsage(response.Get("usage")),
+	})
+	if err != nil {
+		return data
+	}
+	return translated
+}
+
+// toolInput 解析工具调用的参数，不是有效的 JSON 对象时返回空对象
+func toolInput(arguments string) json.RawMessage {
+	if gjson.Valid(arguments) && gjson.Parse(arguments).IsObject() {
+		return json.RawMessage(arguments)
+	}
+	return json.RawMessage("{}")
+}
+
+// openAIChatStreamTranslator 将 Chat Completions 的 SSE chunk 转换为 Anthropic 的流式事件
+type openAIChatStreamTranslator struct {
+	model   string
+	started bool
+	done    bool
+	anthropicBlocks
+	// OpenAI tool_calls 的 index -> Anthropic 内容块序号
+	toolBlocks   map[int64]int
+	finishReason string
+	usage        gjson.Result
+	reported     *upstreamUsage
+}
+
+// hook 处理一行 SSE，返回转换后的事件（可能为多个），没有对应事件的行返回空内容
+func (t *openAIChatStreamTranslator) hook(line []byte) (bool, []byte) {
+	payload := strings.TrimSpace(string(line))
+	if !strings.HasPrefix(payload, "data:") || t.done {
+		return true, nil
+	}
+	payload = strings.TrimSpace(strings.TrimPrefix(payload, "data:"))
+	var events []string
+	if payload == "[DONE]" {
+		events = t.finish()
+	} else if gjson.Valid(payload) {
+		events = t.chunk(gjson.Parse(payload))
+	}
+	if len(events) == 0 {
+		return true, nil
+	}
+	// xrequest 会在末尾补上换行，上游 chunk 之后的空行结束最后一个事件
+	return true, []byte(strings.Join(events, "\n\n"))
+}
+
+func sseEvent(name string, payload map[string]any) string {
+	payload["type"] = name
+	data, _ := json.Marshal(payload)
+	return "event: " + name + "\ndata: " + string(data)
+}
+
+func (t *openAIChatStreamTranslator) chunk(chunk gjson.Result) []string {
+	var events []string
+	if !t.started {
+		t.started = true
+		if chunkModel := chunk.Get("model").String(); chunkModel != "" {
+			t.model = chunkModel
+		}
+		events = append(events, sseEvent("message_start", map[string]any{"message": map[string]any{
+			"id":            anthropicMessageID(chunk.Get("id").String()),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         t.model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]any{"input_tokens": 0, "output_tokens": 0},
+		}}))
+	}
+
+	choice := chunk.Get("choices.0")
+	if text := choice.Get("delta.content").String(); text != "" {
+		if t.openBlock != "text" {
+			events = append(events, t.startBlock("text", map[string]any{"type": "text", "text": ""})...)
+		}
+		events = append(events, sseEvent("content_block_delta", map[string]any{
+			"index": t.nextIndex - 1,
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		}))
+	}
+	for _, call := range choice.Get("delta.tool_calls").Array() {
+		callIndex := call.Get("index").Int()
+		if t.toolBlocks == nil {
+			t.toolBlocks = make(map[int64]int)
+		}
+		if _, ok := t.toolBlocks[callIndex]; !ok {
+			events = append(events, t.startBlock("tool_use", map[string]any{
+				"type":  "tool_use",
+				"id":    call.Get("id").String(),
+				"name":  call.Get("function.name").String(),
+				"input": map[string]any{},
+			})...)
+			t.toolBlocks[callIndex] = t.nextIndex - 1
+		}
+		if arguments := call.Get("function.arguments").String(); arguments != "" {
+			events = append(events, sseEvent("content_block_delta", map[string]any{
+				"index": t.toolBlocks[callIndex],
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": arguments},
+			}))
+		}
+	}
+
+	if reason := choice.Get("finish_reason").String(); reason != "" {
+		t.finishReason = reason
+	}
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		t.usage = usage
+		t.reported.recordOpenAIChat(usage)
+	}
+	// 同时带有结束原因与用量时不必等待 [DONE]
+	if t.finishReason != "" && t.usage.Exists() {
+		events = append(events, t.finish()...)
+	}
+	return events
+}
+
+// anthropicBlocks 跟踪转换后的流中当前未关闭的内容块（text 或 tool_use）与下一个块的序号
+type anthropicBlocks struct {
+	openBlock string
+	nextIndex int
+}
+
+// startBlock 关闭当前的内容块并开始新的内容块
+func (b *anthropicBlocks) startBlock(kind string, block map[string]any) []string {
+	events := b.closeBlock()
+	events = append(events, sseEvent("content_block_start", map[string]any{"index": b.nextIndex, "content_block": block}))
+	b.openBlock = kind
+	b.nextIndex++
+	return events
+}
+
+func (b *anthropicBlocks) closeBlock() []string {
+	if b.openBlock == "" {
+		return nil
+	}
+	b.openBlock = ""
+	return []string{sseEvent("content_block_stop", map[string]any{"index": b.nextIndex - 1})}
+}
+
+// finish 输出结束事件，用量放在 message_delta 中
+func (t *openAIChatStreamTranslator) finish() []string {
+	if t.done || !t.started {
+		return nil
+	}
+	t.done = true
+	events := t.closeBlock()
+	events = append(events,
+		sseEvent("message_delta", map[string]any{
+			"delta": map[string]any{"stop_reason": anthropicStopReason(t.finishReason), "stop_sequence": nil},
+			"usage": anthropicUsage(t.usage),
+		}),
+		sseEvent("message_stop", map[string]any{}),
+	)
+	return events
+}
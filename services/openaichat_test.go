@@ -0,0 +1,244 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestAnthropicToOpenAIChatRequest(t *testing.T) {
+	body := `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 1024,
+		"stream": true,
+		"stop_sequences": ["END"],
+		"system": [{"type": "text", "text": "You are helpful."}, {"type": "text", "text": "Be brief."}],
+		"tools": [
+			{"name": "read_file", "description": "Read a file", "input_schema": {"type": "object", "properties": {"path": {"type": "string"}}}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBOR"}},
+				{"type": "text", "text": "What is in main.go?"}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "...", "signature": "sig"},
+				{"type": "text", "text": "Let me read it."},
+				{"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": {"path": "main.go"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "package main"}]},
+				{"type": "text", "text": "Summarize it."}
+			]}
+		]
+	}`
+	translated, err := anthropicToOpenAIChatRequest([]byte(body), "deepseek-chat")
+	if err != nil {
+		t.Fatalf("转换请求失败: %v", err)
+	}
+	chat := gjson.ParseBytes(translated)
+	checks := map[string]string{
+		"model":                              "deepseek-chat",
+		"max_tokens":                         "1024",
+		"stream":                             "true",
+		"stream_options.include_usage":       "true",
+		"stop.0":                             "END",
+		"tool_choice":                        "required",
+		"parallel_tool_calls":                "false",
+		"tools.#":                            "1",
+		"tools.0.function.name":              "read_file",
+		"tools.0.function.parameters.type":   "object",
+		"messages.#":                         "5",
+		"messages.0.role":                    "system",
+		"messages.0.content":                 "You are helpful.\nBe brief.",
+		"messages.1.content.0.image_url.url": "data:image/png;base64,iVBOR",
+		"messages.1.content.1.text":          "What is in main.go?",
+		"messages.2.role":                    "assistant",
+		"messages.2.content":                 "Let me read it.",
+		"messages.2.tool_calls.0.id":         "toolu_1",
+		"messages.2.tool_calls.0.function.arguments": `{"path": "main.go"}`,
+		"messages.3.role":           "tool",
+		"messages.3.tool_call_id":   "toolu_1",
+		"messages.3.content":        "package main",
+		"messages.4.content.0.text": "Summarize it.",
+	}
+	for path, expected := range checks {
+		if got := chat.Get(path).String(); got != expected {
+			t.Errorf("%s = %q，期望 %q", path, got, expected)
+		}
+	}
+}
+
+func TestOpenAIChatToAnthropicResponse(t *testing.T) {
+	data := openAIChatToAnthropicResponse([]byte(`{
+		"id": "chatcmpl-abc", "model": "deepseek-chat",
+		"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": "Reading.",
+			"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "read_file", "arguments": "{\"path\":\"main.go\"}"}}]}}],
+		"usage": {"prompt_tokens": 120, "completion_tokens": 30, "prompt_tokens_details": {"cached_tokens": 100}}
+	}`), "fallback")
+	message := gjson.ParseBytes(data)
+	checks := map[string]string{
+		"id":                            "msg_abc",
+		"type":                          "message",
+		"model":                         "deepseek-chat",
+		"stop_reason":                   "tool_use",
+		"content.0.text":                "Reading.",
+		"content.1.type":                "tool_use",
+		"content.1.input.path":          "main.go",
+		"usage.input_tokens":            "20",
+		"usage.output_tokens":           "30",
+		"usage.cache_read_input_tokens": "100",
+	}
+	for path, expected := range checks {
+		if got := message.Get(path).String(); got != expected {
+			t.Errorf("%s = %q，期望 %q", path, got, expected)
+		}
+	}
+}
+
+// streamEvents 将转换后的 SSE 拆分为事件名与数据
+func streamEvents(t *testing.T, stream string) []gjson.Result {
+	t.Helper()
+	var events []gjson.Result
+	for _, block := range strings.Split(stream, "\n\n") {
+		block = strings.TrimSpace(block)
+		if block == "" {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("事件格式错误: %q", block)
+		}
+		data := gjson.Parse(strings.TrimPrefix(lines[1], "data: "))
+		if data.Get("type").String() != strings.TrimPrefix(lines[0], "event: ") {
+			t.Fatalf("事件名与 type 不一致: %q", block)
+		}
+		events = append(events, data)
+	}
+	return events
+}
+
+const openAIChatStream = `data: {"id":"chatcmpl-1","model":"deepseek-chat","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"Hello"}}]}
+
+: keep-alive
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":" world"}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read_file","arguments":""}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"main.go\"}"}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: {"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":50,"completion_tokens":12}}
+
+data: [DONE]
+
+`
+
+func TestOpenAIChatStreamTranslation(t *testing.T) {
+	usage := &upstreamUsage{}
+	hook := openAIChatDialect{}.responseHook("deepseek-chat", true, usage)
+	var output strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(openAIChatStream, "\n"), "\n") {
+		// 与 xrequest 相同：空行原样写出，其余行经过钩子后补上换行
+		if line == "" {
+			output.WriteString("\n")
+			continue
+		}
+		if flush, data := hook([]byte(line)); flush {
+			output.Write(data)
+			output.WriteString("\n")
+		}
+	}
+
+	events := streamEvents(t, output.String())
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Get("type").String())
+	}
+	expected := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_delta,content_block_stop,message_delta,message_stop"
+	if got := strings.Join(types, ","); got != expected {
+		t.Fatalf("事件顺序错误:\n%s\n期望\n%s", got, expected)
+	}
+	if events[0].Get("message.model").String() != "deepseek-chat" || events[2].Get("delta.text").String() != "Hello" {
+		t.Fatalf("文本事件错误: %s %s", events[0].Raw, events[2].Raw)
+	}
+	if block := events[5].Get("content_block"); block.Get("type").String() != "tool_use" || block.Get("name").String() != "read_file" ||
+		events[5].Get("index").Int() != 1 {
+		t.Fatalf("工具调用应作为第二个内容块: %s", events[5].Raw)
+	}
+	if json := events[6].Get("delta.partial_json").String() + events[7].Get("delta.partial_json").String(); json != `{"path":"main.go"}` {
+		t.Fatalf("工具参数错误: %s", json)
+	}
+	if delta := events[9]; delta.Get("delta.stop_reason").String() != "tool_use" || delta.Get("usage.input_tokens").Int() != 50 ||
+		delta.Get("usage.output_tokens").Int() != 12 {
+		t.Fatalf("message_delta 错误: %s", delta.Raw)
+	}
+	if !usage.reported || usage.inputTokens != 50 || usage.outputTokens != 12 {
+		t.Fatalf("应记录上游报告的用量: %+v", usage)
+	}
+}
+
+func TestRelayTranslatesToOpenAIChat(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwardedPath, forwardedModel string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwardedPath, forwardedModel = r.URL.Path, gjson.GetBytes(body, "model").String()
+		if !gjson.GetBytes(body, "messages.0.content").Exists() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, openAIChatStream)
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "deepseek", APIURL: upstream.URL + "/v1", APIKey: "sk-deepseek-1234567890", Enabled: true,
+		APIFormat: APIFormatOpenAIChat, ModelRewrites: []ModelRewrite{{Match: "claude-*", Target: "deepseek-chat"}},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedPath != "/v1/chat/completions" || forwardedModel != "deepseek-chat" {
+		t.Fatalf("应转发到 Chat Completions 接口: %s %s", forwardedPath, forwardedModel)
+	}
+	events := streamEvents(t, rec.Body.String())
+	if len(events) != 11 || events[len(events)-1].Get("type").String() != "message_stop" {
+		t.Fatalf("响应应转换为 Anthropic 事件: %s", rec.Body.String())
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "deepseek"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); entry.InputTokens != 50 || entry.OutputTokens != 12 || entry.Model != "deepseek-chat" {
+		t.Fatalf("转换后的用量应计入日志: %+v", entry)
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 未配置时发送的应用归属信息，OpenRouter 据此在排行与用量统计中展示应用
+	defaultOpenRouterReferer = "https://github.com/daodao97/code-switch"
+	defaultOpenRouterTitle   = "Code Switch"
+	// 剩余额度低于该值（美元）时提示即将用完
+	defaultOpenRouterLowCredit = 1.0
+	// 额度查询结果的缓存时间，统计页面轮询时不重复请求
+	openRouterCreditsTTL = time.Minute
+)
+
+// OpenRouterConfig 描述 OpenRouter provider：发送应用归属请求头，将模型名转换为 OpenRouter 的 slug，
+// 记录响应中 OpenRouter 报告的实际费用，并在统计中展示剩余额度。
+// apiUrl 为 https://openrouter.ai/api（claude，Anthropic 兼容接口）或 https://openrouter.ai/api/v1（codex）
+type OpenRouterConfig struct {
+	// HTTP-Referer 请求头，默认为本项目地址
+	Referer string `json:"referer,omitempty"`
+	// X-Title 请求头，默认 Code Switch
+	Title string `json:"title,omitempty"`
+	// 剩余额度低于该值（美元）时在统计中标记为即将用完，默认 1
+	LowCreditThreshold float64 `json:"lowCreditThreshold,omitempty"`
+}
+
+func (c *OpenRouterConfig) setHeaders(headers map[string]string) {
+	referer, title := strings.TrimSpace(c.Referer), strings.TrimSpace(c.Title)
+	if referer == "" {
+		referer = defaultOpenRouterReferer
+	}
+	if title == "" {
+		title = defaultOpenRouterTitle
+	}
+	headers["HTTP-Referer"] = referer
+	headers["X-Title"] = title
+}
+
+func (c *OpenRouterConfig) lowCreditThreshold() float64 {
+	if c.LowCreditThreshold > 0 {
+		return c.LowCreditThreshold
+	}
+	return defaultOpenRouterLowCredit
+}
+
+func (c *OpenRouterConfig) validate() []string {
+	if c.LowCreditThreshold < 0 {
+		return []string{"openRouter.lowCreditThreshold 不能为负数"}
+	}
+	return nil
+}
+
+// openRouterVendors 按模型名前缀确定 OpenRouter slug 中的厂商
+var openRouterVendors = []struct {
+	prefixes []string
+	vendor   string
+}{
+	{[]string{"claude"}, "anthropic"},
+	{[]string{"gpt-", "o1", "o3", "o4", "codex-", "chatgpt-"}, "openai"},
+	{[]string{"gemini", "gemma"}, "google"},
+	{[]string{"grok"}, "x-ai"},
+	{[]string{"deepseek"}, "deepseek"},
+	{[]string{"qwen"}, "qwen"},
+	{[]string{"mistral", "codestral", "devstral"}, "mistralai"},
+	{[]string{"kimi"}, "moonshotai"},
+	{[]string{"glm"}, "z-ai"},
+}
+
+var (
+	// Anthropic 模型名中的日期后缀，OpenRouter 的 slug 不包含日期
+	anthropicDateSuffix = regexp.MustCompile(`-(\d{8}|latest)$`)
+	// Anthropic 模型名中以 - 分隔的版本号（4-5、3-7），OpenRouter 使用 . 分隔
+	anthropicVersion = regexp.MustCompile(`\b(\d)-(\d)\b`)
+)
+
+// openRouterSlug 将官方模型名转换为 OpenRouter 的 slug，如 claude-sonnet-4-5-20250929 -> anthropic/claude-sonnet-4.5；
+// 已包含厂商（带 /）或无法识别厂商的模型名保持不变
+func openRouterSlug(model string) string {
+	if model == "" || strings.Contains(model, "/") {
+		return model
+	}
+	lower := strings.ToLower(model)
+	for _, entry := range openRouterVendors {
+		for _, prefix := range entry.prefixes {
+			if !strings.HasPrefix(lower, prefix) {
+				continue
+			}
+			if entry.vendor == "anthropic" {
+				lower = anthropicVersion.ReplaceAllString(anthropicDateSuffix.ReplaceAllString(lower, ""), "$1.$2")
+			}
+			return entry.vendor + "/" + lower
+		}
+	}
+	return model
+}
+
+// openRouterCostHook 读取响应 usage 中 OpenRouter 报告的费用（美元），流式响应在最后一个带 usage 的 chunk 中
+func openRouterCostHook(log *ReqeustLog) xrequest.ResponseHook {
+	return func(data []byte) (bool, []byte) {
+		payload := strings.TrimSpace(string(data))
+		if strings.HasPrefix(payload, "{") {
+			recordOpenRouterCost(payload, log)
+		} else {
+			parseEventPayload(payload, recordOpenRouterCost, log)
+		}
+		return true, data
+	}
+}
+
+func recordOpenRouterCost(data string, log *ReqeustLog) {
+	for _, path := range []string{"usage.cost", "response.usage.cost"} {
+		if cost := gjson.Get(data, path); cost.Exists() {
+			log.ReportedCost = cost.Float()
+		}
+	}
+}
+
+// OpenRouterCredits 是 OpenRouter provider 的额度情况
+type OpenRouterCredits struct {
+	Platform string `json:"platform"`
+	Provider string `json:"provider"`
+	// 当前 Key 的额度上限与剩余额度（美元），Key 未设置上限时为账户余额
+	Limit     *float64 `json:"limit,omitempty"`
+	Remaining *float64 `json:"remaining,omitempty"`
+	// 当前 Key 已使用的额度（美元）
+	Usage float64 `json:"usage"`
+	// 剩余额度低于 lowCreditThreshold，即将用完
+	Low       bool      `json:"low"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// openRouterCreditsCache 缓存各 provider 的额度查询结果，key 为 poolKey(platform, provider)
+type openRouterCreditsCache struct {
+	mu      sync.Mutex
+	entries map[string]OpenRouterCredits
+	client  *http.Client
+}
+
+func newOpenRouterCreditsCache() *openRouterCreditsCache {
+	return &openRouterCreditsCache{entries: make(map[string]OpenRouterCredits), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// get 返回 provider 的额度，缓存过期时重新查询
+func (cc *openRouterCreditsCache) get(kind string, provider Provider) OpenRouterCredits {
+	key := poolKey(kind, provider.Name)
+	cc.mu.Lock()
+	cached, ok := cc.entries[key]
+	cc.mu.Unlock()
+	if ok && time.Since(cached.UpdatedAt) < openRouterCreditsTTL {
+		return cached
+	}
+
+	credits := OpenRouterCredits{Platform: kind, Provider: provider.Name, UpdatedAt: time.Now()}
+	if err := fetchOpenRouterCredits(cc.client, provider, &credits); err != nil {
+		credits.Error = err.Error()
+	} else if credits.Remaining != nil && *credits.Remaining < provider.OpenRouter.lowCreditThreshold() {
+		credits.Low = true
+		fmt.Printf("[WARN] OpenRouter provider %s 剩余额度 $%.4f，即将用完\n", provider.Name, *credits.Remaining)
+	}
+	cc.mu.Lock()
+	cc.entries[key] = credits
+	cc.mu.Unlock()
+	return credits
+}
+
+// openRouterAPIBase 返回 OpenRouter 的 /api/v1 地址，claude 的 apiUrl 不含 /v1
+func openRouterAPIBase(apiURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(apiURL, "/"), "/v1") + "/v1"
+}
+
+// fetchOpenRouterCredits 查询 /key 获取 Key 的额度；Key 未设置上限时查询 /credits 获取账户余额
+func fetchOpenRouterCredits(client *http.Client, provider Provider, credits *OpenRouterCredits) error {
+	keys := provider.AllAPIKeys()
+	if len(keys) == 0 {
+		return fmt.Errorf("未配置 API Key")
+	}
+	base := openRouterAPIBase(provider.APIURL)
+	data, err := openRouterGet(client, base+"/key", keys[0])
+	if err != nil {
+		return err
+	}
+	credits.Usage = gjson.Get(data, "data.usage").Float()
+	if limit := gjson.Get(data, "data.limit"); limit.Type == gjson.Number {
+		value, remaining := limit.Float(), gjson.Get(data, "data.limit_remaining").Float()
+		credits.Limit, credits.Remaining = &value, &remaining
+		return nil
+	}
+	data, err = openRouterGet(client, base+"/credits", keys[0])
+	if err != nil {
+		return err
+	}
+	total := gjson.Get(data, "data.total_credits").Float()
+	remaining := total - gjson.Get(data, "data.total_usage").Float()
+	credits.Limit, credits.Remaining = &total, &remaining
+	return nil
+}
+
+func openRouterGet(client *http.Client, url string, apiKey string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("无效的 apiUrl: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("查询额度失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("查询额度失败: HTTP %d %s", resp.StatusCode, truncateString(string(body), 256))
+	}
+	return string(body), nil
+}
+
+// OpenRouterCredits 返回所有启用的 OpenRouter provider 的剩余额度
+func (prs *ProviderRelayService) OpenRouterCredits() []OpenRouterCredits {
+	result := []OpenRouterCredits{}
+	for _, kind := range []string{"claude", "codex"} {
+		providers, err := prs.loadProviders(kind)
+		if err != nil {
+			continue
+		}
+		for _, provider := range providers {
+			if provider.Enabled && provider.OpenRouter != nil {
+				result = append(result, prs.openRouterCredits.get(kind, provider))
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		return result[i].Provider < result[j].Provider
+	})
+	return result
+}
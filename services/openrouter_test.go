@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestOpenRouterSlug(t *testing.T) {
+	cases := map[string]string{
+		"claude-sonnet-4-5-20250929": "anthropic/claude-sonnet-4.5",
+		"claude-3-7-sonnet-latest":   "anthropic/claude-3.7-sonnet",
+		"claude-opus-4-1":            "anthropic/claude-opus-4.1",
+		"claude-sonnet-4-20250514":   "anthropic/claude-sonnet-4",
+		"gpt-5-codex":                "openai/gpt-5-codex",
+		"o4-mini":                    "openai/o4-mini",
+		"gemini-2.5-pro":             "google/gemini-2.5-pro",
+		"anthropic/claude-haiku-4.5": "anthropic/claude-haiku-4.5",
+		"my-finetune":                "my-finetune",
+	}
+	for model, expected := range cases {
+		if slug := openRouterSlug(model); slug != expected {
+			t.Errorf("openRouterSlug(%q) = %q，期望 %q", model, slug, expected)
+		}
+	}
+
+	provider := Provider{OpenRouter: &OpenRouterConfig{}, ModelRewrites: []ModelRewrite{{Match: "haiku", Target: "claude-haiku-4-5"}}}
+	if model := provider.GetEffectiveModel("haiku"); model != "anthropic/claude-haiku-4.5" {
+		t.Fatalf("改写后的模型应转换为 slug: %s", model)
+	}
+
+	pricing, err := modelpricing.DefaultService()
+	if err != nil {
+		t.Fatalf("加载价格失败: %v", err)
+	}
+	if cost := pricing.CalculateCost("anthropic/claude-sonnet-4.5", modelpricing.UsageSnapshot{InputTokens: 1000}); cost.Match.Key != "openrouter/anthropic/claude-sonnet-4.5" ||
+		cost.Match.Strategy != modelpricing.MatchPrefix {
+		t.Fatalf("slug 应匹配 openrouter/ 价格条目: %+v", cost.Match)
+	}
+}
+
+func TestRelayForwardsToOpenRouter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var model, referer, title string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		model = gjson.GetBytes(body, "model").String()
+		referer, title = r.Header.Get("HTTP-Referer"), r.Header.Get("X-Title")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"gen-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"gen-1\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":1000,\"completion_tokens\":100,\"cost\":0.0045}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "openrouter", APIURL: upstream.URL + "/api/v1", APIKey: "sk-or-1234567890", Enabled: true,
+		APIFormat: APIFormatOpenAIChat, OpenRouter: &OpenRouterConfig{Title: "My Team"},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5-20250929","max_tokens":1000,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "message_stop") {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if model != "anthropic/claude-sonnet-4.5" || referer != defaultOpenRouterReferer || title != "My Team" {
+		t.Fatalf("应使用 OpenRouter 的 slug 与应用归属请求头: model=%q referer=%q title=%q", model, referer, title)
+	}
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "openrouter"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); math.Abs(entry.ReportedCost-0.0045) > 1e-12 || entry.InputTokens != 1000 || entry.Model != "anthropic/claude-sonnet-4.5" {
+		t.Fatalf("应记录 OpenRouter 报告的费用: %+v", entry)
+	}
+}
+
+func TestOpenRouterCredits(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var paths []string
+	limited := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer sk-or-1234567890" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/key":
+			if limited {
+				fmt.Fprint(w, `{"data":{"label":"sk-or-v1-123","usage":9.5,"limit":10,"limit_remaining":0.5,"is_free_tier":false}}`)
+			} else {
+				fmt.Fprint(w, `{"data":{"label":"sk-or-v1-123","usage":12,"limit":null,"limit_remaining":null}}`)
+			}
+		case "/api/v1/credits":
+			fmt.Fprint(w, `{"data":{"total_credits":50,"total_usage":12}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "openrouter", APIURL: server.URL + "/api", APIKey: "sk-or-1234567890", Enabled: true, OpenRouter: &OpenRouterConfig{}},
+		{ID: 2, Name: "other", APIURL: server.URL, APIKey: "sk-other-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := &ProviderRelayService{providerService: ps, openRouterCredits: newOpenRouterCreditsCache()}
+	credits := relay.OpenRouterCredits()
+	if len(credits) != 1 || credits[0].Provider != "openrouter" || credits[0].Error != "" || credits[0].Remaining == nil ||
+		*credits[0].Remaining != 0.5 || *credits[0].Limit != 10 || credits[0].Usage != 9.5 || !credits[0].Low {
+		t.Fatalf("Key 额度错误: %+v", credits)
+	}
+	// 缓存有效期内不重复查询
+	relay.OpenRouterCredits()
+	if len(paths) != 1 {
+		t.Fatalf("额度应被缓存: %v", paths)
+	}
+
+	limited = false
+	relay.openRouterCredits = newOpenRouterCreditsCache()
+	credits = relay.OpenRouterCredits()
+	if len(credits) != 1 || credits[0].Remaining == nil || *credits[0].Remaining != 38 || *credits[0].Limit != 50 || credits[0].Low {
+		t.Fatalf("Key 未设置上限时应使用账户余额: %+v", credits)
+	}
+	if strings.Join(paths, ",") != "/api/v1/key,/api/v1/key,/api/v1/credits" {
+		t.Fatalf("额度查询的接口错误: %v", paths)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Anthropic 在模型过载时返回 529 overloaded_error
+	statusOverloaded = 529
+	// 过载通常持续数分钟且只影响单个模型，冷却比限流更久
+	defaultOverloadCooldown = 3 * time.Minute
+)
+
+// ModelOverload 描述 provider 上单个模型的过载情况
+type ModelOverload struct {
+	Platform       string    `json:"platform"`
+	Provider       string    `json:"provider"`
+	Model          string    `json:"model"`
+	Count          int64     `json:"count"`
+	LastOverloadAt time.Time `json:"last_overload_at"`
+	CooldownUntil  time.Time `json:"cooldown_until"`
+}
+
+// isOverloadError 判断上游错误是否为模型过载（区别于 Key 级别的 429 限流）
+func isOverloadError(err error) bool {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return false
+	}
+	return upstreamErr.StatusCode == statusOverloaded || strings.Contains(upstreamErr.Body, "overloaded_error")
+}
+
+// overloadCooldownFor 返回过载后模型的冷却时长，上游给出更长的 Retry-After 时以其为准
+func overloadCooldownFor(err error, cfg OverloadConfig) time.Duration {
+	cooldown := time.Duration(cfg.CooldownSeconds * float64(time.Second))
+	if cooldown <= 0 {
+		cooldown = defaultOverloadCooldown
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > cooldown {
+		cooldown = upstreamErr.RetryAfter
+	}
+	return cooldown
+}
+
+// overloadTracker 按 provider + 模型记录过载次数与冷却时间
+type overloadTracker struct {
+	mu      sync.Mutex
+	entries map[string]*ModelOverload
+}
+
+func newOverloadTracker() *overloadTracker {
+	return &overloadTracker{entries: make(map[string]*ModelOverload)}
+}
+
+func modelSlot(kind string, providerName string, model string) string {
+	return poolKey(kind, providerName) + "\x00" + model
+}
+
+// record 记录一次过载并让该模型进入冷却
+func (ot *overloadTracker) record(kind string, providerName string, model string, cooldown time.Duration) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+	slot := modelSlot(kind, providerName, model)
+	entry := ot.entries[slot]
+	if entry == nil {
+		entry = &ModelOverload{Platform: kind, Provider: providerName, Model: model}
+		ot.entries[slot] = entry
+	}
+	now := time.Now()
+	entry.Count++
+	entry.LastOverloadAt = now
+	entry.CooldownUntil = now.Add(cooldown)
+}
+
+// cooldownUntil 返回模型的冷却结束时间，未在冷却中时返回 false
+func (ot *overloadTracker) cooldownUntil(kind string, providerName string, model string) (time.Time, bool) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+	entry := ot.entries[modelSlot(kind, providerName, model)]
+	if entry == nil || !time.Now().Before(entry.CooldownUntil) {
+		return time.Time{}, false
+	}
+	return entry.CooldownUntil, true
+}
+
+// snapshot 返回所有模型的过载统计，按 platform/provider/model 排序
+func (ot *overloadTracker) snapshot() []ModelOverload {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+	result := make([]ModelOverload, 0, len(ot.entries))
+	for _, entry := range ot.entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
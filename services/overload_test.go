@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOverloadClassification(t *testing.T) {
+	overloaded := &UpstreamError{StatusCode: http.StatusInternalServerError, Body: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`}
+	if !isOverloadError(overloaded) || !isOverloadError(&UpstreamError{StatusCode: 529}) {
+		t.Fatalf("529 与 overloaded_error 都应识别为过载")
+	}
+	if isOverloadError(&UpstreamError{StatusCode: http.StatusTooManyRequests}) || isOverloadError(errors.New("connection reset")) {
+		t.Fatalf("限流与网络错误不应识别为过载")
+	}
+	if countsTowardHealth(overloaded) {
+		t.Fatalf("模型过载不应计入 provider 健康分")
+	}
+	if got := classifyHTTPError(529); got != errorClassOverload {
+		t.Fatalf("classifyHTTPError(529) = %s", got)
+	}
+
+	if got := overloadCooldownFor(overloaded, OverloadConfig{}); got != defaultOverloadCooldown {
+		t.Fatalf("默认冷却 = %v", got)
+	}
+	long := &UpstreamError{StatusCode: 529, RetryAfter: 10 * time.Minute}
+	if got := overloadCooldownFor(long, OverloadConfig{CooldownSeconds: 30}); got != 10*time.Minute {
+		t.Fatalf("Retry-After 更长时应以其为准，实际 %v", got)
+	}
+}
+
+func TestOverloadTrackerIsModelScoped(t *testing.T) {
+	tracker := newOverloadTracker()
+	tracker.record("claude", "relay", "claude-opus", time.Minute)
+	tracker.record("claude", "relay", "claude-opus", time.Minute)
+
+	if _, cooling := tracker.cooldownUntil("claude", "relay", "claude-opus"); !cooling {
+		t.Fatalf("过载的模型应进入冷却")
+	}
+	if _, cooling := tracker.cooldownUntil("claude", "relay", "claude-sonnet"); cooling {
+		t.Fatalf("同一 provider 的其他模型不应受影响")
+	}
+	stats := tracker.snapshot()
+	if len(stats) != 1 || stats[0].Count != 2 || stats[0].Model != "claude-opus" {
+		t.Fatalf("过载统计不正确: %+v", stats)
+	}
+
+	tracker.record("claude", "relay", "claude-haiku", -time.Second)
+	if _, cooling := tracker.cooldownUntil("claude", "relay", "claude-haiku"); cooling {
+		t.Fatalf("冷却结束后应恢复可用")
+	}
+}
+
+func TestStreamProgressDetectsOverload(t *testing.T) {
+	var progress streamProgress
+	progress.track("claude", `{"type":"content_block_delta","delta":{"text":"hi"}}`)
+	progress.track("claude", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	if !progress.overloaded || progress.completed {
+		t.Fatalf("流中的 overloaded_error 事件应被识别: %+v", progress)
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket 令牌桶：以固定速率补充令牌，桶满时最多允许 burst 个请求瞬时通过
+// 令牌不足时请求排队等待，而不是被拒绝，从而把突发流量整形为平滑的请求序列
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// reserve 预占一个令牌，返回需要等待的时长
+// 令牌允许透支为负数，表示已排队的请求，保证先到先发
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > float64(b.burst) {
+			b.tokens = float64(b.burst)
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel 归还未使用的令牌（等待期间客户端断开）
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
+
+// providerPacer 按 provider 维护令牌桶，独立于客户端侧的限流
+type providerPacer struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// 测试中替换时钟，为 nil 时使用 time.Now
+	now func() time.Time
+}
+
+func newProviderPacer() *providerPacer {
+	return &providerPacer{buckets: make(map[string]*tokenBucket)}
+}
+
+// wait 阻塞直到该 provider 允许发出下一个请求
+// 未配置 RequestsPerSecond 的 provider 不做节流；返回实际等待时长
+func (pp *providerPacer) wait(ctx context.Context, kind string, provider Provider) (time.Duration, error) {
+	if provider.RequestsPerSecond <= 0 {
+		return 0, nil
+	}
+	bucket := pp.bucket(kind, provider)
+	delay := bucket.reserve(pp.clock())
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		bucket.cancel()
+		return 0, ctx.Err()
+	}
+}
+
+func (pp *providerPacer) clock() time.Time {
+	if pp.now != nil {
+		return pp.now()
+	}
+	return time.Now()
+}
+
+func (pp *providerPacer) bucket(kind string, provider Provider) *tokenBucket {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	key := poolKey(kind, provider.Name)
+	burst := provider.RequestBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	bucket := pp.buckets[key]
+	// 配置变更后重建令牌桶
+	if bucket == nil || bucket.rate != provider.RequestsPerSecond || bucket.burst != burst {
+		bucket = newTokenBucket(provider.RequestsPerSecond, burst, pp.clock())
+		pp.buckets[key] = bucket
+	}
+	return bucket
+}
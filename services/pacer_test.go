@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	type step struct {
+		// 距 start 的时间，cancel 为 true 时归还一个令牌而不预占
+		at     time.Duration
+		cancel bool
+		want   time.Duration
+	}
+	cases := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{
+			name: "突发用完后按速率排队", rate: 2, burst: 2,
+			steps: []step{{at: 0}, {at: 0}, {at: 0, want: 500 * time.Millisecond}, {at: 0, want: time.Second}},
+		},
+		{
+			name: "空闲期间按速率补充", rate: 2, burst: 3,
+			steps: []step{{at: 0}, {at: 0}, {at: 0}, {at: 0, want: 500 * time.Millisecond}, {at: 1500 * time.Millisecond}, {at: 1500 * time.Millisecond}, {at: 1500 * time.Millisecond, want: 500 * time.Millisecond}},
+		},
+		{
+			name: "补充不超过突发上限", rate: 10, burst: 2,
+			steps: []step{{at: time.Hour}, {at: time.Hour}, {at: time.Hour, want: 100 * time.Millisecond}},
+		},
+		{
+			name: "取消的预占归还令牌", rate: 1, burst: 1,
+			steps: []step{{at: 0}, {at: 0, want: time.Second}, {at: 0, cancel: true}, {at: 0, want: time.Second}},
+		},
+		{
+			name: "归还不超过突发上限", rate: 1, burst: 1,
+			steps: []step{{at: 0, cancel: true}, {at: 0, cancel: true}, {at: 0}, {at: 0, want: time.Second}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket := newTokenBucket(tc.rate, tc.burst, start)
+			for i, s := range tc.steps {
+				if s.cancel {
+					bucket.cancel()
+					continue
+				}
+				if got := bucket.reserve(start.Add(s.at)); got != s.want {
+					t.Fatalf("第 %d 步应等待 %s，实际 %s", i, s.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderPacerWait(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	pacer := newProviderPacer()
+	pacer.now = func() time.Time { return now }
+	provider := Provider{Name: "paced", RequestsPerSecond: 0.01}
+
+	if waited, err := pacer.wait(context.Background(), "claude", Provider{Name: "free"}); waited != 0 || err != nil {
+		t.Fatalf("未配置速率时不应等待: %s %v", waited, err)
+	}
+	if waited, err := pacer.wait(context.Background(), "claude", provider); waited != 0 || err != nil {
+		t.Fatalf("桶中有令牌时不应等待: %s %v", waited, err)
+	}
+
+	// 等待期间 ctx 取消，立即返回并归还预占的令牌
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := pacer.wait(ctx, "claude", provider)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("ctx 取消后应返回 context.Canceled: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ctx 取消后 wait 应立即返回")
+	}
+	if delay := pacer.bucket("claude", provider).reserve(now); delay != 100*time.Second {
+		t.Fatalf("取消的请求不应占用令牌: %s", delay)
+	}
+
+	// 配置变更后按新的速率重建令牌桶，补充按注入的时钟计算
+	provider.RequestsPerSecond = 100
+	if waited, err := pacer.wait(context.Background(), "claude", provider); waited != 0 || err != nil {
+		t.Fatalf("重建的令牌桶应是满的: %s %v", waited, err)
+	}
+	if waited, err := pacer.wait(context.Background(), "claude", provider); waited != 10*time.Millisecond || err != nil {
+		t.Fatalf("时钟未前进时应按速率等待: %s %v", waited, err)
+	}
+	now = now.Add(time.Second)
+	if waited, err := pacer.wait(context.Background(), "claude", provider); waited != 0 || err != nil {
+		t.Fatalf("时钟前进后应补充令牌: %s %v", waited, err)
+	}
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 预检各阶段的结果
+const (
+	PreflightOK   = "ok"
+	PreflightWarn = "warn"
+	PreflightFail = "fail"
+)
+
+// 预检的认证检查方式
+const (
+	// 不联网，使用模拟 Key 校验认证配置能否正确签名请求（默认）
+	PreflightAuthMock = "mock"
+	// 使用真实 Key 请求上游的模型列表接口，401/403 视为失败
+	PreflightAuthLive = "live"
+	// 跳过认证检查
+	PreflightAuthSkip = "skip"
+)
+
+const defaultPreflightAuthTimeout = 10 * time.Second
+
+// PreflightSpec 描述一次预检，通常以 YAML 保存在部署仓库中，用于在 CI 中拦截有问题的配置变更
+type PreflightSpec struct {
+	// 配置包路径（config export 的输出），相对路径基于预检文件所在目录；留空时检查本机当前配置
+	Bundle   string             `yaml:"bundle" json:"bundle"`
+	Pricing  PreflightPricing   `yaml:"pricing" json:"pricing"`
+	Auth     PreflightAuth      `yaml:"auth" json:"auth"`
+	Requests []PreflightRequest `yaml:"requests" json:"requests"`
+}
+
+// PreflightPricing 控制价格数据检查
+type PreflightPricing struct {
+	// 只使用内置价格数据，不读取缓存或请求远程
+	Offline bool `yaml:"offline" json:"offline"`
+	// 路由到的模型缺少价格数据时视为失败（默认仅警告）
+	RequireModels bool `yaml:"requireModels" json:"requireModels"`
+}
+
+// PreflightAuth 控制 provider 认证检查
+type PreflightAuth struct {
+	Mode           string  `yaml:"mode" json:"mode"`
+	TimeoutSeconds float64 `yaml:"timeoutSeconds" json:"timeoutSeconds"`
+}
+
+// PreflightRequest 是一条用于模拟路由的代表性请求
+type PreflightRequest struct {
+	Name     string `yaml:"name" json:"name"`
+	Platform string `yaml:"platform" json:"platform"`
+	Model    string `yaml:"model" json:"model"`
+	// 期望首选的 provider 与实际发送的模型，留空不检查
+	ExpectProvider string `yaml:"expectProvider" json:"expectProvider"`
+	ExpectModel    string `yaml:"expectModel" json:"expectModel"`
+}
+
+// PreflightCheck 是单项检查的结果
+type PreflightCheck struct {
+	Stage   string `json:"stage"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// PreflightReport 汇总所有检查结果
+type PreflightReport struct {
+	Checks   []PreflightCheck `json:"checks"`
+	Failures int              `json:"failures"`
+	Warnings int              `json:"warnings"`
+}
+
+// OK 判断预检是否没有失败项
+func (r PreflightReport) OK() bool {
+	return r.Failures == 0
+}
+
+func (r *PreflightReport) add(stage string, name string, status string, format string, args ...interface{}) {
+	check := PreflightCheck{Stage: stage, Name: name, Status: status}
+	if format != "" {
+		check.Message = fmt.Sprintf(format, args...)
+	}
+	switch status {
+	case PreflightFail:
+		r.Failures++
+	case PreflightWarn:
+		r.Warnings++
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// LoadPreflightSpec 读取预检文件（YAML 或 JSON）
+func LoadPreflightSpec(path string) (PreflightSpec, error) {
+	var spec PreflightSpec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("解析预检配置失败: %w", err)
+	}
+	if spec.Bundle != "" && !filepath.IsAbs(spec.Bundle) {
+		spec.Bundle = filepath.Join(filepath.Dir(path), spec.Bundle)
+	}
+	return spec, nil
+}
+
+// PreflightService 依次执行配置校验、价格数据加载、provider 认证检查与路由模拟
+type PreflightService struct {
+	providerService *ProviderService
+	relayConfig     *RelayConfigService
+	client          *http.Client
+}
+
+func NewPreflightService(ps *ProviderService, rcs *RelayConfigService) *PreflightService {
+	return &PreflightService{providerService: ps, relayConfig: rcs}
+}
+
+func (pfs *PreflightService) Start() error { return nil }
+func (pfs *PreflightService) Stop() error  { return nil }
+
+// Run 执行预检，配置无法加载时直接返回，其余单项失败不会中断后续检查
+func (pfs *PreflightService) Run(spec PreflightSpec) PreflightReport {
+	report := PreflightReport{Checks: []PreflightCheck{}}
+	providers, ok := pfs.checkConfig(spec, &report)
+	if !ok {
+		return report
+	}
+	pricing := pfs.checkPricing(spec, &report)
+	pfs.checkAuth(spec, providers, &report)
+	pfs.simulateRoutes(spec, providers, pricing, &report)
+	return report
+}
+
+// checkConfig 加载并校验 provider 与 relay 配置
+func (pfs *PreflightService) checkConfig(spec PreflightSpec, report *PreflightReport) (map[string][]Provider, bool) {
+	providers := make(map[string][]Provider, len(bundleKinds))
+	relayCfg := defaultRelayConfig()
+	source := "本机配置"
+	if spec.Bundle != "" {
+		source = spec.Bundle
+		data, err := os.ReadFile(spec.Bundle)
+		if err == nil {
+			var bundle ConfigBundle
+			if bundle, err = ParseConfigBundle(data); err == nil {
+				providers = bundle.Providers
+				if bundle.Relay != nil {
+					relayCfg = *bundle.Relay
+				}
+			}
+		}
+		if err != nil {
+			report.add("config", source, PreflightFail, "读取配置包失败: %v", err)
+			return nil, false
+		}
+	} else {
+		for _, kind := range bundleKinds {
+			list, err := pfs.providerService.LoadProviders(kind)
+			if err != nil {
+				report.add("config", kind, PreflightFail, "加载配置失败: %v", err)
+				return nil, false
+			}
+			for _, msg := range resolveProviderReferences(kind, list) {
+				report.add("config", kind, PreflightFail, "%s", msg)
+			}
+			providers[kind] = list
+		}
+		if pfs.relayConfig != nil {
+			cfg, err := pfs.relayConfig.GetRelayConfig()
+			if err != nil {
+				report.add("config", "relay", PreflightFail, "加载 relay 配置失败: %v", err)
+				return nil, false
+			}
+			relayCfg = cfg
+		}
+	}
+
+	for _, kind := range bundleKinds {
+		errs, warnings := providerConfigIssues(kind, providers[kind])
+		for _, msg := range errs {
+			report.add("config", kind, PreflightFail, "%s", msg)
+		}
+		for _, msg := range warnings {
+			report.add("config", kind, PreflightWarn, "%s", msg)
+		}
+	}
+	for _, rule := range relayCfg.Refusal.Rules {
+		for _, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				report.add("config", "relay/refusal", PreflightWarn, "分类 %s 的正则 %q 无效，运行时会被忽略: %v", rule.Tag, pattern, err)
+			}
+		}
+	}
+	report.add("config", source, PreflightOK, "已加载 %d 个 claude provider、%d 个 codex provider",
+		len(providers["claude"]), len(providers["codex"]))
+	return providers, true
+}
+
+// checkPricing 加载价格数据，失败时路由模拟不再检查价格
+func (pfs *PreflightService) checkPricing(spec PreflightSpec, report *PreflightReport) *modelpricing.Service {
+	var (
+		pricing *modelpricing.Service
+		err     error
+	)
+	if spec.Pricing.Offline {
+		pricing, err = modelpricing.NewService()
+	} else {
+		pricing, err = modelpricing.DefaultService()
+	}
+	if err != nil {
+		report.add("pricing", "load", PreflightFail, "加载价格数据失败: %v", err)
+		return nil
+	}
+	info := pricing.Info()
+	switch {
+	case info.Offline:
+		report.add("pricing", "load", PreflightOK, "价格数据处于离线模式，使用内置数据（%d 个模型）", info.ModelCount)
+	case info.Source == modelpricing.SourceEmbedded:
+		report.add("pricing", "load", PreflightOK, "使用内置价格数据（%d 个模型）", info.ModelCount)
+	case info.Stale:
+		report.add("pricing", "load", PreflightWarn, "价格数据已过期：更新于 %s，来自 %s（%d 个模型）",
+			info.FetchedAt.Format("2006-01-02 15:04"), info.SourceURL, info.ModelCount)
+	default:
+		report.add("pricing", "load", PreflightOK, "价格数据更新于 %s，来自 %s（%d 个模型）",
+			info.FetchedAt.Format("2006-01-02 15:04"), info.SourceURL, info.ModelCount)
+	}
+	return pricing
+}
+
+// checkAuth 检查每个启用的 provider 能否完成认证
+func (pfs *PreflightService) checkAuth(spec PreflightSpec, providers map[string][]Provider, report *PreflightReport) {
+	mode := strings.ToLower(strings.TrimSpace(spec.Auth.Mode))
+	if mode == "" {
+		mode = PreflightAuthMock
+	}
+	switch mode {
+	case PreflightAuthSkip:
+		return
+	case PreflightAuthMock, PreflightAuthLive:
+	default:
+		report.add("auth", "mode", PreflightFail, "不支持的认证检查方式: %s", spec.Auth.Mode)
+		return
+	}
+	client := pfs.client
+	if client == nil {
+		timeout := time.Duration(spec.Auth.TimeoutSeconds * float64(time.Second))
+		if timeout <= 0 {
+			timeout = defaultPreflightAuthTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	for _, kind := range bundleKinds {
+		for _, p := range providers[kind] {
+			if !p.Enabled {
+				continue
+			}
+			name := kind + "/" + p.Name
+			var err error
+			if mode == PreflightAuthLive {
+				err = liveAuthCheck(client, kind, p)
+			} else {
+				err = mockAuthCheck(kind, p)
+			}
+			if err != nil {
+				report.add("auth", name, PreflightFail, "%v", err)
+				continue
+			}
+			report.add("auth", name, PreflightOK, "")
+		}
+	}
+}
+
+// mockAuthCheck 使用模拟 Key 执行认证策略，不发送请求
+func mockAuthCheck(kind string, p Provider) error {
+	strategy, err := p.AuthStrategy()
+	if err != nil {
+		return err
+	}
+	req, err := authCheckRequest(kind, p)
+	if err != nil {
+		return err
+	}
+	key := "preflight-mock-key"
+	if _, ok := strategy.(awsSigV4Auth); ok {
+		key = "AKIDPREFLIGHT:preflight-mock-secret"
+	}
+	return strategy.Apply(req, key)
+}
+
+// liveAuthCheck 使用第一个 Key 请求上游的模型列表接口
+func liveAuthCheck(client *http.Client, kind string, p Provider) error {
+	// 本地 provider 可以不配置 Key，通过列出模型检查服务是否可用
+	if p.Local != nil {
+		_, err := listLocalModels(client, p)
+		return err
+	}
+	keys := p.AllAPIKeys()
+	if len(keys) == 0 || isSecretPlaceholder(keys[0]) {
+		return fmt.Errorf("未配置 API Key")
+	}
+	strategy, err := p.AuthStrategy()
+	if err != nil {
+		return err
+	}
+	req, err := authCheckRequest(kind, p)
+	if err != nil {
+		return err
+	}
+	if err := strategy.Apply(req, keys[0]); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求上游失败: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("上游拒绝认证: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func authCheckRequest(kind string, p Provider) (*http.Request, error) {
+	endpoint := "/v1/models"
+	if kind == "codex" {
+		endpoint = "/models"
+	}
+	req, err := http.NewRequest(http.MethodGet, joinURL(p.APIURL, endpoint), nil)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 apiUrl: %w", err)
+	}
+	if kind == "claude" {
+		req.Header.Set("anthropic-version", "2023-06-01")
+	}
+	return req, nil
+}
+
+// simulateRoutes 按代理的筛选规则计算每条代表性请求的降级顺序
+func (pfs *PreflightService) simulateRoutes(spec PreflightSpec, providers map[string][]Provider, pricing *modelpricing.Service, report *PreflightReport) {
+	for i, request := range spec.Requests {
+		kind := request.Platform
+		if kind == "" {
+			kind = "claude"
+		}
+		name := request.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d %s", i+1, request.Model)
+		}
+		var chain []string
+		var first Provider
+		for _, p := range providers[kind] {
+			if routeSkipReason(p, request.Model) != "" {
+				continue
+			}
+			if len(chain) == 0 {
+				first = p
+			}
+			chain = append(chain, fmt.Sprintf("%s(%s)", p.Name, p.GetEffectiveModel(request.Model)))
+		}
+		if len(chain) == 0 {
+			report.add("route", name, PreflightFail, "没有可用的 provider 支持 %s 模型 %s", kind, request.Model)
+			continue
+		}
+		route := strings.Join(chain, " -> ")
+		effectiveModel := first.GetEffectiveModel(request.Model)
+		if request.ExpectProvider != "" && request.ExpectProvider != first.Name {
+			report.add("route", name, PreflightFail, "期望首选 %s，实际为 %s", request.ExpectProvider, route)
+			continue
+		}
+		if request.ExpectModel != "" && request.ExpectModel != effectiveModel {
+			report.add("route", name, PreflightFail, "期望发送模型 %s，实际为 %s", request.ExpectModel, effectiveModel)
+			continue
+		}
+		if pricing != nil && effectiveModel != "" && !pricing.CalculateCost(effectiveModel, modelpricing.UsageSnapshot{}).HasPricing {
+			status := PreflightWarn
+			if spec.Pricing.RequireModels {
+				status = PreflightFail
+			}
+			report.add("route", name, status, "%s；模型 %s 缺少价格数据，费用将无法统计", route, effectiveModel)
+			continue
+		}
+		report.add("route", name, PreflightOK, "%s", route)
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreflightRoutesAndConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: "https://primary.example.com", APIKey: "k1", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-20250514": true}},
+		{ID: 2, Name: "backup", APIURL: "https://backup.example.com", APIKey: "k2", Enabled: true,
+			SupportedModels: map[string]bool{"claude-sonnet-4-5": true},
+			ModelMapping:    map[string]string{"claude-sonnet-4-20250514": "claude-sonnet-4-5"}},
+		{ID: 3, Name: "bedrock", APIURL: "https://bedrock.example.com", APIKey: "k3", Enabled: true,
+			Auth: &AuthConfig{Type: AuthTypeAWS, Region: "us-east-1"}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	spec := PreflightSpec{
+		Pricing: PreflightPricing{Offline: true},
+		Requests: []PreflightRequest{
+			{Name: "sonnet", Model: "claude-sonnet-4-20250514", ExpectProvider: "primary"},
+			{Name: "wrong-expectation", Model: "claude-sonnet-4-20250514", ExpectProvider: "backup"},
+			{Name: "unroutable", Platform: "codex", Model: "gpt-5"},
+		},
+	}
+	report := NewPreflightService(ps, NewRelayConfigService()).Run(spec)
+	statuses := make(map[string]string)
+	for _, check := range report.Checks {
+		statuses[check.Stage+":"+check.Name] = check.Status
+	}
+	if statuses["route:sonnet"] != PreflightOK {
+		t.Fatalf("sonnet 路由应通过: %+v", report.Checks)
+	}
+	if statuses["route:wrong-expectation"] != PreflightFail || statuses["route:unroutable"] != PreflightFail {
+		t.Fatalf("不符合期望的路由应失败: %+v", report.Checks)
+	}
+	// 模拟 Key 可以完成 SigV4 签名
+	if statuses["auth:claude/bedrock"] != PreflightOK {
+		t.Fatalf("mock 认证检查应通过: %+v", report.Checks)
+	}
+	if report.OK() || report.Failures != 2 {
+		t.Fatalf("应有 2 项失败: %+v", report)
+	}
+}
+
+func TestPreflightLiveAuth(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	bundle := `{"version":1,"providers":{"claude":[
+		{"id":1,"name":"good","apiUrl":"` + server.URL + `","apiKey":"good","enabled":true},
+		{"id":2,"name":"revoked","apiUrl":"` + server.URL + `","apiKey":"bad","enabled":true},
+		{"id":3,"name":"redacted","apiUrl":"` + server.URL + `","apiKey":"<fill-in-secret>","enabled":true}
+	]}}`
+	if err := os.WriteFile(filepath.Join(dir, "bundle.json"), []byte(bundle), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	specPath := filepath.Join(dir, "preflight.yaml")
+	if err := os.WriteFile(specPath, []byte("bundle: bundle.json\npricing:\n  offline: true\nauth:\n  mode: live\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadPreflightSpec(specPath)
+	if err != nil {
+		t.Fatalf("读取预检配置失败: %v", err)
+	}
+
+	report := NewPreflightService(NewProviderService(), nil).Run(spec)
+	statuses := make(map[string]string)
+	for _, check := range report.Checks {
+		statuses[check.Stage+":"+check.Name] = check.Status
+	}
+	if statuses["auth:claude/good"] != PreflightOK {
+		t.Fatalf("有效 Key 应通过认证检查: %+v", report.Checks)
+	}
+	if statuses["auth:claude/revoked"] != PreflightFail || statuses["auth:claude/redacted"] != PreflightFail {
+		t.Fatalf("无效或未填写的 Key 应失败: %+v", report.Checks)
+	}
+}
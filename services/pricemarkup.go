@@ -0,0 +1,65 @@
+package services
+
+import (
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+// CostOptions 返回该 provider 的计价调整（价格倍率、按次附加费与 Azure 部署对应的计价模型），本地 provider 不计费
+func (p Provider) CostOptions() []modelpricing.CostOption {
+	if p.Local != nil {
+		return []modelpricing.CostOption{modelpricing.WithZeroCost()}
+	}
+	var opts []modelpricing.CostOption
+	if p.PriceMultiplier > 0 {
+		opts = append(opts, modelpricing.WithMarkup(p.PriceMultiplier, 0))
+	}
+	if p.RequestFee > 0 {
+		opts = append(opts, modelpricing.WithSurcharge(p.RequestFee))
+	}
+	if p.Azure != nil && len(p.Azure.Deployments) > 0 {
+		opts = append(opts, modelpricing.WithModelAliases(p.Azure.Deployments))
+	}
+	return opts
+}
+
+// providerMarkups 保存各 provider 的计价调整，key 为 poolKey(platform, provider)
+// 日志只记录 provider 名称，统计时按当前配置查找；已删除的 provider 按官方价格计算
+type providerMarkups map[string][]modelpricing.CostOption
+
+// loadProviderMarkups 读取所有平台的 provider 配置，读取失败的平台按官方价格计算
+func loadProviderMarkups() providerMarkups {
+	markups := providerMarkups{}
+	ps := NewProviderService()
+	for _, kind := range []string{"claude", "codex"} {
+		providers, err := ps.LoadProviders(kind)
+		if err != nil {
+			continue
+		}
+		for _, provider := range providers {
+			if opts := provider.CostOptions(); opts != nil {
+				markups[poolKey(kind, provider.Name)] = opts
+			}
+		}
+	}
+	return markups
+}
+
+func (m providerMarkups) options(platform string, provider string) []modelpricing.CostOption {
+	return m[poolKey(platform, provider)]
+}
+
+// forRecord 返回日志记录的计价调整，并按写入时间应用时段价格
+func (m providerMarkups) forRecord(record xdb.Record) []modelpricing.CostOption {
+	opts := m.options(record.GetString("platform"), record.GetString("provider"))
+	if createdAt, ok := parseCreatedAt(record); ok {
+		// 不修改共享的切片
+		opts = append(opts[:len(opts):len(opts)], modelpricing.WithRequestTime(createdAt))
+	}
+	return opts
+}
+
+func (m providerMarkups) forEntry(entry *ReqeustLog) []modelpricing.CostOption {
+	return m.forRecord(xdb.Record{"platform": entry.Platform, "provider": entry.Provider, "created_at": entry.CreatedAt})
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+func TestProviderPriceMarkup(t *testing.T) {
+	pricing, err := modelpricing.NewServiceFromData([]byte(`{"test-model":{"input_cost_per_token":0.000001,"output_cost_per_token":0.000002}}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	usage := modelpricing.UsageSnapshot{InputTokens: 1000, OutputTokens: 500}
+	official := pricing.CalculateCost("test-model", usage)
+
+	reseller := Provider{PriceMultiplier: 1.5, RequestFee: 0.01}
+	cost := pricing.CalculateCost("test-model", usage, reseller.CostOptions()...)
+	if want := official.TotalCost*1.5 + 0.01; math.Abs(cost.TotalCost-want) > 1e-12 {
+		t.Fatalf("TotalCost = %v，期望 %v", cost.TotalCost, want)
+	}
+	if math.Abs(cost.InputCost-official.InputCost*1.5) > 1e-12 || cost.SurchargeCost != 0.01 {
+		t.Fatalf("费用明细未按倍率调整: %+v", cost)
+	}
+
+	// 只配置按次附加费时 token 费用按官方价格计算
+	relay := pricing.CalculateCost("test-model", usage, Provider{RequestFee: 0.02}.CostOptions()...)
+	if relay.SurchargeCost != 0.02 || math.Abs(relay.TotalCost-(official.TotalCost+0.02)) > 1e-12 {
+		t.Fatalf("按次附加费计算错误: %+v", relay)
+	}
+
+	// 未产生用量的请求不收取按次费用
+	if failed := pricing.CalculateCost("test-model", modelpricing.UsageSnapshot{}, reseller.CostOptions()...); failed.TotalCost != 0 {
+		t.Fatalf("失败请求不应计费: %+v", failed)
+	}
+	if opts := (Provider{}).CostOptions(); opts != nil {
+		t.Fatalf("未配置时应按官方价格计算")
+	}
+	if errs := (&Provider{PriceMultiplier: -1}).ValidateConfiguration(); len(errs) == 0 {
+		t.Fatalf("负数倍率应报错")
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PricingSummary 是 GET /api/pricing 的响应
+type PricingSummary struct {
+	Pricing modelpricing.Info `json:"pricing"`
+	// 生效的模型别名（含内置别名）
+	Aliases map[string]string `json:"aliases"`
+	// 价格数据中的模型名（按名称排序），可按 filter / provider / mode 过滤
+	Models []string `json:"models"`
+}
+
+// PricingModel 是 GET /api/pricing/{model} 的响应，模型名的匹配方式与费用统计相同
+type PricingModel struct {
+	Model         string                    `json:"model"`
+	Match         modelpricing.ModelMatch   `json:"match"`
+	Entry         modelpricing.PricingEntry `json:"entry"`
+	ContextWindow PricingContextWindow      `json:"context_window"`
+	// 带 explain=true 参数时返回每一步匹配尝试
+	Steps   []modelpricing.MatchStep `json:"steps,omitempty"`
+	Pricing modelpricing.Info        `json:"pricing"`
+}
+
+// PricingContextWindow 是模型的 token 上限，缺少 max_input_tokens / max_output_tokens 时按 max_tokens 计算
+type PricingContextWindow struct {
+	MaxInputTokens  int `json:"max_input_tokens"`
+	MaxOutputTokens int `json:"max_output_tokens"`
+}
+
+// registerPricingRoutes 注册只读的价格查询接口，供状态栏脚本与外部看板复用代理的模型匹配与价格数据
+func (prs *ProviderRelayService) registerPricingRoutes(router gin.IRouter) {
+	api := router.Group("/api/pricing", loopbackOnly)
+	api.GET("", pricingSummaryHandler)
+	// 模型名可能包含 /（如 openrouter/anthropic/claude-sonnet-4.5）
+	api.GET("/*model", pricingModelHandler)
+}
+
+func pricingSummaryHandler(c *gin.Context) {
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "价格数据不可用"})
+		return
+	}
+	filter := strings.ToLower(strings.TrimSpace(c.Query("filter")))
+	provider, mode := strings.TrimSpace(c.Query("provider")), strings.TrimSpace(c.Query("mode"))
+	models := make([]string, 0)
+	for _, model := range pricing.ListModels() {
+		if filter != "" && !strings.Contains(strings.ToLower(model), filter) {
+			continue
+		}
+		if provider != "" || mode != "" {
+			entry, _ := pricing.GetEntry(model)
+			if provider != "" && !strings.EqualFold(entry.LiteLLMProvider, provider) {
+				continue
+			}
+			if mode != "" && !strings.EqualFold(entry.Mode, mode) {
+				continue
+			}
+		}
+		models = append(models, model)
+	}
+	c.JSON(http.StatusOK, PricingSummary{Pricing: pricing.Info(), Aliases: pricing.Aliases(), Models: models})
+}
+
+func pricingModelHandler(c *gin.Context) {
+	model := strings.TrimPrefix(c.Param("model"), "/")
+	if model == "" {
+		pricingSummaryHandler(c)
+		return
+	}
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "价格数据不可用"})
+		return
+	}
+	explain, _ := strconv.ParseBool(c.Query("explain"))
+	var steps []modelpricing.MatchStep
+	if explain {
+		steps = pricing.ExplainMatch(model).Steps
+	}
+	entry, found := pricing.GetEntry(model)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "价格数据中没有匹配的模型",
+			"model": model,
+			"match": pricing.MatchModel(model),
+			"steps": steps,
+		})
+		return
+	}
+	maxInput, maxOutput, _ := pricing.ContextWindow(model)
+	c.JSON(http.StatusOK, PricingModel{
+		Model:         model,
+		Match:         pricing.MatchModel(model),
+		Entry:         entry,
+		ContextWindow: PricingContextWindow{MaxInputTokens: maxInput, MaxOutputTokens: maxOutput},
+		Steps:         steps,
+		Pricing:       pricing.Info(),
+	})
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPricingAPI(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	relay := NewProviderRelayService(NewProviderService(), NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	get := func(path string, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/pricing?filter=claude-sonnet-4-5&provider=anthropic", "127.0.0.1:40000")
+	var summary PricingSummary
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &summary) != nil {
+		t.Fatalf("查询价格数据失败 %d: %s", rec.Code, rec.Body.String())
+	}
+	if summary.Pricing.ModelCount == 0 || summary.Aliases["gpt-5-codex"] != "gpt-5" || len(summary.Models) == 0 {
+		t.Fatalf("价格数据摘要错误: %+v", summary)
+	}
+	for _, model := range summary.Models {
+		if model == "us.anthropic.claude-sonnet-4-5-20250929-v1:0" {
+			t.Fatalf("应按 provider 过滤模型: %v", summary.Models)
+		}
+	}
+
+	rec = get("/api/pricing/claude-sonnet-4-5[1m]?explain=true", "127.0.0.1:40000")
+	var model PricingModel
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &model) != nil {
+		t.Fatalf("查询模型价格失败 %d: %s", rec.Code, rec.Body.String())
+	}
+	if model.Match.Key != "claude-sonnet-4-5" || model.Entry.InputCostPerToken == 0 || model.ContextWindow.MaxInputTokens != 1000000 ||
+		len(model.Steps) == 0 || model.Pricing.Version == "" {
+		t.Fatalf("模型价格信息错误: %+v", model)
+	}
+
+	// 模型名中的 / 作为名称的一部分
+	if rec := get("/api/pricing/bedrock/us-gov-east-1/claude-sonnet-4-5-20250929-v1:0", "127.0.0.1:40000"); rec.Code != http.StatusOK {
+		t.Fatalf("包含 / 的模型名应能查询: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/api/pricing/zzzz-unknown-zzzz", "127.0.0.1:40000"); rec.Code != http.StatusNotFound {
+		t.Fatalf("未知模型应返回 404: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/api/pricing/claude-sonnet-4-5", "192.0.2.1:40000"); rec.Code != http.StatusForbidden {
+		t.Fatalf("应拒绝远程访问: %d", rec.Code)
+	}
+}
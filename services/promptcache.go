@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// provider 的 cacheControl：默认按 relay 配置自动插入缓存断点
+	CacheControlAuto = ""
+	// 发送前删除请求中所有的 cache_control，用于会拒绝该字段的 Anthropic 兼容 provider
+	CacheControlStrip = "strip"
+	// 原样发送，不自动插入缓存断点
+	CacheControlKeep = "keep"
+	// 插入缓存断点（cacheControlAction 的返回值）
+	cacheControlInject = "inject"
+
+	// Anthropic 可缓存前缀的最小 token 数（Haiku 为 2048）
+	defaultPromptCacheMinTokens      = 1024
+	defaultPromptCacheMinTokensHaiku = 2048
+)
+
+var ephemeralCacheControl = []byte(`{"type":"ephemeral"}`)
+
+// PromptCacheConfig 控制发往 Anthropic 协议 provider 的请求是否自动插入 cache_control 缓存断点（默认关闭）
+// 请求本身已包含 cache_control 时（如 Claude Code）不做修改
+type PromptCacheConfig struct {
+	Enabled bool `json:"enabled"`
+	// 前缀（工具定义、系统提示）估算超过该 token 数时才插入断点，默认 1024，Haiku 模型为 2048
+	MinTokens int `json:"minTokens,omitempty"`
+}
+
+func (c PromptCacheConfig) minTokens(model string) int {
+	if c.MinTokens > 0 {
+		return c.MinTokens
+	}
+	if strings.Contains(strings.ToLower(model), "haiku") {
+		return defaultPromptCacheMinTokensHaiku
+	}
+	return defaultPromptCacheMinTokens
+}
+
+func validateCacheControl(mode string) error {
+	switch mode {
+	case CacheControlAuto, CacheControlStrip, CacheControlKeep:
+		return nil
+	}
+	return fmt.Errorf("不支持的 cacheControl: %s（可选 strip、keep）", mode)
+}
+
+// cacheControlAction 返回发往 provider 前对请求的 cache_control 做的处理：inject、strip，不处理时返回 ""
+// 只处理以 Anthropic Messages 格式发送的请求，转换为其他协议的请求由协议转换处理
+func (c PromptCacheConfig) cacheControlAction(provider Provider, kind string, endpoint string) string {
+	if kind != "claude" || endpoint != "/v1/messages" {
+		return ""
+	}
+	switch provider.APIFormat {
+	case "", APIFormatAnthropic, APIFormatBedrock, APIFormatVertex:
+	default:
+		return ""
+	}
+	switch provider.CacheControl {
+	case CacheControlStrip:
+		return CacheControlStrip
+	case CacheControlKeep:
+		return ""
+	}
+	if c.Enabled {
+		return cacheControlInject
+	}
+	return ""
+}
+
+// injectCacheControl 在足够长的稳定前缀末尾插入缓存断点：工具定义的最后一项与系统提示的最后一段。
+// 缓存按 tools -> system -> messages 的顺序匹配前缀，系统提示变化时仍可命中工具定义的缓存
+func injectCacheControl(body []byte, model string, minTokens int) ([]byte, error) {
+	if bytes.Contains(body, []byte(`"cache_control"`)) {
+		return body, nil
+	}
+	tools := gjson.GetBytes(body, "tools")
+	system := gjson.GetBytes(body, "system")
+	toolTokens := 0
+	if tools.IsArray() && len(tools.Array()) > 0 {
+		toolTokens, _ = countPromptTokens(model, tools.Raw)
+	}
+	systemTokens := 0
+	if system.Exists() {
+		systemTokens, _ = countPromptTokens(model, system.String())
+	}
+
+	var err error
+	if toolTokens >= minTokens {
+		path := fmt.Sprintf("tools.%d.cache_control", len(tools.Array())-1)
+		if body, err = sjson.SetRawBytes(body, path, ephemeralCacheControl); err != nil {
+			return nil, err
+		}
+	}
+	if systemTokens == 0 || toolTokens+systemTokens < minTokens {
+		return body, nil
+	}
+	if system.Type == gjson.String {
+		block, err := sjson.SetBytes([]byte(`{"type":"text"}`), "text", system.String())
+		if err != nil {
+			return nil, err
+		}
+		if block, err = sjson.SetRawBytes(block, "cache_control", ephemeralCacheControl); err != nil {
+			return nil, err
+		}
+		return sjson.SetRawBytes(body, "system", append(append([]byte("["), block...), ']'))
+	}
+	if blocks := system.Array(); len(blocks) > 0 {
+		return sjson.SetRawBytes(body, fmt.Sprintf("system.%d.cache_control", len(blocks)-1), ephemeralCacheControl)
+	}
+	return body, nil
+}
+
+// stripCacheControl 删除工具定义、系统提示与消息内容中的 cache_control
+func stripCacheControl(body []byte) ([]byte, error) {
+	if !bytes.Contains(body, []byte(`"cache_control"`)) {
+		return body, nil
+	}
+	var paths []string
+	collect := func(prefix string, value gjson.Result) {
+		value.ForEach(func(index, item gjson.Result) bool {
+			if item.Get("cache_control").Exists() {
+				paths = append(paths, fmt.Sprintf("%s.%d.cache_control", prefix, index.Int()))
+			}
+			return true
+		})
+	}
+	collect("tools", gjson.GetBytes(body, "tools"))
+	if system := gjson.GetBytes(body, "system"); system.IsArray() {
+		collect("system", system)
+	}
+	gjson.GetBytes(body, "messages").ForEach(func(index, message gjson.Result) bool {
+		if content := message.Get("content"); content.IsArray() {
+			collect(fmt.Sprintf("messages.%d.content", index.Int()), content)
+		}
+		return true
+	})
+	var err error
+	for _, path := range paths {
+		if body, err = sjson.DeleteBytes(body, path); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
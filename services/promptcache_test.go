@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestInjectCacheControlMarksLargePrefixes(t *testing.T) {
+	longText := strings.Repeat("You are a careful coding assistant. ", 400)
+	body := fmt.Sprintf(`{"model":"claude-sonnet-4-5","system":%q,"tools":[{"name":"a","input_schema":{}},{"name":"b","description":%q,"input_schema":{}}],"messages":[{"role":"user","content":"hi"}]}`, longText, longText)
+
+	result, err := injectCacheControl([]byte(body), "claude-sonnet-4-5", 1024)
+	if err != nil {
+		t.Fatalf("插入缓存断点失败: %v", err)
+	}
+	if !gjson.GetBytes(result, "tools.1.cache_control").Exists() || gjson.GetBytes(result, "tools.0.cache_control").Exists() {
+		t.Fatalf("应在最后一个工具定义上插入断点: %s", gjson.GetBytes(result, "tools").Raw)
+	}
+	system := gjson.GetBytes(result, "system")
+	if !system.IsArray() || system.Get("0.text").String() != longText || system.Get("0.cache_control.type").String() != "ephemeral" {
+		t.Fatalf("字符串系统提示应转换为带断点的文本块: %s", system.Raw)
+	}
+
+	short := []byte(`{"model":"claude-sonnet-4-5","system":[{"type":"text","text":"be brief"}],"messages":[{"role":"user","content":"hi"}]}`)
+	if result, _ := injectCacheControl(short, "claude-sonnet-4-5", 1024); string(result) != string(short) {
+		t.Fatalf("较短的前缀不应插入断点: %s", result)
+	}
+	managed := []byte(fmt.Sprintf(`{"system":[{"type":"text","text":%q},{"type":"text","text":"x","cache_control":{"type":"ephemeral"}}]}`, longText))
+	if result, _ := injectCacheControl(managed, "claude-sonnet-4-5", 1024); string(result) != string(managed) {
+		t.Fatalf("客户端已设置 cache_control 时不应修改: %s", result)
+	}
+}
+
+func TestStripCacheControl(t *testing.T) {
+	body := []byte(`{"system":[{"type":"text","text":"s","cache_control":{"type":"ephemeral"}}],"tools":[{"name":"a","cache_control":{"type":"ephemeral"}}],"messages":[{"role":"user","content":[{"type":"text","text":"a"},{"type":"text","text":"b","cache_control":{"type":"ephemeral"}}]}]}`)
+	result, err := stripCacheControl(body)
+	if err != nil {
+		t.Fatalf("删除 cache_control 失败: %v", err)
+	}
+	if strings.Contains(string(result), "cache_control") {
+		t.Fatalf("应删除所有 cache_control: %s", result)
+	}
+	if gjson.GetBytes(result, "messages.0.content.1.text").String() != "b" || gjson.GetBytes(result, "system.0.text").String() != "s" {
+		t.Fatalf("不应修改其他内容: %s", result)
+	}
+}
+
+func TestCacheControlAction(t *testing.T) {
+	cfg := PromptCacheConfig{Enabled: true}
+	cases := []struct {
+		provider Provider
+		kind     string
+		endpoint string
+		want     string
+	}{
+		{Provider{}, "claude", "/v1/messages", cacheControlInject},
+		{Provider{APIFormat: APIFormatBedrock}, "claude", "/v1/messages", cacheControlInject},
+		{Provider{APIFormat: APIFormatOpenAIChat}, "claude", "/v1/messages", ""},
+		{Provider{CacheControl: CacheControlKeep}, "claude", "/v1/messages", ""},
+		{Provider{CacheControl: CacheControlStrip}, "claude", "/v1/messages", CacheControlStrip},
+		{Provider{}, "claude", "/v1/messages/count_tokens", ""},
+		{Provider{}, "codex", "/responses", ""},
+	}
+	for _, tc := range cases {
+		if got := cfg.cacheControlAction(tc.provider, tc.kind, tc.endpoint); got != tc.want {
+			t.Fatalf("%+v %s %s: 期望 %q，实际 %q", tc.provider, tc.kind, tc.endpoint, tc.want, got)
+		}
+	}
+	if got := (PromptCacheConfig{}).cacheControlAction(Provider{CacheControl: CacheControlStrip}, "claude", "/v1/messages"); got != CacheControlStrip {
+		t.Fatalf("未开启自动插入时仍应删除 cache_control: %q", got)
+	}
+	if (PromptCacheConfig{}).minTokens("claude-3-5-haiku-latest") != 2048 {
+		t.Fatalf("Haiku 模型的最小缓存长度应为 2048")
+	}
+	if errs := (&Provider{Name: "p", APIURL: "https://api.example.com", APIKey: "sk", CacheControl: "always"}).ValidateConfiguration(); !strings.Contains(strings.Join(errs, ";"), "cacheControl") {
+		t.Fatalf("应拒绝不支持的 cacheControl: %v", errs)
+	}
+}
+
+func TestRelayInjectsAndStripsCacheControl(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var primaryBody, strictBody string
+	newUpstream := func(received *string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			*received = string(data)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":10,"output_tokens":5}}`)
+		}))
+	}
+	primary := newUpstream(&primaryBody, http.StatusServiceUnavailable)
+	defer primary.Close()
+	strict := newUpstream(&strictBody, http.StatusOK)
+	defer strict.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: primary.URL, APIKey: "sk-primary-1234567890", Enabled: true},
+		{ID: 2, Name: "strict", APIURL: strict.URL, APIKey: "sk-strict-1234567890", Enabled: true, CacheControl: CacheControlStrip},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.PromptCache = PromptCacheConfig{Enabled: true}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	post := func(body string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	post(fmt.Sprintf(`{"model":"claude-sonnet-4-5","max_tokens":100,"system":%q,"messages":[{"role":"user","content":"hi"}]}`, strings.Repeat("Follow the repository conventions. ", 400)))
+	if gjson.Get(primaryBody, "system.0.cache_control.type").String() != "ephemeral" {
+		t.Fatalf("发往 Anthropic provider 的请求应插入缓存断点: %s", gjson.Get(primaryBody, "system").Raw)
+	}
+	if strictBody == "" || strings.Contains(strictBody, "cache_control") {
+		t.Fatalf("发往 strip provider 的请求不应包含 cache_control: %s", strictBody)
+	}
+
+	// 客户端自行设置的 cache_control 原样发送，仅对 strip provider 删除
+	post(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral"}}]}]}`)
+	if !gjson.Get(primaryBody, "messages.0.content.0.cache_control").Exists() {
+		t.Fatalf("客户端设置的 cache_control 应原样发送: %s", primaryBody)
+	}
+	if strings.Contains(strictBody, "cache_control") || gjson.Get(strictBody, "messages.0.content.0.text").String() != "hi" {
+		t.Fatalf("发往 strip provider 的请求不应包含 cache_control: %s", strictBody)
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 使用口令加密 Key 的配置包版本，旧版本导入时会拒绝而不是把密文当作 Key 保存
+	encryptedBundleVersion = 2
+	profileKDF             = "pbkdf2-sha256"
+	profileCipher          = "aes-256-gcm"
+	profileKDFIterations   = 600000
+	encryptedSecretPrefix  = "enc:"
+)
+
+// BundleEncryption 描述配置包中 Key 的加密方式，口令不会写入配置包
+type BundleEncryption struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Cipher     string `json:"cipher"`
+}
+
+// ProviderProfileOptions 选择导出的 provider 与 Key 的处理方式
+type ProviderProfileOptions struct {
+	// claude 或 codex，留空时导出两类 provider
+	Platform string `json:"platform"`
+	// provider 名称，留空时导出该平台的全部 provider
+	Names []string `json:"names"`
+	// 将 Key 替换为占位符，导入方需要自己补齐
+	RedactSecrets bool `json:"redactSecrets"`
+	// 使用口令加密 Key，导入方需要输入相同的口令
+	Passphrase string `json:"passphrase"`
+}
+
+// ExportProfile 导出选中的 provider（不含 relay 配置），Key 可以替换为占位符或使用口令加密
+func (cbs *ConfigBundleService) ExportProfile(opts ProviderProfileOptions) (ConfigBundle, error) {
+	if opts.RedactSecrets && opts.Passphrase != "" {
+		return ConfigBundle{}, errors.New("不能同时隐藏与加密 Key")
+	}
+	kinds := bundleKinds
+	if platform := strings.ToLower(strings.TrimSpace(opts.Platform)); platform != "" {
+		if platform != "claude" && platform != "codex" {
+			return ConfigBundle{}, fmt.Errorf("不支持的平台: %s", opts.Platform)
+		}
+		kinds = []string{platform}
+	}
+	bundle, err := cbs.BuildBundle(opts.RedactSecrets)
+	if err != nil {
+		return bundle, err
+	}
+	bundle.Relay = nil
+
+	wanted := make(map[string]bool, len(opts.Names))
+	for _, name := range opts.Names {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+	found := make(map[string]bool, len(wanted))
+	selected := make(map[string][]Provider, len(kinds))
+	for _, kind := range kinds {
+		providers := []Provider{}
+		for _, p := range bundle.Providers[kind] {
+			if len(wanted) > 0 && !wanted[p.Name] {
+				continue
+			}
+			found[p.Name] = true
+			providers = append(providers, p)
+		}
+		selected[kind] = providers
+	}
+	for name := range wanted {
+		if !found[name] {
+			return bundle, fmt.Errorf("provider 不存在: %s", name)
+		}
+	}
+	bundle.Providers = selected
+
+	if opts.Passphrase != "" {
+		// 引用钥匙串的 Key 在其他机器上无法读取，加密前替换为实际的值；${VAR} 与 file: 引用保留，由导入方的环境补齐
+		if err := forEachBundleSecret(&bundle, ResolveSecret); err != nil {
+			return bundle, err
+		}
+		if err := encryptBundleSecrets(&bundle, opts.Passphrase); err != nil {
+			return bundle, err
+		}
+	}
+	return bundle, nil
+}
+
+// ExportProfileFile 将 provider 配置导出到 path
+func (cbs *ConfigBundleService) ExportProfileFile(path string, opts ProviderProfileOptions) error {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return fmt.Errorf("export path is required")
+	}
+	bundle, err := cbs.ExportProfile(opts)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// ImportProfile 导入他人分享的 provider 配置，合并规则与 ImportBundle 相同；
+// 只导入 provider，配置包中的 relay 配置会被忽略（需要时使用 config import）
+func (cbs *ConfigBundleService) ImportProfile(data []byte, passphrase string, dryRun bool) (ConfigBundleImportResult, error) {
+	bundle, err := ParseConfigBundle(data)
+	if err != nil {
+		return ConfigBundleImportResult{}, err
+	}
+	if err := UnlockBundle(&bundle, passphrase); err != nil {
+		return ConfigBundleImportResult{}, err
+	}
+	bundle.Relay = nil
+	return cbs.ImportBundle(bundle, dryRun)
+}
+
+// ImportProfileFile 从文件读取并导入 provider 配置
+func (cbs *ConfigBundleService) ImportProfileFile(path string, passphrase string, dryRun bool) (ConfigBundleImportResult, error) {
+	data, err := os.ReadFile(strings.TrimSpace(path))
+	if err != nil {
+		return ConfigBundleImportResult{}, err
+	}
+	return cbs.ImportProfile(data, passphrase, dryRun)
+}
+
+// UnlockBundle 使用口令解密配置包中的 Key，未加密的配置包不做处理
+func UnlockBundle(bundle *ConfigBundle, passphrase string) error {
+	if bundle.Encryption == nil {
+		return nil
+	}
+	if passphrase == "" {
+		return errors.New("配置包中的 Key 已使用口令加密，请提供口令")
+	}
+	aead, err := bundleCipher(*bundle.Encryption, passphrase)
+	if err != nil {
+		return err
+	}
+	err = forEachBundleSecret(bundle, func(value string) (string, error) {
+		if !strings.HasPrefix(value, encryptedSecretPrefix) {
+			return value, nil
+		}
+		return openSecret(aead, value)
+	})
+	if err != nil {
+		return err
+	}
+	bundle.Encryption = nil
+	bundle.Version = configBundleVersion
+	return nil
+}
+
+func encryptBundleSecrets(bundle *ConfigBundle, passphrase string) error {
+	encryption, err := newBundleEncryption()
+	if err != nil {
+		return err
+	}
+	aead, err := bundleCipher(encryption, passphrase)
+	if err != nil {
+		return err
+	}
+	err = forEachBundleSecret(bundle, func(value string) (string, error) {
+		return sealSecret(aead, value)
+	})
+	if err != nil {
+		return err
+	}
+	bundle.Encryption = &encryption
+	bundle.Version = encryptedBundleVersion
+	return nil
+}
+
+// newBundleEncryption 生成使用随机盐的加密参数
+func newBundleEncryption() (BundleEncryption, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return BundleEncryption{}, err
+	}
+	return BundleEncryption{
+		KDF:        profileKDF,
+		Iterations: profileKDFIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Cipher:     profileCipher,
+	}, nil
+}
+
+// sealSecret 加密单个 Key，结果为 enc:<base64(nonce|密文)>
+func sealSecret(aead cipher.AEAD, value string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+func openSecret(aead cipher.AEAD, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedSecretPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("加密的 Key 已损坏")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("口令错误或内容已被修改")
+	}
+	return string(plain), nil
+}
+
+func bundleCipher(encryption BundleEncryption, passphrase string) (cipher.AEAD, error) {
+	if encryption.KDF != profileKDF || encryption.Cipher != profileCipher || encryption.Iterations <= 0 {
+		return nil, fmt.Errorf("不支持的加密方式: %s/%s", encryption.KDF, encryption.Cipher)
+	}
+	salt, err := base64.StdEncoding.DecodeString(encryption.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("加密参数无效: %w", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, encryption.Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// forEachBundleSecret 依次替换配置包中非空、非占位符的 Key
+func forEachBundleSecret(bundle *ConfigBundle, transform func(string) (string, error)) error {
+	apply := func(value *string) error {
+		if strings.TrimSpace(*value) == "" || isSecretPlaceholder(*value) {
+			return nil
+		}
+		replaced, err := transform(*value)
+		if err != nil {
+			return err
+		}
+		*value = replaced
+		return nil
+	}
+	for _, providers := range bundle.Providers {
+		for i := range providers {
+			if err := apply(&providers[i].APIKey); err != nil {
+				return err
+			}
+			for j := range providers[i].APIKeys {
+				if err := apply(&providers[i].APIKeys[j]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// profileImportRequest 是 POST /admin/profiles/import 的请求体
+type profileImportRequest struct {
+	Profile    json.RawMessage `json:"profile"`
+	Passphrase string          `json:"passphrase"`
+}
+
+// registerProfileRoutes 注册 provider 配置的导出与导入接口；口令只通过请求体传递，避免出现在访问日志中。
+// 通过 HTTP 导出时 Key 总是隐藏或加密，不返回明文
+func (prs *ProviderRelayService) registerProfileRoutes(admin gin.IRouter) {
+	admin.POST("/profiles/export", func(c *gin.Context) {
+		if !requireContentType(c, "application/json") {
+			return
+		}
+		var opts ProviderProfileOptions
+		if body, err := io.ReadAll(c.Request.Body); err != nil || (len(body) > 0 && json.Unmarshal(body, &opts) != nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		opts.RedactSecrets = opts.Passphrase == ""
+		bundle, err := NewConfigBundleService(prs.providerService, prs.relayConfig).ExportProfile(opts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, bundle)
+	})
+	admin.POST("/profiles/import", func(c *gin.Context) {
+		if !requireContentType(c, "application/json") {
+			return
+		}
+		var req profileImportRequest
+		if body, err := io.ReadAll(c.Request.Body); err != nil || json.Unmarshal(body, &req) != nil || len(req.Profile) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+		result, err := NewConfigBundleService(prs.providerService, prs.relayConfig).ImportProfile(req.Profile, req.Passphrase, dryRun)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
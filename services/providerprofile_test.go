@@ -0,0 +1,119 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProviderProfileEncryptsSecrets(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "team", APIURL: "https://team.example.com", APIKey: "sk-team-1234567890", APIKeys: []string{"sk-team-backup-1234567890"}, Enabled: true},
+		{ID: 2, Name: "personal", APIURL: "https://personal.example.com", APIKey: "sk-personal-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	cbs := NewConfigBundleService(ps, NewRelayConfigService())
+	if _, err := cbs.ExportProfile(ProviderProfileOptions{Names: []string{"missing"}}); err == nil {
+		t.Fatalf("导出不存在的 provider 应报错")
+	}
+	bundle, err := cbs.ExportProfile(ProviderProfileOptions{Platform: "claude", Names: []string{"team"}, Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	data, _ := json.Marshal(bundle)
+	if strings.Contains(string(data), "sk-team") || bundle.Relay != nil || len(bundle.Providers["claude"]) != 1 || bundle.Version != encryptedBundleVersion {
+		t.Fatalf("导出的配置应只包含选中的 provider 且 Key 已加密: %s", data)
+	}
+
+	// 团队成员在另一台机器上导入
+	t.Setenv("HOME", t.TempDir())
+	cbs = NewConfigBundleService(NewProviderService(), NewRelayConfigService())
+	for _, passphrase := range []string{"", "wrong horse"} {
+		if _, err := cbs.ImportProfile(data, passphrase, false); err == nil {
+			t.Fatalf("口令 %q 不正确时应拒绝导入", passphrase)
+		}
+	}
+	parsed, err := ParseConfigBundle(data)
+	if err != nil {
+		t.Fatalf("解析配置包失败: %v", err)
+	}
+	if _, err := cbs.ImportBundle(parsed, false); err == nil {
+		t.Fatalf("未解密的配置包不应直接导入")
+	}
+	result, err := cbs.ImportProfile(data, "correct horse", false)
+	if err != nil || len(result.Created) != 1 || len(result.MissingSecrets) != 0 {
+		t.Fatalf("使用正确的口令应导入成功: %+v %v", result, err)
+	}
+	providers, _ := NewProviderService().LoadProviders("claude")
+	if len(providers) != 1 || providers[0].APIKey != "sk-team-1234567890" || providers[0].APIKeys[0] != "sk-team-backup-1234567890" {
+		t.Fatalf("导入后应恢复原始的 Key: %+v", providers)
+	}
+}
+
+func TestProviderProfileAdminRoutes(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	ps := NewProviderService()
+	if err := ps.SaveProviders("codex", []Provider{
+		{ID: 1, Name: "shared", APIURL: "https://shared.example.com", APIKey: "sk-shared-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	send := func(path string, contentType string, origin string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:4321"
+		req.Host = "127.0.0.1:18100"
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		return send(path, "application/json", "", body)
+	}
+
+	// 未要求隐藏 Key 时也不返回明文
+	rec := post("/admin/profiles/export", `{"platform":"codex"}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sk-shared") || !strings.Contains(rec.Body.String(), `"redacted":true`) {
+		t.Fatalf("导出接口应返回隐藏 Key 的配置: %d %s", rec.Code, rec.Body.String())
+	}
+	if encrypted := post("/admin/profiles/export", `{"platform":"codex","passphrase":"correct horse"}`); encrypted.Code != http.StatusOK ||
+		strings.Contains(encrypted.Body.String(), "sk-shared") || strings.Contains(encrypted.Body.String(), `"redacted":true`) {
+		t.Fatalf("提供口令时应返回加密的 Key: %d %s", encrypted.Code, encrypted.Body.String())
+	}
+	// 跨站页面发送的 text/plain 请求与带 Origin 的请求都应被拒绝
+	for _, path := range []string{"/admin/profiles/export", "/admin/profiles/import"} {
+		if rec := send(path, "text/plain", "", `{}`); rec.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("%s 应拒绝 text/plain 请求: %d", path, rec.Code)
+		}
+		if rec := send(path, "text/plain", "https://evil.example", `{}`); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s 应拒绝跨站请求: %d", path, rec.Code)
+		}
+	}
+	profile := strings.Replace(rec.Body.String(), `"name":"shared"`, `"name":"teammate"`, 1)
+	rec = post("/admin/profiles/import?dry_run=true", `{"profile":`+profile+`}`)
+	var result ConfigBundleImportResult
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &result) != nil || !result.DryRun ||
+		strings.Join(result.Created, ",") != "codex/teammate" || strings.Join(result.MissingSecrets, ",") != "codex/teammate" {
+		t.Fatalf("导入接口应返回合并结果: %d %s", rec.Code, rec.Body.String())
+	}
+	if providers, _ := ps.LoadProviders("codex"); len(providers) != 1 {
+		t.Fatalf("dry_run 不应写入配置: %+v", providers)
+	}
+	if rec := post("/admin/profiles/import", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("缺少配置包时应返回 400: %d", rec.Code)
+	}
+}
@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	modelpricing "codeswitch/resources/model-pricing"
+
 	"github.com/daodao97/xgo/xdb"
 	"github.com/daodao97/xgo/xrequest"
 	"github.com/gin-gonic/gin"
@@ -22,15 +27,75 @@ import (
 
 type ProviderRelayService struct {
 	providerService *ProviderService
+	relayConfig     *RelayConfigService
 	server          *http.Server
+	// relay 配置中 listeners 的 HTTP 服务
+	listenerServers []*http.Server
 	addr            string
+	version         string
+	keyPool         *apiKeyPool
+	pacer           *providerPacer
+	concurrency     *concurrencyLimiter
+	responses       *responseCache
+	dedup           *requestDeduper
+	queue           *requestQueue
+	health          *healthTracker
+	latency         *latencyTracker
+	overloads       *overloadTracker
+	refusals        *refusalTracker
+	inflight        *inflightRegistry
+	sessions        *stickySessionTracker
+	quotas          *quotaTracker
+	clients         *upstreamClients
+	reloader        *configReloader
+	retryHooks      retryHookSet
+	qualityScorers  qualityScorerSet
+	middlewares     middlewareSet
+
+	// OpenRouter provider 的额度查询缓存
+	openRouterCredits *openRouterCreditsCache
 }
 
-func NewProviderRelayService(providerService *ProviderService, addr string) *ProviderRelayService {
+func NewProviderRelayService(providerService *ProviderService, relayConfig *RelayConfigService, addr string) *ProviderRelayService {
 	if addr == "" {
 		addr = ":18100"
 	}
 
+	if err := InitDatabase(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	prs := &ProviderRelayService{
+		providerService: providerService,
+		relayConfig:     relayConfig,
+		addr:            addr,
+		keyPool:         newAPIKeyPool(),
+		pacer:           newProviderPacer(),
+		concurrency:     newConcurrencyLimiter(),
+		responses:       newResponseCache(),
+		dedup:           newRequestDeduper(),
+		queue:           newRequestQueue(queueDir()),
+		health:          newHealthTracker(),
+		latency:         newLatencyTracker(),
+		overloads:       newOverloadTracker(),
+		refusals:        newRefusalTracker(),
+		inflight:        newInflightRegistry(),
+		sessions:        newStickySessionTracker(),
+		quotas:          newQuotaTracker(),
+		clients:         newUpstreamClients(),
+		reloader:        newConfigReloader(),
+
+		openRouterCredits: newOpenRouterCreditsCache(),
+	}
+	prs.AddMiddleware(MiddlewareOrderHeaders, headerMiddleware{})
+	prs.AddMiddleware(MiddlewareOrderModel, modelMiddleware{})
+	prs.AddMiddleware(MiddlewareOrderUsage, usageMiddleware{})
+	return prs
+}
+
+// InitDatabase 初始化 ~/.code-switch/app.db 并确保 request_log 表结构最新
+// relay 服务与命令行子命令共用同一个数据库
+func InitDatabase() error {
 	home, _ := os.UserHomeDir()
 
 	if err := xdb.Inits([]xdb.Config{
@@ -40,15 +105,20 @@ func NewProviderRelayService(providerService *ProviderService, addr string) *Pro
 			DSN:    filepath.Join(home, ".code-switch", "app.db?cache=shared&mode=rwc"),
 		},
 	}); err != nil {
-		fmt.Printf("初始化数据库失败: %v\n", err)
-	} else if err := ensureRequestLogTable(); err != nil {
-		fmt.Printf("初始化 request_log 表失败: %v\n", err)
+		return fmt.Errorf("初始化数据库失败: %w", err)
 	}
+	if err := ensureRequestLogTable(); err != nil {
+		return fmt.Errorf("初始化 request_log 表失败: %w", err)
+	}
+	return nil
+}
 
-	return &ProviderRelayService{
-		providerService: providerService,
-		addr:            addr,
+func queueDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(home, ".code-switch", "queue")
 }
 
 func (prs *ProviderRelayService) Start() error {
@@ -69,13 +139,27 @@ func (prs *ProviderRelayService) Start() error {
 		Handler: router,
 	}
 
-	fmt.Printf("provider relay server listening on %s\n", prs.addr)
-
-	go func() {
-		if err := prs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("provider relay server error: %v\n", err)
+	// 同一个 server 同时服务 TCP 端口与 Unix socket，Stop 时一起关闭
+	socket := prs.loadRelayConfig().Socket
+	if socket.Enabled {
+		if err := prs.serveSocket(socket); err != nil {
+			if socket.DisableTCP {
+				return fmt.Errorf("监听 Unix socket 失败: %w", err)
+			}
+			fmt.Printf("[WARN] 监听 Unix socket 失败，只监听 TCP 端口: %v\n", err)
 		}
-	}()
+	}
+	prs.watchConfig()
+
+	if !(socket.Enabled && socket.DisableTCP) {
+		fmt.Printf("provider relay server listening on %s\n", prs.addr)
+		go func() {
+			if err := prs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("provider relay server error: %v\n", err)
+			}
+		}()
+	}
+	prs.startListeners()
 	return nil
 }
 
@@ -85,114 +169,247 @@ func (prs *ProviderRelayService) validateConfig() []string {
 	warnings := make([]string, 0)
 
 	for _, kind := range []string{"claude", "codex"} {
-		providers, err := prs.providerService.LoadProviders(kind)
+		providers, err := prs.loadProviders(kind)
 		if err != nil {
 			warnings = append(warnings, fmt.Sprintf("[%s] 加载配置失败: %v", kind, err))
 			continue
 		}
+		errs, kindWarnings := providerConfigIssues(kind, providers)
+		warnings = append(warnings, errs...)
+		warnings = append(warnings, kindWarnings...)
+	}
 
-		enabledCount := 0
-		for _, p := range providers {
-			if !p.Enabled {
-				continue
-			}
-			enabledCount++
+	return warnings
+}
 
-			// 验证每个启用的 provider
-			if errs := p.ValidateConfiguration(); len(errs) > 0 {
-				for _, errMsg := range errs {
-					warnings = append(warnings, fmt.Sprintf("[%s/%s] %s", kind, p.Name, errMsg))
-				}
-			}
+// providerConfigIssues 检查单个平台下启用的 provider 配置
+// errs 为校验失败（代理时该 provider 会被跳过），warnings 为可能影响降级的提示
+func providerConfigIssues(kind string, providers []Provider) (errs []string, warnings []string) {
+	enabledCount := 0
+	for _, p := range providers {
+		if !p.Enabled {
+			continue
+		}
+		enabledCount++
 
-			// 检查是否配置了模型白名单或映射
-			if (p.SupportedModels == nil || len(p.SupportedModels) == 0) &&
-				(p.ModelMapping == nil || len(p.ModelMapping) == 0) {
-				warnings = append(warnings, fmt.Sprintf(
-					"[%s/%s] 未配置 supportedModels 或 modelMapping，将假设支持所有模型（可能导致降级失败）",
-					kind, p.Name))
-			}
+		// 验证每个启用的 provider
+		for _, errMsg := range p.ValidateConfiguration() {
+			errs = append(errs, fmt.Sprintf("[%s/%s] %s", kind, p.Name, errMsg))
 		}
 
-		if enabledCount == 0 {
-			warnings = append(warnings, fmt.Sprintf("[%s] 没有启用的 provider", kind))
+		// 检查是否配置了模型白名单或映射
+		if (p.SupportedModels == nil || len(p.SupportedModels) == 0) &&
+			(p.ModelMapping == nil || len(p.ModelMapping) == 0) && len(p.ModelRewrites) == 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"[%s/%s] 未配置 supportedModels、modelMapping 或 modelRewrites，将假设支持所有模型（可能导致降级失败）",
+				kind, p.Name))
 		}
 	}
 
-	return warnings
+	if enabledCount == 0 {
+		warnings = append(warnings, fmt.Sprintf("[%s] 没有启用的 provider", kind))
+	}
+	return errs, warnings
 }
 
+// Stop 停止接受新请求，等待进行中的请求（含流式响应）在 relay 配置的 shutdown.drainSeconds 内完成后返回
 func (prs *ProviderRelayService) Stop() error {
-	if prs.server == nil {
-		return nil
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return prs.server.Shutdown(ctx)
+	prs.stopWatchingConfig()
+	return prs.drain(prs.loadRelayConfig().Shutdown.drainTimeout())
 }
 
 func (prs *ProviderRelayService) Addr() string {
 	return prs.addr
 }
 
+// AddRetryHooks 注册重试过程的回调钩子
+func (prs *ProviderRelayService) AddRetryHooks(hooks RetryHooks) {
+	prs.retryHooks.add(hooks)
+}
+
+// AddQualityScorer 注册质量评分钩子，用于识别内置规则之外的拒答（需开启 refusal 配置）
+func (prs *ProviderRelayService) AddQualityScorer(scorer QualityScorer) {
+	prs.qualityScorers.add(scorer)
+}
+
+// AddMiddleware 按 order 注册转发链的中间件（内置的 headers、model、usage 分别为 MiddlewareOrderHeaders、
+// MiddlewareOrderModel、MiddlewareOrderUsage），与已注册的中间件同名时替换它
+func (prs *ProviderRelayService) AddMiddleware(order int, middleware Middleware) {
+	prs.middlewares.add(order, middleware)
+}
+
+// ProviderHealth 返回各 provider 在最近窗口内的健康分
+func (prs *ProviderRelayService) ProviderHealth() []ProviderHealth {
+	return prs.health.snapshot()
+}
+
+// ProviderLatency 返回各 provider 上每个模型最近的首字节延迟
+func (prs *ProviderRelayService) ProviderLatency() []ProviderLatency {
+	return prs.latency.snapshot()
+}
+
+// APIKeyUsage 返回 Key 池中每个 Key 的使用与冷却情况
+func (prs *ProviderRelayService) APIKeyUsage() []APIKeyUsage {
+	return prs.keyPool.snapshot()
+}
+
+// ModelOverloads 返回各 provider 上每个模型的过载次数与冷却情况
+func (prs *ProviderRelayService) ModelOverloads() []ModelOverload {
+	return prs.overloads.snapshot()
+}
+
+// RefusalBlackouts 返回因反复拒答而被临时屏蔽的 provider/模型组合
+func (prs *ProviderRelayService) RefusalBlackouts() []RefusalBlackout {
+	return prs.refusals.snapshot()
+}
+
 func (prs *ProviderRelayService) registerRoutes(router gin.IRouter) {
 	router.POST("/v1/messages", prs.proxyHandler("claude", "/v1/messages"))
+	router.POST(countTokensEndpoint, prs.countTokensHandler)
 	router.POST("/responses", prs.proxyHandler("codex", "/responses"))
+	router.POST(chatCompletionsEndpoint, prs.proxyHandler("codex", chatCompletionsEndpoint))
+	router.POST(embeddingsEndpoint, prs.proxyHandler("codex", embeddingsEndpoint))
+	router.POST(rerankEndpoint, prs.proxyHandler("codex", rerankEndpoint))
+	router.POST(geminiInboundPath, prs.geminiHandler())
+	router.GET("/v1/client/hello", prs.clientHelloHandler)
+	router.POST("/v1/client/estimate", prs.clientEstimateHandler)
+	router.GET("/metrics", prs.metricsHandler)
+	prs.registerAdminRoutes(router)
+	prs.registerPricingRoutes(router)
 }
 
 func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var bodyBytes []byte
-		if c.Request.Body != nil {
-			data, err := io.ReadAll(c.Request.Body)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
-				return
-			}
-			bodyBytes = data
-			c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		relayCfg, listener, ok := prs.listenerConfig(c)
+		if !ok {
+			return
+		}
+		// 详细日志采样：[INFO] 日志在请求结束后按采样结果输出
+		requestID := newRequestID()
+		logs := newSampleDecision(relayCfg.LogSampling, requestID)
+		defer func() { logs.finish(c.Writer.Status()) }()
+		body, err := bufferRequestBody(c.Request.Body, relayCfg.BodyBuffer.MemoryLimitBytes, relayCfg.BodyBuffer.SpillDir)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		defer body.Close()
+		if body.spilled() {
+			c.Request.Body = http.NoBody
+		} else {
+			c.Request.Body = io.NopCloser(bytes.NewReader(body.data))
 		}
 
+		// 写入临时文件的请求体在这里临时读取一次用于解析元数据
+		bodyBytes, err := body.Bytes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read buffered request body"})
+			return
+		}
+		// 同时进行中的相同请求共享一次上游请求的响应
+		handled, finishDedup := prs.dedupRequest(c, relayCfg.Dedup, kind, endpoint, bodyBytes)
+		if handled {
+			return
+		}
+		defer finishDedup()
 		isStream := gjson.GetBytes(bodyBytes, "stream").Bool()
+		streamUsage := needsStreamUsage(endpoint, isStream, bodyBytes)
 		requestedModel := gjson.GetBytes(bodyBytes, "model").String()
+		sessionID := extractSessionID(kind, bodyBytes, c.Request.Header)
+		stickySession := relayCfg.Routing.stickySessionKey(kind, bodyBytes, c.Request.Header)
+		promptTags := relayCfg.Refusal.promptTags(kind, bodyBytes)
+		inputImages := countInputImages(kind, bodyBytes)
+		serviceTier := modelpricing.NormalizeServiceTier(gjson.GetBytes(bodyBytes, "service_tier").String())
+		clientKey := clientAPIKey(c.Request.Header)
+		if rule := relayCfg.ModelPolicy.deniedRequest(clientKey, requestedModel); rule != nil {
+			abortModelDenied(c, kind, requestedModel, rule)
+			return
+		}
+		// 超出预算的请求只能使用兜底的本地 provider，没有时拒绝
+		var overBudget string
+		var estimate RequestEstimate
+		if relayCfg.Client.enforcesBudget() && requestedModel != "" {
+			estimate = estimateRequest(kind, requestedModel, bodyBytes)
+			logs.logf("[INFO] 请求 %s 的估算费用 %s（提示词 %d tokens，预计输出 %d tokens）\n",
+				requestedModel, estimate.Display, estimate.PromptTokens, estimate.ExpectedOutputTokens)
+			reason, err := relayCfg.Client.budgetBlockReason(estimate)
+			if err != nil {
+				fmt.Printf("[WARN] 检查预算失败，不拦截请求: %v\n", err)
+			} else {
+				overBudget = reason
+			}
+		}
+		target := newRouteTarget(kind, requestedModel, bodyBytes, relayCfg.Routing)
+		promptTokens := relayCfg.Routing.longPromptTokens(kind, requestedModel, bodyBytes)
+		bodyBytes = nil
 
 		// 如果未指定模型，记录警告但不拦截
 		if requestedModel == "" {
 			fmt.Printf("[WARN] 请求未指定模型名，无法执行模型智能降级\n")
 		}
 
-		providers, err := prs.providerService.LoadProviders(kind)
+		providers, err := prs.listenerProviders(kind, listener)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load providers"})
 			return
 		}
+		// 模型规则禁止的 provider 不参与路由，降级链与小模型路由也不会使用
+		providers, policyDenied := relayCfg.ModelPolicy.filterProviders(clientKey, providers, requestedModel)
 
 		active := make([]Provider, 0, len(providers))
 		skippedCount := 0
-		for _, provider := range providers {
-			// 基础过滤：enabled、URL、APIKey
-			if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
-				continue
+		candidates := relayCfg.Routing.expandModelClass(providers, requestedModel)
+		// 模型类别展开后检查各 provider 实际使用的模型
+		candidates, classDenied := relayCfg.ModelPolicy.filterProviders(clientKey, candidates, requestedModel)
+		if policyDenied == nil {
+			policyDenied = classDenied
+		}
+		if long := relayCfg.Routing.routeLongPrompt(candidates, requestedModel, promptTokens); long != nil {
+			candidates = long
+		}
+		for _, provider := range candidates {
+			reason := routeSkipReason(provider, provider.routeModel(requestedModel))
+			if reason == "" {
+				reason = prs.quotas.blockReason(kind, provider)
 			}
-
-			// 配置验证：失败则自动跳过
-			if errs := provider.ValidateConfiguration(); len(errs) > 0 {
-				fmt.Printf("[WARN] Provider %s 配置验证失败，已自动跳过: %v\n", provider.Name, errs)
+			switch reason {
+			case "":
+				active = append(active, provider)
+			case routeSkipInactive:
+			default:
+				logs.logf("[INFO] Provider %s %s，已跳过\n", provider.Name, reason)
 				skippedCount++
-				continue
 			}
+		}
 
-			// 核心过滤：只保留支持请求模型的 provider
-			if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
-				fmt.Printf("[INFO] Provider %s 不支持模型 %s，已跳过\n", provider.Name, requestedModel)
-				skippedCount++
-				continue
+		active, fallbacks := splitLocalFallbacks(active)
+		if overBudget != "" {
+			if len(fallbacks) == 0 {
+				fmt.Printf("[WARN] %s，已拒绝\n", overBudget)
+				c.JSON(http.StatusPaymentRequired, gin.H{"error": overBudget, "estimate": estimate})
+				return
 			}
+			fmt.Printf("[WARN] %s，改用本地 provider\n", overBudget)
+			active = nil
+		}
 
-			active = append(active, provider)
+		// 匹配降级链的请求按链声明的顺序逐跳尝试，不再参与负载均衡；小模型请求按同样方式尝试指定的 provider
+		var chain *routeChain
+		if fallbackChain := relayCfg.Routing.fallbackChain(requestedModel); fallbackChain != nil && overBudget == "" {
+			active, chain = prs.chainProviders(kind, requestedModel, fallbackChain, providers, relayCfg.Client)
+			fallbacks = nil
+		} else if smallChain := relayCfg.Routing.smallModelChain(requestedModel); smallChain != nil && overBudget == "" {
+			// 小模型请求不跟随主模型的路由，使用指定的 provider
+			if routed, route := prs.routeSmallModel(kind, requestedModel, smallChain, providers, relayCfg.Client); routed != nil {
+				active, chain, fallbacks = routed, route, nil
+			}
 		}
 
-		if len(active) == 0 {
+		if len(active) == 0 && len(fallbacks) == 0 {
+			if policyDenied != nil {
+				abortModelDenied(c, kind, requestedModel, policyDenied)
+				return
+			}
 			if requestedModel != "" {
 				c.JSON(http.StatusNotFound, gin.H{
 					"error": fmt.Sprintf("没有可用的 provider 支持模型 '%s'（已跳过 %d 个不兼容的 provider）", requestedModel, skippedCount),
@@ -203,95 +420,612 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			return
 		}
 
-		fmt.Printf("[INFO] 找到 %d 个可用的 provider（已过滤 %d 个）：", len(active), skippedCount)
+		if chain == nil {
+			// 兜底的本地 provider 排在所有云端 provider 之后，云端均失败时才会使用
+			active = append(prs.pinSession(kind, stickySession, prs.balanceProviders(target, active, relayCfg.Routing)), fallbacks...)
+		}
+		activeNames := make([]string, 0, len(active))
 		for _, p := range active {
-			fmt.Printf("%s ", p.Name)
+			activeNames = append(activeNames, p.Name)
+		}
+		logs.logf("[INFO] 找到 %d 个可用的 provider（已过滤 %d 个）：%s\n", len(active), skippedCount, strings.Join(activeNames, " "))
+
+		var relayCache ResponseCacheConfig
+		if relayCfg.Cache.cacheable(isStream, c.Request.Header) {
+			relayCache = relayCfg.Cache
+		}
+		relayReq := &relayRequest{
+			id:             requestID,
+			kind:           kind,
+			endpoint:       endpoint,
+			query:          flattenQuery(c.Request.URL.Query()),
+			clientHeaders:  cloneHeaders(c.Request.Header),
+			isStream:       isStream,
+			streamUsage:    streamUsage,
+			requestedModel: requestedModel,
+			sessionID:      sessionID,
+			stickySession:  stickySession,
+			stickyTTL:      relayCfg.Routing.stickySessionTTL(),
+			chain:          chain,
+			background:     isBackgroundRequest(c.Request.Header),
+			cache:          relayCache,
+			promptCache:    relayCfg.PromptCache,
+			timeouts:       relayCfg.Timeouts,
+			proxy:          relayCfg.Proxy,
+			tracker:        newRetryTracker(relayCfg.Retry.Policy()),
+			transcripts:    relayCfg.Transcripts,
+			bodyBuffer:     relayCfg.BodyBuffer,
+			overload:       relayCfg.Overload,
+			refusal:        relayCfg.Refusal,
+			promptTags:     promptTags,
+			sampling:       logs,
+			inputImages:    inputImages,
+			listener:       listenerTag(listener),
+			systemPrompt:   relayCfg.Routing.SystemPrompt,
+			serviceTier:    serviceTier,
+		}
+
+		// 管理接口取消请求时中断上下文，正在进行的上游请求与排队等待随之结束
+		ctx, cancel := context.WithCancelCause(c.Request.Context())
+		defer cancel(nil)
+		c.Request = c.Request.WithContext(ctx)
+		relayReq.inflight = prs.inflight.register(relayReq, cancel)
+		defer prs.inflight.remove(relayReq.id)
+
+		c.Header("X-Code-Switch-Request-Id", relayReq.id)
+		lastErr := prs.relayProviders(c, relayReq, active, body)
+		var queuedSince time.Time
+		for lastErr != nil && !c.Writer.Written() &&
+			!errors.Is(lastErr, ErrRetryBudgetExhausted) && !errors.Is(lastErr, context.Canceled) {
+			if queuedSince.IsZero() {
+				queuedSince = time.Now()
+			}
+			resumed, queueErr := prs.waitInQueue(c, relayReq, active, body, queuedSince)
+			if queueErr != nil {
+				lastErr = queueErr
+				break
+			}
+			if !resumed {
+				break
+			}
+			relayReq.tracker.restartBudget()
+			lastErr = prs.relayProviders(c, relayReq, active, body)
+		}
+
+		if lastErr == nil {
+			return
+		}
+		prs.retryHooks.giveUp(relayReq.retryEvent(lastErr))
+		if c.Writer.Written() {
+			return
 		}
-		fmt.Println()
+		if errors.Is(context.Cause(ctx), ErrRequestCancelled) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "请求已被取消"})
+			return
+		}
+		if errors.Is(context.Cause(ctx), ErrRelayShuttingDown) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "relay 正在退出，请重试"})
+			return
+		}
+		var queueFullErr *queueFullError
+		if errors.As(lastErr, &queueFullErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(queueFullErr.retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": queueFullErr.Error()})
+			return
+		}
+		var budgetErr *RetryBudgetExhaustedError
+		if errors.As(lastErr, &budgetErr) {
+			fmt.Printf("[WARN]   ✗ %v\n", budgetErr)
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error":    budgetErr.Error(),
+				"attempts": budgetErr.Attempts,
+			})
+			return
+		}
+
+		message := fmt.Sprintf("所有 %d 个 provider 均失败（共尝试 %d 次）", len(active), len(relayReq.tracker.attempts))
+		if lastErr != nil {
+			message = fmt.Sprintf("%s: %s", message, lastErr.Error())
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": message})
+	}
+}
+
+// routeSkipInactive 表示 provider 未启用或缺少地址/Key，路由时静默跳过
+const routeSkipInactive = "未启用"
+
+// routeSkipReason 返回 provider 不参与 requestedModel 路由的原因，可以参与时返回 ""
+func routeSkipReason(provider Provider, requestedModel string) string {
+	// 基础过滤：enabled、URL、APIKey（本地 provider 可以不配置 Key）
+	if !provider.Enabled || provider.upstreamBaseURL() == "" || (!provider.HasAPIKey() && provider.Local == nil) {
+		return routeSkipInactive
+	}
+	// 配置验证：失败则自动跳过
+	if errs := provider.ValidateConfiguration(); len(errs) > 0 {
+		return fmt.Sprintf("配置验证失败: %v", errs)
+	}
+	// 核心过滤：只保留支持请求模型的 provider
+	if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
+		return fmt.Sprintf("不支持模型 %s", requestedModel)
+	}
+	return ""
+}
 
-		query := flattenQuery(c.Request.URL.Query())
-		clientHeaders := cloneHeaders(c.Request.Header)
+// relayProviders 按顺序尝试所有可用 provider，直到成功或无法继续降级
+func (prs *ProviderRelayService) relayProviders(c *gin.Context, req *relayRequest, active []Provider, body *requestBody) error {
+	if req.chain == nil {
+		active = prs.preferNonRefusing(req, active)
+	}
+	var lastErr error
+	previousProvider := ""
+	for i, provider := range active {
+		if previousProvider != "" {
+			prs.retryHooks.failover(req.retryEvent(lastErr), previousProvider, provider.Name)
+		}
+		previousProvider = provider.Name
 
-		var lastErr error
-		attemptCount := 0
-		for i, provider := range active {
-			attemptCount++
+		effectiveModel := provider.GetEffectiveModel(provider.routeModel(req.requestedModel))
+		upstreamModel, _ := longContextUpstreamModel(effectiveModel)
 
-			effectiveModel := provider.GetEffectiveModel(requestedModel)
+		currentBody := body
+		if upstreamModel != req.requestedModel && req.requestedModel != "" {
+			req.sampling.logf("[INFO]   Provider %s 映射模型: %s -> %s\n", provider.Name, req.requestedModel, effectiveModel)
+		}
+		dialect := provider.dialect(req.kind, req.endpoint)
+		middlewareReq := &MiddlewareRequest{
+			Platform:       req.kind,
+			Endpoint:       req.endpoint,
+			Provider:       provider,
+			RequestedModel: req.requestedModel,
+			Model:          upstreamModel,
+			RequestID:      req.id,
+			SessionID:      req.sessionID,
+			IsStream:       req.isStream,
+			Headers:        upstreamHeaders(req, dialect, effectiveModel),
+			body:           body,
+		}
+		if err := prs.middlewares.processRequest(middlewareReq); err != nil {
+			fmt.Printf("[ERROR]   Provider %s: %v\n", provider.Name, err)
+			lastErr = err
+			continue
+		}
+		cacheControl := req.promptCache.cacheControlAction(provider, req.kind, req.endpoint)
+		systemPrompts := systemPromptRules(req.systemPrompt, provider, req.requestedModel)
+		if middlewareReq.rewritten || dialect != nil || req.streamUsage || cacheControl != "" || len(systemPrompts) > 0 {
+			if dialect != nil {
+				req.sampling.logf("[INFO]   Provider %s 使用 %s 协议，转换请求与响应\n", provider.Name, provider.APIFormat)
+			}
 
-			currentBodyBytes := bodyBytes
-			if effectiveModel != requestedModel && requestedModel != "" {
-				fmt.Printf("[INFO]   Provider %s 映射模型: %s -> %s\n", provider.Name, requestedModel, effectiveModel)
+			modifiedBody, err := body.rewrite(func(data []byte) ([]byte, error) {
+				if middlewareReq.rewritten {
+					data = middlewareReq.data
+				}
+				// 在插入 cache_control 与协议转换之前修改，注入的内容也能被缓存并转换为 provider 的格式
+				if len(systemPrompts) > 0 {
+					var err error
+					values := headerTemplateValues{model: upstreamModel, provider: provider.Name, platform: req.kind, requestID: req.id, sessionID: req.sessionID}
+					if data, err = applySystemPrompts(req.kind, req.endpoint, data, systemPrompts, values, req.clientHeaders, req.listener); err != nil {
+						return nil, err
+					}
+				}
+				switch cacheControl {
+				case cacheControlInject:
+					var err error
+					if data, err = injectCacheControl(data, upstreamModel, req.promptCache.minTokens(upstreamModel)); err != nil {
+						return nil, err
+					}
+				case CacheControlStrip:
+					var err error
+					if data, err = stripCacheControl(data); err != nil {
+						return nil, err
+					}
+				}
+				if req.streamUsage {
+					return includeStreamUsage(data)
+				}
+				if dialect != nil {
+					return dialect.translateRequest(data, upstreamModel)
+				}
+				return data, nil
+			}, req.bodyBuffer.MemoryLimitBytes, req.bodyBuffer.SpillDir)
+			if err != nil {
+				fmt.Printf("[ERROR]   转换请求体失败: %v\n", err)
+				lastErr = err
+				continue
+			}
+			currentBody = modifiedBody
+		}
 
-				modifiedBody, err := ReplaceModelInRequestBody(bodyBytes, effectiveModel)
-				if err != nil {
-					fmt.Printf("[ERROR]   替换模型名失败: %v\n", err)
-					lastErr = err
-					continue
+		req.cacheKey = ""
+		if req.cache.Enabled {
+			if data, err := currentBody.Bytes(); err == nil {
+				req.cacheKey = responseCacheKey(req.kind, provider.Name, effectiveModel, req.endpoint, data)
+				if entry, ok := prs.responses.get(req.cacheKey); ok {
+					req.sampling.logf("[INFO]   ✓ 命中缓存: %s | Model: %s | 节省 $%.6f\n", provider.Name, effectiveModel, entry.cost)
+					if currentBody != body {
+						currentBody.Close()
+					}
+					entry.serve(c)
+					recordCacheHit(req, provider, effectiveModel, entry)
+					return nil
 				}
-				currentBodyBytes = modifiedBody
 			}
+		}
+
+		req.sampling.logf("[INFO]   [%d/%d] Provider: %s | Model: %s\n",
+			i+1, len(active), provider.Name, effectiveModel)
+		if req.chain != nil {
+			req.chain.annotate(c, i, provider)
+		}
 
-			fmt.Printf("[INFO]   [%d/%d] Provider: %s | Model: %s\n",
-				i+1, len(active), provider.Name, effectiveModel)
+		err := prs.tryProvider(c, req, provider, currentBody, middlewareReq.Headers, effectiveModel)
+		if currentBody != body {
+			currentBody.Close()
+		}
+		if err == nil {
+			// 兜底的本地 provider 只在云端失败时使用，不固定会话
+			if req.stickySession != "" && !provider.isLocalFallback() {
+				prs.sessions.bind(req.kind, req.stickySession, provider, req.stickyTTL)
+			}
+			return nil
+		}
+		lastErr = err
+		// 预算耗尽、客户端断开或响应已开始写出时，不再降级到下一个 provider
+		if errors.Is(err, ErrRetryBudgetExhausted) || errors.Is(err, context.Canceled) || c.Writer.Written() {
+			break
+		}
+		if req.chain != nil && !req.chain.hops[i].continues(err) {
+			req.sampling.logf("[INFO]   降级链第 %d 跳的错误类型 %s 不在 fallbackOn 中，不再降级\n", req.chain.hops[i].position, fallbackErrorType(err))
+			break
+		}
+	}
+	return lastErr
+}
+
+// waitInQueue 在所有 provider 均被限流时排队，等待最早的限流窗口结束
+// 返回 resumed=false 表示不满足排队条件（未开启、并非限流或等待过久），调用方按普通失败处理
+func (prs *ProviderRelayService) waitInQueue(c *gin.Context, req *relayRequest, active []Provider, body *requestBody, queuedSince time.Time) (bool, error) {
+	cfg := prs.loadRelayConfig().Queue
+	if !cfg.Enabled {
+		return false, nil
+	}
+	resumeAt, limited := prs.keyPool.rateLimitedUntil(req.kind, active)
+	if !limited {
+		return false, nil
+	}
+	maxWait := time.Duration(cfg.MaxWaitSeconds * float64(time.Second))
+	if maxWait <= 0 {
+		maxWait = defaultQueueMaxWait
+	}
+	if resumeAt.Sub(queuedSince) > maxWait {
+		fmt.Printf("[WARN]   所有 provider 均被限流，恢复时间超出最长排队时间 %.0fs，不再排队\n", maxWait.Seconds())
+		return false, nil
+	}
+
+	// 已写入临时文件的请求体无需再由队列保存
+	var queuedBody []byte
+	if !body.spilled() {
+		queuedBody = body.data
+	}
+	entry, err := prs.queue.enqueue(cfg, req.kind, req.requestedModel, queuedBody, resumeAt)
+	if err != nil {
+		fmt.Printf("[WARN]   所有 provider 均被限流且排队已满，拒绝请求\n")
+		return false, &queueFullError{retryAfter: time.Until(resumeAt)}
+	}
+	if entry.Persisted {
+		// 请求体已落盘，释放内存中的副本
+		body.data = nil
+		c.Request.Body = http.NoBody
+	}
+	req.sampling.logf("[INFO]   所有 provider 均被限流，请求已排队（%s），预计 %.1fs 后恢复\n", entry.ID, time.Until(resumeAt).Seconds())
+
+	waitErr := prs.queue.wait(c.Request.Context(), entry)
+	restored, readErr := prs.queue.dequeue(entry)
+	if waitErr != nil {
+		fmt.Printf("[WARN]   客户端在排队期间断开: %v\n", waitErr)
+		return false, waitErr
+	}
+	if readErr != nil {
+		return false, fmt.Errorf("读取排队请求体失败: %w", readErr)
+	}
+	if !body.spilled() {
+		body.data = restored
+	}
+	req.sampling.logf("[INFO]   排队请求 %s 恢复，已等待 %.1fs\n", entry.ID, time.Since(entry.EnqueuedAt).Seconds())
+	return true, nil
+}
+
+// queueFullError 在排队已满时返回，retryAfter 为最早的限流恢复时间
+type queueFullError struct {
+	retryAfter time.Duration
+}
+
+func (e *queueFullError) Error() string {
+	return fmt.Sprintf("所有 provider 均被限流且排队已满，请在 %.0fs 后重试", math.Ceil(e.retryAfter.Seconds()))
+}
+
+func (e *queueFullError) Unwrap() error {
+	return ErrQueueFull
+}
+
+// QueuedRequests 返回当前因限流而排队的请求
+func (prs *ProviderRelayService) QueuedRequests() []QueuedRequest {
+	return prs.queue.snapshot()
+}
+
+// upstreamHeaders 返回发往 provider 的基础请求头，provider 配置的 header 由中间件添加
+func upstreamHeaders(req *relayRequest, dialect upstreamDialect, model string) map[string]string {
+	headers := cloneMap(req.clientHeaders)
+	if _, longContext := longContextUpstreamModel(model); longContext && dialect == nil && req.kind == "claude" {
+		addLongContextBeta(headers)
+	}
+	if dialect != nil {
+		// 由 Go 客户端处理压缩，转换时需要解码后的响应
+		delete(headers, "Accept-Encoding")
+	}
+	if _, ok := headers["Accept"]; !ok {
+		headers["Accept"] = "application/json"
+	}
+	// 添加固定的自定义 header
+	headers["X-Working-Dir"] = "/tmp"
+	return headers
+}
+
+// relayRequest 汇总一次代理请求在各次尝试之间共享的上下文
+type relayRequest struct {
+	id             string
+	kind           string
+	endpoint       string
+	query          map[string]string
+	clientHeaders  map[string]string
+	isStream       bool
+	streamUsage    bool
+	requestedModel string
+	sessionID      string
+	stickySession  string
+	stickyTTL      time.Duration
+	chain          *routeChain
+	background     bool
+	cache          ResponseCacheConfig
+	cacheKey       string
+	promptCache    PromptCacheConfig
+	timeouts       ProviderTimeouts
+	proxy          ProxyConfig
+	transcripts    TranscriptConfig
+	bodyBuffer     BodyBufferConfig
+	overload       OverloadConfig
+	refusal        RefusalConfig
+	promptTags     []string
+	sampling       *sampleDecision
+	inputImages    int
+	serviceTier    string
+	listener       string
+	systemPrompt   []SystemPromptRule
+	tracker        *retryTracker
+	inflight       *inflightEntry
+}
+
+// retryEvent 生成重试钩子事件，并带上请求的采样结果
+func (req *relayRequest) retryEvent(err error) RetryEvent {
+	event := req.tracker.event(req.kind, req.requestedModel, err)
+	event.Sampled = req.sampling.sampled(err)
+	return event
+}
+
+// tryProvider 在单个 provider 上执行请求：
+// 429/401 冷却当前 Key 并轮换到下一个 Key；模型过载时冷却该模型并降级；瞬时故障按退避策略重试；其余错误交由调用方降级
+func (prs *ProviderRelayService) tryProvider(c *gin.Context, req *relayRequest, provider Provider, body *requestBody, headers map[string]string, model string) error {
+	kind := req.kind
+	if until, cooling := prs.overloads.cooldownUntil(kind, provider.Name, model); cooling {
+		fmt.Printf("[WARN]   ✗ 跳过: %s | 模型 %s 过载冷却中，剩余 %.0fs\n", provider.Name, model, time.Until(until).Seconds())
+		return fmt.Errorf("provider %s 的模型 %s 过载冷却中", provider.Name, model)
+	}
+	keys := prs.keyPool.candidates(kind, provider)
+	if len(keys) == 0 {
+		fmt.Printf("[WARN]   ✗ 跳过: %s | 所有 API Key 均在冷却中\n", provider.Name)
+		return fmt.Errorf("provider %s 的所有 API Key 均在冷却中", provider.Name)
+	}
+
+	rules := provider.RetryRules()
+	health := prs.health.health(kind, provider.Name)
+	policy := adaptRetryPolicy(req.tracker.policy, health)
+	if policy.MaxRetryAttempts != req.tracker.policy.MaxRetryAttempts {
+		req.sampling.logf("[INFO]   Provider %s 健康状态 %s（成功率 %.0f%%），重试次数调整为 %d\n",
+			provider.Name, health.State, health.SuccessRate*100, policy.MaxRetryAttempts)
+	}
+	var lastErr error
+	for i, apiKey := range keys {
+		for retry := 0; ; retry++ {
+			if err := req.tracker.checkBudget(); err != nil {
+				return err
+			}
+			waited, err := prs.pacer.wait(c.Request.Context(), kind, provider)
+			if err != nil {
+				fmt.Printf("[WARN]   客户端在节流等待期间断开: %v\n", err)
+				return err
+			}
+			if waited > 0 {
+				req.sampling.logf("[INFO]   Provider %s 节流等待 %.2fs\n", provider.Name, waited.Seconds())
+			}
+			release, queued, err := prs.concurrency.acquire(c.Request.Context(), kind, provider, req.background)
+			if err != nil {
+				fmt.Printf("[WARN]   ✗ 跳过: %s | %v\n", provider.Name, err)
+				return err
+			}
+			if queued > 0 {
+				req.sampling.logf("[INFO]   Provider %s 并发已满，排队等待 %.2fs\n", provider.Name, queued.Seconds())
+			}
+			prs.keyPool.markUsed(kind, provider.Name, apiKey)
 
 			startTime := time.Now()
-			ok, err := prs.forwardRequest(c, kind, provider, endpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel)
+			ok, err := prs.forwardRequest(c, req, provider, apiKey, body, headers, model)
 			duration := time.Since(startTime)
-
+			release()
+
+			attempt := RetryAttempt{
+				Provider:  provider.Name,
+				Model:     model,
+				KeyHint:   maskAPIKey(apiKey),
+				StartedAt: startTime,
+				Duration:  duration,
+			}
 			if ok {
-				fmt.Printf("[INFO]   ✓ 成功: %s | 耗时: %.2fs\n", provider.Name, duration.Seconds())
-				return
+				attempt.StatusCode = http.StatusOK
+				req.tracker.record(attempt)
+				prs.health.record(kind, provider.Name, true)
+				prs.retryHooks.attempt(req.retryEvent(nil))
+				req.sampling.logf("[INFO]   ✓ 成功: %s | Key: %s | 耗时: %.2fs\n", provider.Name, maskAPIKey(apiKey), duration.Seconds())
+				return nil
 			}
 
 			errorMsg := "未知错误"
 			if err != nil {
 				errorMsg = err.Error()
 			}
-			fmt.Printf("[WARN]   ✗ 失败: %s | 错误: %s | 耗时: %.2fs\n",
-				provider.Name, errorMsg, duration.Seconds())
+			attempt.Error = errorMsg
+			var upstreamErr *UpstreamError
+			if errors.As(err, &upstreamErr) {
+				attempt.StatusCode = upstreamErr.StatusCode
+			}
+			req.tracker.record(attempt)
+			if countsTowardHealth(err) {
+				prs.health.record(kind, provider.Name, false)
+			}
+			prs.retryHooks.attempt(req.retryEvent(err))
+			fmt.Printf("[WARN]   ✗ 失败: %s | Key: %s | 错误: %s | 耗时: %.2fs\n",
+				provider.Name, maskAPIKey(apiKey), errorMsg, duration.Seconds())
 			lastErr = err
+
+			if isOverloadError(err) {
+				// 过载只影响当前模型，换 Key 或重试同一模型无济于事，冷却该模型后直接降级
+				cooldown := overloadCooldownFor(err, req.overload)
+				prs.overloads.record(kind, provider.Name, model, cooldown)
+				req.sampling.logf("[INFO]   Provider %s 的模型 %s 过载，冷却 %.0fs\n", provider.Name, model, cooldown.Seconds())
+				return err
+			}
+
+			if c.Writer.Written() {
+				return err
+			}
+
+			if cooldown, rotate := keyCooldownFor(upstreamErr); rotate {
+				if upstreamErr.StatusCode == http.StatusTooManyRequests {
+					prs.keyPool.markRateLimited(kind, provider.Name, apiKey, cooldown)
+				} else {
+					prs.keyPool.markCooldown(kind, provider.Name, apiKey, cooldown)
+				}
+				req.sampling.logf("[INFO]   Key %s 进入冷却 %.0fs\n", maskAPIKey(apiKey), cooldown.Seconds())
+				if i+1 < len(keys) {
+					prs.retryHooks.retry(req.retryEvent(err), 0)
+				}
+				break
+			}
+
+			if retry >= policy.MaxRetryAttempts || !ShouldRetry(err, rules) {
+				return err
+			}
+			delay := policy.Backoff(retry+1, err)
+			if !req.tracker.allows(delay) {
+				req.sampling.logf("[INFO]   等待 %.2fs 将超出重试预算，放弃在 %s 上重试\n", delay.Seconds(), provider.Name)
+				return err
+			}
+			req.sampling.logf("[INFO]   %.2fs 后重试 %s（第 %d/%d 次重试）\n", delay.Seconds(), provider.Name, retry+1, policy.MaxRetryAttempts)
+			prs.retryHooks.retry(req.retryEvent(err), delay)
+			if waitErr := req.tracker.wait(c.Request.Context(), delay); waitErr != nil {
+				return waitErr
+			}
 		}
+	}
+	return lastErr
+}
 
-		message := fmt.Sprintf("所有 %d 个 provider 均失败（共尝试 %d 次）", len(active), attemptCount)
-		if lastErr != nil {
-			message = fmt.Sprintf("%s: %s", message, lastErr.Error())
+// loadRelayConfig 返回当前生效的 relay 配置，配置文件变化时自动重新加载
+func (prs *ProviderRelayService) loadRelayConfig() RelayConfig {
+	if prs.reloader == nil {
+		cfg, err := prs.relayConfig.GetRelayConfig()
+		if err != nil {
+			fmt.Printf("[WARN] 读取 relay 配置失败，使用默认配置: %v\n", err)
 		}
-		c.JSON(http.StatusBadGateway, gin.H{"error": message})
+		return cfg
 	}
+	return prs.reloader.relay(prs.relayConfig)
 }
 
 func (prs *ProviderRelayService) forwardRequest(
 	c *gin.Context,
-	kind string,
+	relayReq *relayRequest,
 	provider Provider,
-	endpoint string,
-	query map[string]string,
-	clientHeaders map[string]string,
-	bodyBytes []byte,
-	isStream bool,
+	apiKey string,
+	body *requestBody,
+	headers map[string]string,
 	model string,
 ) (bool, error) {
-	targetURL := joinURL(provider.APIURL, endpoint)
-	headers := cloneMap(clientHeaders)
-	headers["Authorization"] = fmt.Sprintf("Bearer %s", provider.APIKey)
-	if _, ok := headers["Accept"]; !ok {
-		headers["Accept"] = "application/json"
+	kind := relayReq.kind
+	isStream := relayReq.isStream
+	dialect := provider.dialect(kind, relayReq.endpoint)
+	targetURL := joinURL(provider.upstreamBaseURL(), relayReq.endpoint)
+	query := provider.upstreamQuery(relayReq.query)
+	// model 保留 [1m] 后缀用于记录与计价，发送给 provider 的模型名不带后缀
+	upstreamModel, _ := longContextUpstreamModel(model)
+	if dialect != nil {
+		targetURL = joinURL(provider.upstreamBaseURL(), dialect.endpoint(upstreamModel, isStream))
+		// 客户端的查询参数（如 ?beta=true）属于原协议，Gemini 等会拒绝未知参数
+		query = provider.upstreamQuery(nil)
+	}
+	auth, err := provider.AuthStrategy()
+	if err != nil {
+		return false, err
 	}
-
-	// 添加固定的自定义 header
-	headers["X-Working-Dir"] = "/tmp"
 
 	requestLog := &ReqeustLog{
 		Platform: kind,
 		Provider: provider.Name,
 		Model:    model,
+		KeyHint:  maskAPIKey(apiKey),
 		IsStream: isStream,
+		// 尝试记录在本次请求结束后才写入 tracker
+		RequestID:   relayReq.id,
+		Attempt:     len(relayReq.tracker.attempts) + 1,
+		InputImages: relayReq.inputImages,
+		// 请求指定的服务等级，上游在响应中报告实际等级时以响应为准
+		ServiceTier: relayReq.serviceTier,
+		Listener:    relayReq.listener,
+	}
+	relayReq.inflight.startAttempt(requestLog)
+	interrupted := false
+	refusal := ""
+	var capture *transcriptCapture
+	var cached *cachedResponse
+	if relayReq.transcripts.Enabled {
+		capture = newTranscriptCapture(relayReq.transcripts)
 	}
 	start := time.Now()
 	defer func() {
 		requestLog.DurationSec = time.Since(start).Seconds()
-		if _, err := xdb.New("request_log").Insert(xdb.Record{
+		if interrupted {
+			estimateInterruptedUsage(kind, requestLog, body.Len())
+		}
+		if requestLog.HttpCode != 0 {
+			prs.scoreQuality(relayReq, QualityEvent{
+				Platform:   kind,
+				Provider:   provider.Name,
+				Model:      model,
+				Tags:       relayReq.promptTags,
+				StatusCode: requestLog.HttpCode,
+				Refused:    refusal != "",
+				Reason:     refusal,
+			})
+			prs.quotas.record(kind, provider, int64(requestLog.InputTokens+requestLog.OutputTokens+requestLog.CacheCreateTokens+requestLog.CacheReadTokens))
+		}
+		// 与 created_at 一致按写入时间计算时段价格
+		costOptions := append(provider.CostOptions(), modelpricing.WithRequestTime(time.Now()))
+		recorded := recordedCost(requestLog, costOptions...)
+		cost := recorded.TotalCost
+		if cached != nil && !interrupted && refusal == "" && requestLog.HttpCode == cached.status {
+			cached.cost = cachedResponseCost(kind, requestLog, cached.body, cost, costOptions...)
+			prs.responses.put(relayReq.cache, cached)
+		}
+		logID, err := xdb.New("request_log").Insert(xdb.Record{
 			"platform":            requestLog.Platform,
 			"model":               requestLog.Model,
 			"provider":            requestLog.Provider,
@@ -301,43 +1035,179 @@ func (prs *ProviderRelayService) forwardRequest(
 			"cache_create_tokens": requestLog.CacheCreateTokens,
 			"cache_read_tokens":   requestLog.CacheReadTokens,
 			"reasoning_tokens":    requestLog.ReasoningTokens,
+			"key_hint":            requestLog.KeyHint,
 			"is_stream":           boolToInt(requestLog.IsStream),
 			"duration_sec":        requestLog.DurationSec,
-		}); err != nil {
+			"original_cost":       cost,
+			"request_id":          requestLog.RequestID,
+			"attempt":             requestLog.Attempt,
+			"usage_estimated":     boolToInt(requestLog.UsageEstimated),
+			"input_audio_tokens":  requestLog.InputAudioTokens,
+			"output_audio_tokens": requestLog.OutputAudioTokens,
+			"input_images":        requestLog.InputImages,
+			"output_images":       requestLog.OutputImages,
+			"web_search_calls":    requestLog.WebSearchCalls,
+			"code_executions":     requestLog.CodeExecutions,
+			"queries":             requestLog.Queries,
+			"service_tier":        requestLog.ServiceTier,
+			"surcharge_cost":      recorded.SurchargeCost,
+			"pricing_version":     recorded.PricingVersion,
+			"reported_cost":       requestLog.ReportedCost,
+			"listener":            requestLog.Listener,
+		})
+		if err != nil {
 			fmt.Printf("写入 request_log 失败: %v\n", err)
+			return
+		}
+		if capture != nil && relayReq.sampling.keep(requestLog, cost) {
+			if err := saveTranscript(logID, requestLog, relayReq.sessionID, body.head(capture.limit+1), capture); err != nil {
+				fmt.Printf("写入 request_transcript 失败: %v\n", err)
+			}
 		}
 	}()
 
+	// 重试由 tryProvider 统一控制，这里只发起单次请求
+	if _, ok := headers["Content-Type"]; !ok {
+		headers["Content-Type"] = "application/json"
+	}
+	connectTimeout, firstByteTimeout, totalTimeout := resolveTimeouts(provider.Timeouts, relayReq.timeouts)
+	client, err := prs.clients.get(provider.Name, upstreamTransport{
+		connect: connectTimeout,
+		proxy:   resolveProxy(provider.Proxy, relayReq.proxy),
+		tls:     provider.TLS,
+	})
+	if err != nil {
+		return false, err
+	}
+	deadline := newUpstreamDeadline(c.Request.Context(), firstByteTimeout, totalTimeout)
+	defer deadline.stop()
+	// 请求体通过钩子在每次发出请求时重新打开，不依赖只能读取一次的 reader
+	// 认证放在请求体之后，签名类的方式需要最终的 URL 与请求体
 	req := xrequest.New().
+		WithContext(deadline.ctx).
+		SetClient(client).
 		SetHeaders(headers).
 		SetQueryParams(query).
-		SetRetry(1, 500*time.Millisecond)
-
-	reqBody := bytes.NewReader(bodyBytes)
-	req = req.SetBody(reqBody)
+		AddReqHook(body.attach).
+		AddReqHook(func(r *http.Request) error {
+			return auth.Apply(r, apiKey)
+		})
+	if isStream {
+		// 调试模式会在返回前读取整个响应体用于打印，流式请求不打印
+		req.SetDebug(false)
+	}
 
 	resp, err := req.Post(targetURL)
+	deadline.received()
 	if err != nil {
-		return false, err
+		return false, deadline.wrap(err)
 	}
 
 	if resp == nil {
 		return false, fmt.Errorf("empty response")
 	}
 
-	if resp.Error() != nil {
-		return false, resp.Error()
-	}
-
 	status := resp.StatusCode()
 	requestLog.HttpCode = status
 
 	if status >= http.StatusOK && status < http.StatusMultipleChoices {
-		_, copyErr := resp.ToHttpResponseWriter(c.Writer, ReqeustLogHook(c, kind, requestLog))
+		var reported *upstreamUsage
+		if dialect != nil {
+			reported = &upstreamUsage{}
+		}
+		if decoder, ok := dialect.(streamDecoder); ok && isStream {
+			resp.RawResponse.Body = decoder.decodeStream(resp.RawResponse.Body)
+			resp.RawResponse.Header.Set("Content-Type", "text/event-stream")
+		}
+		middlewareResp := &MiddlewareResponse{
+			Platform:       kind,
+			Endpoint:       relayReq.endpoint,
+			Provider:       provider,
+			RequestedModel: relayReq.requestedModel,
+			Model:          model,
+			RequestID:      relayReq.id,
+			IsStream:       isStream || strings.Contains(resp.RawResponse.Header.Get("Content-Type"), "text/event-stream"),
+			StatusCode:     status,
+			Header:         resp.RawResponse.Header,
+			Log:            requestLog,
+		}
+		hooks := []xrequest.ResponseHook{
+			prs.latency.firstByteHook(kind, provider.Name, relayReq.requestedModel, start),
+			prs.middlewares.responseHook(middlewareResp),
+			relayReq.inflight.progressHook(requestLog),
+		}
+		if dialect != nil {
+			// 先转换为客户端协议，用量解析与 transcript 使用转换后的内容；转换后长度变化，不能沿用上游的 Content-Length
+			hooks = append([]xrequest.ResponseHook{dialect.responseHook(upstreamModel, isStream, reported)}, hooks...)
+			resp.RawResponse.Header.Del("Content-Length")
+		}
+		if provider.OpenRouter != nil {
+			// 在协议转换之前读取，转换后的响应不保留 usage.cost
+			hooks = append([]xrequest.ResponseHook{openRouterCostHook(requestLog)}, hooks...)
+		}
+		if capture != nil {
+			hooks = append(hooks, capture.hook)
+		}
+		if contentType := resp.RawResponse.Header.Get("Content-Type"); relayReq.cacheKey != "" && !strings.Contains(contentType, "text/event-stream") {
+			cached = &cachedResponse{key: relayReq.cacheKey, status: status, contentType: contentType}
+			hooks = append(hooks, func(data []byte) (bool, []byte) {
+				cached.body = append(cached.body, data...)
+				return true, data
+			})
+		}
+		var copyErr error
+		if isStream {
+			_, copyErr = relayStream(c.Writer, resp.RawResponse, hooks...)
+		} else {
+			_, copyErr = resp.ToHttpResponseWriter(c.Writer, hooks...)
+		}
+		reported.apply(requestLog)
+		copyErr = deadline.wrap(copyErr)
+		interrupted = copyErr != nil
+		if requestLog.progress.refused {
+			refusal = "refusal"
+		}
+		if copyErr == nil && requestLog.progress.overloaded {
+			// 流已开始写出无法降级，但仍按过载记录，让后续请求避开该模型
+			interrupted = true
+			requestLog.HttpCode = statusOverloaded
+			return false, &UpstreamError{StatusCode: statusOverloaded, Body: "overloaded_error (stream)"}
+		}
 		return copyErr == nil, copyErr
 	}
 
-	return false, fmt.Errorf("upstream status %d", status)
+	errorBody := resp.String()
+	refusal = refusalFromBody(status, errorBody)
+	if capture != nil {
+		capture.write([]byte(errorBody))
+	}
+	return false, &UpstreamError{
+		StatusCode: status,
+		Body:       truncateString(errorBody, 512),
+		RetryAfter: parseRetryAfter(resp.Headers().Get("Retry-After")),
+	}
+}
+
+// UpstreamError 表示上游返回了非 2xx 状态码
+type UpstreamError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("upstream status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("upstream status %d: %s", e.StatusCode, e.Body)
+}
+
+func truncateString(s string, limit int) string {
+	s = strings.TrimSpace(s)
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "..."
 }
 
 func cloneHeaders(header http.Header) map[string]string {
@@ -382,13 +1252,17 @@ func boolToInt(b bool) int {
 }
 
 func ensureRequestLogColumn(db *sql.DB, column string, definition string) error {
-	query := fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('request_log') WHERE name = '%s'", column)
+	return ensureTableColumn(db, "request_log", column, definition)
+}
+
+func ensureTableColumn(db *sql.DB, table string, column string, definition string) error {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = '%s'", table, column)
 	var count int
 	if err := db.QueryRow(query).Scan(&count); err != nil {
 		return err
 	}
 	if count == 0 {
-		alter := fmt.Sprintf("ALTER TABLE request_log ADD COLUMN %s %s", column, definition)
+		alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
 		if _, err := db.Exec(alter); err != nil {
 			return err
 		}
@@ -416,8 +1290,26 @@ func ensureRequestLogTableWithDB(db *sql.DB) error {
 		cache_create_tokens INTEGER,
 		cache_read_tokens INTEGER,
 		reasoning_tokens INTEGER,
+		key_hint TEXT DEFAULT '',
 		is_stream INTEGER DEFAULT 0,
 		duration_sec REAL DEFAULT 0,
+		original_cost REAL DEFAULT 0,
+		repriced_cost REAL DEFAULT 0,
+		repriced_at TEXT DEFAULT '',
+		request_id TEXT DEFAULT '',
+		attempt INTEGER DEFAULT 0,
+		usage_estimated INTEGER DEFAULT 0,
+		input_audio_tokens INTEGER DEFAULT 0,
+		output_audio_tokens INTEGER DEFAULT 0,
+		input_images INTEGER DEFAULT 0,
+		output_images INTEGER DEFAULT 0,
+		service_tier TEXT DEFAULT '',
+		surcharge_cost REAL DEFAULT 0,
+		pricing_version TEXT DEFAULT '',
+		reported_cost REAL DEFAULT 0,
+		cache_hit INTEGER DEFAULT 0,
+		cache_saved_cost REAL DEFAULT 0,
+		listener TEXT DEFAULT '',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	)`
 
@@ -434,6 +1326,62 @@ func ensureRequestLogTableWithDB(db *sql.DB) error {
 	if err := ensureRequestLogColumn(db, "duration_sec", "REAL DEFAULT 0"); err != nil {
 		return err
 	}
+	if err := ensureRequestLogColumn(db, "key_hint", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "original_cost", "REAL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "repriced_cost", "REAL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "repriced_at", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "request_id", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "attempt", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "usage_estimated", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	for _, column := range []string{"input_audio_tokens", "output_audio_tokens", "input_images", "output_images", "web_search_calls", "code_executions", "queries"} {
+		if err := ensureRequestLogColumn(db, column, "INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	if err := ensureRequestLogColumn(db, "service_tier", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "listener", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "surcharge_cost", "REAL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "pricing_version", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "reported_cost", "REAL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "cache_hit", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "cache_saved_cost", "REAL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_request_log_request_id ON request_log(request_id)`); err != nil {
+		return err
+	}
+	if err := ensureRepricingRunTable(db); err != nil {
+		return err
+	}
+	if err := ensureTranscriptTables(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -446,7 +1394,10 @@ func ReqeustLogHook(c *gin.Context, kind string, usage *ReqeustLog) func(data []
 		if kind == "codex" {
 			parserFn = CodexParseTokenUsageFromResponse
 		}
-		parseEventPayload(payload, parserFn, usage)
+		parseEventPayload(payload, func(data string, usage *ReqeustLog) {
+			parserFn(data, usage)
+			usage.progress.track(kind, data)
+		}, usage)
 
 		return true, data
 	}
@@ -473,6 +1424,7 @@ type ReqeustLog struct {
 	CacheCreateTokens int     `json:"cache_create_tokens"`
 	CacheReadTokens   int     `json:"cache_read_tokens"`
 	ReasoningTokens   int     `json:"reasoning_tokens"`
+	KeyHint           string  `json:"key_hint"`
 	IsStream          bool    `json:"is_stream"`
 	DurationSec       float64 `json:"duration_sec"`
 	CreatedAt         string  `json:"created_at"`
@@ -484,17 +1436,46 @@ type ReqeustLog struct {
 	Ephemeral1hCost   float64 `json:"ephemeral_1h_cost"`
 	TotalCost         float64 `json:"total_cost"`
 	HasPricing        bool    `json:"has_pricing"`
+	OriginalCost      float64 `json:"original_cost"` // 写入日志时按当时价格计算的费用
+	RepricedCost      float64 `json:"repriced_cost"` // 价格修正后重新计算的费用
+	RepricedAt        string  `json:"repriced_at"`
+	RequestID         string  `json:"request_id"`          // 同一客户端请求的所有尝试共享
+	Attempt           int     `json:"attempt"`             // 该请求的第几次上游尝试，从 1 开始
+	UsageEstimated    bool    `json:"usage_estimated"`     // 流式响应中断，用量为估算值
+	SurchargeCost     float64 `json:"surcharge_cost"`      // provider 配置的按次附加费（已计入 total_cost）
+	InputAudioTokens  int     `json:"input_audio_tokens"`  // 包含在 input_tokens 中
+	OutputAudioTokens int     `json:"output_audio_tokens"` // 包含在 output_tokens 中
+	InputImages       int     `json:"input_images"`        // 请求中的图片张数
+	OutputImages      int     `json:"output_images"`       // 生成的图片张数
+	ImageCost         float64 `json:"image_cost"`
+	AudioCost         float64 `json:"audio_cost"`
+	WebSearchCalls    int     `json:"web_search_calls"`  // 服务端网页搜索次数
+	CodeExecutions    int     `json:"code_executions"`   // 服务端代码执行次数
+	ToolCost          float64 `json:"tool_cost"`         // 服务端工具的按次费用（已计入 total_cost）
+	Queries           int     `json:"queries"`           // 重排序的查询次数
+	RequestCost       float64 `json:"request_cost"`      // 重排序等按次计费的费用（已计入 total_cost）
+	ServiceTier       string  `json:"service_tier"`      // priority / flex，为空表示标准等级
+	ServiceTierCost   float64 `json:"service_tier_cost"` // 相对标准价格的差额（已计入 total_cost）
+	PricingVersion    string  `json:"pricing_version"`   // 写入日志时使用的价格数据版本，费用按该版本的快照计算
+	ReportedCost      float64 `json:"reported_cost"`     // 上游在响应中报告的实际费用（如 OpenRouter 的 usage.cost），未报告时为 0
+	CacheHit          bool    `json:"cache_hit"`         // 响应来自缓存，未请求上游
+	CacheSavedCost    float64 `json:"cache_saved_cost"`  // 命中缓存节省的费用（原请求的费用）
+	Listener          string  `json:"listener"`          // 接收请求的监听配置的归属标签，默认监听地址为空
+
+	progress streamProgress
 }
 
 // claude code usage parser
+// 用量为累计值，message_delta 中出现的字段覆盖 message_start
 func ClaudeCodeParseTokenUsageFromResponse(data string, usage *ReqeustLog) {
-	usage.InputTokens += int(gjson.Get(data, "message.usage.input_tokens").Int())
-	usage.OutputTokens += int(gjson.Get(data, "message.usage.output_tokens").Int())
-	usage.CacheCreateTokens += int(gjson.Get(data, "message.usage.cache_creation_input_tokens").Int())
-	usage.CacheReadTokens += int(gjson.Get(data, "message.usage.cache_read_input_tokens").Int())
-
-	usage.InputTokens += int(gjson.Get(data, "usage.input_tokens").Int())
-	usage.OutputTokens += int(gjson.Get(data, "usage.output_tokens").Int())
+	recordAnthropicStreamUsage(gjson.Get(data, "message.usage"), usage)
+	recordAnthropicStreamUsage(gjson.Get(data, "usage"), usage)
+	if tier := gjson.Get(data, "message.usage.service_tier").String(); tier != "" {
+		usage.ServiceTier = modelpricing.NormalizeServiceTier(tier)
+	}
+	if tier := gjson.Get(data, "usage.service_tier").String(); tier != "" {
+		usage.ServiceTier = modelpricing.NormalizeServiceTier(tier)
+	}
 }
 
 // codex usage parser
@@ -503,7 +1484,18 @@ func CodexParseTokenUsageFromResponse(data string, usage *ReqeustLog) {
 	usage.OutputTokens += int(gjson.Get(data, "response.usage.output_tokens").Int())
 	usage.CacheReadTokens += int(gjson.Get(data, "response.usage.input_tokens_details.cached_tokens").Int())
 	usage.ReasoningTokens += int(gjson.Get(data, "response.usage.output_tokens_details.reasoning_tokens").Int())
-	fmt.Println("data ---->", data, fmt.Sprintf("%v", usage))
+	usage.InputAudioTokens += int(gjson.Get(data, "response.usage.input_tokens_details.audio_tokens").Int())
+	usage.OutputAudioTokens += int(gjson.Get(data, "response.usage.output_tokens_details.audio_tokens").Int())
+	if tier := gjson.Get(data, "response.service_tier").String(); tier != "" {
+		usage.ServiceTier = modelpricing.NormalizeServiceTier(tier)
+	}
+	if gjson.Get(data, "type").String() == "response.completed" {
+		output := gjson.Get(data, "response.output")
+		usage.OutputImages += countOutputImages(output)
+		usage.WebSearchCalls += countOutputItems(output, "web_search_call")
+		usage.CodeExecutions += countOutputItems(output, "code_interpreter_call")
+	}
+	recordChatCompletionUsage(data, usage)
 }
 
 // ReplaceModelInRequestBody 替换请求体中的模型名
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	modelpricing "codeswitch/resources/model-pricing"
 	"github.com/tidwall/gjson"
 )
 
@@ -302,3 +303,32 @@ func BenchmarkReplaceModelInRequestBody(b *testing.B) {
 		_, _ = ReplaceModelInRequestBody(bodyBytes, "anthropic/claude-sonnet-4")
 	}
 }
+
+func TestParseServiceTierFromResponse(t *testing.T) {
+	claude := &ReqeustLog{}
+	ClaudeCodeParseTokenUsageFromResponse(`{"type":"message_start","message":{"usage":{"input_tokens":10,"service_tier":"priority"}}}`, claude)
+	if claude.ServiceTier != modelpricing.ServiceTierPriority {
+		t.Fatalf("claude 服务等级 = %q", claude.ServiceTier)
+	}
+	// 请求指定 priority，响应报告实际按 default 处理
+	codex := &ReqeustLog{ServiceTier: modelpricing.ServiceTierPriority}
+	CodexParseTokenUsageFromResponse(`{"type":"response.completed","response":{"service_tier":"default","usage":{"input_tokens":10}}}`, codex)
+	if codex.ServiceTier != "" {
+		t.Fatalf("codex 服务等级应以响应为准: %q", codex.ServiceTier)
+	}
+}
+
+func TestParseServerToolUsage(t *testing.T) {
+	var claude ReqeustLog
+	ClaudeCodeParseTokenUsageFromResponse(`{"type":"message_start","message":{"usage":{"input_tokens":10,"server_tool_use":{"web_search_requests":1}}}}`, &claude)
+	ClaudeCodeParseTokenUsageFromResponse(`{"type":"message_delta","usage":{"output_tokens":20,"server_tool_use":{"web_search_requests":3}}}`, &claude)
+	if claude.WebSearchCalls != 3 {
+		t.Fatalf("Claude 的网页搜索次数应取累计值 3: %d", claude.WebSearchCalls)
+	}
+	var codex ReqeustLog
+	CodexParseTokenUsageFromResponse(`{"type":"response.completed","response":{"usage":{"input_tokens":10,"output_tokens":5},
+		"output":[{"type":"web_search_call"},{"type":"web_search_call"},{"type":"code_interpreter_call"},{"type":"message"}]}}`, &codex)
+	if codex.WebSearchCalls != 2 || codex.CodeExecutions != 1 {
+		t.Fatalf("Codex 的服务端工具调用次数错误: %+v", codex)
+	}
+}
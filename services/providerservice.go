@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 )
@@ -20,6 +21,33 @@ type Provider struct {
 	Accent  string `json:"accent"`
 	Enabled bool   `json:"enabled"`
 
+	// 备用 API Key - 与 APIKey 共同组成 Key 池，按轮询方式使用
+	// 遇到 429/401 时自动切换到下一个 Key，失败的 Key 进入冷却
+	APIKeys []string `json:"apiKeys,omitempty"`
+
+	// 请求节流 - 每秒最多向该 provider 发出的请求数（0 表示不限制）
+	// 超出速率的请求在代理内排队等待，避免突发流量触发中转站封禁
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+
+	// 节流桶容量 - 允许瞬时突发的请求数（默认 1，即严格匀速）
+	RequestBurst int `json:"requestBurst,omitempty"`
+
+	// 并发上限 - 同时发往该 provider 的最大请求数（0 表示不限制），超出的请求排队等待空位
+	// 避免大量并行的子任务触发上游的并发限制；标记为后台的请求排在交互请求之后
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+
+	// 并发等待队列 - 最多排队等待的请求数（默认 50），队列已满时降级到下一个 provider
+	ConcurrencyQueue int `json:"concurrencyQueue,omitempty"`
+
+	// 并发等待时间 - 单个请求最长等待空位的秒数（默认 60），超时后降级到下一个 provider
+	ConcurrencyWaitSeconds float64 `json:"concurrencyWaitSeconds,omitempty"`
+
+	// 额外的可重试状态码 - 在默认的 502/503/504 之外（如某些中转站用 500 表示瞬时故障）
+	RetryableStatusCodes []int `json:"retryableStatusCodes,omitempty"`
+
+	// 可重试的响应体正则 - 匹配上游错误响应时视为瞬时故障（如自定义的 "upstream busy" 错误 JSON）
+	RetryableBodyPatterns []string `json:"retryableBodyPatterns,omitempty"`
+
 	// 模型白名单 - Provider 原生支持的模型名
 	// 使用 map 实现 O(1) 查找，向后兼容（omitempty）
 	SupportedModels map[string]bool `json:"supportedModels,omitempty"`
@@ -28,12 +56,78 @@ type Provider struct {
 	// 支持精确匹配和通配符（如 "claude-*" -> "anthropic/claude-*"）
 	ModelMapping map[string]string `json:"modelMapping,omitempty"`
 
+	// 模型改写规则 - 按顺序匹配，在 ModelMapping 的精确映射之后、通配符映射之前生效
+	// 支持多个 * 通配符与正则捕获组（如 "^claude-(\\w+)-4-5$" -> "$1-latest"）
+	ModelRewrites []ModelRewrite `json:"modelRewrites,omitempty"`
+
 	// 优先级分组 - 数字越小优先级越高（1-10，默认 1）
 	// 使用 omitempty 确保零值不序列化，向后兼容
 	Level int `json:"level,omitempty"`
 
+	// 上游协议 - 留空时与平台一致（claude 为 Anthropic Messages）
+	// openai-chat：将 Claude 请求转换为 OpenAI Chat Completions 发送，响应转换回 Anthropic 格式（apiUrl 需包含 /v1 等版本前缀）
+	// gemini：转换为 Gemini generateContent（apiUrl 如 https://generativelanguage.googleapis.com/v1beta），默认使用 x-goog-api-key 认证
+	// bedrock：通过 bedrock-runtime 的 invoke 接口调用（apiUrl 如 https://bedrock-runtime.us-east-1.amazonaws.com），默认使用 apiUrl 区域的 SigV4 签名
+	// vertex：通过 Vertex AI 调用 Claude（rawPredict）或 Gemini（generateContent），apiUrl 可留空并按 vertex 配置生成，默认使用 Google 凭据换取的 OAuth2 token
+	APIFormat string `json:"apiFormat,omitempty"`
+
+	// Azure OpenAI - 配置后每个请求附加 api-version，模型名为部署名，默认使用 api-key 请求头认证
+	Azure *AzureConfig `json:"azure,omitempty"`
+
+	// 本地推理服务（Ollama、LM Studio、llama.cpp server）- 配置后请求不计费、API Key 可留空，可设为云端不可用或超出预算时的兜底
+	Local *LocalConfig `json:"local,omitempty"`
+
+	// OpenRouter - 配置后发送应用归属请求头、将模型名转换为 OpenRouter 的 slug（如 anthropic/claude-sonnet-4.5），并记录 OpenRouter 报告的费用与剩余额度
+	OpenRouter *OpenRouterConfig `json:"openRouter,omitempty"`
+
+	// Vertex AI 项目与区域 - apiFormat 为 vertex 且未配置 apiUrl 时用于生成接口地址
+	Vertex *VertexConfig `json:"vertex,omitempty"`
+
+	// Gemini 安全设置 - apiFormat 为 gemini 或 vertex（Gemini 模型）时随请求发送，请求体自带 safetySettings 时以请求为准
+	GeminiSafetySettings []GeminiSafetySetting `json:"geminiSafetySettings,omitempty"`
+
+	// 认证方式 - 未配置时使用 Authorization: Bearer（gemini 协议为 x-goog-api-key，bedrock 协议为 SigV4，vertex 协议为 Google OAuth2，Azure OpenAI 为 api-key，本地服务仅在配置了 Key 时使用 Bearer）
+	// 支持 bearer、x-api-key、query、header（自定义请求头）、aws-sigv4 和 google-oauth（API Key 为 Google 凭据 JSON 或其文件路径）
+	Auth *AuthConfig `json:"auth,omitempty"`
+
+	// 价格倍率 - 相对官方价格的倍数（如转售商的 0.8 或 1.5，默认 1）
+	PriceMultiplier float64 `json:"priceMultiplier,omitempty"`
+
+	// 按次附加费 - 每次产生用量的请求在 token 费用之外收取的固定费用（美元），不受价格倍率影响
+	RequestFee float64 `json:"requestFee,omitempty"`
+
+	// 权重 - 同一优先级（Level）内按权重比例分配请求（如 80/20 分流），未配置时按列表顺序使用
+	Weight int `json:"weight,omitempty"`
+
+	// 标签 - 用于批量操作时按团队、用途等分组选择 provider
+	Tags []string `json:"tags,omitempty"`
+
+	// 用量配额 - 按时间窗口限制请求数与 token 数，即将用完时暂停路由到该 provider，窗口重置后自动恢复
+	Quotas []ProviderQuota `json:"quotas,omitempty"`
+
+	// 提示缓存断点 - 空为按 relay 配置自动插入 cache_control；strip 删除请求中的 cache_control（用于拒绝该字段的兼容 provider）；keep 原样发送
+	CacheControl string `json:"cacheControl,omitempty"`
+
+	// 超时 - 覆盖 relay 配置的连接、首字节与总超时，适用于本地模型、跨区域等延迟差异较大的 provider
+	Timeouts *ProviderTimeouts `json:"timeouts,omitempty"`
+
+	// 自定义 header - 发送前删除、覆盖或追加的 header（如 anthropic-beta、租户 ID），值支持 {model} 等占位符
+	Headers *HeaderRules `json:"headers,omitempty"`
+
+	// system prompt - 转发前追加、前置或替换 system prompt（如合规声明、上游要求的前缀），在 relay 配置 routing.systemPrompt 之后执行
+	SystemPrompt []SystemPromptRule `json:"systemPrompt,omitempty"`
+
+	// 出站代理 - 覆盖 relay 的代理配置：代理地址（http://、https://、socks5://）或 direct 直连
+	Proxy string `json:"proxy,omitempty"`
+
+	// TLS - 自定义根证书、mTLS 客户端证书，或跳过证书校验（不安全）
+	TLS *ProviderTLS `json:"tls,omitempty"`
+
 	// 内部字段：配置验证错误（不持久化）
 	configErrors []string `json:"-"`
+
+	// 内部字段：按模型档位路由时本次请求使用的模型（不持久化）
+	routedModel string `json:"-"`
 }
 
 type providerEnvelope struct {
@@ -148,13 +242,39 @@ func (ps *ProviderService) LoadProviders(kind string) ([]Provider, error) {
 	return envelope.Providers, nil
 }
 
+// AllAPIKeys 返回去重后的 Key 池（APIKey 优先，其次为 APIKeys）
+func (p *Provider) AllAPIKeys() []string {
+	keys := make([]string, 0, 1+len(p.APIKeys))
+	seen := make(map[string]bool, 1+len(p.APIKeys))
+	for _, key := range append([]string{p.APIKey}, p.APIKeys...) {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// HasAPIKey 判断 provider 是否至少配置了一个可用的 Key
+func (p *Provider) HasAPIKey() bool {
+	return len(p.AllAPIKeys()) > 0
+}
+
 // IsModelSupported 检查 provider 是否支持指定的模型
 // 支持条件：1) 模型在 SupportedModels 中（精确或通配符匹配）
 //          2) 模型在 ModelMapping 的 key 中（精确或通配符匹配）
+//          3) 模型匹配 ModelRewrites 中的规则
 func (p *Provider) IsModelSupported(modelName string) bool {
 	// 向后兼容：如果未配置白名单和映射，假设支持所有模型
 	if (p.SupportedModels == nil || len(p.SupportedModels) == 0) &&
-		(p.ModelMapping == nil || len(p.ModelMapping) == 0) {
+		(p.ModelMapping == nil || len(p.ModelMapping) == 0) && len(p.ModelRewrites) == 0 {
+		return true
+	}
+
+	// 场景 0：匹配模型改写规则
+	if _, ok := p.rewriteModel(modelName); ok {
 		return true
 	}
 
@@ -190,10 +310,26 @@ func (p *Provider) IsModelSupported(modelName string) bool {
 	return false
 }
 
+// routeModel 返回 provider 为本次请求处理的模型：按模型档位路由时为档位中选中的模型，否则为请求的模型
+func (p *Provider) routeModel(requestedModel string) string {
+	if p.routedModel != "" {
+		return p.routedModel
+	}
+	return requestedModel
+}
+
 // GetEffectiveModel 获取实际应该使用的模型名
-// 如果存在映射（精确或通配符），返回映射后的模型名；否则返回原模型名
+// 如果存在映射（精确映射、改写规则或通配符映射），返回映射后的模型名；否则返回原模型名
 func (p *Provider) GetEffectiveModel(requestedModel string) string {
-	if p.ModelMapping == nil || len(p.ModelMapping) == 0 {
+	if p.OpenRouter != nil {
+		return openRouterSlug(p.mappedModel(requestedModel))
+	}
+	return p.mappedModel(requestedModel)
+}
+
+// mappedModel 按 ModelMapping 与 ModelRewrites 返回映射后的模型名
+func (p *Provider) mappedModel(requestedModel string) string {
+	if (p.ModelMapping == nil || len(p.ModelMapping) == 0) && len(p.ModelRewrites) == 0 {
 		return requestedModel
 	}
 
@@ -202,6 +338,11 @@ func (p *Provider) GetEffectiveModel(requestedModel string) string {
 		return mappedModel
 	}
 
+	// 按顺序应用改写规则
+	if rewritten, ok := p.rewriteModel(requestedModel); ok {
+		return rewritten
+	}
+
 	// 查找通配符映射
 	for pattern, replacement := range p.ModelMapping {
 		if matchWildcard(pattern, requestedModel) {
@@ -270,6 +411,124 @@ func (p *Provider) ValidateConfiguration() []string {
 		}
 	}
 
+	// 规则 4：节流参数不能为负数
+	if p.RequestsPerSecond < 0 {
+		errors = append(errors, fmt.Sprintf("requestsPerSecond 不能为负数: %v", p.RequestsPerSecond))
+	}
+	if p.RequestBurst < 0 {
+		errors = append(errors, fmt.Sprintf("requestBurst 不能为负数: %d", p.RequestBurst))
+	}
+	if p.MaxConcurrency < 0 || p.ConcurrencyQueue < 0 || p.ConcurrencyWaitSeconds < 0 {
+		errors = append(errors, "maxConcurrency、concurrencyQueue 与 concurrencyWaitSeconds 不能为负数")
+	}
+
+	// 规则 5：可重试规则必须合法
+	for _, code := range p.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			errors = append(errors, fmt.Sprintf("retryableStatusCodes 包含无效的状态码: %d", code))
+		}
+	}
+	for _, pattern := range p.RetryableBodyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errors = append(errors, fmt.Sprintf("retryableBodyPatterns 包含无效的正则 '%s': %v", pattern, err))
+		}
+	}
+
+	// 规则 6：计价调整不能为负数
+	if p.PriceMultiplier < 0 {
+		errors = append(errors, fmt.Sprintf("priceMultiplier 不能为负数: %v", p.PriceMultiplier))
+	}
+	if p.RequestFee < 0 {
+		errors = append(errors, fmt.Sprintf("requestFee 不能为负数: %v", p.RequestFee))
+	}
+
+	// 规则 7：权重不能为负数
+	if p.Weight < 0 {
+		errors = append(errors, fmt.Sprintf("weight 不能为负数: %d", p.Weight))
+	}
+
+	// 规则 8：模型改写规则必须合法
+	for i, rule := range p.ModelRewrites {
+		if err := rule.validate(); err != nil {
+			errors = append(errors, fmt.Sprintf("modelRewrites[%d] 无效: %v", i, err))
+		}
+	}
+
+	// 规则 9：上游协议必须支持
+	if err := validateAPIFormat(p.APIFormat); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	// 规则 10：认证方式必须可用
+	if _, err := p.AuthStrategy(); err != nil {
+		errors = append(errors, fmt.Sprintf("auth 配置无效: %v", err))
+	}
+
+	// 规则 11：vertex 协议未配置 apiUrl 时需要能确定项目
+	if p.APIFormat == APIFormatVertex && p.APIURL == "" && p.vertexBaseURL() == "" {
+		errors = append(errors, "vertex 协议需要配置 vertex.projectId 或 apiUrl")
+	}
+
+	// 规则 12：Gemini 安全设置必须填写类别与阈值
+	for i, setting := range p.GeminiSafetySettings {
+		if strings.TrimSpace(setting.Category) == "" || strings.TrimSpace(setting.Threshold) == "" {
+			errors = append(errors, fmt.Sprintf("geminiSafetySettings[%d] 需要 category 与 threshold", i))
+		}
+	}
+
+	// 规则 13：Azure 部署映射必须完整
+	if p.Azure != nil {
+		errors = append(errors, p.Azure.validate()...)
+	}
+
+	// 规则 14：本地服务类型必须支持
+	if p.Local != nil {
+		errors = append(errors, p.Local.validate()...)
+	}
+
+	// 规则 15：OpenRouter 额度提醒阈值不能为负数
+	if p.OpenRouter != nil {
+		errors = append(errors, p.OpenRouter.validate()...)
+	}
+
+	// 规则 16：用量配额必须有有效的窗口与上限
+	for i, quota := range p.Quotas {
+		errors = append(errors, quota.validate(i)...)
+	}
+
+	// 规则 17：提示缓存断点处理方式必须支持
+	if err := validateCacheControl(p.CacheControl); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	// 规则 18：超时时间必须有效
+	if p.Timeouts != nil {
+		errors = append(errors, p.Timeouts.validate()...)
+	}
+
+	// 规则 19：自定义 header 的名称与值必须合法
+	if p.Headers != nil {
+		errors = append(errors, p.Headers.validate()...)
+	}
+
+	// 规则 20：出站代理地址必须有效
+	if proxy := strings.TrimSpace(p.Proxy); proxy != "" && proxy != ProxyDirect {
+		if err := validateProxyURL(proxy); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	// 规则 21：TLS 证书文件必须可用
+	if p.TLS != nil {
+		errors = append(errors, p.TLS.validate()...)
+	}
+
+	// 规则 22：Key 引用的名称必须合法
+	errors = append(errors, p.validateSecretRefs()...)
+
+	// 规则 23：system prompt 规则必须有效
+	errors = append(errors, validateSystemPrompts(p.SystemPrompt)...)
+
 	p.configErrors = errors
 	return errors
 }
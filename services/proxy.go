@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// provider 的 proxy 设置为该值时直连，不使用 relay 配置与环境变量中的代理
+const ProxyDirect = "direct"
+
+// ProxyConfig 是发往上游的出站代理，未配置时遵循 HTTPS_PROXY、HTTP_PROXY 与 NO_PROXY 环境变量
+type ProxyConfig struct {
+	// 代理地址，支持 http://、https://、socks5://（可带用户名密码）
+	URL string `json:"url,omitempty"`
+	// 不走代理的主机，与 NO_PROXY 环境变量合并：域名（含子域名）、IP、CIDR、host:port，* 表示全部直连
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+func validateProxyURL(raw string) error {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("代理地址无效: %v", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("不支持的代理协议: %s（可选 http、https、socks5）", raw)
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("代理地址缺少主机: %s", raw)
+	}
+	return nil
+}
+
+func (c ProxyConfig) validate() []string {
+	if raw := strings.TrimSpace(c.URL); raw != "" {
+		if err := validateProxyURL(raw); err != nil {
+			return []string{err.Error()}
+		}
+	}
+	return nil
+}
+
+// resolveProxy 返回 provider 生效的代理配置：provider 单独配置的代理只用于该 provider，不受 relay 的 noProxy 影响
+func resolveProxy(provider string, defaults ProxyConfig) ProxyConfig {
+	switch provider = strings.TrimSpace(provider); provider {
+	case "":
+		return defaults
+	case ProxyDirect:
+		return ProxyConfig{URL: ProxyDirect}
+	default:
+		return ProxyConfig{URL: provider}
+	}
+}
+
+// proxyFunc 返回 http.Transport 使用的代理函数；配置无效时忽略并回退到环境变量
+func (c ProxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxyURL := strings.TrimSpace(c.URL)
+	if proxyURL == ProxyDirect {
+		return nil
+	}
+	if proxyURL == "" && len(c.NoProxy) == 0 {
+		return http.ProxyFromEnvironment
+	}
+	cfg := httpproxy.FromEnvironment()
+	if proxyURL != "" {
+		if err := validateProxyURL(proxyURL); err != nil {
+			fmt.Printf("[WARN] %v，使用环境变量中的代理\n", err)
+		} else {
+			cfg.HTTPProxy, cfg.HTTPSProxy = proxyURL, proxyURL
+		}
+	}
+	if len(c.NoProxy) > 0 {
+		cfg.NoProxy = strings.Join(append([]string{cfg.NoProxy}, c.NoProxy...), ",")
+	}
+	proxy := cfg.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxy(r.URL)
+	}
+}
+
+// key 返回区分不同代理配置的字符串，用于复用 HTTP client
+func (c ProxyConfig) key() string {
+	return strings.TrimSpace(c.URL) + "|" + strings.Join(c.NoProxy, ",")
+}
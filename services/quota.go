@@ -0,0 +1,274 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+const (
+	// 剩余配额低于上限的该比例时停止路由，为进行中的请求留出余量
+	defaultQuotaReserveRatio = 0.05
+	// 样本最少保留的时间，短于该值的窗口也按该时间清理
+	quotaMinRetention = time.Minute
+)
+
+// ProviderQuota 是 provider 在一个时间窗口内的用量上限
+type ProviderQuota struct {
+	// 窗口长度，如 1m、1h、24h，Claude 订阅的用量窗口为 5h
+	Window string `json:"window"`
+	// 窗口从第一次请求开始计时、到期后整体重置（Claude 订阅的 5 小时窗口），默认按滚动窗口统计
+	Anchored bool `json:"anchored,omitempty"`
+	// 窗口内最多请求数
+	MaxRequests int `json:"maxRequests,omitempty"`
+	// 窗口内最多 token 数（输入、输出与缓存读写之和）
+	MaxTokens int64 `json:"maxTokens,omitempty"`
+	// 剩余配额低于上限的该比例时视为即将用完，默认 0.05
+	ReserveRatio float64 `json:"reserveRatio,omitempty"`
+}
+
+func (q ProviderQuota) window() time.Duration {
+	window, err := time.ParseDuration(strings.TrimSpace(q.Window))
+	if err != nil {
+		return 0
+	}
+	return window
+}
+
+func (q ProviderQuota) reserve() float64 {
+	if q.ReserveRatio > 0 && q.ReserveRatio < 1 {
+		return q.ReserveRatio
+	}
+	return defaultQuotaReserveRatio
+}
+
+func (q ProviderQuota) validate(index int) []string {
+	var errors []string
+	if q.window() <= 0 {
+		errors = append(errors, fmt.Sprintf("quotas[%d].window '%s' 不是有效的时长（如 1m、24h、5h）", index, q.Window))
+	}
+	if q.MaxRequests < 0 || q.MaxTokens < 0 || (q.MaxRequests == 0 && q.MaxTokens == 0) {
+		errors = append(errors, fmt.Sprintf("quotas[%d] 需要设置 maxRequests 或 maxTokens，且不能为负数", index))
+	}
+	if q.ReserveRatio < 0 || q.ReserveRatio >= 1 {
+		errors = append(errors, fmt.Sprintf("quotas[%d].reserveRatio 必须在 0 到 1 之间", index))
+	}
+	return errors
+}
+
+// ProviderQuotaUsage 是一个配额窗口的当前用量
+type ProviderQuotaUsage struct {
+	Platform          string `json:"platform"`
+	Provider          string `json:"provider"`
+	Window            string `json:"window"`
+	Anchored          bool   `json:"anchored"`
+	MaxRequests       int    `json:"max_requests,omitempty"`
+	MaxTokens         int64  `json:"max_tokens,omitempty"`
+	UsedRequests      int    `json:"used_requests"`
+	UsedTokens        int64  `json:"used_tokens"`
+	RemainingRequests int    `json:"remaining_requests,omitempty"`
+	RemainingTokens   int64  `json:"remaining_tokens,omitempty"`
+	// 即将用完，已停止路由到该 provider
+	Exhausted bool `json:"exhausted"`
+	// 用量回落到可用范围（滚动窗口）或窗口重置（固定窗口）的时间，未用完时为空
+	ResetAt time.Time `json:"reset_at,omitempty"`
+}
+
+type quotaSample struct {
+	at     time.Time
+	tokens int64
+}
+
+// quotaTracker 记录每个 provider 最近的请求与 token 用量，key 为 poolKey(platform, provider)；
+// 首次使用时从请求日志恢复，重启后仍按窗口内的实际用量计算
+type quotaTracker struct {
+	mu      sync.Mutex
+	samples map[string][]quotaSample
+	seeded  map[string]bool
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{samples: make(map[string][]quotaSample), seeded: make(map[string]bool)}
+}
+
+// record 记录一次发送到 provider 的请求及其 token 用量
+func (qt *quotaTracker) record(kind string, provider Provider, tokens int64) {
+	if len(provider.Quotas) == 0 {
+		return
+	}
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	key := poolKey(kind, provider.Name)
+	qt.seedLocked(kind, provider)
+	qt.samples[key] = append(qt.samples[key], quotaSample{at: time.Now(), tokens: tokens})
+}
+
+// seedLocked 从请求日志加载最长窗口内的用量
+func (qt *quotaTracker) seedLocked(kind string, provider Provider) {
+	key := poolKey(kind, provider.Name)
+	if qt.seeded[key] {
+		return
+	}
+	qt.seeded[key] = true
+	since := time.Now().Add(-quotaRetention(provider.Quotas))
+	records, err := xdb.New("request_log").Selects(
+		xdb.WhereEq("platform", kind),
+		xdb.WhereEq("provider", provider.Name),
+		xdb.WhereGte("created_at", since.Format(timeLayout)),
+		xdb.Field("created_at", "input_tokens", "output_tokens", "cache_create_tokens", "cache_read_tokens"),
+		xdb.OrderByAsc("created_at"),
+	)
+	if err != nil {
+		fmt.Printf("[WARN] 从请求日志恢复 provider %s 的配额用量失败: %v\n", provider.Name, err)
+		return
+	}
+	seeded := make([]quotaSample, 0, len(records))
+	for _, record := range records {
+		at, ok := parseCreatedAt(record)
+		if !ok {
+			continue
+		}
+		tokens := record.GetInt64("input_tokens") + record.GetInt64("output_tokens") +
+			record.GetInt64("cache_create_tokens") + record.GetInt64("cache_read_tokens")
+		seeded = append(seeded, quotaSample{at: at, tokens: tokens})
+	}
+	qt.samples[key] = append(seeded, qt.samples[key]...)
+}
+
+func quotaRetention(quotas []ProviderQuota) time.Duration {
+	retention := quotaMinRetention
+	for _, quota := range quotas {
+		// 固定窗口需要上一个窗口的样本确定当前窗口的起点
+		window := quota.window()
+		if quota.Anchored {
+			window *= 2
+		}
+		if window > retention {
+			retention = window
+		}
+	}
+	return retention
+}
+
+// usage 返回 provider 各配额窗口的当前用量
+func (qt *quotaTracker) usage(kind string, provider Provider) []ProviderQuotaUsage {
+	if len(provider.Quotas) == 0 {
+		return nil
+	}
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	qt.seedLocked(kind, provider)
+	key := poolKey(kind, provider.Name)
+	now := time.Now()
+	samples := qt.samples[key]
+	cutoff := now.Add(-quotaRetention(provider.Quotas))
+	start := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(cutoff) })
+	samples = samples[start:]
+	qt.samples[key] = samples
+
+	result := make([]ProviderQuotaUsage, 0, len(provider.Quotas))
+	for _, quota := range provider.Quotas {
+		window := quota.window()
+		if window <= 0 {
+			continue
+		}
+		result = append(result, summarizeQuota(kind, provider.Name, quota, window, samples, now))
+	}
+	return result
+}
+
+func summarizeQuota(kind string, providerName string, quota ProviderQuota, window time.Duration, samples []quotaSample, now time.Time) ProviderQuotaUsage {
+	usage := ProviderQuotaUsage{
+		Platform:    kind,
+		Provider:    providerName,
+		Window:      quota.Window,
+		Anchored:    quota.Anchored,
+		MaxRequests: quota.MaxRequests,
+		MaxTokens:   quota.MaxTokens,
+	}
+	var inWindow []quotaSample
+	var windowEnd time.Time
+	if quota.Anchored {
+		// 窗口从第一次请求开始，到期后下一次请求开始新的窗口
+		var anchor time.Time
+		for i, sample := range samples {
+			if anchor.IsZero() || !sample.at.Before(anchor.Add(window)) {
+				anchor = sample.at
+				inWindow = samples[i:]
+			}
+		}
+		if anchor.IsZero() || !now.Before(anchor.Add(window)) {
+			inWindow = nil
+		}
+		windowEnd = anchor.Add(window)
+	} else {
+		cutoff := now.Add(-window)
+		start := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(cutoff) })
+		inWindow = samples[start:]
+	}
+	usage.UsedRequests = len(inWindow)
+	for _, sample := range inWindow {
+		usage.UsedTokens += sample.tokens
+	}
+
+	requestLimit := float64(quota.MaxRequests) * (1 - quota.reserve())
+	tokenLimit := float64(quota.MaxTokens) * (1 - quota.reserve())
+	exhausted := func(requests int, tokens int64) bool {
+		return (quota.MaxRequests > 0 && float64(requests) >= requestLimit) || (quota.MaxTokens > 0 && float64(tokens) >= tokenLimit)
+	}
+	if quota.MaxRequests > 0 {
+		usage.RemainingRequests = max(quota.MaxRequests-usage.UsedRequests, 0)
+	}
+	if quota.MaxTokens > 0 {
+		usage.RemainingTokens = max(quota.MaxTokens-usage.UsedTokens, 0)
+	}
+	if !exhausted(usage.UsedRequests, usage.UsedTokens) {
+		return usage
+	}
+	usage.Exhausted = true
+	if quota.Anchored {
+		usage.ResetAt = windowEnd
+		return usage
+	}
+	// 滚动窗口：最早的样本依次过期，用量回落到可用范围的时间
+	requests, tokens := usage.UsedRequests, usage.UsedTokens
+	for _, sample := range inWindow {
+		requests--
+		tokens -= sample.tokens
+		if !exhausted(requests, tokens) {
+			usage.ResetAt = sample.at.Add(window)
+			break
+		}
+	}
+	return usage
+}
+
+// blockReason 在 provider 的任一配额即将用完时返回停止路由的原因
+func (qt *quotaTracker) blockReason(kind string, provider Provider) string {
+	for _, usage := range qt.usage(kind, provider) {
+		if usage.Exhausted {
+			return fmt.Sprintf("%s 配额即将用完（已用 %d 次请求、%d tokens），%s 后恢复",
+				usage.Window, usage.UsedRequests, usage.UsedTokens, usage.ResetAt.Format("15:04:05"))
+		}
+	}
+	return ""
+}
+
+// ProviderQuotas 返回所有配置了配额的 provider 在各窗口的用量与剩余配额
+func (prs *ProviderRelayService) ProviderQuotas() []ProviderQuotaUsage {
+	result := []ProviderQuotaUsage{}
+	for _, kind := range []string{"claude", "codex"} {
+		providers, err := prs.loadProviders(kind)
+		if err != nil {
+			continue
+		}
+		for _, provider := range providers {
+			result = append(result, prs.quotas.usage(kind, provider)...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSummarizeQuota(t *testing.T) {
+	now := time.Now()
+	samples := []quotaSample{
+		{at: now.Add(-50 * time.Second), tokens: 100},
+		{at: now.Add(-30 * time.Second), tokens: 100},
+		{at: now.Add(-10 * time.Second), tokens: 100},
+	}
+	rolling := ProviderQuota{Window: "1m", MaxRequests: 3}
+	usage := summarizeQuota("claude", "p", rolling, time.Minute, samples, now)
+	if !usage.Exhausted || usage.UsedRequests != 3 || usage.RemainingRequests != 0 || !usage.ResetAt.Equal(samples[0].at.Add(time.Minute)) {
+		t.Fatalf("滚动窗口用满后应在最早的请求过期时恢复: %+v", usage)
+	}
+	usage = summarizeQuota("claude", "p", ProviderQuota{Window: "40s", MaxRequests: 3}, 40*time.Second, samples, now)
+	if usage.Exhausted || usage.UsedRequests != 2 || usage.RemainingRequests != 1 {
+		t.Fatalf("只应统计窗口内的请求: %+v", usage)
+	}
+	usage = summarizeQuota("claude", "p", ProviderQuota{Window: "1m", MaxTokens: 1000}, time.Minute, samples, now)
+	if usage.Exhausted || usage.UsedTokens != 300 || usage.RemainingTokens != 700 {
+		t.Fatalf("token 配额统计错误: %+v", usage)
+	}
+	// 剩余不足预留比例时即视为用完
+	usage = summarizeQuota("claude", "p", ProviderQuota{Window: "1m", MaxTokens: 310, ReserveRatio: 0.1}, time.Minute, samples, now)
+	if !usage.Exhausted {
+		t.Fatalf("剩余配额低于预留比例时应停止路由: %+v", usage)
+	}
+
+	// 固定窗口从第一次请求开始计时，到期后下一次请求开启新窗口
+	anchored := ProviderQuota{Window: "5h", Anchored: true, MaxRequests: 2}
+	fiveHours := 5 * time.Hour
+	samples = []quotaSample{
+		{at: now.Add(-7 * time.Hour)},
+		{at: now.Add(-6 * time.Hour)},
+		{at: now.Add(-90 * time.Minute)},
+		{at: now.Add(-time.Hour)},
+	}
+	usage = summarizeQuota("claude", "p", anchored, fiveHours, samples, now)
+	if !usage.Exhausted || usage.UsedRequests != 2 || !usage.ResetAt.Equal(samples[2].at.Add(fiveHours)) {
+		t.Fatalf("固定窗口应从新窗口的第一次请求开始计时: %+v", usage)
+	}
+	usage = summarizeQuota("claude", "p", anchored, fiveHours, samples[:2], now)
+	if usage.Exhausted || usage.UsedRequests != 0 {
+		t.Fatalf("固定窗口到期后应整体重置: %+v", usage)
+	}
+}
+
+func TestProviderQuotaValidation(t *testing.T) {
+	p := Provider{Name: "p", APIURL: "https://example.com", Quotas: []ProviderQuota{
+		{Window: "5h", Anchored: true, MaxTokens: 1000000},
+		{Window: "daily", MaxRequests: 10},
+		{Window: "1m"},
+	}}
+	errs := p.ValidateConfiguration()
+	if len(errs) != 2 || !strings.Contains(errs[0], "quotas[1].window") || !strings.Contains(errs[1], "quotas[2]") {
+		t.Fatalf("应报告无效的配额配置: %v", errs)
+	}
+}
+
+func TestRelayPausesProviderNearQuota(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":400,\"output_tokens\":1}}}\n\n")
+			fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":100}}\n\n")
+		}
+	}
+	primary := httptest.NewServer(handler("primary"))
+	defer primary.Close()
+	backup := httptest.NewServer(handler("backup"))
+	defer backup.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: primary.URL, APIKey: "sk-primary-1234567890", Enabled: true, Level: 1,
+			Quotas: []ProviderQuota{{Window: "5h", Anchored: true, MaxTokens: 1500}}},
+		{ID: 2, Name: "backup", APIURL: backup.URL, APIKey: "sk-backup-1234567890", Enabled: true, Level: 2},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	// 重启前已在窗口内使用的请求从请求日志恢复
+	if _, err := xdb.New("request_log", xdb.WithSaveZero()).Insert(xdb.Record{
+		"platform": "claude", "provider": "primary", "model": "claude-sonnet-4-5", "http_code": 200,
+		"input_tokens": 400, "output_tokens": 100, "created_at": time.Now().Format(timeLayout),
+	}); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func() {
+		hits = nil
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	post()
+	if strings.Join(hits, ",") != "primary" {
+		t.Fatalf("配额充足时应使用优先级最高的 provider: %v", hits)
+	}
+	quotas := relay.ProviderQuotas()
+	if len(quotas) != 1 || quotas[0].UsedRequests != 2 || quotas[0].UsedTokens != 1000 || quotas[0].RemainingTokens != 500 || quotas[0].Exhausted {
+		t.Fatalf("应统计日志中已有的与新发出的请求: %+v", quotas)
+	}
+
+	post()
+	if strings.Join(hits, ",") != "primary" {
+		t.Fatalf("配额用完前仍应路由到该 provider: %v", hits)
+	}
+	post()
+	if strings.Join(hits, ",") != "backup" {
+		t.Fatalf("配额即将用完时应跳过该 provider: %v", hits)
+	}
+	quotas = relay.ProviderQuotas()
+	if len(quotas) != 1 || !quotas[0].Exhausted || quotas[0].ResetAt.IsZero() {
+		t.Fatalf("应返回配额用完与恢复时间: %+v", quotas)
+	}
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultRefusalThreshold = 3
+	defaultRefusalWindow    = 10 * time.Minute
+	defaultRefusalBlackout  = 30 * time.Minute
+	// 未命中任何分类规则的请求归入默认分类
+	refusalTagDefault = "default"
+)
+
+// 上游以错误响应拒绝请求时常见的内容策略标识（小写）
+var refusalBodyMarkers = []string{
+	"content_policy_violation",
+	"content_filter",
+	"content filtering policy",
+	"responsible ai policy",
+}
+
+// RefusalConfig 控制 provider/模型组合反复拒答时的临时屏蔽
+type RefusalConfig struct {
+	Enabled bool `json:"enabled"`
+	// 窗口内同一分类的拒答次数达到该值后屏蔽该 provider/模型组合
+	Threshold     int     `json:"threshold"`
+	WindowSeconds float64 `json:"windowSeconds"`
+	// 屏蔽时长（秒），期间优先使用其他 provider
+	BlackoutSeconds float64 `json:"blackoutSeconds"`
+	// 按最后一条用户消息对请求分类，未配置时所有请求归为同一类
+	Rules []RefusalRule `json:"rules,omitempty"`
+}
+
+// RefusalRule 将匹配任一正则的提示词归入 Tag 分类
+type RefusalRule struct {
+	Tag      string   `json:"tag"`
+	Patterns []string `json:"patterns"`
+}
+
+// RefusalBlackout 描述一个因拒答被临时屏蔽的 provider/模型/分类组合
+type RefusalBlackout struct {
+	Platform string    `json:"platform"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	Tag      string    `json:"tag"`
+	Refusals int       `json:"refusals"`
+	Until    time.Time `json:"until"`
+}
+
+// QualityEvent 描述一次上游尝试的响应，供质量评分钩子判断是否属于拒答
+type QualityEvent struct {
+	Platform   string
+	Provider   string
+	Model      string
+	Tags       []string
+	StatusCode int
+	// Refused 为内置规则的判断结果（stop_reason 为 refusal 或内容策略错误）
+	Refused bool
+	Reason  string
+}
+
+// QualityScorer 对上游响应评分，返回 refused=true 时计入拒答
+// 任一 scorer 判定为拒答即计入，scorer 无法撤销内置规则的判断
+type QualityScorer func(event QualityEvent) (refused bool, reason string)
+
+// qualityScorerSet 保存已注册的评分钩子
+type qualityScorerSet struct {
+	mu      sync.RWMutex
+	scorers []QualityScorer
+}
+
+func (qs *qualityScorerSet) add(scorer QualityScorer) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.scorers = append(qs.scorers, scorer)
+}
+
+// score 依次执行所有 scorer，返回最终的拒答判断
+func (qs *qualityScorerSet) score(event QualityEvent) (bool, string) {
+	qs.mu.RLock()
+	scorers := qs.scorers
+	qs.mu.RUnlock()
+	refused, reason := event.Refused, event.Reason
+	for _, scorer := range scorers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("[ERROR] quality scorer panic: %v\n", r)
+				}
+			}()
+			if ok, why := scorer(event); ok && !refused {
+				refused, reason = true, why
+			}
+		}()
+	}
+	return refused, reason
+}
+
+func (c RefusalConfig) threshold() int {
+	if c.Threshold <= 0 {
+		return defaultRefusalThreshold
+	}
+	return c.Threshold
+}
+
+func (c RefusalConfig) window() time.Duration {
+	if c.WindowSeconds <= 0 {
+		return defaultRefusalWindow
+	}
+	return time.Duration(c.WindowSeconds * float64(time.Second))
+}
+
+func (c RefusalConfig) blackout() time.Duration {
+	if c.BlackoutSeconds <= 0 {
+		return defaultRefusalBlackout
+	}
+	return time.Duration(c.BlackoutSeconds * float64(time.Second))
+}
+
+// promptTags 返回请求所属的分类（无效的正则直接忽略）
+func (c RefusalConfig) promptTags(kind string, body []byte) []string {
+	if !c.Enabled {
+		return nil
+	}
+	prompt := lastUserPrompt(kind, body)
+	var tags []string
+	for _, rule := range c.Rules {
+		if rule.Tag == "" {
+			continue
+		}
+		for _, pattern := range rule.Patterns {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(prompt) {
+				tags = append(tags, rule.Tag)
+				break
+			}
+		}
+	}
+	if len(tags) == 0 {
+		tags = []string{refusalTagDefault}
+	}
+	return tags
+}
+
+// lastUserPrompt 提取最后一条用户消息的文本（claude 为 messages，codex 为 input）
+func lastUserPrompt(kind string, body []byte) string {
+	field := "messages"
+	if kind == "codex" {
+		field = "input"
+		if input := gjson.GetBytes(body, "input"); input.Type == gjson.String {
+			return input.String()
+		}
+	}
+	messages := gjson.GetBytes(body, field).Array()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Get("role").String() != "user" {
+			continue
+		}
+		content := messages[i].Get("content")
+		if content.Type == gjson.String {
+			return content.String()
+		}
+		var parts []string
+		for _, part := range content.Array() {
+			if text := part.Get("text"); text.Exists() {
+				parts = append(parts, text.String())
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// refusalFromBody 判断错误响应是否为内容策略拒绝
+func refusalFromBody(status int, body string) string {
+	if status < 400 || status >= 500 {
+		return ""
+	}
+	lower := strings.ToLower(body)
+	for _, marker := range refusalBodyMarkers {
+		if strings.Contains(lower, marker) {
+			return marker
+		}
+	}
+	return ""
+}
+
+// refusalTracker 按 provider/模型/分类统计拒答，超过阈值后临时屏蔽
+type refusalTracker struct {
+	mu        sync.Mutex
+	refusals  map[string][]time.Time
+	blackouts map[string]*RefusalBlackout
+}
+
+func newRefusalTracker() *refusalTracker {
+	return &refusalTracker{
+		refusals:  make(map[string][]time.Time),
+		blackouts: make(map[string]*RefusalBlackout),
+	}
+}
+
+func refusalSlot(kind string, providerName string, model string, tag string) string {
+	return modelSlot(kind, providerName, model) + "\x00" + tag
+}
+
+// record 记录一次拒答，返回是否因此进入屏蔽
+func (rt *refusalTracker) record(kind string, providerName string, model string, tag string, cfg RefusalConfig) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	slot := refusalSlot(kind, providerName, model, tag)
+	now := time.Now()
+	window := cfg.window()
+	recent := make([]time.Time, 0, len(rt.refusals[slot])+1)
+	for _, at := range rt.refusals[slot] {
+		if now.Sub(at) <= window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < cfg.threshold() {
+		rt.refusals[slot] = recent
+		return false
+	}
+	delete(rt.refusals, slot)
+	rt.blackouts[slot] = &RefusalBlackout{
+		Platform: kind,
+		Provider: providerName,
+		Model:    model,
+		Tag:      tag,
+		Refusals: len(recent),
+		Until:    now.Add(cfg.blackout()),
+	}
+	return true
+}
+
+// blackedOut 判断 provider/模型组合是否对请求的任一分类处于屏蔽中
+func (rt *refusalTracker) blackedOut(kind string, providerName string, model string, tags []string) (RefusalBlackout, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	now := time.Now()
+	for _, tag := range tags {
+		slot := refusalSlot(kind, providerName, model, tag)
+		entry := rt.blackouts[slot]
+		if entry == nil {
+			continue
+		}
+		if !now.Before(entry.Until) {
+			delete(rt.blackouts, slot)
+			continue
+		}
+		return *entry, true
+	}
+	return RefusalBlackout{}, false
+}
+
+// snapshot 返回仍在屏蔽中的组合
+func (rt *refusalTracker) snapshot() []RefusalBlackout {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	now := time.Now()
+	result := make([]RefusalBlackout, 0, len(rt.blackouts))
+	for slot, entry := range rt.blackouts {
+		if !now.Before(entry.Until) {
+			delete(rt.blackouts, slot)
+			continue
+		}
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Platform != result[j].Platform {
+			return result[i].Platform < result[j].Platform
+		}
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		if result[i].Model != result[j].Model {
+			return result[i].Model < result[j].Model
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
+
+// preferNonRefusing 将对本次请求分类处于屏蔽中的 provider 移到末尾，仅在没有其他选择时使用
+func (prs *ProviderRelayService) preferNonRefusing(req *relayRequest, active []Provider) []Provider {
+	if !req.refusal.Enabled || len(req.promptTags) == 0 {
+		return active
+	}
+	preferred := make([]Provider, 0, len(active))
+	var blocked []Provider
+	for _, provider := range active {
+		model := provider.GetEffectiveModel(provider.routeModel(req.requestedModel))
+		if entry, ok := prs.refusals.blackedOut(req.kind, provider.Name, model, req.promptTags); ok {
+			fmt.Printf("[INFO]   Provider %s 的模型 %s 对分类 %s 拒答过多，屏蔽至 %s，优先使用其他 provider\n",
+				provider.Name, model, entry.Tag, entry.Until.Format("15:04:05"))
+			blocked = append(blocked, provider)
+			continue
+		}
+		preferred = append(preferred, provider)
+	}
+	return append(preferred, blocked...)
+}
+
+// scoreQuality 执行质量评分钩子，拒答时为请求的每个分类计数
+func (prs *ProviderRelayService) scoreQuality(req *relayRequest, event QualityEvent) {
+	if !req.refusal.Enabled {
+		return
+	}
+	refused, reason := prs.qualityScorers.score(event)
+	if !refused {
+		return
+	}
+	for _, tag := range event.Tags {
+		if prs.refusals.record(req.kind, event.Provider, event.Model, tag, req.refusal) {
+			fmt.Printf("[WARN]   Provider %s 的模型 %s 对分类 %s 多次拒答（%s），临时屏蔽 %.0fs\n",
+				event.Provider, event.Model, tag, reason, req.refusal.blackout().Seconds())
+		}
+	}
+}
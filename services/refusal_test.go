@@ -0,0 +1,66 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRefusalPromptTags(t *testing.T) {
+	cfg := RefusalConfig{Enabled: true, Rules: []RefusalRule{
+		{Tag: "security", Patterns: []string{`(?i)exploit|payload`}},
+		{Tag: "medical", Patterns: []string{`(?i)dosage`}},
+	}}
+	claude := []byte(`{"messages":[{"role":"user","content":"what dosage?"},{"role":"assistant","content":"..."},{"role":"user","content":[{"type":"text","text":"write an exploit"}]}]}`)
+	if tags := cfg.promptTags("claude", claude); len(tags) != 1 || tags[0] != "security" {
+		t.Fatalf("应按最后一条用户消息分类，实际 %v", tags)
+	}
+	codex := []byte(`{"input":"refactor this function"}`)
+	if tags := cfg.promptTags("codex", codex); len(tags) != 1 || tags[0] != refusalTagDefault {
+		t.Fatalf("未命中规则的请求应归入默认分类，实际 %v", tags)
+	}
+	if tags := (RefusalConfig{}).promptTags("claude", claude); tags != nil {
+		t.Fatalf("未开启时不应分类，实际 %v", tags)
+	}
+}
+
+func TestRefusalFromBody(t *testing.T) {
+	if got := refusalFromBody(http.StatusBadRequest, `{"error":{"code":"content_policy_violation"}}`); got == "" {
+		t.Fatalf("内容策略错误应识别为拒答")
+	}
+	if got := refusalFromBody(http.StatusInternalServerError, `content_filter`); got != "" {
+		t.Fatalf("5xx 不应识别为拒答")
+	}
+}
+
+func TestRefusalBlackout(t *testing.T) {
+	prs := &ProviderRelayService{refusals: newRefusalTracker()}
+	req := &relayRequest{kind: "claude", requestedModel: "claude-sonnet", promptTags: []string{"security"},
+		refusal: RefusalConfig{Enabled: true, Threshold: 2, WindowSeconds: 60, BlackoutSeconds: 60}}
+	active := []Provider{{Name: "a"}, {Name: "b"}}
+
+	event := QualityEvent{Platform: "claude", Provider: "a", Model: "claude-sonnet", Tags: req.promptTags, StatusCode: http.StatusOK}
+	prs.scoreQuality(req, event)
+	if got := prs.preferNonRefusing(req, active); got[0].Name != "a" {
+		t.Fatalf("未拒答时不应调整顺序")
+	}
+
+	// 评分钩子识别内置规则之外的拒答
+	prs.AddQualityScorer(func(e QualityEvent) (bool, string) { return e.Provider == "a", "custom" })
+	prs.scoreQuality(req, event)
+	prs.scoreQuality(req, event)
+	if got := prs.preferNonRefusing(req, active); got[0].Name != "b" || got[1].Name != "a" {
+		t.Fatalf("多次拒答后应优先使用其他 provider，实际 %v", got)
+	}
+	blackouts := prs.RefusalBlackouts()
+	if len(blackouts) != 1 || blackouts[0].Tag != "security" || !blackouts[0].Until.After(time.Now()) {
+		t.Fatalf("屏蔽记录不正确: %+v", blackouts)
+	}
+
+	// 其他分类的请求不受影响
+	other := *req
+	other.promptTags = []string{refusalTagDefault}
+	if got := prs.preferNonRefusing(&other, active); got[0].Name != "a" {
+		t.Fatalf("屏蔽只作用于匹配的分类")
+	}
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+const relayConfigFileName = "relay.json"
+
+// RelayConfig 是 relay 的全局配置（与 provider 列表一起存放在 ~/.code-switch 下）
+type RelayConfig struct {
+	Retry       RetryConfig         `json:"retry"`
+	Queue       QueueConfig         `json:"queue"`
+	Transcripts TranscriptConfig    `json:"transcripts"`
+	BodyBuffer  BodyBufferConfig    `json:"bodyBuffer"`
+	Pricing     PricingConfig       `json:"pricing"`
+	Overload    OverloadConfig      `json:"overload"`
+	Refusal     RefusalConfig       `json:"refusal"`
+	Currency    CurrencyConfig      `json:"currency"`
+	LogSampling LogSamplingConfig   `json:"logSampling"`
+	Client      ClientConfig        `json:"client"`
+	Metrics     MetricsConfig       `json:"metrics"`
+	Routing     RoutingConfig       `json:"routing"`
+	Cache       ResponseCacheConfig `json:"cache"`
+	Dedup       DedupConfig         `json:"dedup"`
+	PromptCache PromptCacheConfig   `json:"promptCache"`
+	Timeouts    ProviderTimeouts    `json:"timeouts"`
+	Proxy       ProxyConfig         `json:"proxy"`
+	Socket      SocketConfig        `json:"socket"`
+	Shutdown    ShutdownConfig      `json:"shutdown"`
+	ModelPolicy ModelPolicyConfig   `json:"modelPolicy"`
+	// 额外的监听地址，每个监听地址使用独立的 provider 集合、路由规则与归属标签
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+}
+
+// RetryConfig 控制失败请求的重试行为
+type RetryConfig struct {
+	// 同一 provider 上的最大重试次数（不含首次请求）
+	MaxRetryAttempts int `json:"maxRetryAttempts"`
+	// 指数退避的初始等待与上限（毫秒）
+	BaseDelayMs int `json:"baseDelayMs"`
+	MaxDelayMs  int `json:"maxDelayMs"`
+	// 单个请求的重试总预算（秒），超出后不再发起新的尝试
+	BudgetSeconds float64 `json:"budgetSeconds"`
+	// 根据 provider 近期成功率自适应调整重试力度
+	Adaptive bool `json:"adaptive"`
+}
+
+// QueueConfig 控制所有 provider 均被限流时的排队行为
+type QueueConfig struct {
+	Enabled bool `json:"enabled"`
+	// 同时排队的最大请求数，超出后直接返回 429
+	MaxSize int `json:"maxSize"`
+	// 单个请求的最长排队时间（秒），限流窗口超过该值时不再排队
+	MaxWaitSeconds float64 `json:"maxWaitSeconds"`
+	// 排队期间将请求体写入 ~/.code-switch/queue，避免大请求长时间占用内存
+	PersistBodies bool `json:"persistBodies"`
+}
+
+// TranscriptConfig 控制是否保存请求与响应内容（默认关闭）
+type TranscriptConfig struct {
+	Enabled bool `json:"enabled"`
+	// 单条请求/响应保存的最大字节数，超出部分截断
+	MaxBodyBytes int `json:"maxBodyBytes"`
+	// 使用按租户区分的密钥加密保存（AES-256-GCM，密钥位于 ~/.code-switch/transcript-keys.json）
+	Encrypt bool `json:"encrypt"`
+	// 保存时的压缩方式：zstd-chat（默认，使用训练的对话 JSON 字典）、zstd、deflate-chat、deflate 或 none，
+	// 也可以是 RegisterBodyCodec 注册的实现；修改后已保存的内容仍按各自的压缩方式读取
+	Compression string `json:"compression"`
+}
+
+// BodyBufferConfig 控制请求体的缓存方式（重试与降级时重放完整请求）
+type BodyBufferConfig struct {
+	// 保存在内存中的最大请求体字节数，超出后写入临时目录
+	MemoryLimitBytes int64 `json:"memoryLimitBytes"`
+	// 临时文件目录，留空使用系统临时目录
+	SpillDir string `json:"spillDir"`
+}
+
+// OverloadConfig 控制模型过载（529 overloaded_error）后的冷却行为
+type OverloadConfig struct {
+	// 过载模型在该 provider 上的冷却时长（秒），冷却期间直接降级到下一个 provider
+	CooldownSeconds float64 `json:"cooldownSeconds"`
+}
+
+// PricingConfig 控制模型价格数据的缓存位置与数据源
+type PricingConfig struct {
+	// 价格缓存目录，留空时依次使用 $CODE_SWITCH_PRICING_CACHE_DIR、$XDG_CACHE_HOME/code-switch、~/.cache/code-switch
+	CacheDir string `json:"cacheDir"`
+	// 多个进程（如 CLI 与常驻的 relay）共享缓存目录时，写入缓存前获取锁文件
+	CacheLock bool `json:"cacheLock,omitempty"`
+	// 用户提供的镜像地址，优先于内置数据源尝试
+	Mirrors []string `json:"mirrors,omitempty"`
+	// 其他格式的数据源（如 OpenRouter 的模型列表），按顺序优先于镜像与内置数据源尝试
+	Sources []PricingSourceConfig `json:"sources,omitempty"`
+	// 单个数据源的请求超时（秒）
+	SourceTimeoutSeconds float64 `json:"sourceTimeoutSeconds,omitempty"`
+	// 定时更新价格数据的间隔（小时），同时作为缓存有效期，默认 24
+	UpdateIntervalHours float64 `json:"updateIntervalHours,omitempty"`
+	// 远程价格数据的 SHA256 摘要白名单（镜像发布固定文件时使用），留空不校验
+	SHA256 []string `json:"sha256,omitempty"`
+	// 远程价格数据至少包含的模型数量，少于该值时拒绝使用
+	MinModels int `json:"minModels,omitempty"`
+	// 拉取价格数据使用的代理地址（如 http://proxy.corp:8080），留空时使用 relay 的出站代理配置
+	ProxyURL string `json:"proxyUrl,omitempty"`
+	// 离线模式：不访问远程数据源、不定时更新，只使用内置数据与本地覆盖；未开启时仍可通过 $CODE_SWITCH_PRICING_OFFLINE 开启
+	Offline bool `json:"offline,omitempty"`
+	// 本地价格覆盖文件（LiteLLM 格式），字段覆盖同名模型的价格，也可新增模型
+	OverridesFile string `json:"overridesFile,omitempty"`
+	// 严格匹配模型名：不按名称包含关系模糊匹配价格，未匹配的模型不计费
+	StrictMatching bool `json:"strictMatching,omitempty"`
+	// 模型别名（模型名 -> 价格条目名），用于 relay 以自定义名称提供的模型，如 kimi-for-coding
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// 价格数据缺少 1 小时缓存或 [1m] 长上下文单价时使用的规则，优先于内置规则
+	FallbackRules []modelpricing.FallbackRule `json:"fallbackRules,omitempty"`
+	// 历史价格数据快照的保留天数（自最后使用起算），默认 400；日志的费用按写入时的价格版本计算
+	SnapshotRetentionDays float64 `json:"snapshotRetentionDays,omitempty"`
+	// 按时段调整价格的规则（如 DeepSeek 的错峰优惠），按日志写入时间计算
+	TimeOfDayRules []modelpricing.TimeOfDayRule `json:"timeOfDayRules,omitempty"`
+}
+
+// PricingSourceConfig 是一个指定格式的价格数据源
+type PricingSourceConfig struct {
+	URL string `json:"url"`
+	// 数据格式：litellm（默认）、openrouter（/api/v1/models）或 csv（每 1k token 的价格）
+	Format string `json:"format,omitempty"`
+}
+
+// ApplyPricingConfig 将价格配置应用到 modelpricing，需在首次加载价格数据前调用；proxy 为 relay 的出站代理配置
+func ApplyPricingConfig(cfg PricingConfig, proxy ProxyConfig) {
+	modelpricing.SetCacheDir(cfg.CacheDir)
+	opts := []modelpricing.Option{
+		modelpricing.WithMirrors(cfg.Mirrors...),
+		modelpricing.WithSourceTimeout(time.Duration(cfg.SourceTimeoutSeconds * float64(time.Second))),
+		modelpricing.WithUpdateInterval(time.Duration(cfg.UpdateIntervalHours * float64(time.Hour))),
+		modelpricing.WithSHA256(cfg.SHA256...),
+		modelpricing.WithMinModels(cfg.MinModels),
+		modelpricing.WithCacheLock(cfg.CacheLock),
+		modelpricing.WithOverridesFile(cfg.OverridesFile),
+		modelpricing.WithStrictMatching(cfg.StrictMatching),
+		modelpricing.WithAliases(cfg.Aliases),
+		modelpricing.WithSnapshotRetention(time.Duration(cfg.SnapshotRetentionDays * float64(24*time.Hour))),
+	}
+	// WithSource 插入到数据源列表开头，倒序添加以保持配置的顺序
+	for i := len(cfg.Sources) - 1; i >= 0; i-- {
+		source, err := modelpricing.SourceFormat(cfg.Sources[i].Format)
+		if err != nil {
+			fmt.Printf("[WARN] 价格数据源 %s 的格式无效，忽略: %v\n", cfg.Sources[i].URL, err)
+			continue
+		}
+		opts = append(opts, modelpricing.WithSource(cfg.Sources[i].URL, source))
+	}
+	if cfg.Offline {
+		opts = append(opts, modelpricing.WithOffline(true))
+	}
+	if len(cfg.TimeOfDayRules) > 0 {
+		for _, rule := range cfg.TimeOfDayRules {
+			if err := rule.Validate(); err != nil {
+				fmt.Printf("[WARN] 时段价格规则 %s %s-%s 无效，忽略: %v\n", rule.Pattern, rule.Start, rule.End, err)
+			}
+		}
+		opts = append(opts, modelpricing.WithTimeOfDayRules(cfg.TimeOfDayRules...))
+	}
+	if len(cfg.FallbackRules) > 0 {
+		opts = append(opts, modelpricing.WithFallbackRules(cfg.FallbackRules...))
+	}
+	if proxyURL := strings.TrimSpace(cfg.ProxyURL); proxyURL != "" {
+		proxy = resolveProxy(proxyURL, proxy)
+	}
+	if proxy.URL != "" || len(proxy.NoProxy) > 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy.proxyFunc()
+		opts = append(opts, modelpricing.WithTransport(transport))
+	}
+	modelpricing.Configure(opts...)
+}
+
+// CurrencyConfig 控制报表中费用显示的货币（价格数据与日志始终以美元保存）
+type CurrencyConfig struct {
+	// 目标货币代码，如 CNY、EUR、JPY，留空为 USD
+	Target string `json:"target"`
+	// 汇率接口地址，返回 {"rates": {...}} 格式的 JSON（以美元为基准）
+	RateSourceURL string `json:"rateSourceUrl,omitempty"`
+	// 手动指定的汇率（1 USD = N 目标货币），配置后不再请求汇率接口
+	Rates map[string]float64 `json:"rates,omitempty"`
+	// 汇率缓存时长（小时），默认 24
+	CacheTTLHours float64 `json:"cacheTTLHours,omitempty"`
+}
+
+// ApplyCurrencyConfig 设置报表使用的货币与汇率源
+func ApplyCurrencyConfig(cfg CurrencyConfig) {
+	var source modelpricing.RateSource
+	switch {
+	case len(cfg.Rates) > 0:
+		source = modelpricing.StaticRates(cfg.Rates)
+	case cfg.RateSourceURL != "":
+		source = modelpricing.HTTPRateSource{URL: cfg.RateSourceURL}
+	}
+	modelpricing.ConfigureCurrency(cfg.Target, source, time.Duration(cfg.CacheTTLHours*float64(time.Hour)))
+}
+
+type RelayConfigService struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewRelayConfigService() *RelayConfigService {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return &RelayConfigService{path: filepath.Join(home, ".code-switch", relayConfigFileName)}
+}
+
+func (rcs *RelayConfigService) Start() error { return nil }
+func (rcs *RelayConfigService) Stop() error  { return nil }
+
+func defaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		Retry: RetryConfig{
+			MaxRetryAttempts: defaultMaxRetryAttempts,
+			BaseDelayMs:      int(defaultRetryBaseDelay.Milliseconds()),
+			MaxDelayMs:       int(defaultRetryMaxDelay.Milliseconds()),
+			BudgetSeconds:    defaultRetryBudget.Seconds(),
+			Adaptive:         true,
+		},
+		Queue: QueueConfig{
+			Enabled:        true,
+			MaxSize:        defaultQueueMaxSize,
+			MaxWaitSeconds: defaultQueueMaxWait.Seconds(),
+		},
+		Transcripts: TranscriptConfig{
+			MaxBodyBytes: defaultTranscriptMaxBytes,
+			Compression:  TranscriptCodecZstdChat,
+		},
+		BodyBuffer: BodyBufferConfig{
+			MemoryLimitBytes: defaultBodyMemoryLimit,
+		},
+		Overload: OverloadConfig{
+			CooldownSeconds: defaultOverloadCooldown.Seconds(),
+		},
+		Timeouts: ProviderTimeouts{
+			ConnectSeconds:   defaultConnectTimeout.Seconds(),
+			FirstByteSeconds: defaultFirstByteTimeout.Seconds(),
+			TotalSeconds:     defaultTotalTimeout.Seconds(),
+		},
+		Refusal: RefusalConfig{
+			Threshold:       defaultRefusalThreshold,
+			WindowSeconds:   defaultRefusalWindow.Seconds(),
+			BlackoutSeconds: defaultRefusalBlackout.Seconds(),
+		},
+		Shutdown: ShutdownConfig{DrainSeconds: defaultDrainTimeout.Seconds()},
+	}
+}
+
+// validate 检查 relay 配置中无法使用的值
+func (cfg RelayConfig) validate() []string {
+	var errors []string
+	if cfg.Retry.MaxRetryAttempts < 0 || cfg.Retry.BaseDelayMs < 0 || cfg.Retry.MaxDelayMs < 0 || cfg.Retry.BudgetSeconds < 0 {
+		errors = append(errors, "重试次数与等待时间不能为负数")
+	}
+	if cfg.Queue.MaxSize < 0 || cfg.Queue.MaxWaitSeconds < 0 {
+		errors = append(errors, "排队长度与等待时间不能为负数")
+	}
+	for _, err := range cfg.Timeouts.validate() {
+		errors = append(errors, "timeouts: "+err)
+	}
+	for _, err := range cfg.Proxy.validate() {
+		errors = append(errors, "proxy: "+err)
+	}
+	for _, err := range cfg.Socket.validate() {
+		errors = append(errors, "socket: "+err)
+	}
+	for _, err := range cfg.Shutdown.validate() {
+		errors = append(errors, "shutdown: "+err)
+	}
+	for _, err := range cfg.ModelPolicy.validate() {
+		errors = append(errors, "modelPolicy: "+err)
+	}
+	for _, err := range validateSystemPrompts(cfg.Routing.SystemPrompt) {
+		errors = append(errors, "routing: "+err)
+	}
+	errors = append(errors, validateListeners(cfg.Listeners)...)
+	return errors
+}
+
+// GetRelayConfig 返回持久化的 relay 配置，文件不存在时返回默认值
+func (rcs *RelayConfigService) GetRelayConfig() (RelayConfig, error) {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+	return rcs.loadLocked()
+}
+
+// SaveRelayConfig 保存 relay 配置
+func (rcs *RelayConfigService) SaveRelayConfig(cfg RelayConfig) (RelayConfig, error) {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+	if err := rcs.saveLocked(cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// GetModelAliases 返回生效的模型别名（含内置别名）
+func (rcs *RelayConfigService) GetModelAliases() (map[string]string, error) {
+	svc, err := modelpricing.DefaultService()
+	if err != nil {
+		return nil, err
+	}
+	return svc.Aliases(), nil
+}
+
+// SaveModelAliases 保存用户定义的模型别名并立即应用到费用计算，返回生效的别名
+func (rcs *RelayConfigService) SaveModelAliases(aliases map[string]string) (map[string]string, error) {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+	cfg, err := rcs.loadLocked()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Pricing.Aliases = aliases
+	if err := rcs.saveLocked(cfg); err != nil {
+		return nil, err
+	}
+	modelpricing.SetAliases(aliases)
+	return rcs.GetModelAliases()
+}
+
+func (rcs *RelayConfigService) loadLocked() (RelayConfig, error) {
+	cfg := defaultRelayConfig()
+	if rcs == nil {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(rcs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	if len(data) == 0 {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return defaultRelayConfig(), err
+	}
+	return cfg, nil
+}
+
+func (rcs *RelayConfigService) saveLocked(cfg RelayConfig) error {
+	if err := os.MkdirAll(filepath.Dir(rcs.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := rcs.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rcs.path)
+}
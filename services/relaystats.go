@@ -0,0 +1,83 @@
+package services
+
+// RelayStatsService 向前端暴露 relay 的运行时统计（健康分、Key 使用情况、排队请求）
+type RelayStatsService struct {
+	relay *ProviderRelayService
+}
+
+func NewRelayStatsService(relay *ProviderRelayService) *RelayStatsService {
+	return &RelayStatsService{relay: relay}
+}
+
+func (rss *RelayStatsService) Start() error { return nil }
+func (rss *RelayStatsService) Stop() error  { return nil }
+
+// ProviderHealth 返回各 provider 的健康分
+func (rss *RelayStatsService) ProviderHealth() []ProviderHealth {
+	return rss.relay.ProviderHealth()
+}
+
+// ProviderLatency 返回各 provider 上每个模型的首字节延迟（p50/p95）
+func (rss *RelayStatsService) ProviderLatency() []ProviderLatency {
+	return rss.relay.ProviderLatency()
+}
+
+// APIKeyUsage 返回各 API Key 的使用与冷却情况
+func (rss *RelayStatsService) APIKeyUsage() []APIKeyUsage {
+	return rss.relay.APIKeyUsage()
+}
+
+// ModelOverloads 返回各 provider 上每个模型的过载次数
+func (rss *RelayStatsService) ModelOverloads() []ModelOverload {
+	return rss.relay.ModelOverloads()
+}
+
+// RefusalBlackouts 返回因反复拒答而被临时屏蔽的 provider/模型组合
+func (rss *RelayStatsService) RefusalBlackouts() []RefusalBlackout {
+	return rss.relay.RefusalBlackouts()
+}
+
+// QueuedRequests 返回当前因限流而排队的请求
+func (rss *RelayStatsService) QueuedRequests() []QueuedRequest {
+	return rss.relay.QueuedRequests()
+}
+
+// InflightRequests 返回当前正在代理中的请求
+func (rss *RelayStatsService) InflightRequests() []InflightRequest {
+	return rss.relay.InflightRequests()
+}
+
+// OpenRouterCredits 返回 OpenRouter provider 的剩余额度，low 为 true 表示即将用完
+func (rss *RelayStatsService) OpenRouterCredits() []OpenRouterCredits {
+	return rss.relay.OpenRouterCredits()
+}
+
+// ProviderQuotas 返回配置了配额的 provider 在各窗口的已用量、剩余量与恢复时间
+func (rss *RelayStatsService) ProviderQuotas() []ProviderQuotaUsage {
+	return rss.relay.ProviderQuotas()
+}
+
+// ProviderConcurrency 返回配置了并发上限的 provider 当前进行中与排队等待的请求数
+func (rss *RelayStatsService) ProviderConcurrency() []ProviderConcurrency {
+	return rss.relay.ProviderConcurrency()
+}
+
+// ResponseCacheStats 返回响应缓存的条目数、命中次数与节省的费用
+func (rss *RelayStatsService) ResponseCacheStats() ResponseCacheStats {
+	return rss.relay.ResponseCacheStats()
+}
+
+// DedupStats 返回合并到进行中相同请求的次数与当前可合并的请求数
+func (rss *RelayStatsService) DedupStats() DedupStats {
+	return rss.relay.DedupStats()
+}
+
+// ConfigReloadStatus 返回各配置文件的加载状态，配置无效时包含校验错误
+func (rss *RelayStatsService) ConfigReloadStatus() []ConfigReloadStatus {
+	return rss.relay.ConfigReloadStatus()
+}
+
+// CancelRequest 取消一个进行中的请求
+func (rss *RelayStatsService) CancelRequest(id string) bool {
+	return rss.relay.CancelRequest(id)
+}
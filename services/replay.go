@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+const (
+	auditActionReplay = "replay"
+	// 默认回放到本机 relay，与正常使用时经过相同的降级与计费逻辑
+	defaultReplayTarget  = "http://127.0.0.1:18100"
+	defaultReplayTimeout = 10 * time.Minute
+	replayPageSize       = 200
+)
+
+// ReplayOptions 控制保存的请求回放
+type ReplayOptions struct {
+	Criteria TranscriptCriteria `json:"criteria"`
+	// 最多回放的条数，0 表示不限制
+	Limit int `json:"limit"`
+	// relay 地址，默认 http://127.0.0.1:18100
+	Target string `json:"target"`
+	// 检查点文件；中断后使用同一文件重新执行时跳过已成功的请求
+	Checkpoint string `json:"checkpoint"`
+	// 解密加密内容的原因，记录在审计中
+	Reason string `json:"reason"`
+	// 单个请求的超时时间（秒）
+	TimeoutSeconds float64 `json:"timeoutSeconds"`
+}
+
+// ReplayResult 是单条请求的回放结果
+type ReplayResult struct {
+	TranscriptID int64     `json:"transcript_id"`
+	Platform     string    `json:"platform"`
+	Model        string    `json:"model"`
+	StatusCode   int       `json:"status_code"`
+	DurationMs   int64     `json:"duration_ms"`
+	Error        string    `json:"error,omitempty"`
+	CompletedAt  time.Time `json:"completed_at"`
+	// Skipped 为 true 表示检查点中已记录成功，本次未重新发送
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// OK 判断回放是否成功
+func (r ReplayResult) OK() bool {
+	return r.Error == "" && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// ReplaySummary 汇总一次回放
+type ReplaySummary struct {
+	Total      int    `json:"total"`
+	Skipped    int    `json:"skipped"`
+	Succeeded  int    `json:"succeeded"`
+	Failed     int    `json:"failed"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+// replayCheckpoint 是检查点文件的内容，只保存成功与失败的结果，不保存请求内容
+type replayCheckpoint struct {
+	Target    string                 `json:"target"`
+	Criteria  TranscriptCriteria     `json:"criteria"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Results   map[int64]ReplayResult `json:"results"`
+}
+
+func loadReplayCheckpoint(path string) (*replayCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var checkpoint replayCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("解析检查点失败: %w", err)
+	}
+	if checkpoint.Results == nil {
+		checkpoint.Results = make(map[int64]ReplayResult)
+	}
+	return &checkpoint, nil
+}
+
+// save 先写临时文件再重命名，避免中断时留下不完整的检查点
+func (cp *replayCheckpoint) save(path string) error {
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReplayTranscripts 按 id 顺序将保存的请求重新发送到 relay，progress 在每条请求完成后调用
+// 配置了检查点时每完成一条即写入，已成功的请求不会重复发送，失败的请求在下次执行时重试
+func (ts *TranscriptService) ReplayTranscripts(ctx context.Context, opts ReplayOptions, progress func(ReplayResult)) (ReplaySummary, error) {
+	summary := ReplaySummary{Checkpoint: opts.Checkpoint}
+	target := opts.Target
+	if target == "" {
+		target = defaultReplayTarget
+	}
+	timeout := time.Duration(opts.TimeoutSeconds * float64(time.Second))
+	if timeout <= 0 {
+		timeout = defaultReplayTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	// 检查点中只保存 key_hint，避免完整 Key 落盘
+	criteria := opts.Criteria
+	if criteria.Key != "" {
+		criteria.Key = keyHintFor(criteria.Key)
+	}
+	checkpoint := &replayCheckpoint{Target: target, Criteria: criteria, Results: make(map[int64]ReplayResult)}
+	if opts.Checkpoint != "" {
+		loaded, err := loadReplayCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return summary, err
+		}
+		if loaded != nil {
+			if loaded.Target != target || !sameCriteria(loaded.Criteria, criteria) {
+				return summary, fmt.Errorf("检查点 %s 属于另一组回放参数，请使用新的检查点文件", opts.Checkpoint)
+			}
+			checkpoint = loaded
+		}
+	}
+
+	audited := false
+	var lastID int64
+	for {
+		options := append(opts.Criteria.options(),
+			xdb.WhereEq("deleted_at", ""),
+			xdb.WhereEq("purged_at", ""),
+			xdb.WhereGt("id", lastID),
+			xdb.OrderByAsc("id"),
+			xdb.Limit(replayPageSize),
+		)
+		records, err := xdb.New("request_transcript").Selects(options...)
+		if err != nil && !errors.Is(err, xdb.ErrNotFound) && !isNoSuchTableErr(err) {
+			return summary, err
+		}
+		if len(records) == 0 {
+			return summary, nil
+		}
+		for _, record := range records {
+			if opts.Limit > 0 && summary.Total >= opts.Limit {
+				return summary, nil
+			}
+			if err := ctx.Err(); err != nil {
+				return summary, err
+			}
+			transcript := decodeTranscriptRecord(record)
+			lastID = transcript.ID
+			summary.Total++
+
+			if previous, ok := checkpoint.Results[transcript.ID]; ok && previous.OK() {
+				previous.Skipped = true
+				summary.Skipped++
+				if progress != nil {
+					progress(previous)
+				}
+				continue
+			}
+			if transcript.Encrypted {
+				if transcript, err = ts.decryptTranscript(transcript); err != nil {
+					return summary, err
+				}
+				if !audited {
+					if _, err := recordPurgeAudit(auditActionReplay, opts.Criteria, opts.Reason, 1); err != nil {
+						return summary, fmt.Errorf("写入回放审计失败: %w", err)
+					}
+					audited = true
+				}
+			}
+
+			result := replayTranscript(ctx, client, target, transcript)
+			if result.OK() {
+				summary.Succeeded++
+			} else {
+				summary.Failed++
+			}
+			if opts.Checkpoint != "" {
+				checkpoint.Results[transcript.ID] = result
+				if err := checkpoint.save(opts.Checkpoint); err != nil {
+					return summary, fmt.Errorf("写入检查点失败: %w", err)
+				}
+			}
+			if progress != nil {
+				progress(result)
+			}
+		}
+	}
+}
+
+// replayTranscript 发送单条请求并读完响应（流式响应同样读到结束）
+func replayTranscript(ctx context.Context, client *http.Client, target string, transcript Transcript) ReplayResult {
+	result := ReplayResult{TranscriptID: transcript.ID, Platform: transcript.Platform, Model: transcript.Model}
+	finish := func(err error) ReplayResult {
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.CompletedAt = time.Now().UTC()
+		return result
+	}
+	if transcript.Truncated {
+		return finish(errors.New("请求内容已截断，无法回放"))
+	}
+	endpoint := "/v1/messages"
+	if transcript.Platform == "codex" {
+		endpoint = "/responses"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, joinURL(target, endpoint), bytes.NewReader([]byte(transcript.RequestBody)))
+	if err != nil {
+		return finish(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.DurationMs = time.Since(start).Milliseconds()
+		return finish(err)
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.StatusCode = resp.StatusCode
+	if err == nil && !result.OK() {
+		err = fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return finish(err)
+}
+
+func sameCriteria(a TranscriptCriteria, b TranscriptCriteria) bool {
+	return a.Key == b.Key && a.SessionID == b.SessionID && a.From.Equal(b.From) && a.To.Equal(b.To)
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestReplayTranscriptsResumesFromCheckpoint(t *testing.T) {
+	initTestDatabase(t)
+	entry := &ReqeustLog{Platform: "claude", Provider: "p1", Model: "m"}
+	for i, prompt := range []string{"one", "two", "three"} {
+		capture := newTranscriptCapture(TranscriptConfig{})
+		if err := saveTranscript(int64(i+1), entry, "s1", []byte(prompt), capture); err != nil {
+			t.Fatalf("保存内容失败: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	failTwo := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		hits[string(body)]++
+		if r.URL.Path != "/v1/messages" || (string(body) == "two" && failTwo) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ts := NewTranscriptService()
+	opts := ReplayOptions{
+		Criteria:   TranscriptCriteria{SessionID: "s1"},
+		Target:     server.URL,
+		Checkpoint: filepath.Join(t.TempDir(), "replay.json"),
+	}
+	summary, err := ts.ReplayTranscripts(context.Background(), opts, nil)
+	if err != nil || summary.Total != 3 || summary.Succeeded != 2 || summary.Failed != 1 {
+		t.Fatalf("首次回放 = (%+v, %v)", summary, err)
+	}
+
+	// 再次执行只重试失败的请求
+	mu.Lock()
+	failTwo = false
+	mu.Unlock()
+	summary, err = ts.ReplayTranscripts(context.Background(), opts, nil)
+	if err != nil || summary.Skipped != 2 || summary.Succeeded != 1 || summary.Failed != 0 {
+		t.Fatalf("恢复回放 = (%+v, %v)", summary, err)
+	}
+	if hits["one"] != 1 || hits["three"] != 1 || hits["two"] != 2 {
+		t.Fatalf("已成功的请求不应重复发送: %v", hits)
+	}
+
+	// 参数不同的回放不能复用检查点
+	other := opts
+	other.Criteria = TranscriptCriteria{SessionID: "s2"}
+	if _, err := ts.ReplayTranscripts(context.Background(), other, nil); err == nil {
+		t.Fatalf("参数不一致时应拒绝使用检查点")
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+const (
+	// 价格更新后重新计算最近多少天的费用
+	defaultRepriceWindowDays = 7
+	// 检查价格数据是否更新的间隔
+	repriceCheckInterval = time.Hour
+	// 费用差异小于该值时视为未变化
+	repriceEpsilon = 1e-9
+)
+
+// RepricingModelAdjustment 汇总单个模型的费用修正
+type RepricingModelAdjustment struct {
+	Model         string  `json:"model"`
+	Entries       int     `json:"entries"`
+	OriginalTotal float64 `json:"original_total"`
+	RepricedTotal float64 `json:"repriced_total"`
+	Delta         float64 `json:"delta"`
+}
+
+// RepricingSummary 描述一次重新计价的结果
+type RepricingSummary struct {
+	StartedAt        time.Time                  `json:"started_at"`
+	PricingUpdatedAt time.Time                  `json:"pricing_updated_at"`
+	WindowDays       int                        `json:"window_days"`
+	Scanned          int                        `json:"scanned"`
+	Adjusted         int                        `json:"adjusted"`
+	Backfilled       int                        `json:"backfilled"`
+	OriginalTotal    float64                    `json:"original_total"`
+	RepricedTotal    float64                    `json:"repriced_total"`
+	Delta            float64                    `json:"delta"`
+	Models           []RepricingModelAdjustment `json:"models"`
+}
+
+// RepricingService 在价格数据更新后重新计算最近的请求费用
+// 原始费用保存在 original_cost，修正后的费用写入 repriced_cost，两者同时保留便于对账
+type RepricingService struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	last    *RepricingSummary
+	running bool
+	// updated 在价格数据更新时收到通知，立即触发一次检查
+	updated chan struct{}
+}
+
+func NewRepricingService() *RepricingService {
+	return &RepricingService{updated: make(chan struct{}, 1)}
+}
+
+// Start 启动后台检查：价格数据更新时立即检查，此外每小时检查一次，有更新则重新计价
+func (rs *RepricingService) Start() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.cancel != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if pricing, err := modelpricing.DefaultService(); err == nil {
+		unregister := pricing.OnUpdated(func(oldVersion, newVersion string) {
+			fmt.Printf("[INFO] 模型价格数据已更新: %s -> %s\n", oldVersion, newVersion)
+			select {
+			case rs.updated <- struct{}{}:
+			default:
+			}
+		})
+		rs.cancel = func() {
+			unregister()
+			cancel()
+		}
+	} else {
+		rs.cancel = cancel
+	}
+	go rs.loop(ctx)
+	return nil
+}
+
+func (rs *RepricingService) Stop() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.cancel != nil {
+		rs.cancel()
+		rs.cancel = nil
+	}
+	return nil
+}
+
+func (rs *RepricingService) loop(ctx context.Context) {
+	// 启动后稍等片刻，避免与初始化争抢数据库
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-rs.updated:
+		}
+		if _, err := rs.RepriceIfPricingUpdated(); err != nil {
+			fmt.Printf("[WARN] 重新计价失败: %v\n", err)
+		}
+		timer.Reset(repriceCheckInterval)
+	}
+}
+
+// RepriceIfPricingUpdated 仅在价格数据比上次重新计价更新时执行，返回 nil 表示无需处理
+func (rs *RepricingService) RepriceIfPricingUpdated() (*RepricingSummary, error) {
+	updatedAt := modelpricing.LastUpdated()
+	if updatedAt.IsZero() {
+		return nil, nil
+	}
+	lastRun, err := lastRepricingRun()
+	if err != nil {
+		return nil, err
+	}
+	if !updatedAt.Truncate(time.Second).After(lastRun) {
+		return nil, nil
+	}
+	summary, err := rs.RepriceRecent(defaultRepriceWindowDays)
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// LastRepricingSummary 返回本次运行中最近一次重新计价的结果
+func (rs *RepricingService) LastRepricingSummary() *RepricingSummary {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.last
+}
+
+// RepriceRecent 按当前价格重新计算最近 days 天的请求费用
+func (rs *RepricingService) RepriceRecent(days int) (RepricingSummary, error) {
+	if days <= 0 {
+		days = defaultRepriceWindowDays
+	}
+	summary := RepricingSummary{
+		StartedAt:        time.Now(),
+		PricingUpdatedAt: modelpricing.LastUpdated(),
+		WindowDays:       days,
+		Models:           []RepricingModelAdjustment{},
+	}
+
+	rs.mu.Lock()
+	if rs.running {
+		rs.mu.Unlock()
+		return summary, errors.New("重新计价正在进行中")
+	}
+	rs.running = true
+	rs.mu.Unlock()
+	defer func() {
+		rs.mu.Lock()
+		rs.running = false
+		rs.mu.Unlock()
+	}()
+
+	pricing, err := modelpricing.DefaultService()
+	if err != nil {
+		return summary, err
+	}
+
+	since := summary.StartedAt.AddDate(0, 0, -days).Format(timeLayout)
+	records, err := xdb.New("request_log").Selects(
+		xdb.WhereGe("created_at", since),
+		xdb.Field("id", "platform", "provider", "model", "input_tokens", "output_tokens", "cache_create_tokens",
+			"cache_read_tokens", "input_audio_tokens", "output_audio_tokens", "input_images", "output_images", "web_search_calls", "code_executions", "queries",
+			"service_tier", "original_cost", "repriced_cost", "repriced_at"),
+	)
+	if err != nil && !errors.Is(err, xdb.ErrNotFound) {
+		return summary, err
+	}
+
+	model := xdb.New("request_log", xdb.WithSaveZero())
+	now := summary.StartedAt.Format(timeLayout)
+	byModel := make(map[string]*RepricingModelAdjustment)
+	markups := loadProviderMarkups()
+	for _, record := range records {
+		summary.Scanned++
+		modelName := record.GetString("model")
+		newCost := pricing.CalculateCost(modelName, recordUsage(record), markups.forRecord(record)...).TotalCost
+		originalCost := record.GetFloat64("original_cost")
+
+		// 早于原始费用记录功能写入的日志没有原始费用，用当前价格回填，不计为修正
+		if originalCost == 0 && record.GetString("repriced_at") == "" {
+			if newCost > 0 {
+				if _, err := model.Update(xdb.Record{"id": record.GetInt64("id"), "original_cost": newCost}); err != nil {
+					return summary, err
+				}
+				summary.Backfilled++
+			}
+			continue
+		}
+
+		currentCost := originalCost
+		if record.GetString("repriced_at") != "" {
+			currentCost = record.GetFloat64("repriced_cost")
+		}
+		if math.Abs(newCost-currentCost) <= repriceEpsilon {
+			continue
+		}
+		if _, err := model.Update(xdb.Record{
+			"id":            record.GetInt64("id"),
+			"repriced_cost": newCost,
+			"repriced_at":   now,
+		}); err != nil {
+			return summary, err
+		}
+
+		summary.Adjusted++
+		summary.OriginalTotal += originalCost
+		summary.RepricedTotal += newCost
+		adjustment := byModel[modelName]
+		if adjustment == nil {
+			adjustment = &RepricingModelAdjustment{Model: modelName}
+			byModel[modelName] = adjustment
+		}
+		adjustment.Entries++
+		adjustment.OriginalTotal += originalCost
+		adjustment.RepricedTotal += newCost
+	}
+	summary.Delta = summary.RepricedTotal - summary.OriginalTotal
+	for _, adjustment := range byModel {
+		adjustment.Delta = adjustment.RepricedTotal - adjustment.OriginalTotal
+		summary.Models = append(summary.Models, *adjustment)
+	}
+	sort.Slice(summary.Models, func(i, j int) bool {
+		return math.Abs(summary.Models[i].Delta) > math.Abs(summary.Models[j].Delta)
+	})
+
+	if err := saveRepricingRun(summary); err != nil {
+		fmt.Printf("[WARN] 保存重新计价记录失败: %v\n", err)
+	}
+	rs.mu.Lock()
+	rs.last = &summary
+	rs.mu.Unlock()
+	printRepricingSummary(summary)
+	return summary, nil
+}
+
+func printRepricingSummary(summary RepricingSummary) {
+	fmt.Printf("[INFO] 重新计价完成：扫描 %d 条，修正 %d 条，回填 %d 条，费用 $%.4f -> $%.4f（%+.4f）\n",
+		summary.Scanned, summary.Adjusted, summary.Backfilled, summary.OriginalTotal, summary.RepricedTotal, summary.Delta)
+	for _, adjustment := range summary.Models {
+		fmt.Printf("[INFO]   %s: %d 条，$%.4f -> $%.4f（%+.4f）\n",
+			adjustment.Model, adjustment.Entries, adjustment.OriginalTotal, adjustment.RepricedTotal, adjustment.Delta)
+	}
+}
+
+// recordedCost 按当前价格与 provider 的计价调整计算写入日志时的费用
+func recordedCost(entry *ReqeustLog, opts ...modelpricing.CostOption) modelpricing.CostBreakdown {
+	pricing, err := modelpricing.DefaultService()
+	if err != nil || pricing == nil || entry == nil {
+		return modelpricing.CostBreakdown{}
+	}
+	return pricing.CalculateCost(entry.Model, entry.usageSnapshot(), opts...)
+}
+
+func ensureRepricingRunTable(db *sql.DB) error {
+	const createTableSQL = `CREATE TABLE IF NOT EXISTS repricing_run (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pricing_updated_at TEXT,
+		window_days INTEGER,
+		scanned INTEGER,
+		adjusted INTEGER,
+		original_total REAL,
+		repriced_total REAL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(createTableSQL)
+	return err
+}
+
+func saveRepricingRun(summary RepricingSummary) error {
+	_, err := xdb.New("repricing_run", xdb.WithSaveZero()).Insert(xdb.Record{
+		"pricing_updated_at": summary.PricingUpdatedAt.Format(time.RFC3339),
+		"window_days":        summary.WindowDays,
+		"scanned":            summary.Scanned,
+		"adjusted":           summary.Adjusted,
+		"original_total":     summary.OriginalTotal,
+		"repriced_total":     summary.RepricedTotal,
+	})
+	return err
+}
+
+// lastRepricingRun 返回最近一次重新计价所基于的价格更新时间
+func lastRepricingRun() (time.Time, error) {
+	record, err := xdb.New("repricing_run").First(xdb.OrderByDesc("id"), xdb.Field("pricing_updated_at"))
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	parsed, err := time.Parse(time.RFC3339, record.GetString("pricing_updated_at"))
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return parsed, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+func TestRepriceRecentAfterPriceChange(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	pricing, err := modelpricing.DefaultService()
+	if err != nil {
+		t.Fatalf("初始化价格服务失败: %v", err)
+	}
+	usage := modelpricing.UsageSnapshot{InputTokens: 10000, OutputTokens: 2000}
+	official := pricing.CalculateCost("claude-sonnet-4-5", usage).TotalCost
+	if official <= 0 {
+		t.Fatalf("内置价格数据应包含 claude-sonnet-4-5")
+	}
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "reseller", APIURL: "https://reseller.example.com", APIKey: "sk-reseller-1234567890", Enabled: true},
+		{ID: 2, Name: "official", APIURL: "https://api.anthropic.com", APIKey: "sk-official-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	now := time.Now()
+	insert := func(provider string, originalCost float64, createdAt time.Time) int64 {
+		id, err := xdb.New("request_log", xdb.WithSaveZero()).Insert(xdb.Record{
+			"platform": "claude", "provider": provider, "model": "claude-sonnet-4-5", "http_code": 200,
+			"input_tokens": usage.InputTokens, "output_tokens": usage.OutputTokens,
+			"original_cost": originalCost, "created_at": createdAt.Format(timeLayout),
+		})
+		if err != nil {
+			t.Fatalf("写入请求日志失败: %v", err)
+		}
+		return id
+	}
+	// 按调整前的价格写入的日志、早于原始费用记录功能的日志、按过期价格写入的日志与窗口之外的日志
+	reseller := insert("reseller", official, now.Add(-time.Hour))
+	legacy := insert("reseller", 0, now.Add(-2*time.Hour))
+	stale := insert("official", official/2, now.Add(-24*time.Hour))
+	outside := insert("official", official/2, now.AddDate(0, 0, -30))
+
+	// 中转站调整计价：按官方价格的 1.5 倍计费
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "reseller", APIURL: "https://reseller.example.com", APIKey: "sk-reseller-1234567890", Enabled: true, PriceMultiplier: 1.5},
+		{ID: 2, Name: "official", APIURL: "https://api.anthropic.com", APIKey: "sk-official-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	rs := NewRepricingService()
+	summary, err := rs.RepriceRecent(7)
+	if err != nil {
+		t.Fatalf("重新计价失败: %v", err)
+	}
+	if summary.Scanned != 3 || summary.Adjusted != 2 || summary.Backfilled != 1 {
+		t.Fatalf("应扫描窗口内的 3 条日志，修正 2 条并回填 1 条: %+v", summary)
+	}
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	wantOriginal := official + official/2
+	wantRepriced := official*1.5 + official
+	if !near(summary.OriginalTotal, wantOriginal) || !near(summary.RepricedTotal, wantRepriced) || !near(summary.Delta, wantRepriced-wantOriginal) {
+		t.Fatalf("费用合计不正确: %+v，期望 %v -> %v", summary, wantOriginal, wantRepriced)
+	}
+	if len(summary.Models) != 1 || summary.Models[0].Entries != 2 || !near(summary.Models[0].Delta, summary.Delta) {
+		t.Fatalf("按模型汇总不正确: %+v", summary.Models)
+	}
+	if rs.LastRepricingSummary() == nil || rs.LastRepricingSummary().Adjusted != 2 {
+		t.Fatalf("应保存最近一次重新计价的结果")
+	}
+
+	// 原始费用保持不变，修正后的费用单独保存；回填的日志只写入 original_cost
+	ls := NewLogService()
+	markups := loadProviderMarkups()
+	wantCosts := map[int64]float64{reseller: official * 1.5, legacy: official * 1.5, stale: official, outside: official / 2}
+	var total float64
+	for id, want := range wantCosts {
+		record, err := xdb.New("request_log").First(xdb.WhereEq("id", id))
+		if err != nil {
+			t.Fatalf("读取日志 %d 失败: %v", id, err)
+		}
+		if got := ls.recordCost(record, markups); !near(got, want) {
+			t.Fatalf("日志 %d 的费用 = %v，期望 %v", id, got, want)
+		}
+		total += ls.recordCost(record, markups)
+		if id == reseller && (!near(record.GetFloat64("original_cost"), official) || record.GetString("repriced_at") == "") {
+			t.Fatalf("修正的日志应保留原始费用: %v", record)
+		}
+		if id == legacy && (!near(record.GetFloat64("original_cost"), official*1.5) || record.GetString("repriced_at") != "") {
+			t.Fatalf("回填的日志不应计为修正: %v", record)
+		}
+	}
+	if want := official*1.5*2 + official + official/2; !near(total, want) {
+		t.Fatalf("日志费用合计 = %v，期望 %v", total, want)
+	}
+
+	// 价格未再变化时重复执行不产生修正
+	summary, err = rs.RepriceRecent(7)
+	if err != nil || summary.Scanned != 3 || summary.Adjusted != 0 || summary.Backfilled != 0 {
+		t.Fatalf("价格未变化时不应再修正: %+v %v", summary, err)
+	}
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// 请求体超过该大小时写入临时文件，避免重试和排队期间长时间占用内存
+const defaultBodyMemoryLimit = 8 << 20
+
+// requestBody 保存客户端请求体的完整副本
+// 每次上游尝试都从头读取，保证重试与降级发送的始终是完整的原始请求
+type requestBody struct {
+	data []byte
+	// path 非空表示请求体已写入临时文件
+	path string
+	size int64
+}
+
+// bufferRequestBody 读取完整的请求体：不超过 memoryLimit 时保存在内存中，否则写入 dir 下的临时文件
+func bufferRequestBody(r io.Reader, memoryLimit int64, dir string) (*requestBody, error) {
+	if r == nil {
+		return &requestBody{}, nil
+	}
+	if memoryLimit <= 0 {
+		memoryLimit = defaultBodyMemoryLimit
+	}
+	head, err := io.ReadAll(io.LimitReader(r, memoryLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(head)) <= memoryLimit {
+		return &requestBody{data: head, size: int64(len(head))}, nil
+	}
+
+	file, err := os.CreateTemp(dir, "code-switch-body-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("创建请求体临时文件失败: %w", err)
+	}
+	size, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), r))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return &requestBody{path: file.Name(), size: size}, nil
+}
+
+// spilled 表示请求体保存在临时文件中
+func (b *requestBody) spilled() bool {
+	return b.path != ""
+}
+
+func (b *requestBody) Len() int64 {
+	return b.size
+}
+
+// open 返回从头读取请求体的 reader
+func (b *requestBody) open() (io.ReadCloser, error) {
+	if b.path == "" {
+		return io.NopCloser(bytes.NewReader(b.data)), nil
+	}
+	file, err := os.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	return &eofClosingFile{File: file}, nil
+}
+
+// eofClosingFile 在读到末尾时自动关闭文件
+// xrequest 生成调试 curl 时会读完并替换 req.Body 而不关闭原 reader，这里避免泄漏文件句柄
+type eofClosingFile struct {
+	*os.File
+	closed bool
+}
+
+func (f *eofClosingFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, io.EOF
+	}
+	n, err := f.File.Read(p)
+	if err == io.EOF {
+		f.Close()
+	}
+	return n, err
+}
+
+func (f *eofClosingFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.File.Close()
+}
+
+// Bytes 返回完整的请求体；写入临时文件的请求体每次调用都会重新读取
+func (b *requestBody) Bytes() ([]byte, error) {
+	if b.path == "" {
+		return b.data, nil
+	}
+	return os.ReadFile(b.path)
+}
+
+// head 返回请求体的前 n 个字节
+func (b *requestBody) head(n int) []byte {
+	if b.path == "" {
+		if len(b.data) > n {
+			return b.data[:n]
+		}
+		return b.data
+	}
+	reader, err := b.open()
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	data, _ := io.ReadAll(io.LimitReader(reader, int64(n)))
+	return data
+}
+
+// rewrite 对请求体应用 fn，返回新的请求体（调用方负责 Close）
+func (b *requestBody) rewrite(fn func([]byte) ([]byte, error), memoryLimit int64, dir string) (*requestBody, error) {
+	data, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	modified, err := fn(data)
+	if err != nil {
+		return nil, err
+	}
+	return bufferRequestBody(bytes.NewReader(modified), memoryLimit, dir)
+}
+
+// attach 作为请求钩子为每次发出的 http.Request 设置全新的请求体
+// 同时设置 GetBody，使 net/http 在连接被复用失败或重定向时也能重放完整请求
+func (b *requestBody) attach(req *http.Request) error {
+	req.ContentLength = b.size
+	if b.size == 0 {
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return nil
+	}
+	body, err := b.open()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	req.GetBody = b.open
+	return nil
+}
+
+// Close 删除临时文件
+func (b *requestBody) Close() error {
+	if b == nil || b.path == "" {
+		return nil
+	}
+	err := os.Remove(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestBodyReplays(t *testing.T) {
+	payload := `{"model":"claude-sonnet","messages":[{"role":"user","content":"` + strings.Repeat("x", 64) + `"}]}`
+	for _, limit := range []int64{1 << 20, 16} {
+		body, err := bufferRequestBody(strings.NewReader(payload), limit, t.TempDir())
+		if err != nil {
+			t.Fatalf("bufferRequestBody(limit=%d) 失败: %v", limit, err)
+		}
+		if body.spilled() != (limit == 16) {
+			t.Fatalf("limit=%d 时 spilled = %v", limit, body.spilled())
+		}
+
+		// 多次尝试都应发送完整的请求体
+		for attempt := 0; attempt < 3; attempt++ {
+			req, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+			if err := body.attach(req); err != nil {
+				t.Fatalf("attach 失败: %v", err)
+			}
+			got, _ := io.ReadAll(req.Body)
+			req.Body.Close()
+			if string(got) != payload || req.ContentLength != int64(len(payload)) {
+				t.Fatalf("limit=%d 第 %d 次尝试请求体不完整: %d 字节", limit, attempt+1, len(got))
+			}
+			replay, _ := req.GetBody()
+			again, _ := io.ReadAll(replay)
+			replay.Close()
+			if !bytes.Equal(again, got) {
+				t.Fatalf("GetBody 应返回完整请求体")
+			}
+		}
+
+		rewritten, err := body.rewrite(func(data []byte) ([]byte, error) {
+			return ReplaceModelInRequestBody(data, "mapped")
+		}, limit, t.TempDir())
+		if err != nil {
+			t.Fatalf("rewrite 失败: %v", err)
+		}
+		data, _ := rewritten.Bytes()
+		if !strings.Contains(string(data), `"model":"mapped"`) {
+			t.Errorf("rewrite 结果不正确: %s", data)
+		}
+		rewritten.Close()
+
+		path := body.path
+		if err := body.Close(); err != nil {
+			t.Fatalf("Close 失败: %v", err)
+		}
+		if path != "" {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("Close 后临时文件应被删除")
+			}
+		}
+	}
+}
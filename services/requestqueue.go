@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueMaxSize = 32
+	defaultQueueMaxWait = 2 * time.Minute
+)
+
+// ErrQueueFull 表示排队请求数已达上限
+var ErrQueueFull = errors.New("request queue is full")
+
+// QueuedRequest 描述一个因限流而排队的请求
+type QueuedRequest struct {
+	ID         string    `json:"id"`
+	Platform   string    `json:"platform"`
+	Model      string    `json:"model"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	ResumeAt   time.Time `json:"resume_at"`
+	Persisted  bool      `json:"persisted"`
+
+	body     []byte
+	bodyPath string
+}
+
+// requestQueue 是有界的等待队列：请求在限流窗口结束后按同一平台内的入队顺序恢复，
+// 不同平台的 provider 各自限流，互不阻塞；开启 PersistBodies 时请求体在排队期间保存在磁盘上
+type requestQueue struct {
+	mu      sync.Mutex
+	dir     string
+	entries []*QueuedRequest
+	// changed 在队列出队时关闭并重建，用于唤醒等待前序请求的协程
+	changed chan struct{}
+	seq     atomic.Int64
+}
+
+func newRequestQueue(dir string) *requestQueue {
+	q := &requestQueue{dir: dir, changed: make(chan struct{})}
+	q.cleanupStale()
+	return q
+}
+
+// cleanupStale 清理上次运行遗留的请求体文件：客户端连接已随进程退出断开，无法再恢复
+func (q *requestQueue) cleanupStale() {
+	if q.dir == "" {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.body"))
+	if err != nil || len(files) == 0 {
+		return
+	}
+	for _, file := range files {
+		_ = os.Remove(file)
+	}
+	fmt.Printf("[WARN] 已清理 %d 个上次运行遗留的排队请求\n", len(files))
+}
+
+// enqueue 将请求加入队列；队列已满时返回 ErrQueueFull
+func (q *requestQueue) enqueue(cfg QueueConfig, platform string, model string, body []byte, resumeAt time.Time) (*QueuedRequest, error) {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultQueueMaxSize
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) >= maxSize {
+		return nil, ErrQueueFull
+	}
+
+	entry := &QueuedRequest{
+		ID:         strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(q.seq.Add(1), 36),
+		Platform:   platform,
+		Model:      model,
+		EnqueuedAt: time.Now(),
+		ResumeAt:   resumeAt,
+		body:       body,
+	}
+	if cfg.PersistBodies && q.dir != "" {
+		if err := q.persistLocked(entry); err != nil {
+			fmt.Printf("[WARN] 排队请求体写入磁盘失败，改为保存在内存中: %v\n", err)
+		}
+	}
+	q.entries = append(q.entries, entry)
+	return entry, nil
+}
+
+func (q *requestQueue) persistLocked(entry *QueuedRequest) error {
+	if err := os.MkdirAll(q.dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(q.dir, entry.ID+".body")
+	if err := os.WriteFile(path, entry.body, 0o600); err != nil {
+		return err
+	}
+	entry.bodyPath = path
+	entry.Persisted = true
+	entry.body = nil
+	return nil
+}
+
+// wait 阻塞到 entry 的恢复时间，且同一平台先入队的请求均已出队
+func (q *requestQueue) wait(ctx context.Context, entry *QueuedRequest) error {
+	if delay := time.Until(entry.ResumeAt); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	for {
+		q.mu.Lock()
+		head := q.headLocked(entry)
+		changed := q.changed
+		q.mu.Unlock()
+		if head {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// headLocked 判断 entry 之前是否没有同一平台的请求
+func (q *requestQueue) headLocked(entry *QueuedRequest) bool {
+	for _, e := range q.entries {
+		if e == entry {
+			return true
+		}
+		if e.Platform == entry.Platform {
+			return false
+		}
+	}
+	return true
+}
+
+// dequeue 将 entry 移出队列并返回请求体
+func (q *requestQueue) dequeue(entry *QueuedRequest) ([]byte, error) {
+	q.mu.Lock()
+	for i, e := range q.entries {
+		if e == entry {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	close(q.changed)
+	q.changed = make(chan struct{})
+	q.mu.Unlock()
+
+	if entry.bodyPath == "" {
+		return entry.body, nil
+	}
+	defer os.Remove(entry.bodyPath)
+	return os.ReadFile(entry.bodyPath)
+}
+
+// snapshot 返回当前排队中的请求
+func (q *requestQueue) snapshot() []QueuedRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	result := make([]QueuedRequest, 0, len(q.entries))
+	for _, entry := range q.entries {
+		result = append(result, QueuedRequest{
+			ID:         entry.ID,
+			Platform:   entry.Platform,
+			Model:      entry.Model,
+			EnqueuedAt: entry.EnqueuedAt,
+			ResumeAt:   entry.ResumeAt,
+			Persisted:  entry.Persisted,
+		})
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequestQueueBounded(t *testing.T) {
+	q := newRequestQueue("")
+	cfg := QueueConfig{Enabled: true, MaxSize: 1}
+	if _, err := q.enqueue(cfg, "claude", "m", []byte("a"), time.Now()); err != nil {
+		t.Fatalf("首次入队失败: %v", err)
+	}
+	if _, err := q.enqueue(cfg, "claude", "m", []byte("b"), time.Now()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("队列已满时应返回 ErrQueueFull，实际: %v", err)
+	}
+}
+
+func TestRequestQueueFIFO(t *testing.T) {
+	q := newRequestQueue("")
+	cfg := QueueConfig{Enabled: true, MaxSize: 4}
+	first, _ := q.enqueue(cfg, "claude", "m", []byte("first"), time.Now())
+	second, _ := q.enqueue(cfg, "claude", "m", []byte("second"), time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.wait(ctx, second); err == nil {
+		t.Fatalf("前序请求未出队时后续请求不应恢复")
+	}
+
+	if err := q.wait(context.Background(), first); err != nil {
+		t.Fatalf("队首请求应立即恢复: %v", err)
+	}
+	body, err := q.dequeue(first)
+	if err != nil || string(body) != "first" {
+		t.Fatalf("dequeue = (%q, %v)", body, err)
+	}
+	if err := q.wait(context.Background(), second); err != nil {
+		t.Fatalf("前序请求出队后应恢复: %v", err)
+	}
+}
+
+func TestRequestQueuePlatformsDoNotBlockEachOther(t *testing.T) {
+	q := newRequestQueue("")
+	cfg := QueueConfig{Enabled: true, MaxSize: 4}
+	claude, _ := q.enqueue(cfg, "claude", "m", []byte("claude"), time.Now().Add(time.Hour))
+	codex, _ := q.enqueue(cfg, "codex", "m", []byte("codex"), time.Now())
+	laterClaude, _ := q.enqueue(cfg, "claude", "m", []byte("later"), time.Now())
+
+	if err := q.wait(context.Background(), codex); err != nil {
+		t.Fatalf("恢复时间已到的其他平台请求不应被前序请求阻塞: %v", err)
+	}
+	if _, err := q.dequeue(codex); err != nil {
+		t.Fatalf("dequeue 失败: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.wait(ctx, laterClaude); err == nil {
+		t.Fatalf("同一平台内仍应按入队顺序恢复")
+	}
+	q.dequeue(claude)
+	if err := q.wait(context.Background(), laterClaude); err != nil {
+		t.Fatalf("同一平台的前序请求出队后应恢复: %v", err)
+	}
+}
+
+func TestRequestQueuePersistBodies(t *testing.T) {
+	q := newRequestQueue(t.TempDir())
+	entry, err := q.enqueue(QueueConfig{MaxSize: 1, PersistBodies: true}, "codex", "m", []byte("payload"), time.Now())
+	if err != nil {
+		t.Fatalf("入队失败: %v", err)
+	}
+	if !entry.Persisted || entry.body != nil {
+		t.Fatalf("开启 PersistBodies 后请求体应写入磁盘")
+	}
+	body, err := q.dequeue(entry)
+	if err != nil || string(body) != "payload" {
+		t.Fatalf("dequeue = (%q, %v)", body, err)
+	}
+	if len(q.snapshot()) != 0 {
+		t.Errorf("出队后队列应为空")
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 响应头标记响应来自缓存
+	responseCacheHeader = "X-Code-Switch-Cache"
+
+	defaultResponseCacheTTL        = 5 * time.Minute
+	defaultResponseCacheMaxEntries = 1000
+	defaultResponseCacheMaxBytes   = 32 << 20
+)
+
+// ResponseCacheConfig 控制非流式请求的精确匹配缓存（默认关闭）
+// 同一 provider、模型与请求体（忽略字段顺序与空白）的重复请求直接返回缓存的响应，不再请求上游
+type ResponseCacheConfig struct {
+	Enabled bool `json:"enabled"`
+	// 缓存有效期（秒），默认 300
+	TTLSeconds float64 `json:"ttlSeconds,omitempty"`
+	// 最多缓存的响应数，默认 1000，超出后淘汰最久未使用的响应
+	MaxEntries int `json:"maxEntries,omitempty"`
+	// 缓存占用的最大字节数，默认 32MB
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+func (c ResponseCacheConfig) ttl() time.Duration {
+	if c.TTLSeconds > 0 {
+		return time.Duration(c.TTLSeconds * float64(time.Second))
+	}
+	return defaultResponseCacheTTL
+}
+
+func (c ResponseCacheConfig) maxEntries() int {
+	if c.MaxEntries > 0 {
+		return c.MaxEntries
+	}
+	return defaultResponseCacheMaxEntries
+}
+
+func (c ResponseCacheConfig) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return defaultResponseCacheMaxBytes
+}
+
+// ResponseCacheStats 是响应缓存的运行统计
+type ResponseCacheStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	// 命中缓存节省的费用合计（美元），按原请求的用量计算
+	SavedCost float64 `json:"saved_cost"`
+}
+
+type cachedResponse struct {
+	key         string
+	status      int
+	contentType string
+	body        []byte
+	// 原请求的费用，命中时计入节省的费用
+	cost    float64
+	expires time.Time
+}
+
+// responseCache 是按最近使用淘汰的响应缓存，同时受条目数与字节数限制
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	bytes   int64
+	stats   ResponseCacheStats
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// responseCacheKey 返回请求的缓存 key，字段顺序与空白不同的请求视为相同
+func responseCacheKey(kind string, provider string, model string, endpoint string, body []byte) string {
+	return requestHash(body, kind, provider, model, endpoint)
+}
+
+// requestHash 计算 parts 与请求体的摘要；请求体按 JSON 重新序列化，忽略字段顺序与空白
+func requestHash(body []byte, parts ...string) string {
+	normalized := body
+	var parsed any
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		if data, err := json.Marshal(parsed); err == nil {
+			normalized = data
+		}
+	}
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(normalized)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cacheable 判断请求能否使用缓存：流式请求与客户端要求不使用缓存（Cache-Control: no-cache / no-store）的请求不缓存
+func (c ResponseCacheConfig) cacheable(isStream bool, header http.Header) bool {
+	if !c.Enabled || isStream {
+		return false
+	}
+	control := strings.ToLower(header.Get("Cache-Control"))
+	return !strings.Contains(control, "no-cache") && !strings.Contains(control, "no-store")
+}
+
+func (rc *responseCache) get(key string) (*cachedResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	element, ok := rc.entries[key]
+	if ok {
+		entry := element.Value.(*cachedResponse)
+		if time.Now().Before(entry.expires) {
+			rc.order.MoveToFront(element)
+			rc.stats.Hits++
+			rc.stats.SavedCost += entry.cost
+			return entry, true
+		}
+		rc.removeLocked(element)
+	}
+	rc.stats.Misses++
+	return nil, false
+}
+
+func (rc *responseCache) put(cfg ResponseCacheConfig, entry *cachedResponse) {
+	size := int64(len(entry.body))
+	if size > cfg.maxBytes() {
+		return
+	}
+	entry.expires = time.Now().Add(cfg.ttl())
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if element, ok := rc.entries[entry.key]; ok {
+		rc.removeLocked(element)
+	}
+	rc.entries[entry.key] = rc.order.PushFront(entry)
+	rc.bytes += size
+	for rc.order.Len() > cfg.maxEntries() || rc.bytes > cfg.maxBytes() {
+		rc.removeLocked(rc.order.Back())
+	}
+}
+
+func (rc *responseCache) removeLocked(element *list.Element) {
+	entry := element.Value.(*cachedResponse)
+	rc.order.Remove(element)
+	delete(rc.entries, entry.key)
+	rc.bytes -= int64(len(entry.body))
+}
+
+func (rc *responseCache) snapshot() ResponseCacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	stats := rc.stats
+	stats.Entries = rc.order.Len()
+	stats.Bytes = rc.bytes
+	return stats
+}
+
+// serve 将缓存的响应写回客户端
+func (entry *cachedResponse) serve(c *gin.Context) {
+	c.Header(responseCacheHeader, "hit")
+	c.Data(entry.status, entry.contentType, entry.body)
+}
+
+// cachedResponseCost 返回缓存响应对应的原请求费用；非流式响应的用量未写入日志时从响应体解析
+func cachedResponseCost(kind string, requestLog *ReqeustLog, body []byte, recorded float64, opts ...modelpricing.CostOption) float64 {
+	if recorded > 0 {
+		return recorded
+	}
+	usage := &ReqeustLog{Platform: requestLog.Platform, Model: requestLog.Model, ServiceTier: requestLog.ServiceTier}
+	if kind == "codex" {
+		CodexParseTokenUsageFromResponse(string(body), usage)
+	} else {
+		ClaudeCodeParseTokenUsageFromResponse(string(body), usage)
+	}
+	return recordedCost(usage, opts...).TotalCost
+}
+
+// recordCacheHit 将命中缓存的请求写入请求日志，不产生费用，节省的费用记入 cache_saved_cost
+func recordCacheHit(req *relayRequest, provider Provider, model string, entry *cachedResponse) {
+	if _, err := xdb.New("request_log").Insert(xdb.Record{
+		"platform":         req.kind,
+		"model":            model,
+		"provider":         provider.Name,
+		"http_code":        entry.status,
+		"request_id":       req.id,
+		"cache_hit":        1,
+		"cache_saved_cost": entry.cost,
+		"listener":         req.listener,
+	}); err != nil {
+		fmt.Printf("写入 request_log 失败: %v\n", err)
+	}
+}
+
+// ResponseCacheStats 返回响应缓存的条目数、命中次数与节省的费用
+func (prs *ProviderRelayService) ResponseCacheStats() ResponseCacheStats {
+	return prs.responses.snapshot()
+}
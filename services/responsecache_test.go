@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseCacheKeyNormalizesBody(t *testing.T) {
+	key := responseCacheKey("claude", "p", "claude-sonnet-4-5", "/v1/messages", []byte(`{"model":"claude-sonnet-4-5","max_tokens":10}`))
+	if reordered := responseCacheKey("claude", "p", "claude-sonnet-4-5", "/v1/messages", []byte("{\n  \"max_tokens\": 10,\n  \"model\": \"claude-sonnet-4-5\"\n}")); reordered != key {
+		t.Fatalf("字段顺序与空白不同的请求应使用相同的 key")
+	}
+	if other := responseCacheKey("claude", "q", "claude-sonnet-4-5", "/v1/messages", []byte(`{"model":"claude-sonnet-4-5","max_tokens":10}`)); other == key {
+		t.Fatalf("不同 provider 的请求不应共用缓存")
+	}
+}
+
+func TestResponseCacheLimits(t *testing.T) {
+	cache := newResponseCache()
+	cfg := ResponseCacheConfig{Enabled: true, MaxEntries: 2, MaxBytes: 10}
+	cache.put(cfg, &cachedResponse{key: "a", status: 200, body: []byte("aaa")})
+	cache.put(cfg, &cachedResponse{key: "b", status: 200, body: []byte("bbb")})
+	if _, ok := cache.get("a"); !ok {
+		t.Fatalf("应命中缓存")
+	}
+	// 超出条目数时淘汰最久未使用的 b
+	cache.put(cfg, &cachedResponse{key: "c", status: 200, body: []byte("ccc")})
+	if _, ok := cache.get("b"); ok {
+		t.Fatalf("应淘汰最久未使用的响应")
+	}
+	// 超出字节数时继续淘汰
+	cache.put(cfg, &cachedResponse{key: "d", status: 200, body: []byte("dddddd")})
+	if _, ok := cache.get("a"); ok {
+		t.Fatalf("超出字节数时应淘汰最久未使用的响应")
+	}
+	if stats := cache.snapshot(); stats.Entries != 2 || stats.Bytes != 9 {
+		t.Fatalf("应按字节数淘汰: %+v", stats)
+	}
+	cache.put(cfg, &cachedResponse{key: "huge", status: 200, body: []byte("0123456789abc")})
+	if _, ok := cache.get("huge"); ok {
+		t.Fatalf("超过缓存上限的响应不应缓存")
+	}
+
+	cache.put(ResponseCacheConfig{TTLSeconds: 0.001}, &cachedResponse{key: "expired", status: 200, body: []byte("x"), cost: 1})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.get("expired"); ok {
+		t.Fatalf("过期的响应不应命中")
+	}
+	if stats := cache.snapshot(); stats.Hits != 1 || stats.Misses != 4 || stats.SavedCost != 0 {
+		t.Fatalf("命中统计错误: %+v", stats)
+	}
+}
+
+func TestRelayServesRepeatedRequestsFromCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	hits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"msg_%d","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":1000,"output_tokens":500}}`, hits)
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: upstream.URL, APIKey: "sk-primary-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Cache = ResponseCacheConfig{Enabled: true}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(body string, cacheControl string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	first := post(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`, "")
+	second := post(`{"max_tokens":100, "model":"claude-sonnet-4-5", "messages":[{"role":"user","content":"hi"}]}`, "")
+	if hits != 1 || second.Header().Get(responseCacheHeader) != "hit" || second.Body.String() != first.Body.String() {
+		t.Fatalf("相同的请求应直接返回缓存的响应: hits=%d %q %s", hits, second.Header().Get(responseCacheHeader), second.Body.String())
+	}
+	post(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`, "no-cache")
+	post(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`, "")
+	if hits != 3 {
+		t.Fatalf("不同的请求或 no-cache 请求不应使用缓存: hits=%d", hits)
+	}
+
+	stats, err := NewLogService().StatsSince("claude")
+	if err != nil {
+		t.Fatalf("查询统计失败: %v", err)
+	}
+	cached := relay.ResponseCacheStats()
+	if stats.CacheHits != 1 || stats.CostSavedByCache <= 0 || cached.Hits != 1 || cached.SavedCost != stats.CostSavedByCache {
+		t.Fatalf("应统计命中缓存节省的费用: hits=%d saved=%v cache=%+v", stats.CacheHits, stats.CostSavedByCache, cached)
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetryAttempts = 1
+	defaultRetryBaseDelay   = 500 * time.Millisecond
+	defaultRetryMaxDelay    = 8 * time.Second
+	defaultRetryBudget      = 30 * time.Second
+)
+
+// ErrRetryBudgetExhausted 表示请求的重试总预算已用尽
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryPolicy 描述单个请求的重试策略
+type RetryPolicy struct {
+	MaxRetryAttempts int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	// Budget 限制从收到请求起发起新尝试（含退避等待）的总时长，0 表示不限制
+	// 已经开始的上游请求（例如长时间的流式响应）不会被预算打断
+	Budget time.Duration
+	// Adaptive 为 true 时根据 provider 近期健康情况调整重试次数与退避
+	Adaptive bool
+}
+
+// Policy 将配置转换为重试策略，缺省字段使用默认值
+func (c RetryConfig) Policy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxRetryAttempts: c.MaxRetryAttempts,
+		BaseDelay:        time.Duration(c.BaseDelayMs) * time.Millisecond,
+		MaxDelay:         time.Duration(c.MaxDelayMs) * time.Millisecond,
+		Budget:           time.Duration(c.BudgetSeconds * float64(time.Second)),
+		Adaptive:         c.Adaptive,
+	}
+	if policy.MaxRetryAttempts < 0 {
+		policy.MaxRetryAttempts = 0
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultRetryBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	if policy.Budget < 0 {
+		policy.Budget = 0
+	}
+	return policy
+}
+
+// Backoff 返回第 retry 次重试前的等待时长（指数退避，上游给出 Retry-After 时优先使用）
+func (p RetryPolicy) Backoff(retry int, err error) time.Duration {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > 0 {
+		if upstreamErr.RetryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return upstreamErr.RetryAfter
+	}
+	if retry < 1 {
+		retry = 1
+	}
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// RetryRules 是 provider 自定义的可重试规则，在默认规则之外追加
+type RetryRules struct {
+	StatusCodes  []int
+	BodyPatterns []*regexp.Regexp
+}
+
+// RetryRules 编译 provider 配置的可重试规则（无效的正则在配置校验阶段已被拦截，这里直接忽略）
+func (p Provider) RetryRules() RetryRules {
+	rules := RetryRules{StatusCodes: p.RetryableStatusCodes}
+	for _, pattern := range p.RetryableBodyPatterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			rules.BodyPatterns = append(rules.BodyPatterns, re)
+		}
+	}
+	return rules
+}
+
+// ShouldRetry 判断一次失败是否值得在同一 provider 上重试
+// 网关类错误（502/503/504）与限流提示属于瞬时故障，provider 可通过 rules 追加状态码与响应体正则；
+// 模型过载（529 overloaded_error）单独处理：冷却该模型并降级，不在同一 provider 上重试；
+// 其余状态码视为终态直接降级
+func ShouldRetry(err error, rules RetryRules) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrRetryBudgetExhausted) || isOverloadError(err) {
+		return false
+	}
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		// 网络层错误（连接重置、超时等）
+		return true
+	}
+	switch upstreamErr.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	for _, code := range rules.StatusCodes {
+		if upstreamErr.StatusCode == code {
+			return true
+		}
+	}
+	for _, re := range rules.BodyPatterns {
+		if re.MatchString(upstreamErr.Body) {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(upstreamErr.Body), "rate limit")
+}
+
+// RetryAttempt 记录一次上游尝试
+type RetryAttempt struct {
+	Provider   string        `json:"provider"`
+	Model      string        `json:"model"`
+	KeyHint    string        `json:"key_hint"`
+	StatusCode int           `json:"status_code"`
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// RetryBudgetExhaustedError 在重试预算用尽时返回，附带所有尝试记录
+type RetryBudgetExhaustedError struct {
+	Budget   time.Duration
+	Elapsed  time.Duration
+	Attempts []RetryAttempt
+}
+
+func (e *RetryBudgetExhaustedError) Error() string {
+	return fmt.Sprintf("retry budget exhausted: %d 次尝试耗时 %.1fs，超出预算 %.1fs",
+		len(e.Attempts), e.Elapsed.Seconds(), e.Budget.Seconds())
+}
+
+func (e *RetryBudgetExhaustedError) Is(target error) bool {
+	return target == ErrRetryBudgetExhausted
+}
+
+// retryTracker 跟踪单个请求的所有尝试与预算消耗
+type retryTracker struct {
+	policy   RetryPolicy
+	start    time.Time
+	attempts []RetryAttempt
+}
+
+func newRetryTracker(policy RetryPolicy) *retryTracker {
+	return &retryTracker{policy: policy, start: time.Now()}
+}
+
+func (t *retryTracker) record(attempt RetryAttempt) {
+	t.attempts = append(t.attempts, attempt)
+}
+
+// restartBudget 重新开始计算预算（请求排队恢复后，排队时间不计入重试预算）
+func (t *retryTracker) restartBudget() {
+	t.start = time.Now()
+}
+
+func (t *retryTracker) elapsed() time.Duration {
+	return time.Since(t.start)
+}
+
+func (t *retryTracker) budgetError() *RetryBudgetExhaustedError {
+	attempts := make([]RetryAttempt, len(t.attempts))
+	copy(attempts, t.attempts)
+	return &RetryBudgetExhaustedError{
+		Budget:   t.policy.Budget,
+		Elapsed:  t.elapsed(),
+		Attempts: attempts,
+	}
+}
+
+// checkBudget 在发起新尝试前调用，预算用尽时返回 RetryBudgetExhaustedError
+func (t *retryTracker) checkBudget() error {
+	if t.policy.Budget > 0 && t.elapsed() >= t.policy.Budget {
+		return t.budgetError()
+	}
+	return nil
+}
+
+// allows 判断退避 delay 后是否仍在预算内；不在时应放弃同一 provider 上的重试，
+// 剩余预算留给后续 provider，是否真正耗尽由下一次尝试前的 checkBudget 判断
+func (t *retryTracker) allows(delay time.Duration) bool {
+	return t.policy.Budget <= 0 || t.elapsed()+delay < t.policy.Budget
+}
+
+// wait 执行退避等待，ctx 取消时立即返回
+func (t *retryTracker) wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryEvent 描述重试过程中的一个事件
+type RetryEvent struct {
+	Platform       string
+	RequestedModel string
+	// Attempt 是触发事件的最近一次尝试
+	Attempt RetryAttempt
+	// Attempts 是截至目前该请求的尝试总数
+	Attempts int
+	Err      error
+	// Sampled 为该请求目前的详细日志采样结果（见 LogSamplingConfig），记录追踪信息的集成据此与控制台日志、请求内容保持一致
+	Sampled bool
+}
+
+// RetryHooks 允许集成方观察重试过程（通知、UI 状态、provider 健康记录等）
+// 所有回调均为可选，并在请求 goroutine 中同步调用，应避免长时间阻塞
+type RetryHooks struct {
+	// OnAttempt 在每次上游尝试结束后调用（无论成功与否）
+	OnAttempt func(event RetryEvent)
+	// OnRetry 在确定会在同一 provider 上再次尝试时调用（退避重试或轮换到下一个 Key），delay 为即将等待的时长；
+	// 没有可轮换的 Key、达到重试次数或等待会超出预算时不调用，此时请求降级到下一个 provider
+	OnRetry func(event RetryEvent, delay time.Duration)
+	// OnFailover 在从一个 provider 降级到下一个 provider 时调用
+	OnFailover func(event RetryEvent, from string, to string)
+	// OnGiveUp 在请求最终失败（所有 provider 失败或预算耗尽）时调用
+	OnGiveUp func(event RetryEvent)
+}
+
+// retryHookSet 保存已注册的钩子
+type retryHookSet struct {
+	mu    sync.RWMutex
+	hooks []RetryHooks
+}
+
+func (hs *retryHookSet) add(hooks RetryHooks) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.hooks = append(hs.hooks, hooks)
+}
+
+func (hs *retryHookSet) each(fn func(h RetryHooks)) {
+	hs.mu.RLock()
+	hooks := hs.hooks
+	hs.mu.RUnlock()
+	for _, h := range hooks {
+		func() {
+			// 钩子中的 panic 不应影响请求
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("[ERROR] retry hook panic: %v\n", r)
+				}
+			}()
+			fn(h)
+		}()
+	}
+}
+
+func (hs *retryHookSet) attempt(event RetryEvent) {
+	hs.each(func(h RetryHooks) {
+		if h.OnAttempt != nil {
+			h.OnAttempt(event)
+		}
+	})
+}
+
+func (hs *retryHookSet) retry(event RetryEvent, delay time.Duration) {
+	hs.each(func(h RetryHooks) {
+		if h.OnRetry != nil {
+			h.OnRetry(event, delay)
+		}
+	})
+}
+
+func (hs *retryHookSet) failover(event RetryEvent, from string, to string) {
+	hs.each(func(h RetryHooks) {
+		if h.OnFailover != nil {
+			h.OnFailover(event, from, to)
+		}
+	})
+}
+
+func (hs *retryHookSet) giveUp(event RetryEvent) {
+	hs.each(func(h RetryHooks) {
+		if h.OnGiveUp != nil {
+			h.OnGiveUp(event)
+		}
+	})
+}
+
+// event 基于当前请求状态构造事件
+func (t *retryTracker) event(platform string, requestedModel string, err error) RetryEvent {
+	event := RetryEvent{
+		Platform:       platform,
+		RequestedModel: requestedModel,
+		Attempts:       len(t.attempts),
+		Err:            err,
+	}
+	if len(t.attempts) > 0 {
+		event.Attempt = t.attempts[len(t.attempts)-1]
+	}
+	return event
+}
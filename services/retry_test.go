@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShouldRetry(t *testing.T) {
+	custom := Provider{
+		RetryableStatusCodes:  []int{http.StatusInternalServerError},
+		RetryableBodyPatterns: []string{`"code"\s*:\s*"upstream_busy"`},
+	}.RetryRules()
+
+	tests := []struct {
+		name  string
+		err   error
+		rules RetryRules
+		want  bool
+	}{
+		{"网络错误", errors.New("connection reset"), RetryRules{}, true},
+		{"503", &UpstreamError{StatusCode: http.StatusServiceUnavailable}, RetryRules{}, true},
+		{"400 终态", &UpstreamError{StatusCode: http.StatusBadRequest}, RetryRules{}, false},
+		{"限流提示", &UpstreamError{StatusCode: http.StatusBadRequest, Body: "Rate limit exceeded"}, RetryRules{}, true},
+		{"默认不重试 500", &UpstreamError{StatusCode: http.StatusInternalServerError}, RetryRules{}, false},
+		{"自定义状态码", &UpstreamError{StatusCode: http.StatusInternalServerError}, custom, true},
+		{"自定义响应体", &UpstreamError{StatusCode: http.StatusBadRequest, Body: `{"code": "upstream_busy"}`}, custom, true},
+		{"过载不重试", &UpstreamError{StatusCode: 529, Body: `{"type":"error","error":{"type":"overloaded_error"}}`}, RetryRules{StatusCodes: []int{529}}, false},
+		{"客户端断开", context.Canceled, custom, false},
+		{"预算耗尽", &RetryBudgetExhaustedError{}, custom, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldRetry(tt.err, tt.rules); got != tt.want {
+				t.Errorf("ShouldRetry = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryRulesValidation(t *testing.T) {
+	p := Provider{RetryableStatusCodes: []int{42}, RetryableBodyPatterns: []string{"("}}
+	if errs := p.ValidateConfiguration(); len(errs) != 2 {
+		t.Errorf("无效的状态码与正则都应报错，实际: %v", errs)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryConfig{BaseDelayMs: 100, MaxDelayMs: 1000}.Policy()
+	tests := []struct {
+		name  string
+		retry int
+		err   error
+		want  time.Duration
+	}{
+		{"第 1 次重试", 1, errors.New("connection reset"), 100 * time.Millisecond},
+		{"指数增长", 3, errors.New("connection reset"), 400 * time.Millisecond},
+		{"不超过上限", 10, errors.New("connection reset"), time.Second},
+		{"次数小于 1 按第 1 次计算", 0, nil, 100 * time.Millisecond},
+		{"优先使用 Retry-After", 1, &UpstreamError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 700 * time.Millisecond}, 700 * time.Millisecond},
+		{"Retry-After 不超过上限", 1, &UpstreamError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Minute}, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Backoff(tt.retry, tt.err); got != tt.want {
+				t.Errorf("Backoff = %s，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryTrackerBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    time.Duration
+		elapsed   time.Duration
+		delay     time.Duration
+		exhausted bool
+		allows    bool
+	}{
+		{"不限制预算", 0, time.Hour, time.Hour, false, true},
+		{"预算充足", 10 * time.Second, time.Second, time.Second, false, true},
+		{"等待后超出预算", 10 * time.Second, 8 * time.Second, 5 * time.Second, false, false},
+		{"预算已用尽", 10 * time.Second, 11 * time.Second, 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newRetryTracker(RetryPolicy{Budget: tt.budget})
+			tracker.start = time.Now().Add(-tt.elapsed)
+			tracker.record(RetryAttempt{Provider: "a", StatusCode: http.StatusBadGateway})
+			err := tracker.checkBudget()
+			if got := errors.Is(err, ErrRetryBudgetExhausted); got != tt.exhausted {
+				t.Fatalf("checkBudget = %v，期望耗尽: %v", err, tt.exhausted)
+			}
+			var budgetErr *RetryBudgetExhaustedError
+			if tt.exhausted && (!errors.As(err, &budgetErr) || len(budgetErr.Attempts) != 1 || budgetErr.Budget != tt.budget) {
+				t.Fatalf("预算错误应附带尝试记录: %+v", budgetErr)
+			}
+			if got := tracker.allows(tt.delay); got != tt.allows {
+				t.Fatalf("allows = %v，期望 %v", got, tt.allows)
+			}
+		})
+	}
+}
+
+func TestRetryTrackerWait(t *testing.T) {
+	tracker := newRetryTracker(RetryPolicy{Budget: time.Millisecond})
+	tracker.start = time.Now().Add(-time.Hour)
+	// 预算只在发起新尝试前检查，wait 本身不返回预算错误
+	if err := tracker.wait(context.Background(), 10*time.Millisecond); err != nil {
+		t.Fatalf("wait 应正常等待: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := tracker.wait(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ctx 取消后应返回 context.Canceled: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("ctx 取消后 wait 应立即返回")
+	}
+}
+
+func TestRetryAfterBeyondBudgetFailsOver(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	handler := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			if status != http.StatusOK {
+				w.Header().Set("Retry-After", "5")
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"type":"message","usage":{"input_tokens":1,"output_tokens":1}}`))
+		}
+	}
+	primary := httptest.NewServer(handler("primary", http.StatusServiceUnavailable))
+	defer primary.Close()
+	backup := httptest.NewServer(handler("backup", http.StatusOK))
+	defer backup.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: primary.URL, APIKey: "sk-primary-1234567890", Enabled: true, Level: 1},
+		{ID: 2, Name: "backup", APIURL: backup.URL, APIKey: "sk-backup-1234567890", Enabled: true, Level: 2},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Retry = RetryConfig{MaxRetryAttempts: 2, MaxDelayMs: 10000, BudgetSeconds: 2}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	retries := 0
+	relay.AddRetryHooks(RetryHooks{OnRetry: func(RetryEvent, time.Duration) { retries++ }})
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)))
+	// Retry-After 超出剩余预算时放弃同一 provider 上的重试，而不是结束整个请求
+	if rec.Code != http.StatusOK || strings.Join(hits, ",") != "primary,backup" {
+		t.Fatalf("应降级到下一个 provider: %d %v %s", rec.Code, hits, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || retries != 0 {
+		t.Fatalf("不应等待超出预算的 Retry-After，也不应通知重试: %s %d", elapsed, retries)
+	}
+}
+
+func TestRetryHooksFireOnlyBeforeAnotherAttempt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	pool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, "pool:"+r.Header.Get("X-Api-Key"))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer pool.Close()
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, "flaky")
+		if len(hits) == 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"type":"message","usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer flaky.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "pool", APIURL: pool.URL, APIKey: "sk-pool-a-1234567890", APIKeys: []string{"sk-pool-b-1234567890"}, Enabled: true, Level: 1},
+		{ID: 2, Name: "flaky", APIURL: flaky.URL, APIKey: "sk-flaky-1234567890", Enabled: true, Level: 2},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Retry = RetryConfig{MaxRetryAttempts: 1, BaseDelayMs: 10, BudgetSeconds: 30}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	var retries []string
+	failovers := 0
+	relay.AddRetryHooks(RetryHooks{
+		OnRetry: func(event RetryEvent, delay time.Duration) {
+			retries = append(retries, event.Attempt.Provider+"/"+delay.String())
+		},
+		OnFailover: func(RetryEvent, string, string) { failovers++ },
+	})
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK || len(hits) != 4 {
+		t.Fatalf("应轮换 Key 后降级并在重试后成功: %d %v", rec.Code, hits)
+	}
+	// 第二个 Key 被限流后没有可轮换的 Key，直接降级而不通知重试
+	if got := strings.Join(retries, ","); got != "pool/0s,flaky/10ms" || failovers != 1 {
+		t.Fatalf("OnRetry 只应在确定再次尝试时调用: %q，降级 %d 次", got, failovers)
+	}
+}
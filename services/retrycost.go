@@ -0,0 +1,125 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/tidwall/gjson"
+)
+
+// 中断的流式响应按约 4 个字符 1 个 token 估算已生成的输出
+const estimatedCharsPerToken = 4
+
+// RequestCost 汇总同一个客户端请求所有尝试（含重试与降级）的费用
+type RequestCost struct {
+	RequestID string       `json:"request_id"`
+	Attempts  []ReqeustLog `json:"attempts"`
+	TotalCost float64      `json:"total_cost"`
+	// RetryCost 是未成为最终响应的尝试所消耗的费用
+	RetryCost float64 `json:"retry_cost"`
+}
+
+// RequestCost 返回指定请求 ID 下的所有尝试及其费用
+func (ls *LogService) RequestCost(requestID string) (RequestCost, error) {
+	result := RequestCost{RequestID: requestID, Attempts: []ReqeustLog{}}
+	requestID = strings.TrimSpace(requestID)
+	if requestID == "" {
+		return result, errors.New("request id 不能为空")
+	}
+	records, err := xdb.New("request_log").Selects(
+		xdb.WhereEq("request_id", requestID),
+		xdb.OrderByAsc("attempt"),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return result, nil
+		}
+		return result, err
+	}
+	final := -1
+	markups := loadProviderMarkups()
+	for i, record := range records {
+		entry := requestLogFromRecord(record)
+		ls.decorateCost(&entry, markups)
+		result.Attempts = append(result.Attempts, entry)
+		result.TotalCost += entry.TotalCost
+		if entry.HttpCode >= 200 && entry.HttpCode < 300 && !entry.UsageEstimated {
+			final = i
+		}
+	}
+	for i, entry := range result.Attempts {
+		if i != final {
+			result.RetryCost += entry.TotalCost
+		}
+	}
+	return result, nil
+}
+
+// newRequestID 生成客户端请求 ID，用于关联同一请求的多次尝试
+func newRequestID() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + hex.EncodeToString(suffix)
+}
+
+// streamProgress 记录流式响应的进度，用于估算中断的尝试已消耗的 token
+type streamProgress struct {
+	chars     int
+	completed bool
+	// overloaded 表示上游在流中发送了 overloaded_error 事件
+	overloaded bool
+	// refused 表示模型拒绝回答（claude 的 stop_reason 为 refusal，codex 输出了 refusal 内容）
+	refused bool
+}
+
+// track 累计一个 SSE data 负载中的增量文本，并识别结束事件
+func (p *streamProgress) track(kind string, data string) {
+	if kind == "codex" {
+		switch gjson.Get(data, "type").String() {
+		case "response.completed":
+			p.completed = true
+		case "response.refusal.delta", "response.refusal.done":
+			p.refused = true
+		case "response.output_text.delta", "response.reasoning_summary_text.delta", "response.function_call_arguments.delta":
+			p.chars += len(gjson.Get(data, "delta").String())
+		}
+		return
+	}
+	switch gjson.Get(data, "type").String() {
+	case "message_stop":
+		p.completed = true
+	case "message_delta":
+		if gjson.Get(data, "delta.stop_reason").String() == "refusal" {
+			p.refused = true
+		}
+	case "error":
+		if gjson.Get(data, "error.type").String() == "overloaded_error" {
+			p.overloaded = true
+		}
+	case "content_block_delta":
+		delta := gjson.Get(data, "delta")
+		p.chars += len(delta.Get("text").String()) + len(delta.Get("thinking").String()) + len(delta.Get("partial_json").String())
+	}
+}
+
+// estimateInterruptedUsage 在流式响应未正常结束时补全用量估算
+// 上游通常只在结束事件中给出输出（codex 还包括输入）token 数，中断的尝试否则会记为 0
+func estimateInterruptedUsage(kind string, usage *ReqeustLog, requestSize int64) {
+	progress := usage.progress
+	if !usage.IsStream || progress.completed || progress.chars == 0 {
+		return
+	}
+	if estimated := (progress.chars + estimatedCharsPerToken - 1) / estimatedCharsPerToken; estimated > usage.OutputTokens {
+		usage.OutputTokens = estimated
+		usage.UsageEstimated = true
+	}
+	if usage.InputTokens == 0 && usage.CacheReadTokens == 0 && requestSize > 0 {
+		usage.InputTokens = int(requestSize / estimatedCharsPerToken)
+		usage.UsageEstimated = true
+	}
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+func TestEstimateInterruptedUsage(t *testing.T) {
+	usage := &ReqeustLog{IsStream: true}
+	hook := ReqeustLogHook(nil, "claude", usage)
+	hook([]byte(`data: {"type":"message_start","message":{"usage":{"input_tokens":120,"output_tokens":1}}}`))
+	hook([]byte(`data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"0123456789abcdef"}}`))
+
+	estimateInterruptedUsage("claude", usage, 1000)
+	if !usage.UsageEstimated || usage.OutputTokens != 4 || usage.InputTokens != 120 {
+		t.Fatalf("中断的流应估算输出 token: %+v", usage)
+	}
+
+	completed := &ReqeustLog{IsStream: true}
+	hook = ReqeustLogHook(nil, "codex", completed)
+	hook([]byte(`data: {"type":"response.output_text.delta","delta":"0123456789abcdef"}`))
+	hook([]byte(`data: {"type":"response.completed","response":{"usage":{"input_tokens":50,"output_tokens":2}}}`))
+	estimateInterruptedUsage("codex", completed, 1000)
+	if completed.UsageEstimated || completed.OutputTokens != 2 {
+		t.Errorf("正常结束的流不应估算: %+v", completed)
+	}
+}
+
+func TestRequestCostIncludesRetries(t *testing.T) {
+	initTestDatabase(t)
+	logs := xdb.New("request_log", xdb.WithSaveZero())
+	for attempt, code := range []int{503, 200} {
+		if _, err := logs.Insert(xdb.Record{
+			"platform": "claude", "provider": "p1", "model": "m", "http_code": code,
+			"request_id": "req-1", "attempt": attempt + 1,
+		}); err != nil {
+			t.Fatalf("写入日志失败: %v", err)
+		}
+	}
+	cost, err := NewLogService().RequestCost("req-1")
+	if err != nil || len(cost.Attempts) != 2 || cost.Attempts[0].Attempt != 1 || cost.Attempts[1].HttpCode != 200 {
+		t.Fatalf("RequestCost = (%+v, %v)", cost, err)
+	}
+	if cost.RetryCost > cost.TotalCost {
+		t.Errorf("重试费用不应超过总费用: %+v", cost)
+	}
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+const scorecardMonthLayout = "2006-01"
+
+// 错误分类
+const (
+	errorClassNetwork   = "network"
+	errorClassAuth      = "auth"
+	errorClassRateLimit = "rate_limit"
+	errorClassOverload  = "overloaded"
+	errorClassClient    = "client"
+	errorClassServer    = "server"
+)
+
+// ProviderScorecard 是单个 provider 在一个自然月内的 SLA 汇总
+type ProviderScorecard struct {
+	Platform           string           `json:"platform"`
+	Provider           string           `json:"provider"`
+	TotalRequests      int64            `json:"total_requests"`
+	SuccessfulRequests int64            `json:"successful_requests"`
+	Availability       float64          `json:"availability"`
+	P50LatencySec      float64          `json:"p50_latency_sec"`
+	P95LatencySec      float64          `json:"p95_latency_sec"`
+	MeanTokensPerSec   float64          `json:"mean_tokens_per_sec"`
+	InputTokens        int64            `json:"input_tokens"`
+	OutputTokens       int64            `json:"output_tokens"`
+	CostTotal          float64          `json:"cost_total"`
+	EffectiveCostPer1M float64          `json:"effective_cost_per_1m"`
+	Errors             map[string]int64 `json:"errors"`
+}
+
+// ScorecardReport 汇总某个月所有 provider 的 scorecard
+type ScorecardReport struct {
+	Month       string              `json:"month"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Providers   []ProviderScorecard `json:"providers"`
+}
+
+type scorecardAccumulator struct {
+	card       ProviderScorecard
+	latencies  []float64
+	throughput []float64
+}
+
+// ProviderScorecards 计算指定月份（YYYY-MM，留空为当月）每个 provider 的 scorecard
+// 有效单价按该 provider 全部请求（含失败重试）的费用除以成功请求的 token 数计算
+func (ls *LogService) ProviderScorecards(month string) (ScorecardReport, error) {
+	start, err := parseScorecardMonth(month)
+	if err != nil {
+		return ScorecardReport{}, err
+	}
+	end := start.AddDate(0, 1, 0)
+	report := ScorecardReport{
+		Month:       start.Format(scorecardMonthLayout),
+		GeneratedAt: time.Now(),
+		Providers:   []ProviderScorecard{},
+	}
+
+	records, err := xdb.New("request_log").Selects(
+		// 多取一天，时区差异在下面按 parseCreatedAt 精确过滤
+		xdb.WhereGte("created_at", start.Add(-24*time.Hour).Format(timeLayout)),
+		xdb.WhereLt("created_at", end.Add(24*time.Hour).Format(timeLayout)),
+		xdb.Field(
+			"platform",
+			"provider",
+			"model",
+			"http_code",
+			"input_tokens",
+			"output_tokens",
+			"cache_create_tokens",
+			"cache_read_tokens",
+			"input_audio_tokens",
+			"output_audio_tokens",
+			"input_images",
+			"output_images",
+			"web_search_calls",
+			"code_executions",
+			"queries",
+			"service_tier",
+			"duration_sec",
+			"original_cost",
+			"repriced_cost",
+			"repriced_at",
+			"pricing_version",
+			"created_at",
+		),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return report, nil
+		}
+		return report, err
+	}
+
+	accumulators := map[string]*scorecardAccumulator{}
+	markups := loadProviderMarkups()
+	for _, record := range records {
+		if createdAt, ok := parseCreatedAt(record); ok && (createdAt.Before(start) || !createdAt.Before(end)) {
+			continue
+		}
+		platform := record.GetString("platform")
+		provider := strings.TrimSpace(record.GetString("provider"))
+		if provider == "" {
+			provider = "(unknown)"
+		}
+		key := poolKey(platform, provider)
+		acc := accumulators[key]
+		if acc == nil {
+			acc = &scorecardAccumulator{card: ProviderScorecard{
+				Platform: platform,
+				Provider: provider,
+				Errors:   map[string]int64{},
+			}}
+			accumulators[key] = acc
+		}
+
+		card := &acc.card
+		card.TotalRequests++
+		card.CostTotal += ls.recordCost(record, markups)
+		httpCode := record.GetInt("http_code")
+		if httpCode < 200 || httpCode >= 300 {
+			card.Errors[classifyHTTPError(httpCode)]++
+			continue
+		}
+
+		card.SuccessfulRequests++
+		input := record.GetInt("input_tokens") + record.GetInt("cache_create_tokens") + record.GetInt("cache_read_tokens")
+		output := record.GetInt("output_tokens")
+		card.InputTokens += int64(input)
+		card.OutputTokens += int64(output)
+		duration := record.GetFloat64("duration_sec")
+		if duration > 0 {
+			acc.latencies = append(acc.latencies, duration)
+			if output > 0 {
+				acc.throughput = append(acc.throughput, float64(output)/duration)
+			}
+		}
+	}
+
+	for _, acc := range accumulators {
+		card := acc.card
+		if card.TotalRequests > 0 {
+			card.Availability = float64(card.SuccessfulRequests) / float64(card.TotalRequests)
+		}
+		sort.Float64s(acc.latencies)
+		card.P50LatencySec = percentile(acc.latencies, 0.50)
+		card.P95LatencySec = percentile(acc.latencies, 0.95)
+		card.MeanTokensPerSec = mean(acc.throughput)
+		if tokens := card.InputTokens + card.OutputTokens; tokens > 0 {
+			card.EffectiveCostPer1M = card.CostTotal / float64(tokens) * 1e6
+		}
+		report.Providers = append(report.Providers, card)
+	}
+	sort.Slice(report.Providers, func(i, j int) bool {
+		if report.Providers[i].Platform != report.Providers[j].Platform {
+			return report.Providers[i].Platform < report.Providers[j].Platform
+		}
+		return report.Providers[i].Provider < report.Providers[j].Provider
+	})
+	return report, nil
+}
+
+// recordCost 返回一条日志的费用：优先使用修正后的费用，其次为写入时的费用，都没有时按当前价格计算
+func (ls *LogService) recordCost(record xdb.Record, markups providerMarkups) float64 {
+	if record.GetString("repriced_at") != "" {
+		return record.GetFloat64("repriced_cost")
+	}
+	if cost := record.GetFloat64("original_cost"); cost > 0 {
+		return cost
+	}
+	return ls.calculateCost(record.GetString("pricing_version"), record.GetString("model"), recordUsage(record), markups.forRecord(record)...).TotalCost
+}
+
+// ExportScorecards 将 scorecard 导出为 json 或 html 文件
+func (ls *LogService) ExportScorecards(month string, format string, path string) error {
+	report, err := ls.ProviderScorecards(month)
+	if err != nil {
+		return err
+	}
+	data, err := RenderScorecards(report, format)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// RenderScorecards 将 scorecard 渲染为 json 或 html
+func RenderScorecards(report ScorecardReport, format string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		return json.MarshalIndent(report, "", "  ")
+	case "html":
+		var buf bytes.Buffer
+		if err := scorecardTemplate.Execute(&buf, report); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("不支持的导出格式: %s（可选 json、html）", format)
+	}
+}
+
+func parseScorecardMonth(month string) (time.Time, error) {
+	month = strings.TrimSpace(month)
+	if month == "" {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local), nil
+	}
+	parsed, err := time.ParseInLocation(scorecardMonthLayout, month, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("月份格式应为 YYYY-MM: %s", month)
+	}
+	return parsed, nil
+}
+
+// classifyHTTPError 将失败请求归类，http_code 为 0 表示未收到上游响应
+func classifyHTTPError(code int) string {
+	switch {
+	case code == 0:
+		return errorClassNetwork
+	case code == 401 || code == 403:
+		return errorClassAuth
+	case code == 429:
+		return errorClassRateLimit
+	case code == statusOverloaded:
+		return errorClassOverload
+	case code >= 500:
+		return errorClassServer
+	default:
+		return errorClassClient
+	}
+}
+
+// percentile 返回已排序数据的分位数（最近秩法）
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+var scorecardTemplate = template.Must(template.New("scorecard").Funcs(template.FuncMap{
+	"pct":  func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) },
+	"f2":   func(v float64) string { return fmt.Sprintf("%.2f", v) },
+	"cost": modelpricing.FormatCost,
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>Provider Scorecard {{.Month}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", sans-serif; margin: 32px; color: #1f2933; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #e4e7eb; padding: 8px 10px; text-align: right; }
+th:first-child, td:first-child, th:nth-child(2), td:nth-child(2) { text-align: left; }
+th { background: #f5f7fa; }
+.muted { color: #7b8794; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>Provider Scorecard · {{.Month}}</h1>
+<p class="muted">生成时间 {{.GeneratedAt.Format "2006-01-02 15:04:05"}}</p>
+<table>
+<thead>
+<tr><th>平台</th><th>Provider</th><th>请求数</th><th>可用率</th><th>P50 延迟 (s)</th><th>P95 延迟 (s)</th><th>平均 tokens/s</th><th>费用</th><th>有效单价 /1M tokens</th><th>错误分布</th></tr>
+</thead>
+<tbody>
+{{range .Providers}}<tr>
+<td>{{.Platform}}</td><td>{{.Provider}}</td><td>{{.TotalRequests}}</td><td>{{pct .Availability}}</td>
+<td>{{f2 .P50LatencySec}}</td><td>{{f2 .P95LatencySec}}</td><td>{{f2 .MeanTokensPerSec}}</td>
+<td>{{cost .CostTotal}}</td><td>{{cost .EffectiveCostPer1M}}</td>
+<td>{{range $class, $count := .Errors}}{{$class}}: {{$count}} {{end}}</td>
+</tr>
+{{else}}<tr><td colspan="10">本月没有请求记录</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+func TestProviderScorecards(t *testing.T) {
+	initTestDatabase(t)
+
+	rows := []xdb.Record{
+		{"platform": "claude", "provider": "p1", "model": "m", "http_code": 200, "output_tokens": 100, "input_tokens": 900, "duration_sec": 1.0, "original_cost": 0.01},
+		{"platform": "claude", "provider": "p1", "model": "m", "http_code": 200, "output_tokens": 300, "input_tokens": 700, "duration_sec": 3.0, "original_cost": 0.01},
+		{"platform": "claude", "provider": "p1", "model": "m", "http_code": 503, "duration_sec": 0.5, "original_cost": 0.005},
+		{"platform": "claude", "provider": "p1", "model": "m", "http_code": 429, "duration_sec": 0.1},
+		{"platform": "codex", "provider": "p2", "model": "m", "http_code": 0},
+	}
+	logs := xdb.New("request_log", xdb.WithSaveZero())
+	for _, row := range rows {
+		if _, err := logs.Insert(row); err != nil {
+			t.Fatalf("写入日志失败: %v", err)
+		}
+	}
+
+	report, err := NewLogService().ProviderScorecards("")
+	if err != nil || len(report.Providers) != 2 {
+		t.Fatalf("ProviderScorecards = (%+v, %v)", report, err)
+	}
+	card := report.Providers[0]
+	if card.Provider != "p1" || card.TotalRequests != 4 || card.SuccessfulRequests != 2 || card.Availability != 0.5 {
+		t.Errorf("请求统计不正确: %+v", card)
+	}
+	if card.P95LatencySec != 3.0 || card.MeanTokensPerSec != 100 {
+		t.Errorf("延迟/吞吐不正确: %+v", card)
+	}
+	if card.Errors[errorClassServer] != 1 || card.Errors[errorClassRateLimit] != 1 {
+		t.Errorf("错误分布不正确: %+v", card.Errors)
+	}
+	// 失败请求的费用也计入有效单价
+	if math.Abs(card.EffectiveCostPer1M-12.5) > 1e-9 {
+		t.Errorf("有效单价 = %v, want 12.5", card.EffectiveCostPer1M)
+	}
+	if report.Providers[1].Errors[errorClassNetwork] != 1 {
+		t.Errorf("网络错误未归类: %+v", report.Providers[1])
+	}
+
+	html, err := RenderScorecards(report, "html")
+	if err != nil || !strings.Contains(string(html), "p1") {
+		t.Errorf("RenderScorecards(html) = %v", err)
+	}
+	if _, err := RenderScorecards(report, "xml"); err == nil {
+		t.Errorf("不支持的格式应返回错误")
+	}
+}
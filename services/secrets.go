@@ -0,0 +1,415 @@
+package services
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 保存 Key 的后端，配置中以 <后端>:<名称> 引用，如 keyring:anthropic-main
+const (
+	// 系统钥匙串：macOS Keychain、Windows 凭据管理器或 libsecret（GNOME Keyring / KWallet）
+	SecretBackendKeyring = "keyring"
+	// ~/.code-switch/secrets.json，每个 Key 使用口令加密（PBKDF2 + AES-256-GCM），适合没有钥匙串的服务器
+	SecretBackendFile = "secretfile"
+)
+
+const (
+	// 钥匙串中的服务名
+	keyringService = "code-switch"
+	// 加密文件的口令取自该环境变量
+	secretFilePassphraseEnv = "CODE_SWITCH_SECRETS_PASSPHRASE"
+	secretFileName          = "secrets.json"
+)
+
+var (
+	secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	// 迁移时生成 Key 名称需要替换的字符
+	secretNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// secretStore 是保存 Key 的后端
+type secretStore interface {
+	get(name string) (string, error)
+	set(name string, value string) error
+	delete(name string) error
+}
+
+// errSecretNotFound 表示后端中没有该名称的 Key
+var errSecretNotFound = errors.New("未找到")
+
+// secretKeyring 是系统钥匙串，测试中可替换
+var secretKeyring secretStore = osKeyring{}
+
+// parseSecretRef 解析 keyring:<name> 形式的引用；不是引用时 ok 为 false
+func parseSecretRef(value string) (backend string, name string, ok bool) {
+	backend, name, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found || (backend != SecretBackendKeyring && backend != SecretBackendFile) {
+		return "", "", false
+	}
+	return backend, name, true
+}
+
+func isSecretRef(value string) bool {
+	_, _, ok := parseSecretRef(value)
+	return ok
+}
+
+func validateSecretName(name string) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("Key 名称 %q 无效，只能包含字母、数字、点、下划线与短横线", name)
+	}
+	return nil
+}
+
+func secretStoreFor(backend string) (secretStore, error) {
+	switch backend {
+	case SecretBackendKeyring:
+		return secretKeyring, nil
+	case SecretBackendFile:
+		return newSecretFile()
+	default:
+		return nil, fmt.Errorf("不支持的 Key 存储方式: %s（可选 %s、%s）", backend, SecretBackendKeyring, SecretBackendFile)
+	}
+}
+
+// ResolveSecret 返回 Key 引用对应的值，不是引用时原样返回
+func ResolveSecret(value string) (string, error) {
+	backend, name, ok := parseSecretRef(value)
+	if !ok {
+		return value, nil
+	}
+	if err := validateSecretName(name); err != nil {
+		return "", err
+	}
+	store, err := secretStoreFor(backend)
+	if err != nil {
+		return "", err
+	}
+	secret, err := store.get(name)
+	if err != nil {
+		return "", fmt.Errorf("读取 %s:%s 失败: %w", backend, name, err)
+	}
+	return secret, nil
+}
+
+// SetSecret 将 Key 保存到后端，返回可写入配置的引用
+func SetSecret(backend string, name string, value string) (string, error) {
+	if err := validateSecretName(name); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(value) == "" {
+		return "", errors.New("Key 不能为空")
+	}
+	store, err := secretStoreFor(backend)
+	if err != nil {
+		return "", err
+	}
+	if err := store.set(name, value); err != nil {
+		return "", fmt.Errorf("保存 %s:%s 失败: %w", backend, name, err)
+	}
+	return backend + ":" + name, nil
+}
+
+// DeleteSecret 从后端删除 Key
+func DeleteSecret(backend string, name string) error {
+	if err := validateSecretName(name); err != nil {
+		return err
+	}
+	store, err := secretStoreFor(backend)
+	if err != nil {
+		return err
+	}
+	return store.delete(name)
+}
+
+// validateSecretRefs 检查 Key 引用的格式，不读取后端
+func (p *Provider) validateSecretRefs() []string {
+	var errors []string
+	for _, key := range append([]string{p.APIKey}, p.APIKeys...) {
+		if _, name, ok := parseSecretRef(key); ok {
+			if err := validateSecretName(name); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+	return errors
+}
+
+// osKeyring 通过系统自带的命令行工具或 API 访问钥匙串
+type osKeyring struct{}
+
+func (osKeyring) get(name string) (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w").Output()
+		if err != nil {
+			return "", keyringCommandError(err)
+		}
+		return strings.TrimRight(string(out), "\n"), nil
+	case "linux":
+		out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "account", name).Output()
+		if err != nil || len(out) == 0 {
+			return "", keyringCommandError(err)
+		}
+		return string(out), nil
+	case "windows":
+		return windowsCredentialGet(keyringService + ":" + name)
+	default:
+		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+func (osKeyring) set(name string, value string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		// 通过 security -i 从标准输入传入十六进制编码的 Key，避免出现在进程参数中
+		cmd := exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", keyringService, name, hex.EncodeToString([]byte(value))))
+		return keyringRun(cmd)
+	case "linux":
+		cmd := exec.Command("secret-tool", "store", "--label", keyringService+": "+name, "service", keyringService, "account", name)
+		cmd.Stdin = strings.NewReader(value)
+		return keyringRun(cmd)
+	case "windows":
+		return windowsCredentialSet(keyringService+":"+name, value)
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+func (osKeyring) delete(name string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return keyringRun(exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", name))
+	case "linux":
+		return keyringRun(exec.Command("secret-tool", "clear", "service", keyringService, "account", name))
+	case "windows":
+		return windowsCredentialDelete(keyringService + ":" + name)
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+func keyringRun(cmd *exec.Cmd) error {
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return keyringCommandError(err)
+	}
+	return nil
+}
+
+// keyringCommandError 区分没有安装命令行工具与钥匙串中没有该 Key
+func keyringCommandError(err error) error {
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		return fmt.Errorf("无法访问系统钥匙串（%s 不可用），可改用 %s", execErr.Name, SecretBackendFile)
+	}
+	return errSecretNotFound
+}
+
+// secretFile 是使用口令加密的 Key 文件，每个 Key 单独加密
+type secretFile struct {
+	path       string
+	passphrase string
+}
+
+type secretFileContent struct {
+	Version    int               `json:"version"`
+	Encryption BundleEncryption  `json:"encryption"`
+	Secrets    map[string]string `json:"secrets"`
+}
+
+// secretFileMu 串行化加密文件的读写
+var secretFileMu sync.Mutex
+
+func newSecretFile() (*secretFile, error) {
+	passphrase := os.Getenv(secretFilePassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("使用 %s 需要通过环境变量 %s 提供口令", SecretBackendFile, secretFilePassphraseEnv)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return &secretFile{path: filepath.Join(home, ".code-switch", secretFileName), passphrase: passphrase}, nil
+}
+
+func (f *secretFile) load() (secretFileContent, error) {
+	content := secretFileContent{Version: 1, Secrets: map[string]string{}}
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		encryption, err := newBundleEncryption()
+		content.Encryption = encryption
+		return content, err
+	}
+	if err != nil {
+		return content, err
+	}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return content, fmt.Errorf("解析 %s 失败: %w", f.path, err)
+	}
+	if content.Secrets == nil {
+		content.Secrets = map[string]string{}
+	}
+	return content, nil
+}
+
+func (f *secretFile) get(name string) (string, error) {
+	secretFileMu.Lock()
+	defer secretFileMu.Unlock()
+	content, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	sealed, ok := content.Secrets[name]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	aead, err := bundleCipher(content.Encryption, f.passphrase)
+	if err != nil {
+		return "", err
+	}
+	return openSecret(aead, sealed)
+}
+
+func (f *secretFile) set(name string, value string) error {
+	secretFileMu.Lock()
+	defer secretFileMu.Unlock()
+	content, err := f.load()
+	if err != nil {
+		return err
+	}
+	aead, err := bundleCipher(content.Encryption, f.passphrase)
+	if err != nil {
+		return err
+	}
+	// 确认口令与已保存的 Key 一致，避免同一文件中混用不同口令
+	for existing, sealed := range content.Secrets {
+		if _, err := openSecret(aead, sealed); err != nil {
+			return fmt.Errorf("无法解密已保存的 %s: %w", existing, err)
+		}
+	}
+	sealed, err := sealSecret(aead, value)
+	if err != nil {
+		return err
+	}
+	content.Secrets[name] = sealed
+	return f.save(content)
+}
+
+func (f *secretFile) delete(name string) error {
+	secretFileMu.Lock()
+	defer secretFileMu.Unlock()
+	content, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := content.Secrets[name]; !ok {
+		return errSecretNotFound
+	}
+	delete(content.Secrets, name)
+	return f.save(content)
+}
+
+func (f *secretFile) save(content secretFileContent) error {
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// ListFileSecrets 返回加密文件中保存的 Key 名称（系统钥匙串不支持列出）
+func ListFileSecrets() ([]string, error) {
+	f, err := newSecretFile()
+	if err != nil {
+		return nil, err
+	}
+	secretFileMu.Lock()
+	defer secretFileMu.Unlock()
+	content, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(content.Secrets))
+	for name := range content.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SecretMigration 是一个迁移到后端的 Key
+type SecretMigration struct {
+	Provider string `json:"provider"`
+	Ref      string `json:"ref"`
+}
+
+// MigrateSecrets 将配置中的明文 Key 保存到后端并替换为引用，名称为 <平台>-<provider>（Key 池中的其余 Key 追加序号）
+func (ps *ProviderService) MigrateSecrets(backend string, dryRun bool) ([]SecretMigration, error) {
+	if _, err := secretStoreFor(backend); err != nil {
+		return nil, err
+	}
+	migrated := []SecretMigration{}
+	for _, kind := range bundleKinds {
+		providers, err := ps.LoadProviders(kind)
+		if err != nil {
+			return migrated, fmt.Errorf("加载 %s provider 失败: %w", kind, err)
+		}
+		changed := false
+		for i := range providers {
+			p := &providers[i]
+			base := kind + "-" + strings.Trim(secretNameUnsafe.ReplaceAllString(p.Name, "-"), "-._")
+			if base == kind+"-" {
+				base = fmt.Sprintf("%s-%d", kind, p.ID)
+			}
+			migrate := func(value *string, name string) error {
+				if strings.TrimSpace(*value) == "" || isSecretRef(*value) || isSecretPlaceholder(*value) {
+					return nil
+				}
+				ref := backend + ":" + name
+				if !dryRun {
+					if _, err := SetSecret(backend, name, *value); err != nil {
+						return err
+					}
+				}
+				*value, changed = ref, true
+				migrated = append(migrated, SecretMigration{Provider: kind + "/" + p.Name, Ref: ref})
+				return nil
+			}
+			if err := migrate(&p.APIKey, base); err != nil {
+				return migrated, err
+			}
+			for j := range p.APIKeys {
+				if err := migrate(&p.APIKeys[j], fmt.Sprintf("%s-%d", base, j+2)); err != nil {
+					return migrated, err
+				}
+			}
+		}
+		if changed && !dryRun {
+			if err := ps.SaveProviders(kind, providers); err != nil {
+				return migrated, fmt.Errorf("保存 %s provider 失败: %w", kind, err)
+			}
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package services
+
+import "errors"
+
+var errWindowsCredentialUnsupported = errors.New("Windows 凭据管理器仅在 Windows 上可用")
+
+func windowsCredentialGet(target string) (string, error) {
+	return "", errWindowsCredentialUnsupported
+}
+
+func windowsCredentialSet(target string, value string) error {
+	return errWindowsCredentialUnsupported
+}
+
+func windowsCredentialDelete(target string) error {
+	return errWindowsCredentialUnsupported
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryKeyring 在测试中代替系统钥匙串
+type memoryKeyring map[string]string
+
+func (m memoryKeyring) get(name string) (string, error) {
+	value, ok := m[name]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return value, nil
+}
+
+func (m memoryKeyring) set(name string, value string) error {
+	m[name] = value
+	return nil
+}
+
+func (m memoryKeyring) delete(name string) error {
+	delete(m, name)
+	return nil
+}
+
+func useMemoryKeyring(t *testing.T) memoryKeyring {
+	t.Helper()
+	keyring := memoryKeyring{}
+	previous := secretKeyring
+	secretKeyring = keyring
+	t.Cleanup(func() { secretKeyring = previous })
+	return keyring
+}
+
+func TestSecretFileEncryptsKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(secretFilePassphraseEnv, "")
+	if _, err := SetSecret(SecretBackendFile, "anthropic-main", "sk-ant-1234567890"); err == nil {
+		t.Fatalf("未提供口令时应报错")
+	}
+	t.Setenv(secretFilePassphraseEnv, "correct horse")
+	ref, err := SetSecret(SecretBackendFile, "anthropic-main", "sk-ant-1234567890")
+	if err != nil || ref != "secretfile:anthropic-main" {
+		t.Fatalf("保存失败: %q %v", ref, err)
+	}
+	f, _ := newSecretFile()
+	data, _ := os.ReadFile(f.path)
+	if strings.Contains(string(data), "sk-ant") {
+		t.Fatalf("文件中不应包含明文 Key: %s", data)
+	}
+	if value, err := ResolveSecret(ref); err != nil || value != "sk-ant-1234567890" {
+		t.Fatalf("读取失败: %q %v", value, err)
+	}
+	if names, err := ListFileSecrets(); err != nil || strings.Join(names, ",") != "anthropic-main" {
+		t.Fatalf("列出失败: %v %v", names, err)
+	}
+
+	t.Setenv(secretFilePassphraseEnv, "wrong horse")
+	if _, err := ResolveSecret(ref); err == nil {
+		t.Fatalf("口令错误时应读取失败")
+	}
+	if _, err := SetSecret(SecretBackendFile, "other", "sk-other-1234567890"); err == nil {
+		t.Fatalf("不应使用不同的口令写入同一文件")
+	}
+	if _, err := ResolveSecret("keyring:bad name"); err == nil {
+		t.Fatalf("名称无效时应报错")
+	}
+	if value, _ := ResolveSecret("sk-plain-1234567890"); value != "sk-plain-1234567890" {
+		t.Fatalf("不是引用时应原样返回: %q", value)
+	}
+}
+
+func TestMigrateSecretsAndResolveInRelay(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	keyring := useMemoryKeyring(t)
+	var authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "Team Relay", APIURL: upstream.URL, APIKey: "sk-team-1234567890", APIKeys: []string{"sk-team-backup-1234567890"}, Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	if migrated, err := ps.MigrateSecrets(SecretBackendKeyring, true); err != nil || len(migrated) != 2 || len(keyring) != 0 {
+		t.Fatalf("dry-run 不应写入钥匙串: %+v %v", migrated, err)
+	}
+	migrated, err := ps.MigrateSecrets(SecretBackendKeyring, false)
+	if err != nil || len(migrated) != 2 || migrated[0].Ref != "keyring:claude-Team-Relay" || migrated[1].Ref != "keyring:claude-Team-Relay-2" {
+		t.Fatalf("迁移失败: %+v %v", migrated, err)
+	}
+	path, _ := providerFilePath("claude")
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "sk-team") {
+		t.Fatalf("配置中不应再包含明文 Key: %s", data)
+	}
+
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || authorization != "Bearer sk-team-1234567890" {
+		t.Fatalf("应使用钥匙串中的 Key 请求上游: %d %q", rec.Code, authorization)
+	}
+
+	delete(keyring, "claude-Team-Relay")
+	delete(keyring, "claude-Team-Relay-2")
+	report := ValidateConfig(ConfigValidateOptions{})
+	if _, ok := findIssue(report, "读取 keyring:claude-Team-Relay 失败"); !ok {
+		t.Fatalf("config validate 应报告无法读取的 Key: %+v", report.Issues)
+	}
+}
@@ -0,0 +1,98 @@
+//go:build windows
+
+package services
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// 通过 advapi32 的 Cred* 接口读写 Windows 凭据管理器中的普通凭据
+var (
+	advapi32        = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// winCredential 对应 CREDENTIALW
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func windowsCredentialGet(target string) (string, error) {
+	targetPtr, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return "", err
+	}
+	var cred *winCredential
+	ok, _, callErr := procCredReadW.Call(uintptr(unsafe.Pointer(targetPtr)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		if errors.Is(callErr, errorNotFound) {
+			return "", errSecretNotFound
+		}
+		return "", callErr
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func windowsCredentialSet(target string, value string) error {
+	targetPtr, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return err
+	}
+	userPtr, err := syscall.UTF16PtrFromString(keyringService)
+	if err != nil {
+		return err
+	}
+	blob := []byte(value)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         targetPtr,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           userPtr,
+	}
+	if ok, _, callErr := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ok == 0 {
+		return callErr
+	}
+	return nil
+}
+
+func windowsCredentialDelete(target string) error {
+	targetPtr, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return err
+	}
+	if ok, _, callErr := procCredDeleteW.Call(uintptr(unsafe.Pointer(targetPtr)), credTypeGeneric, 0); ok == 0 {
+		if errors.Is(callErr, errorNotFound) {
+			return errSecretNotFound
+		}
+		return callErr
+	}
+	return nil
+}
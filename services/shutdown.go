@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	// 排空超时后中断剩余请求，再等待它们写入请求日志的时间
+	abortGracePeriod = 5 * time.Second
+)
+
+// ErrRelayShuttingDown 表示请求因 relay 退出而被中断
+var ErrRelayShuttingDown = errors.New("relay is shutting down")
+
+// ShutdownConfig 控制退出（SIGTERM 或关闭应用）时如何处理进行中的请求
+type ShutdownConfig struct {
+	// 停止接受新请求后，等待进行中的请求（含流式响应）完成的最长时间（秒），默认 30；超时后中断剩余请求
+	DrainSeconds float64 `json:"drainSeconds"`
+}
+
+func (cfg ShutdownConfig) validate() []string {
+	if cfg.DrainSeconds < 0 {
+		return []string{"drainSeconds 不能为负数"}
+	}
+	return nil
+}
+
+func (cfg ShutdownConfig) drainTimeout() time.Duration {
+	if cfg.DrainSeconds <= 0 {
+		return defaultDrainTimeout
+	}
+	return time.Duration(cfg.DrainSeconds * float64(time.Second))
+}
+
+// drain 关闭所有监听并等待进行中的请求完成，每个请求结束时已写入请求日志；
+// 超过 timeout 仍未结束的请求被中断（已转发部分的用量按估算记录），最多再等待 abortGracePeriod 后关闭连接
+func (prs *ProviderRelayService) drain(timeout time.Duration) error {
+	servers := prs.listenerServers
+	if prs.server != nil {
+		servers = append([]*http.Server{prs.server}, servers...)
+	}
+	prs.listenerServers = nil
+	if len(servers) == 0 {
+		return nil
+	}
+	if n := prs.inflight.count(); n > 0 {
+		fmt.Printf("[INFO] 停止接受新请求，等待 %d 个进行中的请求完成（最多 %s）\n", n, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() == nil {
+		return errors.Join(errs...)
+	}
+
+	aborted := prs.inflight.cancelAll(ErrRelayShuttingDown)
+	fmt.Printf("[WARN] %s 内仍有 %d 个请求未完成，已中断\n", timeout, aborted)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), abortGracePeriod)
+	defer waitCancel()
+	if !prs.inflight.waitIdle(waitCtx) {
+		fmt.Printf("[WARN] 中断后仍有 %d 个请求未结束，这些请求可能没有写入请求日志\n", prs.inflight.count())
+	}
+	for _, server := range servers {
+		server.Close()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+)
+
+func TestStopDrainsInflightStreams(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		select {
+		case <-release:
+			fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":5}}\n\n")
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "primary", APIURL: upstream.URL, APIKey: "sk-primary-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Shutdown.DrainSeconds = 5
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+
+	// start 启动 relay 并发送一个流式请求，上游开始响应后返回
+	start := func() (*ProviderRelayService, string, chan string) {
+		t.Helper()
+		relay := NewProviderRelayService(ps, rcs, "")
+		router := gin.New()
+		relay.registerRoutes(router)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("监听失败: %v", err)
+		}
+		relay.server = &http.Server{Handler: router}
+		go relay.server.Serve(ln)
+		url := "http://" + ln.Addr().String() + "/v1/messages"
+		bodies := make(chan string, 1)
+		go func() {
+			resp, err := http.Post(url, "application/json", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+			if err != nil {
+				bodies <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			bodies <- string(data)
+		}()
+		<-started
+		return relay, url, bodies
+	}
+	logCount := func() int {
+		t.Helper()
+		records, err := xdb.New("request_log").Selects()
+		if err != nil {
+			t.Fatalf("读取请求日志失败: %v", err)
+		}
+		return len(records)
+	}
+
+	relay, url, bodies := start()
+	stopped := make(chan error, 1)
+	go func() { stopped <- relay.Stop() }()
+	// 排空期间不再接受新请求
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(strings.Replace(url, "/v1/messages", "/metrics", 1))
+		if err != nil {
+			break
+		}
+		resp.Body.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("Stop 后仍接受新请求")
+		}
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("进行中的流式响应结束前 Stop 不应返回: %v", err)
+	default:
+	}
+	release <- struct{}{}
+	if err := <-stopped; err != nil {
+		t.Fatalf("Stop 失败: %v", err)
+	}
+	if body := <-bodies; !strings.Contains(body, "message_delta") {
+		t.Fatalf("流式响应应完整转发: %q", body)
+	}
+	if n := logCount(); n != 1 {
+		t.Fatalf("Stop 返回前应写入请求日志: %d", n)
+	}
+
+	// 超过排空时间的流被中断，用量仍写入请求日志
+	cfg.Shutdown.DrainSeconds = 0.2
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay, _, bodies = start()
+	begin := time.Now()
+	if err := relay.Stop(); err != nil {
+		t.Fatalf("Stop 失败: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 3*time.Second {
+		t.Fatalf("排空超时后应中断剩余请求: %s", elapsed)
+	}
+	if body := <-bodies; strings.Contains(body, "message_delta") {
+		t.Fatalf("被中断的流不应包含后续内容: %q", body)
+	}
+	if n := logCount(); n != 2 {
+		t.Fatalf("被中断的请求应写入请求日志: %d", n)
+	}
+}
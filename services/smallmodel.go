@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 未配置 match 时视为小模型的请求：Claude Code 的摘要、标题生成等后台任务使用 haiku
+var defaultSmallModelMatches = []string{"*haiku*"}
+
+// SmallModelConfig 将小模型（后台任务）请求固定路由到指定的低价 provider 或本地模型，与主模型的路由无关
+type SmallModelConfig struct {
+	// 匹配小模型请求的规则（与 modelRewrites 的 match 相同），默认 *haiku*
+	Match []string `json:"match,omitempty"`
+	// 按顺序尝试的 provider，均不可用时按常规路由
+	Providers []string `json:"providers"`
+	// 在这些 provider 上使用的模型（如本地的 qwen2.5-coder:7b），留空时使用请求的模型（仍会应用 provider 的模型映射）
+	Model string `json:"model,omitempty"`
+}
+
+// smallModelChain 在请求的模型为小模型时返回由指定 provider 组成的降级链
+func (c RoutingConfig) smallModelChain(model string) *FallbackChain {
+	rule := c.SmallModel
+	if rule == nil || model == "" || len(rule.Providers) == 0 {
+		return nil
+	}
+	matches := rule.Match
+	if len(matches) == 0 {
+		matches = defaultSmallModelMatches
+	}
+	for _, match := range matches {
+		if _, ok := (ModelRewrite{Match: match, Target: model}).rewrite(strings.ToLower(model)); !ok {
+			continue
+		}
+		chain := &FallbackChain{Match: match}
+		for _, provider := range rule.Providers {
+			chain.Hops = append(chain.Hops, FallbackHop{Provider: provider, Model: strings.TrimSpace(rule.Model)})
+		}
+		return chain
+	}
+	return nil
+}
+
+// routeSmallModel 返回小模型请求在指定 provider 上的尝试顺序，指定的 provider 均不可用时返回 nil
+func (prs *ProviderRelayService) routeSmallModel(kind string, requestedModel string, chain *FallbackChain, providers []Provider, client ClientConfig) ([]Provider, *routeChain) {
+	active, route := prs.chainProviders(kind, requestedModel, chain, providers, client)
+	if len(active) == 0 {
+		fmt.Printf("[WARN] 小模型请求 %s 指定的 provider 均不可用，按常规路由\n", requestedModel)
+		return nil, nil
+	}
+	fmt.Printf("[INFO] 小模型请求 %s 路由到指定的 provider\n", requestedModel)
+	return active, route
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestSmallModelChain(t *testing.T) {
+	cfg := RoutingConfig{SmallModel: &SmallModelConfig{Providers: []string{"cheap", "ollama"}, Model: "glm-4.5-air"}}
+	chain := cfg.smallModelChain("claude-3-5-Haiku-20241022")
+	if chain == nil || len(chain.Hops) != 2 || chain.Hops[0].Provider != "cheap" || chain.Hops[1].Model != "glm-4.5-air" {
+		t.Fatalf("haiku 请求应使用指定的 provider: %+v", chain)
+	}
+	if chain := cfg.smallModelChain("claude-sonnet-4-5"); chain != nil {
+		t.Fatalf("主模型不应匹配: %+v", chain)
+	}
+	cfg.SmallModel.Match = []string{"gpt-5-nano", "*-mini"}
+	if cfg.smallModelChain("gpt-5-mini") == nil || cfg.smallModelChain("claude-haiku-4-5") != nil {
+		t.Fatalf("应按配置的 match 匹配小模型")
+	}
+	if (RoutingConfig{SmallModel: &SmallModelConfig{}}).smallModelChain("claude-haiku-4-5") != nil {
+		t.Fatalf("未指定 provider 时不应生效")
+	}
+}
+
+func TestRelayRoutesSmallModelToDesignatedProvider(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			hits = append(hits, name+":"+gjson.GetBytes(body, "model").String())
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":10,"output_tokens":5}}`)
+		}
+	}
+	mainServer := httptest.NewServer(handler("main"))
+	defer mainServer.Close()
+	local := httptest.NewServer(handler("ollama"))
+	defer local.Close()
+
+	ps := NewProviderService()
+	ollama := Provider{ID: 2, Name: "ollama", APIURL: local.URL, Enabled: true, Level: 5, Local: &LocalConfig{Server: LocalServerOllama},
+		ModelRewrites: []ModelRewrite{{Match: "claude-*", Target: "qwen2.5-coder:7b"}}}
+	mainProvider := Provider{ID: 1, Name: "main", APIURL: mainServer.URL, APIKey: "sk-main-1234567890", Enabled: true, Level: 1}
+	if err := ps.SaveProviders("claude", []Provider{mainProvider, ollama}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Routing.SmallModel = &SmallModelConfig{Providers: []string{"ollama"}}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(model string) {
+		hits = nil
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"`+model+`","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	post("claude-haiku-4-5-20251001")
+	if strings.Join(hits, ",") != "ollama:qwen2.5-coder:7b" {
+		t.Fatalf("haiku 请求应路由到指定的本地 provider: %v", hits)
+	}
+	post("claude-sonnet-4-5")
+	if strings.Join(hits, ",") != "main:claude-sonnet-4-5" {
+		t.Fatalf("主模型应按常规路由: %v", hits)
+	}
+
+	// 指定的 provider 不可用时按常规路由
+	ollama.Enabled = false
+	if err := ps.SaveProviders("claude", []Provider{mainProvider, ollama}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	post("claude-haiku-4-5-20251001")
+	if strings.Join(hits, ",") != "main:claude-haiku-4-5-20251001" {
+		t.Fatalf("指定的 provider 不可用时应按常规路由: %v", hits)
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 客户端可通过该请求头指定会话标识，优先于从请求体推断
+	defaultSessionHeader = "X-Code-Switch-Session"
+	// 会话固定的有效期，自最后一次成功请求起算
+	defaultStickySessionTTL = time.Hour
+	// 固定记录超过该数量时清理过期的记录
+	stickySessionSweepSize = 1024
+)
+
+// stickySessionKey 返回用于固定 provider 的会话标识，未开启会话固定时返回 ""：
+// 依次使用客户端请求头、请求中的会话标识（metadata.user_id、session_id、prompt_cache_key），
+// 都没有时使用系统提示与第一条消息的摘要，同一对话的后续请求摘要不变
+func (c RoutingConfig) stickySessionKey(kind string, body []byte, header http.Header) string {
+	if !c.StickySessions {
+		return ""
+	}
+	if session := strings.TrimSpace(header.Get(c.sessionHeader())); session != "" {
+		return "header:" + session
+	}
+	if session := extractSessionID(kind, body, header); session != "" {
+		return "session:" + session
+	}
+	systemField, messagesField := "system", "messages"
+	if kind == "codex" {
+		systemField, messagesField = "instructions", "input"
+	}
+	system := gjson.GetBytes(body, systemField)
+	first := gjson.GetBytes(body, messagesField+".0")
+	if !system.Exists() && !first.Exists() {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(system.Raw + "\n" + first.Raw))
+	return "prompt:" + hex.EncodeToString(sum[:8])
+}
+
+func (c RoutingConfig) sessionHeader() string {
+	if header := strings.TrimSpace(c.SessionHeader); header != "" {
+		return header
+	}
+	return defaultSessionHeader
+}
+
+func (c RoutingConfig) stickySessionTTL() time.Duration {
+	if c.StickySessionTTLMinutes <= 0 {
+		return defaultStickySessionTTL
+	}
+	return time.Duration(c.StickySessionTTLMinutes * float64(time.Minute))
+}
+
+// stickySession 是会话当前固定的 provider（模型档位展开时同时记录选中的模型）
+type stickySession struct {
+	provider    string
+	routedModel string
+	expiresAt   time.Time
+}
+
+// stickySessionTracker 记录每个会话最后一次成功使用的 provider，key 为 poolKey(platform, 会话标识)
+type stickySessionTracker struct {
+	mu       sync.Mutex
+	sessions map[string]stickySession
+}
+
+func newStickySessionTracker() *stickySessionTracker {
+	return &stickySessionTracker{sessions: make(map[string]stickySession)}
+}
+
+// bind 将会话固定到成功处理请求的 provider，并刷新有效期
+func (st *stickySessionTracker) bind(kind string, session string, provider Provider, ttl time.Duration) {
+	now := time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.sessions) >= stickySessionSweepSize {
+		for key, entry := range st.sessions {
+			if now.After(entry.expiresAt) {
+				delete(st.sessions, key)
+			}
+		}
+	}
+	st.sessions[poolKey(kind, session)] = stickySession{provider: provider.Name, routedModel: provider.routedModel, expiresAt: now.Add(ttl)}
+}
+
+func (st *stickySessionTracker) get(kind string, session string) (stickySession, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	entry, ok := st.sessions[poolKey(kind, session)]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return stickySession{}, false
+	}
+	return entry, true
+}
+
+// pinSession 将会话固定的 provider 移到最前面；该 provider 已不可用（被过滤、近期不健康）时保持负载均衡的顺序，
+// 请求成功后会话改为固定到实际处理的 provider
+func (prs *ProviderRelayService) pinSession(kind string, session string, ordered []Provider) []Provider {
+	if session == "" {
+		return ordered
+	}
+	pinned, ok := prs.sessions.get(kind, session)
+	if !ok {
+		return ordered
+	}
+	for i, provider := range ordered {
+		if provider.Name != pinned.provider || provider.routedModel != pinned.routedModel {
+			continue
+		}
+		if health := prs.health.health(kind, provider.Name); health.unhealthy() {
+			fmt.Printf("[INFO] 会话固定的 provider %s 近期不健康，重新选择\n", provider.Name)
+			return ordered
+		}
+		if i > 0 {
+			fmt.Printf("[INFO] 会话固定使用 provider %s\n", provider.Name)
+			result := make([]Provider, 0, len(ordered))
+			result = append(result, provider)
+			result = append(result, ordered[:i]...)
+			return append(result, ordered[i+1:]...)
+		}
+		return ordered
+	}
+	return ordered
+}
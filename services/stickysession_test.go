@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStickySessionKey(t *testing.T) {
+	cfg := RoutingConfig{StickySessions: true}
+	header := http.Header{}
+	first := []byte(`{"system":"You are Claude Code.","messages":[{"role":"user","content":"fix the bug"}]}`)
+	next := []byte(`{"system":"You are Claude Code.","messages":[{"role":"user","content":"fix the bug"},{"role":"assistant","content":"done"},{"role":"user","content":"thanks"}]}`)
+	other := []byte(`{"system":"You are Claude Code.","messages":[{"role":"user","content":"write tests"}]}`)
+
+	if key := (RoutingConfig{}).stickySessionKey("claude", first, header); key != "" {
+		t.Fatalf("未开启时不应返回会话标识: %q", key)
+	}
+	key := cfg.stickySessionKey("claude", first, header)
+	if !strings.HasPrefix(key, "prompt:") || key != cfg.stickySessionKey("claude", next, header) || key == cfg.stickySessionKey("claude", other, header) {
+		t.Fatalf("同一对话的摘要应保持不变，不同对话应不同: %q", key)
+	}
+	withUser := []byte(`{"metadata":{"user_id":"user_abc_account__session_1234"},"messages":[{"role":"user","content":"hi"}]}`)
+	if key := cfg.stickySessionKey("claude", withUser, header); key != "session:1234" {
+		t.Fatalf("应使用 metadata.user_id 中的会话: %q", key)
+	}
+	header.Set(defaultSessionHeader, "conv-42")
+	if key := cfg.stickySessionKey("claude", withUser, header); key != "header:conv-42" {
+		t.Fatalf("客户端请求头应优先: %q", key)
+	}
+	if key := cfg.stickySessionKey("codex", []byte(`{}`), http.Header{}); key != "" {
+		t.Fatalf("无法识别会话时应返回空: %q", key)
+	}
+}
+
+func TestRelayPinsSessionToProvider(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var hits []string
+	failing := map[string]bool{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.Header().Set("Content-Type", "application/json")
+			if failing[name] {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"error":"boom"}`)
+				return
+			}
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":10,"output_tokens":5}}`)
+		}
+	}
+	alpha := httptest.NewServer(handler("alpha"))
+	defer alpha.Close()
+	beta := httptest.NewServer(handler("beta"))
+	defer beta.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "alpha", APIURL: alpha.URL, APIKey: "sk-alpha-1234567890", Enabled: true, Weight: 1},
+		{ID: 2, Name: "beta", APIURL: beta.URL, APIKey: "sk-beta-1234567890", Enabled: true, Weight: 1},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Retry.MaxRetryAttempts = 0
+	cfg.Routing.StickySessions = true
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	post := func(session string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set(defaultSessionHeader, session)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	post("conv-1")
+	pinned := hits[0]
+	for i := 0; i < 20; i++ {
+		post("conv-1")
+	}
+	for _, hit := range hits {
+		if hit != pinned {
+			t.Fatalf("同一会话应始终使用 %s: %v", pinned, hits)
+		}
+	}
+
+	// 固定的 provider 失败后降级，会话改为固定到实际处理的 provider
+	other := map[string]string{"alpha": "beta", "beta": "alpha"}[pinned]
+	failing[pinned] = true
+	hits = nil
+	post("conv-1")
+	if strings.Join(hits, ",") != pinned+","+other {
+		t.Fatalf("固定的 provider 失败后应降级: %v", hits)
+	}
+	failing[pinned] = false
+	hits = nil
+	for i := 0; i < 10; i++ {
+		post("conv-1")
+	}
+	for _, hit := range hits {
+		if hit != other {
+			t.Fatalf("降级后会话应固定到 %s: %v", other, hits)
+		}
+	}
+}
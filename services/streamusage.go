@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Chat Completions 接口（codex 配置 wire_api = "chat" 时使用）
+const chatCompletionsEndpoint = "/chat/completions"
+
+// anthropicUsageFields 是 Anthropic 流式用量中按累计值报告的字段：
+// message_start 的 message.usage 给出初始值，message_delta 的 usage 为截至当前的累计值，出现的字段覆盖之前的值
+var anthropicUsageFields = []struct {
+	name  string
+	field func(*ReqeustLog) *int
+}{
+	{"input_tokens", func(l *ReqeustLog) *int { return &l.InputTokens }},
+	{"output_tokens", func(l *ReqeustLog) *int { return &l.OutputTokens }},
+	{"cache_creation_input_tokens", func(l *ReqeustLog) *int { return &l.CacheCreateTokens }},
+	{"cache_read_input_tokens", func(l *ReqeustLog) *int { return &l.CacheReadTokens }},
+	{"server_tool_use.web_search_requests", func(l *ReqeustLog) *int { return &l.WebSearchCalls }},
+}
+
+// recordAnthropicStreamUsage 记录 message_start 或 message_delta 中的用量
+func recordAnthropicStreamUsage(usage gjson.Result, log *ReqeustLog) {
+	for _, entry := range anthropicUsageFields {
+		if value := usage.Get(entry.name); value.Exists() {
+			*entry.field(log) = int(value.Int())
+		}
+	}
+}
+
+// recordChatCompletionUsage 记录 Chat Completions 最后一个 chunk 中的用量（choices 为空，需要 stream_options.include_usage），
+// 与 Responses API 一致，输入 token 包含缓存命中的部分
+func recordChatCompletionUsage(data string, log *ReqeustLog) {
+	if gjson.Get(data, "object").String() != "chat.completion.chunk" {
+		return
+	}
+	usage := gjson.Get(data, "usage")
+	if !usage.IsObject() {
+		return
+	}
+	log.InputTokens = int(usage.Get("prompt_tokens").Int())
+	log.OutputTokens = int(usage.Get("completion_tokens").Int())
+	log.CacheReadTokens = int(usage.Get("prompt_tokens_details.cached_tokens").Int())
+	log.ReasoningTokens = int(usage.Get("completion_tokens_details.reasoning_tokens").Int())
+	log.InputAudioTokens = int(usage.Get("prompt_tokens_details.audio_tokens").Int())
+	log.OutputAudioTokens = int(usage.Get("completion_tokens_details.audio_tokens").Int())
+}
+
+// needsStreamUsage 判断流式的 Chat Completions 请求是否需要要求上游在最后一个 chunk 中报告用量
+func needsStreamUsage(endpoint string, isStream bool, body []byte) bool {
+	return endpoint == chatCompletionsEndpoint && isStream && !gjson.GetBytes(body, "stream_options.include_usage").Bool()
+}
+
+// includeStreamUsage 为请求体设置 stream_options.include_usage，否则流式响应不包含用量
+func includeStreamUsage(body []byte) ([]byte, error) {
+	return sjson.SetBytes(body, "stream_options.include_usage", true)
+}
+
+// relayStream 将上游的流式响应逐行转发给客户端，每行经过钩子处理后立即 flush，空行（SSE 事件分隔）直接写出。
+// 与 xrequest 的 ToHttpResponseWriter 不同，不会先预读 1KB 判断格式，较短的首个事件不会等到后续数据到达才发出，
+// 上游未使用 text/event-stream 时也按流转发而不缓冲整个响应体
+func relayStream(w http.ResponseWriter, resp *http.Response, hooks ...xrequest.ResponseHook) (int64, error) {
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+
+	reader := bufio.NewReader(resp.Body)
+	var written int64
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			out := line
+			if trimmed := bytes.TrimRight(line, "\n"); len(trimmed) > 0 {
+				flush := true
+				for _, hook := range hooks {
+					flush, trimmed = hook(trimmed)
+				}
+				out = nil
+				if flush {
+					out = trimmed
+					if bytes.HasSuffix(line, []byte("\n")) {
+						out = append(out, '\n')
+					}
+				}
+			}
+			if len(out) > 0 {
+				n, err := w.Write(out)
+				written += int64(n)
+				if err != nil {
+					return written, fmt.Errorf("写入响应失败: %w", err)
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, fmt.Errorf("读取上游流式响应失败: %w", readErr)
+		}
+	}
+}
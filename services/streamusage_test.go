@@ -0,0 +1,106 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestStreamUsageIsCumulative(t *testing.T) {
+	claude := &ReqeustLog{IsStream: true}
+	hook := ReqeustLogHook(nil, "claude", claude)
+	hook([]byte(`data: {"type":"message_start","message":{"usage":{"input_tokens":120,"output_tokens":1,"cache_read_input_tokens":50}}}`))
+	hook([]byte(`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`))
+	hook([]byte(`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"input_tokens":120,"output_tokens":80,"cache_read_input_tokens":50}}`))
+	if claude.InputTokens != 120 || claude.OutputTokens != 80 || claude.CacheReadTokens != 50 {
+		t.Fatalf("message_delta 的累计用量应覆盖 message_start: %+v", claude)
+	}
+
+	chat := &ReqeustLog{IsStream: true}
+	hook = ReqeustLogHook(nil, "codex", chat)
+	hook([]byte(`data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"hi"}}],"usage":null}`))
+	hook([]byte(`data: {"object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":300,"completion_tokens":40,` +
+		`"prompt_tokens_details":{"cached_tokens":200},"completion_tokens_details":{"reasoning_tokens":16}}}`))
+	hook([]byte(`data: [DONE]`))
+	if chat.InputTokens != 300 || chat.OutputTokens != 40 || chat.CacheReadTokens != 200 || chat.ReasoningTokens != 16 {
+		t.Fatalf("应从 Chat Completions 的最后一个 chunk 读取用量: %+v", chat)
+	}
+
+	if !needsStreamUsage(chatCompletionsEndpoint, true, []byte(`{"stream":true}`)) ||
+		needsStreamUsage(chatCompletionsEndpoint, true, []byte(`{"stream":true,"stream_options":{"include_usage":true}}`)) ||
+		needsStreamUsage(chatCompletionsEndpoint, false, []byte(`{}`)) || needsStreamUsage("/responses", true, []byte(`{}`)) {
+		t.Fatalf("needsStreamUsage 判断错误")
+	}
+}
+
+func TestRelayStreamsChatCompletionsIncrementally(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	firstRead := make(chan struct{})
+	var includeUsage, waited bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		includeUsage = gjson.GetBytes(body, "stream_options.include_usage").Bool()
+		// 部分中转站的流式响应不使用 text/event-stream
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		// 客户端收到第一个 chunk 后才结束响应
+		select {
+		case <-firstRead:
+			waited = true
+		case <-time.After(5 * time.Second):
+		}
+		fmt.Fprint(w, "data: {\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":1000,\"completion_tokens\":100}}\n\ndata: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("codex", []Provider{
+		{ID: 1, Name: "openai", APIURL: upstream.URL + "/v1", APIKey: "sk-chat-1234567890", Enabled: true},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.Contains(line, `"content":"hi"`) {
+		t.Fatalf("应立即转发第一个 chunk: %q %v", line, err)
+	}
+	close(firstRead)
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), "[DONE]") {
+		t.Fatalf("响应不完整: %s", rest)
+	}
+	if !waited || !includeUsage {
+		t.Fatalf("上游结束前客户端应已收到数据，且请求应附加 include_usage: waited=%v includeUsage=%v", waited, includeUsage)
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "openai"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); entry.InputTokens != 1000 || entry.OutputTokens != 100 || record.GetFloat64("original_cost") <= 0 {
+		t.Fatalf("流式响应的用量与费用记录错误: %+v cost=%v", entry, record.GetFloat64("original_cost"))
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// SystemPromptRule.Mode 的取值，留空时为 append
+	SystemPromptAppend  = "append"
+	SystemPromptPrepend = "prepend"
+	SystemPromptReplace = "replace"
+
+	// 客户端指定 {project} 的请求头，未指定时从 Claude Code / Codex 发送的工作目录中取目录名
+	projectHeader = "X-Code-Switch-Project"
+)
+
+var (
+	claudeWorkingDirPattern = regexp.MustCompile(`Working directory: ([^\n]+)`)
+	codexCwdPattern         = regexp.MustCompile(`<cwd>([^<]+)</cwd>`)
+)
+
+// SystemPromptRule 在转发前修改请求的 system prompt（Anthropic 的 system、Responses 的 instructions、
+// Chat Completions 开头的 system 消息），如合规声明、回复语言或上游要求的前缀。
+// 在转换为 provider 的协议之前执行，text 可使用 header 模板的占位符，以及 {project}（客户端的项目目录名）与 {listener}（监听配置的归属标签）
+type SystemPromptRule struct {
+	// 适用的模型（客户端请求的模型，支持一个 * 通配符），为空时适用于所有模型
+	Models []string `json:"models,omitempty"`
+	// append（追加在原有内容之后，默认）、prepend（插入在最前面）或 replace（替换原有内容）
+	Mode string `json:"mode,omitempty"`
+	Text string `json:"text"`
+}
+
+func (r SystemPromptRule) mode() string {
+	if r.Mode == "" {
+		return SystemPromptAppend
+	}
+	return r.Mode
+}
+
+func (r SystemPromptRule) matches(model string) bool {
+	if len(r.Models) == 0 {
+		return true
+	}
+	for _, pattern := range r.Models {
+		if matchWildcard(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSystemPrompts(rules []SystemPromptRule) []string {
+	var errors []string
+	for i, rule := range rules {
+		switch rule.mode() {
+		case SystemPromptAppend, SystemPromptPrepend, SystemPromptReplace:
+		default:
+			errors = append(errors, fmt.Sprintf("systemPrompt[%d] 的 mode 不支持: %s（可选 append、prepend、replace）", i, rule.Mode))
+		}
+		if strings.TrimSpace(rule.Text) == "" && rule.mode() != SystemPromptReplace {
+			errors = append(errors, fmt.Sprintf("systemPrompt[%d] 的 text 不能为空", i))
+		}
+	}
+	return errors
+}
+
+// systemPromptRules 返回适用于请求的规则，先执行 routing 的规则，再执行 provider 的规则
+func systemPromptRules(routing []SystemPromptRule, provider Provider, requestedModel string) []SystemPromptRule {
+	var rules []SystemPromptRule
+	for _, list := range [][]SystemPromptRule{routing, provider.SystemPrompt} {
+		for _, rule := range list {
+			if rule.matches(requestedModel) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// applySystemPrompts 按客户端协议修改请求体中的 system prompt，不含 system prompt 的接口（如 embeddings）原样返回
+func applySystemPrompts(kind string, endpoint string, body []byte, rules []SystemPromptRule, values headerTemplateValues, headers map[string]string, listener string) ([]byte, error) {
+	project := ""
+	for _, rule := range rules {
+		if strings.Contains(rule.Text, "{project}") {
+			project = requestProject(kind, headers, body)
+			break
+		}
+	}
+	var err error
+	for _, rule := range rules {
+		text := strings.NewReplacer("{project}", project, "{listener}", listener).Replace(rule.Text)
+		text = values.render(text)
+		switch {
+		case kind == "claude" && endpoint == "/v1/messages":
+			body, err = applyAnthropicSystem(body, rule.mode(), text)
+		case kind == "codex" && endpoint == "/responses":
+			body, err = applyStringSystem(body, "instructions", rule.mode(), text)
+		case kind == "codex" && endpoint == chatCompletionsEndpoint:
+			body, err = applyChatSystem(body, rule.mode(), text)
+		default:
+			return body, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func joinSystemText(existing string, text string, mode string) string {
+	switch {
+	case mode == SystemPromptReplace || existing == "":
+		return text
+	case text == "":
+		return existing
+	case mode == SystemPromptPrepend:
+		return text + "\n\n" + existing
+	}
+	return existing + "\n\n" + text
+}
+
+func applyStringSystem(body []byte, field string, mode string, text string) ([]byte, error) {
+	joined := joinSystemText(gjson.GetBytes(body, field).String(), text, mode)
+	if joined == "" {
+		return sjson.DeleteBytes(body, field)
+	}
+	return sjson.SetBytes(body, field, joined)
+}
+
+// applyAnthropicSystem 修改 Anthropic 的 system：字符串直接拼接，内容块数组插入文本块，保留原有块的 cache_control
+func applyAnthropicSystem(body []byte, mode string, text string) ([]byte, error) {
+	system := gjson.GetBytes(body, "system")
+	if !system.IsArray() || mode == SystemPromptReplace {
+		return applyStringSystem(body, "system", mode, text)
+	}
+	var blocks []json.RawMessage
+	if err := json.Unmarshal([]byte(system.Raw), &blocks); err != nil {
+		return nil, err
+	}
+	block, _ := json.Marshal(map[string]string{"type": "text", "text": text})
+	if mode == SystemPromptPrepend {
+		blocks = append([]json.RawMessage{block}, blocks...)
+	} else {
+		blocks = append(blocks, block)
+	}
+	raw, err := json.Marshal(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(body, "system", raw)
+}
+
+// applyChatSystem 修改 Chat Completions 开头的 system / developer 消息：prepend 插入在最前面，
+// append 插入在这些消息之后，replace 删除它们后插入一条
+func applyChatSystem(body []byte, mode string, text string) ([]byte, error) {
+	var messages []json.RawMessage
+	if raw := gjson.GetBytes(body, "messages").Raw; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &messages); err != nil {
+			return nil, err
+		}
+	}
+	leading := 0
+	for leading < len(messages) {
+		role := gjson.GetBytes(messages[leading], "role").String()
+		if role != "system" && role != "developer" {
+			break
+		}
+		leading++
+	}
+	var inserted []json.RawMessage
+	if text != "" {
+		message, _ := json.Marshal(map[string]string{"role": "system", "content": text})
+		inserted = append(inserted, message)
+	}
+	result := make([]json.RawMessage, 0, len(messages)+1)
+	switch mode {
+	case SystemPromptPrepend:
+		result = append(append(result, inserted...), messages...)
+	case SystemPromptReplace:
+		result = append(append(result, inserted...), messages[leading:]...)
+	default:
+		result = append(append(append(result, messages[:leading]...), inserted...), messages[leading:]...)
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(body, "messages", raw)
+}
+
+// requestProject 返回客户端的项目名：优先使用 X-Code-Switch-Project 请求头，
+// 其次取 Claude Code 的 system prompt 或 Codex 的 environment_context 中工作目录的最后一级
+func requestProject(kind string, headers map[string]string, body []byte) string {
+	if project := strings.TrimSpace(headers[projectHeader]); project != "" {
+		return project
+	}
+	dir := ""
+	if kind == "claude" {
+		if match := claudeWorkingDirPattern.FindStringSubmatch(anthropicText(gjson.GetBytes(body, "system"))); match != nil {
+			dir = match[1]
+		}
+	} else if match := codexCwdPattern.FindSubmatch(body); match != nil {
+		// 原始请求体中的反斜杠经过 JSON 转义
+		dir = strings.ReplaceAll(string(match[1]), `\\`, `\`)
+	}
+	dir = strings.TrimRight(strings.TrimSpace(dir), `/\`)
+	return dir[strings.LastIndexAny(dir, `/\`)+1:]
+}
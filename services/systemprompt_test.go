@@ -0,0 +1,101 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestApplySystemPrompts(t *testing.T) {
+	render := func(kind string, endpoint string, body string, rules ...SystemPromptRule) string {
+		t.Helper()
+		data, err := applySystemPrompts(kind, endpoint, []byte(body), rules, headerTemplateValues{model: "gpt-5", platform: kind}, map[string]string{}, "work")
+		if err != nil {
+			t.Fatalf("修改 system prompt 失败: %v", err)
+		}
+		return string(data)
+	}
+
+	if out := render("claude", "/v1/messages", `{"system":"be brief","messages":[]}`, SystemPromptRule{Text: "listener {listener}"}); gjson.Get(out, "system").String() != "be brief\n\nlistener work" {
+		t.Fatalf("应追加在字符串 system 之后: %s", out)
+	}
+	out := render("claude", "/v1/messages", `{"system":[{"type":"text","text":"base","cache_control":{"type":"ephemeral"}}]}`, SystemPromptRule{Mode: SystemPromptPrepend, Text: "banner"})
+	if blocks := gjson.Get(out, "system").Array(); len(blocks) != 2 || blocks[0].Get("text").String() != "banner" || !blocks[1].Get("cache_control").Exists() {
+		t.Fatalf("应在内容块数组最前面插入，并保留 cache_control: %s", out)
+	}
+	if out := render("codex", "/responses", `{"instructions":"old"}`, SystemPromptRule{Mode: SystemPromptReplace, Text: "model {model}"}); gjson.Get(out, "instructions").String() != "model gpt-5" {
+		t.Fatalf("应替换 instructions: %s", out)
+	}
+	out = render("codex", chatCompletionsEndpoint, `{"messages":[{"role":"system","content":"a"},{"role":"user","content":"hi"}]}`, SystemPromptRule{Text: "b"})
+	if contents := gjson.Get(out, "messages.#.content").String(); contents != `["a","b","hi"]` {
+		t.Fatalf("应插入在开头的 system 消息之后: %s", out)
+	}
+	out = render("codex", chatCompletionsEndpoint, `{"messages":[{"role":"developer","content":"a"},{"role":"user","content":"hi"}]}`, SystemPromptRule{Mode: SystemPromptReplace})
+	if contents := gjson.Get(out, "messages.#.content").String(); contents != `["hi"]` {
+		t.Fatalf("内容为空的 replace 应删除 system 消息: %s", out)
+	}
+	if out := render("codex", embeddingsEndpoint, `{"input":"x"}`, SystemPromptRule{Text: "b"}); out != `{"input":"x"}` {
+		t.Fatalf("embeddings 请求不应修改: %s", out)
+	}
+
+	if project := requestProject("codex", map[string]string{}, []byte(`{"input":"<cwd>C:\\Users\\me\\api-server</cwd>"}`)); project != "api-server" {
+		t.Fatalf("应从 Codex 的工作目录中取项目名: %q", project)
+	}
+	if project := requestProject("claude", map[string]string{projectHeader: "billing"}, nil); project != "billing" {
+		t.Fatalf("应优先使用请求头中的项目名: %q", project)
+	}
+	if errs := validateSystemPrompts([]SystemPromptRule{{Mode: "insert", Text: "x"}, {Text: " "}}); len(errs) != 2 {
+		t.Fatalf("应报告不支持的 mode 与空内容: %v", errs)
+	}
+}
+
+func TestRelayInjectsSystemPromptBeforeForwarding(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		forwarded = string(data)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "gateway", APIURL: upstream.URL, APIKey: "sk-gateway-1234567890", Enabled: true,
+			SystemPrompt: []SystemPromptRule{{Mode: SystemPromptPrepend, Text: "Routed via {provider}."}}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Routing.SystemPrompt = []SystemPromptRule{
+		{Text: "Project {project}: always reply in Chinese."},
+		{Models: []string{"claude-opus-*"}, Text: "opus only"},
+	}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"system":[{"type":"text","text":"You are Claude Code.\nWorking directory: /home/me/code-switch\n"}],"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	var texts []string
+	for _, block := range gjson.Get(forwarded, "system").Array() {
+		texts = append(texts, block.Get("text").String())
+	}
+	if len(texts) != 3 || texts[0] != "Routed via gateway." || texts[2] != "Project code-switch: always reply in Chinese." {
+		t.Fatalf("应先执行 routing 的规则，再执行 provider 的规则，且不使用其他模型的规则: %q", texts)
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultConnectTimeout   = 10 * time.Second
+	defaultFirstByteTimeout = 5 * time.Minute
+	defaultTotalTimeout     = 30 * time.Minute
+)
+
+// ProviderTimeouts 是发往上游的请求超时（秒），未配置的项依次使用 relay 配置与内置默认值
+// 非流式请求的上游通常在生成完毕后才返回响应头，首字节超时需覆盖整个生成时间
+type ProviderTimeouts struct {
+	// 建立连接（含 TLS 握手）的超时，默认 10 秒
+	ConnectSeconds float64 `json:"connectSeconds,omitempty"`
+	// 发出请求到收到响应头的超时，默认 300 秒
+	FirstByteSeconds float64 `json:"firstByteSeconds,omitempty"`
+	// 整个请求（含流式响应的传输）的超时，默认 1800 秒
+	TotalSeconds float64 `json:"totalSeconds,omitempty"`
+}
+
+func (t ProviderTimeouts) validate() []string {
+	var errors []string
+	if t.ConnectSeconds < 0 || t.FirstByteSeconds < 0 || t.TotalSeconds < 0 {
+		errors = append(errors, "超时时间不能为负数")
+	}
+	if t.TotalSeconds > 0 && t.FirstByteSeconds > t.TotalSeconds {
+		errors = append(errors, "首字节超时不能超过总超时")
+	}
+	return errors
+}
+
+// resolveTimeouts 合并 provider 与 relay 的超时配置，都未配置的项使用内置默认值
+func resolveTimeouts(provider *ProviderTimeouts, defaults ProviderTimeouts) (connect, firstByte, total time.Duration) {
+	pick := func(get func(ProviderTimeouts) float64, fallback time.Duration) time.Duration {
+		if provider != nil && get(*provider) > 0 {
+			return time.Duration(get(*provider) * float64(time.Second))
+		}
+		if get(defaults) > 0 {
+			return time.Duration(get(defaults) * float64(time.Second))
+		}
+		return fallback
+	}
+	connect = pick(func(t ProviderTimeouts) float64 { return t.ConnectSeconds }, defaultConnectTimeout)
+	firstByte = pick(func(t ProviderTimeouts) float64 { return t.FirstByteSeconds }, defaultFirstByteTimeout)
+	total = pick(func(t ProviderTimeouts) float64 { return t.TotalSeconds }, defaultTotalTimeout)
+	return connect, firstByte, total
+}
+
+// upstreamTimeoutError 表示上游请求超过了配置的超时，按 timeout 类型降级
+type upstreamTimeoutError struct {
+	stage   string
+	timeout time.Duration
+}
+
+func (e *upstreamTimeoutError) Error() string {
+	return fmt.Sprintf("上游%s超时（%s）", e.stage, e.timeout)
+}
+
+func (e *upstreamTimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// upstreamDeadline 为单次上游请求设置首字节与总超时，收到响应头后调用 received 停止首字节计时
+type upstreamDeadline struct {
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	firstByte *time.Timer
+	total     *time.Timer
+}
+
+func newUpstreamDeadline(parent context.Context, firstByte, total time.Duration) *upstreamDeadline {
+	ctx, cancel := context.WithCancelCause(parent)
+	return &upstreamDeadline{
+		ctx:       ctx,
+		cancel:    cancel,
+		firstByte: time.AfterFunc(firstByte, func() { cancel(&upstreamTimeoutError{stage: "首字节", timeout: firstByte}) }),
+		total:     time.AfterFunc(total, func() { cancel(&upstreamTimeoutError{stage: "总", timeout: total}) }),
+	}
+}
+
+func (d *upstreamDeadline) received() {
+	d.firstByte.Stop()
+}
+
+// wrap 在请求因超时中断时返回对应的 upstreamTimeoutError，客户端断开等其他原因原样返回 err
+func (d *upstreamDeadline) wrap(err error) error {
+	if timeoutErr, ok := context.Cause(d.ctx).(*upstreamTimeoutError); ok && err != nil {
+		return timeoutErr
+	}
+	return err
+}
+
+func (d *upstreamDeadline) stop() {
+	d.firstByte.Stop()
+	d.total.Stop()
+	d.cancel(nil)
+}
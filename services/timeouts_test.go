@@ -0,0 +1,87 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolveTimeouts(t *testing.T) {
+	connect, firstByte, total := resolveTimeouts(nil, ProviderTimeouts{})
+	if connect != defaultConnectTimeout || firstByte != defaultFirstByteTimeout || total != defaultTotalTimeout {
+		t.Fatalf("未配置时应使用默认超时: %v %v %v", connect, firstByte, total)
+	}
+	connect, firstByte, total = resolveTimeouts(&ProviderTimeouts{FirstByteSeconds: 600}, ProviderTimeouts{ConnectSeconds: 3, FirstByteSeconds: 60})
+	if connect != 3*time.Second || firstByte != 10*time.Minute || total != defaultTotalTimeout {
+		t.Fatalf("provider 的超时应覆盖 relay 配置: %v %v %v", connect, firstByte, total)
+	}
+	if errs := (ProviderTimeouts{FirstByteSeconds: 120, TotalSeconds: 60}).validate(); len(errs) != 1 {
+		t.Fatalf("首字节超时超过总超时应报错: %v", errs)
+	}
+	if fallbackErrorType(&upstreamTimeoutError{stage: "首字节", timeout: time.Second}) != FallbackOnTimeout {
+		t.Fatalf("超时应按 timeout 类型降级")
+	}
+}
+
+func TestRelayAppliesProviderTimeouts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	stalled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer stream.Close()
+	// 先结束挂起的 handler，httptest.Server.Close 会等待所有请求处理完毕
+	defer close(stalled)
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "slow", APIURL: slow.URL, APIKey: "sk-slow-1234567890", Enabled: true, Timeouts: &ProviderTimeouts{FirstByteSeconds: 0.05}},
+		{ID: 2, Name: "stream", APIURL: stream.URL, APIKey: "sk-stream-1234567890", Enabled: true, Timeouts: &ProviderTimeouts{TotalSeconds: 0.2}},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Retry.MaxRetryAttempts = 0
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, rcs, "")
+	router := gin.New()
+	relay.registerRoutes(router)
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("超时后应结束请求，实际耗时 %v", elapsed)
+	}
+	// 首字节超时的 provider 降级到下一个；流式响应超过总超时后中断
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "message_start") {
+		t.Fatalf("首字节超时后应降级到下一个 provider: %d %s", rec.Code, rec.Body.String())
+	}
+	health := relay.health.health("claude", "slow")
+	if health.Failures == 0 {
+		t.Fatalf("首字节超时应记为失败: %+v", health)
+	}
+}
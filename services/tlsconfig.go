@@ -0,0 +1,73 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ProviderTLS 是连接 provider 的 TLS 选项，用于企业内的 TLS 拦截代理或要求客户端证书的中转
+type ProviderTLS struct {
+	// 额外信任的根证书（PEM），与系统证书一起使用
+	CAFile string `json:"caFile,omitempty"`
+	// 客户端证书与私钥（PEM），用于 mTLS，需同时配置
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// 跳过服务端证书校验，连接可能被中间人窃听，仅用于排查问题
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+func (t *ProviderTLS) validate() []string {
+	var errors []string
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errors = append(errors, "客户端证书与私钥需同时配置")
+	}
+	for _, file := range []string{t.CAFile, t.CertFile, t.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(expandHome(file)); err != nil {
+			errors = append(errors, fmt.Sprintf("TLS 文件不可用: %v", err))
+		}
+	}
+	return errors
+}
+
+func (t *ProviderTLS) key() string {
+	if t == nil {
+		return ""
+	}
+	return strings.Join([]string{t.CAFile, t.CertFile, t.KeyFile, fmt.Sprint(t.InsecureSkipVerify)}, "|")
+}
+
+// config 读取证书文件并返回 tls.Config，未配置时返回 nil 使用默认设置
+func (t *ProviderTLS) config() (*tls.Config, error) {
+	if t == nil || (t.CAFile == "" && t.CertFile == "" && !t.InsecureSkipVerify) {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		data, err := os.ReadFile(expandHome(t.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("读取根证书失败: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("根证书 %s 中没有有效的 PEM 证书", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(expandHome(t.CertFile), expandHome(t.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
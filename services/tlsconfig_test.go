@@ -0,0 +1,116 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeClientCertificate 生成自签名的客户端证书并写入 dir，返回证书与私钥路径
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "code-switch"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("序列化私钥失败: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestProviderTLSValidate(t *testing.T) {
+	if errs := (&ProviderTLS{CertFile: "client.pem"}).validate(); len(errs) != 2 {
+		t.Fatalf("只配置证书且文件不存在时应报错: %v", errs)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, []byte("not a certificate"), 0o600)
+	if _, err := (&ProviderTLS{CAFile: caFile}).config(); err == nil {
+		t.Fatalf("无效的根证书应报错")
+	}
+	if cfg, err := (&ProviderTLS{}).config(); cfg != nil || err != nil {
+		t.Fatalf("未配置时应使用默认设置: %v %v", cfg, err)
+	}
+}
+
+func TestRelayUsesProviderTLSOptions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	dir := t.TempDir()
+	var clientCerts int
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts = len(r.TLS.PeerCertificates)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0o600)
+	certFile, keyFile := writeClientCertificate(t, dir)
+
+	send := func(tlsOptions *ProviderTLS) *httptest.ResponseRecorder {
+		ps := NewProviderService()
+		if err := ps.SaveProviders("claude", []Provider{
+			{ID: 1, Name: "corp", APIURL: upstream.URL, APIKey: "sk-corp-1234567890", Enabled: true, TLS: tlsOptions},
+		}); err != nil {
+			t.Fatalf("保存 provider 失败: %v", err)
+		}
+		rcs := NewRelayConfigService()
+		cfg := defaultRelayConfig()
+		cfg.Retry.MaxRetryAttempts = 0
+		if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+			t.Fatalf("保存 relay 配置失败: %v", err)
+		}
+		relay := NewProviderRelayService(ps, rcs, "")
+		router := gin.New()
+		relay.registerRoutes(router)
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(&ProviderTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}); rec.Code != http.StatusOK || clientCerts != 1 {
+		t.Fatalf("应使用自定义根证书并发送客户端证书: %d %d %s", rec.Code, clientCerts, rec.Body.String())
+	}
+	if rec := send(&ProviderTLS{CAFile: caFile}); rec.Code == http.StatusOK {
+		t.Fatalf("未配置客户端证书时 mTLS 握手应失败")
+	}
+	if rec := send(nil); rec.Code == http.StatusOK {
+		t.Fatalf("未信任的证书应校验失败")
+	}
+	if rec := send(&ProviderTLS{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}); rec.Code != http.StatusOK {
+		t.Fatalf("跳过证书校验时应成功: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,586 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/tidwall/gjson"
+)
+
+const defaultTranscriptMaxBytes = 256 * 1024
+
+const (
+	purgeActionSoftDelete = "soft_delete"
+	purgeActionRestore    = "restore"
+	purgeActionPurge      = "purge"
+	auditActionRead       = "read"
+	auditActionReencrypt  = "reencrypt"
+)
+
+// Transcript 是一次上游请求保存的请求/响应内容
+type Transcript struct {
+	ID           int64  `json:"id"`
+	RequestLogID int64  `json:"request_log_id"`
+	Platform     string `json:"platform"`
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	KeyHint      string `json:"key_hint"`
+	SessionID    string `json:"session_id"`
+	Tenant       string `json:"tenant"`
+	RequestBody  string `json:"request_body"`
+	ResponseBody string `json:"response_body"`
+	Truncated    bool   `json:"truncated"`
+	Encrypted    bool   `json:"encrypted"`
+	Codec        string `json:"codec"`
+	CreatedAt    string `json:"created_at"`
+	DeletedAt    string `json:"deleted_at"`
+	PurgedAt     string `json:"purged_at"`
+}
+
+// TranscriptCriteria 描述要查询或清除的内容范围，各条件之间为“与”关系
+type TranscriptCriteria struct {
+	Key       string    `json:"key"` // 完整 Key 或脱敏后的 key_hint
+	SessionID string    `json:"session_id"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+func (c TranscriptCriteria) isEmpty() bool {
+	return strings.TrimSpace(c.Key) == "" && strings.TrimSpace(c.SessionID) == "" && c.From.IsZero() && c.To.IsZero()
+}
+
+func (c TranscriptCriteria) options() []xdb.Option {
+	options := []xdb.Option{}
+	if key := strings.TrimSpace(c.Key); key != "" {
+		options = append(options, xdb.WhereEq("key_hint", keyHintFor(key)))
+	}
+	if session := strings.TrimSpace(c.SessionID); session != "" {
+		options = append(options, xdb.WhereEq("session_id", session))
+	}
+	// created_at 由 sqlite CURRENT_TIMESTAMP 写入，为 UTC 时间
+	if !c.From.IsZero() {
+		options = append(options, xdb.WhereGe("created_at", c.From.UTC().Format(timeLayout)))
+	}
+	if !c.To.IsZero() {
+		options = append(options, xdb.WhereLt("created_at", c.To.UTC().Format(timeLayout)))
+	}
+	return options
+}
+
+// keyHintFor 将完整 Key 转换为 key_hint；已经是脱敏形式的直接返回
+func keyHintFor(key string) string {
+	key = strings.TrimSpace(key)
+	if strings.Contains(key, "...") || strings.Trim(key, "*") == "" {
+		return key
+	}
+	return maskAPIKey(key)
+}
+
+// PurgeResult 描述一次清除/软删除的结果
+type PurgeResult struct {
+	Action   string `json:"action"`
+	Affected int64  `json:"affected"`
+	DryRun   bool   `json:"dry_run"`
+	AuditID  int64  `json:"audit_id"`
+}
+
+// PurgeAuditEntry 是清除操作的墓碑记录：只记录清除范围与数量，不含任何内容
+type PurgeAuditEntry struct {
+	ID        int64  `json:"id"`
+	Action    string `json:"action"`
+	Criteria  string `json:"criteria"`
+	Reason    string `json:"reason"`
+	Affected  int64  `json:"affected"`
+	CreatedAt string `json:"created_at"`
+}
+
+// TranscriptService 管理保存的请求/响应内容，并支持按 Key、会话或时间范围清除
+// 清除只影响 request_transcript 中的内容，request_log 中的 token 与费用统计保持不变
+// 加密保存的内容在列表中不返回正文，需通过 ReadTranscript 显式解密，每次解密都会写入审计
+type TranscriptService struct {
+	keyring *transcriptKeyring
+}
+
+func NewTranscriptService() *TranscriptService {
+	return &TranscriptService{keyring: sharedTranscriptKeyring()}
+}
+
+func (ts *TranscriptService) Start() error { return nil }
+func (ts *TranscriptService) Stop() error  { return nil }
+
+// ListTranscripts 返回满足条件、未被删除的内容记录（按 id 倒序）
+func (ts *TranscriptService) ListTranscripts(criteria TranscriptCriteria, limit int) ([]Transcript, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	options := append(criteria.options(),
+		xdb.WhereEq("deleted_at", ""),
+		xdb.WhereEq("purged_at", ""),
+		xdb.OrderByDesc("id"),
+		xdb.Limit(limit),
+	)
+	records, err := xdb.New("request_transcript").Selects(options...)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return []Transcript{}, nil
+		}
+		return nil, err
+	}
+	transcripts := make([]Transcript, 0, len(records))
+	for _, record := range records {
+		transcript := decodeTranscriptRecord(record)
+		if transcript.Encrypted {
+			transcript.RequestBody = ""
+			transcript.ResponseBody = ""
+		}
+		transcripts = append(transcripts, transcript)
+	}
+	return transcripts, nil
+}
+
+// ReadTranscript 读取单条内容；加密内容在此解密，并记录一次读取审计
+func (ts *TranscriptService) ReadTranscript(id int64, reason string) (Transcript, error) {
+	record, err := xdb.New("request_transcript").First(xdb.WhereEq("id", id))
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) {
+			return Transcript{}, fmt.Errorf("内容 #%d 不存在", id)
+		}
+		return Transcript{}, err
+	}
+	transcript := decodeTranscriptRecord(record)
+	if transcript.PurgedAt != "" {
+		return transcript, fmt.Errorf("内容 #%d 已被清除", id)
+	}
+	if !transcript.Encrypted {
+		return transcript, nil
+	}
+	if transcript, err = ts.decryptTranscript(transcript); err != nil {
+		return Transcript{}, err
+	}
+	if _, err := recordAudit(auditActionRead, map[string]any{"id": id, "tenant": transcript.Tenant}, reason, 1); err != nil {
+		return Transcript{}, fmt.Errorf("写入读取审计失败: %w", err)
+	}
+	return transcript, nil
+}
+
+// ListTranscriptKeys 返回所有租户密钥的元信息
+func (ts *TranscriptService) ListTranscriptKeys() ([]TranscriptKeyInfo, error) {
+	return ts.keyring.list()
+}
+
+// RotateTranscriptKey 为租户生成新的活动密钥；旧密钥保留用于解密历史内容
+func (ts *TranscriptService) RotateTranscriptKey(tenant string) (string, error) {
+	tenant = strings.TrimSpace(tenant)
+	if tenant == "" {
+		return "", errors.New("tenant is required")
+	}
+	keyID, err := ts.keyring.rotate(tenant)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("[INFO] 租户 %s 的内容加密密钥已轮换为 %s\n", tenant, keyID)
+	return keyID, nil
+}
+
+// ReencryptTranscripts 使用租户当前的活动密钥重新加密历史内容，完成后删除旧密钥
+func (ts *TranscriptService) ReencryptTranscripts(tenant string) (int64, error) {
+	tenant = strings.TrimSpace(tenant)
+	if tenant == "" {
+		return 0, errors.New("tenant is required")
+	}
+	activeID, _, err := ts.keyring.activeKey(tenant)
+	if err != nil {
+		return 0, err
+	}
+	records, err := xdb.New("request_transcript").Selects(
+		xdb.WhereEq("tenant", tenant),
+		xdb.WhereEq("encrypted", 1),
+		xdb.WhereEq("purged_at", ""),
+		xdb.WhereNotEq("key_id", activeID),
+	)
+	if err != nil && !errors.Is(err, xdb.ErrNotFound) {
+		return 0, err
+	}
+	model := xdb.New("request_transcript", xdb.WithSaveZero())
+	var count int64
+	for _, record := range records {
+		update := xdb.Record{"id": record.GetInt64("id"), "key_id": activeID}
+		for _, field := range []string{"request_body", "response_body"} {
+			plaintext, err := ts.keyring.decryptField(tenant, record.GetString(field))
+			if err != nil {
+				return count, fmt.Errorf("解密内容 #%d 失败: %w", record.GetInt64("id"), err)
+			}
+			ciphertext, _, err := ts.keyring.encryptField(tenant, plaintext)
+			if err != nil {
+				return count, err
+			}
+			update[field] = ciphertext
+		}
+		if _, err := model.Update(update); err != nil {
+			return count, err
+		}
+		count++
+	}
+	removed, err := ts.keyring.retire(tenant)
+	if err != nil {
+		return count, err
+	}
+	if _, err := recordAudit(auditActionReencrypt, map[string]any{"tenant": tenant, "key_id": activeID}, "", count); err != nil {
+		return count, err
+	}
+	fmt.Printf("[INFO] 租户 %s 已重新加密 %d 条内容，删除旧密钥 %d 个\n", tenant, count, removed)
+	return count, nil
+}
+
+// SoftDeleteTranscripts 将内容标记为已删除：不再出现在列表中，但在清除前仍可恢复
+func (ts *TranscriptService) SoftDeleteTranscripts(criteria TranscriptCriteria, reason string) (PurgeResult, error) {
+	return ts.apply(purgeActionSoftDelete, criteria, reason, false, xdb.WhereEq("deleted_at", ""), xdb.WhereEq("purged_at", ""))
+}
+
+// RestoreTranscripts 恢复软删除的内容（已清除的内容无法恢复）
+func (ts *TranscriptService) RestoreTranscripts(criteria TranscriptCriteria, reason string) (PurgeResult, error) {
+	return ts.apply(purgeActionRestore, criteria, reason, false, xdb.WhereNotEq("deleted_at", ""), xdb.WhereEq("purged_at", ""))
+}
+
+// PurgeTranscripts 永久清除请求与响应内容，仅保留元数据行与审计记录
+func (ts *TranscriptService) PurgeTranscripts(criteria TranscriptCriteria, reason string, dryRun bool) (PurgeResult, error) {
+	return ts.apply(purgeActionPurge, criteria, reason, dryRun, xdb.WhereEq("purged_at", ""))
+}
+
+// ListPurgeAudit 返回最近的清除审计记录
+func (ts *TranscriptService) ListPurgeAudit(limit int) ([]PurgeAuditEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	records, err := xdb.New("purge_audit").Selects(xdb.OrderByDesc("id"), xdb.Limit(limit))
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return []PurgeAuditEntry{}, nil
+		}
+		return nil, err
+	}
+	entries := make([]PurgeAuditEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, PurgeAuditEntry{
+			ID:        record.GetInt64("id"),
+			Action:    record.GetString("action"),
+			Criteria:  record.GetString("criteria"),
+			Reason:    record.GetString("reason"),
+			Affected:  record.GetInt64("affected"),
+			CreatedAt: record.GetString("created_at"),
+		})
+	}
+	return entries, nil
+}
+
+func (ts *TranscriptService) apply(action string, criteria TranscriptCriteria, reason string, dryRun bool, extra ...xdb.Option) (PurgeResult, error) {
+	result := PurgeResult{Action: action, DryRun: dryRun}
+	if criteria.isEmpty() {
+		return result, errors.New("至少需要指定 Key、会话或时间范围中的一项")
+	}
+	if !criteria.From.IsZero() && !criteria.To.IsZero() && !criteria.To.After(criteria.From) {
+		return result, errors.New("时间范围无效：结束时间必须晚于开始时间")
+	}
+
+	options := append(criteria.options(), extra...)
+	count, err := xdb.New("request_transcript").Count(options...)
+	if err != nil && !errors.Is(err, xdb.ErrNotFound) {
+		return result, err
+	}
+	result.Affected = count
+	if dryRun || count == 0 {
+		return result, nil
+	}
+
+	now := time.Now().UTC().Format(timeLayout)
+	var update xdb.Record
+	switch action {
+	case purgeActionSoftDelete:
+		update = xdb.Record{"deleted_at": now}
+	case purgeActionRestore:
+		update = xdb.Record{"deleted_at": ""}
+	default:
+		update = xdb.Record{"request_body": "", "response_body": "", "purged_at": now}
+	}
+	if _, err := xdb.New("request_transcript", xdb.WithSaveZero()).Update(update, options...); err != nil {
+		return result, err
+	}
+
+	auditID, err := recordPurgeAudit(action, criteria, reason, count)
+	if err != nil {
+		return result, fmt.Errorf("内容已处理，但写入审计记录失败: %w", err)
+	}
+	result.AuditID = auditID
+	fmt.Printf("[INFO] 内容%s完成：%d 条（审计 #%d）\n", purgeActionLabel(action), count, auditID)
+	return result, nil
+}
+
+func purgeActionLabel(action string) string {
+	switch action {
+	case purgeActionSoftDelete:
+		return "软删除"
+	case purgeActionRestore:
+		return "恢复"
+	default:
+		return "清除"
+	}
+}
+
+func recordPurgeAudit(action string, criteria TranscriptCriteria, reason string, affected int64) (int64, error) {
+	// 审计中只保存 key_hint，避免完整 Key 落盘
+	auditCriteria := criteria
+	if auditCriteria.Key != "" {
+		auditCriteria.Key = keyHintFor(auditCriteria.Key)
+	}
+	return recordAudit(action, auditCriteria, reason, affected)
+}
+
+func recordAudit(action string, criteria any, reason string, affected int64) (int64, error) {
+	data, err := json.Marshal(criteria)
+	if err != nil {
+		return 0, err
+	}
+	return xdb.New("purge_audit", xdb.WithSaveZero()).Insert(xdb.Record{
+		"action":   action,
+		"criteria": string(data),
+		"reason":   strings.TrimSpace(reason),
+		"affected": affected,
+	})
+}
+
+func transcriptFromRecord(record xdb.Record) Transcript {
+	return Transcript{
+		ID:           record.GetInt64("id"),
+		RequestLogID: record.GetInt64("request_log_id"),
+		Platform:     record.GetString("platform"),
+		Provider:     record.GetString("provider"),
+		Model:        record.GetString("model"),
+		KeyHint:      record.GetString("key_hint"),
+		SessionID:    record.GetString("session_id"),
+		Tenant:       record.GetString("tenant"),
+		RequestBody:  record.GetString("request_body"),
+		ResponseBody: record.GetString("response_body"),
+		Truncated:    record.GetBool("truncated"),
+		Encrypted:    record.GetBool("encrypted"),
+		Codec:        record.GetString("codec"),
+		CreatedAt:    record.GetString("created_at"),
+		DeletedAt:    record.GetString("deleted_at"),
+		PurgedAt:     record.GetString("purged_at"),
+	}
+}
+
+// decodeTranscriptRecord 读取记录并解压未加密的内容；加密内容需先通过 decryptTranscript 解密
+func decodeTranscriptRecord(record xdb.Record) Transcript {
+	transcript := transcriptFromRecord(record)
+	if transcript.Encrypted {
+		return transcript
+	}
+	decoded, err := decompressTranscript(transcript)
+	if err != nil {
+		fmt.Printf("[WARN] 解压内容 #%d 失败: %v\n", transcript.ID, err)
+		return transcript
+	}
+	return decoded
+}
+
+func decompressTranscript(transcript Transcript) (Transcript, error) {
+	var err error
+	if transcript.RequestBody, err = decompressField(transcript.RequestBody); err != nil {
+		return transcript, err
+	}
+	if transcript.ResponseBody, err = decompressField(transcript.ResponseBody); err != nil {
+		return transcript, err
+	}
+	return transcript, nil
+}
+
+// transcriptCapture 在转发响应的同时收集响应内容，超过上限的部分被丢弃
+type transcriptCapture struct {
+	mu        sync.Mutex
+	limit     int
+	encrypt   bool
+	codec     string
+	buf       []byte
+	truncated bool
+}
+
+func newTranscriptCapture(cfg TranscriptConfig) *transcriptCapture {
+	limit := cfg.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultTranscriptMaxBytes
+	}
+	codec := cfg.Compression
+	if _, err := lookupBodyCodec(codec); err != nil {
+		fmt.Printf("[WARN] %v，内容将不压缩保存\n", err)
+		codec = TranscriptCodecNone
+	}
+	return &transcriptCapture{limit: limit, encrypt: cfg.Encrypt, codec: codec}
+}
+
+func (tc *transcriptCapture) write(data []byte) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	remaining := tc.limit - len(tc.buf)
+	if remaining <= 0 {
+		if len(data) > 0 {
+			tc.truncated = true
+		}
+		return
+	}
+	if len(data) > remaining {
+		data = data[:remaining]
+		tc.truncated = true
+	}
+	tc.buf = append(tc.buf, data...)
+}
+
+// hook 作为响应钩子使用，不修改转发的数据
+func (tc *transcriptCapture) hook(data []byte) (bool, []byte) {
+	tc.write(data)
+	return true, data
+}
+
+func (tc *transcriptCapture) body() (string, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return string(tc.buf), tc.truncated
+}
+
+func saveTranscript(logID int64, entry *ReqeustLog, sessionID string, requestBody []byte, capture *transcriptCapture) error {
+	responseBody, truncated := capture.body()
+	if len(requestBody) > capture.limit {
+		requestBody = requestBody[:capture.limit]
+		truncated = true
+	}
+	tenant := transcriptTenant(entry)
+	requestText := string(requestBody)
+	originalBytes := len(requestText) + len(responseBody)
+	// 先压缩再加密，加密后的内容无法再压缩
+	requestText, requestCodec, err := compressField(capture.codec, requestText)
+	if err != nil {
+		return err
+	}
+	responseBody, responseCodec, err := compressField(capture.codec, responseBody)
+	if err != nil {
+		return err
+	}
+	codec := requestCodec
+	if codec == "" {
+		codec = responseCodec
+	}
+	keyID := ""
+	if capture.encrypt {
+		keyring := sharedTranscriptKeyring()
+		if requestText, keyID, err = keyring.encryptField(tenant, requestText); err != nil {
+			return fmt.Errorf("加密请求内容失败: %w", err)
+		}
+		var responseKeyID string
+		if responseBody, responseKeyID, err = keyring.encryptField(tenant, responseBody); err != nil {
+			return fmt.Errorf("加密响应内容失败: %w", err)
+		}
+		if keyID == "" {
+			keyID = responseKeyID
+		}
+	}
+	_, err = xdb.New("request_transcript").Insert(xdb.Record{
+		"request_log_id": logID,
+		"platform":       entry.Platform,
+		"provider":       entry.Provider,
+		"model":          entry.Model,
+		"key_hint":       entry.KeyHint,
+		"session_id":     sessionID,
+		"tenant":         tenant,
+		"request_body":   requestText,
+		"response_body":  responseBody,
+		"truncated":      boolToInt(truncated),
+		"encrypted":      boolToInt(capture.encrypt),
+		"key_id":         keyID,
+		"codec":          codec,
+		"original_bytes": originalBytes,
+		"stored_bytes":   len(requestText) + len(responseBody),
+	})
+	return err
+}
+
+// transcriptTenant 返回内容所属的租户，每个租户使用独立的加密密钥
+func transcriptTenant(entry *ReqeustLog) string {
+	return entry.Platform
+}
+
+// extractSessionID 从请求中提取会话标识：
+// Claude Code 在 metadata.user_id 中携带 "..._session_<uuid>"；Codex 使用 session_id 头或 prompt_cache_key
+func extractSessionID(kind string, body []byte, header http.Header) string {
+	if kind == "codex" {
+		if session := strings.TrimSpace(header.Get("session_id")); session != "" {
+			return session
+		}
+		return gjson.GetBytes(body, "prompt_cache_key").String()
+	}
+	userID := gjson.GetBytes(body, "metadata.user_id").String()
+	if idx := strings.LastIndex(userID, "_session_"); idx >= 0 {
+		return userID[idx+len("_session_"):]
+	}
+	return userID
+}
+
+func ensureTranscriptTables(db *sql.DB) error {
+	const createTranscriptSQL = `CREATE TABLE IF NOT EXISTS request_transcript (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		request_log_id INTEGER,
+		platform TEXT,
+		provider TEXT,
+		model TEXT,
+		key_hint TEXT DEFAULT '',
+		session_id TEXT DEFAULT '',
+		request_body TEXT DEFAULT '',
+		response_body TEXT DEFAULT '',
+		truncated INTEGER DEFAULT 0,
+		tenant TEXT DEFAULT '',
+		encrypted INTEGER DEFAULT 0,
+		key_id TEXT DEFAULT '',
+		codec TEXT DEFAULT '',
+		original_bytes INTEGER DEFAULT 0,
+		stored_bytes INTEGER DEFAULT 0,
+		deleted_at TEXT DEFAULT '',
+		purged_at TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	const createAuditSQL = `CREATE TABLE IF NOT EXISTS purge_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT,
+		criteria TEXT,
+		reason TEXT DEFAULT '',
+		affected INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	for _, stmt := range []string{
+		createTranscriptSQL,
+		createAuditSQL,
+		`CREATE INDEX IF NOT EXISTS idx_request_transcript_session ON request_transcript(session_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_request_transcript_key ON request_transcript(key_hint)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for column, definition := range map[string]string{
+		"tenant":         "TEXT DEFAULT ''",
+		"encrypted":      "INTEGER DEFAULT 0",
+		"key_id":         "TEXT DEFAULT ''",
+		"codec":          "TEXT DEFAULT ''",
+		"original_bytes": "INTEGER DEFAULT 0",
+		"stored_bytes":   "INTEGER DEFAULT 0",
+	} {
+		if err := ensureTableColumn(db, "request_transcript", column, definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
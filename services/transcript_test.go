@@ -0,0 +1,240 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+func initTestDatabase(t *testing.T) {
+	t.Helper()
+	if err := xdb.Inits([]xdb.Config{{
+		Name:   "default",
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "app.db?cache=shared&mode=rwc"),
+	}}); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	if err := ensureRequestLogTable(); err != nil {
+		t.Fatalf("初始化表结构失败: %v", err)
+	}
+}
+
+func TestTranscriptPurgeKeepsAggregates(t *testing.T) {
+	initTestDatabase(t)
+
+	entry := &ReqeustLog{Platform: "claude", Provider: "p1", Model: "m", KeyHint: maskAPIKey("sk-1234567890")}
+	for i, session := range []string{"s1", "s1", "s2"} {
+		capture := newTranscriptCapture(TranscriptConfig{MaxBodyBytes: 8})
+		capture.write([]byte("response-body"))
+		if err := saveTranscript(int64(i+1), entry, session, []byte("prompt"), capture); err != nil {
+			t.Fatalf("保存内容失败: %v", err)
+		}
+	}
+
+	ts := NewTranscriptService()
+	if _, err := ts.PurgeTranscripts(TranscriptCriteria{}, "", false); err == nil {
+		t.Fatalf("未指定条件时应拒绝清除")
+	}
+
+	result, err := ts.PurgeTranscripts(TranscriptCriteria{SessionID: "s1"}, "用户请求删除", false)
+	if err != nil || result.Affected != 2 || result.AuditID == 0 {
+		t.Fatalf("PurgeTranscripts = (%+v, %v)", result, err)
+	}
+
+	remaining, err := ts.ListTranscripts(TranscriptCriteria{Key: "sk-1234567890"}, 10)
+	if err != nil || len(remaining) != 1 || remaining[0].SessionID != "s2" {
+		t.Fatalf("清除后应只剩会话 s2 的内容: %+v, %v", remaining, err)
+	}
+	if !remaining[0].Truncated || remaining[0].ResponseBody != "response" {
+		t.Errorf("超过上限的响应应被截断: %+v", remaining[0])
+	}
+
+	rows, err := xdb.New("request_transcript").Count(xdb.WhereNotEq("purged_at", ""))
+	if err != nil || rows != 2 {
+		t.Errorf("清除应保留元数据行，实际 %d, %v", rows, err)
+	}
+
+	audit, err := ts.ListPurgeAudit(10)
+	if err != nil || len(audit) != 1 || audit[0].Affected != 2 || audit[0].Action != purgeActionPurge {
+		t.Errorf("审计记录不正确: %+v, %v", audit, err)
+	}
+
+	soft, err := ts.SoftDeleteTranscripts(TranscriptCriteria{From: time.Now().Add(-time.Hour)}, "")
+	if err != nil || soft.Affected != 1 {
+		t.Fatalf("SoftDeleteTranscripts = (%+v, %v)", soft, err)
+	}
+	restored, err := ts.RestoreTranscripts(TranscriptCriteria{SessionID: "s2"}, "")
+	if err != nil || restored.Affected != 1 {
+		t.Fatalf("RestoreTranscripts = (%+v, %v)", restored, err)
+	}
+}
+
+func TestExtractSessionID(t *testing.T) {
+	body := []byte(`{"metadata":{"user_id":"user_abc_account__session_1234-5678"}}`)
+	if got := extractSessionID("claude", body, nil); got != "1234-5678" {
+		t.Errorf("extractSessionID(claude) = %q", got)
+	}
+	if got := extractSessionID("codex", []byte(`{"prompt_cache_key":"pk-1"}`), map[string][]string{}); got != "pk-1" {
+		t.Errorf("extractSessionID(codex) = %q", got)
+	}
+}
+
+func TestTranscriptEncryptionAndRotation(t *testing.T) {
+	initTestDatabase(t)
+	keyring := newTranscriptKeyring(filepath.Join(t.TempDir(), transcriptKeyringFile))
+	defaultKeyringOnce.Do(func() {})
+	previous := defaultKeyring
+	defaultKeyring = keyring
+	defer func() { defaultKeyring = previous }()
+
+	entry := &ReqeustLog{Platform: "claude", Provider: "p1", Model: "m"}
+	capture := newTranscriptCapture(TranscriptConfig{Encrypt: true})
+	capture.write([]byte("secret response"))
+	if err := saveTranscript(1, entry, "s1", []byte("secret prompt"), capture); err != nil {
+		t.Fatalf("保存加密内容失败: %v", err)
+	}
+
+	raw, err := xdb.New("request_transcript").First(xdb.WhereEq("session_id", "s1"))
+	if err != nil || !isEncryptedField(raw.GetString("request_body")) {
+		t.Fatalf("内容应加密落盘: %v", raw)
+	}
+
+	ts := &TranscriptService{keyring: keyring}
+	listed, err := ts.ListTranscripts(TranscriptCriteria{SessionID: "s1"}, 10)
+	if err != nil || len(listed) != 1 || listed[0].RequestBody != "" || !listed[0].Encrypted {
+		t.Fatalf("列表中不应返回加密正文: %+v, %v", listed, err)
+	}
+
+	oldKey := raw.GetString("key_id")
+	if _, err := ts.RotateTranscriptKey("claude"); err != nil {
+		t.Fatalf("轮换密钥失败: %v", err)
+	}
+	read, err := ts.ReadTranscript(listed[0].ID, "排查问题")
+	if err != nil || read.RequestBody != "secret prompt" || read.ResponseBody != "secret response" {
+		t.Fatalf("轮换后仍应能用旧密钥解密: %+v, %v", read, err)
+	}
+
+	count, err := ts.ReencryptTranscripts("claude")
+	if err != nil || count != 1 {
+		t.Fatalf("ReencryptTranscripts = (%d, %v)", count, err)
+	}
+	if _, err := keyring.key("claude", oldKey); err != ErrTranscriptKeyNotFound {
+		t.Errorf("重新加密后旧密钥应被删除")
+	}
+	read, err = ts.ReadTranscript(listed[0].ID, "")
+	if err != nil || read.RequestBody != "secret prompt" {
+		t.Fatalf("重新加密后应能用新密钥解密: %+v, %v", read, err)
+	}
+
+	if _, err := keyring.decryptField("codex", raw.GetString("request_body")); err == nil {
+		t.Errorf("其他租户不应能解密")
+	}
+}
+
+func TestTranscriptCompression(t *testing.T) {
+	initTestDatabase(t)
+	keyring := newTranscriptKeyring(filepath.Join(t.TempDir(), transcriptKeyringFile))
+	defaultKeyringOnce.Do(func() {})
+	previous := defaultKeyring
+	defaultKeyring = keyring
+	defer func() { defaultKeyring = previous }()
+
+	var request, response strings.Builder
+	request.WriteString(`{"model":"claude-sonnet-4-5","max_tokens":32000,"stream":true,"messages":[`)
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&request, `{"role":"user","content":[{"type":"text","text":"第 %d 轮：请检查 main.go 的错误处理"}]},`, i)
+		fmt.Fprintf(&response, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"片段 %d\"}}\n\n", i)
+	}
+	request.WriteString(`{"role":"user","content":"继续"}]}`)
+
+	entry := &ReqeustLog{Platform: "claude", Provider: "p1", Model: "m"}
+	for session, encrypt := range map[string]bool{"plain": false, "sealed": true} {
+		capture := newTranscriptCapture(TranscriptConfig{Compression: TranscriptCodecZstdChat, Encrypt: encrypt})
+		capture.write([]byte(response.String()))
+		if err := saveTranscript(1, entry, session, []byte(request.String()), capture); err != nil {
+			t.Fatalf("保存内容失败: %v", err)
+		}
+	}
+	// 未压缩的历史内容仍可正常读取
+	legacy := newTranscriptCapture(TranscriptConfig{Compression: TranscriptCodecNone})
+	legacy.write([]byte("old response"))
+	if err := saveTranscript(2, entry, "legacy", []byte("old prompt"), legacy); err != nil {
+		t.Fatalf("保存内容失败: %v", err)
+	}
+
+	raw, err := xdb.New("request_transcript").First(xdb.WhereEq("session_id", "plain"))
+	if err != nil || !isCompressedField(raw.GetString("request_body")) || raw.GetString("codec") != TranscriptCodecZstdChat {
+		t.Fatalf("内容应压缩落盘: %v %v", raw, err)
+	}
+	if stored := len(raw.GetString("request_body")); stored*3 > request.Len() {
+		t.Errorf("压缩效果不足: %d -> %d 字节", request.Len(), stored)
+	}
+
+	ts := &TranscriptService{keyring: keyring}
+	for _, session := range []string{"plain", "sealed"} {
+		listed, err := ts.ListTranscripts(TranscriptCriteria{SessionID: session}, 10)
+		if err != nil || len(listed) != 1 {
+			t.Fatalf("ListTranscripts(%s) = %+v, %v", session, listed, err)
+		}
+		read, err := ts.ReadTranscript(listed[0].ID, "")
+		if err != nil || read.RequestBody != request.String() || read.ResponseBody != response.String() {
+			t.Fatalf("%s 内容应解压为原文: %v", session, err)
+		}
+	}
+	listed, _ := ts.ListTranscripts(TranscriptCriteria{SessionID: "legacy"}, 10)
+	if len(listed) != 1 || listed[0].RequestBody != "old prompt" || listed[0].Codec != "" {
+		t.Fatalf("未压缩内容读取错误: %+v", listed)
+	}
+
+	report, err := ts.TranscriptStorage()
+	if err != nil || report.Total.Transcripts != 3 || len(report.Codecs) != 2 || report.Codecs[0].Codec != TranscriptCodecNone ||
+		report.Codecs[1].Codec != TranscriptCodecZstdChat || report.Codecs[0].Ratio != 1 || report.Codecs[1].Ratio < 3 {
+		t.Fatalf("TranscriptStorage = %+v, %v", report, err)
+	}
+
+	if capture := newTranscriptCapture(TranscriptConfig{Compression: "unknown"}); capture.codec != TranscriptCodecNone {
+		t.Errorf("未知的压缩方式应回退为不压缩: %q", capture.codec)
+	}
+}
+
+// externalCodec 模拟通过 RegisterBodyCodec 接入的第三方压缩实现
+type externalCodec struct{}
+
+func (externalCodec) Name() string { return "external" }
+
+func (externalCodec) Encode(data []byte) ([]byte, error) {
+	return deflateCodec{}.Encode(data)
+}
+
+func (externalCodec) Decode(data []byte) ([]byte, error) {
+	return deflateCodec{}.Decode(data)
+}
+
+func TestRegisteredBodyCodec(t *testing.T) {
+	RegisterBodyCodec(externalCodec{})
+	defer func() {
+		bodyCodecsMu.Lock()
+		delete(bodyCodecs, "external")
+		bodyCodecsMu.Unlock()
+	}()
+	if capture := newTranscriptCapture(TranscriptConfig{Compression: "external"}); capture.codec != "external" {
+		t.Fatalf("transcripts.compression 应可以使用注册的实现: %q", capture.codec)
+	}
+	value := strings.Repeat(`{"type":"text","text":"hello"}`, 50)
+	stored, codec, err := compressField("external", value)
+	if err != nil || codec != "external" || !strings.HasPrefix(stored, compressedFieldPrefix+"external:") {
+		t.Fatalf("compressField = (%q, %q, %v)", stored, codec, err)
+	}
+	// 切换默认压缩方式后，已保存的内容仍按各自记录的 codec 解压
+	chat, _, _ := compressField(TranscriptCodecDeflateChat, value)
+	for _, field := range []string{stored, chat} {
+		if got, err := decompressField(field); err != nil || got != value {
+			t.Fatalf("decompressField = %v", err)
+		}
+	}
+}
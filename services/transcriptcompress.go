@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// 压缩字段格式：cmp:v1:<codec>:<base64(压缩数据)>，加密时先压缩再加密
+	compressedFieldPrefix = "cmp:v1:"
+
+	// TranscriptCodecNone 表示不压缩
+	TranscriptCodecNone = "none"
+	// TranscriptCodecDeflate 为标准 DEFLATE
+	TranscriptCodecDeflate = "deflate"
+	// TranscriptCodecDeflateChat 为使用内置对话 JSON 预置字典的 DEFLATE
+	TranscriptCodecDeflateChat = "deflate-chat"
+	// TranscriptCodecZstd 为不带字典的 zstd
+	TranscriptCodecZstd = "zstd"
+	// TranscriptCodecZstdChat 为使用按对话 JSON 样本训练的字典的 zstd（默认）
+	TranscriptCodecZstdChat = "zstd-chat"
+)
+
+// BodyCodec 压缩保存的请求/响应内容，Name 写入每条内容中用于解压，已使用的名称不能改变编码格式。
+// 内置 deflate（标准库）与 zstd（klauspost/compress）两类实现，单条内容通常只有几十 KB，压缩率主要取决于字典。
+// 其他实现可以通过 RegisterBodyCodec 注册，并在 transcripts.compression 中使用它的名称，已保存的内容按各自的 codec 解压，不需要迁移
+type BodyCodec interface {
+	Name() string
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+var (
+	bodyCodecsMu sync.RWMutex
+	bodyCodecs   = map[string]BodyCodec{}
+)
+
+// RegisterBodyCodec 注册压缩实现，同名的实现会被替换
+func RegisterBodyCodec(codec BodyCodec) {
+	bodyCodecsMu.Lock()
+	defer bodyCodecsMu.Unlock()
+	bodyCodecs[codec.Name()] = codec
+}
+
+// lookupBodyCodec 返回指定名称的压缩实现，none 或空名称返回 nil
+func lookupBodyCodec(name string) (BodyCodec, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == TranscriptCodecNone {
+		return nil, nil
+	}
+	bodyCodecsMu.RLock()
+	defer bodyCodecsMu.RUnlock()
+	codec, ok := bodyCodecs[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的压缩方式: %s", name)
+	}
+	return codec, nil
+}
+
+func init() {
+	RegisterBodyCodec(deflateCodec{name: TranscriptCodecDeflate})
+	RegisterBodyCodec(deflateCodec{name: TranscriptCodecDeflateChat, dict: chatJSONDictionary})
+	RegisterBodyCodec(&zstdCodec{name: TranscriptCodecZstd})
+	RegisterBodyCodec(&zstdCodec{name: TranscriptCodecZstdChat, dict: zstdChatDictionary})
+}
+
+// zstdChatDictionary 是 zstd-chat 的字典：内容为 chatJSONDictionary，熵编码表按 Claude Code 与 Codex 的对话样本训练
+// （见 TestTrainZstdChatDictionary）。字典随程序一起发布，不随 klauspost/compress 的版本变化；已保存的内容依赖该字典解压，
+// 重新训练时必须使用新的 codec 名称
+//
+//go:embed zstd_chat.dict
+var zstdChatDictionary []byte
+
+// chatJSONDictionary 是 deflate-chat 的预置字典，收录 Anthropic Messages 与 OpenAI Responses 请求和 SSE 响应中的常见片段
+// 越常见的片段越靠后（DEFLATE 对距离较近的匹配编码更短）。已保存的内容依赖该字典解压，修改时必须使用新的 codec 名称
+var chatJSONDictionary = []byte(strings.Join([]string{
+	`"reasoning":{"effort":"medium","summary":"auto"},"include":["reasoning.encrypted_content"],"parallel_tool_calls":true,"tool_choice":"auto","prompt_cache_key":"","instructions":"`,
+	`event: response.output_text.delta`,
+	`data: {"type":"response.output_text.delta","item_id":"msg_","output_index":0,"content_index":0,"delta":"`,
+	``,
+	`event: response.completed`,
+	`data: {"type":"response.completed","response":{"id":"resp_","status":"completed","usage":{"input_tokens":0,"input_tokens_details":{"cached_tokens":0},"output_tokens":0,"output_tokens_details":{"reasoning_tokens":0},"total_tokens":0}}}`,
+	``,
+	`{"type":"function_call","call_id":"call_","name":"","arguments":"{\"command\":[\"bash\",\"-lc\",\"`,
+	`{"type":"message","role":"user","content":[{"type":"input_text","text":"`,
+	`"metadata":{"user_id":"user__account__session_"},"system":[{"type":"text","text":"You are Claude Code, Anthropic's official CLI for Claude.","cache_control":{"type":"ephemeral"}}],`,
+	`"tools":[{"name":"Bash","description":"","input_schema":{"type":"object","properties":{"command":{"type":"string","description":"`,
+	`"required":["command"],"additionalProperties":false,"$schema":"http://json-schema.org/draft-07/schema#"}},`,
+	`{"type":"tool_use","id":"toolu_","name":"","input":{}}`,
+	`{"type":"tool_result","tool_use_id":"toolu_","content":"","is_error":false}`,
+	`event: message_start`,
+	`data: {"type":"message_start","message":{"id":"msg_","type":"message","role":"assistant","model":"claude-sonnet-4-5","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":0,"service_tier":"standard"}}}`,
+	``,
+	`event: content_block_start`,
+	`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	``,
+	`event: content_block_stop`,
+	`data: {"type":"content_block_stop","index":0}`,
+	``,
+	`event: message_delta`,
+	`data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":0}}`,
+	``,
+	`event: message_stop`,
+	`data: {"type":"message_stop"}`,
+	``,
+	`event: ping`,
+	`data: {"type": "ping"}`,
+	``,
+	`{"model":"claude-sonnet-4-5","max_tokens":32000,"stream":true,"messages":[{"role":"user","content":[{"type":"text","text":"`,
+	`"},{"role":"assistant","content":[{"type":"text","text":"`,
+	`event: content_block_delta`,
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"`,
+}, "\n"))
+
+// deflateCodec 使用 compress/flate，dict 非空时作为预置字典
+type deflateCodec struct {
+	name string
+	dict []byte
+}
+
+func (c deflateCodec) Name() string { return c.name }
+
+func (c deflateCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, c.dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c deflateCodec) Decode(data []byte) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(data), c.dict)
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdCodec 使用 klauspost/compress 的 zstd，dict 非空时作为字典；编码器与解码器在第一次使用时创建，可并发使用
+type zstdCodec struct {
+	name string
+	dict []byte
+
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (c *zstdCodec) Name() string { return c.name }
+
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		encoderOptions := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1)}
+		decoderOptions := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if len(c.dict) > 0 {
+			encoderOptions = append(encoderOptions, zstd.WithEncoderDict(c.dict))
+			decoderOptions = append(decoderOptions, zstd.WithDecoderDicts(c.dict))
+		}
+		if c.encoder, c.err = zstd.NewWriter(nil, encoderOptions...); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil, decoderOptions...)
+	})
+	return c.err
+}
+
+func (c *zstdCodec) Encode(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Decode(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeAll(data, nil)
+}
+
+// compressField 压缩保存的内容；压缩后不比原文小时原样返回，codec 返回实际使用的压缩方式
+func compressField(codecName string, value string) (stored string, codec string, err error) {
+	impl, err := lookupBodyCodec(codecName)
+	if err != nil || impl == nil || value == "" {
+		return value, "", err
+	}
+	compressed, err := impl.Encode([]byte(value))
+	if err != nil {
+		return value, "", fmt.Errorf("压缩内容失败: %w", err)
+	}
+	stored = compressedFieldPrefix + impl.Name() + ":" + base64.StdEncoding.EncodeToString(compressed)
+	if len(stored) >= len(value) {
+		return value, "", nil
+	}
+	return stored, impl.Name(), nil
+}
+
+// decompressField 解压 compressField 生成的内容；未压缩的内容原样返回
+func decompressField(value string) (string, error) {
+	if !isCompressedField(value) {
+		return value, nil
+	}
+	name, payload, ok := strings.Cut(strings.TrimPrefix(value, compressedFieldPrefix), ":")
+	if !ok {
+		return "", errors.New("压缩内容格式无效")
+	}
+	impl, err := lookupBodyCodec(name)
+	if err != nil {
+		return "", err
+	}
+	if impl == nil {
+		return "", fmt.Errorf("压缩内容的压缩方式无效: %s", name)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	data, err := impl.Decode(compressed)
+	if err != nil {
+		return "", fmt.Errorf("解压内容失败: %w", err)
+	}
+	return string(data), nil
+}
+
+func isCompressedField(value string) bool {
+	return strings.HasPrefix(value, compressedFieldPrefix)
+}
+
+// TranscriptStorageStats 是保存内容按压缩方式汇总的磁盘占用
+type TranscriptStorageStats struct {
+	Codec         string  `json:"codec"` // 未压缩的内容为 none
+	Transcripts   int64   `json:"transcripts"`
+	OriginalBytes int64   `json:"original_bytes"`
+	StoredBytes   int64   `json:"stored_bytes"`
+	Ratio         float64 `json:"ratio"` // original_bytes / stored_bytes
+}
+
+// TranscriptStorageReport 汇总所有未清除内容的压缩效果
+type TranscriptStorageReport struct {
+	Total  TranscriptStorageStats   `json:"total"`
+	Codecs []TranscriptStorageStats `json:"codecs"`
+}
+
+// TranscriptStorage 按压缩方式统计未清除内容的原始大小与实际保存大小
+func (ts *TranscriptService) TranscriptStorage() (TranscriptStorageReport, error) {
+	return transcriptStorageReport()
+}
+
+func transcriptStorageReport() (TranscriptStorageReport, error) {
+	report := TranscriptStorageReport{Total: TranscriptStorageStats{Codec: "all"}, Codecs: []TranscriptStorageStats{}}
+	db, err := xdb.DB("default")
+	if err != nil {
+		return report, err
+	}
+	rows, err := db.Query(`SELECT codec, COUNT(*), COALESCE(SUM(original_bytes), 0), COALESCE(SUM(stored_bytes), 0)
+		FROM request_transcript WHERE purged_at = '' GROUP BY codec`)
+	if err != nil {
+		if isNoSuchTableErr(err) {
+			return report, nil
+		}
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stats TranscriptStorageStats
+		if err := rows.Scan(&stats.Codec, &stats.Transcripts, &stats.OriginalBytes, &stats.StoredBytes); err != nil {
+			return report, err
+		}
+		if stats.Codec == "" {
+			stats.Codec = TranscriptCodecNone
+		}
+		stats.Ratio = compressionRatio(stats.OriginalBytes, stats.StoredBytes)
+		report.Codecs = append(report.Codecs, stats)
+		report.Total.Transcripts += stats.Transcripts
+		report.Total.OriginalBytes += stats.OriginalBytes
+		report.Total.StoredBytes += stats.StoredBytes
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	report.Total.Ratio = compressionRatio(report.Total.OriginalBytes, report.Total.StoredBytes)
+	sort.Slice(report.Codecs, func(i, j int) bool {
+		return report.Codecs[i].Codec < report.Codecs[j].Codec
+	})
+	return report, nil
+}
+
+func compressionRatio(original int64, stored int64) float64 {
+	if stored <= 0 {
+		return 0
+	}
+	return float64(original) / float64(stored)
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	transcriptKeyringFile = "transcript-keys.json"
+	// 加密字段格式：enc:v1:<keyID>:<base64(nonce|ciphertext)>
+	encryptedFieldPrefix = "enc:v1:"
+)
+
+// ErrTranscriptKeyNotFound 表示解密所需的密钥不存在（可能已被删除）
+var ErrTranscriptKeyNotFound = errors.New("transcript key not found")
+
+// TranscriptKeyInfo 描述一个租户密钥（不含密钥内容）
+type TranscriptKeyInfo struct {
+	Tenant    string    `json:"tenant"`
+	KeyID     string    `json:"key_id"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type transcriptKey struct {
+	Secret    string    `json:"secret"` // base64 编码的 AES-256 密钥
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type tenantKeys struct {
+	Active string                    `json:"active"`
+	Keys   map[string]*transcriptKey `json:"keys"`
+}
+
+// transcriptKeyring 保存每个租户的加密密钥，文件权限为 0600
+// 轮换时生成新密钥作为活动密钥，旧密钥保留用于解密历史内容，直到重新加密后被移除
+type transcriptKeyring struct {
+	mu      sync.Mutex
+	path    string
+	tenants map[string]*tenantKeys
+	loaded  bool
+}
+
+func newTranscriptKeyring(path string) *transcriptKeyring {
+	return &transcriptKeyring{path: path}
+}
+
+var (
+	defaultKeyringOnce sync.Once
+	defaultKeyring     *transcriptKeyring
+)
+
+func sharedTranscriptKeyring() *transcriptKeyring {
+	defaultKeyringOnce.Do(func() {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "."
+		}
+		defaultKeyring = newTranscriptKeyring(filepath.Join(home, ".code-switch", transcriptKeyringFile))
+	})
+	return defaultKeyring
+}
+
+func (kr *transcriptKeyring) loadLocked() error {
+	if kr.loaded {
+		return nil
+	}
+	kr.tenants = make(map[string]*tenantKeys)
+	data, err := os.ReadFile(kr.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			kr.loaded = true
+			return nil
+		}
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &kr.tenants); err != nil {
+			return fmt.Errorf("解析密钥文件失败: %w", err)
+		}
+	}
+	kr.loaded = true
+	return nil
+}
+
+func (kr *transcriptKeyring) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(kr.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(kr.tenants, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := kr.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.path)
+}
+
+// activeKey 返回租户当前的加密密钥，不存在时自动生成
+func (kr *transcriptKeyring) activeKey(tenant string) (string, []byte, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if err := kr.loadLocked(); err != nil {
+		return "", nil, err
+	}
+	keys := kr.tenants[tenant]
+	if keys == nil || keys.Keys[keys.Active] == nil {
+		if _, err := kr.rotateLocked(tenant); err != nil {
+			return "", nil, err
+		}
+		keys = kr.tenants[tenant]
+	}
+	secret, err := base64.StdEncoding.DecodeString(keys.Keys[keys.Active].Secret)
+	return keys.Active, secret, err
+}
+
+func (kr *transcriptKeyring) key(tenant string, keyID string) ([]byte, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if err := kr.loadLocked(); err != nil {
+		return nil, err
+	}
+	keys := kr.tenants[tenant]
+	if keys == nil || keys.Keys[keyID] == nil {
+		return nil, ErrTranscriptKeyNotFound
+	}
+	return base64.StdEncoding.DecodeString(keys.Keys[keyID].Secret)
+}
+
+// rotate 为租户生成新的活动密钥，返回新密钥 ID
+func (kr *transcriptKeyring) rotate(tenant string) (string, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if err := kr.loadLocked(); err != nil {
+		return "", err
+	}
+	return kr.rotateLocked(tenant)
+}
+
+func (kr *transcriptKeyring) rotateLocked(tenant string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	keyID := hex.EncodeToString(idBytes)
+
+	keys := kr.tenants[tenant]
+	if keys == nil {
+		keys = &tenantKeys{Keys: make(map[string]*transcriptKey)}
+		kr.tenants[tenant] = keys
+	}
+	keys.Keys[keyID] = &transcriptKey{
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	keys.Active = keyID
+	if err := kr.saveLocked(); err != nil {
+		delete(keys.Keys, keyID)
+		return "", err
+	}
+	return keyID, nil
+}
+
+// retire 删除租户除活动密钥外的所有旧密钥
+func (kr *transcriptKeyring) retire(tenant string) (int, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if err := kr.loadLocked(); err != nil {
+		return 0, err
+	}
+	keys := kr.tenants[tenant]
+	if keys == nil {
+		return 0, nil
+	}
+	removed := 0
+	for id := range keys.Keys {
+		if id != keys.Active {
+			delete(keys.Keys, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, kr.saveLocked()
+}
+
+func (kr *transcriptKeyring) list() ([]TranscriptKeyInfo, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if err := kr.loadLocked(); err != nil {
+		return nil, err
+	}
+	result := []TranscriptKeyInfo{}
+	for tenant, keys := range kr.tenants {
+		for id, key := range keys.Keys {
+			result = append(result, TranscriptKeyInfo{
+				Tenant:    tenant,
+				KeyID:     id,
+				Active:    id == keys.Active,
+				CreatedAt: key.CreatedAt,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Tenant != result[j].Tenant {
+			return result[i].Tenant < result[j].Tenant
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// encryptField 使用租户的活动密钥加密内容，空内容不加密
+func (kr *transcriptKeyring) encryptField(tenant string, plaintext string) (string, string, error) {
+	if plaintext == "" {
+		return "", "", nil
+	}
+	keyID, secret, err := kr.activeKey(tenant)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	// 以租户作为附加数据，防止密文被挪用到其他租户的记录上
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(tenant))
+	return encryptedFieldPrefix + keyID + ":" + base64.StdEncoding.EncodeToString(sealed), keyID, nil
+}
+
+// decryptField 解密 encryptField 生成的内容；未加密的内容原样返回
+func (kr *transcriptKeyring) decryptField(tenant string, value string) (string, error) {
+	if !isEncryptedField(value) {
+		return value, nil
+	}
+	rest := strings.TrimPrefix(value, encryptedFieldPrefix)
+	keyID, payload, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("加密内容格式无效")
+	}
+	secret, err := kr.key(tenant, keyID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("加密内容长度无效")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(tenant))
+	if err != nil {
+		return "", fmt.Errorf("解密失败: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+func isEncryptedField(value string) bool {
+	return strings.HasPrefix(value, encryptedFieldPrefix)
+}
+
+func newGCM(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// zstdChatDictionaryID 是 zstd_chat.dict 的字典 ID，重新训练字典时需要同时使用新的 ID 与 codec 名称
+	zstdChatDictionaryID = 0x63730001
+	// 作为字典内容的样本数
+	zstdChatHistorySamples = 8
+)
+
+// TestTrainZstdChatDictionary 在设置 CODE_SWITCH_TRAIN_ZSTD_DICT=1 时用生成的对话样本重新训练 zstd_chat.dict：
+// 字典内容为部分样本与 chatJSONDictionary，熵编码表按全部样本统计。已保存的内容依赖字典解压，训练结果只能用于新的 codec 名称
+func TestTrainZstdChatDictionary(t *testing.T) {
+	if os.Getenv("CODE_SWITCH_TRAIN_ZSTD_DICT") != "1" {
+		t.Skip("设置 CODE_SWITCH_TRAIN_ZSTD_DICT=1 重新训练字典")
+	}
+	samples := chatDictionarySamples(rand.New(rand.NewSource(1)), 400)
+	// 字典内容为部分样本加上 chatJSONDictionary，越常见的片段越靠后（距离越近编码越短）
+	var history []byte
+	for _, sample := range samples[:zstdChatHistorySamples] {
+		history = append(history, sample...)
+	}
+	history = append(history, chatJSONDictionary...)
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       zstdChatDictionaryID,
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedBestCompression,
+	})
+	if err != nil {
+		t.Fatalf("训练字典失败: %v", err)
+	}
+	if err := os.WriteFile("zstd_chat.dict", dict, 0o644); err != nil {
+		t.Fatalf("写入字典失败: %v", err)
+	}
+}
+
+// TestZstdChatCompression 对照 deflate-chat 检查训练后的字典在未参与训练的样本上的压缩效果
+func TestZstdChatCompression(t *testing.T) {
+	info, err := zstd.InspectDictionary(zstdChatDictionary)
+	if err != nil || info.ID() != zstdChatDictionaryID {
+		t.Fatalf("zstd_chat.dict 无效: %v", err)
+	}
+	var original, zstdSize, deflateSize int
+	for _, sample := range chatDictionarySamples(rand.New(rand.NewSource(2)), 50) {
+		stored, codec, err := compressField(TranscriptCodecZstdChat, string(sample))
+		if err != nil || codec != TranscriptCodecZstdChat {
+			t.Fatalf("compressField = (%q, %v)", codec, err)
+		}
+		if got, err := decompressField(stored); err != nil || got != string(sample) {
+			t.Fatalf("解压结果与原文不一致: %v", err)
+		}
+		deflated, _, _ := compressField(TranscriptCodecDeflateChat, string(sample))
+		original += len(sample)
+		zstdSize += len(stored)
+		deflateSize += len(deflated)
+	}
+	// 训练的字典至少比 deflate-chat 的预置字典小 10%
+	if zstdSize*3 > original || zstdSize*10 > deflateSize*9 {
+		t.Fatalf("zstd-chat 压缩效果不足: %d -> %d 字节（deflate-chat %d 字节）", original, zstdSize, deflateSize)
+	}
+}
+
+// chatDictionarySamples 生成 Anthropic Messages 与 OpenAI Responses 的请求和 SSE 响应样本，结构与 Claude Code、Codex 的请求一致
+func chatDictionarySamples(r *rand.Rand, n int) [][]byte {
+	words := strings.Fields("the file function error return value package import test config request response model provider " +
+		"请 检查 修改 错误 处理 函数 文件 配置 测试 返回 日志 main.go handler.go README.md go build ./... func if err != nil")
+	sentence := func(min, max int) string {
+		count := min + r.Intn(max-min+1)
+		parts := make([]string, count)
+		for i := range parts {
+			parts[i] = words[r.Intn(len(words))]
+		}
+		return strings.Join(parts, " ")
+	}
+	id := func(prefix string) string {
+		const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		b := make([]byte, 24)
+		for i := range b {
+			b[i] = letters[r.Intn(len(letters))]
+		}
+		return prefix + string(b)
+	}
+	quote := func(s string) string {
+		data, _ := json.Marshal(s)
+		return string(data)
+	}
+	models := []string{"claude-sonnet-4-5", "claude-opus-4-1", "claude-haiku-4-5"}
+	codexModels := []string{"gpt-5-codex", "gpt-5", "gpt-5-mini"}
+
+	samples := make([][]byte, 0, n)
+	for len(samples) < n {
+		var b strings.Builder
+		switch len(samples) % 4 {
+		case 0:
+			fmt.Fprintf(&b, `{"model":"%s","max_tokens":32000,"stream":true,"metadata":{"user_id":"%s"},"system":[{"type":"text","text":"You are Claude Code, Anthropic's official CLI for Claude.","cache_control":{"type":"ephemeral"}}],`,
+				models[r.Intn(len(models))], id("user_"))
+			b.WriteString(`"tools":[`)
+			for i, name := range []string{"Bash", "Read", "Edit", "Grep"} {
+				if i > 0 {
+					b.WriteString(",")
+				}
+				fmt.Fprintf(&b, `{"name":"%s","description":%s,"input_schema":{"type":"object","properties":{"command":{"type":"string","description":%s}},"required":["command"],"additionalProperties":false,"$schema":"http://json-schema.org/draft-07/schema#"}}`,
+					name, quote(sentence(8, 20)), quote(sentence(3, 8)))
+			}
+			b.WriteString(`],"messages":[`)
+			for turn := 0; turn < 2+r.Intn(6); turn++ {
+				if turn > 0 {
+					b.WriteString(",")
+				}
+				toolID := id("toolu_")
+				fmt.Fprintf(&b, `{"role":"user","content":[{"type":"text","text":%s}]},{"role":"assistant","content":[{"type":"text","text":%s},{"type":"tool_use","id":"%s","name":"Bash","input":{"command":%s}}]},{"role":"user","content":[{"type":"tool_result","tool_use_id":"%s","content":%s,"is_error":false}]}`,
+					quote(sentence(5, 30)), quote(sentence(5, 40)), toolID, quote(sentence(2, 6)), toolID, quote(sentence(10, 60)))
+			}
+			b.WriteString(`]}`)
+		case 1:
+			fmt.Fprintf(&b, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"%s\",\"type\":\"message\",\"role\":\"assistant\",\"model\":\"%s\",\"content\":[],\"stop_reason\":null,\"stop_sequence\":null,\"usage\":{\"input_tokens\":%d,\"cache_creation_input_tokens\":%d,\"cache_read_input_tokens\":%d,\"output_tokens\":1,\"service_tier\":\"standard\"}}}\n\n",
+				id("msg_"), models[r.Intn(len(models))], r.Intn(5000), r.Intn(2000), r.Intn(50000))
+			b.WriteString("event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n")
+			for i := 0; i < 5+r.Intn(40); i++ {
+				fmt.Fprintf(&b, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":%s}}\n\n", quote(sentence(1, 4)))
+			}
+			b.WriteString("event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n")
+			if r.Intn(2) == 0 {
+				fmt.Fprintf(&b, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"%s\",\"name\":\"Bash\",\"input\":{}}}\n\n", id("toolu_"))
+				fmt.Fprintf(&b, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":%s}}\n\n", quote(`{"command": "`+sentence(2, 5)+`"}`))
+				b.WriteString("event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n")
+			}
+			fmt.Fprintf(&b, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\",\"stop_sequence\":null},\"usage\":{\"output_tokens\":%d}}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", r.Intn(4000))
+		case 2:
+			fmt.Fprintf(&b, `{"model":"%s","instructions":%s,"input":[`, codexModels[r.Intn(len(codexModels))], quote(sentence(20, 60)))
+			for turn := 0; turn < 2+r.Intn(6); turn++ {
+				if turn > 0 {
+					b.WriteString(",")
+				}
+				callID := id("call_")
+				fmt.Fprintf(&b, `{"type":"message","role":"user","content":[{"type":"input_text","text":%s}]},{"type":"function_call","call_id":"%s","name":"shell","arguments":%s},{"type":"function_call_output","call_id":"%s","output":%s}`,
+					quote(sentence(5, 30)), callID, quote(`{"command":["bash","-lc","`+sentence(2, 5)+`"]}`), callID, quote(sentence(10, 60)))
+			}
+			fmt.Fprintf(&b, `],"tools":[{"type":"function","name":"shell","description":%s,"strict":false,"parameters":{"type":"object","properties":{"command":{"type":"array","items":{"type":"string"}}},"required":["command"]}}],"tool_choice":"auto","parallel_tool_calls":true,"reasoning":{"effort":"medium","summary":"auto"},"store":false,"stream":true,"include":["reasoning.encrypted_content"],"prompt_cache_key":"%s"}`,
+				quote(sentence(8, 20)), id(""))
+		case 3:
+			responseID := id("resp_")
+			fmt.Fprintf(&b, "event: response.created\ndata: {\"type\":\"response.created\",\"sequence_number\":0,\"response\":{\"id\":\"%s\",\"object\":\"response\",\"status\":\"in_progress\",\"model\":\"%s\"}}\n\n", responseID, codexModels[r.Intn(len(codexModels))])
+			itemID := id("msg_")
+			for i := 0; i < 5+r.Intn(40); i++ {
+				fmt.Fprintf(&b, "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"sequence_number\":%d,\"item_id\":\"%s\",\"output_index\":0,\"content_index\":0,\"delta\":%s}\n\n", i+1, itemID, quote(sentence(1, 4)))
+			}
+			fmt.Fprintf(&b, "event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"%s\",\"status\":\"completed\",\"usage\":{\"input_tokens\":%d,\"input_tokens_details\":{\"cached_tokens\":%d},\"output_tokens\":%d,\"output_tokens_details\":{\"reasoning_tokens\":%d},\"total_tokens\":%d}}}\n\n",
+				responseID, r.Intn(50000), r.Intn(40000), r.Intn(4000), r.Intn(2000), r.Intn(60000))
+		}
+		samples = append(samples, []byte(b.String()))
+	}
+	return samples
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upstreamTransport 是发往上游的连接设置，相同设置的 provider 共用 HTTP client 与连接池
+type upstreamTransport struct {
+	connect time.Duration
+	proxy   ProxyConfig
+	tls     *ProviderTLS
+}
+
+func (t upstreamTransport) key() string {
+	return t.connect.String() + "|" + t.proxy.key() + "|" + t.tls.key()
+}
+
+// upstreamClients 按连接设置复用发往上游的 HTTP client
+type upstreamClients struct {
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+func newUpstreamClients() *upstreamClients {
+	return &upstreamClients{clients: make(map[string]*http.Client)}
+}
+
+// get 返回连接设置对应的 HTTP client；provider 仅用于日志
+func (uc *upstreamClients) get(provider string, settings upstreamTransport) (*http.Client, error) {
+	key := settings.key()
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if client, ok := uc.clients[key]; ok {
+		return client, nil
+	}
+	tlsConfig, err := settings.tls.config()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil && tlsConfig.InsecureSkipVerify {
+		fmt.Printf("[WARN] ⚠️ Provider %s 已关闭 TLS 证书校验（insecureSkipVerify），请求内容与 API Key 可能被中间人窃取，请仅在排查问题时使用\n", provider)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: settings.connect, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = settings.connect
+	transport.MaxIdleConnsPerHost = 16
+	transport.Proxy = settings.proxy.proxyFunc()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	client := &http.Client{Transport: transport}
+	uc.clients[key] = client
+	return client, nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unixAddrPrefix 标记 listeners 中的 Unix socket 地址，如 unix:/home/me/.code-switch/work.sock
+const unixAddrPrefix = "unix:"
+
+// maxUnixSocketPath 是 socket 路径的最大长度（macOS 的 sun_path 为 104 字节，含结尾的 0）
+const maxUnixSocketPath = 103
+
+// SocketConfig 控制 Unix domain socket 监听（默认关闭）
+type SocketConfig struct {
+	// 监听 Unix socket，socket 文件的权限为 0600，只有当前用户可以连接
+	Enabled bool `json:"enabled"`
+	// socket 文件的绝对路径，默认 ~/.code-switch/relay.sock
+	Path string `json:"path"`
+	// 不监听 TCP 端口，只通过 socket 访问；共享的开发机上 localhost 端口对所有用户可见
+	DisableTCP bool `json:"disableTcp"`
+}
+
+func (cfg SocketConfig) validate() []string {
+	var errors []string
+	if cfg.DisableTCP && !cfg.Enabled {
+		errors = append(errors, "disableTcp 需要同时启用 socket，否则 relay 没有可用的监听地址")
+	}
+	if cfg.Path != "" {
+		if err := validateSocketPath(cfg.Path); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	return errors
+}
+
+// socketPath 返回 socket 文件路径
+func (cfg SocketConfig) socketPath() (string, error) {
+	if cfg.Path != "" {
+		return cfg.Path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".code-switch", "relay.sock"), nil
+}
+
+func validateSocketPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("socket 路径必须是绝对路径: %s", path)
+	}
+	if len(path) > maxUnixSocketPath {
+		return fmt.Errorf("socket 路径超过 %d 字节: %s", maxUnixSocketPath, path)
+	}
+	return nil
+}
+
+// listenAddr 监听 TCP 地址，或以 unix: 开头的 Unix socket 地址
+func listenAddr(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		return listenUnixSocket(path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// unixSocketListener 关闭时删除 socket 文件
+type unixSocketListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.Listener.Close()
+	if removeErr := os.Remove(l.path); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// listenUnixSocket 监听权限为 0600 的 Unix socket：先在只有当前用户可以访问的临时目录中创建并设置权限，
+// 再移动到目标路径，其他用户在任何时刻都无法连接。目标路径上残留的 socket（上次未正常退出）会被替换，
+// 仍有进程监听或不是 socket 时报错
+func listenUnixSocket(path string) (net.Listener, error) {
+	if err := validateSocketPath(path); err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp(dir, ".cs-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, "s")
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// socket 文件移动后由 unixSocketListener 删除
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixSocketListener{Listener: listener, path: path}, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是 socket，拒绝覆盖", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s 已有进程在监听", path)
+	}
+	return os.Remove(path)
+}
+
+// isUnixSocketRequest 判断请求是否来自 Unix socket，socket 的文件权限已限制为当前用户
+func isUnixSocketRequest(c *gin.Context) bool {
+	_, ok := c.Request.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	return ok
+}
+
+// serveSocket 在 Unix socket 上提供与 TCP 端口相同的服务
+func (prs *ProviderRelayService) serveSocket(cfg SocketConfig) error {
+	path, err := cfg.socketPath()
+	if err != nil {
+		return err
+	}
+	ln, err := listenUnixSocket(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("provider relay server listening on %s%s\n", unixAddrPrefix, path)
+	go func() {
+		if err := prs.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("provider relay server error: %v\n", err)
+		}
+	}()
+	return nil
+}
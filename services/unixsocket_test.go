@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRelayListensOnUnixSocketOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 上的文件权限不限制 socket 访问")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	initTestDatabase(t)
+	socketPath := filepath.Join(home, "relay.sock")
+	// 上次未正常退出时残留的 socket 文件
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("创建 socket 失败: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	rcs := NewRelayConfigService()
+	cfg := defaultRelayConfig()
+	cfg.Socket = SocketConfig{Enabled: true, Path: socketPath, DisableTCP: true}
+	if errs := cfg.validate(); len(errs) > 0 {
+		t.Fatalf("socket 配置应通过校验: %v", errs)
+	}
+	if _, err := rcs.SaveRelayConfig(cfg); err != nil {
+		t.Fatalf("保存 relay 配置失败: %v", err)
+	}
+	relay := NewProviderRelayService(NewProviderService(), rcs, "127.0.0.1:0")
+	if err := relay.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		relay.Stop()
+		t.Fatalf("socket 应只允许当前用户访问: %v %v", info, err)
+	}
+	if _, err := listenUnixSocket(socketPath); err == nil {
+		relay.Stop()
+		t.Fatalf("socket 仍在监听时应拒绝替换")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	// 通过 socket 的请求可以访问只允许本机访问的管理接口
+	resp, err := client.Get("http://relay/admin/config")
+	if err != nil {
+		relay.Stop()
+		t.Fatalf("通过 socket 请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		relay.Stop()
+		t.Fatalf("socket 请求应视为本机请求: %d", resp.StatusCode)
+	}
+
+	if err := relay.Stop(); err != nil {
+		t.Fatalf("停止失败: %v", err)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Fatalf("停止后应删除 socket 文件: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(home, ".cs-*")); len(leftovers) > 0 {
+		t.Fatalf("应删除创建 socket 时的临时目录: %v", leftovers)
+	}
+}
+
+func TestUnixSocketRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.sock")
+	os.WriteFile(path, []byte("data"), 0o600)
+	if _, err := listenUnixSocket(path); err == nil {
+		t.Fatalf("目标路径是普通文件时应拒绝覆盖")
+	}
+	if errs := (SocketConfig{DisableTCP: true, Path: "relay.sock"}).validate(); len(errs) != 2 {
+		t.Fatalf("应报告未启用 socket 与相对路径: %v", errs)
+	}
+}
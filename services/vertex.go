@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daodao97/xgo/xrequest"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// Vertex AI 调用 Anthropic 模型时请求体中必须携带的版本
+	vertexAnthropicVersion = "vertex-2023-10-16"
+	// 未配置区域时使用全局端点，Claude 与 Gemini 均支持
+	defaultVertexRegion = "global"
+)
+
+// VertexConfig 描述 Vertex AI 的项目与区域，用于生成 publisher 模型的接口地址
+type VertexConfig struct {
+	// GCP 项目 ID，留空时使用服务账号凭据中的 project_id
+	ProjectID string `json:"projectId,omitempty"`
+	// 区域，如 us-east5、europe-west1，默认 global
+	Region string `json:"region,omitempty"`
+}
+
+// vertexBaseURL 返回 Vertex AI 的项目区域地址，如
+// https://us-east5-aiplatform.googleapis.com/v1/projects/my-project/locations/us-east5
+func (p *Provider) vertexBaseURL() string {
+	region := defaultVertexRegion
+	project := ""
+	if p.Vertex != nil {
+		if strings.TrimSpace(p.Vertex.Region) != "" {
+			region = strings.TrimSpace(p.Vertex.Region)
+		}
+		project = strings.TrimSpace(p.Vertex.ProjectID)
+	}
+	if project == "" {
+		if creds, err := parseGoogleCredentials(p.APIKey); err == nil {
+			project = creds.ProjectID
+		}
+	}
+	if project == "" {
+		return ""
+	}
+	host := "aiplatform.googleapis.com"
+	if region != defaultVertexRegion {
+		host = region + "-" + host
+	}
+	return fmt.Sprintf("https://%s/v1/projects/%s/locations/%s", host, project, region)
+}
+
+// upstreamBaseURL 返回转发请求使用的 provider 地址；vertex 协议未配置 apiUrl 时按项目与区域生成
+func (p *Provider) upstreamBaseURL() string {
+	if p.APIURL == "" && p.APIFormat == APIFormatVertex {
+		return p.vertexBaseURL()
+	}
+	return p.APIURL
+}
+
+// vertexDialect 通过 Vertex AI 的 publisher 模型接口调用 Claude 或 Gemini：
+// claude 开头的模型（如 claude-sonnet-4-5@20250929）使用 Anthropic publisher 的 rawPredict，请求与响应保持 Anthropic 格式；
+// 其余模型使用 Google publisher 的 generateContent，复用 Gemini 的协议转换
+type vertexDialect struct {
+	gemini geminiDialect
+}
+
+func isVertexAnthropicModel(model string) bool {
+	return strings.HasPrefix(strings.ToLower(model), "claude")
+}
+
+func (d vertexDialect) endpoint(model string, stream bool) string {
+	if !isVertexAnthropicModel(model) {
+		return "/publishers/google" + d.gemini.endpoint(model, stream)
+	}
+	path := "/publishers/anthropic/models/" + model
+	if stream {
+		return path + ":streamRawPredict"
+	}
+	return path + ":rawPredict"
+}
+
+func (d vertexDialect) translateRequest(body []byte, model string) ([]byte, error) {
+	if !isVertexAnthropicModel(model) {
+		return d.gemini.translateRequest(body, model)
+	}
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("请求体不是有效的 JSON")
+	}
+	// 模型在接口路径中，Vertex 会拒绝请求体中的 model；stream 仍由请求体决定
+	data, err := sjson.DeleteBytes(body, "model")
+	if err == nil && !gjson.GetBytes(data, "anthropic_version").Exists() {
+		data, err = sjson.SetBytes(data, "anthropic_version", vertexAnthropicVersion)
+	}
+	return data, err
+}
+
+func (d vertexDialect) responseHook(model string, stream bool, usage *upstreamUsage) xrequest.ResponseHook {
+	if !isVertexAnthropicModel(model) {
+		return d.gemini.responseHook(model, stream, usage)
+	}
+	return anthropicUsageHook(stream, usage)
+}
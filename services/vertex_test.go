@@ -0,0 +1,223 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// newGoogleTokenServer 返回校验服务账号 JWT 并签发 access token 的测试服务，以及对应的凭据 JSON
+func newGoogleTokenServer(t *testing.T) (*httptest.Server, string, *atomic.Int32) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		r.ParseForm()
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"bad assertion"}`)
+			return
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil ||
+			gjson.GetBytes(claims, "iss").String() != "relay@my-project.iam.gserviceaccount.com" ||
+			gjson.GetBytes(claims, "scope").String() != googleCloudScope {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"bad signature"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"ya29.test-token","expires_in":3599,"token_type":"Bearer"}`)
+	}))
+	t.Cleanup(server.Close)
+	creds, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "my-project",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "relay@my-project.iam.gserviceaccount.com",
+		"token_uri":      server.URL + "/token",
+	})
+	return server, string(creds), &requests
+}
+
+func TestGoogleOAuthAuth(t *testing.T) {
+	_, creds, requests := newGoogleTokenServer(t)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "https://aiplatform.googleapis.com/v1/x", nil)
+		req.Header.Set("X-Api-Key", "client-key")
+		if err := (googleOAuthAuth{}).Apply(req, creds); err != nil {
+			t.Fatalf("获取 access token 失败: %v", err)
+		}
+		if req.Header.Get("Authorization") != "Bearer ya29.test-token" || req.Header.Get("X-Api-Key") != "" {
+			t.Fatalf("应使用 access token 认证并移除客户端的认证头: %v", req.Header)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("access token 应缓存到过期前: 请求了 %d 次", requests.Load())
+	}
+
+	// 凭据也可以是文件路径
+	path := filepath.Join(t.TempDir(), "sa.json")
+	os.WriteFile(path, []byte(creds), 0600)
+	if parsed, err := parseGoogleCredentials(path); err != nil || parsed.ProjectID != "my-project" {
+		t.Fatalf("应读取凭据文件: %+v %v", parsed, err)
+	}
+	if _, err := parseGoogleCredentials(`{"type":"service_account","client_email":"a@b"}`); err == nil {
+		t.Fatalf("缺少私钥的服务账号应报错")
+	}
+	req := httptest.NewRequest(http.MethodPost, "https://aiplatform.googleapis.com/v1/x", nil)
+	if err := (googleOAuthAuth{}).Apply(req, strings.Replace(creds, "relay@", "other@", 1)); err == nil ||
+		!strings.Contains(err.Error(), "bad signature") {
+		t.Fatalf("token 接口的错误应返回给调用方: %v", err)
+	}
+}
+
+func TestGoogleAuthorizedUserCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "1//refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"ya29.user-token","expires_in":3599}`)
+	}))
+	defer server.Close()
+	creds := `{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"1//refresh","token_uri":"` + server.URL + `"}`
+	req := httptest.NewRequest(http.MethodPost, "https://aiplatform.googleapis.com/v1/x", nil)
+	if err := (googleOAuthAuth{}).Apply(req, creds); err != nil || req.Header.Get("Authorization") != "Bearer ya29.user-token" {
+		t.Fatalf("用户凭据应使用 refresh token 换取 access token: %v %v", err, req.Header)
+	}
+}
+
+func TestVertexEndpoints(t *testing.T) {
+	provider := Provider{APIFormat: APIFormatVertex, Vertex: &VertexConfig{ProjectID: "my-project", Region: "us-east5"}}
+	if base := provider.upstreamBaseURL(); base != "https://us-east5-aiplatform.googleapis.com/v1/projects/my-project/locations/us-east5" {
+		t.Fatalf("区域地址错误: %s", base)
+	}
+	provider.Vertex.Region = ""
+	if base := provider.upstreamBaseURL(); base != "https://aiplatform.googleapis.com/v1/projects/my-project/locations/global" {
+		t.Fatalf("默认应使用全局端点: %s", base)
+	}
+	if errs := (&Provider{Name: "vertex", APIKey: `{"type":"service_account"}`, APIFormat: APIFormatVertex}).ValidateConfiguration(); len(errs) == 0 {
+		t.Fatalf("无法确定项目时应报错")
+	}
+
+	dialect := vertexDialect{}
+	if endpoint := dialect.endpoint("claude-sonnet-4-5@20250929", true); endpoint != "/publishers/anthropic/models/claude-sonnet-4-5@20250929:streamRawPredict" {
+		t.Fatalf("Claude 模型应使用 streamRawPredict: %s", endpoint)
+	}
+	if endpoint := dialect.endpoint("gemini-2.5-pro", false); endpoint != "/publishers/google/models/gemini-2.5-pro:generateContent" {
+		t.Fatalf("Gemini 模型应使用 generateContent: %s", endpoint)
+	}
+
+	body := []byte(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	data, err := dialect.translateRequest(body, "claude-sonnet-4-5@20250929")
+	if err != nil || gjson.GetBytes(data, "model").Exists() || !gjson.GetBytes(data, "stream").Bool() ||
+		gjson.GetBytes(data, "anthropic_version").String() != vertexAnthropicVersion {
+		t.Fatalf("Claude 请求体转换错误: %s %v", data, err)
+	}
+	data, err = dialect.translateRequest(body, "gemini-2.5-pro")
+	if err != nil || gjson.GetBytes(data, "contents.0.parts.0.text").String() != "hi" {
+		t.Fatalf("Gemini 模型应转换为 generateContent 请求: %s %v", data, err)
+	}
+	if auth, _ := provider.AuthStrategy(); auth != (googleOAuthAuth{}) {
+		t.Fatalf("vertex 协议默认应使用 Google OAuth2: %#v", auth)
+	}
+}
+
+func TestVertexModelPricing(t *testing.T) {
+	pricing, err := modelpricing.NewServiceFromData([]byte(`{
+		"vertex_ai/claude-sonnet-4-5@20250929": {"input_cost_per_token": 0.000003},
+		"claude-opus-4-5-20251101": {"input_cost_per_token": 0.000005}
+	}`))
+	if err != nil {
+		t.Fatalf("NewServiceFromData 失败: %v", err)
+	}
+	for model, key := range map[string]string{
+		"claude-sonnet-4-5@20250929": "vertex_ai/claude-sonnet-4-5@20250929",
+		"claude-opus-4-5@20251101":   "claude-opus-4-5-20251101",
+	} {
+		if match := pricing.MatchModel(model); match.Key != key || match.Strategy != modelpricing.MatchPrefix {
+			t.Errorf("%s 应匹配 %s: %+v", model, key, match)
+		}
+	}
+}
+
+func TestRelayForwardsToVertexClaude(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	initTestDatabase(t)
+	_, creds, _ := newGoogleTokenServer(t)
+	var forwardedPath, forwardedAuth string
+	var forwardedBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedBody, _ = io.ReadAll(r.Body)
+		forwardedPath, forwardedAuth = r.URL.Path, r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":12,\"cache_creation_input_tokens\":40,\"output_tokens\":1}}}\n\n"+
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":9}}\n\n"+
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{
+		ID: 1, Name: "vertex", APIURL: upstream.URL + "/v1/projects/my-project/locations/us-east5", APIKey: creds, Enabled: true,
+		APIFormat: APIFormatVertex, ModelRewrites: []ModelRewrite{{Match: "claude-sonnet-4-5", Target: "claude-sonnet-4-5@20250929"}},
+	}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+	relay := NewProviderRelayService(ps, NewRelayConfigService(), "")
+	router := gin.New()
+	relay.registerRoutes(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("转发请求失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if forwardedPath != "/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4-5@20250929:streamRawPredict" {
+		t.Fatalf("应转发到 publisher 模型接口: %s", forwardedPath)
+	}
+	if forwardedAuth != "Bearer ya29.test-token" || gjson.GetBytes(forwardedBody, "anthropic_version").String() != vertexAnthropicVersion {
+		t.Fatalf("请求应使用 OAuth2 token 并转换请求体: %s %s", forwardedAuth, forwardedBody)
+	}
+	if !strings.Contains(rec.Body.String(), "message_stop") {
+		t.Fatalf("响应应原样转发: %s", rec.Body.String())
+	}
+
+	record, err := xdb.New("request_log").First(xdb.WhereEq("provider", "vertex"))
+	if err != nil {
+		t.Fatalf("查询 request_log 失败: %v", err)
+	}
+	if entry := requestLogFromRecord(record); entry.InputTokens != 12 || entry.CacheCreateTokens != 40 || entry.OutputTokens != 9 {
+		t.Fatalf("Vertex 的用量应计入日志: %+v", entry)
+	}
+}